@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jalling97/go_rag_demo/demo/rag"
+)
+
+// auditColumns are the columns of audit -format csv.
+var auditColumns = []string{"time", "trace_id", "namespace", "caller", "session_id", "question", "filter", "chunks", "answer", "cached", "no_context", "models", "prompt_tokens", "completion_tokens", "embedding_tokens", "cost", "error"}
+
+// audit searches the AUDIT_LOG, printing a line for every query matching
+// its flags or, with -format jsonl or csv, exporting the matching records
+// whole.
+func audit(ctx context.Context, p *rag.Pipeline, args []string) error {
+	flags := flag.NewFlagSet("audit", flag.ExitOnError)
+	file := flags.String("file", "", "audit log to read, AUDIT_LOG by default")
+	since := flags.String("since", "", "only queries from this time on: RFC 3339, a date such as 2026-01-31, or a duration such as 24h before now")
+	until := flags.String("until", "", "only queries before this time, given like -since")
+	caller := flags.String("caller", "", "only queries made as this user or group")
+	namespace := flags.String("namespace", "", "only queries in this namespace; all of them by default")
+	session := flags.String("session", "", "only queries in this session")
+	text := flags.String("text", "", "only queries whose question or answer contains this text")
+	format := flags.String("format", "text", "output format: text, jsonl or csv")
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		return errors.New("audit takes no arguments")
+	}
+	path := *file
+	if path == "" {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		if path = cfg.AuditLog; path == "" {
+			return errors.New("no audit log; set AUDIT_LOG or give -file")
+		}
+	}
+	filter := rag.AuditFilter{Caller: *caller, Namespace: *namespace, SessionID: *session, Text: *text}
+	var err error
+	if filter.Since, err = parseAuditTime(*since); err != nil {
+		return fmt.Errorf("-since: %w", err)
+	}
+	if filter.Until, err = parseAuditTime(*until); err != nil {
+		return fmt.Errorf("-until: %w", err)
+	}
+
+	var write func(rag.AuditRecord) error
+	enc := json.NewEncoder(os.Stdout)
+	w := csv.NewWriter(os.Stdout)
+	switch *format {
+	case "text":
+		write = printAuditRecord
+	case "jsonl":
+		write = func(rec rag.AuditRecord) error { return enc.Encode(rec) }
+	case "csv":
+		if err := w.Write(auditColumns); err != nil {
+			return err
+		}
+		write = func(rec rag.AuditRecord) error { return w.Write(auditRow(rec)) }
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	matched := 0
+	err = rag.ReadAuditLog(f, func(rec rag.AuditRecord) error {
+		if !filter.Match(rec) {
+			return nil
+		}
+		matched++
+		return write(rec)
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%d queries\n", matched)
+	return nil
+}
+
+// parseAuditTime parses the time given to -since or -until: an RFC 3339
+// time, a date, which starts at midnight in the local time zone, or a
+// duration before now. The empty string is the zero time.
+func parseAuditTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("want a time such as 2026-01-31T15:04:05Z, a date such as 2026-01-31 or a duration such as 24h, got %q", s)
+}
+
+// printAuditRecord prints a line summarizing a query.
+func printAuditRecord(rec rag.AuditRecord) error {
+	caller := strings.Join(rec.Caller, ",")
+	if caller == "" {
+		caller = "-"
+	}
+	tokens := 0
+	if rec.Usage != nil {
+		tokens = rec.Usage.PromptTokens + rec.Usage.CompletionTokens + rec.Usage.EmbeddingTokens
+	}
+	var note string
+	switch {
+	case rec.Error != "":
+		note = ", failed: " + rec.Error
+	case rec.Cached:
+		note = ", cached"
+	case rec.NoContext:
+		note = ", no context"
+	}
+	_, err := fmt.Printf("%s  %s  %s  %q  (%d chunks, %d tokens%s)\n",
+		rec.Time.Local().Format(time.DateTime), rec.Namespace, caller, rec.Question, len(rec.Chunks), tokens, note)
+	return err
+}
+
+// auditRow returns the fields of a record in the order of auditColumns.
+func auditRow(rec rag.AuditRecord) []string {
+	usage := rec.Usage
+	if usage == nil {
+		usage = &rag.UsageReport{}
+	}
+	models := make([]string, len(usage.Models))
+	for i, m := range usage.Models {
+		models[i] = m.Model
+	}
+	return []string{
+		rec.Time.Format(time.RFC3339Nano),
+		rec.TraceID,
+		rec.Namespace,
+		strings.Join(rec.Caller, ", "),
+		rec.SessionID,
+		rec.Question,
+		rec.Filter,
+		strings.Join(rec.Chunks, " "),
+		rec.Answer,
+		strconv.FormatBool(rec.Cached),
+		strconv.FormatBool(rec.NoContext),
+		strings.Join(models, " "),
+		strconv.Itoa(usage.PromptTokens),
+		strconv.Itoa(usage.CompletionTokens),
+		strconv.Itoa(usage.EmbeddingTokens),
+		strconv.FormatFloat(usage.Cost, 'f', -1, 64),
+		rec.Error,
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/jalling97/go_rag_demo/demo/rag"
+)
+
+// bench replays the questions of a file at a given concurrency and prints
+// the throughput and the latency percentiles of every stage, to size a
+// deployment and find what slows down under load.
+func bench(ctx context.Context, p *rag.Pipeline, args []string) error {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	concurrency := flags.Int("c", 8, "number of questions asked at once")
+	n := flags.Int("n", 0, "number of questions to ask, starting over when the file runs out; the questions of the file once if 0")
+	duration := flags.Duration("duration", 0, "ask questions for this long instead of -n times")
+	stream := flags.Bool("stream", false, "stream the answers, timing the first delta")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("usage: rag bench [-c 8] [-n requests | -duration 1m] [-stream] [-json] <questions file>")
+	}
+	requests, err := readQuestions(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("%s: %w", flags.Arg(0), err)
+	}
+
+	b := &rag.Benchmark{Pipeline: p, Concurrency: *concurrency, Requests: *n, Duration: *duration, Stream: *stream}
+	report, err := b.Run(ctx, requests)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	fmt.Printf("%d questions at concurrency %d in %.2fs: %.2f answers/s", report.Queries, report.Concurrency, report.Seconds, report.Throughput)
+	if report.Cached > 0 {
+		fmt.Printf(", %d from the answer cache", report.Cached)
+	}
+	fmt.Println()
+	if report.Failed > 0 {
+		var errs []string
+		for _, code := range slices.Sorted(maps.Keys(report.Errors)) {
+			errs = append(errs, fmt.Sprintf("%d %s", report.Errors[code], code))
+		}
+		fmt.Printf("%d failed: %s\n", report.Failed, strings.Join(errs, ", "))
+	}
+	if len(report.Stages) == 0 {
+		return nil
+	}
+	fmt.Printf("\n%-12s %7s %9s %9s %9s %9s %9s\n", "stage", "calls", "p50 ms", "p95 ms", "p99 ms", "mean ms", "max ms")
+	for _, s := range report.Stages {
+		fmt.Printf("%-12s %7d %9.1f %9.1f %9.1f %9.1f %9.1f\n", s.Stage, s.Calls, s.P50, s.P95, s.P99, s.Mean, s.Max)
+	}
+	return nil
+}
+
+// readQuestions reads the questions to replay from a file holding one per
+// line, either as plain text or as a JSON query request such as
+// {"question": "...", "k": 8}, so that the cases of eval can be replayed
+// too. Blank lines are skipped.
+func readQuestions(path string) ([]rag.QueryRequest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var requests []rag.QueryRequest
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		req := rag.QueryRequest{Question: text}
+		if strings.HasPrefix(text, "{") {
+			req = rag.QueryRequest{}
+			if err := json.Unmarshal([]byte(text), &req); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			if req.Question == "" {
+				return nil, fmt.Errorf("line %d: no question", line)
+			}
+		}
+		requests = append(requests, req)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(requests) == 0 {
+		return nil, errors.New("no questions")
+	}
+	return requests, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+
+	"golang.org/x/term"
+
+	"github.com/jalling97/go_rag_demo/demo/rag"
+)
+
+const chatHelp = `Ask a question, or enter a command:
+  /source  show the passages the last answer was drawn from
+  /reset   forget the conversation so far
+  /exit    leave the chat (or press Ctrl-D)
+Ctrl-C stops an answer while it is streamed.`
+
+// chat runs an interactive session in which follow-up questions are answered
+// in the context of the earlier ones, streaming every answer as it is
+// generated. On a terminal, input lines can be edited and earlier ones
+// recalled with the arrow keys. -session resumes a session kept by an
+// earlier chat, which only works if the conversation memory outlives the
+// process.
+func chat(ctx context.Context, p *rag.Pipeline, args []string) error {
+	flags := flag.NewFlagSet("chat", flag.ExitOnError)
+	k := flags.Int("k", rag.DefaultTopK, "number of chunks to retrieve for every question")
+	filter := flags.String("filter", "", "only retrieve chunks matching a metadata filter, e.g. 'source=handbook, year>=2023'")
+	session := flags.String("session", "", "session ID to continue; a new session by default")
+	generation := generationFlags(flags)
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		return errors.New("chat takes no arguments")
+	}
+	if _, err := rag.ParseFilter(*filter); err != nil {
+		return fmt.Errorf("invalid filter: %w", err)
+	}
+	if *session == "" {
+		*session = "chat-" + rand.Text()
+	}
+	opts := generation()
+
+	fmt.Println("Type /help for commands.")
+	var last *rag.Answer
+	in := newChatInput()
+	for {
+		line, err := in.readLine("> ")
+		if err == io.EOF {
+			fmt.Println()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		switch line {
+		case "":
+			continue
+		case "/exit", "/quit":
+			return nil
+		case "/help":
+			fmt.Println(chatHelp)
+			continue
+		case "/reset":
+			if err := p.ResetSession(ctx, *session); err != nil {
+				return err
+			}
+			last = nil
+			fmt.Println("Conversation cleared.")
+			continue
+		case "/source", "/sources":
+			printChatSources(last)
+			continue
+		}
+		if strings.HasPrefix(line, "/") {
+			fmt.Printf("Unknown command %s; type /help for commands.\n", line)
+			continue
+		}
+
+		// Ctrl-C only cancels the question being answered
+		askCtx, stop := signal.NotifyContext(ctx, os.Interrupt)
+		answer, err := p.QueryStream(askCtx, rag.QueryRequest{Question: line, K: *k, Filter: *filter, SessionID: *session, GenerationOptions: opts}, func(delta string) error {
+			fmt.Print(delta)
+			return nil
+		})
+		interrupted := askCtx.Err() != nil
+		stop()
+		fmt.Println()
+		switch {
+		case interrupted:
+			fmt.Println("(stopped)")
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		default:
+			last = answer
+		}
+	}
+}
+
+// printChatSources shows the sources of answer with their text, marking the
+// ones it cites.
+func printChatSources(answer *rag.Answer) {
+	if answer == nil {
+		fmt.Println("No answer yet.")
+		return
+	}
+	if len(answer.Sources) == 0 {
+		fmt.Println("The last answer had no sources.")
+		return
+	}
+	for i, s := range answer.Sources {
+		mark := " "
+		if s.Cited {
+			mark = "*"
+		}
+		fmt.Printf("%s[%d] %s, chunk %d (score %.3f)\n", mark, i+1, s.DocID, s.Chunk, s.Score)
+		if s.Page > 0 {
+			fmt.Printf("    page %d\n", s.Page)
+		}
+		if s.URL != "" && s.URL != s.DocID {
+			fmt.Printf("    %s\n", s.URL)
+		}
+		for _, line := range strings.Split(strings.TrimSpace(s.Text), "\n") {
+			fmt.Printf("    %s\n", line)
+		}
+	}
+	fmt.Println("* cited in the answer")
+}
+
+// chatInput reads the lines typed into the chat. If stdin is a terminal it
+// is switched to raw mode while a line is read, for line editing and
+// history, and restored while answers are printed so that Ctrl-C raises an
+// interrupt again.
+type chatInput struct {
+	terminal *term.Terminal
+	scanner  *bufio.Scanner
+}
+
+func newChatInput() *chatInput {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return &chatInput{scanner: bufio.NewScanner(os.Stdin)}
+	}
+	stdio := struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}
+	return &chatInput{terminal: term.NewTerminal(stdio, "")}
+}
+
+// readLine prompts for and reads a line, returning io.EOF when the input
+// ends, or on a terminal when Ctrl-D or Ctrl-C is pressed.
+func (in *chatInput) readLine(prompt string) (string, error) {
+	if in.terminal == nil {
+		fmt.Print(prompt)
+		if !in.scanner.Scan() {
+			if err := in.scanner.Err(); err != nil {
+				return "", err
+			}
+			return "", io.EOF
+		}
+		return in.scanner.Text(), nil
+	}
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return "", err
+	}
+	defer term.Restore(fd, state)
+	in.terminal.SetPrompt(prompt)
+	return in.terminal.ReadLine()
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/jalling97/go_rag_demo/demo/rag"
+)
+
+// previewChars is how much of the start and end of a chunk -dry-run prints.
+const previewChars = 40
+
+// dryRun previews the documents of ingest -dry-run: it prints the chunks
+// every document would be cut into, with their token counts as the
+// embedding model would count them, and totals them for printTotals.
+type dryRun struct {
+	tokenizer rag.Tokenizer
+	price     float64 // of the embedding model, in US dollars per million tokens
+
+	files, chunks, tokens, embed, embedTokens int
+}
+
+func newDryRun(cfg rag.Config, p *rag.Pipeline) (*dryRun, error) {
+	model := cfg.EmbeddingModel
+	switch cfg.Embedder {
+	case "", "openai":
+		model = cmp.Or(model, rag.DefaultOpenAIEmbeddingModel)
+	case "ollama":
+		model = cmp.Or(model, rag.DefaultOllamaEmbeddingModel)
+	case "vertex":
+		model = cmp.Or(model, rag.DefaultVertexEmbeddingModel)
+	case "bedrock":
+		model = cmp.Or(model, rag.DefaultBedrockEmbeddingModel)
+	}
+	tokenizer, err := rag.NewTiktokenTokenizer(model)
+	if err != nil {
+		return nil, err
+	}
+	d := &dryRun{tokenizer: tokenizer}
+	if p.Usage != nil {
+		d.price = p.Usage.Pricing[model].Input
+	}
+	return d, nil
+}
+
+// preview prints the chunks of docs, recording the documents that could not
+// be chunked as failed in report.
+func (d *dryRun) preview(ctx context.Context, p *rag.Pipeline, w io.Writer, docs []*rag.Document, report *changeReport) error {
+	for _, doc := range docs {
+		preview, err := p.Preview(ctx, doc)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			fmt.Fprintf(w, "File failed: %v (%v)\n", doc.ID, err)
+			report.Failed = append(report.Failed, doc.ID)
+			continue
+		}
+		d.print(w, preview)
+	}
+	return nil
+}
+
+func (d *dryRun) print(w io.Writer, chunked *rag.ChunkPreview) {
+	doc := chunked.Document
+	tokens := make([]int, len(chunked.Chunks))
+	total := 0
+	for i, c := range chunked.Chunks {
+		tokens[i] = d.tokenizer.CountTokens(c.Text)
+		total += tokens[i]
+	}
+	d.files++
+	d.chunks += len(chunked.Chunks)
+	d.tokens += total
+	d.embed += chunked.Embed
+	if chunked.Embed == len(chunked.Chunks) {
+		d.embedTokens += total
+	} else if len(chunked.Chunks) > 0 {
+		// The tokens of the changed chunks alone are not told apart
+		d.embedTokens += total * chunked.Embed / len(chunked.Chunks)
+	}
+
+	switch chunked.Embed {
+	case 0:
+		fmt.Fprintf(w, "File unchanged: %v (%d chunks, %d tokens)\n", doc.ID, len(chunked.Chunks), total)
+	case len(chunked.Chunks):
+		fmt.Fprintf(w, "File would be added: %v (%d chunks, %d tokens)\n", doc.ID, len(chunked.Chunks), total)
+	default:
+		fmt.Fprintf(w, "File would be updated: %v (%d chunks, %d tokens, %d re-embedded)\n", doc.ID, len(chunked.Chunks), total, chunked.Embed)
+	}
+	if len(chunked.Parents) > 0 {
+		fmt.Fprintf(w, "  %d parent chunks\n", len(chunked.Parents))
+	}
+	if len(doc.Metadata) > 0 {
+		fmt.Fprintf(w, "  metadata: %s\n", formatMetadata(doc.Metadata, nil))
+	}
+	for i, c := range chunked.Chunks {
+		fmt.Fprintf(w, "  %s: %d tokens, %d characters\n", c.ID, tokens[i], len([]rune(c.Text)))
+		fmt.Fprintf(w, "    starts: %q\n", preview(c.Text, previewChars))
+		fmt.Fprintf(w, "    ends:   %q\n", previewEnd(c.Text, previewChars))
+		if own := formatMetadata(c.Metadata, doc.Metadata); own != "" {
+			fmt.Fprintf(w, "    metadata: %s\n", own)
+		}
+	}
+}
+
+// printTotals prints what the documents previewed would cost to embed.
+func (d *dryRun) printTotals(w io.Writer) {
+	fmt.Fprintf(w, "Dry run: %d files, %d chunks, %d tokens; %d chunks to embed (about %d tokens)", d.files, d.chunks, d.tokens, d.embed, d.embedTokens)
+	if cost := d.price * float64(d.embedTokens) / 1e6; cost > 0 {
+		fmt.Fprintf(w, ", $%.6f", cost)
+	}
+	fmt.Fprintln(w)
+}
+
+// previewEnd is preview for the end of text.
+func previewEnd(text string, n int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if r := []rune(text); len(r) > n {
+		return "…" + string(r[len(r)-n:])
+	}
+	return text
+}
+
+// formatMetadata lists the entries of m that base does not have, sorted by
+// key.
+func formatMetadata(m, base rag.Metadata) string {
+	var entries []string
+	for _, k := range slices.Sorted(maps.Keys(m)) {
+		if v, ok := base[k]; !ok || v != m[k] {
+			entries = append(entries, k+"="+preview(m[k], previewChars))
+		}
+	}
+	return strings.Join(entries, ", ")
+}
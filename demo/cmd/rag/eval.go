@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jalling97/go_rag_demo/demo/rag"
+)
+
+// eval scores retrieval and answers against a JSONL file of cases, after
+// ingesting any files given after it.
+func eval(ctx context.Context, p *rag.Pipeline, args []string) error {
+	flags := flag.NewFlagSet("eval", flag.ExitOnError)
+	k := flags.Int("k", rag.DefaultTopK, "number of chunks to retrieve")
+	judge := flags.Bool("judge", true, "generate answers and have the LLM judge them")
+	verbose := flags.Bool("v", false, "print the metrics of every case")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		return errors.New("no evaluation file given")
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	cases, err := rag.ReadEvalCases(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %w", flags.Arg(0), err)
+	}
+	if flags.NArg() > 1 {
+		if err := ingest(ctx, p, flags.Args()[1:]); err != nil {
+			return err
+		}
+		fmt.Println()
+	}
+
+	evaluator := &rag.Evaluator{Pipeline: p, K: *k}
+	if *judge {
+		evaluator.Judge = p.LLM
+	}
+	report, err := evaluator.Run(ctx, cases)
+	if err != nil {
+		return err
+	}
+	for i, c := range report.Cases {
+		if !*verbose && c.Error == "" {
+			continue
+		}
+		fmt.Printf("%d. %s\n", i+1, c.Question)
+		fmt.Printf("   retrieved: %s\n", strings.Join(c.Retrieved, ", "))
+		fmt.Printf("   recall %.2f, reciprocal rank %.2f", c.Recall, c.ReciprocalRank)
+		if c.Faithfulness != nil {
+			fmt.Printf(", faithfulness %.2f", *c.Faithfulness)
+		}
+		if c.Correctness != nil {
+			fmt.Printf(", correctness %.2f", *c.Correctness)
+		}
+		fmt.Println()
+		if c.Reason != "" {
+			fmt.Printf("   judge: %s\n", c.Reason)
+		}
+		if c.Error != "" {
+			fmt.Printf("   error: %s\n", c.Error)
+		}
+	}
+	line := func(label string, value any) { fmt.Printf("%-14s %v\n", label+":", value) }
+	line("cases", len(report.Cases))
+	line(fmt.Sprintf("recall@%d", report.K), fmt.Sprintf("%.3f", report.Recall))
+	line("MRR", fmt.Sprintf("%.3f", report.MRR))
+	if *judge {
+		line("judged", report.Judged)
+		line("faithfulness", fmt.Sprintf("%.3f", report.Faithfulness))
+		line("correctness", fmt.Sprintf("%.3f", report.Correctness))
+	}
+	line("seconds", fmt.Sprintf("%.2f", report.Seconds))
+	if report.Cost > 0 {
+		line("cost", fmt.Sprintf("%.4f", report.Cost))
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jalling97/go_rag_demo/demo/rag"
+)
+
+// experiment runs the cases of a JSONL file through two pipeline
+// configurations side by side and compares their metrics and answers. Arm
+// A is configured by the file given with -a on top of the current config,
+// or the current config without it, and arm B by the file given with -b.
+// With files given after the cases, each arm ingests them into an index of
+// its own in memory; otherwise both query the configured store, and arms
+// that differ in how documents are ingested are refused.
+func experiment(ctx context.Context, p *rag.Pipeline, args []string) error {
+	flags := flag.NewFlagSet("experiment", flag.ExitOnError)
+	k := flags.Int("k", rag.DefaultTopK, "number of chunks to retrieve")
+	judge := flags.Bool("judge", true, "generate answers, have the LLM judge them and pick the better of each pair")
+	a := flags.String("a", "", "YAML config file of arm A, on top of the current config")
+	b := flags.String("b", "", "YAML config file of arm B, on top of the current config")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	verbose := flags.Bool("v", false, "print the comparison of every case")
+	flags.Parse(args)
+	if *b == "" {
+		return errors.New("no config file given for arm B with -b")
+	}
+	if flags.NArg() == 0 {
+		return errors.New("no evaluation file given")
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	cases, err := rag.ReadEvalCases(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %w", flags.Arg(0), err)
+	}
+	files := flags.Args()[1:]
+	exp := &rag.Experiment{K: *k}
+	if *judge {
+		exp.Judge = p.LLM
+	}
+	arms := []struct {
+		arm  *rag.ExperimentArm
+		file string
+		cfg  rag.Config
+	}{{arm: &exp.A, file: *a}, {arm: &exp.B, file: *b}}
+	for i := range arms {
+		arm := &arms[i]
+		arm.arm.Name = "baseline"
+		if arm.file != "" {
+			arm.arm.Name = strings.TrimSuffix(filepath.Base(arm.file), filepath.Ext(arm.file))
+		}
+		if arm.cfg, err = armConfig(arm.file, len(files) > 0); err != nil {
+			return fmt.Errorf("%s: %w", arm.arm.Name, err)
+		}
+	}
+	if differ := ingestSettingsDiffer(arms[0].cfg, arms[1].cfg); len(differ) > 0 && len(files) == 0 {
+		return fmt.Errorf("the arms differ in %s, which only apply as documents are ingested; give the files to ingest into each arm after the evaluation file", strings.Join(differ, ", "))
+	}
+	for _, arm := range arms {
+		if arm.arm.Pipeline, err = rag.NewPipeline(ctx, arm.cfg); err != nil {
+			return fmt.Errorf("%s: %w", arm.arm.Name, err)
+		}
+		if len(files) > 0 {
+			report, err := ingestSources(ctx, arm.arm.Pipeline, files, ingestOptions{crawler: newCrawler(), out: io.Discard})
+			if err != nil {
+				return fmt.Errorf("%s: %w", arm.arm.Name, err)
+			}
+			if !*asJSON {
+				fmt.Printf("Ingested for %s: %v\n", arm.arm.Name, report)
+			}
+		}
+	}
+	if len(files) > 0 && !*asJSON {
+		fmt.Println()
+	}
+	if exp.A.Name == exp.B.Name {
+		exp.A.Name, exp.B.Name = "A: "+exp.A.Name, "B: "+exp.B.Name
+	}
+
+	report, err := exp.Run(ctx, cases)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	for i, c := range report.Cases {
+		ca, cb := report.A.Cases[i], report.B.Cases[i]
+		if !*verbose && c.Error == "" && ca.Error == "" && cb.Error == "" {
+			continue
+		}
+		fmt.Printf("%d. %s\n", i+1, c.Question)
+		for _, arm := range []struct {
+			name string
+			c    rag.EvalCaseResult
+		}{{report.A.Name, ca}, {report.B.Name, cb}} {
+			fmt.Printf("   %s: recall %.2f, reciprocal rank %.2f", arm.name, arm.c.Recall, arm.c.ReciprocalRank)
+			if arm.c.Correctness != nil {
+				fmt.Printf(", correctness %.2f", *arm.c.Correctness)
+			}
+			fmt.Printf(", %.2fs\n", arm.c.Seconds)
+			if arm.c.Error != "" {
+				fmt.Printf("   %s error: %s\n", arm.name, arm.c.Error)
+			}
+		}
+		switch c.Preferred {
+		case rag.PreferA:
+			fmt.Printf("   better: %s; %s\n", report.A.Name, c.Reason)
+		case rag.PreferB:
+			fmt.Printf("   better: %s; %s\n", report.B.Name, c.Reason)
+		case rag.PreferTie:
+			fmt.Printf("   tie; %s\n", c.Reason)
+		}
+		if c.Error != "" {
+			fmt.Printf("   error: %s\n", c.Error)
+		}
+	}
+	width := max(len(report.A.Name), len(report.B.Name), 8)
+	line := func(label string, va, vb any) { fmt.Printf("%-14s %*v %*v\n", label+":", width, va, width, vb) }
+	fmt.Printf("%-14s %*s %*s\n", "", width, report.A.Name, width, report.B.Name)
+	line("cases", len(report.A.Cases), len(report.B.Cases))
+	line(fmt.Sprintf("recall@%d", report.A.K), fmt.Sprintf("%.3f", report.A.Recall), fmt.Sprintf("%.3f", report.B.Recall))
+	line("MRR", fmt.Sprintf("%.3f", report.A.MRR), fmt.Sprintf("%.3f", report.B.MRR))
+	if *judge {
+		line("judged", report.A.Judged, report.B.Judged)
+		line("faithfulness", fmt.Sprintf("%.3f", report.A.Faithfulness), fmt.Sprintf("%.3f", report.B.Faithfulness))
+		line("correctness", fmt.Sprintf("%.3f", report.A.Correctness), fmt.Sprintf("%.3f", report.B.Correctness))
+	}
+	line("seconds", fmt.Sprintf("%.2f", report.A.Seconds), fmt.Sprintf("%.2f", report.B.Seconds))
+	line("cost", fmt.Sprintf("%.4f", report.A.Cost), fmt.Sprintf("%.4f", report.B.Cost))
+	if *judge {
+		line("preferred", report.WinsA, report.WinsB)
+		fmt.Printf("%-14s %d of %d compared\n", "ties:", report.Ties, report.Compared)
+	}
+	return nil
+}
+
+// armConfig returns the config of an experiment arm: the current config
+// with the settings of file, if not empty, on top. Answers are neither
+// cached, so that each arm generates its own, nor recorded for feedback or
+// audit. With inMemory, the arm gets an empty index in memory.
+func armConfig(file string, inMemory bool) (rag.Config, error) {
+	var paths []string
+	if *configFile != "" {
+		paths = append(paths, *configFile)
+	}
+	if file != "" {
+		paths = append(paths, file)
+	}
+	cfg, err := rag.LoadConfig(paths...)
+	if err != nil {
+		return rag.Config{}, err
+	}
+	cfg.AnswerCache, cfg.FeedbackDB, cfg.AuditLog = "", "", ""
+	if inMemory {
+		cfg.VectorStore, cfg.Shards, cfg.ShardURLs = "memory", 0, ""
+	}
+	return cfg, nil
+}
+
+// ingestSettingsDiffer returns the settings that differ between a and b
+// and change how documents are loaded, chunked or embedded, which arms
+// querying the same store cannot compare.
+func ingestSettingsDiffer(a, b rag.Config) []string {
+	var differ []string
+	for _, s := range []struct {
+		env  string
+		a, b any
+	}{
+		{"EMBEDDER", a.Embedder, b.Embedder},
+		{"EMBEDDING_MODEL", a.EmbeddingModel, b.EmbeddingModel},
+		{"SPARSE_EMBEDDER", a.SparseEmbedder, b.SparseEmbedder},
+		{"CHUNK_SIZE", a.ChunkSize, b.ChunkSize},
+		{"CHUNK_OVERLAP", a.ChunkOverlap, b.ChunkOverlap},
+		{"PARENT_CHUNK_SIZE", a.ParentChunkSize, b.ParentChunkSize},
+		{"ROW_TEMPLATE", a.RowTemplate, b.RowTemplate},
+		{"PART_SIZE", a.PartSize, b.PartSize},
+		{"MAX_FILE_SIZE", a.MaxFileSize, b.MaxFileSize},
+		{"OCR", a.OCR, b.OCR},
+		{"OCR_LANGUAGES", a.OCRLanguages, b.OCRLanguages},
+		{"OCR_MIN_CHARS", a.OCRMinChars, b.OCRMinChars},
+		{"DEDUP", a.Dedup, b.Dedup},
+		{"DEDUP_THRESHOLD", a.DedupThreshold, b.DedupThreshold},
+		{"SUMMARY_FANOUT", a.SummaryFanout, b.SummaryFanout},
+		{"ENRICHMENT", a.Enrichment, b.Enrichment},
+		{"LANGUAGE_DETECTION", a.Languages, b.Languages},
+		{"PII", a.PII, b.PII},
+	} {
+		if s.a != s.b {
+			differ = append(differ, s.env)
+		}
+	}
+	return differ
+}
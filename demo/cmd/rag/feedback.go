@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/jalling97/go_rag_demo/demo/rag"
+)
+
+// feedback rates an answer given while FEEDBACK_DB was set, by the ID query
+// printed for it, as POST /feedback does.
+func feedback(ctx context.Context, p *rag.Pipeline, args []string) error {
+	flags := flag.NewFlagSet("feedback", flag.ExitOnError)
+	comment := flags.String("comment", "", "what was good or bad about the answer")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		return errors.New("usage: rag feedback [-comment text] <answer id> up|down")
+	}
+	f := rag.Feedback{AnswerID: flags.Arg(0), Rating: rag.Rating(flags.Arg(1)), Comment: *comment}
+	if err := p.SubmitFeedback(ctx, f); err != nil {
+		return err
+	}
+	fmt.Printf("Rated answer %s %s\n", f.AnswerID, f.Rating)
+	return nil
+}
@@ -0,0 +1,567 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/jalling97/go_rag_demo/demo/rag"
+)
+
+// ingestGroup is how many documents are loaded before their chunks are
+// embedded together, bounding memory use on large directories.
+const ingestGroup = 32
+
+// ingest loads and stores the given files. Directories are walked
+// recursively, skipping files no loader is registered for, the files in
+// zip and tar archives are ingested as ArchiveLoader describes, http(s) URLs
+// are crawled, s3:// and gs:// URLs name bucket prefixes whose objects
+// are ingested and confluence:// and notion:// URLs name Confluence spaces
+// and Notion databases whose pages are. Unchanged chunks are not embedded
+// again, and documents stored from a directory, prefix, space or database
+// whose files or pages have since been removed are deleted. Text, CSV and
+// JSONL files larger than PART_SIZE are ingested in parts, one at a time,
+// and files larger than MAX_FILE_SIZE are skipped. -acl restricts
+// the ingested documents to the given principals, and -expires stops them
+// from being retrieved after a date, see rag.ExpiresKey. With -resume,
+// objects and pages ingested are recorded in a file with their ETag or
+// version, and those recorded unchanged are not even downloaded again, so
+// that an interrupted run can be resumed and later runs only fetch what was
+// edited.
+// Interrupting ingest stops it after the document being stored. With
+// -dry-run, the documents are only loaded and chunked, and their chunks
+// printed with their token counts, see dryRun.
+func ingest(ctx context.Context, p *rag.Pipeline, args []string) (err error) {
+	flags := flag.NewFlagSet("ingest", flag.ExitOnError)
+	opts := ingestOptions{crawler: newCrawler(), out: os.Stdout}
+	flags.IntVar(&opts.crawler.MaxDepth, "depth", 2, "how many links to follow from a crawled URL")
+	flags.IntVar(&opts.crawler.MaxPages, "max-pages", rag.DefaultCrawlPages, "maximum number of pages to crawl per URL")
+	flags.BoolVar(&opts.crawler.SameDomain, "same-domain", true, "only crawl pages on the host of the starting URL")
+	flags.DurationVar(&opts.crawler.Delay, "delay", 0, "pause between crawled pages")
+	flags.BoolVar(&opts.prune, "prune", true, "delete stored documents of files removed from ingested directories and bucket prefixes")
+	flags.StringVar(&opts.acl, "acl", "", "comma-separated users and groups allowed to retrieve the documents, e.g. 'alice, group:eng'; everyone by default")
+	flags.StringVar(&opts.expires, "expires", "", "date or RFC 3339 time after which the documents are no longer retrieved and serve deletes them, e.g. 2026-12-31")
+	resume := flags.String("resume", "", "file recording the objects and pages ingested, to skip them when run again")
+	dry := flags.Bool("dry-run", false, "print the chunks the documents would be cut into, without embedding or storing them")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		return errors.New("no files given")
+	}
+	if opts.expires != "" {
+		if _, err := rag.ParseExpiry(opts.expires); err != nil {
+			return err
+		}
+	}
+	ctx, stop := stopOnSignal(ctx)
+	defer stop()
+	defer func() { err = interrupted(ctx, err) }()
+
+	if *dry {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		if opts.dryRun, err = newDryRun(cfg, p); err != nil {
+			return err
+		}
+		*resume = ""
+	}
+	if opts.progress, err = loadIngestProgress(*resume); err != nil {
+		return err
+	}
+	report, err := ingestSources(ctx, p, flags.Args(), opts)
+	if err != nil {
+		return err
+	}
+	if opts.dryRun != nil {
+		opts.dryRun.printTotals(opts.out)
+	} else if p.Usage != nil {
+		printUsage(p.Usage.Report())
+	}
+	if len(report.Failed) > 0 {
+		return fmt.Errorf("%d files could not be ingested", len(report.Failed))
+	}
+	return nil
+}
+
+// ingestOptions are the settings of ingestSources. The outcome of every
+// document is printed to out. With dryRun, documents are previewed rather
+// than ingested, and nothing is stored or deleted.
+type ingestOptions struct {
+	crawler  *rag.Crawler
+	prune    bool
+	acl      string
+	expires  string
+	progress *ingestProgress
+	out      io.Writer
+	dryRun   *dryRun
+}
+
+// newCrawler returns the crawler of ingest with its default settings.
+func newCrawler() *rag.Crawler {
+	return &rag.Crawler{UserAgent: "go_rag_demo", MaxDepth: 2, MaxPages: rag.DefaultCrawlPages, SameDomain: true}
+}
+
+// ingestSources ingests the files, directories, URLs, bucket prefixes and
+// page sources in roots as described for ingest and reports what changed
+// in the store.
+func ingestSources(ctx context.Context, p *rag.Pipeline, roots []string, opts ingestOptions) (*changeReport, error) {
+	stored, err := p.Store.Documents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	partSize, maxSize := int64(cfg.PartSize)<<20, int64(cfg.MaxFileSize)<<20
+	report := newChangeReport(ctx, stored)
+	crawler := *opts.crawler
+	crawler.MaxSize = maxSize
+	crawler.OnError = func(pageURL string, err error) {
+		fmt.Fprintf(opts.out, "Page skipped: %v (%v)\n", pageURL, err)
+	}
+	email := rag.EmailLoader{OnSkip: func(message string, err error) {
+		fmt.Fprintf(opts.out, "Message skipped: %v (%v)\n", message, err)
+	}}
+	rag.RegisterLoader(".eml", email)
+	rag.RegisterLoader(".mbox", email)
+
+	progress := opts.progress
+	if progress == nil {
+		progress, _ = loadIngestProgress("")
+	}
+	var docs []*rag.Document
+	flush := func() error {
+		if opts.dryRun != nil {
+			err := opts.dryRun.preview(ctx, p, opts.out, docs, report)
+			docs = docs[:0]
+			return err
+		}
+		results, err := p.IngestAll(ctx, docs)
+		if _, err := printResults(opts.out, results, err); err != nil {
+			return err
+		}
+		report.add(results)
+		docs = docs[:0]
+		return progress.commit(results)
+	}
+	add := func(doc *rag.Document) error {
+		if opts.acl != "" {
+			if doc.Metadata == nil {
+				doc.Metadata = rag.Metadata{}
+			}
+			doc.Metadata[rag.ACLKey] = opts.acl
+		}
+		if opts.expires != "" {
+			if doc.Metadata == nil {
+				doc.Metadata = rag.Metadata{}
+			}
+			doc.Metadata[rag.ExpiresKey] = opts.expires
+		}
+		docs = append(docs, doc)
+		if len(docs) == ingestGroup {
+			return flush()
+		}
+		return nil
+	}
+	seen := make(map[string]bool)
+	var dirs, prefixes []string
+	for _, root := range roots {
+		if strings.HasPrefix(root, "http://") || strings.HasPrefix(root, "https://") {
+			err := crawler.Crawl(ctx, root, add)
+			if errors.Is(err, rag.ErrFileTooLarge) {
+				fmt.Fprintf(opts.out, "Page skipped: %v (%v)\n", root, err)
+				report.Failed = append(report.Failed, root)
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if rag.IsBucketURL(root) {
+			bucket, err := rag.OpenBucket(root, cfg)
+			if err != nil {
+				return nil, err
+			}
+			if err := ingestBucket(ctx, bucket, partSize, maxSize, progress, stored, seen, report, opts.out, add, flush); err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, bucket.URL(bucket.Prefix))
+			continue
+		}
+		if rag.IsPageSourceURL(root) {
+			source, err := rag.OpenPageSource(root, cfg)
+			if err != nil {
+				return nil, err
+			}
+			if err := ingestPages(ctx, source, maxSize, progress, seen, report, opts.out, add); err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, source.URL())
+			continue
+		}
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			name := filepath.ToSlash(path)
+			if rag.IsArchive(path) {
+				return ingestArchive(ctx, p, path, cfg, stored, seen, report, opts, add, flush)
+			}
+			if _, err := rag.LoaderFor(path); err != nil && path != root {
+				return nil
+			}
+			err = rag.LoadFileParts(ctx, path, partSize, maxSize, func(doc *rag.Document) error {
+				seen[doc.ID] = true
+				if err := add(doc); err != nil || doc.ID == name {
+					return err
+				}
+				// Parts are stored one at a time to bound memory
+				return flush()
+			})
+			if errors.Is(err, rag.ErrFileTooLarge) {
+				// What is stored of the file is kept
+				for _, d := range stored {
+					seen[d.ID] = seen[d.ID] || rag.IsPartOf(d.ID, name)
+				}
+				fmt.Fprintf(opts.out, "File skipped: %v (%v)\n", name, err)
+				report.Failed = append(report.Failed, name)
+				return nil
+			}
+			if err != nil || opts.dryRun != nil {
+				return err
+			}
+			return removeStaleParts(ctx, p, stored, name, seen, report, opts.out)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if info, err := os.Stat(root); err == nil && info.IsDir() {
+			dirs = append(dirs, filepath.ToSlash(filepath.Clean(root)))
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	if opts.prune && opts.dryRun == nil && len(dirs)+len(prefixes) > 0 {
+		if err := pruneRemoved(ctx, p, dirs, prefixes, seen, report, opts.out); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// ingestArchive ingests the files in the archive at path, opening the
+// archives inside it too, and deletes the stored documents of files no
+// longer in it. Files larger than MAX_FILE_SIZE are skipped and added to
+// the failures of report, keeping what is stored of them.
+func ingestArchive(ctx context.Context, p *rag.Pipeline, path string, cfg rag.Config, stored []rag.DocumentInfo, seen map[string]bool, report *changeReport, opts ingestOptions, add func(*rag.Document) error, flush func() error) error {
+	name := filepath.ToSlash(path)
+	archive := rag.ArchiveLoader{
+		PartSize: int64(cfg.PartSize) << 20,
+		MaxSize:  int64(cfg.MaxFileSize) << 20,
+		OnSkip: func(file string, err error) {
+			for _, d := range stored {
+				seen[d.ID] = seen[d.ID] || rag.IsPartOf(d.ID, file)
+			}
+			fmt.Fprintf(opts.out, "File skipped: %v (%v)\n", file, err)
+			report.Failed = append(report.Failed, file)
+		},
+	}
+	err := archive.LoadFile(ctx, path, func(doc *rag.Document) error {
+		seen[doc.ID] = true
+		if err := add(doc); err != nil || doc.Metadata[rag.PartKey] == "" {
+			return err
+		}
+		// Parts are stored one at a time to bound memory
+		return flush()
+	})
+	if err != nil || opts.dryRun != nil {
+		return err
+	}
+	for _, d := range stored {
+		if seen[d.ID] || !inDir(d.ID, name) {
+			continue
+		}
+		if err := p.Delete(ctx, d.ID); err != nil {
+			return err
+		}
+		fmt.Fprintf(opts.out, "File removed from vector store: %v\n", d.ID)
+		report.Removed = append(report.Removed, d.ID)
+	}
+	return nil
+}
+
+// printResults prints the outcome of an ingestion to w and returns the
+// number of documents that failed. Errors other than a *rag.BatchError are
+// returned.
+func printResults(w io.Writer, results []rag.IngestResult, err error) (int, error) {
+	var batchErr *rag.BatchError
+	if err != nil && !errors.As(err, &batchErr) {
+		return 0, err
+	}
+	failed := 0
+	for _, r := range results {
+		if r.Error != "" {
+			failed++
+			fmt.Fprintf(w, "File failed: %v (%s)\n", r.ID, r.Error)
+			continue
+		}
+		switch r.Embedded {
+		case 0:
+			fmt.Fprintf(w, "File unchanged: %v (%d chunks)\n", r.ID, r.Chunks)
+		case r.Chunks:
+			fmt.Fprintf(w, "File added to vector store: %v (%d chunks)\n", r.ID, r.Chunks)
+		default:
+			fmt.Fprintf(w, "File updated in vector store: %v (%d chunks, %d re-embedded)\n", r.ID, r.Chunks, r.Embedded)
+		}
+		if r.Duplicates > 0 {
+			fmt.Fprintf(w, "  %d duplicate chunks skipped\n", r.Duplicates)
+		}
+	}
+	if batchErr != nil {
+		for _, f := range batchErr.Failures {
+			fmt.Fprintf(w, "Batch of chunks %d-%d failed: %v\n", f.Start, f.End-1, f.Err)
+		}
+	}
+	return failed, nil
+}
+
+// printUsage prints the tokens and cost of a report, if it has any.
+func printUsage(report *rag.UsageReport) {
+	var parts []string
+	if report.EmbeddingTokens > 0 {
+		parts = append(parts, fmt.Sprintf("%d embedding tokens", report.EmbeddingTokens))
+	}
+	if report.PromptTokens+report.CompletionTokens > 0 {
+		parts = append(parts, fmt.Sprintf("%d prompt and %d completion tokens", report.PromptTokens, report.CompletionTokens))
+	}
+	if len(parts) > 0 {
+		fmt.Printf("Usage: %s ($%.4f)\n", strings.Join(parts, ", "), report.Cost)
+	}
+}
+
+// pruneRemoved deletes stored documents that were loaded from a file within
+// one of dirs, or an object or page under one of the prefixes, but were not
+// seen this time, and adds them to report.
+func pruneRemoved(ctx context.Context, p *rag.Pipeline, dirs, prefixes []string, seen map[string]bool, report *changeReport, w io.Writer) error {
+	docs, err := p.Store.Documents(ctx)
+	if err != nil {
+		return err
+	}
+	for _, d := range docs {
+		within := slices.ContainsFunc(dirs, func(dir string) bool { return inDir(d.ID, dir) }) ||
+			slices.ContainsFunc(prefixes, func(prefix string) bool { return strings.HasPrefix(d.ID, prefix) })
+		if seen[d.ID] || !within {
+			continue
+		}
+		if err := p.Delete(ctx, d.ID); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "File removed from vector store: %v\n", d.ID)
+		report.Removed = append(report.Removed, d.ID)
+	}
+	return nil
+}
+
+// removeStaleParts deletes the stored documents of the file name, whole or
+// in parts, that were not seen when it was loaded this time, such as the
+// parts of a longer version of it, and adds them to report.
+func removeStaleParts(ctx context.Context, p *rag.Pipeline, stored []rag.DocumentInfo, name string, seen map[string]bool, report *changeReport, w io.Writer) error {
+	for _, d := range stored {
+		if seen[d.ID] || !rag.IsPartOf(d.ID, name) {
+			continue
+		}
+		if err := p.Delete(ctx, d.ID); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "File removed from vector store: %v\n", d.ID)
+		report.Removed = append(report.Removed, d.ID)
+	}
+	return nil
+}
+
+// inDir reports whether the document ID is the slash-separated path of a
+// file within dir.
+func inDir(id, dir string) bool {
+	if dir == "." {
+		// Walking "." yields paths without a leading "./"
+		return !path.IsAbs(id) && !strings.HasPrefix(id, "../") && !strings.Contains(id, "://")
+	}
+	return strings.HasPrefix(id, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// ingestBucket loads the objects under the prefix of bucket and passes them
+// to add, skipping those that progress records as ingested unchanged and
+// those of types no loader handles. Objects larger than partSize are
+// loaded in parts, as files are, which are stored one at a time with flush
+// and, not being recorded in progress, read again by every ingest. The
+// URLs of all listed objects, and the parts stored of those not loaded
+// again, are added to seen. Objects that cannot be downloaded or loaded,
+// or are larger than maxSize, are printed to w and added to the failures
+// of report.
+func ingestBucket(ctx context.Context, bucket *rag.Bucket, partSize, maxSize int64, progress *ingestProgress, stored []rag.DocumentInfo, seen map[string]bool, report *changeReport, w io.Writer, add func(*rag.Document) error, flush func() error) error {
+	return bucket.List(ctx, func(obj rag.BucketObject) error {
+		id := bucket.URL(obj.Key)
+		seen[id] = true
+		// What is stored of an object not loaded again is kept
+		keep := func() {
+			for _, d := range stored {
+				seen[d.ID] = seen[d.ID] || rag.IsPartOf(d.ID, id)
+			}
+		}
+		if progress.done(id, obj.ETag) {
+			keep()
+			fmt.Fprintf(w, "Object already ingested: %v\n", id)
+			report.Unchanged++
+			return nil
+		}
+		var stop error
+		err := bucket.LoadParts(ctx, obj, partSize, maxSize, func(doc *rag.Document) error {
+			seen[doc.ID] = true
+			if doc.Metadata[rag.PartKey] == "" {
+				progress.pending[id] = obj.ETag
+				stop = add(doc)
+				return stop
+			}
+			if stop = add(doc); stop == nil {
+				stop = flush()
+			}
+			return stop
+		})
+		if stop != nil {
+			return stop
+		}
+		if errors.Is(err, rag.ErrNoLoader) {
+			fmt.Fprintf(w, "Object skipped: %v (%v)\n", id, err)
+			return nil
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			keep()
+			fmt.Fprintf(w, "Object failed: %v (%v)\n", id, err)
+			report.Failed = append(report.Failed, id)
+		}
+		return nil
+	})
+}
+
+// ingestPages loads the pages of source and passes them to add, skipping
+// those that progress records as ingested at their current version. The
+// IDs of all listed pages are added to seen. Pages that cannot be loaded,
+// or have more than maxSize bytes of text, are printed to w and added to
+// the failures of report.
+func ingestPages(ctx context.Context, source rag.PageSource, maxSize int64, progress *ingestProgress, seen map[string]bool, report *changeReport, w io.Writer, add func(*rag.Document) error) error {
+	return source.Pages(ctx, func(page rag.Page) error {
+		seen[page.ID] = true
+		if progress.done(page.ID, page.Version) {
+			fmt.Fprintf(w, "Page already ingested: %v\n", page.ID)
+			report.Unchanged++
+			return nil
+		}
+		doc, err := source.Load(ctx, page)
+		if err == nil && maxSize > 0 {
+			size := 0
+			for _, sec := range doc.Sections {
+				size += len(sec.Text)
+			}
+			if int64(size) > maxSize {
+				err = fmt.Errorf("%w: %s has %d bytes of text, more than %d", rag.ErrFileTooLarge, page.ID, size, maxSize)
+			}
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			fmt.Fprintf(w, "Page failed: %v (%v)\n", page.ID, err)
+			report.Failed = append(report.Failed, page.ID)
+			return nil
+		}
+		progress.pending[page.ID] = page.Version
+		return add(doc)
+	})
+}
+
+// ingestProgress records the ETags of the objects ingested from buckets,
+// and the versions of the pages ingested from page sources, in a JSON file,
+// which is rewritten after every group of documents.
+type ingestProgress struct {
+	path    string            // no recording if empty
+	ETags   map[string]string `json:"etags"`
+	pending map[string]string // ETags of loaded objects not stored yet
+}
+
+// loadIngestProgress reads the progress file at path, which need not exist.
+// An empty path disables recording.
+func loadIngestProgress(path string) (*ingestProgress, error) {
+	progress := &ingestProgress{path: path, ETags: make(map[string]string), pending: make(map[string]string)}
+	if path == "" {
+		return progress, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return progress, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, progress); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	if progress.ETags == nil {
+		progress.ETags = make(map[string]string)
+	}
+	return progress, nil
+}
+
+// done reports whether the object or page with the given ID was ingested
+// with the given ETag or version.
+func (p *ingestProgress) done(id, etag string) bool {
+	return p.path != "" && etag != "" && p.ETags[id] == etag
+}
+
+// commit records the pending objects among results that were stored.
+func (p *ingestProgress) commit(results []rag.IngestResult) error {
+	if p.path == "" {
+		return nil
+	}
+	changed := false
+	for _, r := range results {
+		etag, ok := p.pending[r.ID]
+		if !ok {
+			continue
+		}
+		delete(p.pending, r.ID)
+		if r.Error == "" {
+			p.ETags[r.ID] = etag
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write a new file and rename it, so that an interrupted write does not
+	// lose the progress recorded so far
+	tmp := p.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.path)
+}
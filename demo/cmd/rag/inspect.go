@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jalling97/go_rag_demo/demo/rag"
+)
+
+// documents lists the stored documents with their chunk counts, or shows
+// the metadata and chunks of one, or its text with the given chunks marked,
+// so that what ingestion stored can be checked without asking the LLM.
+// expiring lists the documents that expire within -within, soonest first.
+func documents(ctx context.Context, p *rag.Pipeline, args []string) error {
+	if len(args) == 0 || args[0] == "list" && len(args) == 1 {
+		docs, err := p.Store.Documents(ctx)
+		if err != nil {
+			return err
+		}
+		for _, d := range docs {
+			fmt.Printf("%s\t%d chunks\n", d.ID, d.Chunks)
+		}
+		return nil
+	}
+	if len(args) >= 2 && args[0] == "source" {
+		return source(ctx, p, args[1], args[2:])
+	}
+	if len(args) >= 1 && args[0] == "expiring" {
+		return expiring(ctx, p, args[1:])
+	}
+	if len(args) != 2 || args[0] != "show" {
+		return errors.New("usage: rag documents [list | show <id> | source <id> [chunk id...] | expiring [-within 168h]]")
+	}
+	docID := args[1]
+	if s, ok := p.Store.(rag.SourceStore); ok {
+		doc, err := s.Source(ctx, docID)
+		if err != nil {
+			return err
+		}
+		if doc != nil {
+			fmt.Printf("Document %s, %d sections, %d characters\n", doc.ID, len(doc.Sections), len(doc.Text()))
+			printMetadata("  ", doc.Metadata)
+		}
+	}
+	s, ok := p.Store.(rag.ChunkStore)
+	if !ok {
+		// Stores that cannot return chunks can still list their IDs
+		is, ok := p.Store.(rag.IncrementalStore)
+		if !ok {
+			return errors.New("the vector store cannot return stored chunks")
+		}
+		hashes, err := is.ChunkHashes(ctx, docID)
+		if err != nil {
+			return err
+		}
+		if len(hashes) == 0 {
+			return fmt.Errorf("%w: %s", rag.ErrDocumentNotFound, docID)
+		}
+		for _, id := range slices.Sorted(maps.Keys(hashes)) {
+			fmt.Println(id)
+		}
+		return nil
+	}
+	chunks, err := s.Chunks(ctx, docID)
+	if err != nil {
+		return err
+	}
+	if len(chunks) == 0 {
+		return fmt.Errorf("%w: %s", rag.ErrDocumentNotFound, docID)
+	}
+	for _, c := range chunks {
+		fmt.Printf("%s\t%d characters\t%s\n", c.ID, len(c.Text), preview(c.Text, 60))
+	}
+	return nil
+}
+
+// expiring prints the documents expiring within -within, those already
+// expired, which serve deletes when EXPIRY_SWEEP is due, included.
+func expiring(ctx context.Context, p *rag.Pipeline, args []string) error {
+	flags := flag.NewFlagSet("documents expiring", flag.ExitOnError)
+	within := flags.Duration("within", 7*24*time.Hour, "how far ahead to look for expiring documents")
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		return errors.New("usage: rag documents expiring [-within 168h]")
+	}
+	docs, err := p.Expiring(ctx, time.Now().Add(*within))
+	if err != nil {
+		return err
+	}
+	for _, d := range docs {
+		state := "expires"
+		if d.Expired {
+			state = "expired"
+		}
+		fmt.Printf("%s\t%s %s\n", d.ID, state, d.ExpiresAt.Local().Format(time.DateTime))
+	}
+	return nil
+}
+
+// source prints the stored text of a document with the spans of the given
+// chunks between [[ and ]], overlapping spans marked as one.
+func source(ctx context.Context, p *rag.Pipeline, docID string, chunkIDs []string) error {
+	view, err := p.SourceView(ctx, docID, chunkIDs)
+	if err != nil {
+		return err
+	}
+	text := []rune(view.Text)
+	var b strings.Builder
+	at := 0
+	for i := 0; i < len(view.Highlights); {
+		start, end := view.Highlights[i].Start, view.Highlights[i].End
+		for i++; i < len(view.Highlights) && view.Highlights[i].Start <= end; i++ {
+			end = max(end, view.Highlights[i].End)
+		}
+		start, end = min(max(start, at), len(text)), min(end, len(text))
+		b.WriteString(string(text[at:start]) + "[[" + string(text[start:end]) + "]]")
+		at = end
+	}
+	b.WriteString(string(text[at:]))
+	fmt.Println(b.String())
+	if len(view.Highlights) > 0 {
+		fmt.Println()
+	}
+	for _, h := range view.Highlights {
+		fmt.Printf("%s: characters %d to %d\n", h.ChunkID, h.Start, h.End)
+	}
+	if len(view.Highlights) < len(chunkIDs) {
+		fmt.Println("\nChunks stored without offsets are not marked; ingest the document again to mark them")
+	}
+	return nil
+}
+
+// chunks shows a stored chunk or parent chunk with its metadata and text.
+func chunks(ctx context.Context, p *rag.Pipeline, args []string) error {
+	if len(args) != 2 || args[0] != "show" {
+		return errors.New("usage: rag chunks show <id>")
+	}
+	id := args[1]
+	// Chunk IDs are the document ID followed by #n, #sl-n for summaries or
+	// #pn for parents
+	cut := strings.LastIndex(id, "#")
+	if cut < 0 {
+		return fmt.Errorf("not a chunk ID: %s", id)
+	}
+	var chunk *rag.Chunk
+	if strings.HasPrefix(id[cut+1:], "p") {
+		s, ok := p.Store.(rag.ParentStore)
+		if !ok {
+			return errors.New("the vector store keeps no parent chunks")
+		}
+		parents, err := s.Parents(ctx, []string{id})
+		if err != nil {
+			return err
+		}
+		if c, ok := parents[id]; ok {
+			chunk = &c
+		}
+	} else {
+		s, ok := p.Store.(rag.ChunkStore)
+		if !ok {
+			return errors.New("the vector store cannot return stored chunks")
+		}
+		stored, err := s.Chunks(ctx, id[:cut])
+		if err != nil {
+			return err
+		}
+		if i := slices.IndexFunc(stored, func(c rag.Chunk) bool { return c.ID == id }); i >= 0 {
+			chunk = &stored[i]
+		}
+	}
+	if chunk == nil {
+		return fmt.Errorf("chunk not found: %s", id)
+	}
+	printChunk(*chunk, nil)
+	return nil
+}
+
+// search prints the chunks retrieved for a query with their scores,
+// metadata and text, without generating an answer. It retrieves as query
+// does, which only asks the LLM with HYDE or QUERY_VARIANTS; with -raw it
+// instead searches the vector store with the embedded query alone, printing
+// its similarity scores before any fusion, reranking or MIN_SCORE. Chunks
+// the principals given with -as may not see are left out either way.
+func search(ctx context.Context, p *rag.Pipeline, args []string) error {
+	flags := flag.NewFlagSet("search", flag.ExitOnError)
+	k := flags.Int("k", rag.DefaultTopK, "number of chunks to retrieve")
+	filter := flags.String("filter", "", "only retrieve chunks matching a metadata filter, e.g. 'source=handbook, year>=2023'")
+	raw := flags.Bool("raw", false, "search the vector store alone and print its raw similarity scores")
+	flags.Parse(args)
+	query := strings.Join(flags.Args(), " ")
+	if query == "" {
+		return errors.New("no query given")
+	}
+	f, err := rag.ParseFilter(*filter)
+	if err != nil {
+		return err
+	}
+	retriever := p.Retriever
+	if *raw {
+		retriever = &rag.ACLRetriever{Retriever: &rag.VectorRetriever{Embedder: p.Embedder, Store: p.Store, Model: p.EmbeddingModel}}
+	}
+	results, err := retriever.Retrieve(ctx, query, *k, f)
+	if err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		fmt.Println("No chunks found")
+	}
+	for i, r := range results {
+		if i > 0 {
+			fmt.Println()
+		}
+		printChunk(r.Chunk, &r.Score)
+	}
+	return nil
+}
+
+// printChunk prints a chunk's ID, its score if not nil, its metadata and its
+// text, indented.
+func printChunk(c rag.Chunk, score *float32) {
+	fmt.Print(c.ID)
+	if score != nil {
+		fmt.Printf(" (score %.4f)", *score)
+	}
+	fmt.Println()
+	if c.ParentID != "" {
+		fmt.Printf("  parent: %s\n", c.ParentID)
+	}
+	printMetadata("  ", c.Metadata)
+	fmt.Println()
+	for _, line := range strings.Split(c.Text, "\n") {
+		if line != "" {
+			line = "    " + line
+		}
+		fmt.Println(line)
+	}
+}
+
+// printMetadata prints metadata as key: value lines ordered by key.
+func printMetadata(indent string, metadata rag.Metadata) {
+	for _, key := range slices.Sorted(maps.Keys(metadata)) {
+		fmt.Printf("%s%s: %s\n", indent, key, metadata[key])
+	}
+}
+
+// preview returns the first n characters of text on one line.
+func preview(text string, n int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if r := []rune(text); len(r) > n {
+		return string(r[:n]) + "…"
+	}
+	return text
+}
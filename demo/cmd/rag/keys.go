@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jalling97/go_rag_demo/demo/rag"
+)
+
+// keys lists the API keys in API_KEYS with their usage this month, or
+// creates or revokes one. A created key is printed once; only its hash is
+// kept.
+func keys(ctx context.Context, p *rag.Pipeline, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.APIKeys == "" {
+		return errors.New("no key file; set API_KEYS")
+	}
+	store, err := rag.OpenAPIKeyStore(ctx, cfg.APIKeys)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if len(args) == 0 || args[0] == "list" {
+		keys, err := store.Keys(ctx)
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			fmt.Printf("%s\t%s\t%d requests, %s tokens this month", k.ID, k.Name, k.Requests, keyQuota(k))
+			if k.RateLimit > 0 {
+				fmt.Printf(", %g per minute", k.RateLimit)
+			}
+			if len(k.Principals) > 0 {
+				fmt.Printf(", as %s", strings.Join(k.Principals, ", "))
+			}
+			if !k.LastUsed.IsZero() {
+				fmt.Printf(", last used %s", k.LastUsed.Local().Format(time.DateTime))
+			}
+			fmt.Println()
+		}
+		return nil
+	}
+	switch args[0] {
+	case "create":
+		flags := flag.NewFlagSet("keys create", flag.ExitOnError)
+		principals := flags.String("principals", "", "comma-separated user and groups the key queries as; by default the caller names them in X-Principals")
+		rate := flags.Float64("rate", 0, "requests per minute the key may make, 0 for unlimited")
+		quota := flags.Int("quota", 0, "tokens the key may use per month, 0 for unlimited")
+		flags.Parse(args[1:])
+		if flags.NArg() != 1 {
+			return errors.New("usage: rag keys create [-principals list] [-rate n] [-quota n] <name>")
+		}
+		key, secret, err := store.Create(ctx, rag.APIKey{
+			Name:       flags.Arg(0),
+			Principals: rag.ParsePrincipals(*principals),
+			RateLimit:  *rate,
+			Quota:      *quota,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "API key %s created for %s; it is not shown again:\n", key.ID, key.Name)
+		fmt.Println(secret)
+	case "revoke":
+		if len(args) != 2 {
+			return errors.New("usage: rag keys revoke <id or name>")
+		}
+		if err := store.Revoke(ctx, args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("API key revoked: %s\n", args[1])
+	default:
+		return fmt.Errorf("unknown keys command %q", args[0])
+	}
+	return nil
+}
+
+// keyQuota describes the tokens a key used this month out of its quota.
+func keyQuota(k rag.APIKey) string {
+	if k.Quota == 0 {
+		return fmt.Sprint(k.Tokens)
+	}
+	return fmt.Sprintf("%d of %d", k.Tokens, k.Quota)
+}
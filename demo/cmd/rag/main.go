@@ -0,0 +1,223 @@
+// Command rag runs the local RAG pipeline from the command line.
+//
+// Usage:
+//
+//	rag [-config file] [-no-cache] [-namespace name] [-as principals] <command> [arguments]
+//
+//	rag ingest [-acl principals] [-expires date] [-resume file] [-dry-run] <file, directory, URL, bucket URL or page source>...
+//	rag query [-json] [-explain] [generation flags] <question>
+//	rag feedback [-comment text] <answer id> up|down
+//	rag search [-k 4] [-filter filter] [-raw] <query>
+//	rag documents [list | show <id> | source <id> [chunk id...] | expiring [-within 168h]]
+//	rag chunks show <id>
+//	rag summarize [-json] <document id> | -filter filter
+//	rag chat [-k 4] [-session id] [generation flags]
+//	rag eval [-k 4] [-judge=false] <cases.jsonl> [file or directory...]
+//	rag bench [-c 8] [-n requests | -duration 1m] [-stream] [-json] <questions file>
+//	rag experiment [-k 4] [-judge=false] [-json] [-v] [-a config.yaml] -b config.yaml <cases.jsonl> [file or directory...]
+//	rag prompts [-update] [-golden dir] <cases.jsonl> [file or directory...]
+//	rag serve [-addr :8080] [-grpc-addr :9090] [-shutdown-timeout 30s]
+//	rag rechunk
+//	rag reindex [-to location]
+//	rag sync
+//	rag namespaces [list | create [profile flags] <name> | delete <name> | profile [-reset] [profile flags] <name>]
+//	rag export <file>
+//	rag import <file>
+//	rag audit [-since time] [-until time] [-caller principal] [-text text] [-format text|jsonl|csv]
+//	rag keys [list | create [-principals list] [-rate n] [-quota n] <name> | revoke <id or name>]
+//
+// Providers are configured through environment variables, or in the YAML
+// file given with -config or RAG_CONFIG, whose settings the environment
+// variables override; see the README.
+// The default SQLite vector store keeps ingested documents in rag.db, so
+// they can be queried by later runs, together with the text extracted from
+// each file so that rechunk can split them again after CHUNK_SIZE or
+// CHUNK_OVERLAP changed. Embeddings are cached across runs unless -no-cache
+// is given. With -namespace, documents are ingested into, queried from and
+// listed in the given namespace instead of the default one; other
+// namespaces must be created first. A namespace may have a profile of its
+// own chunk size and overlap, embedding model and prompt template, set with
+// the -chunk-size, -chunk-overlap, -embedding-model and -prompt flags of
+// namespaces create and namespaces profile, which apply to the namespace in
+// place of the configured settings. Documents ingested with -acl are only
+// retrieved for the principals it lists, so questions about them must name
+// the caller's user and groups with -as. Documents ingested with -expires
+// are no longer retrieved once the date has passed, and serve deletes them
+// whenever EXPIRY_SWEEP is due; documents expiring lists those expiring
+// soon. export writes every namespace, with the chunks and embeddings of
+// its documents, to a snapshot file that import loads into the store of
+// another machine without embedding the documents again. prompts compares
+// the prompts assembled for a fixed corpus and set of questions with golden
+// files. sync ingests the sources listed in SYNC_SOURCES again, as serve
+// does whenever SYNC_SCHEDULE is due, skipping the objects and pages
+// recorded unchanged in SYNC_STATE.
+// ingest reads Confluence spaces and Notion databases given as
+// confluence://SPACE and notion://database-id. audit searches the log of
+// the queries answered while AUDIT_LOG was set and exports the matching
+// records as JSON Lines or CSV. keys manages the API keys serve requires
+// when API_KEYS is set. search, documents and chunks print what is
+// retrieved and stored, scores, metadata and chunk texts included, without
+// generating answers, for debugging bad answers at the retrieval layer.
+// summarize summarizes a stored document, or the documents matching
+// -filter, map-reduce style: SUMMARIZE_TOKENS of chunks at a time, then
+// the summaries of every section and finally those of the sections.
+// reindex embeds the stored chunks again, after EMBEDDING_MODEL changed,
+// into a new index that replaces the SQLite store at once or is built at
+// -to. feedback rates an answer by the ID query prints for it while
+// FEEDBACK_DB is set. experiment runs the cases of eval through two
+// configurations, the settings of the files given with -a and -b on top of
+// the current one, and compares their metrics and answers side by side.
+// bench asks the questions of a file, one per line, from several goroutines
+// at once and reports the throughput and the p50, p95 and p99 latencies of
+// every stage. The generation flags of query and chat, -temperature,
+// -top-p, -max-tokens, -stop, -presence-penalty and -frequency-penalty,
+// override the defaults of the model for their answers.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/jalling97/go_rag_demo/demo/rag"
+)
+
+var configFile = flag.String("config", os.Getenv("RAG_CONFIG"), "YAML config file; environment variables override its settings")
+
+type command func(ctx context.Context, p *rag.Pipeline, args []string) error
+
+var commands = map[string]command{
+	"audit":      audit,
+	"bench":      bench,
+	"chat":       chat,
+	"chunks":     chunks,
+	"documents":  documents,
+	"eval":       eval,
+	"experiment": experiment,
+	"export":     export,
+	"feedback":   feedback,
+	"import":     importSnapshot,
+	"ingest":     ingest,
+	"keys":       keys,
+	"namespaces": namespaces,
+	"prompts":    prompts,
+	"query":      query,
+	"rechunk":    rechunk,
+	"reindex":    reindex,
+	"search":     search,
+	"serve":      serve,
+	"summarize":  summarize,
+	"sync":       syncSources,
+}
+
+func main() {
+	noCache := flag.Bool("no-cache", false, "neither read nor write the embedding cache")
+	namespace := flag.String("namespace", rag.DefaultNamespace, "namespace to ingest into and query from")
+	as := flag.String("as", "", "comma-separated user and groups to query as, e.g. 'alice, group:eng'")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: rag [-config file] [-no-cache] [-namespace name] [-as principals] <ingest|query|feedback|search|documents|chunks|summarize|chat|rechunk|reindex|sync|eval|experiment|bench|prompts|serve|namespaces|export|import|audit|keys> [arguments]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 1 || commands[args[0]] == nil {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := rag.ValidateNamespace(*namespace); err != nil {
+		fmt.Fprintf(os.Stderr, "rag: %v\n", err)
+		os.Exit(2)
+	}
+	ctx := rag.WithNamespace(context.Background(), *namespace)
+	ctx = rag.WithPrincipals(ctx, rag.ParsePrincipals(*as)...)
+	if err := run(ctx, commands[args[0]], args[1:], *noCache); err != nil {
+		fmt.Fprintf(os.Stderr, "rag %s: %v\n", args[0], err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, cmd command, args []string, noCache bool) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if noCache {
+		cfg.EmbedCache = ""
+	}
+	if cfg.RowTemplate != "" {
+		tmpl, err := rag.ParseRowTemplate(cfg.RowTemplate)
+		if err != nil {
+			return fmt.Errorf("ROW_TEMPLATE: %w", err)
+		}
+		for _, ext := range []string{".csv", ".jsonl", ".ndjson"} {
+			rag.RegisterLoader(ext, rag.RecordLoader{Template: tmpl})
+		}
+	}
+	ocr, err := rag.NewOCR(cfg)
+	if err != nil {
+		return err
+	}
+	if ocr != nil {
+		rag.RegisterLoader(".pdf", rag.PDFLoader{OCR: ocr, OCRMinChars: cfg.OCRMinChars})
+	}
+	shutdown, err := rag.SetupTracing(ctx)
+	if err != nil {
+		return err
+	}
+	defer shutdown(context.Background())
+	p, err := rag.NewPipeline(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	if p.Webhooks != nil {
+		p.Webhooks.OnError = func(url string, event rag.WebhookEvent, err error) {
+			fmt.Fprintf(os.Stderr, "Delivering %s event %s to %s: %v\n", event.Type, event.ID, url, err)
+		}
+		// Events still being delivered when the command ends are waited for
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), webhookGrace)
+			defer cancel()
+			if err := p.Webhooks.Close(ctx); err != nil {
+				fmt.Fprintf(os.Stderr, "Gave up on webhook deliveries after %v\n", webhookGrace)
+			}
+		}()
+	}
+	return cmd(ctx, p, args)
+}
+
+// webhookGrace is how long a command waits for its webhook deliveries
+// once it is done.
+const webhookGrace = 30 * time.Second
+
+// loadConfig reads the config file given with -config, if any, and the
+// environment.
+func loadConfig() (rag.Config, error) {
+	if *configFile == "" {
+		return rag.ConfigFromEnv()
+	}
+	return rag.LoadConfig(*configFile)
+}
+
+// stopOnSignal returns a context canceled on SIGINT or SIGTERM, for
+// commands that write to the store and should stop between documents rather
+// than be killed in the middle of one. Once it is canceled, another signal
+// kills the process as usual.
+func stopOnSignal(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	context.AfterFunc(ctx, stop)
+	return ctx, stop
+}
+
+// interrupted replaces err with a note that the command was interrupted if
+// ctx, returned by stopOnSignal, was canceled.
+func interrupted(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return errors.New("interrupted; documents already stored are kept, run the command again to finish")
+	}
+	return err
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jalling97/go_rag_demo/demo/rag"
+)
+
+const namespacesUsage = "usage: rag namespaces [list | create [profile flags] <name> | delete <name> | profile [profile flags] <name>]"
+
+// namespaces lists the namespaces, creates or deletes one, or shows or
+// changes the profile of one.
+func namespaces(ctx context.Context, p *rag.Pipeline, args []string) error {
+	if len(args) == 0 || args[0] == "list" {
+		namespaces, err := p.Namespaces(ctx)
+		if err != nil {
+			return err
+		}
+		for _, n := range namespaces {
+			fmt.Printf("%s\t%d chunks\n", n.Name, n.Chunks)
+		}
+		return nil
+	}
+	switch args[0] {
+	case "create":
+		flags := flag.NewFlagSet("namespaces create", flag.ExitOnError)
+		set := profileFlags(flags)
+		flags.Parse(args[1:])
+		if flags.NArg() != 1 {
+			return errors.New(namespacesUsage)
+		}
+		name := flags.Arg(0)
+		profile, err := set(nil)
+		if err != nil {
+			return err
+		}
+		if err := p.CreateNamespace(ctx, name); err != nil {
+			return err
+		}
+		fmt.Printf("Namespace created: %s\n", name)
+		if profile != nil {
+			if err := p.SetNamespaceProfile(ctx, name, profile); err != nil {
+				return fmt.Errorf("setting the profile of %s: %w", name, err)
+			}
+			printProfile(profile)
+		}
+	case "delete":
+		if len(args) != 2 {
+			return errors.New(namespacesUsage)
+		}
+		if err := p.DeleteNamespace(ctx, args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("Namespace deleted: %s\n", args[1])
+	case "profile":
+		flags := flag.NewFlagSet("namespaces profile", flag.ExitOnError)
+		set := profileFlags(flags)
+		reset := flags.Bool("reset", false, "remove the profile, so that the namespace uses the configured settings")
+		flags.Parse(args[1:])
+		if flags.NArg() != 1 {
+			return errors.New(namespacesUsage)
+		}
+		name := flags.Arg(0)
+		profile, err := p.NamespaceProfile(ctx, name)
+		if err != nil {
+			return err
+		}
+		changed := *reset || flags.NFlag() > 0
+		if *reset {
+			profile = nil
+		}
+		if profile, err = set(profile); err != nil {
+			return err
+		}
+		if changed {
+			if err := p.SetNamespaceProfile(ctx, name, profile); err != nil {
+				return err
+			}
+		}
+		if profile == nil {
+			fmt.Fprintf(os.Stderr, "Namespace %s has no profile and uses the configured settings\n", name)
+			return nil
+		}
+		printProfile(profile)
+	default:
+		return fmt.Errorf("unknown namespaces command %q", args[0])
+	}
+	return nil
+}
+
+// profileFlags defines the flags setting the fields of a namespace profile
+// on flags. The function it returns applies those given to a profile, nil
+// for none, returning nil if no field is set.
+func profileFlags(flags *flag.FlagSet) func(*rag.NamespaceProfile) (*rag.NamespaceProfile, error) {
+	chunkSize := flags.Int("chunk-size", 0, "chunk size of the namespace's documents, 0 for CHUNK_SIZE")
+	chunkOverlap := flags.Int("chunk-overlap", 0, "chunk overlap of the namespace's documents, with -chunk-size")
+	model := flags.String("embedding-model", "", "embedding model of the configured EMBEDDER for the namespace, \"\" for EMBEDDING_MODEL")
+	prompt := flags.String("prompt", "", "prompt template file for the namespace's questions, \"\" for PROMPT_TEMPLATE")
+	return func(profile *rag.NamespaceProfile) (*rag.NamespaceProfile, error) {
+		var updated rag.NamespaceProfile
+		if profile != nil {
+			updated = *profile
+		}
+		var err error
+		flags.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "chunk-size":
+				updated.ChunkSize = *chunkSize
+			case "chunk-overlap":
+				updated.ChunkOverlap = *chunkOverlap
+			case "embedding-model":
+				updated.EmbeddingModel = *model
+			case "prompt":
+				updated.Prompt = ""
+				if *prompt != "" {
+					var data []byte
+					data, err = os.ReadFile(*prompt)
+					updated.Prompt = string(data)
+				}
+			}
+		})
+		if err != nil || updated == (rag.NamespaceProfile{}) {
+			return nil, err
+		}
+		return &updated, nil
+	}
+}
+
+// printProfile prints the settings a profile overrides.
+func printProfile(profile *rag.NamespaceProfile) {
+	if profile.ChunkSize > 0 {
+		fmt.Printf("chunk size\t%d, overlap %d\n", profile.ChunkSize, profile.ChunkOverlap)
+	}
+	if profile.EmbeddingModel != "" {
+		fmt.Printf("embedding model\t%s\n", profile.EmbeddingModel)
+	}
+	if profile.Prompt != "" {
+		fmt.Printf("prompt\t%d bytes\n", len(profile.Prompt))
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jalling97/go_rag_demo/demo/rag"
+)
+
+// prompts checks the prompts assembled for a JSONL file of cases against
+// golden files, after ingesting a fixed corpus of files given after it, so
+// that changes to loading, chunking, retrieval or the prompt template do
+// not alter what the model is asked unnoticed. The corpus is ingested into
+// a fresh in-memory store with the hash embedder, and the settings of
+// stages calling a model or keeping state are those of rag.PinPromptConfig,
+// so the prompts only depend on the files, the cases and the settings it
+// keeps; they give -date as today's date.
+// With -update the golden files are written instead.
+func prompts(ctx context.Context, _ *rag.Pipeline, args []string) error {
+	flags := flag.NewFlagSet("prompts", flag.ExitOnError)
+	golden := flags.String("golden", "", "directory of the golden files, the cases file without its extension by default")
+	update := flags.Bool("update", false, "write the golden files instead of comparing with them")
+	verbose := flags.Bool("v", false, "also list the prompts that did not change")
+	date := flags.String("date", "2025-01-01", "today's date as the prompts give it")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		return errors.New("no cases file given")
+	}
+	today, err := time.Parse(time.DateOnly, *date)
+	if err != nil {
+		return fmt.Errorf("-date: %w", err)
+	}
+	ctx = rag.WithPromptDate(ctx, today)
+	casesFile := flags.Arg(0)
+	dir := *golden
+	if dir == "" {
+		dir = strings.TrimSuffix(casesFile, filepath.Ext(casesFile))
+	}
+	f, err := os.Open(casesFile)
+	if err != nil {
+		return err
+	}
+	cases, err := rag.ReadPromptCases(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %w", casesFile, err)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	p, err := rag.NewPipeline(ctx, rag.PinPromptConfig(cfg))
+	if err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		if err := ingest(ctx, p, flags.Args()[1:]); err != nil {
+			return err
+		}
+		fmt.Println()
+	}
+	if *update {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+
+	changed := 0
+	for _, c := range cases {
+		messages, err := p.PromptMessages(ctx, c.QueryRequest)
+		if err != nil {
+			return fmt.Errorf("%s: %w", c.Name, err)
+		}
+		got := rag.FormatPrompt(messages)
+		path := filepath.Join(dir, c.Name+".golden")
+		if *update {
+			if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+				return err
+			}
+			fmt.Printf("wrote %s\n", path)
+			continue
+		}
+		want, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			changed++
+			fmt.Printf("NEW  %s: no golden file %s\n", c.Name, path)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		diff := rag.DiffLines(string(want), got, 3)
+		if diff == "" {
+			if *verbose {
+				fmt.Printf("ok   %s\n", c.Name)
+			}
+			continue
+		}
+		changed++
+		fmt.Printf("FAIL %s: the prompt changed (-want +got):\n%s\n", c.Name, diff)
+	}
+	if *update {
+		return nil
+	}
+	if changed > 0 {
+		return fmt.Errorf("%d of %d prompts changed; run with -update to accept them", changed, len(cases))
+	}
+	fmt.Printf("%d prompts unchanged\n", len(cases))
+	return nil
+}
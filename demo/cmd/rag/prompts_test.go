@@ -0,0 +1,16 @@
+package main
+
+import "testing"
+
+// TestPromptsGolden checks the prompts of testdata/prompts.jsonl against
+// their golden files as "rag prompts" does, so that a change to how
+// prompts are assembled fails the tests. Accept a change with
+//
+//	go run ./cmd/rag prompts -update testdata/prompts.jsonl doc_1.txt doc_2.txt
+func TestPromptsGolden(t *testing.T) {
+	// The cases filter on the paths of the corpus, relative to the module
+	t.Chdir("../..")
+	if err := prompts(t.Context(), nil, []string{"testdata/prompts.jsonl", "doc_1.txt", "doc_2.txt"}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jalling97/go_rag_demo/demo/rag"
+)
+
+// query answers a question, printing the answer as it streams in followed
+// by the sources it was drawn from and, if answers are checked for
+// grounding, the claims the sources do not support. With -json it instead
+// prints the Answer as JSON, generated in rag.FormatJSON. If the LLM
+// searched for the sources itself, the searches it made are printed too.
+// With -explain, no answer is generated; the candidate chunks are printed
+// with the scores each stage of retrieval gave them, see printExplanation.
+func query(ctx context.Context, p *rag.Pipeline, args []string) error {
+	flags := flag.NewFlagSet("query", flag.ExitOnError)
+	k := flags.Int("k", rag.DefaultTopK, "number of chunks to retrieve")
+	filter := flags.String("filter", "", "only retrieve chunks matching a metadata filter, e.g. 'source=handbook, year>=2023'")
+	asJSON := flags.Bool("json", false, "print a JSON object with the answer, its confidence, citations and sources")
+	minScore := flags.Float64("min-score", 0, "drop retrieved chunks scoring below it, MIN_SCORE by default")
+	agentSteps := flags.Int("agent-steps", 0, "turns in which the LLM may search with tool calls before answering, AGENT_STEPS by default")
+	explain := flags.Bool("explain", false, "print the scores of the chunks retrieved for the question instead of answering it")
+	generation := generationFlags(flags)
+	flags.Parse(args)
+	question := strings.Join(flags.Args(), " ")
+	if question == "" {
+		return errors.New("no question given")
+	}
+	req := rag.QueryRequest{Question: question, K: *k, Filter: *filter, GenerationOptions: generation()}
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "min-score":
+			req.MinScore = minScore
+		case "agent-steps":
+			req.AgentSteps = agentSteps
+		}
+	})
+	if *explain {
+		explanation, err := p.Explain(ctx, req)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(explanation)
+		}
+		printExplanation(explanation)
+		return nil
+	}
+	if *asJSON {
+		req.Format = rag.FormatJSON
+		answer, err := p.Query(ctx, req)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(answer)
+	}
+
+	fmt.Println(">", question)
+	answer, err := p.QueryStream(ctx, req, func(delta string) error {
+		fmt.Print(delta)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+	fmt.Println()
+	for i, s := range answer.Sources {
+		page := ""
+		if s.Page > 0 {
+			page = fmt.Sprintf(" p.%d", s.Page)
+		}
+		if s.URL != "" && s.URL != s.DocID {
+			page += " <" + s.URL + ">"
+		}
+		note := ""
+		switch s.Injection {
+		case rag.InjectionFlag:
+			note = ", possible prompt injection"
+		case rag.InjectionStrip:
+			note = ", prompt injection removed"
+		}
+		if s.Summarized {
+			note += ", summarized to fit"
+		}
+		fmt.Printf("[%d] %s%s, chunk %d (score %.3f%s)\n", i+1, s.DocID, page, s.Chunk, s.Score, note)
+	}
+	if len(answer.Trace) > 0 {
+		fmt.Println("\nSearched for the sources in these tool calls:")
+		for _, step := range answer.Trace {
+			result := fmt.Sprintf("passages %v", step.Passages)
+			if step.Error != "" {
+				result = "error: " + step.Error
+			}
+			fmt.Printf("  %d. %s %s: %s\n", step.Step, step.Tool, step.Arguments, result)
+		}
+	}
+	if g := answer.Grounding; g != nil {
+		fmt.Printf("\nGroundedness %.2f", g.Score)
+		if g.Regenerated {
+			fmt.Print(" (regenerated after unsupported claims)")
+		}
+		fmt.Println()
+		switch {
+		case g.Stripped:
+			fmt.Println("Removed from the answer as unsupported by the sources:")
+		case len(g.Unsupported) > 0:
+			fmt.Println("Unsupported by the sources:")
+		}
+		for _, c := range g.Unsupported {
+			fmt.Printf("  - %s\n", c)
+		}
+	}
+	if answer.Cached {
+		fmt.Println("\nAnswered from the answer cache")
+	}
+	if answer.Route != "" {
+		fmt.Printf("\nRouted to %s\n", answer.Route)
+	}
+	if answer.Provider != "" {
+		fmt.Printf("\nGenerated by %s\n", answer.Provider)
+	}
+	if len(answer.TimedOut) > 0 {
+		fmt.Printf("\nSkipped as they timed out: %s\n", strings.Join(answer.TimedOut, ", "))
+	}
+	if answer.ID != "" {
+		fmt.Printf("\nAnswer %s; rate it with rag feedback %s up|down\n", answer.ID, answer.ID)
+	}
+	if answer.Usage != nil {
+		fmt.Println()
+		printUsage(answer.Usage)
+	}
+	return nil
+}
+
+// generationFlags defines the flags of the generation options of answers
+// on flags and returns a function returning the options set by them once
+// flags were parsed. Options whose flags are not given keep the defaults
+// of the model.
+func generationFlags(flags *flag.FlagSet) func() rag.GenerationOptions {
+	var opts rag.GenerationOptions
+	temperature := flags.Float64("temperature", 0, "sampling temperature of the answer, from 0 to 2")
+	topP := flags.Float64("top-p", 0, "sample the answer from the likeliest tokens whose probabilities add up to this, from 0 to 1")
+	flags.IntVar(&opts.MaxTokens, "max-tokens", 0, "most tokens the answer may have; the model's limit if 0")
+	flags.Func("stop", fmt.Sprintf("end the answer before this sequence; may be given up to %d times", rag.MaxStopSequences), func(s string) error {
+		opts.Stop = append(opts.Stop, s)
+		return nil
+	})
+	presence := flags.Float64("presence-penalty", 0, "discourage tokens that appeared in the answer so far, from -2 to 2")
+	frequency := flags.Float64("frequency-penalty", 0, "discourage tokens by how often they appeared in the answer so far, from -2 to 2")
+	return func() rag.GenerationOptions {
+		flags.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "temperature":
+				opts.Temperature = temperature
+			case "top-p":
+				opts.TopP = topP
+			case "presence-penalty":
+				opts.PresencePenalty = presence
+			case "frequency-penalty":
+				opts.FrequencyPenalty = frequency
+			}
+		})
+		return opts
+	}
+}
+
+// printExplanation prints a table of the candidate chunks of an
+// explanation: their rank among the retrieved chunks, whether they made it
+// into the prompt, the score they were retrieved with and their dense,
+// sparse and rerank scores, "-" standing for none.
+func printExplanation(e *rag.Explanation) {
+	if e.Route != "" {
+		fmt.Printf("Routed to %s\n", e.Route)
+	}
+	if e.Query != "" {
+		fmt.Printf("Retrieved for %q\n", e.Query)
+	}
+	if e.SelfQuery != nil {
+		fmt.Printf("Searched for %q", e.SelfQuery.Query)
+		if len(e.SelfQuery.Filter) > 0 {
+			fmt.Printf(" where %v", e.SelfQuery.Filter)
+		}
+		fmt.Println()
+	}
+	if len(e.TimedOut) > 0 {
+		fmt.Printf("Skipped as they timed out: %s\n", strings.Join(e.TimedOut, ", "))
+	}
+	if e.Route != "" || e.Query != "" || e.SelfQuery != nil || len(e.TimedOut) > 0 {
+		fmt.Println()
+	}
+	if len(e.Candidates) == 0 {
+		fmt.Println("No chunks found")
+		return
+	}
+	fmt.Printf("%4s  %-6s  %8s  %8s  %8s  %8s  %s\n", "rank", "prompt", "score", "dense", "sparse", "rerank", "chunk")
+	for _, c := range e.Candidates {
+		rank, prompt := "-", "no"
+		if c.Rank > 0 {
+			rank = strconv.Itoa(c.Rank)
+		}
+		if c.InPrompt {
+			prompt = "yes"
+		}
+		fmt.Printf("%4s  %-6s  %8s  %8s  %8s  %8s  %s\n", rank, prompt, formatScore(c.Score), formatScore(c.DenseScore), formatScore(c.SparseScore), formatScore(c.RerankScore), c.ID)
+	}
+}
+
+// formatScore formats a score of an explanation, or "-" if there is none.
+func formatScore(score *float32) string {
+	if score == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.4f", *score)
+}
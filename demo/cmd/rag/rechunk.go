@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/jalling97/go_rag_demo/demo/rag"
+)
+
+// rechunk splits the stored documents again with the configured chunk size
+// and overlap, without loading their files, and embeds the chunks that
+// changed. Interrupting it stops it after the document being stored.
+func rechunk(ctx context.Context, p *rag.Pipeline, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("rechunk takes no arguments")
+	}
+	ctx, stop := stopOnSignal(ctx)
+	defer stop()
+	results, err := p.Rechunk(ctx)
+	failed, err := printResults(os.Stdout, results, err)
+	if err != nil {
+		return interrupted(ctx, err)
+	}
+	if p.Usage != nil {
+		printUsage(p.Usage.Report())
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d documents could not be re-chunked", failed)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jalling97/go_rag_demo/demo/rag"
+)
+
+// reindex embeds every stored chunk again with the configured embedding
+// model, after it changed, into a new index. With the SQLite store and no
+// -to, the new index is built next to SQLITE_PATH and then renamed over
+// it, and with pgvector it is built in a schema of the database and then
+// swapped in, so that the switch is atomic. Otherwise it is built at -to,
+// a SQLite file, a DATABASE_URL or a collection, and the setting that
+// switches to it is printed: with the collection stores, every process
+// switches as it is restarted with the new COLLECTION, not atomically.
+func reindex(ctx context.Context, p *rag.Pipeline, args []string) (err error) {
+	flags := flag.NewFlagSet("reindex", flag.ExitOnError)
+	to := flags.String("to", "", "SQLite file, DATABASE_URL or collection to build the new index in, instead of replacing the active one")
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		return errors.New("usage: rag reindex [-to location]")
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.Shards > 0 || cfg.ShardURLs != "" {
+		return errors.New("sharded stores cannot be reindexed")
+	}
+	target := cfg
+	swap := false
+	var setting string
+	switch cfg.VectorStore {
+	case "", "sqlite":
+		if *to == "" {
+			*to, swap = cfg.SQLitePath+".reindex", true
+			// A reindex interrupted before is started over
+			for _, suffix := range []string{"", "-wal", "-shm"} {
+				if err := os.Remove(*to + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+					return err
+				}
+			}
+		}
+		target.SQLitePath, setting = *to, "SQLITE_PATH"
+	case "memory":
+		return errors.New("the memory store keeps no index to reindex")
+	case "pgvector":
+		target.DatabaseURL, setting = *to, "DATABASE_URL"
+		swap = *to == ""
+	default:
+		target.Collection, setting = *to, "COLLECTION"
+	}
+	if *to == "" && !swap {
+		return fmt.Errorf("reindexing %s needs -to, a new collection to switch COLLECTION to", cfg.VectorStore)
+	}
+	var store rag.VectorStore
+	if cfg.VectorStore == "pgvector" && swap {
+		metric := rag.Metric(cmp.Or(cfg.Metric, string(rag.MetricCosine)))
+		store, err = rag.NewPGVectorReindexStore(ctx, cfg.DatabaseURL, metric)
+	} else {
+		store, err = rag.NewVectorStore(ctx, target)
+	}
+	if err != nil {
+		return err
+	}
+	ctx, stop := stopOnSignal(ctx)
+	defer stop()
+	stats, err := p.Reindex(ctx, store, func(r rag.ReindexProgress) {
+		fmt.Fprintf(os.Stderr, "Reindexed %d of %d documents (%d chunks)\n", r.Documents, r.Total, r.Chunks)
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return errors.New("interrupted; the active index is unchanged, run the command again to start over")
+		}
+		return err
+	}
+	fmt.Printf("Reindexed %d documents (%d chunks) in %d namespaces\n", stats.Documents, stats.Chunks, stats.Namespaces)
+	if !swap {
+		fmt.Printf("Set %s=%s to switch to the new index\n", setting, *to)
+		return nil
+	}
+	if pg, ok := store.(*rag.PGVectorStore); ok {
+		defer pg.Close()
+		if err := pg.SwapIndex(ctx); err != nil {
+			return err
+		}
+		fmt.Println("Switched DATABASE_URL to the new index")
+		return nil
+	}
+	// Both databases are closed first, which folds their write-ahead logs
+	// into them; a log left behind belongs to another process still using
+	// the old index, which would read it into the new one
+	for _, s := range []rag.VectorStore{store, p.Store} {
+		if c, ok := s.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := os.Stat(cfg.SQLitePath + "-wal"); err == nil {
+		return fmt.Errorf("%s is in use by another process; stop it and rename %s to %s to switch", cfg.SQLitePath, *to, cfg.SQLitePath)
+	}
+	if err := os.Rename(*to, cfg.SQLitePath); err != nil {
+		return err
+	}
+	fmt.Printf("Switched %s to the new index\n", cfg.SQLitePath)
+	return nil
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/jalling97/go_rag_demo/demo/rag"
+)
+
+// serve exposes the pipeline over HTTP and, if -grpc-addr is set, gRPC.
+// Setting -addr to the empty string serves gRPC only. Documents posted to
+// /ingest are ingested in the background by jobs kept in JOBS_DB, and jobs
+// left unfinished by an earlier run are resumed. On SIGINT or SIGTERM the
+// servers stop accepting connections and wait up to -shutdown-timeout for
+// the requests in flight to finish, while the running job stops after the
+// document it is writing, to continue when the server is started again.
+// With SYNC_SCHEDULE set, the SYNC_SOURCES are synced into the namespace
+// given with -namespace whenever the schedule is due, see syncSources.
+// Whenever EXPIRY_SWEEP is due, expired documents are deleted from every
+// namespace, see sweepOnSchedule.
+// With API_KEYS set, every request needs one of the keys managed with
+// rag keys. Unless -reload is false, the retrieval and generation settings
+// are reloaded without a restart whenever the config file, the prompt
+// template or the routes change, see reloadOnChange. /healthz reports
+// that the server is up and /readyz whether the vector store and the
+// providers are reachable, for the liveness and readiness probes of
+// Kubernetes.
+func serve(ctx context.Context, p *rag.Pipeline, args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", ":8080", "address to serve HTTP on")
+	grpcAddr := flags.String("grpc-addr", "", "address to serve gRPC on, e.g. :9090")
+	shutdownTimeout := flags.Duration("shutdown-timeout", 30*time.Second, "how long to wait for requests in flight when shutting down")
+	reload := flags.Bool("reload", true, "reload the retrieval and generation settings when their files change or on SIGHUP")
+	flags.Parse(args)
+	if *addr == "" && *grpcAddr == "" {
+		return errors.New("no address to listen on")
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	ctx, stop := stopOnSignal(ctx)
+	defer stop()
+	// Run until either server or the job queue fails, or a signal arrives
+	errc := make(chan error, 3)
+	var background sync.WaitGroup
+	var jobs *rag.JobQueue
+	if cfg.JobsDB != "" && *addr != "" {
+		if jobs, err = rag.OpenJobQueue(ctx, cfg.JobsDB, p); err != nil {
+			return err
+		}
+		defer jobs.Close()
+		background.Go(func() {
+			if err := jobs.Run(ctx); ctx.Err() == nil {
+				errc <- fmt.Errorf("ingestion jobs: %w", err)
+			}
+		})
+	}
+	if cfg.SyncSchedule != "" {
+		schedule, err := rag.ParseSchedule(cfg.SyncSchedule)
+		if err != nil {
+			return err
+		}
+		log.Printf("Syncing %s on schedule %q", cfg.SyncSources, cfg.SyncSchedule)
+		background.Go(func() { syncOnSchedule(ctx, p, cfg, schedule) })
+	}
+	if cfg.ExpirySweep != "" {
+		schedule, err := rag.ParseSchedule(cfg.ExpirySweep)
+		if err != nil {
+			return err
+		}
+		background.Go(func() { sweepOnSchedule(ctx, p, schedule) })
+	}
+	var keys *rag.APIKeyStore
+	if cfg.APIKeys != "" {
+		if keys, err = rag.OpenAPIKeyStore(ctx, cfg.APIKeys); err != nil {
+			return err
+		}
+		defer keys.Close()
+		log.Printf("Requiring API keys from %s", cfg.APIKeys)
+	}
+	reloader := rag.NewReloader(p, cfg)
+	if *reload {
+		background.Go(func() { reloadOnChange(ctx, reloader, cfg) })
+	}
+	var hs *http.Server
+	if *addr != "" {
+		handler := reloader.Handler(jobs)
+		if keys != nil {
+			handler = rag.RequireAPIKey(handler, keys)
+		}
+		hs = &http.Server{Addr: *addr, Handler: handler}
+		go func() {
+			log.Printf("Listening on %s", *addr)
+			if err := hs.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				errc <- err
+			}
+		}()
+	}
+	var gs *grpc.Server
+	if *grpcAddr != "" {
+		lis, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			return err
+		}
+		opts := rag.GRPCServerOptions()
+		if keys != nil {
+			opts = append(opts, rag.GRPCAPIKeyOptions(keys)...)
+		}
+		gs = grpc.NewServer(opts...)
+		reloader.RegisterGRPC(gs)
+		go func() {
+			log.Printf("Serving gRPC on %s", *grpcAddr)
+			if err := gs.Serve(lis); err != nil {
+				errc <- err
+			}
+		}()
+	}
+
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		log.Printf("Shutting down, waiting up to %v for requests in flight", *shutdownTimeout)
+	}
+	// Stops the job queue too
+	stop()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if hs != nil {
+		if err := hs.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Closing connections of unfinished HTTP requests: %v", err)
+			hs.Close()
+		}
+	}
+	if gs != nil {
+		stopGRPC(shutdownCtx, gs)
+	}
+	background.Wait()
+	return err
+}
+
+// sweepOnSchedule deletes the expired documents of p whenever schedule is
+// due, until ctx is done, and logs those it deleted. It stops if the store
+// cannot list the expiry times of its documents.
+func sweepOnSchedule(ctx context.Context, p *rag.Pipeline, schedule rag.Schedule) {
+	for {
+		next := schedule.Next(time.Now())
+		if next.IsZero() {
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+		deleted, err := p.SweepExpired(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		for _, d := range deleted {
+			log.Printf("Deleted %s from namespace %s, expired %s", d.ID, d.Namespace, d.ExpiresAt.Local().Format(time.DateTime))
+		}
+		if errors.Is(err, rag.ErrNotSupported) {
+			log.Printf("Not deleting expired documents: %v", err)
+			return
+		}
+		if err != nil {
+			log.Printf("Deleting expired documents failed: %v", err)
+		}
+	}
+}
+
+// stopGRPC stops s gracefully, or forcibly once ctx is done.
+func stopGRPC(ctx context.Context, s *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Printf("Canceling unfinished gRPC calls: %v", ctx.Err())
+		s.Stop()
+		<-done
+	}
+}
+
+// reloadInterval is how often reloadOnChange looks for changed files.
+const reloadInterval = 2 * time.Second
+
+// reloadOnChange reloads the pipeline of r from the config whenever the
+// config file, PROMPT_TEMPLATE or ROUTES changes, or the process gets
+// SIGHUP, until ctx is done, so that prompts and retrieval can be tuned
+// while serving. Only the settings Pipeline.Reconfigure applies take
+// effect; changes to the others are logged as needing a restart. A config
+// that does not load or is invalid is logged, and the pipeline kept.
+func reloadOnChange(ctx context.Context, r *rag.Reloader, cfg rag.Config) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+	watched := func() map[string]string { return fileVersions(*configFile, cfg.PromptTemplate, cfg.Routes) }
+	versions := watched()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		case <-ticker.C:
+			if maps.Equal(watched(), versions) {
+				continue
+			}
+		}
+		next, err := loadConfig()
+		var applied, ignored []string
+		if err == nil {
+			applied, ignored, err = r.Reload(next)
+		}
+		if err != nil {
+			log.Printf("Not reloading the config: %v", err)
+		} else {
+			cfg = next
+			if len(applied) > 0 {
+				log.Printf("Reloaded the config; changed %s", strings.Join(applied, ", "))
+			} else {
+				log.Printf("Reloaded the config")
+			}
+			if len(ignored) > 0 {
+				log.Printf("Restart to apply the changes to %s", strings.Join(ignored, ", "))
+			}
+		}
+		// A broken file is not reloaded again until it changes
+		versions = watched()
+	}
+}
+
+// fileVersions returns the modification time and size of every file of
+// paths that is not empty, or the empty string for files that do not
+// exist, to compare with those of a later call.
+func fileVersions(paths ...string) map[string]string {
+	versions := make(map[string]string)
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil {
+			versions[path] = fmt.Sprintf("%v %d", info.ModTime(), info.Size())
+		} else {
+			versions[path] = ""
+		}
+	}
+	return versions
+}
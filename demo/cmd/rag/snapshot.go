@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jalling97/go_rag_demo/demo/rag"
+)
+
+// export writes a snapshot of the whole index to a file, or to standard
+// output for "-".
+func export(ctx context.Context, p *rag.Pipeline, args []string) (err error) {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: rag export <file>")
+	}
+	var w io.Writer = os.Stdout
+	if args[0] != "-" {
+		f, err := os.Create(args[0])
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		w = f
+	}
+	stats, err := p.Export(ctx, w)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d documents (%d chunks) in %d namespaces\n", stats.Documents, stats.Chunks, stats.Namespaces)
+	return nil
+}
+
+// importSnapshot loads a snapshot written by export from a file, or from
+// standard input for "-".
+func importSnapshot(ctx context.Context, p *rag.Pipeline, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: rag import <file>")
+	}
+	var r io.Reader = os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	stats, err := p.Import(ctx, r)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d documents (%d chunks) in %d namespaces\n", stats.Documents, stats.Chunks, stats.Namespaces)
+	return nil
+}
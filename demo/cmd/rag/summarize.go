@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jalling97/go_rag_demo/demo/rag"
+)
+
+// summarize prints the summary of a stored document, or of the documents
+// matching -filter, with its key points and the summary of every section,
+// as POST /summarize returns it.
+func summarize(ctx context.Context, p *rag.Pipeline, args []string) error {
+	flags := flag.NewFlagSet("summarize", flag.ExitOnError)
+	filter := flags.String("filter", "", "summarize the documents with chunks matching this filter, e.g. 'source=handbook'")
+	asJSON := flags.Bool("json", false, "print a JSON object with the summary, key points and sections")
+	flags.Parse(args)
+	req := rag.SummarizeRequest{Filter: *filter}
+	if flags.NArg() == 1 {
+		req.DocID = flags.Arg(0)
+	}
+	if flags.NArg() > 1 || (req.DocID == "") == (req.Filter == "") {
+		return errors.New("usage: rag summarize [-json] <document id> | -filter filter")
+	}
+	summary, err := p.Summarize(ctx, req)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(summary)
+	}
+	fmt.Println(summary.Summary)
+	if len(summary.KeyPoints) > 0 {
+		fmt.Println("\nKey points:")
+		for _, point := range summary.KeyPoints {
+			fmt.Printf("- %s\n", point)
+		}
+	}
+	if len(summary.Sections) > 1 {
+		fmt.Println("\nSections:")
+		for _, sec := range summary.Sections {
+			title := sec.DocID
+			if sec.Title != "" {
+				title += " > " + sec.Title
+			}
+			fmt.Printf("\n%s (%d chunks)\n%s\n", title, len(sec.Chunks), sec.Summary)
+		}
+	}
+	fmt.Printf("\nSummarized %d chunks of %d documents in %d LLM calls\n", summary.Chunks, summary.Documents, summary.Calls)
+	if summary.Usage != nil {
+		printUsage(summary.Usage)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jalling97/go_rag_demo/demo/rag"
+)
+
+// syncSources ingests the SYNC_SOURCES again, as ingest does with its
+// default flags: changed files are re-embedded, new ones added and those
+// removed from directories, bucket prefixes and page sources deleted. With
+// SYNC_STATE, the ETags and versions of the objects and pages synced are
+// recorded there as ingest -resume does, so that only those edited since
+// are downloaded again. It prints the outcome of every document and a
+// summary of the change report, which is also appended to SYNC_REPORT if it
+// is set. With SYNC_SCHEDULE, serve syncs the same way in the background.
+func syncSources(ctx context.Context, p *rag.Pipeline, args []string) error {
+	if len(args) > 0 {
+		return errors.New("sync takes no arguments")
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	ctx, stop := stopOnSignal(ctx)
+	defer stop()
+	report, err := runSync(ctx, p, cfg, os.Stdout)
+	if report == nil {
+		return interrupted(ctx, err)
+	}
+	fmt.Printf("Sync: %v\n", report)
+	if p.Usage != nil {
+		printUsage(p.Usage.Report())
+	}
+	if err != nil {
+		return err
+	}
+	if len(report.Failed) > 0 {
+		return fmt.Errorf("%d documents could not be ingested", len(report.Failed))
+	}
+	return nil
+}
+
+// runSync ingests the SYNC_SOURCES of cfg, printing the outcome of every
+// document to w, and appends the change report to SYNC_REPORT. The report
+// is returned even if it could not be appended.
+func runSync(ctx context.Context, p *rag.Pipeline, cfg rag.Config, w io.Writer) (*changeReport, error) {
+	var sources []string
+	for s := range strings.SplitSeq(cfg.SyncSources, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			sources = append(sources, s)
+		}
+	}
+	if len(sources) == 0 {
+		return nil, errors.New("no sources to sync; set SYNC_SOURCES")
+	}
+	progress, err := loadIngestProgress(cfg.SyncState)
+	if err != nil {
+		return nil, err
+	}
+	report, err := ingestSources(ctx, p, sources, ingestOptions{crawler: newCrawler(), prune: true, progress: progress, out: w})
+	if err != nil {
+		return nil, err
+	}
+	if cfg.SyncReport != "" {
+		if err := report.append(cfg.SyncReport); err != nil {
+			return report, fmt.Errorf("writing sync report: %w", err)
+		}
+	}
+	return report, nil
+}
+
+// syncOnSchedule runs runSync whenever schedule is due, until ctx is done,
+// and logs the summary of every change report.
+func syncOnSchedule(ctx context.Context, p *rag.Pipeline, cfg rag.Config, schedule rag.Schedule) {
+	for {
+		next := schedule.Next(time.Now())
+		if next.IsZero() {
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+		report, err := runSync(ctx, p, cfg, io.Discard)
+		if ctx.Err() != nil {
+			return
+		}
+		if report != nil {
+			log.Printf("Sync: %v", report)
+		}
+		if err != nil {
+			log.Printf("Sync failed: %v", err)
+		}
+	}
+}
+
+// changeReport lists what an ingestion changed in the namespace it ran in:
+// the documents it added, updated and removed, how many it found unchanged
+// and the documents it failed to ingest.
+type changeReport struct {
+	Time      time.Time `json:"time"`
+	Namespace string    `json:"namespace"`
+	Added     []string  `json:"added"`
+	Updated   []string  `json:"updated"`
+	Removed   []string  `json:"removed"`
+	Unchanged int       `json:"unchanged"`
+	Failed    []string  `json:"failed"`
+
+	stored map[string]int // chunks of the documents stored before
+}
+
+// newChangeReport starts a report on the namespace of ctx, given the
+// documents stored in it beforehand.
+func newChangeReport(ctx context.Context, stored []rag.DocumentInfo) *changeReport {
+	r := &changeReport{
+		Time:      time.Now().UTC(),
+		Namespace: rag.NamespaceFrom(ctx),
+		Added:     []string{},
+		Updated:   []string{},
+		Removed:   []string{},
+		Failed:    []string{},
+		stored:    make(map[string]int, len(stored)),
+	}
+	for _, d := range stored {
+		r.stored[d.ID] = d.Chunks
+	}
+	return r
+}
+
+// add tallies the results of an ingestion. A document that was stored
+// before counts as updated if chunks of it were embedded again, added or
+// deleted.
+func (r *changeReport) add(results []rag.IngestResult) {
+	for _, res := range results {
+		chunks, stored := r.stored[res.ID]
+		switch {
+		case res.Error != "":
+			r.Failed = append(r.Failed, res.ID)
+		case !stored:
+			r.Added = append(r.Added, res.ID)
+		case res.Embedded > 0 || res.Chunks != chunks:
+			r.Updated = append(r.Updated, res.ID)
+		default:
+			r.Unchanged++
+		}
+	}
+}
+
+func (r *changeReport) String() string {
+	return fmt.Sprintf("%d added, %d updated, %d removed, %d unchanged, %d failed",
+		len(r.Added), len(r.Updated), len(r.Removed), r.Unchanged, len(r.Failed))
+}
+
+// append appends the report to the file at path as a line of JSON.
+func (r *changeReport) append(path string) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,168 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ACLKey is the metadata key holding the access control list of a
+// document: the comma-separated principals allowed to retrieve it, such as
+// "alice, group:engineering". Documents without it can be retrieved by
+// everyone; an empty list allows no one.
+const ACLKey = "acl"
+
+// PrincipalsHeader is the HTTP header, and gRPC metadata key, from which the
+// servers take the principals of a query. The servers trust it as given, so
+// they should sit behind a proxy that authenticates callers and sets it.
+const PrincipalsHeader = "X-Principals"
+
+type principalsKey struct{}
+
+// WithPrincipals returns a context that makes the pipeline retrieve only
+// chunks of documents whose ACL names one of the given principals, a user
+// and the groups they belong to, or that have no ACL. Without principals
+// only documents without an ACL are retrieved.
+func WithPrincipals(ctx context.Context, principals ...string) context.Context {
+	return context.WithValue(ctx, principalsKey{}, principals)
+}
+
+// PrincipalsFrom returns the principals set on ctx by WithPrincipals.
+func PrincipalsFrom(ctx context.Context) []string {
+	principals, _ := ctx.Value(principalsKey{}).([]string)
+	return principals
+}
+
+// ParsePrincipals splits a comma-separated list of principals, as found in
+// ACL metadata and PrincipalsHeader, dropping empty entries.
+func ParsePrincipals(list string) []string {
+	var principals []string
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			principals = append(principals, p)
+		}
+	}
+	return principals
+}
+
+// allowed reports whether a chunk with the given metadata may be retrieved
+// by callers with the given principals.
+func allowed(metadata Metadata, principals []string) bool {
+	acl, ok := metadata[ACLKey]
+	if !ok {
+		return true
+	}
+	for _, p := range ParsePrincipals(acl) {
+		if slices.Contains(principals, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// ACLRetriever drops the chunks the principals of the context may not see,
+// see WithPrincipals, and the chunks of expired documents, see ExpiresKey,
+// from the results of Retriever. It wraps the retrievers that search the
+// store, so that no other stage, from the reranker to the prompt, gets to
+// see a forbidden chunk. Since the filtering happens after the search,
+// Retriever is asked for Candidates results, 4*k by default, and fewer
+// than k may be left when most of the best matches are forbidden or
+// expired.
+type ACLRetriever struct {
+	Retriever  Retriever
+	Candidates int
+}
+
+func (r *ACLRetriever) Retrieve(ctx context.Context, query string, k int, filter Filter) ([]SearchResult, error) {
+	candidates := r.Candidates
+	if candidates <= 0 {
+		candidates = 4 * k
+	}
+	results, err := r.Retriever.Retrieve(ctx, query, candidates, filter)
+	if err != nil {
+		return nil, err
+	}
+	principals := PrincipalsFrom(ctx)
+	now := time.Now()
+	visible := results[:0]
+	for _, res := range results {
+		if allowed(res.Metadata, principals) && !expired(res.Metadata, now) {
+			visible = append(visible, res)
+		}
+	}
+	if len(visible) > k {
+		visible = visible[:k]
+	}
+	return visible, nil
+}
+
+// ErrForbidden is returned for a request to replace a document that the
+// principals of the caller may not see.
+var ErrForbidden = errors.New("forbidden")
+
+// Documents lists the documents of the namespace of ctx that the principals
+// of ctx may see, see WithPrincipals, with the number of their chunks they
+// may see, leaving out the chunks of expired documents, see ExpiresKey. The
+// store must be a MetadataStore or a ChunkStore, to read the ACLs of the
+// chunks.
+func (p *Pipeline) Documents(ctx context.Context) ([]DocumentInfo, error) {
+	docs, err := p.Store.Documents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := p.chunkMetadata(ctx, ACLKey, ExpiresKey)
+	if err != nil {
+		return nil, err
+	}
+	principals, now := PrincipalsFrom(ctx), time.Now()
+	hidden := make(map[string]int)
+	for _, c := range chunks {
+		if !allowed(c.Metadata, principals) || expired(c.Metadata, now) {
+			hidden[c.DocID]++
+		}
+	}
+	visible := docs[:0]
+	for _, d := range docs {
+		if d.Chunks -= hidden[d.ID]; d.Chunks > 0 {
+			visible = append(visible, d)
+		}
+	}
+	return visible, nil
+}
+
+// checkAllowed returns an error of kind kind for the first of the documents
+// of the namespace of ctx named by ids with a chunk the principals of ctx
+// may not see, so that they can neither delete it nor replace it and its
+// ACL. Documents that are not stored pass.
+func (p *Pipeline) checkAllowed(ctx context.Context, kind error, ids ...string) error {
+	principals := PrincipalsFrom(ctx)
+	var chunks []Chunk
+	if s, ok := p.Store.(ChunkStore); ok {
+		for _, id := range ids {
+			docChunks, err := s.Chunks(ctx, id)
+			if err != nil {
+				return err
+			}
+			chunks = append(chunks, docChunks...)
+		}
+	} else {
+		all, err := p.chunkMetadata(ctx, ACLKey)
+		if err != nil {
+			return err
+		}
+		for _, c := range all {
+			if slices.Contains(ids, c.DocID) {
+				chunks = append(chunks, c)
+			}
+		}
+	}
+	for _, c := range chunks {
+		if !allowed(c.Metadata, principals) {
+			return fmt.Errorf("%w: %s", kind, c.DocID)
+		}
+	}
+	return nil
+}
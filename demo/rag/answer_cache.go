@@ -0,0 +1,244 @@
+package rag
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultAnswerCacheTTL is how long AnswerCache serves an answer, and
+// DefaultAnswerSimilarity how similar a question must be to a cached one.
+const (
+	DefaultAnswerCacheTTL   = 24 * time.Hour
+	DefaultAnswerSimilarity = 0.95
+)
+
+var answerCacheMigrations = []string{
+	`CREATE TABLE answers (
+		id         INTEGER PRIMARY KEY,
+		scope      TEXT    NOT NULL,
+		sources    TEXT    NOT NULL,
+		embedding  BLOB    NOT NULL,
+		answer     TEXT    NOT NULL,
+		created_at INTEGER NOT NULL
+	)`,
+	`CREATE INDEX answers_lookup ON answers (scope, sources)`,
+	`CREATE TABLE answer_documents (
+		answer_id INTEGER NOT NULL,
+		namespace TEXT    NOT NULL,
+		doc_id    TEXT    NOT NULL
+	)`,
+	`CREATE INDEX answer_documents_doc ON answer_documents (namespace, doc_id)`,
+}
+
+// AnswerCache persists answers in a SQLite file so that a question asked
+// again, in the same or nearly the same words, is answered without calling
+// the LLM. Retrieval still runs for every question: a cached answer is only
+// served if the question's embedding has a cosine similarity of at least
+// Similarity with that of the cached question and exactly the same chunks
+// were retrieved for both, so answers over a changed corpus are generated
+// afresh. Answers are also only shared between requests with the same
+// namespace, principals and settings, and they expire after TTL, or never
+// if it is zero. Ingesting or deleting a document invalidates the answers
+// drawn from it.
+type AnswerCache struct {
+	TTL        time.Duration
+	Similarity float64
+
+	db *sql.DB
+}
+
+// NewAnswerCache opens or creates the cache file at path, creating its
+// directory if needed.
+func NewAnswerCache(ctx context.Context, path string, ttl time.Duration, similarity float64) (*AnswerCache, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("answer cache: %w", err)
+	}
+	db, err := openSQLite(ctx, path, answerCacheMigrations)
+	if err != nil {
+		return nil, fmt.Errorf("answer cache: %w", err)
+	}
+	return &AnswerCache{TTL: ttl, Similarity: similarity, db: db}, nil
+}
+
+// Close closes the cache file.
+func (c *AnswerCache) Close() error {
+	return c.db.Close()
+}
+
+// answerKey identifies the answers a query may be served from.
+type answerKey struct {
+	scope     string
+	sources   string
+	embedding []float32
+	docs      []string
+}
+
+// get returns the cached answer of the most similar question with the same
+// scope and sources, or nil if there is none.
+func (c *AnswerCache) get(ctx context.Context, key *answerKey) (*Answer, error) {
+	query := `SELECT embedding, answer FROM answers WHERE scope = ? AND sources = ?`
+	args := []any{key.scope, key.sources}
+	if c.TTL > 0 {
+		query += ` AND created_at > ?`
+		args = append(args, time.Now().Add(-c.TTL).UnixMilli())
+	}
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var best []byte
+	bestScore := float32(c.Similarity)
+	for rows.Next() {
+		var embedding, answer []byte
+		if err := rows.Scan(&embedding, &answer); err != nil {
+			return nil, err
+		}
+		if score := similarity(MetricCosine, key.embedding, decodeVector(embedding)); score >= bestScore {
+			best, bestScore = answer, score
+		}
+	}
+	if err := rows.Err(); err != nil || best == nil {
+		return nil, err
+	}
+	var answer Answer
+	if err := json.Unmarshal(best, &answer); err != nil {
+		return nil, err
+	}
+	answer.Cached = true
+	return &answer, nil
+}
+
+// put stores an answer under key, dropping expired answers.
+func (c *AnswerCache) put(ctx context.Context, key *answerKey, answer *Answer) error {
+	stored := *answer
+	stored.Usage = nil
+	data, err := json.Marshal(&stored)
+	if err != nil {
+		return err
+	}
+	ns := NamespaceFrom(ctx)
+	return sqliteTx(ctx, c.db, func(tx *sql.Tx) error {
+		now := time.Now()
+		if c.TTL > 0 {
+			if err := deleteAnswers(ctx, tx, `created_at <= ?`, now.Add(-c.TTL).UnixMilli()); err != nil {
+				return err
+			}
+		}
+		res, err := tx.ExecContext(ctx, `INSERT INTO answers (scope, sources, embedding, answer, created_at) VALUES (?, ?, ?, ?, ?)`,
+			key.scope, key.sources, encodeVector(key.embedding), data, now.UnixMilli())
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		for _, doc := range key.docs {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO answer_documents (answer_id, namespace, doc_id) VALUES (?, ?, ?)`, id, ns, doc); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// invalidate drops the cached answers drawn from a document of the
+// namespace of ctx.
+func (c *AnswerCache) invalidate(ctx context.Context, docID string) error {
+	return sqliteTx(ctx, c.db, func(tx *sql.Tx) error {
+		return deleteAnswers(ctx, tx, `id IN (SELECT answer_id FROM answer_documents WHERE namespace = ? AND doc_id = ?)`, NamespaceFrom(ctx), docID)
+	})
+}
+
+// invalidateNamespace drops the cached answers drawn from a namespace.
+func (c *AnswerCache) invalidateNamespace(ctx context.Context, name string) error {
+	return sqliteTx(ctx, c.db, func(tx *sql.Tx) error {
+		return deleteAnswers(ctx, tx, `id IN (SELECT answer_id FROM answer_documents WHERE namespace = ?)`, name)
+	})
+}
+
+// deleteAnswers deletes the answers matching a condition on the answers
+// table, and then the documents of answers that are gone.
+func deleteAnswers(ctx context.Context, tx *sql.Tx, cond string, args ...any) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM answers WHERE `+cond, args...); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `DELETE FROM answer_documents WHERE answer_id NOT IN (SELECT id FROM answers)`)
+	return err
+}
+
+// cachedAnswer looks up the answer to req in the pipeline's AnswerCache,
+// given the sources retrieved for it. It returns the key to cache the
+// answer under if none is found, and neither for queries that cannot be
+// cached: questions within a session depend on the conversation.
+func (p *Pipeline) cachedAnswer(ctx context.Context, req QueryRequest, sources []SearchResult) (_ *Answer, _ *answerKey, err error) {
+	if p.Answers == nil || req.SessionID != "" {
+		return nil, nil, nil
+	}
+	ctx, span := tracer.Start(ctx, "rag.answer_cache")
+	defer func() { endSpan(span, err) }()
+	embedding, err := embedQuery(ctx, p.Embedder, req.Question)
+	if err != nil {
+		return nil, nil, fmt.Errorf("embedding question: %w", err)
+	}
+	key := &answerKey{scope: answerScope(ctx, req), embedding: embedding}
+	h := sha256.New()
+	for _, s := range sources {
+		// Length prefixes keep the encoding unambiguous
+		fmt.Fprintf(h, "%d:%s%d:%s", len(s.ID), s.ID, len(s.Text), s.Text)
+		if !slices.Contains(key.docs, s.DocID) {
+			key.docs = append(key.docs, s.DocID)
+		}
+	}
+	key.sources = hex.EncodeToString(h.Sum(nil))
+	answer, err := p.Answers.get(ctx, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("answer cache: %w", err)
+	}
+	span.SetAttributes(attribute.Bool("rag.cached", answer != nil))
+	if answer != nil {
+		return answer, nil, nil
+	}
+	return nil, key, nil
+}
+
+// cacheAnswer stores an answer under a key returned by cachedAnswer. The
+// answer is good even if it cannot be cached, so errors are only recorded
+// on the query's span.
+func (p *Pipeline) cacheAnswer(ctx context.Context, key *answerKey, answer *Answer) {
+	// Answers missing a stage that timed out are not kept
+	if key == nil || timedOutAny(ctx) {
+		return
+	}
+	if err := p.Answers.put(ctx, key, answer); err != nil {
+		trace.SpanFromContext(ctx).RecordError(fmt.Errorf("answer cache: %w", err))
+	}
+}
+
+// answerScope hashes what besides the question and its sources determines
+// an answer: the namespace, the principals and the settings of the
+// request, the model its route generates with included.
+func answerScope(ctx context.Context, req QueryRequest) string {
+	principals := slices.Sorted(slices.Values(PrincipalsFrom(ctx)))
+	req.Question = ""
+	data, _ := json.Marshal(struct {
+		Namespace  string       `json:"namespace"`
+		Principals []string     `json:"principals"`
+		Request    QueryRequest `json:"request"`
+		Model      string       `json:"model,omitempty"` // of the route
+	}{NamespaceFrom(ctx), principals, req, req.Model})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
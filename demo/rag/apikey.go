@@ -0,0 +1,401 @@
+package rag
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// apiKeyPrefix starts every API key, so that leaked keys are easy to spot.
+const apiKeyPrefix = "rag_"
+
+// quotaMonthFormat is how the month whose usage a quota limits is stored.
+const quotaMonthFormat = "2006-01"
+
+var (
+	// ErrAPIKeyNotFound is returned for an API key ID or name that is not
+	// in an APIKeyStore.
+	ErrAPIKeyNotFound = errors.New("API key not found")
+	// ErrUnauthenticated is returned for a request without a valid API key.
+	ErrUnauthenticated = errors.New("missing or invalid API key")
+	// ErrRateLimited is returned for a request above its API key's rate
+	// limit.
+	ErrRateLimited = errors.New("API key rate limit exceeded")
+	// ErrQuotaExceeded is returned for a request by an API key that has
+	// used up its monthly token quota.
+	ErrQuotaExceeded = errors.New("API key quota exceeded")
+)
+
+var apiKeyMigrations = []string{
+	`CREATE TABLE api_keys (
+		id         TEXT PRIMARY KEY,
+		name       TEXT NOT NULL UNIQUE,
+		hash       TEXT NOT NULL,
+		principals TEXT NOT NULL DEFAULT '[]',
+		rate_limit REAL NOT NULL DEFAULT 0,
+		quota      INTEGER NOT NULL DEFAULT 0,
+		created_at TEXT NOT NULL,
+		last_used  TEXT NOT NULL DEFAULT ''
+	) WITHOUT ROWID`,
+	`CREATE TABLE api_key_usage (
+		key_id   TEXT NOT NULL,
+		month    TEXT NOT NULL,
+		requests INTEGER NOT NULL DEFAULT 0,
+		tokens   INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (key_id, month)
+	) WITHOUT ROWID`,
+}
+
+// An APIKey lets a client call the server. Requests made with a key that
+// has Principals query as them, whatever the PrincipalsHeader says; keys
+// without principals are trusted to name the caller in the header, as a
+// backend authenticating its own users would. RateLimit bounds the
+// requests made with the key per minute and Quota the tokens their
+// providers report per calendar month, in UTC; both are unlimited if zero.
+// Requests and Tokens count the usage of the current month.
+type APIKey struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Principals []string  `json:"principals"`
+	RateLimit  float64   `json:"rate_limit,omitempty"`
+	Quota      int       `json:"quota,omitempty"`
+	Requests   int       `json:"requests"`
+	Tokens     int       `json:"tokens"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsed   time.Time `json:"last_used,omitzero"`
+}
+
+// An APIKeyStore keeps the API keys of a server and their usage in a
+// SQLite file. Only the SHA-256 hashes of keys are stored, which suffices
+// for keys as random as those Create makes; a key is shown once, when it is
+// created, and cannot be recovered from the file. Rate limits are kept per
+// process, while quotas count the usage of every process sharing the file.
+type APIKeyStore struct {
+	db *sql.DB
+
+	mu      sync.Mutex
+	buckets map[string]*keyBucket
+}
+
+// keyBucket is the token bucket enforcing the rate limit of a key.
+type keyBucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// OpenAPIKeyStore opens or creates the key file at path, creating its
+// directory if needed.
+func OpenAPIKeyStore(ctx context.Context, path string) (*APIKeyStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("API keys: %w", err)
+	}
+	db, err := openSQLite(ctx, path, apiKeyMigrations)
+	if err != nil {
+		return nil, fmt.Errorf("API keys: %w", err)
+	}
+	return &APIKeyStore{db: db, buckets: make(map[string]*keyBucket)}, nil
+}
+
+// Close closes the key file.
+func (s *APIKeyStore) Close() error {
+	return s.db.Close()
+}
+
+// Create adds a key with the name, principals, rate limit and quota of key,
+// returning it with its ID set and the secret the client sends.
+func (s *APIKeyStore) Create(ctx context.Context, key APIKey) (*APIKey, string, error) {
+	if key.Name == "" {
+		return nil, "", errors.New("API key needs a name")
+	}
+	if key.RateLimit < 0 || key.Quota < 0 {
+		return nil, "", errors.New("API key rate limit and quota must not be negative")
+	}
+	key.ID = strings.ToLower(rand.Text()[:8])
+	key.Principals = append([]string{}, key.Principals...)
+	key.CreatedAt = time.Now().UTC()
+	secret := apiKeyPrefix + key.ID + "_" + strings.ToLower(rand.Text())
+	principals, _ := json.Marshal(key.Principals)
+	_, err := s.db.ExecContext(ctx, `INSERT INTO api_keys (id, name, hash, principals, rate_limit, quota, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, key.ID, key.Name, hashAPIKey(secret), principals, key.RateLimit, key.Quota, formatJobTime(key.CreatedAt))
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return nil, "", fmt.Errorf("an API key named %q exists already", key.Name)
+		}
+		return nil, "", fmt.Errorf("creating API key: %w", err)
+	}
+	return &key, secret, nil
+}
+
+// Keys lists the keys by name, with their usage this month.
+func (s *APIKeyStore) Keys(ctx context.Context) ([]APIKey, error) {
+	return s.query(ctx, `ORDER BY k.name`)
+}
+
+// Revoke deletes the key with the given ID or name, together with its
+// usage; requests made with it fail from then on.
+func (s *APIKeyStore) Revoke(ctx context.Context, idOrName string) error {
+	return sqliteTx(ctx, s.db, func(tx *sql.Tx) error {
+		var id string
+		err := tx.QueryRowContext(ctx, `SELECT id FROM api_keys WHERE id = ? OR name = ?`, idOrName, idOrName).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %s", ErrAPIKeyNotFound, idOrName)
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM api_keys WHERE id = ?`, id); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM api_key_usage WHERE key_id = ?`, id)
+		return err
+	})
+}
+
+// Authenticate returns the key whose secret is given, or
+// ErrUnauthenticated. It does not check the key's rate limit or quota.
+func (s *APIKeyStore) Authenticate(ctx context.Context, secret string) (*APIKey, error) {
+	rest, ok := strings.CutPrefix(secret, apiKeyPrefix)
+	id, _, ok2 := strings.Cut(rest, "_")
+	if !ok || !ok2 {
+		return nil, ErrUnauthenticated
+	}
+	var hash string
+	err := s.db.QueryRowContext(ctx, `SELECT hash FROM api_keys WHERE id = ?`, id).Scan(&hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUnauthenticated
+	}
+	if err != nil {
+		return nil, fmt.Errorf("API keys: %w", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(hashAPIKey(secret))) != 1 {
+		return nil, ErrUnauthenticated
+	}
+	keys, err := s.query(ctx, `WHERE k.id = ?`, id)
+	if err != nil {
+		return nil, fmt.Errorf("API keys: %w", err)
+	}
+	if len(keys) == 0 {
+		return nil, ErrUnauthenticated
+	}
+	return &keys[0], nil
+}
+
+// admit authenticates a request made with secret and checks the key's
+// rate limit and quota, returning the key, or how long to wait before
+// retrying with an error wrapping ErrRateLimited.
+func (s *APIKeyStore) admit(ctx context.Context, secret string) (*APIKey, time.Duration, error) {
+	key, err := s.Authenticate(ctx, secret)
+	if err != nil {
+		return nil, 0, err
+	}
+	if key.Quota > 0 && key.Tokens >= key.Quota {
+		return nil, 0, fmt.Errorf("%w: %s used %d of %d tokens this month", ErrQuotaExceeded, key.Name, key.Tokens, key.Quota)
+	}
+	if wait := s.take(key); wait > 0 {
+		return nil, wait, fmt.Errorf("%w: %s allows %g requests per minute", ErrRateLimited, key.Name, key.RateLimit)
+	}
+	return key, 0, nil
+}
+
+// take takes a request from the token bucket of key, which holds up to a
+// minute's worth of requests, returning 0 or, if the bucket is empty, how
+// long until it holds a request again.
+func (s *APIKeyStore) take(key *APIKey) time.Duration {
+	if key.RateLimit <= 0 {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	b, ok := s.buckets[key.ID]
+	if !ok {
+		b = &keyBucket{tokens: key.RateLimit, at: now}
+		s.buckets[key.ID] = b
+	}
+	perSecond := key.RateLimit / 60
+	b.tokens = min(key.RateLimit, b.tokens+now.Sub(b.at).Seconds()*perSecond)
+	b.at = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+	}
+	b.tokens--
+	return 0
+}
+
+// record adds a request and the tokens it used to the usage of the key
+// with the given ID.
+func (s *APIKeyStore) record(ctx context.Context, id string, tokens int) error {
+	now := time.Now().UTC()
+	return sqliteTx(ctx, s.db, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO api_key_usage (key_id, month, requests, tokens) VALUES (?, ?, 1, ?)
+			ON CONFLICT (key_id, month) DO UPDATE SET requests = requests + 1, tokens = tokens + excluded.tokens`,
+			id, now.Format(quotaMonthFormat), tokens)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE api_keys SET last_used = ? WHERE id = ?`, formatJobTime(now), id)
+		return err
+	})
+}
+
+// metered returns a context counting the tokens used by a request made
+// with key and a function adding the request to the key's usage once it is
+// done.
+func (s *APIKeyStore) metered(ctx context.Context, key *APIKey) (context.Context, func()) {
+	var tokens atomic.Int64
+	ctx = WithUsageFunc(ctx, func(u Usage) {
+		tokens.Add(int64(u.PromptTokens + u.CompletionTokens + u.EmbeddingTokens))
+	})
+	return ctx, func() {
+		// The request may have been canceled, but its usage still counts
+		if err := s.record(context.WithoutCancel(ctx), key.ID, int(tokens.Load())); err != nil {
+			trace.SpanFromContext(ctx).RecordError(fmt.Errorf("recording usage of API key %s: %w", key.Name, err))
+		}
+	}
+}
+
+func (s *APIKeyStore) query(ctx context.Context, clause string, args ...any) ([]APIKey, error) {
+	args = append([]any{time.Now().UTC().Format(quotaMonthFormat)}, args...)
+	rows, err := s.db.QueryContext(ctx, `SELECT k.id, k.name, k.principals, k.rate_limit, k.quota, k.created_at, k.last_used,
+			COALESCE(u.requests, 0), COALESCE(u.tokens, 0)
+		FROM api_keys k LEFT JOIN api_key_usage u ON u.key_id = k.id AND u.month = ? `+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := []APIKey{}
+	for rows.Next() {
+		var key APIKey
+		var principals, created, lastUsed string
+		if err := rows.Scan(&key.ID, &key.Name, &principals, &key.RateLimit, &key.Quota, &created, &lastUsed, &key.Requests, &key.Tokens); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(principals), &key.Principals); err != nil {
+			return nil, fmt.Errorf("API key %s: %w", key.ID, err)
+		}
+		key.CreatedAt, _ = time.Parse(jobTimeFormat, created)
+		key.LastUsed, _ = time.Parse(jobTimeFormat, lastUsed)
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(header string) string {
+	scheme, token, _ := strings.Cut(header, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// RequireAPIKey serves h only to requests carrying a key of keys in an
+// "Authorization: Bearer" header, within its rate limit and quota, and
+// counts the tokens used for each request against the key's quota. The
+// Prometheus metrics, the health probes and the admin UI's page are served
+// without a key, so that the UI can ask for one. Requests without a valid
+// key get 401 Unauthorized, and those above their rate limit or quota 429
+// Too Many Requests. Tokens used by ingestion jobs running in the
+// background are not counted.
+func RequireAPIKey(h http.Handler, keys *APIKeyStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && (r.URL.Path == "/" || r.URL.Path == "/metrics" || r.URL.Path == "/healthz" || r.URL.Path == "/readyz" || strings.HasPrefix(r.URL.Path, "/ui/")) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		key, wait, err := keys.admit(r.Context(), bearerToken(r.Header.Get("Authorization")))
+		switch {
+		case errors.Is(err, ErrUnauthenticated):
+			w.Header().Set("WWW-Authenticate", `Bearer realm="rag"`)
+			writeError(w, err)
+			return
+		case errors.Is(err, ErrRateLimited):
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, err)
+			return
+		case err != nil:
+			writeError(w, err)
+			return
+		}
+		if len(key.Principals) > 0 {
+			r.Header.Set(PrincipalsHeader, strings.Join(key.Principals, ","))
+		}
+		ctx, done := keys.metered(r.Context(), key)
+		defer done()
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// GRPCAPIKeyOptions returns the options making a gRPC server require a key
+// of keys, sent under the "authorization" metadata key as "Bearer <key>",
+// for every call, as RequireAPIKey does for HTTP. Calls without a valid key
+// fail with Unauthenticated, and those above their rate limit or quota
+// with ResourceExhausted.
+func GRPCAPIKeyOptions(keys *APIKeyStore) []grpc.ServerOption {
+	// admit authenticates a call, returning its context and a function
+	// recording its usage once it is done
+	admit := func(ctx context.Context) (context.Context, func(), error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		key, _, err := keys.admit(ctx, bearerToken(strings.Join(md.Get("authorization"), "")))
+		if err != nil {
+			return nil, nil, grpcError(err)
+		}
+		if len(key.Principals) > 0 {
+			md = md.Copy()
+			md.Set(PrincipalsHeader, strings.Join(key.Principals, ","))
+			ctx = metadata.NewIncomingContext(ctx, md)
+		}
+		ctx, done := keys.metered(ctx, key)
+		return ctx, done, nil
+	}
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			ctx, done, err := admit(ctx)
+			if err != nil {
+				return nil, err
+			}
+			defer done()
+			return handler(ctx, req)
+		}),
+		grpc.ChainStreamInterceptor(func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			ctx, done, err := admit(ss.Context())
+			if err != nil {
+				return err
+			}
+			defer done()
+			return handler(srv, &keyedStream{ss, ctx})
+		}),
+	}
+}
+
+// keyedStream is a server stream with the context of its API key.
+type keyedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *keyedStream) Context() context.Context { return s.ctx }
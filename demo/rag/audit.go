@@ -0,0 +1,160 @@
+package rag
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// AuditRecord is the record of a query in an AuditLog. Caller holds the
+// principals the query was made as, see WithPrincipals, and Chunks the IDs
+// of the chunks the answer was generated from, in the order of its sources.
+// AnswerID is the ID of the answer, by which feedback on it refers to it.
+// Usage tells the models that were called and the tokens they used, and
+// Error why the query failed, in which case there is no answer.
+type AuditRecord struct {
+	Time      time.Time    `json:"time"`
+	TraceID   string       `json:"trace_id,omitempty"`
+	Namespace string       `json:"namespace"`
+	Caller    []string     `json:"caller"`
+	SessionID string       `json:"session_id,omitempty"`
+	Question  string       `json:"question"`
+	Filter    string       `json:"filter,omitempty"`
+	Chunks    []string     `json:"chunks"`
+	AnswerID  string       `json:"answer_id,omitempty"`
+	Answer    string       `json:"answer,omitempty"`
+	Cached    bool         `json:"cached,omitempty"`
+	NoContext bool         `json:"no_context,omitempty"`
+	Usage     *UsageReport `json:"usage,omitempty"`
+	Provider  string       `json:"provider,omitempty"`
+	Error     string       `json:"error,omitempty"`
+}
+
+// An AuditLog appends a record of every query a Pipeline answers, or fails
+// to, to a file of JSON Lines at Path, for compliance reviews. Records are
+// only ever added, each with a single write, so several processes may
+// append to the same file. The file is created readable by its owner only,
+// since it holds every question and answer.
+type AuditLog struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// Record appends rec to the log.
+func (l *AuditLog) Record(rec AuditRecord) error {
+	return appendJSONLine(&l.mu, l.Path, rec)
+}
+
+// appendJSONLine appends the JSON encoding of v as a line to the file at
+// path in a single write, holding mu, creating the file readable by its
+// owner only.
+func appendJSONLine(mu *sync.Mutex, path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadAuditLog calls fn with every record of an audit log read from r, in
+// the order they were appended, until fn returns an error.
+func ReadAuditLog(r io.Reader, fn func(AuditRecord) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var rec AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// AuditFilter selects records of an audit log. Records match if they were
+// made at or after Since and before Until, by a caller with the principal
+// Caller, in Namespace and in the session SessionID, and their question or
+// answer contains Text, ignoring case. Empty fields match every record.
+type AuditFilter struct {
+	Since, Until time.Time
+	Caller       string
+	Namespace    string
+	SessionID    string
+	Text         string
+}
+
+// Match reports whether rec matches f.
+func (f AuditFilter) Match(rec AuditRecord) bool {
+	switch {
+	case !f.Since.IsZero() && rec.Time.Before(f.Since),
+		!f.Until.IsZero() && !rec.Time.Before(f.Until),
+		f.Caller != "" && !slices.Contains(rec.Caller, f.Caller),
+		f.Namespace != "" && rec.Namespace != f.Namespace,
+		f.SessionID != "" && rec.SessionID != f.SessionID:
+		return false
+	}
+	if f.Text == "" {
+		return true
+	}
+	text := strings.ToLower(f.Text)
+	return strings.Contains(strings.ToLower(rec.Question), text) || strings.Contains(strings.ToLower(rec.Answer), text)
+}
+
+// audit records a query, the chunks retrieved for it and its outcome in the
+// pipeline's AuditLog, if it has one.
+func (p *Pipeline) audit(ctx context.Context, req QueryRequest, sources []SearchResult, answer *Answer, err error) error {
+	if p.Audit == nil {
+		return nil
+	}
+	rec := AuditRecord{
+		Time:      time.Now().UTC(),
+		Namespace: NamespaceFrom(ctx),
+		Caller:    PrincipalsFrom(ctx),
+		SessionID: req.SessionID,
+		Question:  req.Question,
+		Filter:    req.Filter,
+		Chunks:    make([]string, len(sources)),
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		rec.TraceID = sc.TraceID().String()
+	}
+	if rec.Caller == nil {
+		rec.Caller = []string{}
+	}
+	for i, s := range sources {
+		rec.Chunks[i] = s.ID
+	}
+	if answer != nil {
+		rec.AnswerID, rec.Answer, rec.Cached, rec.NoContext, rec.Usage = answer.ID, answer.Answer, answer.Cached, answer.NoContext, answer.Usage
+		rec.Provider = answer.Provider
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	return p.Audit.Record(rec)
+}
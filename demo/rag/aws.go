@@ -0,0 +1,306 @@
+package rag
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AWSCredentials are the keys requests to AWS are signed with. Expires is
+// set for temporary credentials.
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expires         time.Time
+}
+
+// awsKeys returns the AWS keys set in cfg, if any.
+func awsKeys(cfg Config) AWSCredentials {
+	return AWSCredentials{AccessKeyID: cfg.S3AccessKey, SecretAccessKey: cfg.S3SecretKey, SessionToken: cfg.S3SessionToken}
+}
+
+// awsCredentialChain finds the AWS credentials of the environment the way
+// the AWS SDKs do, when no keys are configured: from the profile named by
+// AWS_PROFILE, "default" if unset, of the shared credentials file, then
+// from the credentials endpoint of an ECS task or an EKS pod identity and
+// last from the instance metadata service of EC2. Temporary credentials
+// are kept until shortly before they expire.
+type awsCredentialChain struct {
+	static AWSCredentials
+	client *http.Client // for the credential endpoints
+
+	mu     sync.Mutex
+	cached AWSCredentials
+}
+
+// newAWSCredentialChain returns a chain of the given keys, if set, or of
+// the credentials of the environment.
+func newAWSCredentialChain(keys AWSCredentials) *awsCredentialChain {
+	return &awsCredentialChain{static: keys, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+// Credentials returns the credentials to sign a request with.
+func (c *awsCredentialChain) Credentials(ctx context.Context) (AWSCredentials, error) {
+	if c.static.AccessKeyID != "" {
+		return c.static, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cached.AccessKeyID != "" && (c.cached.Expires.IsZero() || time.Until(c.cached.Expires) > 5*time.Minute) {
+		return c.cached, nil
+	}
+	creds, err := c.find(ctx)
+	if err != nil {
+		return AWSCredentials{}, fmt.Errorf("finding AWS credentials: %w", err)
+	}
+	c.cached = creds
+	return creds, nil
+}
+
+func (c *awsCredentialChain) find(ctx context.Context) (AWSCredentials, error) {
+	if creds, ok, err := sharedAWSCredentials(); err != nil || ok {
+		return creds, err
+	}
+	if full, relative := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"), os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); full != "" || relative != "" {
+		if relative != "" {
+			full = "http://169.254.170.2" + relative
+		}
+		return c.containerCredentials(ctx, full)
+	}
+	creds, err := c.instanceCredentials(ctx)
+	if err != nil {
+		return AWSCredentials{}, fmt.Errorf("no keys, profile or container credentials are set, and the instance metadata service failed: %w", err)
+	}
+	return creds, nil
+}
+
+// sharedAWSCredentials reads the keys of the profile named by AWS_PROFILE
+// from the shared credentials file, ~/.aws/credentials unless
+// AWS_SHARED_CREDENTIALS_FILE names another. It reports false if there is
+// no such file or profile.
+func sharedAWSCredentials() (AWSCredentials, bool, error) {
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return AWSCredentials{}, false, nil
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return AWSCredentials{}, false, nil
+	}
+	if err != nil {
+		return AWSCredentials{}, false, err
+	}
+	defer f.Close()
+	profile := envOr("AWS_PROFILE", "default")
+	var creds AWSCredentials
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || section != profile {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "aws_access_key_id":
+			creds.AccessKeyID = strings.TrimSpace(value)
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = strings.TrimSpace(value)
+		case "aws_session_token":
+			creds.SessionToken = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return AWSCredentials{}, false, fmt.Errorf("reading %s: %w", path, err)
+	}
+	if creds.AccessKeyID == "" {
+		return AWSCredentials{}, false, nil
+	}
+	return creds, true, nil
+}
+
+// envOr returns the value of the environment variable key, or def if it
+// is empty.
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// containerCredentials fetches the credentials of an ECS task or EKS pod
+// from uri, authorized with AWS_CONTAINER_AUTHORIZATION_TOKEN or the token
+// in AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE if one is set.
+func (c *awsCredentialChain) containerCredentials(ctx context.Context, uri string) (AWSCredentials, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return AWSCredentials{}, err
+	}
+	token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if path := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return AWSCredentials{}, err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	return c.fetchCredentials(req)
+}
+
+// imdsURL is the address of the EC2 instance metadata service.
+const imdsURL = "http://169.254.169.254/latest"
+
+// instanceCredentials fetches the credentials of the role of the EC2
+// instance with IMDSv2.
+func (c *awsCredentialChain) instanceCredentials(ctx context.Context) (AWSCredentials, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, imdsURL+"/api/token", nil)
+	if err != nil {
+		return AWSCredentials{}, err
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "21600")
+	token, err := c.read(req)
+	if err != nil {
+		return AWSCredentials{}, err
+	}
+	get := func(path string) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, imdsURL+"/meta-data/iam/security-credentials/"+path, nil)
+		if err == nil {
+			req.Header.Set("X-Aws-Ec2-Metadata-Token", token)
+		}
+		return req, err
+	}
+	req, err = get("")
+	if err != nil {
+		return AWSCredentials{}, err
+	}
+	roles, err := c.read(req)
+	if err != nil {
+		return AWSCredentials{}, err
+	}
+	role, _, _ := strings.Cut(strings.TrimSpace(roles), "\n")
+	if role == "" {
+		return AWSCredentials{}, errors.New("the instance has no IAM role")
+	}
+	if req, err = get(role); err != nil {
+		return AWSCredentials{}, err
+	}
+	return c.fetchCredentials(req)
+}
+
+// read sends req and returns the body of its response, if it succeeded.
+func (c *awsCredentialChain) read(req *http.Request) (string, error) {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s %s: %s", req.Method, req.URL.Redacted(), resp.Status)
+	}
+	return string(data), nil
+}
+
+// fetchCredentials sends req to a credentials endpoint and decodes the
+// temporary credentials it returns.
+func (c *awsCredentialChain) fetchCredentials(req *http.Request) (AWSCredentials, error) {
+	body, err := c.read(req)
+	if err != nil {
+		return AWSCredentials{}, err
+	}
+	var res struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+	if err := json.Unmarshal([]byte(body), &res); err != nil {
+		return AWSCredentials{}, fmt.Errorf("decoding credentials: %w", err)
+	}
+	if res.AccessKeyID == "" {
+		return AWSCredentials{}, errors.New("the credentials endpoint returned no keys")
+	}
+	return AWSCredentials{AccessKeyID: res.AccessKeyID, SecretAccessKey: res.SecretAccessKey, SessionToken: res.Token, Expires: res.Expiration}, nil
+}
+
+// signV4 adds an AWS Signature Version 4 for service in region to req,
+// whose body has the hex-encoded SHA-256 payloadHash.
+func signV4(req *http.Request, creds AWSCredentials, region, service, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	// Services other than S3 sign the path escaped twice
+	path := req.URL.EscapedPath()
+	if service != "s3" {
+		path = s3Escape(path, false)
+	}
+	var canonical strings.Builder
+	fmt.Fprintf(&canonical, "%s\n%s\n%s\n", req.Method, path, req.URL.RawQuery)
+	for _, name := range names {
+		fmt.Fprintf(&canonical, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+	fmt.Fprintf(&canonical, "\n%s\n%s", signedHeaders, payloadHash)
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonical.String()))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
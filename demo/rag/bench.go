@@ -0,0 +1,251 @@
+package rag
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stages a Benchmark reports beyond those of Timeouts: searching the store
+// with the embedding of the question, building the prompt and, for
+// streamed answers, the time until the first delta arrived.
+const (
+	StageSearch     = "search"
+	StagePrompt     = "prompt"
+	StageFirstDelta = "first_delta"
+)
+
+// A Benchmark replays questions against a Pipeline from Concurrency
+// goroutines at once and measures how long answering them takes, in all
+// and in every stage, to size a deployment and find the stages that slow
+// down under load, such as a store contending for a lock. Questions are
+// asked in turn, starting over when they run out, until Requests were
+// asked or, if Duration is set, until it has passed.
+//
+// Stages are timed by middleware registered with the pipeline when Run is
+// first called, which only measures the questions of the Benchmark: each
+// retrieval, reranking, prompt and generation, a stage running inside
+// another counting in both. The retrievers of this package also time
+// embedding the question, StageEmbed, apart from searching the store with
+// it, StageSearch, both counting in their retrieval too, so that a store
+// contending for a lock is told apart from a slow embedding model.
+type Benchmark struct {
+	Pipeline    *Pipeline
+	Concurrency int           // 1 if zero
+	Requests    int           // the number of questions if zero
+	Duration    time.Duration // replay for this long instead of Requests times
+	Stream      bool          // answer with QueryStream, timing StageFirstDelta
+
+	once sync.Once
+}
+
+// StageLatency sums up the durations of a stage in milliseconds: the
+// median, the 95th and 99th percentiles, the mean and the longest.
+type StageLatency struct {
+	Stage string  `json:"stage"`
+	Calls int     `json:"calls"`
+	P50   float64 `json:"p50_ms"`
+	P95   float64 `json:"p95_ms"`
+	P99   float64 `json:"p99_ms"`
+	Mean  float64 `json:"mean_ms"`
+	Max   float64 `json:"max_ms"`
+}
+
+// BenchReport is the outcome of a Benchmark. Queries counts the questions
+// asked and Throughput the answers per second over Seconds. Failed counts
+// the questions that failed, by ErrorCode in Errors, and Cached those
+// answered from the AnswerCache. Stages starts with StageRequest, the
+// whole of answering a question, followed by the other stages timed, in
+// the order they run.
+type BenchReport struct {
+	Concurrency int            `json:"concurrency"`
+	Queries     int            `json:"queries"`
+	Failed      int            `json:"failed"`
+	Cached      int            `json:"cached"`
+	Errors      map[string]int `json:"errors,omitempty"`
+	Seconds     float64        `json:"seconds"`
+	Throughput  float64        `json:"queries_per_second"`
+	Stages      []StageLatency `json:"stages"`
+}
+
+// benchStages are the stages of a BenchReport in their order.
+var benchStages = []string{StageRequest, StageRetrieve, StageEmbed, StageSearch, StageRerank, StagePrompt, StageGenerate, StageFirstDelta}
+
+// A benchRun collects the durations measured by a run of a Benchmark.
+type benchRun struct {
+	bench *Benchmark
+
+	mu        sync.Mutex
+	durations map[string][]time.Duration
+	failed    map[string]int
+	cached    int
+}
+
+type benchRunKey struct{}
+
+func (r *benchRun) record(stage string, d time.Duration) {
+	r.mu.Lock()
+	r.durations[stage] = append(r.durations[stage], d)
+	r.mu.Unlock()
+}
+
+// timed records how long stage took in ctx, if ctx asks a question of the
+// Benchmark b.
+func (b *Benchmark) timed(ctx context.Context, stage string, start time.Time) {
+	if run, ok := ctx.Value(benchRunKey{}).(*benchRun); ok && run.bench == b {
+		run.record(stage, time.Since(start))
+	}
+}
+
+// benchTimed records how long stage took in ctx, if ctx asks a question of
+// a Benchmark, for the stages within retrieval that middleware cannot see.
+func benchTimed(ctx context.Context, stage string, start time.Time) {
+	if run, ok := ctx.Value(benchRunKey{}).(*benchRun); ok {
+		run.record(stage, time.Since(start))
+	}
+}
+
+// middleware times the stages of answering a question.
+func (b *Benchmark) middleware() Middleware {
+	return Middleware{
+		Retrieve: func(next RetrieveFunc) RetrieveFunc {
+			return func(ctx context.Context, query string, k int, filter Filter) ([]SearchResult, error) {
+				defer b.timed(ctx, StageRetrieve, time.Now())
+				return next(ctx, query, k, filter)
+			}
+		},
+		Rerank: func(next RerankFunc) RerankFunc {
+			return func(ctx context.Context, query string, results []SearchResult) ([]SearchResult, error) {
+				defer b.timed(ctx, StageRerank, time.Now())
+				return next(ctx, query, results)
+			}
+		},
+		Prompt: func(next PromptFunc) PromptFunc {
+			return func(ctx context.Context, req PromptRequest) ([]Message, []SearchResult, error) {
+				defer b.timed(ctx, StagePrompt, time.Now())
+				return next(ctx, req)
+			}
+		},
+		Generate: func(next GenerateFunc) GenerateFunc {
+			return func(ctx context.Context, messages []Message, onDelta func(string) error) (string, error) {
+				defer b.timed(ctx, StageGenerate, time.Now())
+				return next(ctx, messages, onDelta)
+			}
+		},
+	}
+}
+
+// Run replays requests and reports how long answering them took. Failed
+// questions are counted and do not stop the run; canceling ctx ends it
+// early, with the questions answered so far.
+func (b *Benchmark) Run(ctx context.Context, requests []QueryRequest) (*BenchReport, error) {
+	if len(requests) == 0 {
+		return nil, invalidRequest("no questions to replay")
+	}
+	b.once.Do(func() { b.Pipeline.Use(b.middleware()) })
+	run := &benchRun{bench: b, durations: make(map[string][]time.Duration), failed: make(map[string]int)}
+	ctx = context.WithValue(ctx, benchRunKey{}, run)
+	concurrency := max(b.Concurrency, 1)
+	total := b.Requests
+	if total <= 0 {
+		total = len(requests)
+	}
+	var deadline time.Time
+	if b.Duration > 0 {
+		deadline = time.Now().Add(b.Duration)
+	}
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	start := time.Now()
+	for range concurrency {
+		wg.Go(func() {
+			for ctx.Err() == nil {
+				i := int(next.Add(1)) - 1
+				if deadline.IsZero() && i >= total || !deadline.IsZero() && time.Now().After(deadline) {
+					return
+				}
+				b.ask(ctx, run, requests[i%len(requests)])
+			}
+		})
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	report := &BenchReport{Concurrency: concurrency, Cached: run.cached, Seconds: elapsed.Seconds()}
+	for code, n := range run.failed {
+		report.Failed += n
+		if report.Errors == nil {
+			report.Errors = make(map[string]int)
+		}
+		report.Errors[code] = n
+	}
+	report.Queries = len(run.durations[StageRequest]) + report.Failed
+	if report.Seconds > 0 {
+		report.Throughput = float64(report.Queries-report.Failed) / report.Seconds
+	}
+	for _, stage := range benchStages {
+		if d := run.durations[stage]; len(d) > 0 {
+			report.Stages = append(report.Stages, latency(stage, d))
+		}
+	}
+	return report, nil
+}
+
+// ask answers req, recording how long it took unless it failed.
+func (b *Benchmark) ask(ctx context.Context, run *benchRun, req QueryRequest) {
+	start := time.Now()
+	var answer *Answer
+	var err error
+	if b.Stream {
+		var first sync.Once
+		answer, err = b.Pipeline.QueryStream(ctx, req, func(string) error {
+			first.Do(func() { run.record(StageFirstDelta, time.Since(start)) })
+			return nil
+		})
+	} else {
+		answer, err = b.Pipeline.Query(ctx, req)
+	}
+	if err != nil {
+		// Questions canceled as the run ends count for nothing
+		if ctx.Err() == nil {
+			run.mu.Lock()
+			run.failed[ErrorCode(err)]++
+			run.mu.Unlock()
+		}
+		return
+	}
+	run.record(StageRequest, time.Since(start))
+	if answer.Cached {
+		run.mu.Lock()
+		run.cached++
+		run.mu.Unlock()
+	}
+}
+
+// latency sums up the durations of stage.
+func latency(stage string, durations []time.Duration) StageLatency {
+	slices.Sort(durations)
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	// The nearest rank: the smallest duration at least p of them do not exceed
+	percentile := func(p float64) float64 {
+		i := int(math.Ceil(p*float64(len(durations)))) - 1
+		return ms(durations[max(i, 0)])
+	}
+	var sum time.Duration
+	for _, d := range durations {
+		sum += d
+	}
+	return StageLatency{
+		Stage: stage,
+		Calls: len(durations),
+		P50:   percentile(0.50),
+		P95:   percentile(0.95),
+		P99:   percentile(0.99),
+		Mean:  ms(sum) / float64(len(durations)),
+		Max:   ms(durations[len(durations)-1]),
+	}
+}
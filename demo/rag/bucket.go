@@ -0,0 +1,279 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxObjectSize bounds how much of an object a Bucket reads.
+const maxObjectSize = 256 << 20
+
+// emptyPayloadHash is the SHA-256 of an empty request body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// A Bucket reads the objects under a prefix of an S3 bucket, or of a Google
+// Cloud Storage bucket through its S3-compatible XML API, as documents.
+// Requests are signed with AWS Signature Version 4 when AccessKey is set,
+// using HMAC keys for Cloud Storage, and sent unsigned otherwise, which is
+// enough for public buckets.
+type Bucket struct {
+	Client       *http.Client // http.DefaultClient if nil
+	Endpoint     string       // e.g. https://s3.eu-west-1.amazonaws.com or http://localhost:9000
+	Region       string
+	Name         string
+	Prefix       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	// PathStyle addresses the bucket in the path, as in
+	// https://endpoint/bucket/key, rather than as a subdomain of the
+	// endpoint, which S3-compatible servers like MinIO need.
+	PathStyle bool
+
+	scheme string // of the bucket's URL; "s3" if empty
+}
+
+// BucketObject describes an object listed by a Bucket.
+type BucketObject struct {
+	Key          string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+}
+
+// OpenBucket returns the Bucket for a URL of the form s3://bucket/prefix or
+// gs://bucket/prefix, with endpoint and credentials taken from cfg.
+func OpenBucket(rawURL string, cfg Config) (*Bucket, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("bucket URL %s names no bucket", rawURL)
+	}
+	b := &Bucket{Name: u.Host, Prefix: strings.TrimPrefix(u.Path, "/"), scheme: u.Scheme}
+	switch u.Scheme {
+	case "s3":
+		b.Region = cmp.Or(cfg.S3Region, "us-east-1")
+		b.Endpoint = "https://s3." + b.Region + ".amazonaws.com"
+		if cfg.S3Endpoint != "" {
+			b.Endpoint, b.PathStyle = cfg.S3Endpoint, true
+		}
+		b.AccessKey, b.SecretKey, b.SessionToken = cfg.S3AccessKey, cfg.S3SecretKey, cfg.S3SessionToken
+	case "gs":
+		b.Endpoint, b.Region, b.PathStyle = "https://storage.googleapis.com", "auto", true
+		b.AccessKey, b.SecretKey = cfg.GCSAccessKey, cfg.GCSSecretKey
+	default:
+		return nil, fmt.Errorf("unsupported bucket URL %s: use s3:// or gs://", rawURL)
+	}
+	b.Endpoint = strings.TrimRight(b.Endpoint, "/")
+	return b, nil
+}
+
+// IsBucketURL reports whether s is a URL OpenBucket accepts.
+func IsBucketURL(s string) bool {
+	return strings.HasPrefix(s, "s3://") || strings.HasPrefix(s, "gs://")
+}
+
+// URL returns the URL of the object with the given key, e.g.
+// s3://bucket/docs/guide.pdf, which is also the ID of its document.
+func (b *Bucket) URL(key string) string {
+	return cmp.Or(b.scheme, "s3") + "://" + b.Name + "/" + key
+}
+
+// List calls fn with every object under the bucket's prefix in key order,
+// skipping the empty objects that stand for folders. It stops at the first
+// error from fn.
+func (b *Bucket) List(ctx context.Context, fn func(BucketObject) error) error {
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}}
+		if b.Prefix != "" {
+			query.Set("prefix", b.Prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := b.do(ctx, "", query)
+		if err != nil {
+			return err
+		}
+		var page struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				ETag         string    `xml:"ETag"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("listing bucket %s: %w", b.Name, err)
+		}
+		for _, o := range page.Contents {
+			if strings.HasSuffix(o.Key, "/") && o.Size == 0 {
+				continue
+			}
+			obj := BucketObject{Key: o.Key, Size: o.Size, ETag: strings.Trim(o.ETag, `"`), LastModified: o.LastModified}
+			if err := fn(obj); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// Load downloads an object and loads it with the Loader for the extension
+// of its key or, failing that, for its Content-Type. The document is
+// identified by the object's URL, which is also its "source" metadata, and
+// records the key in "object_key" and when the object was last modified,
+// in RFC 3339 format, in "last_modified".
+func (b *Bucket) Load(ctx context.Context, obj BucketObject) (*Document, error) {
+	var doc *Document
+	err := b.LoadParts(ctx, obj, 0, 0, func(d *Document) error {
+		doc = d
+		return nil
+	})
+	return doc, err
+}
+
+// LoadParts loads an object as Load does and passes its document to
+// yield, unless the object is larger than partSize bytes and its Loader is
+// a PartLoader: then its parts of partSize bytes are passed to yield one at
+// a time, each with the metadata of the object, as LoadFileParts does for
+// files. A partSize of 0 never loads objects in parts. Objects larger than
+// maxSize bytes, unless it is 0, are rejected with ErrFileTooLarge before
+// they are downloaded.
+func (b *Bucket) LoadParts(ctx context.Context, obj BucketObject, partSize, maxSize int64, yield func(*Document) error) error {
+	id := b.URL(obj.Key)
+	if maxSize > 0 && obj.Size > maxSize {
+		return fmt.Errorf("%w: %s has %d bytes, more than %d", ErrFileTooLarge, id, obj.Size, maxSize)
+	}
+	resp, err := b.do(ctx, obj.Key, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	loader, err := LoaderFor(obj.Key)
+	if err != nil {
+		if loader, err = LoaderForType(resp.Header.Get("Content-Type")); err != nil {
+			return err
+		}
+	}
+	tag := func(doc *Document) error {
+		if doc.Metadata == nil {
+			doc.Metadata = Metadata{}
+		}
+		doc.Metadata["source"] = id
+		doc.Metadata["object_key"] = obj.Key
+		if !obj.LastModified.IsZero() {
+			doc.Metadata["last_modified"] = obj.LastModified.UTC().Format(time.RFC3339)
+		}
+		return yield(doc)
+	}
+	body := io.LimitReader(resp.Body, maxObjectSize)
+	if pl, ok := loader.(PartLoader); ok && partSize > 0 && obj.Size > partSize {
+		// Parts bound the memory used, so the whole object is read
+		return pl.LoadParts(ctx, id, resp.Body, int(partSize), tag)
+	}
+	doc, err := loadDocument(ctx, loader, id, body)
+	if err != nil {
+		return err
+	}
+	return tag(doc)
+}
+
+// do sends a GET request for key, or for the bucket itself if key is empty,
+// and returns the response if it succeeded.
+func (b *Bucket) do(ctx context.Context, key string, query url.Values) (*http.Response, error) {
+	endpoint, err := url.Parse(b.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bucket endpoint: %w", err)
+	}
+	u := *endpoint
+	u.Path = "/" + key
+	if b.PathStyle {
+		u.Path = "/" + b.Name + "/" + key
+	} else {
+		u.Host = b.Name + "." + u.Host
+	}
+	u.RawPath = s3Escape(u.Path, false)
+	u.RawQuery = s3Query(query)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if b.AccessKey != "" {
+		b.sign(req, time.Now())
+	}
+	resp, err := cmp.Or(b.Client, http.DefaultClient).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var res struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if xml.Unmarshal(data, &res) == nil && res.Code != "" {
+			return nil, fmt.Errorf("bucket %s: %s: %s: %s", b.Name, resp.Status, res.Code, res.Message)
+		}
+		return nil, fmt.Errorf("bucket %s: %s", b.Name, resp.Status)
+	}
+	return resp, nil
+}
+
+// sign adds an AWS Signature Version 4 to req, which must be a GET request
+// without a body.
+func (b *Bucket) sign(req *http.Request, now time.Time) {
+	creds := AWSCredentials{AccessKeyID: b.AccessKey, SecretAccessKey: b.SecretKey, SessionToken: b.SessionToken}
+	signV4(req, creds, b.Region, "s3", emptyPayloadHash, now)
+}
+
+// s3Escape percent-encodes s as Signature Version 4 requires, leaving only
+// unreserved characters and, unless slash is set, "/" as they are.
+func s3Escape(s string, slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~", c) >= 0 || c == '/' && !slash {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// s3Query encodes query sorted by key, in the canonical form of Signature
+// Version 4.
+func s3Query(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,61 @@
+package rag
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+)
+
+// Metadata holds string attributes attached to documents and chunks, such as
+// the source file name or page number.
+type Metadata map[string]string
+
+// Chunk is a piece of a document together with its embedding. Hash
+// identifies the chunk's text, metadata and parent, so that re-ingesting an
+// unchanged chunk can reuse its stored embedding; its offsets in the
+// document are left out, so that an edit does not change the chunks after
+// it. ParentID is only set by
+// parent-document chunking, see ChunkWithParents, and Sparse only by
+// pipelines with a SparseEmbedder.
+type Chunk struct {
+	ID        string        `json:"id"`
+	DocID     string        `json:"doc_id"`
+	ParentID  string        `json:"parent_id,omitempty"`
+	Index     int           `json:"index"`
+	Text      string        `json:"text"`
+	Metadata  Metadata      `json:"metadata,omitempty"`
+	Hash      string        `json:"hash,omitempty"`
+	Embedding []float32     `json:"embedding,omitempty"`
+	Sparse    *SparseVector `json:"sparse,omitempty"`
+}
+
+// chunkHash returns the hex SHA-256 of a chunk's text, parent ID and
+// metadata, its CharStartKey and CharEndKey excepted.
+func chunkHash(text, parentID string, metadata Metadata) string {
+	// Length prefixes keep the encoding unambiguous; chunks without a parent
+	// hash as they did before parents existed
+	h := sha256.New()
+	fmt.Fprintf(h, "%d:%s", len(text), text)
+	if parentID != "" {
+		fmt.Fprintf(h, "parent%d:%s", len(parentID), parentID)
+	}
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		if k != CharStartKey && k != CharEndKey {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		fmt.Fprintf(h, "%d:%s%d:%s", len(k), k, len(metadata[k]), metadata[k])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// SearchResult is a chunk returned from a similarity search. Higher scores
+// are more similar.
+type SearchResult struct {
+	Chunk
+	Score float32 `json:"score"`
+}
@@ -0,0 +1,208 @@
+package rag
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SourceRef identifies a chunk that was given to the model as context. The
+// answer cites it as [n], where n is its 1-based position in Answer.Sources.
+// Injection is set if the pipeline's InjectionGuard found a prompt
+// injection in the chunk, to the mode it handled it with. Span holds the
+// offsets of the chunk in the text of its document, if they were stored,
+// see Pipeline.SourceView. Summarized is set if Text is a summary of the
+// chunk, which did not fit in the pipeline's Budget whole.
+type SourceRef struct {
+	DocID      string        `json:"doc_id"`
+	ChunkID    string        `json:"chunk_id"`
+	Chunk      int           `json:"chunk"`
+	Score      float32       `json:"score"`
+	Page       int           `json:"page,omitempty"`
+	URL        string        `json:"url,omitempty"`
+	Text       string        `json:"text"`
+	Cited      bool          `json:"cited"`
+	Injection  InjectionMode `json:"injection,omitempty"`
+	Summarized bool          `json:"summarized,omitempty"`
+	Span       *Span         `json:"span,omitempty"`
+}
+
+// CitationMode selects how the citation markers of answers are checked.
+type CitationMode string
+
+const (
+	// CitationsValidate rewrites markers citing several passages, such as
+	// [1, 2], [1-3] or [Passage 2], as one marker per passage, [1][2], and
+	// removes markers of passages that were not given.
+	CitationsValidate CitationMode = "validate"
+	// CitationsRenumber also numbers the passages in the order the answer
+	// first cites them, reordering its sources to match, so that the first
+	// source cited is [1].
+	CitationsRenumber CitationMode = "renumber"
+)
+
+// citationPattern matches citation markers such as [2].
+var citationPattern = regexp.MustCompile(`\[(\d+)\]`)
+
+// sourceRefs describes the chunks included in the prompt, marking those the
+// answer cites.
+func sourceRefs(answer string, results []SearchResult) []SourceRef {
+	refs := make([]SourceRef, len(results))
+	for i, r := range results {
+		page, _ := strconv.Atoi(r.Metadata["page"])
+		refs[i] = SourceRef{DocID: r.DocID, ChunkID: r.ID, Chunk: r.Index, Score: r.Score, Page: page, URL: r.Metadata["url"], Text: r.Text, Injection: InjectionMode(r.Metadata[injectionKey]), Summarized: r.Metadata[summarizedKey] == "true"}
+		refs[i].Span, _ = chunkSpan(r.Metadata)
+	}
+	for _, m := range citationPattern.FindAllStringSubmatch(answer, -1) {
+		if n, err := strconv.Atoi(m[1]); err == nil && n >= 1 && n <= len(refs) {
+			refs[n-1].Cited = true
+		}
+	}
+	return refs
+}
+
+// citations returns the 1-based positions of the cited refs.
+func citations(refs []SourceRef) []int {
+	cited := []int{}
+	for i, r := range refs {
+		if r.Cited {
+			cited = append(cited, i+1)
+		}
+	}
+	return cited
+}
+
+// markerPattern matches the citation markers a citationWriter rewrites,
+// with the spaces before them: lists and ranges of passage numbers in
+// brackets, optionally naming them passages or sources.
+var markerPattern = regexp.MustCompile(`(?i)([ \t]*)\[\s*(?:(?:passages?|sources?)\s*)?(\d+(?:\s*(?:,|;|-|–|and)\s*(?:(?:passages?|sources?)\s*)?\d+)*)\s*\]`)
+
+var markerNumber = regexp.MustCompile(`\d+`)
+
+// maxMarker bounds the length of the citation markers markerPattern finds,
+// beyond which a bracket left open is not held back.
+const maxMarker = 48
+
+// citationWriter rewrites the citation markers of an answer to sources, as
+// its mode says, as the answer is written to it.
+type citationWriter struct {
+	mode    CitationMode
+	sources int
+	numbers map[int]int // the new number of each passage cited, by its number
+	order   []int       // the passages cited, in their new order
+	pending string      // text held back until the marker it may end in is complete
+}
+
+func newCitationWriter(mode CitationMode, sources int) *citationWriter {
+	return &citationWriter{mode: mode, sources: sources, numbers: make(map[int]int)}
+}
+
+// write returns the rewritten text of s as far as it can be rewritten,
+// holding back a marker that may not be complete and trailing spaces,
+// which go if a marker following them is removed.
+func (w *citationWriter) write(s string) string {
+	s = w.pending + s
+	cut := len(s)
+	if i := strings.LastIndexByte(s, '['); i >= 0 && !strings.Contains(s[i:], "]") && len(s)-i <= maxMarker {
+		cut = i
+	}
+	cut = len(strings.TrimRight(s[:cut], " \t"))
+	w.pending = s[cut:]
+	return w.rewrite(s[:cut])
+}
+
+// flush returns the rest of the rewritten text.
+func (w *citationWriter) flush() string {
+	s := w.pending
+	w.pending = ""
+	return w.rewrite(s)
+}
+
+func (w *citationWriter) rewrite(s string) string {
+	return markerPattern.ReplaceAllStringFunc(s, func(marker string) string {
+		m := markerPattern.FindStringSubmatch(marker)
+		var b strings.Builder
+		seen := make(map[int]bool)
+		for _, n := range markerNumbers(m[2], w.sources) {
+			if n = w.number(n); n > 0 && !seen[n] {
+				seen[n] = true
+				b.WriteString("[" + strconv.Itoa(n) + "]")
+			}
+		}
+		if b.Len() == 0 {
+			return ""
+		}
+		return m[1] + b.String()
+	})
+}
+
+// markerNumbers returns the passage numbers listed in the text inside a
+// marker, expanding ranges up to the number of sources.
+func markerNumbers(list string, sources int) []int {
+	locs := markerNumber.FindAllStringIndex(list, -1)
+	var numbers []int
+	for i, loc := range locs {
+		n, _ := strconv.Atoi(list[loc[0]:loc[1]])
+		if i > 0 && strings.ContainsAny(list[locs[i-1][1]:loc[0]], "-–") {
+			for prev := numbers[len(numbers)-1] + 1; prev < n && prev <= sources; prev++ {
+				numbers = append(numbers, prev)
+			}
+		}
+		numbers = append(numbers, n)
+	}
+	return numbers
+}
+
+// number returns the number passage n is cited by, 0 if there is no such
+// passage.
+func (w *citationWriter) number(n int) int {
+	if n < 1 || n > w.sources {
+		return 0
+	}
+	if w.mode != CitationsRenumber {
+		return n
+	}
+	if m, ok := w.numbers[n]; ok {
+		return m
+	}
+	w.order = append(w.order, n)
+	w.numbers[n] = len(w.order)
+	return len(w.order)
+}
+
+// reorder returns sources in the order of their new numbers: those cited
+// first, in the order they were, then the others.
+func (w *citationWriter) reorder(sources []SearchResult) []SearchResult {
+	if w.mode != CitationsRenumber {
+		return sources
+	}
+	reordered := make([]SearchResult, 0, len(sources))
+	for _, n := range w.order {
+		reordered = append(reordered, sources[n-1])
+	}
+	for i, s := range sources {
+		if _, ok := w.numbers[i+1]; !ok {
+			reordered = append(reordered, s)
+		}
+	}
+	return reordered
+}
+
+// cite rewrites the citation markers of an answer to sources as the
+// pipeline's Citations mode says, returning the sources in the order the
+// rewritten answer numbers them and the numbers of the passages in also,
+// cited apart from the text, as they now are.
+func (p *Pipeline) cite(text string, sources []SearchResult, also []int) (string, []SearchResult, []int) {
+	if p.Citations == "" {
+		return text, sources, also
+	}
+	w := newCitationWriter(p.Citations, len(sources))
+	text = w.write(text) + w.flush()
+	var cited []int
+	for _, n := range also {
+		if n = w.number(n); n > 0 {
+			cited = append(cited, n)
+		}
+	}
+	return text, w.reorder(sources), cited
+}
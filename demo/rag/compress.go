@@ -0,0 +1,234 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/errgroup"
+)
+
+// DefaultCompressionRatio is the share of the best sentence's score that
+// EmbeddingCompressor requires of the sentences it keeps.
+const DefaultCompressionRatio = 0.8
+
+// compressConcurrency bounds the chunks LLMCompressor reads at once.
+const compressConcurrency = 4
+
+// A Compressor shortens retrieved chunks to the sentences relevant to the
+// query before they are put in the prompt. Chunks without any relevant
+// sentence are dropped; the others keep their order and scores.
+type Compressor interface {
+	Compress(ctx context.Context, query string, results []SearchResult) ([]SearchResult, error)
+}
+
+// NewCompressor returns the Compressor for mode: off, llm or extractive.
+// It returns nil for "" and "off".
+func NewCompressor(mode string, llm LLM, embedder Embedder, ratio float64) (Compressor, error) {
+	switch mode {
+	case "", "off":
+		return nil, nil
+	case "llm":
+		return &LLMCompressor{LLM: llm}, nil
+	case "extractive":
+		return &EmbeddingCompressor{Embedder: embedder, Ratio: ratio}, nil
+	}
+	return nil, fmt.Errorf("unknown compression mode %q", mode)
+}
+
+// CompressRetriever passes the results of Retriever through Compressor.
+type CompressRetriever struct {
+	Retriever  Retriever
+	Compressor Compressor
+}
+
+func (r *CompressRetriever) Retrieve(ctx context.Context, query string, k int, filter Filter) ([]SearchResult, error) {
+	results, err := r.Retriever.Retrieve(ctx, query, k, filter)
+	if err != nil || len(results) == 0 {
+		return results, err
+	}
+	ctx, span := tracer.Start(ctx, "rag.compress", trace.WithAttributes(
+		attribute.Int("rag.chunks", len(results)),
+		attribute.Int("rag.chars", textLength(results)),
+	))
+	results, err = r.Compressor.Compress(ctx, query, results)
+	span.SetAttributes(attribute.Int("rag.compressed_chunks", len(results)), attribute.Int("rag.compressed_chars", textLength(results)))
+	endSpan(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("compressing context: %w", err)
+	}
+	return results, nil
+}
+
+func textLength(results []SearchResult) int {
+	n := 0
+	for _, r := range results {
+		n += len(r.Text)
+	}
+	return n
+}
+
+const compressPrompt = `You select the sentences of a passage that help answer a question.
+The passage is given as numbered sentences. Reply with the numbers of the sentences that are relevant to the question, separated by commas, e.g. 1, 3, 4, or with "none" if no sentence is. Reply with nothing else.`
+
+// LLMCompressor asks LLM which sentences of each chunk are relevant to the
+// query, one chunk at a time. The LLM only picks sentences by number, so
+// the text it keeps is quoted from the chunk. Chunks the LLM fails on are
+// kept whole.
+type LLMCompressor struct {
+	LLM LLM
+}
+
+// sentenceNumber matches the numbers in LLMCompressor replies.
+var sentenceNumber = regexp.MustCompile(`\d+`)
+
+func (c *LLMCompressor) Compress(ctx context.Context, query string, results []SearchResult) ([]SearchResult, error) {
+	keep := make([][]bool, len(results))
+	sentences := make([][]string, len(results))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(compressConcurrency)
+	for i, r := range results {
+		sentences[i] = splitSentences(r.Text)
+		if len(sentences[i]) < 2 {
+			continue
+		}
+		g.Go(func() error {
+			var passage strings.Builder
+			for j, s := range sentences[i] {
+				fmt.Fprintf(&passage, "%d. %s\n", j+1, s)
+			}
+			reply, err := c.LLM.Generate(gctx, []Message{
+				{Role: RoleSystem, Content: compressPrompt},
+				{Role: RoleUser, Content: "Question: " + query + "\n\nPassage:\n" + passage.String()},
+			})
+			if err != nil {
+				// Keep the chunk whole unless the query was cancelled
+				return gctx.Err()
+			}
+			keep[i] = make([]bool, len(sentences[i]))
+			for _, m := range sentenceNumber.FindAllString(reply, -1) {
+				if n, _ := strconv.Atoi(m); n >= 1 && n <= len(keep[i]) {
+					keep[i][n-1] = true
+				}
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return compressResults(results, sentences, keep), nil
+}
+
+// EmbeddingCompressor is an extractive Compressor that needs no LLM: it
+// embeds the query and every sentence of the chunks with Embedder and keeps
+// the sentences whose cosine similarity to the query reaches Ratio times
+// that of the best sentence of all chunks, DefaultCompressionRatio if zero.
+type EmbeddingCompressor struct {
+	Embedder Embedder
+	Ratio    float64
+}
+
+func (c *EmbeddingCompressor) Compress(ctx context.Context, query string, results []SearchResult) ([]SearchResult, error) {
+	ratio := c.Ratio
+	if ratio <= 0 {
+		ratio = DefaultCompressionRatio
+	}
+	texts := []string{query}
+	sentences := make([][]string, len(results))
+	for i, r := range results {
+		sentences[i] = splitSentences(r.Text)
+		texts = append(texts, sentences[i]...)
+	}
+	embeddings, err := c.Embedder.Embed(ctx, texts)
+	if err != nil {
+		return nil, fmt.Errorf("embedding sentences: %w", err)
+	}
+	if len(embeddings) != len(texts) {
+		return nil, fmt.Errorf("embedding sentences: got %d embeddings for %d texts", len(embeddings), len(texts))
+	}
+	scores := make([][]float32, len(results))
+	best := float32(0)
+	next := 1
+	for i := range results {
+		scores[i] = make([]float32, len(sentences[i]))
+		for j := range sentences[i] {
+			scores[i][j] = similarity(MetricCosine, embeddings[0], embeddings[next])
+			best = max(best, scores[i][j])
+			next++
+		}
+	}
+	keep := make([][]bool, len(results))
+	for i := range results {
+		keep[i] = make([]bool, len(sentences[i]))
+		for j, score := range scores[i] {
+			keep[i][j] = score >= float32(ratio)*best
+		}
+	}
+	return compressResults(results, sentences, keep), nil
+}
+
+// compressResults rebuilds every result from the sentences marked in keep,
+// marking gaps with an ellipsis. Results without marks stay whole and
+// results without any kept sentence are dropped.
+func compressResults(results []SearchResult, sentences [][]string, keep [][]bool) []SearchResult {
+	compressed := make([]SearchResult, 0, len(results))
+	for i, r := range results {
+		if keep[i] == nil {
+			compressed = append(compressed, r)
+			continue
+		}
+		var b strings.Builder
+		last := -1
+		for j, s := range sentences[i] {
+			if !keep[i][j] {
+				continue
+			}
+			switch {
+			case last < 0 && j > 0:
+				b.WriteString("… ")
+			case last >= 0 && j > last+1:
+				b.WriteString(" … ")
+			case last >= 0:
+				b.WriteString(" ")
+			}
+			b.WriteString(s)
+			last = j
+		}
+		if last < 0 {
+			continue
+		}
+		if last < len(sentences[i])-1 {
+			b.WriteString(" …")
+		}
+		r.Text = b.String()
+		compressed = append(compressed, r)
+	}
+	return compressed
+}
+
+// sentenceEnd matches the end of a sentence: terminal punctuation, possibly
+// followed by closing quotes or brackets, and then whitespace, or a blank
+// line, which also ends headings and lists. Single line breaks do not, as
+// extracted text is often wrapped mid-sentence.
+var sentenceEnd = regexp.MustCompile(`[.!?]+["'”’)\]]*\s+|\s*\n\s*\n\s*`)
+
+// splitSentences splits text into trimmed, non-empty sentences.
+func splitSentences(text string) []string {
+	var sentences []string
+	start := 0
+	for _, loc := range sentenceEnd.FindAllStringIndex(text, -1) {
+		if s := strings.TrimSpace(text[start:loc[1]]); s != "" {
+			sentences = append(sentences, s)
+		}
+		start = loc[1]
+	}
+	if s := strings.TrimSpace(text[start:]); s != "" {
+		sentences = append(sentences, s)
+	}
+	return sentences
+}
@@ -0,0 +1,50 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const condensePrompt = `You rewrite the latest question of a conversation into a standalone question for a document search.
+Replace pronouns and references to earlier messages, such as "it", "that plan" or "what about pricing?", with what they refer to, keeping the language and intent of the question. If the question already stands on its own, repeat it unchanged.
+Reply with the question only, without answering it.`
+
+// A QueryCondenser rewrites a follow-up question into a standalone one
+// with the conversation it was asked in, so that "what about pricing?"
+// after a question about product X is searched for as "what is the pricing
+// of product X?". The question itself is still what the LLM answers, below
+// the conversation.
+type QueryCondenser struct {
+	LLM LLM
+}
+
+// Condense returns the standalone form of question after history, or
+// question itself if there is no history or the LLM fails.
+func (c *QueryCondenser) Condense(ctx context.Context, question string, history *Conversation) string {
+	if history == nil || history.Summary == "" && len(history.Messages) == 0 {
+		return question
+	}
+	ctx, span := tracer.Start(ctx, "rag.condense")
+	var prompt strings.Builder
+	if history.Summary != "" {
+		fmt.Fprintf(&prompt, "Summary of the conversation so far:\n%s\n\n", history.Summary)
+	}
+	for _, m := range history.Messages {
+		fmt.Fprintf(&prompt, "%s: %s\n", m.Role, m.Content)
+	}
+	fmt.Fprintf(&prompt, "\nLatest question: %s", question)
+	reply, err := c.LLM.Generate(ctx, []Message{
+		{Role: RoleSystem, Content: condensePrompt},
+		{Role: RoleUser, Content: prompt.String()},
+	})
+	reply = strings.TrimSpace(reply)
+	span.SetAttributes(attribute.String("rag.condensed_query", reply))
+	endSpan(span, err)
+	if err != nil || reply == "" {
+		return question
+	}
+	return reply
+}
@@ -0,0 +1,629 @@
+package rag
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// Config selects and configures the providers used by the pipeline. Every
+// field can be set through the environment variable noted beside it, and
+// in the config file read by LoadConfig.
+type Config struct {
+	Embedder         string        // EMBEDDER: openai (default), ollama, onnx, vertex, bedrock or hash
+	EmbeddingModel   string        // EMBEDDING_MODEL: defaults depend on the embedder
+	OllamaHost       string        // OLLAMA_HOST: defaults to http://localhost:11434
+	VertexProject    string        // VERTEX_PROJECT: Google Cloud project of the vertex embedder and llm
+	VertexLocation   string        // VERTEX_LOCATION: region of the vertex embedder and llm, us-central1 by default
+	ONNXModel        string        // ONNX_MODEL: path to a sentence-transformers .onnx file
+	ONNXVocab        string        // ONNX_VOCAB: path to the model's WordPiece vocab.txt
+	ONNXRuntime      string        // ONNXRUNTIME_LIB: path to the onnxruntime shared library
+	SparseEmbedder   string        // SPARSE_EMBEDDER: off (default) or tei sparse vectors such as SPLADE
+	SparseURL        string        // SPARSE_URL: text-embeddings-inference server of the sparse embedder, http://localhost:8080 by default
+	LLM              string        // LLM: openai (default), ollama, vertex, bedrock or openai-compatible
+	ChatModel        string        // CHAT_MODEL: defaults depend on the llm
+	LLMBaseURL       string        // LLM_BASE_URL: API base URL of the openai-compatible llm, e.g. http://localhost:8000/v1
+	LLMAPIKey        string        // LLM_API_KEY: bearer token for the openai-compatible llm
+	LLMStructured    bool          // LLM_STRUCTURED_OUTPUTS: the openai-compatible llm supports JSON Schema response formats, false by default
+	LLMFallbacks     string        // LLM_FALLBACKS: comma-separated llm/model pairs, e.g. ollama/llama3.2, to generate with in turn when the llm fails
+	FallbackTimeout  time.Duration // LLM_FALLBACK_TIMEOUT: time each llm but the last fallback may take before the next is tried, 0 (no limit) by default
+	VectorStore      string        // VECTOR_STORE: sqlite (default), memory, pgvector, qdrant, weaviate, milvus or opensearch (also for Elasticsearch)
+	SQLitePath       string        // SQLITE_PATH: database file of the sqlite store, rag.db by default
+	Metric           string        // VECTOR_METRIC: cosine (default) or ip
+	Quantization     string        // VECTOR_QUANTIZATION: off (default), int8 or binary codes searched by the sqlite and memory stores
+	DatabaseURL      string        // DATABASE_URL: Postgres connection string for pgvector
+	QdrantURL        string        // QDRANT_URL: defaults to http://localhost:6333
+	QdrantAPIKey     string        // QDRANT_API_KEY: API key for Qdrant Cloud
+	WeaviateURL      string        // WEAVIATE_URL: defaults to http://localhost:8080
+	WeaviateAPIKey   string        // WEAVIATE_API_KEY: API key for Weaviate Cloud
+	MilvusURL        string        // MILVUS_URL: defaults to http://localhost:19530
+	MilvusToken      string        // MILVUS_TOKEN: Zilliz Cloud API key, or user:password
+	MilvusIndex      string        // MILVUS_INDEX: vector index type, HNSW (default), IVF_FLAT, IVF_SQ8, FLAT or AUTOINDEX
+	MilvusIndexOpts  string        // MILVUS_INDEX_PARAMS: JSON object of index build parameters, e.g. {"M": 32}
+	MilvusSearchOpts string        // MILVUS_SEARCH_PARAMS: JSON object of search parameters, e.g. {"ef": 128}
+	OpenSearchURL    string        // OPENSEARCH_URL: OpenSearch or Elasticsearch server, defaults to http://localhost:9200
+	OpenSearchToken  string        // OPENSEARCH_TOKEN: Elasticsearch API key, or user:password
+	Collection       string        // COLLECTION: collection name in remote vector stores, rag by default
+	Shards           int           // SHARDS: vector stores the index is split among, 0 (off) by default
+	ShardBy          string        // SHARD_BY: hash (default) spreads documents over all shards, namespace keeps each namespace on one
+	ShardURLs        string        // SHARD_URLS: comma-separated SQLite files, DATABASE_URLs or server URLs of the shards
+	Retriever        string        // RETRIEVER: vector (default), hybrid or sparse
+	HybridWeight     float64       // HYBRID_WEIGHT: share of the dense ranking in hybrid fusion, 0.5 by default
+	ChunkSize        int           // CHUNK_SIZE: maximum chunk length in characters, 1000 by default
+	ChunkOverlap     int           // CHUNK_OVERLAP: characters shared by consecutive chunks, 200 by default
+	ParentChunkSize  int           // PARENT_CHUNK_SIZE: length of the parent chunks given to the LLM, 0 (off) by default
+	QueryVariants    int           // QUERY_VARIANTS: LLM paraphrases of each question to also retrieve for, 0 (off) by default
+	HyDE             bool          // HYDE: retrieve for an answer drafted by the LLM along with each question, false by default
+	SelfQuery        string        // SELF_QUERY: comma-separated metadata fields, each name or name:type, that the LLM turns constraints in questions into filters on; off by default
+	CondenseQueries  bool          // CONDENSE_QUERIES: retrieve for follow-up questions rewritten by the LLM to stand on their own, false by default
+	MinScore         float64       // MIN_SCORE: score below which retrieved chunks are dropped, 0 (off) by default
+	MaxK             int           // MAX_K: most chunks a query may ask to retrieve, 100 by default
+	Languages        string        // LANGUAGE_DETECTION: off (default), tag documents with their language, or filter retrieval by the language of questions too
+	AgentSteps       int           // AGENT_STEPS: turns in which the LLM may search with tool calls before answering, 0 (off) by default
+	Reranker         string        // RERANKER: none (default) or http
+	RerankURL        string        // RERANK_URL: Cohere-compatible rerank endpoint, e.g. https://api.cohere.com/v2/rerank
+	RerankAPIKey     string        // RERANK_API_KEY: bearer token for the rerank endpoint
+	RerankModel      string        // RERANK_MODEL: reranking model name
+	RerankCandidates int           // RERANK_CANDIDATES: results rescored by the reranker, 50 by default
+	MemoryWindow     int           // MEMORY_WINDOW: messages per session kept verbatim, 6 by default
+	SessionStore     string        // SESSION_STORE: memory (default) or redis keeps the conversations of sessions
+	SessionTTL       time.Duration // SESSION_TTL: how long Redis keeps a session after its last question, 24h by default; 0 keeps it forever
+	PromptTemplate   string        // PROMPT_TEMPLATE: path to a text/template file defining "system" and "user"
+	ContextTokens    int           // CONTEXT_TOKENS: token budget of the prompt, 0 (unlimited) by default
+	ContextOverflow  string        // CONTEXT_OVERFLOW: truncate (default), summarize or split the chunks that do not fit in CONTEXT_TOKENS
+	SummarizeTokens  int           // SUMMARIZE_TOKENS: token budget of the passages or summaries of each summarization call, 3000 by default
+	Grounding        string        // GROUNDING: off (default), flag, strip or regenerate unsupported claims of answers
+	InjectionGuard   string        // INJECTION_GUARD: off (default), flag or strip prompt injections in retrieved chunks
+	Citations        string        // CITATIONS: off, validate (default) the citation markers of answers, or renumber them in the order they are cited
+	NoContext        string        // NO_CONTEXT: refuse (default) or generate an answer when no chunk is retrieved
+	Router           string        // ROUTER: off (default), rules or llm routes questions to the models and temperatures of ROUTES
+	Routes           string        // ROUTES: JSON file of the routing table of ROUTER
+	OCR              string        // OCR: off (default) or tesseract recognition of scanned PDF pages
+	OCRLanguages     string        // OCR_LANGUAGES: tesseract languages joined by "+", e.g. eng+deu
+	OCRMinChars      int           // OCR_MIN_CHARS: characters of text below which PDF pages are recognized, 20 by default
+	RowTemplate      string        // ROW_TEMPLATE: text/template turning each CSV or JSONL record into a passage, "column: value" lines by default
+	PartSize         int           // PART_SIZE: megabytes from which text, CSV and JSONL files and objects are ingested in parts of that size, 8 by default; 0 never splits them
+	MaxFileSize      int           // MAX_FILE_SIZE: megabytes above which files, bucket objects and pages are not ingested, 0 (no limit) by default
+	Dedup            string        // DEDUP: off (default), exact or near duplicate chunks are skipped at ingest
+	DedupThreshold   float64       // DEDUP_THRESHOLD: similarity from which chunks are near duplicates, 0.9 by default
+	SummaryFanout    int           // SUMMARY_FANOUT: chunks, and then summaries, summarized together into a tree of summaries of every document, 0 (off) by default
+	Enrichment       string        // ENRICHMENT: off (default), metadata, summary or both: an LLM title, summary and keywords for every chunk, with summary embedded instead of its text and both along with it
+	MMRLambda        float64       // MMR_LAMBDA: relevance weight of MMR diversification, 0 (off) by default
+	Compression      string        // COMPRESSION: off (default), llm or extractive compression of retrieved chunks
+	CompressionRatio float64       // COMPRESSION_RATIO: share of the best sentence's similarity that extractive compression keeps, 0.8 by default
+	Pricing          string        // PRICING: JSON file of model prices per million tokens, added to DefaultPricing
+	BatchSize        int           // EMBED_BATCH_SIZE: chunks per embedding request, 64 by default
+	Concurrency      int           // EMBED_CONCURRENCY: embedding requests in flight, 4 by default
+	Retries          int           // EMBED_RETRIES: retries per failed embedding request, 2 by default
+	RateLimit        float64       // RATE_LIMIT: requests per second each provider client sends, 0 (unlimited) by default
+	RateLimitStore   string        // RATE_LIMIT_STORE: local (default), or redis to share RATE_LIMIT between processes
+	HTTPRetries      int           // HTTP_RETRIES: retries of rate limited or failed provider requests, 3 by default
+	RequestTimeout   time.Duration // REQUEST_TIMEOUT: time in which a question must be answered, 0 (no limit) by default
+	EmbedTimeout     time.Duration // EMBED_TIMEOUT: time each embedding of a question may take, 0 (no limit) by default
+	RetrieveTimeout  time.Duration // RETRIEVE_TIMEOUT: time each search for a question may take, reranking included, 0 (no limit) by default
+	RerankTimeout    time.Duration // RERANK_TIMEOUT: time after which reranking is skipped, 0 (no limit) by default
+	GenerateTimeout  time.Duration // GENERATE_TIMEOUT: time each reply of the LLM may take, 0 (no limit) by default
+	EmbedCache       string        // EMBED_CACHE: embedding cache file, in the user cache directory by default; redis keeps it in Redis, off disables it
+	RedisURL         string        // REDIS_URL: Redis server for state shared between processes, e.g. redis://localhost:6379/0
+	AnswerCache      string        // ANSWER_CACHE: SQLite file of cached answers, off (default) disables it
+	AnswerCacheTTL   time.Duration // ANSWER_CACHE_TTL: how long answers stay cached, 24h by default; 0 keeps them until invalidated
+	AnswerSimilarity float64       // ANSWER_CACHE_SIMILARITY: similarity from which questions share a cached answer, 0.95 by default
+	FeedbackDB       string        // FEEDBACK_DB: SQLite file of the feedback on answers, off (default) disables feedback
+	FeedbackWeight   float64       // FEEDBACK_WEIGHT: share by which feedback raises or lowers the scores of retrieved chunks, 0 (off) by default
+	FeedbackSim      float64       // FEEDBACK_SIMILARITY: similarity from which the feedback on a question counts for another, 0.8 by default
+	RecencyWeight    float64       // RECENCY_WEIGHT: share of the scores of retrieved chunks that decays with the age of their documents, 0 (off) by default
+	RecencyHalfLife  time.Duration // RECENCY_HALF_LIFE: age at which a document counts half as fresh as a new one, 720h by default
+	RecencyFields    string        // RECENCY_FIELDS: comma-separated metadata keys of the time of a document, the first set counting; date, last_modified by default
+	GraphDB          string        // GRAPH_DB: SQLite file of the entities and relations extracted from chunks, off (default) disables the graph
+	GraphHops        int           // GRAPH_HOPS: relations followed from the entities of a question, 1 by default
+	GraphChunks      int           // GRAPH_CHUNKS: chunks found through the graph added to those retrieved, 2 by default
+	AuditLog         string        // AUDIT_LOG: JSON Lines file every query, its chunks and answer are appended to, off (default) disables it
+	PII              string        // PII: off (default), or mask personal data in ingested documents (ingest), in answers (answers) or in both
+	PIIKinds         string        // PII_KINDS: comma-separated kinds of personal data masked, e.g. email,phone,person; all kinds found by default
+	PIINERURL        string        // PII_NER_URL: Presidio analyzer that also finds names, places and other entities
+	PIILog           string        // PII_LOG: JSON Lines file every redaction is recorded in, off (default) disables it
+	WebhookURLs      string        // WEBHOOK_URLS: comma-separated URLs events about changes to the corpus are posted to, none by default
+	WebhookSecret    string        // WEBHOOK_SECRET: key of the HMAC-SHA256 signature of webhook requests, unsigned if empty
+	WebhookEvents    string        // WEBHOOK_EVENTS: comma-separated types of the events posted, all by default
+	WebhookRetries   int           // WEBHOOK_RETRIES: retries of failed webhook deliveries, 5 by default
+	JobsDB           string        // JOBS_DB: SQLite file of the server's ingestion jobs, jobs.db by default; off ingests synchronously
+	APIKeys          string        // API_KEYS: SQLite file of the API keys the server requires, off (default) serves without keys
+	SyncSources      string        // SYNC_SOURCES: comma-separated directories, URLs, bucket URLs and page sources that rag sync ingests again
+	SyncSchedule     string        // SYNC_SCHEDULE: cron expression, or @every interval, at which the server syncs; off by default
+	SyncReport       string        // SYNC_REPORT: file the change report of every sync is appended to as a JSON line
+	SyncState        string        // SYNC_STATE: file of the versions of the bucket objects and pages synced, so unchanged ones are skipped; off (default) syncs everything
+	ExpirySweep      string        // EXPIRY_SWEEP: cron expression, or @every interval, at which the server deletes expired documents; @hourly by default, off never deletes them
+	S3Endpoint       string        // S3_ENDPOINT: endpoint of an S3-compatible server such as MinIO; AWS by default
+	S3Region         string        // AWS_REGION: region of S3 buckets and Bedrock, us-east-1 by default
+	S3AccessKey      string        // AWS_ACCESS_KEY_ID: access key for s3:// buckets and Bedrock, which finds the credentials of the environment without
+	S3SecretKey      string        // AWS_SECRET_ACCESS_KEY: secret key for s3:// buckets and Bedrock
+	S3SessionToken   string        // AWS_SESSION_TOKEN: session token of temporary AWS credentials
+	GCSAccessKey     string        // GCS_HMAC_ACCESS_KEY: HMAC access key for gs:// buckets
+	GCSSecretKey     string        // GCS_HMAC_SECRET: HMAC secret for gs:// buckets
+	ConfluenceURL    string        // CONFLUENCE_URL: base URL of the Confluence site of confluence:// sources, e.g. https://example.atlassian.net/wiki
+	ConfluenceUser   string        // CONFLUENCE_USER: user whose API token CONFLUENCE_TOKEN is; without one the token is a personal access token
+	ConfluenceToken  string        // CONFLUENCE_TOKEN: API token or personal access token for Confluence
+	NotionURL        string        // NOTION_URL: Notion API of notion:// sources, https://api.notion.com by default
+	NotionToken      string        // NOTION_TOKEN: token of the Notion integration the databases are shared with
+}
+
+// setting binds a field of Config to its environment variable and to its
+// key in config files, where dots separate nested mappings.
+type setting struct {
+	key string
+	env string
+	dst any // *string, *int, *float64, *bool or *time.Duration
+}
+
+func (cfg *Config) settings() []setting {
+	return []setting{
+		{"embedder.provider", "EMBEDDER", &cfg.Embedder},
+		{"embedder.model", "EMBEDDING_MODEL", &cfg.EmbeddingModel},
+		{"embedder.batch_size", "EMBED_BATCH_SIZE", &cfg.BatchSize},
+		{"embedder.concurrency", "EMBED_CONCURRENCY", &cfg.Concurrency},
+		{"embedder.retries", "EMBED_RETRIES", &cfg.Retries},
+		{"embedder.cache", "EMBED_CACHE", &cfg.EmbedCache},
+		{"ollama.host", "OLLAMA_HOST", &cfg.OllamaHost},
+		{"vertex.project", "VERTEX_PROJECT", &cfg.VertexProject},
+		{"vertex.location", "VERTEX_LOCATION", &cfg.VertexLocation},
+		{"embedder.sparse.provider", "SPARSE_EMBEDDER", &cfg.SparseEmbedder},
+		{"embedder.sparse.url", "SPARSE_URL", &cfg.SparseURL},
+		{"onnx.model", "ONNX_MODEL", &cfg.ONNXModel},
+		{"onnx.vocab", "ONNX_VOCAB", &cfg.ONNXVocab},
+		{"onnx.runtime", "ONNXRUNTIME_LIB", &cfg.ONNXRuntime},
+		{"llm.provider", "LLM", &cfg.LLM},
+		{"llm.model", "CHAT_MODEL", &cfg.ChatModel},
+		{"llm.base_url", "LLM_BASE_URL", &cfg.LLMBaseURL},
+		{"llm.api_key", "LLM_API_KEY", &cfg.LLMAPIKey},
+		{"llm.structured_outputs", "LLM_STRUCTURED_OUTPUTS", &cfg.LLMStructured},
+		{"llm.fallbacks", "LLM_FALLBACKS", &cfg.LLMFallbacks},
+		{"llm.fallback_timeout", "LLM_FALLBACK_TIMEOUT", &cfg.FallbackTimeout},
+		{"store.type", "VECTOR_STORE", &cfg.VectorStore},
+		{"store.sqlite_path", "SQLITE_PATH", &cfg.SQLitePath},
+		{"store.metric", "VECTOR_METRIC", &cfg.Metric},
+		{"store.quantization", "VECTOR_QUANTIZATION", &cfg.Quantization},
+		{"store.collection", "COLLECTION", &cfg.Collection},
+		{"store.database_url", "DATABASE_URL", &cfg.DatabaseURL},
+		{"store.qdrant.url", "QDRANT_URL", &cfg.QdrantURL},
+		{"store.qdrant.api_key", "QDRANT_API_KEY", &cfg.QdrantAPIKey},
+		{"store.weaviate.url", "WEAVIATE_URL", &cfg.WeaviateURL},
+		{"store.weaviate.api_key", "WEAVIATE_API_KEY", &cfg.WeaviateAPIKey},
+		{"store.milvus.url", "MILVUS_URL", &cfg.MilvusURL},
+		{"store.milvus.token", "MILVUS_TOKEN", &cfg.MilvusToken},
+		{"store.milvus.index", "MILVUS_INDEX", &cfg.MilvusIndex},
+		{"store.milvus.index_params", "MILVUS_INDEX_PARAMS", &cfg.MilvusIndexOpts},
+		{"store.milvus.search_params", "MILVUS_SEARCH_PARAMS", &cfg.MilvusSearchOpts},
+		{"store.opensearch.url", "OPENSEARCH_URL", &cfg.OpenSearchURL},
+		{"store.opensearch.token", "OPENSEARCH_TOKEN", &cfg.OpenSearchToken},
+		{"store.shards", "SHARDS", &cfg.Shards},
+		{"store.shard_by", "SHARD_BY", &cfg.ShardBy},
+		{"store.shard_urls", "SHARD_URLS", &cfg.ShardURLs},
+		{"chunking.size", "CHUNK_SIZE", &cfg.ChunkSize},
+		{"chunking.overlap", "CHUNK_OVERLAP", &cfg.ChunkOverlap},
+		{"chunking.parent_size", "PARENT_CHUNK_SIZE", &cfg.ParentChunkSize},
+		{"chunking.row_template", "ROW_TEMPLATE", &cfg.RowTemplate},
+		{"chunking.part_size", "PART_SIZE", &cfg.PartSize},
+		{"chunking.max_file_size", "MAX_FILE_SIZE", &cfg.MaxFileSize},
+		{"ocr.engine", "OCR", &cfg.OCR},
+		{"ocr.languages", "OCR_LANGUAGES", &cfg.OCRLanguages},
+		{"ocr.min_chars", "OCR_MIN_CHARS", &cfg.OCRMinChars},
+		{"chunking.dedup", "DEDUP", &cfg.Dedup},
+		{"chunking.dedup_threshold", "DEDUP_THRESHOLD", &cfg.DedupThreshold},
+		{"chunking.summary_fanout", "SUMMARY_FANOUT", &cfg.SummaryFanout},
+		{"chunking.enrichment", "ENRICHMENT", &cfg.Enrichment},
+		{"retrieval.strategy", "RETRIEVER", &cfg.Retriever},
+		{"retrieval.hybrid_weight", "HYBRID_WEIGHT", &cfg.HybridWeight},
+		{"retrieval.query_variants", "QUERY_VARIANTS", &cfg.QueryVariants},
+		{"retrieval.min_score", "MIN_SCORE", &cfg.MinScore},
+		{"retrieval.max_k", "MAX_K", &cfg.MaxK},
+		{"retrieval.language_detection", "LANGUAGE_DETECTION", &cfg.Languages},
+		{"retrieval.agent_steps", "AGENT_STEPS", &cfg.AgentSteps},
+		{"retrieval.hyde", "HYDE", &cfg.HyDE},
+		{"retrieval.self_query", "SELF_QUERY", &cfg.SelfQuery},
+		{"retrieval.condense_queries", "CONDENSE_QUERIES", &cfg.CondenseQueries},
+		{"retrieval.mmr_lambda", "MMR_LAMBDA", &cfg.MMRLambda},
+		{"retrieval.compression", "COMPRESSION", &cfg.Compression},
+		{"retrieval.compression_ratio", "COMPRESSION_RATIO", &cfg.CompressionRatio},
+		{"retrieval.rerank.type", "RERANKER", &cfg.Reranker},
+		{"retrieval.rerank.url", "RERANK_URL", &cfg.RerankURL},
+		{"retrieval.rerank.api_key", "RERANK_API_KEY", &cfg.RerankAPIKey},
+		{"retrieval.rerank.model", "RERANK_MODEL", &cfg.RerankModel},
+		{"retrieval.rerank.candidates", "RERANK_CANDIDATES", &cfg.RerankCandidates},
+		{"generation.prompt_template", "PROMPT_TEMPLATE", &cfg.PromptTemplate},
+		{"generation.context_tokens", "CONTEXT_TOKENS", &cfg.ContextTokens},
+		{"generation.context_overflow", "CONTEXT_OVERFLOW", &cfg.ContextOverflow},
+		{"generation.summarize_tokens", "SUMMARIZE_TOKENS", &cfg.SummarizeTokens},
+		{"generation.memory_window", "MEMORY_WINDOW", &cfg.MemoryWindow},
+		{"generation.session_store", "SESSION_STORE", &cfg.SessionStore},
+		{"generation.session_ttl", "SESSION_TTL", &cfg.SessionTTL},
+		{"generation.grounding", "GROUNDING", &cfg.Grounding},
+		{"generation.injection_guard", "INJECTION_GUARD", &cfg.InjectionGuard},
+		{"generation.citations", "CITATIONS", &cfg.Citations},
+		{"generation.no_context", "NO_CONTEXT", &cfg.NoContext},
+		{"generation.router", "ROUTER", &cfg.Router},
+		{"generation.routes", "ROUTES", &cfg.Routes},
+		{"pricing", "PRICING", &cfg.Pricing},
+		{"http.rate_limit", "RATE_LIMIT", &cfg.RateLimit},
+		{"http.retries", "HTTP_RETRIES", &cfg.HTTPRetries},
+		{"http.rate_limit_store", "RATE_LIMIT_STORE", &cfg.RateLimitStore},
+		{"timeouts.request", "REQUEST_TIMEOUT", &cfg.RequestTimeout},
+		{"timeouts.embed", "EMBED_TIMEOUT", &cfg.EmbedTimeout},
+		{"timeouts.retrieve", "RETRIEVE_TIMEOUT", &cfg.RetrieveTimeout},
+		{"timeouts.rerank", "RERANK_TIMEOUT", &cfg.RerankTimeout},
+		{"timeouts.generate", "GENERATE_TIMEOUT", &cfg.GenerateTimeout},
+		{"redis.url", "REDIS_URL", &cfg.RedisURL},
+		{"answer_cache.path", "ANSWER_CACHE", &cfg.AnswerCache},
+		{"answer_cache.ttl", "ANSWER_CACHE_TTL", &cfg.AnswerCacheTTL},
+		{"answer_cache.similarity", "ANSWER_CACHE_SIMILARITY", &cfg.AnswerSimilarity},
+		{"feedback.db", "FEEDBACK_DB", &cfg.FeedbackDB},
+		{"feedback.weight", "FEEDBACK_WEIGHT", &cfg.FeedbackWeight},
+		{"feedback.similarity", "FEEDBACK_SIMILARITY", &cfg.FeedbackSim},
+		{"retrieval.recency_weight", "RECENCY_WEIGHT", &cfg.RecencyWeight},
+		{"retrieval.recency_half_life", "RECENCY_HALF_LIFE", &cfg.RecencyHalfLife},
+		{"retrieval.recency_fields", "RECENCY_FIELDS", &cfg.RecencyFields},
+		{"graph.db", "GRAPH_DB", &cfg.GraphDB},
+		{"graph.hops", "GRAPH_HOPS", &cfg.GraphHops},
+		{"graph.chunks", "GRAPH_CHUNKS", &cfg.GraphChunks},
+		{"audit.log", "AUDIT_LOG", &cfg.AuditLog},
+		{"pii.mode", "PII", &cfg.PII},
+		{"pii.kinds", "PII_KINDS", &cfg.PIIKinds},
+		{"pii.ner_url", "PII_NER_URL", &cfg.PIINERURL},
+		{"pii.log", "PII_LOG", &cfg.PIILog},
+		{"webhooks.urls", "WEBHOOK_URLS", &cfg.WebhookURLs},
+		{"webhooks.secret", "WEBHOOK_SECRET", &cfg.WebhookSecret},
+		{"webhooks.events", "WEBHOOK_EVENTS", &cfg.WebhookEvents},
+		{"webhooks.retries", "WEBHOOK_RETRIES", &cfg.WebhookRetries},
+		{"server.jobs_db", "JOBS_DB", &cfg.JobsDB},
+		{"server.api_keys", "API_KEYS", &cfg.APIKeys},
+		{"sync.sources", "SYNC_SOURCES", &cfg.SyncSources},
+		{"sync.schedule", "SYNC_SCHEDULE", &cfg.SyncSchedule},
+		{"sync.report", "SYNC_REPORT", &cfg.SyncReport},
+		{"sync.state", "SYNC_STATE", &cfg.SyncState},
+		{"expiry.sweep", "EXPIRY_SWEEP", &cfg.ExpirySweep},
+		{"s3.endpoint", "S3_ENDPOINT", &cfg.S3Endpoint},
+		{"s3.region", "AWS_REGION", &cfg.S3Region},
+		{"s3.access_key", "AWS_ACCESS_KEY_ID", &cfg.S3AccessKey},
+		{"s3.secret_key", "AWS_SECRET_ACCESS_KEY", &cfg.S3SecretKey},
+		{"s3.session_token", "AWS_SESSION_TOKEN", &cfg.S3SessionToken},
+		{"gcs.access_key", "GCS_HMAC_ACCESS_KEY", &cfg.GCSAccessKey},
+		{"gcs.secret_key", "GCS_HMAC_SECRET", &cfg.GCSSecretKey},
+		{"confluence.url", "CONFLUENCE_URL", &cfg.ConfluenceURL},
+		{"confluence.user", "CONFLUENCE_USER", &cfg.ConfluenceUser},
+		{"confluence.token", "CONFLUENCE_TOKEN", &cfg.ConfluenceToken},
+		{"notion.url", "NOTION_URL", &cfg.NotionURL},
+		{"notion.token", "NOTION_TOKEN", &cfg.NotionToken},
+	}
+}
+
+// DefaultConfig returns the Config used for settings that are not set, as
+// ConfigFromEnv reads it from an empty environment.
+func DefaultConfig() Config {
+	return Config{
+		OllamaHost:       "http://localhost:11434",
+		VertexLocation:   DefaultVertexLocation,
+		SparseURL:        "http://localhost:8080",
+		SQLitePath:       "rag.db",
+		QdrantURL:        "http://localhost:6333",
+		WeaviateURL:      "http://localhost:8080",
+		MilvusURL:        "http://localhost:19530",
+		MilvusIndex:      "HNSW",
+		OpenSearchURL:    "http://localhost:9200",
+		Collection:       "rag",
+		ShardBy:          "hash",
+		HybridWeight:     0.5,
+		ChunkSize:        1000,
+		ChunkOverlap:     200,
+		PartSize:         DefaultPartSize >> 20,
+		MaxK:             DefaultMaxK,
+		RerankCandidates: DefaultRerankCandidates,
+		MemoryWindow:     DefaultMemoryWindow,
+		Citations:        string(CitationsValidate),
+		SessionTTL:       DefaultSessionTTL,
+		DedupThreshold:   DefaultDedupThreshold,
+		CompressionRatio: DefaultCompressionRatio,
+		OCRMinChars:      DefaultOCRMinChars,
+		BatchSize:        DefaultBatchSize,
+		Concurrency:      DefaultConcurrency,
+		Retries:          DefaultRetries,
+		HTTPRetries:      DefaultHTTPRetries,
+		WebhookRetries:   DefaultWebhookRetries,
+		EmbedCache:       defaultEmbedCachePath(),
+		JobsDB:           "jobs.db",
+		ExpirySweep:      "@hourly",
+		AnswerCacheTTL:   DefaultAnswerCacheTTL,
+		AnswerSimilarity: DefaultAnswerSimilarity,
+		FeedbackSim:      DefaultFeedbackSimilarity,
+		RecencyHalfLife:  DefaultRecencyHalfLife,
+		RecencyFields:    DefaultRecencyFields,
+		GraphHops:        DefaultGraphHops,
+		GraphChunks:      DefaultGraphChunks,
+		SummarizeTokens:  DefaultSummarizeTokens,
+	}
+}
+
+// ConfigFromEnv reads a Config from the environment.
+func ConfigFromEnv() (Config, error) {
+	cfg := DefaultConfig()
+	names := make(map[any]string)
+	if err := cfg.applyEnv(names); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.finish(names)
+}
+
+// LoadConfig reads a Config from the YAML files at paths, each overriding
+// the settings of those before it, such as
+//
+//	embedder:
+//	  provider: ollama
+//	retrieval:
+//	  strategy: hybrid
+//	  hybrid_weight: 0.7
+//
+// and applies the environment variables that are set on top of them.
+// Errors name the offending setting and, if it came from a file, its line.
+func LoadConfig(paths ...string) (Config, error) {
+	cfg := DefaultConfig()
+	names := make(map[any]string)
+	for _, path := range paths {
+		if err := cfg.applyFile(path, names); err != nil {
+			return cfg, err
+		}
+	}
+	if err := cfg.applyEnv(names); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.finish(names)
+}
+
+// applyEnv sets the fields whose environment variables are set and not
+// empty, and names them after their variable in names.
+func (cfg *Config) applyEnv(names map[any]string) error {
+	for _, s := range cfg.settings() {
+		v := os.Getenv(s.env)
+		if v == "" {
+			if _, ok := names[s.dst]; !ok {
+				names[s.dst] = s.env
+			}
+			continue
+		}
+		if err := parseSetting(s.dst, v); err != nil {
+			return fmt.Errorf("%s: %w", s.env, err)
+		}
+		names[s.dst] = s.env
+	}
+	return nil
+}
+
+// applyFile sets the fields given in the YAML file at path, and names them
+// after their file, line and key in names.
+func (cfg *Config) applyFile(path string, names map[any]string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config: %w", err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	settings := make(map[string]setting)
+	sections := make(map[string]bool)
+	for _, s := range cfg.settings() {
+		settings[s.key] = s
+		for i, c := range s.key {
+			if c == '.' {
+				sections[s.key[:i]] = true
+			}
+		}
+	}
+	var apply func(n *yaml.Node, prefix string) error
+	apply = func(n *yaml.Node, prefix string) error {
+		if n.Kind == yaml.AliasNode {
+			n = n.Alias
+		}
+		if n.Kind == yaml.ScalarNode && n.Tag == "!!null" {
+			return nil
+		}
+		if n.Kind != yaml.MappingNode {
+			if prefix == "" {
+				return fmt.Errorf("%s:%d: want a mapping of settings", path, n.Line)
+			}
+			return fmt.Errorf("%s:%d: %s: want a mapping of settings", path, n.Line, prefix)
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i].Value, n.Content[i+1]
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			name := fmt.Sprintf("%s:%d: %s", path, n.Content[i].Line, key)
+			if sections[key] {
+				if err := apply(value, key); err != nil {
+					return err
+				}
+				continue
+			}
+			s, ok := settings[key]
+			if !ok {
+				return fmt.Errorf("%s: unknown setting", name)
+			}
+			if value.Kind == yaml.AliasNode {
+				value = value.Alias
+			}
+			if value.Kind != yaml.ScalarNode {
+				return fmt.Errorf("%s: want a single value", name)
+			}
+			if value.Tag == "!!null" {
+				continue
+			}
+			if err := parseSetting(s.dst, value.Value); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			names[s.dst] = name
+		}
+		return nil
+	}
+	return apply(doc.Content[0], "")
+}
+
+// parseSetting parses v into dst, a field listed by settings.
+func parseSetting(dst any, v string) error {
+	switch dst := dst.(type) {
+	case *string:
+		*dst = v
+	case *bool:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("want true or false, got %q", v)
+		}
+		*dst = b
+	case *int:
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("want an integer, got %q", v)
+		}
+		*dst = i
+	case *float64:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("want a number, got %q", v)
+		}
+		*dst = f
+	case *time.Duration:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("want a duration such as 30s or 24h, got %q", v)
+		}
+		*dst = d
+	default:
+		panic(fmt.Sprintf("rag: unsupported setting type %T", dst))
+	}
+	return nil
+}
+
+// finish resolves settings that turn features off and checks the ranges of
+// numeric settings, naming invalid fields as in names.
+func (cfg *Config) finish(names map[any]string) error {
+	if cfg.EmbedCache == "off" {
+		cfg.EmbedCache = ""
+	}
+	if cfg.JobsDB == "off" {
+		cfg.JobsDB = ""
+	}
+	if cfg.AnswerCache == "off" {
+		cfg.AnswerCache = ""
+	}
+	if cfg.FeedbackDB == "off" {
+		cfg.FeedbackDB = ""
+	}
+	if cfg.GraphDB == "off" {
+		cfg.GraphDB = ""
+	}
+	if cfg.AuditLog == "off" {
+		cfg.AuditLog = ""
+	}
+	if cfg.PIILog == "off" {
+		cfg.PIILog = ""
+	}
+	if cfg.APIKeys == "off" {
+		cfg.APIKeys = ""
+	}
+	if cfg.SyncSchedule == "off" {
+		cfg.SyncSchedule = ""
+	}
+	if cfg.SyncState == "off" {
+		cfg.SyncState = ""
+	}
+	if cfg.ExpirySweep == "off" {
+		cfg.ExpirySweep = ""
+	}
+	if cfg.SelfQuery == "off" {
+		cfg.SelfQuery = ""
+	}
+	for _, store := range []*string{&cfg.SessionStore, &cfg.RateLimitStore, &cfg.EmbedCache} {
+		if *store != "redis" {
+			continue
+		}
+		if cfg.RedisURL == "" {
+			return fmt.Errorf("%s=redis needs %s", names[store], names[&cfg.RedisURL])
+		}
+		if _, err := NewRedisClient(cfg.RedisURL); err != nil {
+			return err
+		}
+	}
+	switch cfg.SessionStore {
+	case "", "memory", "redis":
+	default:
+		return fmt.Errorf("unknown %s %q", names[&cfg.SessionStore], cfg.SessionStore)
+	}
+	switch cfg.RateLimitStore {
+	case "", "local", "redis":
+	default:
+		return fmt.Errorf("unknown %s %q", names[&cfg.RateLimitStore], cfg.RateLimitStore)
+	}
+	for _, schedule := range []*string{&cfg.SyncSchedule, &cfg.ExpirySweep} {
+		if *schedule == "" {
+			continue
+		}
+		if _, err := ParseSchedule(*schedule); err != nil {
+			return fmt.Errorf("%s: %w", names[schedule], err)
+		}
+	}
+	if _, err := ParseMetadataFields(cfg.SelfQuery); err != nil {
+		return fmt.Errorf("%s: %w", names[&cfg.SelfQuery], err)
+	}
+	if cfg.HybridWeight < 0 || cfg.HybridWeight > 1 {
+		return fmt.Errorf("%s must be between 0 and 1, got %v", names[&cfg.HybridWeight], cfg.HybridWeight)
+	}
+	if cfg.AgentSteps < 0 || cfg.AgentSteps > MaxAgentSteps {
+		return fmt.Errorf("%s must be between 0 and %d, got %d", names[&cfg.AgentSteps], MaxAgentSteps, cfg.AgentSteps)
+	}
+	if cfg.MaxK < 0 {
+		return fmt.Errorf("%s must not be negative, got %d", names[&cfg.MaxK], cfg.MaxK)
+	}
+	if cfg.MMRLambda < 0 || cfg.MMRLambda > 1 {
+		return fmt.Errorf("%s must be between 0 and 1, got %v", names[&cfg.MMRLambda], cfg.MMRLambda)
+	}
+	if cfg.CompressionRatio < 0 || cfg.CompressionRatio > 1 {
+		return fmt.Errorf("%s must be between 0 and 1, got %v", names[&cfg.CompressionRatio], cfg.CompressionRatio)
+	}
+	if cfg.AnswerSimilarity < 0 || cfg.AnswerSimilarity > 1 {
+		return fmt.Errorf("%s must be between 0 and 1, got %v", names[&cfg.AnswerSimilarity], cfg.AnswerSimilarity)
+	}
+	if cfg.FeedbackWeight < 0 || cfg.FeedbackWeight > 1 {
+		return fmt.Errorf("%s must be between 0 and 1, got %v", names[&cfg.FeedbackWeight], cfg.FeedbackWeight)
+	}
+	if cfg.FeedbackSim < 0 || cfg.FeedbackSim > 1 {
+		return fmt.Errorf("%s must be between 0 and 1, got %v", names[&cfg.FeedbackSim], cfg.FeedbackSim)
+	}
+	if cfg.RecencyWeight < 0 || cfg.RecencyWeight > 1 {
+		return fmt.Errorf("%s must be between 0 and 1, got %v", names[&cfg.RecencyWeight], cfg.RecencyWeight)
+	}
+	if cfg.RecencyWeight > 0 && cfg.RecencyHalfLife <= 0 {
+		return fmt.Errorf("%s must be positive, got %v", names[&cfg.RecencyHalfLife], cfg.RecencyHalfLife)
+	}
+	if cfg.RateLimit < 0 {
+		return fmt.Errorf("%s must not be negative, got %v", names[&cfg.RateLimit], cfg.RateLimit)
+	}
+	for _, s := range cfg.settings() {
+		if i, ok := s.dst.(*int); ok && *i < 0 {
+			return fmt.Errorf("%s must not be negative, got %d", names[s.dst], *i)
+		}
+		if d, ok := s.dst.(*time.Duration); ok && *d < 0 {
+			return fmt.Errorf("%s must not be negative, got %v", names[s.dst], *d)
+		}
+	}
+	return nil
+}
+
+func getenv(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,171 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Confluence reads the current pages of a Confluence space through the
+// Confluence REST API. With a User, requests authenticate with the user's
+// API token, as Confluence Cloud expects; without one Token is sent as a
+// bearer token, a personal access token of Confluence Data Center.
+type Confluence struct {
+	Client  *http.Client
+	BaseURL string // e.g. https://example.atlassian.net/wiki
+	User    string
+	Token   string
+	Space   string // the space key
+}
+
+// URL returns the URL of the space, confluence://SPACE/.
+func (c *Confluence) URL() string {
+	return "confluence://" + c.Space + "/"
+}
+
+// confluenceContent is a page as the content API returns it.
+type confluenceContent struct {
+	ID      string `json:"id"`
+	Title   string `json:"title"`
+	Version struct {
+		Number int       `json:"number"`
+		When   time.Time `json:"when"`
+	} `json:"version"`
+	Body struct {
+		Storage struct {
+			Value string `json:"value"`
+		} `json:"storage"`
+	} `json:"body"`
+	Metadata struct {
+		Labels struct {
+			Results []struct {
+				Name string `json:"name"`
+			} `json:"results"`
+		} `json:"labels"`
+	} `json:"metadata"`
+	Links struct {
+		WebUI string `json:"webui"`
+	} `json:"_links"`
+}
+
+// Pages lists the current pages of the space, following the API's pages
+// of results. Page versions are Confluence's version numbers.
+func (c *Confluence) Pages(ctx context.Context, fn func(Page) error) error {
+	query := url.Values{
+		"spaceKey": {c.Space},
+		"type":     {"page"},
+		"status":   {"current"},
+		"expand":   {"version"},
+		"limit":    {"100"},
+	}
+	next := "/rest/api/content?" + query.Encode()
+	for next != "" {
+		var resp struct {
+			Results []confluenceContent `json:"results"`
+			Links   struct {
+				Base string `json:"base"`
+				Next string `json:"next"`
+			} `json:"_links"`
+		}
+		if err := c.get(ctx, next, &resp); err != nil {
+			return err
+		}
+		for _, content := range resp.Results {
+			if err := fn(c.page(content, resp.Links.Base)); err != nil {
+				return err
+			}
+		}
+		next = resp.Links.Next
+	}
+	return nil
+}
+
+// Load fetches the body of a page and converts it from Confluence's
+// storage format to text the way HTMLLoader does, keeping the contents of
+// code blocks. The page's labels are recorded as "labels" metadata,
+// separated by commas, and its space as "confluence_space".
+func (c *Confluence) Load(ctx context.Context, page Page) (*Document, error) {
+	id := strings.TrimPrefix(page.ID, c.URL())
+	var content confluenceContent
+	if err := c.get(ctx, "/rest/api/content/"+url.PathEscape(id)+"?expand=body.storage,version,metadata.labels", &content); err != nil {
+		return nil, err
+	}
+	parsed, err := parseHTML(strings.NewReader(confluenceStorageHTML(content.Body.Storage.Value)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("confluence page %s: %w", id, err)
+	}
+	var sections []Section
+	if parsed.text != "" {
+		sections = []Section{{Text: parsed.text}}
+	}
+	doc := pageDocument(page, sections)
+	doc.Metadata["confluence_space"] = c.Space
+	var labels []string
+	for _, label := range content.Metadata.Labels.Results {
+		labels = append(labels, label.Name)
+	}
+	if len(labels) > 0 {
+		doc.Metadata["labels"] = strings.Join(labels, ",")
+	}
+	return doc, nil
+}
+
+// page describes content listed with the base URL of the site.
+func (c *Confluence) page(content confluenceContent, base string) Page {
+	if base == "" {
+		base = c.BaseURL
+	}
+	return Page{
+		ID:         c.URL() + content.ID,
+		Title:      content.Title,
+		URL:        base + content.Links.WebUI,
+		LastEdited: content.Version.When,
+		Version:    strconv.Itoa(content.Version.Number),
+	}
+}
+
+// get sends a GET request for path, relative to the base URL, and decodes
+// the JSON response into v. The path may already start with the context
+// path the base URL ends with, such as /wiki, as the API's next links do on
+// some servers.
+func (c *Confluence) get(ctx context.Context, path string, v any) error {
+	base, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return fmt.Errorf("CONFLUENCE_URL: %w", err)
+	}
+	if !strings.HasPrefix(path, base.Path+"/") {
+		path = base.Path + path
+	}
+	ref, err := url.Parse(path)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base.ResolveReference(ref).String(), nil)
+	if err != nil {
+		return err
+	}
+	if c.User != "" {
+		req.SetBasicAuth(c.User, c.Token)
+	} else if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	return doJSON(c.Client, req, v)
+}
+
+// cdata matches the CDATA sections in which the storage format keeps the
+// contents of code blocks, which an HTML parser would drop.
+var cdata = regexp.MustCompile(`(?s)<!\[CDATA\[(.*?)\]\]>`)
+
+// confluenceStorageHTML returns the storage format body of a page as HTML,
+// with its CDATA sections turned into preformatted text.
+func confluenceStorageHTML(body string) string {
+	return cdata.ReplaceAllStringFunc(body, func(s string) string {
+		return "<pre>" + html.EscapeString(cdata.FindStringSubmatch(s)[1]) + "</pre>"
+	})
+}
@@ -0,0 +1,169 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultCrawlPages is the number of pages a Crawler fetches at most.
+const DefaultCrawlPages = 100
+
+// maxPageSize bounds how much of a page the crawler reads.
+const maxPageSize = 10 << 20
+
+// A Crawler fetches a website breadth first from a seed URL and turns every
+// HTML page it reaches into a Document, as HTMLLoader does. Documents are
+// identified by their canonical URL, which is also recorded as "source" and
+// "url" metadata so answers can cite the page.
+type Crawler struct {
+	Client     *http.Client  // http.DefaultClient if nil
+	MaxDepth   int           // how many links away from the seed to follow; 0 fetches only the seed
+	MaxPages   int           // DefaultCrawlPages if zero
+	SameDomain bool          // only follow links to the seed's host
+	Delay      time.Duration // pause between requests
+	UserAgent  string
+	MaxSize    int64 // bytes above which pages are skipped, 0 for no limit below 10 MB
+
+	// OnError, if set, is called for pages other than the seed that could
+	// not be fetched or parsed. They are skipped either way.
+	OnError func(pageURL string, err error)
+}
+
+// Crawl fetches seed and the pages linked from it, calling fn with each
+// page's Document. It stops at the first error from fn or when the seed
+// itself cannot be fetched.
+func (c *Crawler) Crawl(ctx context.Context, seed string, fn func(*Document) error) error {
+	start, err := url.Parse(seed)
+	if err != nil {
+		return fmt.Errorf("crawl: %w", err)
+	}
+	if start.Scheme != "http" && start.Scheme != "https" {
+		return fmt.Errorf("crawl: %s is not an http or https URL", seed)
+	}
+	maxPages := c.MaxPages
+	if maxPages == 0 {
+		maxPages = DefaultCrawlPages
+	}
+	type link struct {
+		url   *url.URL
+		depth int
+	}
+	queue := []link{{normalizeURL(start), 0}}
+	seen := map[string]bool{queue[0].url.String(): true}
+	crawled := make(map[string]bool) // canonical URLs
+	for fetched := 0; len(queue) > 0 && fetched < maxPages; fetched++ {
+		next := queue[0]
+		queue = queue[1:]
+		if fetched > 0 && c.Delay > 0 {
+			select {
+			case <-time.After(c.Delay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		page, err := c.fetch(ctx, next.url)
+		if err != nil {
+			if next.depth == 0 || ctx.Err() != nil {
+				return fmt.Errorf("crawl %s: %w", next.url, err)
+			}
+			if c.OnError != nil {
+				c.OnError(next.url.String(), err)
+			}
+			continue
+		}
+		if next.depth < c.MaxDepth {
+			for _, u := range page.links {
+				u = normalizeURL(u)
+				if c.SameDomain && !strings.EqualFold(u.Hostname(), start.Hostname()) {
+					continue
+				}
+				if !seen[u.String()] {
+					seen[u.String()] = true
+					queue = append(queue, link{u, next.depth + 1})
+				}
+			}
+		}
+		// Several URLs can lead to one canonical page; keep its first visit
+		canonical := normalizeURL(page.canonical).String()
+		seen[canonical] = true
+		if crawled[canonical] {
+			continue
+		}
+		crawled[canonical] = true
+		if page.text == "" {
+			continue
+		}
+		doc := &Document{
+			ID:       canonical,
+			Sections: []Section{{Text: page.text}},
+			Metadata: Metadata{"source": canonical, "url": canonical},
+		}
+		if page.title != "" {
+			doc.Metadata["title"] = page.title
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetch downloads and parses one page. The page's canonical URL falls back
+// to the URL it was served from after redirects.
+func (c *Crawler) fetch(ctx context.Context, u *url.URL) (*htmlPage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, fmt.Errorf("unsupported content type %q", mediaType)
+	}
+	var body io.Reader = io.LimitReader(resp.Body, maxPageSize)
+	if c.MaxSize > 0 {
+		body = &limitedReader{r: body, n: c.MaxSize, name: u.String()}
+	}
+	page, err := parseHTML(body, resp.Request.URL)
+	if err != nil {
+		return nil, err
+	}
+	if page.canonical == nil {
+		page.canonical = resp.Request.URL
+	}
+	return page, nil
+}
+
+// normalizeURL returns a copy of u without its fragment and with a
+// lower-case host, so that equivalent links compare equal.
+func normalizeURL(u *url.URL) *url.URL {
+	n := *u
+	n.Fragment, n.RawFragment = "", ""
+	n.Host = strings.ToLower(n.Host)
+	if n.Path == "" {
+		n.Path = "/"
+	}
+	return &n
+}
@@ -0,0 +1,312 @@
+package rag
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+)
+
+const (
+	// DefaultDedupThreshold is the estimated Jaccard similarity from which
+	// chunks count as near duplicates.
+	DefaultDedupThreshold = 0.9
+
+	// minHashes is the length of MinHash signatures, split into
+	// minHashBands bands for locality-sensitive hashing. With 64 bands of 2
+	// rows, chunks more than a third similar almost surely share a band,
+	// while unrelated chunks rarely do; candidates are then compared
+	// signature by signature.
+	minHashes    = 128
+	minHashBands = 64
+	// shingleSize is how many consecutive words make a shingle.
+	shingleSize = 3
+)
+
+// minHashSeeds are the seeds of the hash functions of MinHash signatures.
+var minHashSeeds = func() [minHashes]uint64 {
+	var seeds [minHashes]uint64
+	x := uint64(0x5eed)
+	for i := range seeds {
+		x += 0x9e3779b97f4a7c15
+		seeds[i] = mix64(x)
+	}
+	return seeds
+}()
+
+// A Deduplicator finds chunks whose text repeats that of chunks already
+// ingested, such as page headers, license blocks and other boilerplate, so
+// that only the first copy is stored. Texts are compared after lower-casing
+// them and dropping punctuation, exactly or, if Threshold is positive, by
+// the Jaccard similarity of their three-word shingles, as estimated by
+// MinHash signatures: chunks at least Threshold similar are near
+// duplicates. Like KeywordIndex, it is kept in memory and keeps namespaces
+// apart, and if Source is set, the chunks of a namespace are read from it
+// and hashed again the first time the namespace is deduplicated, so that
+// chunks stored by earlier runs, or by other processes until then, are
+// found too.
+type Deduplicator struct {
+	Threshold float64
+	Source    ChunkStore
+
+	mu     sync.Mutex
+	spaces map[string]*dedupSpace
+}
+
+// dedupSpace is the index of one namespace.
+type dedupSpace struct {
+	loaded bool                   // from Source
+	chunks map[string]*dedupEntry // by chunk ID
+	docs   map[string][]string    // chunk IDs by document ID
+	exact  map[[32]byte][]string  // chunk IDs by text hash
+	bands  map[dedupBand][]string // chunk IDs by signature band
+}
+
+type dedupEntry struct {
+	docID     string
+	hash      [32]byte
+	signature *[minHashes]uint64 // nil unless near duplicates are found
+}
+
+// dedupBand identifies the values of a band of a MinHash signature.
+type dedupBand struct {
+	band int
+	key  uint64
+}
+
+// NewDeduplicator returns the Deduplicator selected by mode, which is off,
+// exact or near, or nil if it is off or empty. threshold applies to near.
+func NewDeduplicator(mode string, threshold float64) (*Deduplicator, error) {
+	switch mode {
+	case "", "off":
+		return nil, nil
+	case "exact":
+		return &Deduplicator{}, nil
+	case "near":
+		if threshold <= 0 || threshold > 1 {
+			return nil, fmt.Errorf("DEDUP_THRESHOLD must be above 0 and at most 1, got %v", threshold)
+		}
+		return &Deduplicator{Threshold: threshold}, nil
+	}
+	return nil, fmt.Errorf("unknown dedup mode %q", mode)
+}
+
+// Unique returns the chunks of a document that duplicate neither a chunk of
+// another document in the index nor an earlier chunk of their own, and
+// replaces the document's chunks in the index with them. It fails only if
+// the chunks of Source cannot be read.
+func (d *Deduplicator) Unique(ctx context.Context, docID string, chunks []Chunk) ([]Chunk, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	space := d.space(NamespaceFrom(ctx))
+	if err := d.load(ctx, space); err != nil {
+		return nil, err
+	}
+	space.remove(docID)
+	var unique []Chunk
+	for _, c := range chunks {
+		entry := d.entry(docID, c.Text)
+		if d.duplicate(space, entry) {
+			continue
+		}
+		space.add(c.ID, entry)
+		unique = append(unique, c)
+	}
+	return unique, nil
+}
+
+// entry returns the index entry of a chunk of a document with text.
+func (d *Deduplicator) entry(docID, text string) *dedupEntry {
+	entry := &dedupEntry{docID: docID, hash: textHash(text)}
+	if d.Threshold > 0 {
+		entry.signature = minHash(text)
+	}
+	return entry
+}
+
+// load adds the chunks Source holds in the namespace of ctx to space,
+// unless they were added before; d.mu must be held. Summaries are left
+// out, as they are not deduplicated.
+func (d *Deduplicator) load(ctx context.Context, space *dedupSpace) error {
+	if space.loaded || d.Source == nil {
+		return nil
+	}
+	docs, err := d.Source.Documents(ctx)
+	if err != nil {
+		return fmt.Errorf("dedup: %w", err)
+	}
+	for _, doc := range docs {
+		chunks, err := d.Source.Chunks(ctx, doc.ID)
+		if err != nil {
+			return fmt.Errorf("dedup: reading chunks of %s: %w", doc.ID, err)
+		}
+		for _, c := range chunks {
+			if _, ok := space.chunks[c.ID]; !ok && c.Metadata[SummaryLevelKey] == "" {
+				space.add(c.ID, d.entry(doc.ID, c.Text))
+			}
+		}
+	}
+	space.loaded = true
+	return nil
+}
+
+// Delete drops the chunks of a document from the index.
+func (d *Deduplicator) Delete(ctx context.Context, docID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.space(NamespaceFrom(ctx)).remove(docID)
+}
+
+// DeleteNamespace drops the index of a namespace.
+func (d *Deduplicator) DeleteNamespace(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.spaces, name)
+}
+
+// space returns the index of a namespace, creating it if needed; d.mu must
+// be held.
+func (d *Deduplicator) space(ns string) *dedupSpace {
+	if d.spaces == nil {
+		d.spaces = make(map[string]*dedupSpace)
+	}
+	space, ok := d.spaces[ns]
+	if !ok {
+		space = &dedupSpace{
+			chunks: make(map[string]*dedupEntry),
+			docs:   make(map[string][]string),
+			exact:  make(map[[32]byte][]string),
+			bands:  make(map[dedupBand][]string),
+		}
+		d.spaces[ns] = space
+	}
+	return space
+}
+
+// duplicate reports whether entry duplicates a chunk in space.
+func (d *Deduplicator) duplicate(space *dedupSpace, entry *dedupEntry) bool {
+	if len(space.exact[entry.hash]) > 0 {
+		return true
+	}
+	if entry.signature == nil {
+		return false
+	}
+	checked := make(map[string]bool)
+	for _, band := range signatureBands(entry.signature) {
+		for _, id := range space.bands[band] {
+			if checked[id] {
+				continue
+			}
+			checked[id] = true
+			if similarSignatures(entry.signature, space.chunks[id].signature) >= d.Threshold {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// textHash hashes the words of text, so that texts differing only in case,
+// punctuation and spacing are exact duplicates.
+func textHash(text string) [32]byte {
+	return sha256.Sum256([]byte(strings.Join(tokenize(text), " ")))
+}
+
+func (space *dedupSpace) add(id string, entry *dedupEntry) {
+	space.chunks[id] = entry
+	space.docs[entry.docID] = append(space.docs[entry.docID], id)
+	space.exact[entry.hash] = append(space.exact[entry.hash], id)
+	if entry.signature != nil {
+		for _, band := range signatureBands(entry.signature) {
+			space.bands[band] = append(space.bands[band], id)
+		}
+	}
+}
+
+func (space *dedupSpace) remove(docID string) {
+	for _, id := range space.docs[docID] {
+		entry := space.chunks[id]
+		delete(space.chunks, id)
+		space.exact[entry.hash] = removeID(space.exact[entry.hash], id)
+		if len(space.exact[entry.hash]) == 0 {
+			delete(space.exact, entry.hash)
+		}
+		if entry.signature != nil {
+			for _, band := range signatureBands(entry.signature) {
+				if space.bands[band] = removeID(space.bands[band], id); len(space.bands[band]) == 0 {
+					delete(space.bands, band)
+				}
+			}
+		}
+	}
+	delete(space.docs, docID)
+}
+
+// removeID removes id from ids in place.
+func removeID(ids []string, id string) []string {
+	for i, other := range ids {
+		if other == id {
+			return append(ids[:i], ids[i+1:]...)
+		}
+	}
+	return ids
+}
+
+// minHash computes the MinHash signature of the word shingles of text.
+func minHash(text string) *[minHashes]uint64 {
+	words := tokenize(text)
+	var signature [minHashes]uint64
+	for i := range signature {
+		signature[i] = ^uint64(0)
+	}
+	n := max(len(words)-shingleSize+1, 1)
+	for i := range n {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(words[i:min(i+shingleSize, len(words))], " ")))
+		base := h.Sum64()
+		for j, seed := range minHashSeeds {
+			signature[j] = min(signature[j], mix64(base^seed))
+		}
+	}
+	return &signature
+}
+
+// signatureBands returns the bands of a signature for locality-sensitive
+// hashing.
+func signatureBands(signature *[minHashes]uint64) [minHashBands]dedupBand {
+	const rows = minHashes / minHashBands
+	var bands [minHashBands]dedupBand
+	for b := range bands {
+		key := uint64(b)
+		for _, v := range signature[b*rows : (b+1)*rows] {
+			key = mix64(key ^ v)
+		}
+		bands[b] = dedupBand{band: b, key: key}
+	}
+	return bands
+}
+
+// similarSignatures estimates the Jaccard similarity of the shingles behind
+// two signatures as the fraction of equal values.
+func similarSignatures(a, b *[minHashes]uint64) float64 {
+	equal := 0
+	for i := range a {
+		if a[i] == b[i] {
+			equal++
+		}
+	}
+	return float64(equal) / minHashes
+}
+
+// mix64 is the finalizer of the SplitMix64 generator, a fast bijective hash
+// of 64-bit values.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,4 @@
+// Package rag contains the building blocks of a local Retrieval Augmented
+// Generation pipeline, as an alternative to the hosted vector store used by
+// the Assistants demo in the parent directory.
+package rag
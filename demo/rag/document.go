@@ -0,0 +1,40 @@
+package rag
+
+import "strings"
+
+// A Document is the text extracted from one source, split into sections
+// such as the pages of a PDF. Section metadata is merged over document
+// metadata on every chunk cut from that section.
+type Document struct {
+	ID       string    `json:"id"`
+	Sections []Section `json:"sections"`
+	Metadata Metadata  `json:"metadata,omitempty"`
+}
+
+// A Section is a contiguous part of a Document.
+type Section struct {
+	Text     string   `json:"text"`
+	Metadata Metadata `json:"metadata,omitempty"`
+}
+
+// Text returns the text of all sections separated by blank lines.
+func (d *Document) Text() string {
+	texts := make([]string, len(d.Sections))
+	for i, s := range d.Sections {
+		texts[i] = s.Text
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+// merge returns a copy of m with the entries of other added, overwriting
+// keys present in both.
+func (m Metadata) merge(other Metadata) Metadata {
+	merged := make(Metadata, len(m)+len(other))
+	for k, v := range m {
+		merged[k] = v
+	}
+	for k, v := range other {
+		merged[k] = v
+	}
+	return merged
+}
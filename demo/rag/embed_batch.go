@@ -0,0 +1,124 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	DefaultBatchSize   = 64
+	DefaultConcurrency = 4
+	DefaultRetries     = 2
+)
+
+// BatchFailure is an embedding batch that failed after all retries. Start
+// and End delimit the batch's texts in the embedded slice.
+type BatchFailure struct {
+	Start, End int
+	Err        error
+}
+
+// BatchError reports the batches that could not be embedded.
+type BatchError struct {
+	Batches  int
+	Failures []BatchFailure
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("embedding failed for %d of %d batches, texts %d-%d: %v",
+		len(e.Failures), e.Batches, e.Failures[0].Start, e.Failures[0].End-1, e.Failures[0].Err)
+}
+
+func (e *BatchError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, f := range e.Failures {
+		errs[i] = f.Err
+	}
+	return errs
+}
+
+// embedBatches embeds texts in batches of p.BatchSize with up to
+// p.Concurrency requests in flight, retrying each failed batch p.Retries
+// times with exponential backoff. Vectors of failed batches are left nil and
+// reported in a *BatchError.
+func (p *Pipeline) embedBatches(ctx context.Context, texts []string) ([][]float32, error) {
+	return embedInBatches(ctx, p, texts, p.Embedder.Embed)
+}
+
+// embedSparseBatches is like embedBatches for the pipeline's
+// SparseEmbedder.
+func (p *Pipeline) embedSparseBatches(ctx context.Context, texts []string) ([]*SparseVector, error) {
+	return embedInBatches(ctx, p, texts, func(ctx context.Context, texts []string) ([]*SparseVector, error) {
+		vectors, err := p.SparseEmbedder.EmbedSparse(ctx, texts)
+		pointers := make([]*SparseVector, len(vectors))
+		for i := range vectors {
+			pointers[i] = &vectors[i]
+		}
+		return pointers, err
+	})
+}
+
+// embedInBatches implements embedBatches with any embedding function.
+func embedInBatches[V any](ctx context.Context, p *Pipeline, texts []string, embed func(context.Context, []string) ([]V, error)) ([]V, error) {
+	size := p.BatchSize
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	concurrency := p.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+
+	vectors := make([]V, len(texts))
+	var mu sync.Mutex
+	batchErr := &BatchError{}
+	var g errgroup.Group
+	g.SetLimit(concurrency)
+	for start := 0; start < len(texts); start += size {
+		end := min(start+size, len(texts))
+		batchErr.Batches++
+		g.Go(func() error {
+			batch, err := embedWithRetry(ctx, texts[start:end], p.Retries, embed)
+			if err == nil && len(batch) != end-start {
+				err = fmt.Errorf("got %d embeddings for %d texts", len(batch), end-start)
+			}
+			if err != nil {
+				mu.Lock()
+				batchErr.Failures = append(batchErr.Failures, BatchFailure{Start: start, End: end, Err: err})
+				mu.Unlock()
+				return nil
+			}
+			copy(vectors[start:end], batch)
+			return nil
+		})
+	}
+	g.Wait()
+	if len(batchErr.Failures) > 0 {
+		slices.SortFunc(batchErr.Failures, func(a, b BatchFailure) int { return cmp.Compare(a.Start, b.Start) })
+		return vectors, batchErr
+	}
+	return vectors, nil
+}
+
+func embedWithRetry[V any](ctx context.Context, texts []string, retries int, embed func(context.Context, []string) ([]V, error)) ([]V, error) {
+	backoff := 500 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		vectors, err := embed(ctx, texts)
+		if err == nil || attempt >= retries || errors.Is(err, context.Canceled) {
+			return vectors, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,181 @@
+package rag
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var embedCacheMigrations = []string{
+	`CREATE TABLE embeddings (
+		key       BLOB PRIMARY KEY,
+		embedding BLOB NOT NULL
+	) WITHOUT ROWID`,
+}
+
+// An EmbeddingStore keeps the embeddings of a CachedEmbedder by key. Get
+// returns the embeddings of the given keys found, keyed by string(key).
+type EmbeddingStore interface {
+	Get(ctx context.Context, keys [][]byte) (map[string][]float32, error)
+	Put(ctx context.Context, embeddings map[string][]float32) error
+}
+
+// EmbeddingCache persists embeddings in a SQLite file, keyed by a hash of
+// the embedded text and the model that embedded it.
+type EmbeddingCache struct {
+	db *sql.DB
+}
+
+// NewEmbeddingCache opens or creates the cache file at path, creating its
+// directory if needed.
+func NewEmbeddingCache(ctx context.Context, path string) (*EmbeddingCache, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("embedding cache: %w", err)
+	}
+	db, err := openSQLite(ctx, path, embedCacheMigrations)
+	if err != nil {
+		return nil, fmt.Errorf("embedding cache: %w", err)
+	}
+	return &EmbeddingCache{db: db}, nil
+}
+
+// Close closes the cache file.
+func (c *EmbeddingCache) Close() error {
+	return c.db.Close()
+}
+
+// Get returns the cached embeddings of the given keys, omitting missing ones.
+func (c *EmbeddingCache) Get(ctx context.Context, keys [][]byte) (map[string][]float32, error) {
+	found := make(map[string][]float32)
+	// Stay well below SQLite's limit on the number of parameters
+	const batch = 500
+	for start := 0; start < len(keys); start += batch {
+		part := keys[start:min(start+batch, len(keys))]
+		args := make([]any, len(part))
+		for i, k := range part {
+			args[i] = k
+		}
+		rows, err := c.db.QueryContext(ctx, `SELECT key, embedding FROM embeddings
+			WHERE key IN (?`+strings.Repeat(", ?", len(args)-1)+`)`, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var key, embedding []byte
+			if err := rows.Scan(&key, &embedding); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			found[string(key)] = decodeVector(embedding)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+// Put stores embeddings by key.
+func (c *EmbeddingCache) Put(ctx context.Context, embeddings map[string][]float32) error {
+	return sqliteTx(ctx, c.db, func(tx *sql.Tx) error {
+		stmt, err := tx.PrepareContext(ctx, `INSERT OR REPLACE INTO embeddings (key, embedding) VALUES (?, ?)`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for key, v := range embeddings {
+			if _, err := stmt.ExecContext(ctx, []byte(key), encodeVector(v)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CachedEmbedder wraps an Embedder so that texts embedded before, during
+// an earlier ingest or as an identical query, are read from Cache instead.
+// Queries are cached apart from documents, which some models embed
+// differently. Model must identify the embedding model, as vectors from
+// different models are not interchangeable.
+type CachedEmbedder struct {
+	Embedder Embedder
+	Cache    EmbeddingStore
+	Model    string
+}
+
+func (e *CachedEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	query := isQueryEmbedding(ctx)
+	keys := make([][]byte, len(texts))
+	for i, t := range texts {
+		keys[i] = e.key(t, query)
+	}
+	cached, err := e.Cache.Get(ctx, keys)
+	if err != nil {
+		return nil, fmt.Errorf("embedding cache: %w", err)
+	}
+
+	// Embed each missing text once, even if it occurs several times
+	var missing []string
+	pending := make(map[string]bool)
+	for i, t := range texts {
+		key := string(keys[i])
+		if _, ok := cached[key]; !ok && !pending[key] {
+			pending[key] = true
+			missing = append(missing, t)
+		}
+	}
+	if len(missing) > 0 {
+		vectors, err := e.Embedder.Embed(ctx, missing)
+		if err != nil {
+			return nil, err
+		}
+		if len(vectors) != len(missing) {
+			return nil, fmt.Errorf("embedder returned %d vectors for %d texts", len(vectors), len(missing))
+		}
+		added := make(map[string][]float32, len(missing))
+		for i, t := range missing {
+			added[string(e.key(t, query))] = vectors[i]
+		}
+		if err := e.Cache.Put(ctx, added); err != nil {
+			return nil, fmt.Errorf("embedding cache: %w", err)
+		}
+		for key, v := range added {
+			cached[key] = v
+		}
+	}
+
+	vectors := make([][]float32, len(texts))
+	for i, key := range keys {
+		vectors[i] = cached[string(key)]
+	}
+	return vectors, nil
+}
+
+// key hashes the model and text, separated by a NUL byte, or by a 0x01
+// byte for a query, neither of which model names contain.
+func (e *CachedEmbedder) key(text string, query bool) []byte {
+	h := sha256.New()
+	h.Write([]byte(e.Model))
+	if query {
+		h.Write([]byte{1})
+	} else {
+		h.Write([]byte{0})
+	}
+	h.Write([]byte(text))
+	return h.Sum(nil)
+}
+
+// defaultEmbedCachePath returns the cache file in the user's cache
+// directory, or "" if there is none.
+func defaultEmbedCachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "go_rag_demo", "embeddings.db")
+}
@@ -0,0 +1,62 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+)
+
+// An Embedder turns text into embedding vectors. Implementations return one
+// vector per input text, in the same order as the inputs.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// NewEmbedder returns the Embedder selected by cfg.Embedder.
+func NewEmbedder(cfg Config) (Embedder, error) {
+	switch cfg.Embedder {
+	case "", "openai":
+		return NewOpenAIEmbedder(cfg.EmbeddingModel, openAIClientOptions(cfg)...), nil
+	case "ollama":
+		e := NewOllamaEmbedder(cfg.OllamaHost, cfg.EmbeddingModel)
+		e.Client = newProviderClient(cfg)
+		return e, nil
+	case "onnx":
+		return NewONNXEmbedder(cfg.ONNXModel, cfg.ONNXVocab, cfg.ONNXRuntime)
+	case "vertex":
+		e, err := NewVertexEmbedder(cfg.VertexProject, cfg.VertexLocation, cfg.EmbeddingModel)
+		if err != nil {
+			return nil, err
+		}
+		e.Client = newProviderClient(cfg)
+		return e, nil
+	case "bedrock":
+		e := NewBedrockEmbedder(cfg.S3Region, cfg.EmbeddingModel, awsKeys(cfg))
+		e.Client = newProviderClient(cfg)
+		return e, nil
+	case "hash":
+		return HashEmbedder{}, nil
+	}
+	return nil, fmt.Errorf("unknown embedder %q", cfg.Embedder)
+}
+
+// embeddingModel identifies the model used by the embedder selected by cfg,
+// e.g. "openai/text-embedding-3-small".
+func embeddingModel(cfg Config) string {
+	model := cfg.EmbeddingModel
+	switch cfg.Embedder {
+	case "", "openai":
+		return "openai/" + cmp.Or(model, DefaultOpenAIEmbeddingModel)
+	case "ollama":
+		return "ollama/" + cmp.Or(model, DefaultOllamaEmbeddingModel)
+	case "onnx":
+		return "onnx/" + cfg.ONNXModel
+	case "vertex":
+		return "vertex/" + cmp.Or(model, DefaultVertexEmbeddingModel)
+	case "bedrock":
+		return "bedrock/" + cmp.Or(model, DefaultBedrockEmbeddingModel)
+	case "hash":
+		return "hash"
+	}
+	return cfg.Embedder + "/" + model
+}
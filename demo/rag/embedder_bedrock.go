@@ -0,0 +1,92 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultBedrockEmbeddingModel is used when no embedding model is
+// configured.
+const DefaultBedrockEmbeddingModel = "amazon.titan-embed-text-v2:0"
+
+// BedrockEmbedder embeds text with an embedding model on Amazon Bedrock:
+// an Amazon Titan model, which embeds one text per request, or a Cohere
+// one, whose model ID contains "cohere." and which embeds queries apart
+// from documents.
+type BedrockEmbedder struct {
+	bedrockAPI
+
+	model string
+}
+
+// NewBedrockEmbedder creates a BedrockEmbedder calling Bedrock in region,
+// DefaultBedrockRegion if empty, signed with keys or, if they are unset,
+// the credentials of the environment.
+func NewBedrockEmbedder(region, model string, keys AWSCredentials) *BedrockEmbedder {
+	return &BedrockEmbedder{bedrockAPI: newBedrockAPI(region, keys), model: cmp.Or(model, DefaultBedrockEmbeddingModel)}
+}
+
+func (e *BedrockEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if strings.Contains(e.model, "cohere.") {
+		return e.embedCohere(ctx, texts)
+	}
+	vectors := make([][]float32, len(texts))
+	tokens := 0
+	for i, t := range texts {
+		resp, err := e.post(ctx, e.model, "invoke", map[string]any{"inputText": t})
+		if err != nil {
+			return nil, err
+		}
+		var res struct {
+			Embedding           []float32 `json:"embedding"`
+			InputTextTokenCount int       `json:"inputTextTokenCount"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("bedrock: decoding response: %w", err)
+		}
+		vectors[i] = res.Embedding
+		tokens += res.InputTextTokenCount
+	}
+	reportUsage(ctx, Usage{Model: e.model, EmbeddingTokens: tokens})
+	return vectors, nil
+}
+
+// embedCohere embeds texts in requests of 96, the most Cohere takes. Its
+// replies do not count tokens, which Bedrock returns in a header instead.
+func (e *BedrockEmbedder) embedCohere(ctx context.Context, texts []string) ([][]float32, error) {
+	inputType := "search_document"
+	if isQueryEmbedding(ctx) {
+		inputType = "search_query"
+	}
+	vectors := make([][]float32, 0, len(texts))
+	tokens := 0
+	for start := 0; start < len(texts); start += 96 {
+		part := texts[start:min(start+96, len(texts))]
+		resp, err := e.post(ctx, e.model, "invoke", map[string]any{"texts": part, "input_type": inputType})
+		if err != nil {
+			return nil, err
+		}
+		count, _ := strconv.Atoi(resp.Header.Get("X-Amzn-Bedrock-Input-Token-Count"))
+		tokens += count
+		var res struct {
+			Embeddings [][]float32 `json:"embeddings"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("bedrock: decoding response: %w", err)
+		}
+		if len(res.Embeddings) != len(part) {
+			return nil, fmt.Errorf("bedrock: got %d embeddings for %d inputs", len(res.Embeddings), len(part))
+		}
+		vectors = append(vectors, res.Embeddings...)
+	}
+	reportUsage(ctx, Usage{Model: e.model, EmbeddingTokens: tokens})
+	return vectors, nil
+}
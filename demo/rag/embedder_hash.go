@@ -0,0 +1,56 @@
+package rag
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// DefaultHashDimensions is the length of the vectors of a HashEmbedder
+// without Dimensions.
+const DefaultHashDimensions = 256
+
+// HashEmbedder embeds texts without a model, by hashing each of their words
+// to a dimension of the vector, so that texts sharing words are similar.
+// It captures no meaning, but needs no provider and always returns the same
+// vectors for the same texts, which suits golden prompt tests and offline
+// demos.
+type HashEmbedder struct {
+	Dimensions int // DefaultHashDimensions if zero
+}
+
+func (e HashEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	dims := e.Dimensions
+	if dims <= 0 {
+		dims = DefaultHashDimensions
+	}
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		v := make([]float32, dims)
+		for word := range strings.FieldsFuncSeq(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+			h := fnv.New64a()
+			h.Write([]byte(word))
+			sum := h.Sum64()
+			// The top bit gives the sign, so that collisions tend to cancel
+			if sum>>63 == 0 {
+				v[sum%uint64(dims)]++
+			} else {
+				v[sum%uint64(dims)]--
+			}
+		}
+		var norm float64
+		for _, x := range v {
+			norm += float64(x) * float64(x)
+		}
+		if norm > 0 {
+			scale := float32(1 / math.Sqrt(norm))
+			for j := range v {
+				v[j] *= scale
+			}
+		}
+		vectors[i] = v
+	}
+	return vectors, nil
+}
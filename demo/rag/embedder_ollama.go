@@ -0,0 +1,68 @@
+package rag
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// DefaultOllamaEmbeddingModel is used when no embedding model is configured.
+const DefaultOllamaEmbeddingModel = "nomic-embed-text"
+
+// OllamaEmbedder embeds text with a model served by a local Ollama instance.
+type OllamaEmbedder struct {
+	Client *http.Client // http.DefaultClient if nil
+
+	host  string
+	model string
+}
+
+// NewOllamaEmbedder creates an OllamaEmbedder talking to the Ollama server at
+// host, e.g. http://localhost:11434.
+func NewOllamaEmbedder(host, model string) *OllamaEmbedder {
+	if model == "" {
+		model = DefaultOllamaEmbeddingModel
+	}
+	return &OllamaEmbedder{host: strings.TrimRight(host, "/"), model: model}
+}
+
+func (e *OllamaEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	body, err := json.Marshal(map[string]any{"model": e.model, "input": texts})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.host+"/api/embed", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := cmp.Or(e.Client, http.DefaultClient).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var res struct {
+		Embeddings      [][]float32 `json:"embeddings"`
+		Error           string      `json:"error"`
+		PromptEvalCount int         `json:"prompt_eval_count"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("ollama: decoding response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ollama: %s: %s", resp.Status, res.Error)
+	}
+	if len(res.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("ollama: got %d embeddings for %d inputs", len(res.Embeddings), len(texts))
+	}
+	reportUsage(ctx, Usage{Model: e.model, EmbeddingTokens: res.PromptEvalCount})
+	return res.Embeddings, nil
+}
@@ -0,0 +1,223 @@
+//go:build onnx
+
+package rag
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+
+	ort "github.com/yalue/onnxruntime_go"
+)
+
+// onnxMaxTokens bounds the sequence length fed to the model; BERT-style
+// sentence-transformers are trained on at most 512 positions.
+const onnxMaxTokens = 256
+
+// ONNXEmbedder runs a sentence-transformers model (e.g. all-MiniLM-L6-v2
+// exported to ONNX) locally. Token embeddings are mean pooled over the
+// attention mask and L2 normalized.
+type ONNXEmbedder struct {
+	mu      sync.Mutex
+	session *ort.DynamicAdvancedSession
+	vocab   map[string]int64
+}
+
+// NewONNXEmbedder loads the model at modelPath and its WordPiece vocabulary.
+// runtimeLib optionally points at the onnxruntime shared library.
+func NewONNXEmbedder(modelPath, vocabPath, runtimeLib string) (*ONNXEmbedder, error) {
+	if modelPath == "" || vocabPath == "" {
+		return nil, fmt.Errorf("onnx: ONNX_MODEL and ONNX_VOCAB must be set")
+	}
+	vocab, err := loadVocab(vocabPath)
+	if err != nil {
+		return nil, err
+	}
+	if !ort.IsInitialized() {
+		if runtimeLib != "" {
+			ort.SetSharedLibraryPath(runtimeLib)
+		}
+		if err := ort.InitializeEnvironment(); err != nil {
+			return nil, fmt.Errorf("onnx: initializing runtime: %w", err)
+		}
+	}
+	session, err := ort.NewDynamicAdvancedSession(modelPath,
+		[]string{"input_ids", "attention_mask", "token_type_ids"},
+		[]string{"last_hidden_state"}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("onnx: loading %s: %w", modelPath, err)
+	}
+	return &ONNXEmbedder{session: session, vocab: vocab}, nil
+}
+
+// Close releases the model session.
+func (e *ONNXEmbedder) Close() error {
+	return e.session.Destroy()
+}
+
+func (e *ONNXEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	// Pad every sequence in the batch to the longest one
+	batch := make([][]int64, len(texts))
+	seqLen := 0
+	for i, text := range texts {
+		batch[i] = e.tokenize(text)
+		seqLen = max(seqLen, len(batch[i]))
+	}
+	shape := ort.NewShape(int64(len(texts)), int64(seqLen))
+	ids := make([]int64, len(texts)*seqLen)
+	mask := make([]int64, len(texts)*seqLen)
+	for i, tokens := range batch {
+		for j, id := range tokens {
+			ids[i*seqLen+j] = id
+			mask[i*seqLen+j] = 1
+		}
+	}
+	idsTensor, err := ort.NewTensor(shape, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer idsTensor.Destroy()
+	maskTensor, err := ort.NewTensor(shape, mask)
+	if err != nil {
+		return nil, err
+	}
+	defer maskTensor.Destroy()
+	typesTensor, err := ort.NewTensor(shape, make([]int64, len(ids)))
+	if err != nil {
+		return nil, err
+	}
+	defer typesTensor.Destroy()
+
+	outputs := []ort.Value{nil}
+	e.mu.Lock()
+	err = e.session.Run([]ort.Value{idsTensor, maskTensor, typesTensor}, outputs)
+	e.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("onnx: running model: %w", err)
+	}
+	defer outputs[0].Destroy()
+	hidden, ok := outputs[0].(*ort.Tensor[float32])
+	if !ok {
+		return nil, fmt.Errorf("onnx: unexpected output type %T", outputs[0])
+	}
+	outShape := hidden.GetShape()
+	if len(outShape) != 3 {
+		return nil, fmt.Errorf("onnx: unexpected output shape %v", outShape)
+	}
+	dim := int(outShape[2])
+	data := hidden.GetData()
+
+	// Mean pool over real (unpadded) tokens, then normalize
+	vectors := make([][]float32, len(texts))
+	for i, tokens := range batch {
+		vector := make([]float32, dim)
+		for j := range tokens {
+			offset := (i*seqLen + j) * dim
+			for k := 0; k < dim; k++ {
+				vector[k] += data[offset+k]
+			}
+		}
+		var norm float64
+		for k := range vector {
+			vector[k] /= float32(len(tokens))
+			norm += float64(vector[k]) * float64(vector[k])
+		}
+		if norm > 0 {
+			scale := float32(1 / math.Sqrt(norm))
+			for k := range vector {
+				vector[k] *= scale
+			}
+		}
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
+
+// tokenize applies uncased BERT basic tokenization followed by greedy
+// longest-match WordPiece, wrapped in [CLS] ... [SEP].
+func (e *ONNXEmbedder) tokenize(text string) []int64 {
+	unk := e.vocab["[UNK]"]
+	tokens := []int64{e.vocab["[CLS]"]}
+	for _, word := range splitWords(strings.ToLower(text)) {
+		runes := []rune(word)
+		for start := 0; start < len(runes); {
+			end := len(runes)
+			id, found := int64(0), false
+			for ; end > start; end-- {
+				piece := string(runes[start:end])
+				if start > 0 {
+					piece = "##" + piece
+				}
+				if id, found = e.vocab[piece]; found {
+					break
+				}
+			}
+			if !found {
+				tokens = append(tokens, unk)
+				break
+			}
+			tokens = append(tokens, id)
+			start = end
+		}
+		if len(tokens) >= onnxMaxTokens-1 {
+			tokens = tokens[:onnxMaxTokens-1]
+			break
+		}
+	}
+	return append(tokens, e.vocab["[SEP]"])
+}
+
+// splitWords splits on whitespace and isolates punctuation as its own word.
+func splitWords(text string) []string {
+	var words []string
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			words = append(words, current.String())
+			current.Reset()
+		}
+	}
+	for _, r := range text {
+		switch {
+		case unicode.IsSpace(r):
+			flush()
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			flush()
+			words = append(words, string(r))
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+	return words
+}
+
+func loadVocab(path string) (map[string]int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	vocab := make(map[string]int64)
+	scanner := bufio.NewScanner(f)
+	for id := int64(0); scanner.Scan(); id++ {
+		vocab[scanner.Text()] = id
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for _, special := range []string{"[CLS]", "[SEP]", "[UNK]"} {
+		if _, ok := vocab[special]; !ok {
+			return nil, fmt.Errorf("onnx: vocab %s is missing %s", path, special)
+		}
+	}
+	return vocab, nil
+}
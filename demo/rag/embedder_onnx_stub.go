@@ -0,0 +1,24 @@
+//go:build !onnx
+
+package rag
+
+import (
+	"context"
+	"errors"
+)
+
+var errNoONNX = errors.New("onnx: this binary was built without ONNX support; rebuild with -tags onnx")
+
+// ONNXEmbedder is unavailable in builds without the onnx tag.
+type ONNXEmbedder struct{}
+
+// NewONNXEmbedder always fails in builds without the onnx tag.
+func NewONNXEmbedder(modelPath, vocabPath, runtimeLib string) (*ONNXEmbedder, error) {
+	return nil, errNoONNX
+}
+
+func (e *ONNXEmbedder) Close() error { return errNoONNX }
+
+func (e *ONNXEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	return nil, errNoONNX
+}
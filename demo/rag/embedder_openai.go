@@ -0,0 +1,50 @@
+package rag
+
+import (
+	"context"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// DefaultOpenAIEmbeddingModel is used when no embedding model is configured.
+const DefaultOpenAIEmbeddingModel = openai.EmbeddingModelTextEmbedding3Small
+
+// OpenAIEmbedder embeds text with the OpenAI embeddings API. The API key is
+// read from OPENAI_API_KEY unless overridden by a request option.
+type OpenAIEmbedder struct {
+	client *openai.Client
+	model  string
+}
+
+// NewOpenAIEmbedder creates an OpenAIEmbedder for the given model.
+func NewOpenAIEmbedder(model string, opts ...option.RequestOption) *OpenAIEmbedder {
+	if model == "" {
+		model = DefaultOpenAIEmbeddingModel
+	}
+	return &OpenAIEmbedder{client: openai.NewClient(opts...), model: model}
+}
+
+func (e *OpenAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	res, err := e.client.Embeddings.New(ctx, openai.EmbeddingNewParams{
+		Input:          openai.F[openai.EmbeddingNewParamsInputUnion](openai.EmbeddingNewParamsInputArrayOfStrings(texts)),
+		Model:          openai.F(e.model),
+		EncodingFormat: openai.F(openai.EmbeddingNewParamsEncodingFormatFloat),
+	})
+	if err != nil {
+		return nil, err
+	}
+	reportUsage(ctx, Usage{Model: e.model, EmbeddingTokens: int(res.Usage.PromptTokens)})
+	vectors := make([][]float32, len(texts))
+	for _, data := range res.Data {
+		vector := make([]float32, len(data.Embedding))
+		for i, v := range data.Embedding {
+			vector[i] = float32(v)
+		}
+		vectors[data.Index] = vector
+	}
+	return vectors, nil
+}
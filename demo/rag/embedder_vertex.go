@@ -0,0 +1,85 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// DefaultVertexEmbeddingModel is used when no embedding model is configured.
+const DefaultVertexEmbeddingModel = "text-embedding-005"
+
+// VertexEmbedder embeds text with a Google embedding model on Vertex AI,
+// through its predict method.
+type VertexEmbedder struct {
+	vertexAPI
+
+	model string
+}
+
+// NewVertexEmbedder creates a VertexEmbedder calling Vertex AI in the given
+// Google Cloud project and location, DefaultVertexLocation if empty.
+func NewVertexEmbedder(project, location, model string) (*VertexEmbedder, error) {
+	api, err := newVertexAPI(project, location)
+	if err != nil {
+		return nil, err
+	}
+	return &VertexEmbedder{vertexAPI: api, model: cmp.Or(model, DefaultVertexEmbeddingModel)}, nil
+}
+
+// Embed sends texts in requests of as many as the model takes: 250 for the
+// text-embedding models and one for the Gemini ones.
+func (e *VertexEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	batch := 250
+	if strings.HasPrefix(e.model, "gemini-") {
+		batch = 1
+	}
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += batch {
+		part, err := e.embed(ctx, texts[start:min(start+batch, len(texts))])
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, part...)
+	}
+	return vectors, nil
+}
+
+func (e *VertexEmbedder) embed(ctx context.Context, texts []string) ([][]float32, error) {
+	instances := make([]map[string]string, len(texts))
+	for i, t := range texts {
+		instances[i] = map[string]string{"content": t}
+	}
+	resp, err := e.post(ctx, url.PathEscape(e.model)+":predict", map[string]any{"instances": instances})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var res struct {
+		Predictions []struct {
+			Embeddings struct {
+				Values     []float32 `json:"values"`
+				Statistics struct {
+					TokenCount int `json:"token_count"`
+				} `json:"statistics"`
+			} `json:"embeddings"`
+		} `json:"predictions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("vertex: decoding response: %w", err)
+	}
+	if len(res.Predictions) != len(texts) {
+		return nil, fmt.Errorf("vertex: got %d embeddings for %d inputs", len(res.Predictions), len(texts))
+	}
+	vectors := make([][]float32, len(texts))
+	tokens := 0
+	for i, p := range res.Predictions {
+		vectors[i] = p.Embeddings.Values
+		tokens += p.Embeddings.Statistics.TokenCount
+	}
+	reportUsage(ctx, Usage{Model: e.model, EmbeddingTokens: tokens})
+	return vectors, nil
+}
@@ -0,0 +1,173 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errorDomain is the domain of the ErrorInfo details of gRPC errors.
+const errorDomain = "rag"
+
+// CodeInternal is the ErrorCode of errors outside the taxonomy below.
+const CodeInternal = "internal"
+
+var (
+	// ErrInvalidRequest is returned for a request that is malformed or has
+	// invalid arguments, such as an empty question or a filter that does not
+	// parse.
+	ErrInvalidRequest = errors.New("invalid request")
+	// ErrDocumentNotFound is returned for a document ID that is not in the
+	// vector store.
+	ErrDocumentNotFound = errors.New("document not found")
+	// ErrNotEnabled is returned for an operation of a feature that is
+	// turned off, such as feedback without a FeedbackStore.
+	ErrNotEnabled = errors.New("not enabled")
+	// ErrNotSupported is returned for an operation the configured providers
+	// cannot carry out, such as listing the chunks of a vector store that
+	// does not keep them.
+	ErrNotSupported = errors.New("not supported")
+	// ErrContextTooLarge is returned when a prompt does not fit the context
+	// window, be it the pipeline's Budget or the model's own.
+	ErrContextTooLarge = errors.New("context too large")
+	// ErrEmbeddingProvider marks the errors of the pipeline's Embedder.
+	ErrEmbeddingProvider = errors.New("embedding provider failed")
+	// ErrLLMProvider marks the errors of the pipeline's LLM.
+	ErrLLMProvider = errors.New("llm provider failed")
+)
+
+// errorKinds lists the errors ErrorCode tells apart, with their codes and
+// the statuses they are reported with over HTTP and gRPC. More specific
+// errors come first, since an error can be several at once, e.g. a file too
+// large in an invalid request, or a provider request that ran out of time.
+var errorKinds = []struct {
+	err  error
+	code string
+	http int
+	grpc codes.Code
+}{
+	{ErrDocumentNotFound, "document_not_found", http.StatusNotFound, codes.NotFound},
+	{ErrNamespaceNotFound, "namespace_not_found", http.StatusNotFound, codes.NotFound},
+	{ErrJobNotFound, "job_not_found", http.StatusNotFound, codes.NotFound},
+	{ErrAnswerNotFound, "answer_not_found", http.StatusNotFound, codes.NotFound},
+	{ErrAPIKeyNotFound, "api_key_not_found", http.StatusNotFound, codes.NotFound},
+	{ErrForbidden, "forbidden", http.StatusForbidden, codes.PermissionDenied},
+	{ErrNamespaceExists, "namespace_exists", http.StatusConflict, codes.AlreadyExists},
+	{ErrModelMismatch, "embedding_model_mismatch", http.StatusConflict, codes.FailedPrecondition},
+	{ErrUnauthenticated, "unauthenticated", http.StatusUnauthorized, codes.Unauthenticated},
+	{ErrRateLimited, "rate_limited", http.StatusTooManyRequests, codes.ResourceExhausted},
+	{ErrQuotaExceeded, "quota_exceeded", http.StatusTooManyRequests, codes.ResourceExhausted},
+	{ErrFileTooLarge, "file_too_large", http.StatusRequestEntityTooLarge, codes.InvalidArgument},
+	{ErrNoLoader, "unsupported_file_type", http.StatusUnsupportedMediaType, codes.InvalidArgument},
+	{ErrNotEnabled, "not_enabled", http.StatusNotFound, codes.Unimplemented},
+	{ErrNotSupported, "not_supported", http.StatusNotImplemented, codes.Unimplemented},
+	{ErrContextTooLarge, "context_too_large", http.StatusRequestEntityTooLarge, codes.InvalidArgument},
+	{context.DeadlineExceeded, "timeout", http.StatusGatewayTimeout, codes.DeadlineExceeded},
+	{ErrEmbeddingProvider, "embedding_provider_error", http.StatusBadGateway, codes.Unavailable},
+	{ErrLLMProvider, "llm_provider_error", http.StatusBadGateway, codes.Unavailable},
+	{ErrInvalidRequest, "invalid_request", http.StatusBadRequest, codes.InvalidArgument},
+}
+
+// ErrorCode returns the machine-readable code of err, such as
+// "document_not_found" for an ErrDocumentNotFound, by which the HTTP and
+// gRPC APIs report it, or CodeInternal for an error of no other kind.
+func ErrorCode(err error) string {
+	for _, k := range errorKinds {
+		if errors.Is(err, k.err) {
+			return k.code
+		}
+	}
+	return CodeInternal
+}
+
+// errorStatus returns the HTTP status for an error of the pipeline.
+func errorStatus(err error) int {
+	for _, k := range errorKinds {
+		if errors.Is(err, k.err) {
+			return k.http
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+// grpcError converts an error of the pipeline to a status error, with its
+// ErrorCode as the reason of an ErrorInfo detail.
+func grpcError(err error) error {
+	code := codes.Internal
+	for _, k := range errorKinds {
+		if errors.Is(err, k.err) {
+			code = k.grpc
+			break
+		}
+	}
+	st := status.New(code, err.Error())
+	if withInfo, infoErr := st.WithDetails(&errdetails.ErrorInfo{Reason: ErrorCode(err), Domain: errorDomain}); infoErr == nil {
+		st = withInfo
+	}
+	return st.Err()
+}
+
+// kindError is an error that is also of a kind of the taxonomy, keeping
+// its own message.
+type kindError struct {
+	err, kind error
+}
+
+func (e *kindError) Error() string   { return e.err.Error() }
+func (e *kindError) Unwrap() []error { return []error{e.err, e.kind} }
+
+// withKind returns err marked as of kind, or err itself if it is nil or of
+// that kind already.
+func withKind(kind, err error) error {
+	if err == nil || errors.Is(err, kind) {
+		return err
+	}
+	return &kindError{err: err, kind: kind}
+}
+
+// invalidRequest returns an ErrInvalidRequest with the given message.
+func invalidRequest(format string, args ...any) error {
+	return withKind(ErrInvalidRequest, fmt.Errorf(format, args...))
+}
+
+// contextOverflowMessages are what the errors of providers rejecting a
+// prompt longer than their model's context window say.
+var contextOverflowMessages = []string{
+	"context_length_exceeded",
+	"maximum context length",
+	"context window",
+	"exceeds the available context size",
+	"prompt is too long",
+	"input is too long",
+	"maximum number of tokens",
+}
+
+// providerError marks an error of a provider with kind, with
+// context.DeadlineExceeded if its request timed out, as those of an HTTP
+// client with a Timeout do without saying so, and with ErrContextTooLarge
+// if it says the prompt was too long. Cancellations are passed on as they
+// are, as they are not the provider's doing.
+func providerError(kind, err error) error {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return err
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		err = withKind(context.DeadlineExceeded, err)
+	}
+	message := strings.ToLower(err.Error())
+	for _, m := range contextOverflowMessages {
+		if strings.Contains(message, m) {
+			err = withKind(ErrContextTooLarge, err)
+			break
+		}
+	}
+	return withKind(kind, err)
+}
@@ -0,0 +1,257 @@
+package rag
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// An EvalCase is a question with a reference answer and the IDs of the
+// documents that contain it.
+type EvalCase struct {
+	Question string   `json:"question"`
+	Answer   string   `json:"answer,omitempty"`
+	DocIDs   []string `json:"doc_ids"`
+}
+
+// EvalCaseResult holds the metrics for one EvalCase. Faithfulness is only
+// set when the answer was judged, and Correctness when it was judged
+// against a reference answer. Seconds is how long answering the question,
+// or only retrieving for it, took, and Cost what the answer cost.
+type EvalCaseResult struct {
+	EvalCase
+	Retrieved      []string `json:"retrieved"` // distinct document IDs, best first
+	Recall         float64  `json:"recall"`
+	ReciprocalRank float64  `json:"reciprocal_rank"`
+	Generated      string   `json:"generated,omitempty"`
+	Faithfulness   *float64 `json:"faithfulness,omitempty"`
+	Correctness    *float64 `json:"correctness,omitempty"`
+	Reason         string   `json:"reason,omitempty"`
+	Seconds        float64  `json:"seconds"`
+	Cost           float64  `json:"cost,omitempty"`
+	Error          string   `json:"error,omitempty"`
+}
+
+// EvalReport summarizes an evaluation run. Recall is recall@K and MRR the
+// mean reciprocal rank of the first relevant document, both averaged over
+// all cases; Faithfulness is averaged over the Judged cases and
+// Correctness over those of them with a reference answer. Seconds is the
+// mean time per case and Cost the total cost of the answers.
+type EvalReport struct {
+	K            int              `json:"k"`
+	Cases        []EvalCaseResult `json:"cases"`
+	Recall       float64          `json:"recall"`
+	MRR          float64          `json:"mrr"`
+	Judged       int              `json:"judged"`
+	Faithfulness float64          `json:"faithfulness"`
+	Correctness  float64          `json:"correctness"`
+	Seconds      float64          `json:"seconds"`
+	Cost         float64          `json:"cost"`
+}
+
+// An Evaluator measures how well a pipeline retrieves and answers. With a
+// Judge, the pipeline also answers every question and the judge rates each
+// answer's faithfulness to the retrieved context and its agreement with the
+// reference answer; otherwise only retrieval is measured.
+type Evaluator struct {
+	Pipeline *Pipeline
+	Judge    LLM // nil to skip answer metrics
+	K        int // DefaultTopK if zero
+}
+
+const judgePrompt = `You grade answers written by an assistant that answers questions from numbered context passages.
+Rate the assistant's answer on two scales from 0 to 1:
+- faithfulness: 1 if every claim in the answer is supported by the context, 0 if none is.
+- correctness: 1 if the answer agrees with the reference answer, 0 if it contradicts it or misses it entirely.
+Reply with JSON only, in the form {"faithfulness": 0.5, "correctness": 0.5, "reason": "one sentence"}.`
+
+// Run evaluates every case. A failure to retrieve aborts the run; a failure
+// to answer or judge a case is recorded in its Error and leaves it unjudged.
+func (e *Evaluator) Run(ctx context.Context, cases []EvalCase) (*EvalReport, error) {
+	k := e.K
+	if k <= 0 {
+		k = DefaultTopK
+	}
+	report := &EvalReport{K: k, Cases: make([]EvalCaseResult, len(cases))}
+	referenced := 0
+	for i, c := range cases {
+		r := &report.Cases[i]
+		r.EvalCase = c
+		var answer *Answer
+		start := time.Now()
+		if e.Judge != nil {
+			a, err := e.Pipeline.Query(ctx, QueryRequest{Question: c.Question, K: k})
+			if err != nil {
+				r.Error = err.Error()
+			} else {
+				answer = a
+				for _, s := range a.Sources {
+					r.Retrieved = append(r.Retrieved, s.DocID)
+				}
+				if a.Usage != nil {
+					r.Cost = a.Usage.Cost
+				}
+			}
+		}
+		if answer == nil {
+			start = time.Now()
+			results, err := e.Pipeline.Retriever.Retrieve(ctx, c.Question, k, nil)
+			if err != nil {
+				return nil, fmt.Errorf("case %d: %w", i+1, err)
+			}
+			for _, res := range results {
+				r.Retrieved = append(r.Retrieved, res.DocID)
+			}
+		}
+		r.Seconds = time.Since(start).Seconds()
+		report.Seconds += r.Seconds
+		report.Cost += r.Cost
+		r.Retrieved = distinctDocIDs(r.Retrieved)
+		r.Recall, r.ReciprocalRank = retrievalMetrics(r.Retrieved, c.DocIDs)
+		report.Recall += r.Recall
+		report.MRR += r.ReciprocalRank
+		if answer == nil {
+			continue
+		}
+		r.Generated = answer.Answer
+		if err := e.judge(ctx, r, answer); err != nil {
+			r.Error = err.Error()
+			continue
+		}
+		report.Judged++
+		report.Faithfulness += *r.Faithfulness
+		if r.Correctness != nil {
+			report.Correctness += *r.Correctness
+			referenced++
+		}
+	}
+	if n := float64(len(cases)); n > 0 {
+		report.Recall /= n
+		report.MRR /= n
+		report.Seconds /= n
+	}
+	if report.Judged > 0 {
+		report.Faithfulness /= float64(report.Judged)
+	}
+	if referenced > 0 {
+		report.Correctness /= float64(referenced)
+	}
+	return report, nil
+}
+
+func (e *Evaluator) judge(ctx context.Context, r *EvalCaseResult, answer *Answer) error {
+	var prompt strings.Builder
+	prompt.WriteString("Context:\n")
+	for i, s := range answer.Sources {
+		fmt.Fprintf(&prompt, "[%d] %s\n\n", i+1, s.Text)
+	}
+	reference := r.Answer
+	if reference == "" {
+		reference = "(none given)"
+	}
+	fmt.Fprintf(&prompt, "Question: %s\n\nReference answer: %s\n\nAssistant's answer: %s", r.Question, reference, answer.Answer)
+	reply, err := e.Judge.Generate(ctx, []Message{
+		{Role: RoleSystem, Content: judgePrompt},
+		{Role: RoleUser, Content: prompt.String()},
+	})
+	if err != nil {
+		return fmt.Errorf("judging answer: %w", err)
+	}
+	var verdict struct {
+		Faithfulness *float64 `json:"faithfulness"`
+		Correctness  *float64 `json:"correctness"`
+		Reason       string   `json:"reason"`
+	}
+	if err := parseVerdict(reply, &verdict); err != nil {
+		return err
+	}
+	if verdict.Faithfulness == nil || (verdict.Correctness == nil && r.Answer != "") {
+		return fmt.Errorf("judge reply is missing scores: %q", reply)
+	}
+	clamp := func(v float64) *float64 {
+		v = min(max(v, 0), 1)
+		return &v
+	}
+	r.Faithfulness, r.Reason = clamp(*verdict.Faithfulness), verdict.Reason
+	if r.Answer != "" {
+		r.Correctness = clamp(*verdict.Correctness)
+	}
+	return nil
+}
+
+// parseVerdict decodes the JSON object in a reply of the judge into v.
+func parseVerdict(reply string, v any) error {
+	if err := decodeJSONReply(reply, v); err != nil {
+		return fmt.Errorf("judge %w", err)
+	}
+	return nil
+}
+
+// distinctDocIDs removes repeated document IDs, keeping the first.
+func distinctDocIDs(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	var distinct []string
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			distinct = append(distinct, id)
+		}
+	}
+	return distinct
+}
+
+// retrievalMetrics returns the fraction of relevant documents that were
+// retrieved and the reciprocal rank of the first one.
+func retrievalMetrics(retrieved, relevant []string) (recall, reciprocalRank float64) {
+	if len(relevant) == 0 {
+		return 0, 0
+	}
+	want := make(map[string]bool, len(relevant))
+	for _, id := range relevant {
+		want[id] = true
+	}
+	found := 0
+	for i, id := range retrieved {
+		if want[id] {
+			if found == 0 {
+				reciprocalRank = 1 / float64(i+1)
+			}
+			found++
+		}
+	}
+	return float64(found) / float64(len(want)), reciprocalRank
+}
+
+// ReadEvalCases reads cases from JSON Lines, one EvalCase per line. Blank
+// lines are skipped.
+func ReadEvalCases(r io.Reader) ([]EvalCase, error) {
+	var cases []EvalCase
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var c EvalCase
+		if err := json.Unmarshal([]byte(text), &c); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if c.Question == "" {
+			return nil, fmt.Errorf("line %d: no question", line)
+		}
+		cases = append(cases, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(cases) == 0 {
+		return nil, errors.New("no evaluation cases")
+	}
+	return cases, nil
+}
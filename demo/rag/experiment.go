@@ -0,0 +1,144 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const preferencePrompt = `You compare two answers to a question, each written by an assistant from passages of the same documents.
+Prefer the answer that is more correct, more complete and more to the point, not the longer one; with a reference answer, prefer the one agreeing with it.
+Reply with JSON only, in the form {"better": "1", "reason": "one sentence"}, where better is "1", "2" or "tie".`
+
+// Preferences of ExperimentCase.
+const (
+	PreferA   = "a"
+	PreferB   = "b"
+	PreferTie = "tie"
+)
+
+// ExperimentArm is one of the two pipelines an Experiment compares.
+type ExperimentArm struct {
+	Name     string
+	Pipeline *Pipeline
+}
+
+// An Experiment runs the same cases through two pipelines that differ in
+// their configuration, such as their chunk size or reranker, side by side.
+// Each arm is measured by an Evaluator with the Judge and K of the
+// experiment; with a Judge, the judge is also shown the two answers to
+// every question and says which is better. The answers are put to it in
+// alternating order, so that a bias for the first or second answer favours
+// neither arm.
+type Experiment struct {
+	A, B  ExperimentArm
+	Judge LLM // nil to compare retrieval only
+	K     int // DefaultTopK if zero
+}
+
+// ExperimentCase is the comparison of the answers of both arms to a
+// question: Preferred is PreferA, PreferB or PreferTie, with the judge's
+// Reason, and empty if either arm has no answer or the judge failed, as
+// Error then says.
+type ExperimentCase struct {
+	Question  string `json:"question"`
+	Preferred string `json:"preferred,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ExperimentArmReport is the EvalReport of an arm under its name.
+type ExperimentArmReport struct {
+	Name string `json:"name"`
+	*EvalReport
+}
+
+// ExperimentReport compares the arms of an Experiment. A and B hold the
+// metrics of every case for each arm, and Cases the judge's preferences in
+// the same order. Compared counts the cases the judge compared, of which
+// it preferred arm A in WinsA, arm B in WinsB and neither in Ties.
+type ExperimentReport struct {
+	A        ExperimentArmReport `json:"a"`
+	B        ExperimentArmReport `json:"b"`
+	Cases    []ExperimentCase    `json:"cases"`
+	Compared int                 `json:"compared"`
+	WinsA    int                 `json:"wins_a"`
+	WinsB    int                 `json:"wins_b"`
+	Ties     int                 `json:"ties"`
+}
+
+// Run runs every case through arm A and then arm B and compares their
+// answers. A failure to retrieve aborts the run; failures to answer, judge
+// or compare are recorded in the cases.
+func (e *Experiment) Run(ctx context.Context, cases []EvalCase) (*ExperimentReport, error) {
+	report := &ExperimentReport{A: ExperimentArmReport{Name: e.A.Name}, B: ExperimentArmReport{Name: e.B.Name}}
+	var err error
+	if report.A.EvalReport, err = (&Evaluator{Pipeline: e.A.Pipeline, Judge: e.Judge, K: e.K}).Run(ctx, cases); err != nil {
+		return nil, fmt.Errorf("%s: %w", e.A.Name, err)
+	}
+	if report.B.EvalReport, err = (&Evaluator{Pipeline: e.B.Pipeline, Judge: e.Judge, K: e.K}).Run(ctx, cases); err != nil {
+		return nil, fmt.Errorf("%s: %w", e.B.Name, err)
+	}
+	report.Cases = make([]ExperimentCase, len(cases))
+	for i, c := range cases {
+		r := &report.Cases[i]
+		r.Question = c.Question
+		a, b := report.A.Cases[i], report.B.Cases[i]
+		if e.Judge == nil || a.Generated == "" || b.Generated == "" {
+			continue
+		}
+		if err := e.compare(ctx, r, c, a.Generated, b.Generated, i%2 == 1); err != nil {
+			r.Error = err.Error()
+			continue
+		}
+		report.Compared++
+		switch r.Preferred {
+		case PreferA:
+			report.WinsA++
+		case PreferB:
+			report.WinsB++
+		default:
+			report.Ties++
+		}
+	}
+	return report, nil
+}
+
+// compare has the judge pick the better of the answers a and b to a case,
+// putting b first if swap is set.
+func (e *Experiment) compare(ctx context.Context, r *ExperimentCase, c EvalCase, a, b string, swap bool) error {
+	first, second := a, b
+	if swap {
+		first, second = b, a
+	}
+	reference := c.Answer
+	if reference == "" {
+		reference = "(none given)"
+	}
+	reply, err := e.Judge.Generate(ctx, []Message{
+		{Role: RoleSystem, Content: preferencePrompt},
+		{Role: RoleUser, Content: fmt.Sprintf("Question: %s\n\nReference answer: %s\n\nAnswer 1: %s\n\nAnswer 2: %s", c.Question, reference, first, second)},
+	})
+	if err != nil {
+		return fmt.Errorf("comparing answers: %w", err)
+	}
+	var verdict struct {
+		Better string `json:"better"`
+		Reason string `json:"reason"`
+	}
+	if err := parseVerdict(reply, &verdict); err != nil {
+		return err
+	}
+	arms := map[string]string{"1": PreferA, "2": PreferB}
+	if swap {
+		arms = map[string]string{"1": PreferB, "2": PreferA}
+	}
+	better := strings.ToLower(strings.TrimSpace(verdict.Better))
+	if better == PreferTie {
+		r.Preferred = PreferTie
+	} else if r.Preferred = arms[better]; r.Preferred == "" {
+		return fmt.Errorf("judge reply names no better answer: %q", reply)
+	}
+	r.Reason = verdict.Reason
+	return nil
+}
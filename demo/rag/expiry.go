@@ -0,0 +1,189 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ExpiresKey is the metadata key holding the time a document expires, such
+// as a policy that is only valid until a date, in a form ParseExpiry
+// accepts. Chunks of expired documents are no longer retrieved, see
+// ACLRetriever, and SweepExpired deletes the documents.
+const ExpiresKey = "expires_at"
+
+// ParseExpiry parses the value of ExpiresKey: an RFC 3339 time, such as
+// "2026-12-31T18:00:00Z", or a date, such as "2026-12-31", which expires at
+// the end of that day in UTC.
+func ParseExpiry(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t.AddDate(0, 0, 1), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s %q: want an RFC 3339 time or a date such as 2026-12-31", ExpiresKey, value)
+	}
+	return t, nil
+}
+
+// expiry returns the time a chunk or document with the given metadata
+// expires, if it has a valid ExpiresKey.
+func expiry(metadata Metadata) (time.Time, bool) {
+	value, ok := metadata[ExpiresKey]
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := ParseExpiry(value)
+	return t, err == nil
+}
+
+// expired reports whether a chunk or document with the given metadata has
+// expired at now. Invalid expiry times, which ingestion rejects, never
+// expire.
+func expired(metadata Metadata, now time.Time) bool {
+	t, ok := expiry(metadata)
+	return ok && !t.After(now)
+}
+
+// checkExpiry returns an error if doc has an ExpiresKey ParseExpiry cannot
+// parse, so that a typo does not keep a document forever.
+func checkExpiry(doc *Document) error {
+	if value, ok := doc.Metadata[ExpiresKey]; ok {
+		if _, err := ParseExpiry(value); err != nil {
+			return invalidRequest("invalid %w", err)
+		}
+	}
+	return nil
+}
+
+// ExpiringDocument is a stored document with an expiry time, in Namespace.
+type ExpiringDocument struct {
+	Namespace string    `json:"namespace"`
+	ID        string    `json:"id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Expired   bool      `json:"expired"`
+}
+
+// Expiring lists the documents of the namespace of ctx that expire before
+// the given time, those already expired included, soonest first, so that
+// content can be renewed before it stops being retrieved. A document
+// expires when the first of its chunks does, and only documents with
+// chunks the principals of ctx may see are listed, see WithPrincipals. The
+// store must be a MetadataStore, which finds the chunks with an expiry
+// time by their metadata, or a ChunkStore, whose chunks are all read.
+func (p *Pipeline) Expiring(ctx context.Context, before time.Time) ([]ExpiringDocument, error) {
+	principals := PrincipalsFrom(ctx)
+	return p.expiring(ctx, before, func(metadata Metadata) bool { return allowed(metadata, principals) })
+}
+
+// expiring lists the documents expiring before the given time as Expiring
+// does, considering only the chunks whose metadata visible accepts.
+func (p *Pipeline) expiring(ctx context.Context, before time.Time, visible func(Metadata) bool) ([]ExpiringDocument, error) {
+	chunks, err := p.chunkMetadata(ctx, ExpiresKey)
+	if err != nil {
+		return nil, err
+	}
+	first := make(map[string]time.Time)
+	for _, c := range chunks {
+		t, ok := expiry(c.Metadata)
+		if !ok || !visible(c.Metadata) {
+			continue
+		}
+		if prev, seen := first[c.DocID]; !seen || t.Before(prev) {
+			first[c.DocID] = t
+		}
+	}
+	now := time.Now()
+	expiring := []ExpiringDocument{}
+	for id, t := range first {
+		if !t.Before(before) {
+			continue
+		}
+		expiring = append(expiring, ExpiringDocument{
+			Namespace: NamespaceFrom(ctx),
+			ID:        id,
+			ExpiresAt: t,
+			Expired:   !t.After(now),
+		})
+	}
+	slices.SortStableFunc(expiring, func(a, b ExpiringDocument) int {
+		return cmp.Or(a.ExpiresAt.Compare(b.ExpiresAt), cmp.Compare(a.ID, b.ID))
+	})
+	return expiring, nil
+}
+
+// chunkMetadata returns the ID, DocID and Metadata of the chunks of the
+// namespace of ctx whose metadata has one of keys, reading all chunks if
+// the store cannot find them by their metadata.
+func (p *Pipeline) chunkMetadata(ctx context.Context, keys ...string) ([]Chunk, error) {
+	if ms, ok := p.Store.(MetadataStore); ok {
+		var all []Chunk
+		seen := make(map[string]bool)
+		for _, key := range keys {
+			chunks, err := ms.ChunkMetadata(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+			for _, c := range chunks {
+				if !seen[c.ID] {
+					seen[c.ID] = true
+					all = append(all, c)
+				}
+			}
+		}
+		return all, nil
+	}
+	s, ok := p.Store.(ChunkStore)
+	if !ok {
+		return nil, fmt.Errorf("finding chunks by metadata is %w by the vector store", ErrNotSupported)
+	}
+	docs, err := p.Store.Documents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var all []Chunk
+	for _, d := range docs {
+		chunks, err := s.Chunks(ctx, d.ID)
+		if err != nil {
+			return nil, fmt.Errorf("reading chunks of %s: %w", d.ID, err)
+		}
+		for _, c := range chunks {
+			if slices.ContainsFunc(keys, func(key string) bool { _, ok := c.Metadata[key]; return ok }) {
+				all = append(all, Chunk{ID: c.ID, DocID: c.DocID, Metadata: c.Metadata})
+			}
+		}
+	}
+	return all, nil
+}
+
+// SweepExpired deletes the expired documents of every namespace, as Delete
+// does, and returns them, whoever may see them. Documents deleted before an
+// error are returned with it.
+func (p *Pipeline) SweepExpired(ctx context.Context) ([]ExpiringDocument, error) {
+	namespaces, err := p.Store.Namespaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var deleted []ExpiringDocument
+	for _, ns := range namespaces {
+		nsCtx := WithNamespace(ctx, ns.Name)
+		expiring, err := p.expiring(nsCtx, time.Now(), func(Metadata) bool { return true })
+		if err != nil {
+			return deleted, err
+		}
+		for _, d := range expiring {
+			if !d.Expired {
+				continue
+			}
+			if err := p.Delete(nsCtx, d.ID); err != nil {
+				return deleted, fmt.Errorf("deleting %s: %w", d.ID, err)
+			}
+			deleted = append(deleted, d)
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,200 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// An Explanation tells how the chunks for a question were ranked, to debug
+// why a chunk was or was not used to answer it. Candidates lists every
+// chunk a search stage returned, best ranked first: first those retrieved
+// in the order they were retrieved, then the others by their rerank
+// score, dense score and sparse score. Query is the standalone question
+// retrieved for, if the pipeline's Condenser rewrote a follow-up question,
+// and SelfQuery the query and filter SELF_QUERY parsed from it.
+type Explanation struct {
+	Question   string      `json:"question"`
+	Query      string      `json:"query,omitempty"`
+	SelfQuery  *SelfQuery  `json:"self_query,omitempty"`
+	Route      string      `json:"route,omitempty"`
+	Candidates []Candidate `json:"candidates"`
+	TimedOut   []string    `json:"timed_out,omitempty"`
+}
+
+// A Candidate is a chunk found for a question with the scores it got from
+// each stage of retrieval that scored it: DenseScore from vector search,
+// SparseScore from keyword or sparse vector search and RerankScore from the
+// reranker. A chunk found for several queries, such as the variants of
+// QUERY_VARIANTS, has its best score of each. Score is the score it was
+// retrieved with, after fusion, reranking and the other stages, and Rank
+// its 1-based position among the retrieved chunks, or 0 if it was not
+// retrieved, e.g. as it scored below MinScore or was left out by MMR.
+// InPrompt is set for the chunks that fit the prompt. Chunks that only
+// came out of a later stage, such as the parents of ParentRetriever, have
+// no score of the search stages. The store's own hybrid search only
+// reports its fused scores, as Score.
+type Candidate struct {
+	ID          string   `json:"id"`
+	DocID       string   `json:"doc_id"`
+	Chunk       int      `json:"chunk"`
+	Text        string   `json:"text"`
+	DenseScore  *float32 `json:"dense_score,omitempty"`
+	SparseScore *float32 `json:"sparse_score,omitempty"`
+	RerankScore *float32 `json:"rerank_score,omitempty"`
+	Score       *float32 `json:"score,omitempty"`
+	Rank        int      `json:"rank,omitempty"`
+	InPrompt    bool     `json:"in_prompt"`
+}
+
+// scoreKind names the stage a score of a Candidate comes from.
+type scoreKind int
+
+const (
+	denseScore scoreKind = iota
+	sparseScore
+	rerankScore
+)
+
+// An explainer collects the candidates of an Explanation from the
+// retrievers running with its context.
+type explainer struct {
+	mu         sync.Mutex
+	candidates map[string]*Candidate
+	order      []string
+	query      string         // the question retrieved for
+	selfQuery  *SelfQuery     // the last parsed by a SelfQueryRetriever
+	retrieved  []SearchResult // before they were fitted into the prompt
+}
+
+type explainerKey struct{}
+
+// recordScores records the scores of kind the retriever running in ctx
+// gave results, if an Explanation is being made. Chunks the principals of
+// ctx may not see are left out, as an explanation must not reveal them, and
+// so are chunks of expired documents.
+func recordScores(ctx context.Context, kind scoreKind, results []SearchResult) {
+	e, ok := ctx.Value(explainerKey{}).(*explainer)
+	if !ok {
+		return
+	}
+	principals, now := PrincipalsFrom(ctx), time.Now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, r := range results {
+		if !allowed(r.Metadata, principals) || expired(r.Metadata, now) {
+			continue
+		}
+		c := e.candidate(r)
+		score := &c.DenseScore
+		switch kind {
+		case sparseScore:
+			score = &c.SparseScore
+		case rerankScore:
+			score = &c.RerankScore
+		}
+		if *score == nil || **score < r.Score {
+			*score = &r.Score
+		}
+	}
+}
+
+// candidate returns the candidate of r, adding it if it is new.
+func (e *explainer) candidate(r SearchResult) *Candidate {
+	if c, ok := e.candidates[r.ID]; ok {
+		return c
+	}
+	c := &Candidate{ID: r.ID, DocID: r.DocID, Chunk: r.Index, Text: r.Text}
+	e.candidates[r.ID] = c
+	e.order = append(e.order, r.ID)
+	return c
+}
+
+// Explain retrieves the chunks for req as Query would and fits them into
+// the prompt, without generating an answer, and returns the scores every
+// candidate chunk was given on the way. The LLM is only asked if the
+// pipeline has a Router, rewrites queries or lets a RetrievalAgent search;
+// chunks that do not fit in the Budget are left out as OverflowTruncate
+// leaves them out.
+func (p *Pipeline) Explain(ctx context.Context, req QueryRequest) (_ *Explanation, err error) {
+	ctx, span := tracer.Start(ctx, "rag.explain")
+	defer func() { endSpan(span, err) }()
+	ctx, _ = p.metered(ctx)
+	ctx, cancel, timeouts := p.timed(ctx)
+	defer cancel()
+	defer func() { err = timedOut(ctx, err) }()
+	e := &explainer{candidates: make(map[string]*Candidate)}
+	ctx = withoutOverflowCalls(context.WithValue(ctx, explainerKey{}, e))
+	prepared, err := p.prepare(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	inPrompt, route := prepared.sources, prepared.route
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i, r := range e.retrieved {
+		c := e.candidate(r)
+		if c.Rank == 0 {
+			c.Rank, c.Score = i+1, &r.Score
+		}
+	}
+	for _, r := range inPrompt {
+		e.candidate(r).InPrompt = true
+	}
+	explanation := &Explanation{Question: req.Question, SelfQuery: e.selfQuery, Route: route, Candidates: make([]Candidate, len(e.order)), TimedOut: timeouts.timedOutStages()}
+	if e.query != req.Question {
+		explanation.Query = e.query
+	}
+	for i, id := range e.order {
+		explanation.Candidates[i] = *e.candidates[id]
+	}
+	slices.SortStableFunc(explanation.Candidates, func(a, b Candidate) int {
+		switch {
+		case a.Rank != 0 && b.Rank != 0:
+			return cmp.Compare(a.Rank, b.Rank)
+		case a.Rank != 0:
+			return -1
+		case b.Rank != 0:
+			return 1
+		}
+		// The others by the scores of the last stages first
+		for _, s := range [][2]*float32{{a.RerankScore, b.RerankScore}, {a.DenseScore, b.DenseScore}, {a.SparseScore, b.SparseScore}} {
+			switch {
+			case s[0] != nil && s[1] != nil:
+				if c := cmp.Compare(*s[1], *s[0]); c != 0 {
+					return c
+				}
+			case s[0] != nil:
+				return -1
+			case s[1] != nil:
+				return 1
+			}
+		}
+		return 0
+	})
+	return explanation, nil
+}
+
+// recordRetrieved records the chunks retrieved for a question, with the
+// query they were retrieved for, before they are fitted into the prompt, if
+// an Explanation is being made.
+func recordRetrieved(ctx context.Context, query string, sources []SearchResult) {
+	if e, ok := ctx.Value(explainerKey{}).(*explainer); ok {
+		e.mu.Lock()
+		e.query, e.retrieved = query, slices.Clone(sources)
+		e.mu.Unlock()
+	}
+}
+
+// recordSelfQuery records what a SelfQueryRetriever parsed from a question,
+// if an Explanation is being made.
+func recordSelfQuery(ctx context.Context, sq *SelfQuery) {
+	if e, ok := ctx.Value(explainerKey{}).(*explainer); ok {
+		e.mu.Lock()
+		e.selfQuery = sq
+		e.mu.Unlock()
+	}
+}
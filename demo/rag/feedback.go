@@ -0,0 +1,322 @@
+package rag
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultFeedbackSimilarity is how similar a question must be to a rated
+// one for the rating to weigh in on its retrieval.
+const DefaultFeedbackSimilarity = 0.8
+
+// feedbackAnswerTTL is how long answers can be rated after they were given.
+const feedbackAnswerTTL = 7 * 24 * time.Hour
+
+// feedbackCandidates is how many times k results FeedbackRetriever retrieves
+// to reorder, so that chunks rated up can rise into the top k.
+const feedbackCandidates = 2
+
+// ErrAnswerNotFound is returned for feedback on an answer that is not known,
+// or no longer.
+var ErrAnswerNotFound = errors.New("answer not found")
+
+var feedbackMigrations = []string{
+	`CREATE TABLE feedback_answers (
+		id         TEXT    PRIMARY KEY,
+		namespace  TEXT    NOT NULL,
+		question   TEXT    NOT NULL,
+		chunks     TEXT    NOT NULL,
+		created_at INTEGER NOT NULL
+	)`,
+	`CREATE TABLE feedback (
+		answer_id  TEXT    PRIMARY KEY,
+		namespace  TEXT    NOT NULL,
+		rating     INTEGER NOT NULL,
+		comment    TEXT    NOT NULL,
+		embedding  BLOB    NOT NULL,
+		chunks     TEXT    NOT NULL,
+		created_at INTEGER NOT NULL
+	)`,
+	`CREATE INDEX feedback_namespace ON feedback (namespace)`,
+	`CREATE TABLE feedback_chunks (
+		answer_id TEXT NOT NULL,
+		namespace TEXT NOT NULL,
+		chunk_id  TEXT NOT NULL,
+		PRIMARY KEY (answer_id, chunk_id)
+	)`,
+	`INSERT INTO feedback_chunks (answer_id, namespace, chunk_id)
+		SELECT f.answer_id, f.namespace, c.value FROM feedback f, json_each(f.chunks) c WHERE true
+		ON CONFLICT DO NOTHING`,
+	`CREATE INDEX feedback_chunks_chunk ON feedback_chunks (namespace, chunk_id)`,
+}
+
+// Rating is the verdict of feedback on an answer.
+type Rating string
+
+const (
+	RatingUp   Rating = "up"
+	RatingDown Rating = "down"
+)
+
+// Feedback rates the answer with the ID AnswerID, optionally with a
+// comment.
+type Feedback struct {
+	AnswerID string `json:"answer_id"`
+	Rating   Rating `json:"rating"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// FeedbackStore keeps the feedback given on answers in a SQLite file. Every
+// answer given with sources is recorded with its question and the IDs of
+// the chunks it was drawn from, under the ID that Answer carries, and can
+// be rated for a week. A rating is kept with the embedding of its question,
+// and a later rating of the same answer replaces it.
+//
+// Unless Weight is zero, the ratings also weigh in on retrieval, see
+// FeedbackRetriever: the chunks of answers rated for questions with a
+// cosine similarity of at least Similarity to a query have their score
+// raised, by up to Weight times its magnitude, if they were rated up, and
+// lowered if they were rated down.
+type FeedbackStore struct {
+	Weight     float64
+	Similarity float64
+
+	db *sql.DB
+}
+
+// NewFeedbackStore opens or creates the feedback file at path, creating its
+// directory if needed.
+func NewFeedbackStore(ctx context.Context, path string, weight, similarity float64) (*FeedbackStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("feedback: %w", err)
+	}
+	db, err := openSQLite(ctx, path, feedbackMigrations)
+	if err != nil {
+		return nil, fmt.Errorf("feedback: %w", err)
+	}
+	return &FeedbackStore{Weight: weight, Similarity: similarity, db: db}, nil
+}
+
+// Close closes the feedback file.
+func (s *FeedbackStore) Close() error {
+	return s.db.Close()
+}
+
+// record records an answer to question drawn from sources in the namespace
+// of ctx and returns its ID, dropping answers that can no longer be rated.
+func (s *FeedbackStore) record(ctx context.Context, question string, sources []SearchResult) (string, error) {
+	chunks := make([]string, len(sources))
+	for i, r := range sources {
+		chunks[i] = r.ID
+	}
+	data, err := json.Marshal(chunks)
+	if err != nil {
+		return "", err
+	}
+	id := rand.Text()
+	now := time.Now()
+	err = sqliteTx(ctx, s.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM feedback_answers WHERE created_at <= ?`, now.Add(-feedbackAnswerTTL).UnixMilli()); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `INSERT INTO feedback_answers (id, namespace, question, chunks, created_at) VALUES (?, ?, ?, ?, ?)`,
+			id, NamespaceFrom(ctx), question, data, now.UnixMilli())
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// answer returns the namespace, question and chunks of a recorded answer.
+func (s *FeedbackStore) answer(ctx context.Context, id string) (namespace, question, chunks string, err error) {
+	err = s.db.QueryRowContext(ctx, `SELECT namespace, question, chunks FROM feedback_answers WHERE id = ? AND created_at > ?`,
+		id, time.Now().Add(-feedbackAnswerTTL).UnixMilli()).Scan(&namespace, &question, &chunks)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = fmt.Errorf("%w: %s", ErrAnswerNotFound, id)
+	}
+	return namespace, question, chunks, err
+}
+
+// rate stores the rating of a recorded answer whose question has the given
+// embedding.
+func (s *FeedbackStore) rate(ctx context.Context, f Feedback, namespace, chunks string, embedding []float32) error {
+	rating := 1
+	if f.Rating == RatingDown {
+		rating = -1
+	}
+	var ids []string
+	if err := json.Unmarshal([]byte(chunks), &ids); err != nil {
+		return err
+	}
+	return sqliteTx(ctx, s.db, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT OR REPLACE INTO feedback (answer_id, namespace, rating, comment, embedding, chunks, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			f.AnswerID, namespace, rating, f.Comment, encodeVector(embedding), chunks, time.Now().UnixMilli())
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO feedback_chunks (answer_id, namespace, chunk_id) VALUES (?, ?, ?)`, f.AnswerID, namespace, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// votes returns the net rating, from -1 to 1, of each of the chunks with
+// the given IDs that was rated in answers to questions similar to the one
+// with the given embedding in the namespace of ctx. Ratings count in
+// proportion to the similarity of their questions. Only the ratings of
+// answers drawn from those chunks are read.
+func (s *FeedbackStore) votes(ctx context.Context, embedding []float32, ids []string) (map[string]float64, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	args := []any{NamespaceFrom(ctx)}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT f.answer_id, f.rating, f.embedding, c.chunk_id
+		FROM feedback_chunks c JOIN feedback f ON f.answer_id = c.answer_id
+		WHERE c.namespace = ? AND c.chunk_id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sims := make(map[string]float64) // by answer, whose embedding is decoded once
+	sum, weight := make(map[string]float64), make(map[string]float64)
+	for rows.Next() {
+		var answer, id string
+		var rating int
+		var vector []byte
+		if err := rows.Scan(&answer, &rating, &vector, &id); err != nil {
+			return nil, err
+		}
+		sim, ok := sims[answer]
+		if !ok {
+			sim = float64(similarity(MetricCosine, embedding, decodeVector(vector)))
+			sims[answer] = sim
+		}
+		if sim < s.Similarity {
+			continue
+		}
+		sum[id] += float64(rating) * sim
+		weight[id] += sim
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for id := range sum {
+		sum[id] /= weight[id]
+	}
+	return sum, nil
+}
+
+// deleteNamespace drops the answers and feedback of a namespace.
+func (s *FeedbackStore) deleteNamespace(ctx context.Context, name string) error {
+	return sqliteTx(ctx, s.db, func(tx *sql.Tx) error {
+		for _, table := range []string{"feedback_answers", "feedback", "feedback_chunks"} {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE namespace = ?`, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SubmitFeedback rates an answer given by the pipeline, which must have a
+// FeedbackStore. The question of the answer is embedded to find the ratings
+// of similar questions by.
+func (p *Pipeline) SubmitFeedback(ctx context.Context, f Feedback) (err error) {
+	ctx, span := tracer.Start(ctx, "rag.feedback", trace.WithAttributes(attribute.String("rag.rating", string(f.Rating))))
+	defer func() { endSpan(span, err) }()
+	if p.Feedback == nil {
+		return fmt.Errorf("feedback is %w", ErrNotEnabled)
+	}
+	if f.Rating != RatingUp && f.Rating != RatingDown {
+		return invalidRequest("rating must be %q or %q, got %q", RatingUp, RatingDown, f.Rating)
+	}
+	namespace, question, chunks, err := p.Feedback.answer(ctx, f.AnswerID)
+	if err != nil {
+		return err
+	}
+	embeddings, err := p.Embedder.Embed(ctx, []string{question})
+	if err != nil {
+		return fmt.Errorf("embedding question: %w", err)
+	}
+	if len(embeddings) != 1 {
+		return fmt.Errorf("embedding question: got %d embeddings", len(embeddings))
+	}
+	return p.Feedback.rate(ctx, f, namespace, chunks, embeddings[0])
+}
+
+// recordAnswer records an answer drawn from sources in the pipeline's
+// FeedbackStore, if it has one, and returns the ID to rate it by. Answers
+// without sources have nothing to rate. The answer is good even if it
+// cannot be recorded, so errors are only recorded on the query's span.
+func (p *Pipeline) recordAnswer(ctx context.Context, req QueryRequest, sources []SearchResult) string {
+	if p.Feedback == nil || len(sources) == 0 {
+		return ""
+	}
+	id, err := p.Feedback.record(ctx, req.Question, sources)
+	if err != nil {
+		trace.SpanFromContext(ctx).RecordError(fmt.Errorf("feedback: %w", err))
+	}
+	return id
+}
+
+// FeedbackRetriever reorders the results of Retriever by the feedback of
+// Store: it retrieves twice k candidates and raises the score of each by
+// up to Store.Weight times its magnitude, or lowers it, by the net rating
+// of the chunk in answers to similar questions, keeping the k best. The
+// query is embedded with Embedder to find those questions, once for
+// Retriever too if that embeds it with the same Embedder.
+type FeedbackRetriever struct {
+	Retriever Retriever
+	Embedder  Embedder
+	Store     *FeedbackStore
+}
+
+func (r *FeedbackRetriever) Retrieve(ctx context.Context, query string, k int, filter Filter) ([]SearchResult, error) {
+	ctx = withQueryVectors(ctx)
+	results, err := r.Retriever.Retrieve(ctx, query, k*feedbackCandidates, filter)
+	if err != nil || len(results) == 0 {
+		return results, err
+	}
+	vector, err := embedQuery(ctx, r.Embedder, query)
+	if err != nil {
+		return nil, fmt.Errorf("embedding query: %w", err)
+	}
+	ids := make([]string, len(results))
+	for i, res := range results {
+		ids[i] = res.ID
+	}
+	votes, err := r.Store.votes(ctx, vector, ids)
+	if err != nil {
+		return nil, fmt.Errorf("feedback: %w", err)
+	}
+	for i := range results {
+		if v, ok := votes[results[i].ID]; ok {
+			results[i].Score += float32(math.Abs(float64(results[i].Score)) * r.Store.Weight * v)
+		}
+	}
+	sortResults(results)
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results, nil
+}
@@ -0,0 +1,157 @@
+package rag
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Op is a comparison operator in a filter condition.
+type Op string
+
+const (
+	OpEq Op = "="
+	OpNe Op = "!="
+	OpLt Op = "<"
+	OpLe Op = "<="
+	OpGt Op = ">"
+	OpGe Op = ">="
+)
+
+// A Condition compares the metadata value under Key with Value. If Value is
+// a number the comparison is numeric and only matches numeric metadata;
+// otherwise values are compared as strings, which also orders ISO dates.
+// Chunks without the key never match.
+type Condition struct {
+	Key   string `json:"key"`
+	Op    Op     `json:"op"`
+	Value string `json:"value"`
+}
+
+// A Filter restricts retrieval to chunks whose metadata satisfies all of its
+// conditions. The empty Filter matches every chunk.
+type Filter []Condition
+
+var (
+	conditionPattern = regexp.MustCompile(`^\s*([\w.\-]+)\s*(==|!=|<=|>=|=|<|>)\s*("(?:[^"\\]|\\.)*"|[^,\s]+)\s*`)
+	separatorPattern = regexp.MustCompile(`^(?:,|(?i:and)\s)\s*`)
+	numberPattern    = regexp.MustCompile(`^\s*[-+]?(\d+\.?\d*|\.\d+)([eE][-+]?\d+)?\s*$`)
+)
+
+// ParseFilter parses a filter expression: conditions of the form key op
+// value joined by commas or AND, e.g. `source=handbook, year>=2023`. Values
+// containing spaces or commas can be double-quoted.
+func ParseFilter(expr string) (Filter, error) {
+	var filter Filter
+	rest := strings.TrimSpace(expr)
+	for rest != "" {
+		m := conditionPattern.FindStringSubmatch(rest)
+		if m == nil {
+			return nil, fmt.Errorf("invalid filter condition at %q", rest)
+		}
+		value := m[3]
+		if strings.HasPrefix(value, `"`) {
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted value %s: %w", value, err)
+			}
+			value = unquoted
+		}
+		op := Op(m[2])
+		if op == "==" {
+			op = OpEq
+		}
+		filter = append(filter, Condition{Key: m[1], Op: op, Value: value})
+		rest = rest[len(m[0]):]
+		if rest == "" {
+			break
+		}
+		sep := separatorPattern.FindString(rest)
+		if sep == "" {
+			return nil, fmt.Errorf("expected , or AND at %q", rest)
+		}
+		rest = rest[len(sep):]
+	}
+	return filter, nil
+}
+
+func (f Filter) String() string {
+	conditions := make([]string, len(f))
+	for i, c := range f {
+		value := c.Value
+		if strings.ContainsAny(value, ` ,"`) || value == "" {
+			value = strconv.Quote(value)
+		}
+		conditions[i] = c.Key + string(c.Op) + value
+	}
+	return strings.Join(conditions, ", ")
+}
+
+// Match reports whether m satisfies every condition of f.
+func (f Filter) Match(m Metadata) bool {
+	for _, c := range f {
+		if !c.match(m) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c Condition) match(m Metadata) bool {
+	v, ok := m[c.Key]
+	if !ok {
+		return false
+	}
+	var cmp int
+	if want, isNum := parseNumber(c.Value); isNum {
+		got, gotNum := parseNumber(v)
+		if !gotNum {
+			return false
+		}
+		cmp = compareFloats(got, want)
+	} else {
+		cmp = strings.Compare(v, c.Value)
+	}
+	switch c.Op {
+	case OpEq:
+		return cmp == 0
+	case OpNe:
+		return cmp != 0
+	case OpLt:
+		return cmp < 0
+	case OpLe:
+		return cmp <= 0
+	case OpGt:
+		return cmp > 0
+	case OpGe:
+		return cmp >= 0
+	}
+	return false
+}
+
+// numeric reports whether the condition compares numbers.
+func (c Condition) numeric() bool {
+	_, ok := parseNumber(c.Value)
+	return ok
+}
+
+// parseNumber parses plain decimal numbers, rejecting forms such as "NaN"
+// or hex floats that strconv accepts but other backends would not.
+func parseNumber(s string) (float64, bool) {
+	if !numberPattern.MatchString(s) {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return f, err == nil
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,236 @@
+package rag
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// A PromptCase is a query whose prompt is compared with a golden file.
+// Name names the golden file, and defaults to the question with every run
+// of characters other than letters and digits replaced by a dash.
+type PromptCase struct {
+	Name string `json:"name,omitempty"`
+	QueryRequest
+}
+
+// nonName matches the characters a PromptCase name derived from a question
+// leaves out.
+var nonName = regexp.MustCompile(`[^a-z0-9]+`)
+
+// ReadPromptCases reads prompt cases from JSON Lines, one object per line
+// with the fields of a QueryRequest and an optional "name". Names must be
+// unique.
+func ReadPromptCases(r io.Reader) ([]PromptCase, error) {
+	var cases []PromptCase
+	names := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var c PromptCase
+		if err := json.Unmarshal(scanner.Bytes(), &c); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if c.Question == "" {
+			return nil, fmt.Errorf("line %d: no question", line)
+		}
+		if c.Name == "" {
+			c.Name = strings.Trim(nonName.ReplaceAllString(strings.ToLower(c.Question), "-"), "-")
+			c.Name = c.Name[:min(len(c.Name), 60)]
+		}
+		if strings.ContainsAny(c.Name, `/\`) || c.Name == "." || c.Name == ".." {
+			return nil, fmt.Errorf("line %d: invalid name %q", line, c.Name)
+		}
+		if first, ok := names[c.Name]; ok {
+			return nil, fmt.Errorf("line %d: name %q is taken by line %d", line, c.Name, first)
+		}
+		names[c.Name] = line
+		cases = append(cases, c)
+	}
+	return cases, scanner.Err()
+}
+
+// PromptMessages retrieves context for req and returns the messages the LLM
+// would be asked to answer, without asking it. It runs the same stages as
+// Query up to the LLM call, so retrieval stages calling the LLM or a
+// reranker call them too, but chunks that do not fit in the Budget are left
+// out as OverflowTruncate leaves them out, without summarizing them or
+// answering in parts.
+func (p *Pipeline) PromptMessages(ctx context.Context, req QueryRequest) ([]Message, error) {
+	prepared, err := p.prepare(withoutOverflowCalls(ctx), req)
+	if err != nil {
+		return nil, err
+	}
+	return prepared.messages, nil
+}
+
+// promptSettings lists the settings PinPromptConfig keeps, by environment
+// variable: those of loading, chunking, retrieval without a model and the
+// prompt, which prompts are meant to depend on, and those of the llm
+// client, which is made but not called.
+var promptSettings = map[string]bool{
+	"LLM":                    true,
+	"CHAT_MODEL":             true,
+	"LLM_BASE_URL":           true,
+	"LLM_API_KEY":            true,
+	"LLM_STRUCTURED_OUTPUTS": true,
+	"OLLAMA_HOST":            true,
+	"VERTEX_PROJECT":         true,
+	"VERTEX_LOCATION":        true,
+	"AWS_REGION":             true,
+	"AWS_ACCESS_KEY_ID":      true,
+	"AWS_SECRET_ACCESS_KEY":  true,
+	"AWS_SESSION_TOKEN":      true,
+	"S3_ENDPOINT":            true,
+	"GCS_HMAC_ACCESS_KEY":    true,
+	"GCS_HMAC_SECRET":        true,
+	"CONFLUENCE_URL":         true,
+	"CONFLUENCE_USER":        true,
+	"CONFLUENCE_TOKEN":       true,
+	"NOTION_URL":             true,
+	"NOTION_TOKEN":           true,
+	"VECTOR_METRIC":          true,
+	"VECTOR_QUANTIZATION":    true,
+	"CHUNK_SIZE":             true,
+	"CHUNK_OVERLAP":          true,
+	"PARENT_CHUNK_SIZE":      true,
+	"ROW_TEMPLATE":           true,
+	"PART_SIZE":              true,
+	"MAX_FILE_SIZE":          true,
+	"OCR":                    true,
+	"OCR_LANGUAGES":          true,
+	"OCR_MIN_CHARS":          true,
+	"DEDUP":                  true,
+	"DEDUP_THRESHOLD":        true,
+	"RETRIEVER":              true,
+	"HYBRID_WEIGHT":          true,
+	"MIN_SCORE":              true,
+	"MAX_K":                  true,
+	"LANGUAGE_DETECTION":     true,
+	"MMR_LAMBDA":             true,
+	"COMPRESSION":            true,
+	"COMPRESSION_RATIO":      true,
+	"PROMPT_TEMPLATE":        true,
+	"CONTEXT_TOKENS":         true,
+	"CONTEXT_OVERFLOW":       true,
+	"SUMMARIZE_TOKENS":       true,
+	"MEMORY_WINDOW":          true,
+	"CITATIONS":              true,
+	"NO_CONTEXT":             true,
+	"INJECTION_GUARD":        true,
+	"PII":                    true,
+	"PII_KINDS":              true,
+}
+
+// PinPromptConfig returns cfg changed so that the prompts of a pipeline
+// built from it do not depend on a model, a provider or state kept
+// between runs: the settings promptSettings does not list are those of
+// DefaultConfig, which settings added later therefore get too, chunks are
+// embedded with EMBEDDER=hash into the memory store and COMPRESSION=llm is
+// turned off.
+func PinPromptConfig(cfg Config) Config {
+	pinned := DefaultConfig()
+	from := cfg.settings()
+	for i, s := range pinned.settings() {
+		if promptSettings[s.env] {
+			reflect.ValueOf(s.dst).Elem().Set(reflect.ValueOf(from[i].dst).Elem())
+		}
+	}
+	pinned.Embedder, pinned.EmbeddingModel = "hash", ""
+	pinned.VectorStore, pinned.SessionStore = "memory", "memory"
+	pinned.EmbedCache = ""
+	pinned.CondenseQueries = false
+	if pinned.Compression == "llm" {
+		pinned.Compression = ""
+	}
+	return pinned
+}
+
+// FormatPrompt renders messages as text for a golden file: the role of each
+// message on a line of its own, followed by its content.
+func FormatPrompt(messages []Message) string {
+	var b strings.Builder
+	for i, m := range messages {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "===== %s =====\n%s\n", m.Role, strings.TrimRight(m.Content, "\n"))
+	}
+	return b.String()
+}
+
+// DiffLines returns a line diff turning want into got, with lines only in
+// want prefixed by "-", lines only in got by "+", and up to around
+// unchanged lines before and after each change prefixed by a space. It
+// returns "" if the texts are equal.
+func DiffLines(want, got string, around int) string {
+	a, b := strings.Split(want, "\n"), strings.Split(got, "\n")
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	type line struct {
+		op   byte
+		text string
+	}
+	var lines []line
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, line{' ', a[i]})
+			i, j = i+1, j+1
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, line{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, line{'+', b[j]})
+			j++
+		}
+	}
+	// Show the changed lines and the unchanged ones near them
+	shown := make([]bool, len(lines))
+	for n, l := range lines {
+		if l.op == ' ' {
+			continue
+		}
+		for k := max(n-around, 0); k <= min(n+around, len(lines)-1); k++ {
+			shown[k] = true
+		}
+	}
+	var out strings.Builder
+	last := -1
+	for n, l := range lines {
+		if !shown[n] {
+			continue
+		}
+		if last >= 0 && n > last+1 {
+			out.WriteString("...\n")
+		}
+		fmt.Fprintf(&out, "%c%s\n", l.op, l.text)
+		last = n
+	}
+	if last < 0 {
+		return ""
+	}
+	return out.String()
+}
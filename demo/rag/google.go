@@ -0,0 +1,220 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	googleScope    = "https://www.googleapis.com/auth/cloud-platform"
+	googleTokenURL = "https://oauth2.googleapis.com/token"
+	// googleMetadataURL is where the metadata server of Compute Engine, GKE
+	// and Cloud Run hands out the tokens of the attached service account.
+	googleMetadataURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+// googleTokenSource gets OAuth 2.0 access tokens for Google Cloud APIs
+// from the application default credentials, as the Google Cloud client
+// libraries do: the service account key or user credentials in the JSON
+// file named by GOOGLE_APPLICATION_CREDENTIALS, or else those written by
+// gcloud auth application-default login, or else the service account of
+// the VM, pod or service the process runs on, from the metadata server.
+// Tokens are kept until shortly before they expire.
+type googleTokenSource struct {
+	client *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func newGoogleTokenSource() *googleTokenSource {
+	return &googleTokenSource{client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// googleCredentials is a credentials file: a service account key or the
+// refresh token of a user.
+type googleCredentials struct {
+	Type         string `json:"type"` // service_account or authorized_user
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// googleTokenResponse is the response of a token endpoint.
+type googleTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// Token returns an access token to send as a bearer token.
+func (s *googleTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Until(s.expires) > time.Minute {
+		return s.token, nil
+	}
+	res, err := s.fetch(ctx)
+	if err != nil {
+		return "", fmt.Errorf("getting a Google access token: %w", err)
+	}
+	s.token, s.expires = res.AccessToken, time.Now().Add(time.Duration(res.ExpiresIn)*time.Second)
+	return s.token, nil
+}
+
+func (s *googleTokenSource) fetch(ctx context.Context) (*googleTokenResponse, error) {
+	path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if path == "" {
+		path = gcloudCredentialsPath()
+		if _, err := os.Stat(path); err != nil {
+			return s.metadataToken(ctx)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var creds googleCredentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", path, err)
+	}
+	switch creds.Type {
+	case "service_account":
+		assertion, err := creds.assertion(time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("signing with the key in %s: %w", path, err)
+		}
+		return s.exchange(ctx, cmp.Or(creds.TokenURI, googleTokenURL), url.Values{
+			"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+			"assertion":  {assertion},
+		})
+	case "authorized_user":
+		return s.exchange(ctx, googleTokenURL, url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {creds.ClientID},
+			"client_secret": {creds.ClientSecret},
+			"refresh_token": {creds.RefreshToken},
+		})
+	}
+	return nil, fmt.Errorf("unsupported credentials type %q in %s", creds.Type, path)
+}
+
+// gcloudCredentialsPath returns where gcloud auth application-default login
+// writes the credentials of the user.
+func gcloudCredentialsPath() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("APPDATA"), "gcloud", "application_default_credentials.json")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".config", "gcloud", "application_default_credentials.json")
+}
+
+// assertion returns the JSON Web Token a service account exchanges for an
+// access token, signed with its private key.
+func (c *googleCredentials) assertion(now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(c.PrivateKey))
+	if block == nil {
+		return "", errors.New("no PEM private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return "", err
+		}
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", errors.New("the private key is not an RSA key")
+	}
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": c.PrivateKeyID})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]any{
+		"iss":   c.ClientEmail,
+		"scope": googleScope,
+		"aud":   cmp.Or(c.TokenURI, googleTokenURL),
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// exchange posts form to a token endpoint.
+func (s *googleTokenSource) exchange(ctx context.Context, endpoint string, form url.Values) (*googleTokenResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return s.do(req)
+}
+
+// metadataToken asks the metadata server for a token of the service
+// account attached to the VM, pod or service.
+func (s *googleTokenSource) metadataToken(ctx context.Context) (*googleTokenResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, googleMetadataURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	res, err := s.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("no GOOGLE_APPLICATION_CREDENTIALS or gcloud credentials are set, and the metadata server failed: %w", err)
+	}
+	return res, nil
+}
+
+func (s *googleTokenSource) do(req *http.Request) (*googleTokenResponse, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var res googleTokenResponse
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &res); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("decoding token: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || res.AccessToken == "" {
+		return nil, fmt.Errorf("%s: %s: %s", req.URL.Redacted(), resp.Status, cmp.Or(res.Description, res.Error))
+	}
+	return &res, nil
+}
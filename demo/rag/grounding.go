@@ -0,0 +1,208 @@
+package rag
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// GroundingMode selects what a GroundingCheck does with claims of an answer
+// that the retrieved context does not support.
+type GroundingMode string
+
+const (
+	// GroundingFlag only reports the unsupported claims.
+	GroundingFlag GroundingMode = "flag"
+	// GroundingStrip removes the sentences making unsupported claims from
+	// the answer.
+	GroundingStrip GroundingMode = "strip"
+	// GroundingRegenerate generates the answer once more, telling the LLM
+	// which claims were unsupported, and checks the new answer.
+	GroundingRegenerate GroundingMode = "regenerate"
+)
+
+// unsupportedAnswer replaces an answer all of whose claims were stripped.
+const unsupportedAnswer = "The provided context does not support an answer to this question."
+
+const groundingPrompt = `You check whether an answer written by an assistant is supported by numbered context passages.
+Split the answer into the factual claims it makes, quoting for each claim the sentence of the answer that makes it, word for word. Skip sentences that make no claim, such as saying that the context does not answer the question.
+For every claim decide whether the context passages support it; a claim is supported only if the passages state or directly imply it.
+Reply with JSON only, in the form {"claims": [{"claim": "quoted sentence", "supported": true}]}.`
+
+// groundingSchema is the JSON Schema of the replies to groundingPrompt.
+var groundingSchema = json.RawMessage(`{
+	"type": "object",
+	"properties": {
+		"claims": {
+			"type": "array",
+			"items": {
+				"type": "object",
+				"properties": {
+					"claim": {"type": "string", "description": "A sentence of the answer, quoted word for word."},
+					"supported": {"type": "boolean", "description": "Whether the context supports the claim."}
+				},
+				"required": ["claim", "supported"],
+				"additionalProperties": false
+			}
+		}
+	},
+	"required": ["claims"],
+	"additionalProperties": false
+}`)
+
+// Grounding is the outcome of checking an answer against its sources.
+// Score is the fraction of the answer's claims the sources support, 1 for
+// an answer making no claims. Unsupported lists the claims that are not
+// supported; with GroundingStrip they were removed from the answer, and
+// with GroundingRegenerate they belong to the second answer if Regenerated
+// is set.
+type Grounding struct {
+	Score       float64  `json:"score"`
+	Unsupported []string `json:"unsupported,omitempty"`
+	Stripped    bool     `json:"stripped,omitempty"`
+	Regenerated bool     `json:"regenerated,omitempty"`
+}
+
+// GroundingCheck verifies generated answers claim by claim against the
+// retrieved context, using LLM as a judge, and handles unsupported claims
+// as selected by Mode.
+type GroundingCheck struct {
+	LLM  LLM
+	Mode GroundingMode
+}
+
+// NewGroundingCheck returns the GroundingCheck selected by mode, which is
+// off, flag, strip or regenerate, or nil if it is off or empty.
+func NewGroundingCheck(mode string, llm LLM) (*GroundingCheck, error) {
+	switch GroundingMode(mode) {
+	case "", "off":
+		return nil, nil
+	case GroundingFlag, GroundingStrip, GroundingRegenerate:
+		return &GroundingCheck{LLM: llm, Mode: GroundingMode(mode)}, nil
+	}
+	return nil, fmt.Errorf("unknown grounding mode %q", mode)
+}
+
+// claim is a claim of an answer as judged by the LLM.
+type claim struct {
+	Claim     string `json:"claim"`
+	Supported bool   `json:"supported"`
+}
+
+// Check judges answer against sources and returns the answer to keep, which
+// differs from answer if claims were stripped or it was regenerated.
+// regenerate generates a new answer from the given feedback; it is only
+// called in GroundingRegenerate mode. If the LLM replies with no verdict
+// that can be read, the answer is returned unjudged, with a nil Grounding,
+// and the error is recorded on the span of the check.
+func (g *GroundingCheck) Check(ctx context.Context, answer string, sources []SearchResult, regenerate func(ctx context.Context, feedback string) (string, error)) (_ string, _ *Grounding, err error) {
+	ctx, span := tracer.Start(ctx, "rag.ground")
+	defer func() { endSpan(span, err) }()
+	grounding, err := g.judge(ctx, answer, sources)
+	if errors.Is(err, errReplyNotJSON) {
+		span.RecordError(err)
+		return answer, nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if len(grounding.Unsupported) > 0 {
+		switch g.Mode {
+		case GroundingStrip:
+			answer = stripClaims(answer, grounding.Unsupported)
+			grounding.Stripped = true
+		case GroundingRegenerate:
+			var feedback strings.Builder
+			feedback.WriteString("A reviewer found that the context passages do not support these statements of your previous answer:\n")
+			for _, c := range grounding.Unsupported {
+				fmt.Fprintf(&feedback, "- %s\n", c)
+			}
+			feedback.WriteString("Answer again, using only information found in the context passages.")
+			if answer, err = regenerate(ctx, feedback.String()); err != nil {
+				return "", nil, fmt.Errorf("regenerating answer: %w", err)
+			}
+			if grounding, err = g.judge(ctx, answer, sources); errors.Is(err, errReplyNotJSON) {
+				span.RecordError(err)
+				return answer, nil, nil
+			} else if err != nil {
+				return "", nil, err
+			}
+			grounding.Regenerated = true
+		}
+	}
+	span.SetAttributes(attribute.Float64("rag.groundedness", grounding.Score), attribute.Int("rag.unsupported_claims", len(grounding.Unsupported)))
+	return answer, grounding, nil
+}
+
+// judge asks the LLM which claims of answer sources support.
+func (g *GroundingCheck) judge(ctx context.Context, answer string, sources []SearchResult) (*Grounding, error) {
+	var prompt strings.Builder
+	prompt.WriteString("Context:\n")
+	for i, s := range sources {
+		fmt.Fprintf(&prompt, "[%d] %s\n\n", i+1, s.Text)
+	}
+	fmt.Fprintf(&prompt, "Answer: %s", answer)
+	messages := []Message{
+		{Role: RoleSystem, Content: groundingPrompt},
+		{Role: RoleUser, Content: prompt.String()},
+	}
+	var reply string
+	var err error
+	if llm, ok := g.LLM.(StructuredLLM); ok {
+		reply, err = llm.GenerateJSON(ctx, messages, "grounding", groundingSchema)
+	} else {
+		reply, err = g.LLM.Generate(ctx, messages)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("checking grounding: %w", err)
+	}
+	var verdict struct {
+		Claims []claim `json:"claims"`
+	}
+	if err := decodeJSONReply(reply, &verdict); err != nil {
+		return nil, fmt.Errorf("grounding %w", err)
+	}
+	grounding := &Grounding{Score: 1}
+	if len(verdict.Claims) == 0 {
+		return grounding, nil
+	}
+	supported := 0
+	for _, c := range verdict.Claims {
+		if c.Supported {
+			supported++
+		} else {
+			grounding.Unsupported = append(grounding.Unsupported, c.Claim)
+		}
+	}
+	grounding.Score = float64(supported) / float64(len(verdict.Claims))
+	return grounding, nil
+}
+
+var (
+	blankLines   = regexp.MustCompile(`\n{3,}`)
+	doubleSpaces = regexp.MustCompile(`(\S) {2,}`)
+)
+
+// stripClaims removes the quoted claims from answer. Claims the judge did
+// not quote exactly cannot be found and stay in the answer.
+func stripClaims(answer string, claims []string) string {
+	for _, c := range claims {
+		if c = strings.TrimSpace(c); c != "" {
+			answer = strings.Replace(answer, c, "", 1)
+		}
+	}
+	lines := strings.Split(answer, "\n")
+	for i, line := range lines {
+		lines[i] = doubleSpaces.ReplaceAllString(strings.TrimRight(line, " "), "$1 ")
+	}
+	answer = strings.TrimSpace(blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+	if answer == "" {
+		return unsupportedAnswer
+	}
+	return answer
+}
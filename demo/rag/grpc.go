@@ -0,0 +1,305 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/jalling97/go_rag_demo/demo/ragpb"
+)
+
+// RegisterGRPC registers the rag.v1.RAGService, defined in
+// proto/rag/v1/rag.proto, for p on s. It offers the same operations as
+// NewHandler, with QueryStream streaming the answer as it is generated.
+// Queries and the calls on documents take their principals from the
+// PrincipalsHeader metadata key, and are refused the documents they may
+// not see as over HTTP.
+// Errors carry the status code of their kind and an ErrorInfo detail with
+// their ErrorCode as the reason.
+func RegisterGRPC(s grpc.ServiceRegistrar, p *Pipeline) {
+	registerGRPC(s, func() *Pipeline { return p })
+}
+
+// registerGRPC registers the service of RegisterGRPC, serving every call
+// with the pipeline returned by pipeline.
+func registerGRPC(s grpc.ServiceRegistrar, pipeline func() *Pipeline) {
+	ragpb.RegisterRAGServiceServer(s, &grpcServer{pipeline: pipeline})
+}
+
+type grpcServer struct {
+	ragpb.UnimplementedRAGServiceServer
+	pipeline func() *Pipeline
+}
+
+func (s *grpcServer) Ingest(ctx context.Context, req *ragpb.IngestRequest) (*ragpb.IngestResponse, error) {
+	ctx, err := grpcNamespace(ctx, req.GetNamespace())
+	if err != nil {
+		return nil, err
+	}
+	if len(req.GetDocuments()) == 0 {
+		return nil, grpcError(invalidRequest("no documents to ingest"))
+	}
+	docs := make([]*Document, len(req.GetDocuments()))
+	for i, d := range req.GetDocuments() {
+		if d.GetId() == "" {
+			return nil, grpcError(invalidRequest("document %d has no id", i))
+		}
+		docs[i] = &Document{
+			ID:       d.GetId(),
+			Sections: []Section{{Text: d.GetText()}},
+			Metadata: Metadata{"source": d.GetId()}.merge(d.GetMetadata()),
+		}
+	}
+	ctx = grpcPrincipals(ctx)
+	if err := s.pipeline().checkAllowed(ctx, ErrForbidden, documentIDs(docs)...); err != nil {
+		return nil, grpcError(err)
+	}
+	results, err := s.pipeline().IngestAll(ctx, docs)
+	var batchErr *BatchError
+	if err != nil && !errors.As(err, &batchErr) {
+		return nil, grpcError(err)
+	}
+	resp := &ragpb.IngestResponse{Results: make([]*ragpb.IngestResult, len(results))}
+	for i, r := range results {
+		resp.Results[i] = &ragpb.IngestResult{
+			Id:       r.ID,
+			Chunks:   int32(r.Chunks),
+			Embedded: int32(r.Embedded),
+			Error:    r.Error,
+		}
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) Query(ctx context.Context, req *ragpb.QueryRequest) (*ragpb.QueryResponse, error) {
+	q, err := grpcQueryRequest(s.pipeline(), req)
+	if err != nil {
+		return nil, err
+	}
+	ctx, err = grpcNamespace(ctx, req.GetNamespace())
+	if err != nil {
+		return nil, err
+	}
+	answer, err := s.pipeline().Query(grpcPrincipals(ctx), q)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return grpcAnswer(answer), nil
+}
+
+func (s *grpcServer) QueryStream(req *ragpb.QueryRequest, stream grpc.ServerStreamingServer[ragpb.QueryStreamResponse]) error {
+	q, err := grpcQueryRequest(s.pipeline(), req)
+	if err != nil {
+		return err
+	}
+	ctx, err := grpcNamespace(stream.Context(), req.GetNamespace())
+	if err != nil {
+		return err
+	}
+	answer, err := s.pipeline().QueryStream(grpcPrincipals(ctx), q, func(delta string) error {
+		return stream.Send(&ragpb.QueryStreamResponse{Event: &ragpb.QueryStreamResponse_Delta{Delta: delta}})
+	})
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		return grpcError(err)
+	}
+	return stream.Send(&ragpb.QueryStreamResponse{Event: &ragpb.QueryStreamResponse_Done{Done: grpcAnswer(answer)}})
+}
+
+func (s *grpcServer) ListDocuments(ctx context.Context, req *ragpb.ListDocumentsRequest) (*ragpb.ListDocumentsResponse, error) {
+	ctx, err := grpcNamespace(ctx, req.GetNamespace())
+	if err != nil {
+		return nil, err
+	}
+	docs, err := s.pipeline().Documents(grpcPrincipals(ctx))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &ragpb.ListDocumentsResponse{Documents: make([]*ragpb.DocumentInfo, len(docs))}
+	for i, d := range docs {
+		resp.Documents[i] = &ragpb.DocumentInfo{Id: d.ID, Chunks: int32(d.Chunks)}
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) DeleteDocument(ctx context.Context, req *ragpb.DeleteDocumentRequest) (*ragpb.DeleteDocumentResponse, error) {
+	if req.GetId() == "" {
+		return nil, grpcError(invalidRequest("no document id"))
+	}
+	ctx, err := grpcNamespace(ctx, req.GetNamespace())
+	if err != nil {
+		return nil, err
+	}
+	ctx = grpcPrincipals(ctx)
+	if err := s.pipeline().checkAllowed(ctx, ErrDocumentNotFound, req.GetId()); err != nil {
+		return nil, grpcError(err)
+	}
+	if err := s.pipeline().Delete(ctx, req.GetId()); err != nil {
+		return nil, grpcError(err)
+	}
+	return &ragpb.DeleteDocumentResponse{}, nil
+}
+
+func (s *grpcServer) CreateNamespace(ctx context.Context, req *ragpb.CreateNamespaceRequest) (*ragpb.CreateNamespaceResponse, error) {
+	if err := checkNamespaceChange(req.GetName()); err != nil {
+		return nil, grpcError(withKind(ErrInvalidRequest, err))
+	}
+	if err := s.pipeline().CreateNamespace(ctx, req.GetName()); err != nil {
+		return nil, grpcError(err)
+	}
+	return &ragpb.CreateNamespaceResponse{}, nil
+}
+
+func (s *grpcServer) ListNamespaces(ctx context.Context, req *ragpb.ListNamespacesRequest) (*ragpb.ListNamespacesResponse, error) {
+	namespaces, err := s.pipeline().Namespaces(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &ragpb.ListNamespacesResponse{Namespaces: make([]*ragpb.NamespaceInfo, len(namespaces))}
+	for i, n := range namespaces {
+		resp.Namespaces[i] = &ragpb.NamespaceInfo{Name: n.Name, Chunks: int32(n.Chunks)}
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) DeleteNamespace(ctx context.Context, req *ragpb.DeleteNamespaceRequest) (*ragpb.DeleteNamespaceResponse, error) {
+	if err := checkNamespaceChange(req.GetName()); err != nil {
+		return nil, grpcError(withKind(ErrInvalidRequest, err))
+	}
+	if err := s.pipeline().DeleteNamespace(ctx, req.GetName()); err != nil {
+		return nil, grpcError(err)
+	}
+	return &ragpb.DeleteNamespaceResponse{}, nil
+}
+
+func (s *grpcServer) Feedback(ctx context.Context, req *ragpb.FeedbackRequest) (*ragpb.FeedbackResponse, error) {
+	if s.pipeline().Feedback == nil {
+		return nil, grpcError(fmt.Errorf("feedback is %w", ErrNotEnabled))
+	}
+	f := Feedback{AnswerID: req.GetAnswerId(), Rating: Rating(req.GetRating()), Comment: req.GetComment()}
+	if f.Rating != RatingUp && f.Rating != RatingDown {
+		return nil, grpcError(invalidRequest("rating must be %q or %q", RatingUp, RatingDown))
+	}
+	if err := s.pipeline().SubmitFeedback(ctx, f); err != nil {
+		return nil, grpcError(err)
+	}
+	return &ragpb.FeedbackResponse{}, nil
+}
+
+// grpcNamespace validates the namespace of a request, if it names one, and
+// returns a context for it.
+func grpcNamespace(ctx context.Context, ns string) (context.Context, error) {
+	if ns == "" {
+		return ctx, nil
+	}
+	if err := ValidateNamespace(ns); err != nil {
+		return nil, grpcError(withKind(ErrInvalidRequest, err))
+	}
+	return WithNamespace(ctx, ns), nil
+}
+
+// grpcPrincipals returns a context for the principals listed under the
+// PrincipalsHeader key of the incoming metadata.
+func grpcPrincipals(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return WithPrincipals(ctx, ParsePrincipals(strings.Join(md.Get(PrincipalsHeader), ","))...)
+}
+
+// grpcQueryRequest validates req for p and converts it to a QueryRequest.
+func grpcQueryRequest(p *Pipeline, req *ragpb.QueryRequest) (QueryRequest, error) {
+	if req.GetQuestion() == "" {
+		return QueryRequest{}, grpcError(invalidRequest("question must not be empty"))
+	}
+	if _, err := ParseFilter(req.GetFilter()); err != nil {
+		return QueryRequest{}, grpcError(invalidRequest("invalid filter: %w", err))
+	}
+	format := AnswerFormat(req.GetFormat())
+	if err := format.validate(); err != nil {
+		return QueryRequest{}, grpcError(withKind(ErrInvalidRequest, err))
+	}
+	opts := GenerationOptions{
+		Temperature:      req.Temperature,
+		TopP:             req.TopP,
+		MaxTokens:        int(req.GetMaxTokens()),
+		Stop:             req.GetStop(),
+		PresencePenalty:  req.PresencePenalty,
+		FrequencyPenalty: req.FrequencyPenalty,
+	}
+	if err := opts.validate(); err != nil {
+		return QueryRequest{}, grpcError(withKind(ErrInvalidRequest, err))
+	}
+	var agentSteps *int
+	if req.AgentSteps != nil {
+		n := int(req.GetAgentSteps())
+		agentSteps = &n
+	}
+	q := QueryRequest{
+		Question:          req.GetQuestion(),
+		K:                 int(req.GetK()),
+		SessionID:         req.GetSessionId(),
+		Filter:            req.GetFilter(),
+		Format:            format,
+		MinScore:          req.MinScore,
+		Rerank:            req.Rerank,
+		AgentSteps:        agentSteps,
+		GenerationOptions: opts,
+	}
+	if _, err := p.agentSteps(q); err != nil {
+		return QueryRequest{}, grpcError(withKind(ErrInvalidRequest, err))
+	}
+	if err := p.checkK(q); err != nil {
+		return QueryRequest{}, grpcError(withKind(ErrInvalidRequest, err))
+	}
+	return q, nil
+}
+
+func grpcAnswer(a *Answer) *ragpb.QueryResponse {
+	resp := &ragpb.QueryResponse{
+		Answer:     a.Answer,
+		Sources:    make([]*ragpb.SourceRef, len(a.Sources)),
+		Confidence: a.Confidence,
+		Cached:     a.Cached,
+		NoContext:  a.NoContext,
+		Route:      a.Route,
+		Id:         a.ID,
+		TimedOut:   a.TimedOut,
+		Provider:   a.Provider,
+	}
+	for _, n := range a.Citations {
+		resp.Citations = append(resp.Citations, int32(n))
+	}
+	if g := a.Grounding; g != nil {
+		resp.Grounding = &ragpb.Grounding{Score: g.Score, Unsupported: g.Unsupported, Stripped: g.Stripped, Regenerated: g.Regenerated}
+	}
+	for _, step := range a.Trace {
+		t := &ragpb.AgentStep{Step: int32(step.Step), Tool: step.Tool, Arguments: string(step.Arguments), Error: step.Error}
+		for _, n := range step.Passages {
+			t.Passages = append(t.Passages, int32(n))
+		}
+		resp.Trace = append(resp.Trace, t)
+	}
+	for i, s := range a.Sources {
+		resp.Sources[i] = &ragpb.SourceRef{
+			DocId:     s.DocID,
+			ChunkId:   s.ChunkID,
+			Chunk:     int32(s.Chunk),
+			Score:     s.Score,
+			Page:      int32(s.Page),
+			Url:       s.URL,
+			Text:      s.Text,
+			Cited:     s.Cited,
+			Injection: string(s.Injection),
+		}
+		if s.Span != nil {
+			resp.Sources[i].Span = &ragpb.Span{Start: int32(s.Span.Start), End: int32(s.Span.End)}
+		}
+	}
+	return resp
+}
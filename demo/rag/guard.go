@@ -0,0 +1,126 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"regexp"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InjectionMode selects what an InjectionGuard does with retrieved chunks
+// that look like they carry instructions for the model.
+type InjectionMode string
+
+const (
+	// InjectionFlag keeps the chunks but marks them in the prompt, so that
+	// the model is warned not to follow them.
+	InjectionFlag InjectionMode = "flag"
+	// InjectionStrip removes the sentences holding instructions from the
+	// chunks, dropping chunks that consist of nothing else.
+	InjectionStrip InjectionMode = "strip"
+)
+
+// injectionKey is the metadata key recording the InjectionMode applied to
+// a chunk, if any.
+const injectionKey = "injection"
+
+// injectionPatterns match the phrasings prompt injections typically use:
+// telling the model to disregard its instructions, giving it a new role or
+// new instructions, asking it to reveal its prompt or keep things from the
+// user, and chat markup impersonating other messages. A sentence matching
+// any of them is taken to be an injection.
+var injectionPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\b(ignore|disregard|forget|override|bypass)\b[^.!?\n]{0,40}\b(instructions?|prompts?|rules|directions|guidelines|guardrails)\b`),
+	regexp.MustCompile(`(?i)\b(you are now|from now on,? you|act as (an?|the|if)|pretend (to be|that you|you are)|roleplay as)\b`),
+	regexp.MustCompile(`(?i)\b(new|updated|real|actual|hidden|secret) (system )?(instructions?|prompt)\s*:`),
+	regexp.MustCompile(`(?i)\b(reveal|print|show|repeat|output|leak)\b[^.!?\n]{0,30}\b(system prompt|your (instructions|prompt))\b`),
+	regexp.MustCompile(`(?i)\b(do not|don't|never)\s+(tell|inform|mention|reveal|disclose)\b[^.!?\n]{0,20}\bthe user\b`),
+	regexp.MustCompile(`(?i)\b(if you are|as|attention,?|note to) (an? )?(ai|llm|language model|assistant|chatbot)\b`),
+	regexp.MustCompile(`(?im)<\|(im_start|im_end|system|endoftext)\|>|\[/?INST\]|<</?SYS>>|^\s*#{0,3}\s*(system|assistant)\s*:`),
+}
+
+// InjectionGuard scans retrieved chunks for prompt injections, instructions
+// hidden in documents that try to take over the model, before they are put
+// in the prompt. Detection is pattern based and cannot catch every
+// injection; it complements the default prompt, which encloses chunks in
+// <passage> tags and tells the model never to follow instructions in them.
+// Chunks found to contain injections record the Mode applied to them in
+// their "injection" metadata.
+type InjectionGuard struct {
+	Mode InjectionMode
+}
+
+// NewInjectionGuard returns the InjectionGuard selected by mode, which is
+// off, flag or strip, or nil if it is off or empty.
+func NewInjectionGuard(mode string) (*InjectionGuard, error) {
+	switch InjectionMode(mode) {
+	case "", "off":
+		return nil, nil
+	case InjectionFlag, InjectionStrip:
+		return &InjectionGuard{Mode: InjectionMode(mode)}, nil
+	}
+	return nil, fmt.Errorf("unknown injection guard mode %q", mode)
+}
+
+// Scan returns results with the chunks containing injections flagged or
+// stripped. The other chunks are returned unchanged, in their order.
+func (g *InjectionGuard) Scan(ctx context.Context, results []SearchResult) []SearchResult {
+	_, span := tracer.Start(ctx, "rag.guard", trace.WithAttributes(attribute.Int("rag.chunks", len(results))))
+	defer span.End()
+	sentences := make([][]string, len(results))
+	keep := make([][]bool, len(results))
+	scanned := make([]SearchResult, len(results))
+	found := 0
+	for i, r := range results {
+		scanned[i] = r
+		sentences[i] = splitSentences(r.Text)
+		for j, s := range sentences[i] {
+			if !isInjection(s) {
+				continue
+			}
+			if keep[i] == nil {
+				keep[i] = make([]bool, len(sentences[i]))
+				for k := range keep[i] {
+					keep[i][k] = true
+				}
+				found++
+			}
+			keep[i][j] = false
+		}
+		if keep[i] != nil {
+			// Results share their metadata with the store
+			scanned[i].Metadata = maps.Clone(r.Metadata)
+			if scanned[i].Metadata == nil {
+				scanned[i].Metadata = Metadata{}
+			}
+			scanned[i].Metadata[injectionKey] = string(g.Mode)
+			if g.Mode == InjectionFlag {
+				keep[i] = nil
+			}
+		}
+	}
+	span.SetAttributes(attribute.Int("rag.injections", found))
+	return compressResults(scanned, sentences, keep)
+}
+
+// isInjection reports whether a sentence matches any injection pattern.
+func isInjection(sentence string) bool {
+	for _, p := range injectionPatterns {
+		if p.MatchString(sentence) {
+			return true
+		}
+	}
+	return false
+}
+
+// passageTag matches the tags the default prompt encloses chunks in.
+var passageTag = regexp.MustCompile(`(?i)<(/?passage)\b`)
+
+// escapePassage keeps a chunk from closing the <passage> tag it is
+// enclosed in and posing as text outside of it.
+func escapePassage(text string) string {
+	return passageTag.ReplaceAllString(text, "&lt;$1")
+}
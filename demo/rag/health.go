@@ -0,0 +1,213 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// DefaultHealthInterval is how long a HealthCheck reuses the outcome of
+	// checking a component.
+	DefaultHealthInterval = 10 * time.Second
+	// DefaultHealthTimeout is how long a HealthCheck waits for a component.
+	DefaultHealthTimeout = 5 * time.Second
+)
+
+// The components a HealthCheck checks.
+const (
+	ComponentStore    = "vector_store"
+	ComponentEmbedder = "embedder"
+	ComponentLLM      = "llm"
+)
+
+// A HealthChecker can check that it reaches the service behind it more
+// cheaply than by doing its work, such as by pinging a database or listing
+// the models of a provider. Stores, embedders and LLMs that are not
+// HealthCheckers are checked by listing the namespaces, embedding a short
+// text and generating a single token.
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// ComponentHealth is the outcome of checking a component: "ok" or "error",
+// with the error, and how long the check took.
+type ComponentHealth struct {
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	LatencyMS int64     `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// A HealthReport is the outcome of a HealthCheck: "ok" if every component
+// checked is ok, and "unavailable" otherwise.
+type HealthReport struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentHealth `json:"components"`
+}
+
+// OK reports whether every component checked is ok.
+func (r *HealthReport) OK() bool {
+	return r.Status == "ok"
+}
+
+// A HealthCheck checks that a pipeline reaches its vector store, embedder
+// and LLM, as the /readyz endpoint reports. The outcome of checking a
+// component is reused for Interval, DefaultHealthInterval if zero, and
+// callers arriving during the check of a component wait for it rather than
+// start their own, so that probes sent at any rate reach the providers at
+// most once an interval. Components are checked independently, so that a
+// slow one only delays the callers asking for it. Every component is given
+// Timeout, DefaultHealthTimeout if zero. The zero value is ready to use.
+type HealthCheck struct {
+	Interval time.Duration
+	Timeout  time.Duration
+
+	mu         sync.Mutex
+	components map[string]*componentCheck
+}
+
+// componentCheck is the last outcome of checking a component. Its mutex is
+// held while the component is checked.
+type componentCheck struct {
+	mu      sync.Mutex
+	health  ComponentHealth
+	checked bool
+}
+
+// Check checks the given components of p, all of them if none is given,
+// those checked within the interval excepted.
+func (h *HealthCheck) Check(ctx context.Context, p *Pipeline, components ...string) *HealthReport {
+	if len(components) == 0 {
+		components = []string{ComponentStore, ComponentEmbedder, ComponentLLM}
+	}
+	results := make([]ComponentHealth, len(components))
+	var g errgroup.Group
+	for i, name := range components {
+		c := h.component(name)
+		g.Go(func() error {
+			results[i] = h.checkOnce(ctx, p, name, c)
+			return nil
+		})
+	}
+	g.Wait()
+	report := &HealthReport{Status: "ok", Components: make(map[string]ComponentHealth)}
+	for i, name := range components {
+		report.Components[name] = results[i]
+		if results[i].Status != "ok" {
+			report.Status = "unavailable"
+		}
+	}
+	return report
+}
+
+// component returns the componentCheck of the named component.
+func (h *HealthCheck) component(name string) *componentCheck {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.components == nil {
+		h.components = make(map[string]*componentCheck)
+	}
+	c, ok := h.components[name]
+	if !ok {
+		c = &componentCheck{}
+		h.components[name] = c
+	}
+	return c
+}
+
+// checkOnce returns the outcome of checking the named component, checking
+// it unless it was within the interval.
+func (h *HealthCheck) checkOnce(ctx context.Context, p *Pipeline, name string, c *componentCheck) ComponentHealth {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.checked && time.Since(c.health.CheckedAt) < cmp.Or(h.Interval, DefaultHealthInterval) {
+		return c.health
+	}
+	health := h.check(ctx, p, name)
+	// A check the caller gave up on says nothing of the component
+	if ctx.Err() == nil {
+		c.health, c.checked = health, true
+	}
+	return health
+}
+
+// check checks one component of p.
+func (h *HealthCheck) check(ctx context.Context, p *Pipeline, name string) ComponentHealth {
+	ctx, cancel := context.WithTimeoutCause(ctx, cmp.Or(h.Timeout, DefaultHealthTimeout),
+		fmt.Errorf("no reply after %v: %w", cmp.Or(h.Timeout, DefaultHealthTimeout), context.DeadlineExceeded))
+	defer cancel()
+	start := time.Now()
+	var err error
+	switch name {
+	case ComponentStore:
+		err = storeHealth(ctx, p.Store)
+	case ComponentEmbedder:
+		err = embedderHealth(ctx, p.Embedder)
+	case ComponentLLM:
+		err = llmHealth(ctx, p.LLM)
+	default:
+		err = fmt.Errorf("unknown component %q", name)
+	}
+	if err != nil && ctx.Err() != nil {
+		err = context.Cause(ctx)
+	}
+	c := ComponentHealth{Status: "ok", LatencyMS: time.Since(start).Milliseconds(), CheckedAt: time.Now()}
+	if err != nil {
+		c.Status, c.Error = "error", err.Error()
+	}
+	return c
+}
+
+// storeHealth checks s, by listing its namespaces unless it is a
+// HealthChecker.
+func storeHealth(ctx context.Context, s VectorStore) error {
+	if hc, ok := s.(HealthChecker); ok {
+		return hc.CheckHealth(ctx)
+	}
+	_, err := s.Namespaces(ctx)
+	return err
+}
+
+// embedderHealth checks e, by embedding a short text unless it is a
+// HealthChecker. The embedders the pipeline wraps its own in are looked
+// through, so that the text is not taken from the embedding cache and the
+// check is not counted in the metrics of embedding requests.
+func embedderHealth(ctx context.Context, e Embedder) error {
+	for {
+		switch w := e.(type) {
+		case namespaceEmbedder:
+			e = w.Embedder
+		case instrumentedEmbedder:
+			e = w.Embedder
+		case *CachedEmbedder:
+			e = w.Embedder
+		case HealthChecker:
+			return w.CheckHealth(ctx)
+		default:
+			_, err := e.Embed(ctx, []string{"health check"})
+			return err
+		}
+	}
+}
+
+// llmHealth checks l, by generating a single token unless it is a
+// HealthChecker, which the instrumentedLLM wrapping it may hide.
+func llmHealth(ctx context.Context, l LLM) error {
+	if w, ok := l.(instrumentedLLM); ok {
+		l = w.LLM
+	}
+	if u, ok := l.(unstructuredLLM); ok {
+		l = u.LLM
+	}
+	if hc, ok := l.(HealthChecker); ok {
+		return hc.CheckHealth(ctx)
+	}
+	ctx = WithGenerationOptions(ctx, GenerationOptions{MaxTokens: 1})
+	_, err := l.Generate(ctx, []Message{{Role: RoleUser, Content: "Reply with OK."}})
+	return err
+}
@@ -0,0 +1,387 @@
+package rag
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+)
+
+const (
+	// hnswM is how many neighbours a node links to on each layer above the
+	// bottom one, which allows twice as many.
+	hnswM = 16
+	// hnswEfConstruction and hnswEfSearch are how many candidates are kept
+	// while searching a layer for the neighbours of a new node and for the
+	// results of a search. More find better neighbours and results at the
+	// cost of speed.
+	hnswEfConstruction = 200
+	hnswEfSearch       = 100
+)
+
+// hnswIndex is a Hierarchical Navigable Small World graph of vectors for
+// approximate nearest neighbour search, as described by Malkov and
+// Yashunin. Every vector is a node linked to its nearest neighbours on the
+// bottom layer, and to neighbours on a random number of sparser layers
+// above it; a search descends from the single node of the top layer to the
+// nearest node of each layer and explores the bottom layer from there,
+// visiting a small part of the graph. Removed nodes are only marked
+// deleted, so the graph stays connected through them, and the graph is
+// rebuilt once they outnumber the others. A quantized index keeps only the
+// codes of its vectors, whose scores are estimates. An hnswIndex is not
+// safe for concurrent use; concurrent searches are, as long as nothing is
+// added or removed meanwhile.
+type hnswIndex struct {
+	metric       Metric
+	quantization Quantization
+	nodes        []hnswNode
+	ids          map[string]int32 // node of every chunk ID
+	entry        int32            // node searches start from, -1 if none
+	maxLevel     int
+	deleted      int
+	rng          *rand.Rand
+}
+
+// hnswNode is a node of an hnswIndex. friends holds its neighbours on each
+// layer it is on, from the bottom one up.
+type hnswNode struct {
+	id      string
+	vector  hnswVector
+	friends [][]int32
+	deleted bool
+}
+
+// hnswVector is a vector as the index stores and searches for it,
+// normalized for cosine similarity: its values, or its codes if the index
+// is quantized.
+type hnswVector struct {
+	values []float32
+	quantized
+}
+
+func newHNSWIndex(metric Metric, quantization Quantization) *hnswIndex {
+	return &hnswIndex{
+		metric:       metric,
+		quantization: quantization,
+		ids:          make(map[string]int32),
+		entry:        -1,
+		rng:          rand.New(rand.NewPCG(1, 2)),
+	}
+}
+
+// score is the similarity of two vectors as stored in the index.
+func (h *hnswIndex) score(u, v hnswVector) float32 {
+	if h.quantization != QuantizationOff {
+		return h.quantization.score(u.quantized, v.quantized)
+	}
+	a, b := u.values, v.values
+	n := min(len(a), len(b))
+	a, b = a[:n], b[:n]
+	var s0, s1, s2, s3 float32
+	i := 0
+	for ; i+4 <= n; i += 4 {
+		s0 += a[i] * b[i]
+		s1 += a[i+1] * b[i+1]
+		s2 += a[i+2] * b[i+2]
+		s3 += a[i+3] * b[i+3]
+	}
+	for ; i < n; i++ {
+		s0 += a[i] * b[i]
+	}
+	return s0 + s1 + s2 + s3
+}
+
+// prepare returns vector as it is stored and searched for.
+func (h *hnswIndex) prepare(vector []float32) hnswVector {
+	vector = normalize(h.metric, vector)
+	if h.quantization != QuantizationOff {
+		return hnswVector{quantized: h.quantization.quantize(vector)}
+	}
+	return hnswVector{values: vector}
+}
+
+// add adds the vector of a chunk, replacing the one added before for the
+// same ID.
+func (h *hnswIndex) add(id string, vector []float32) {
+	h.remove(id)
+	h.insert(id, h.prepare(vector))
+}
+
+// insert adds a node for a prepared vector.
+func (h *hnswIndex) insert(id string, vector hnswVector) {
+	// Layers get sparser by a factor of hnswM
+	level := min(int(-math.Log(1-h.rng.Float64())/math.Log(hnswM)), 16)
+	n := int32(len(h.nodes))
+	h.nodes = append(h.nodes, hnswNode{id: id, vector: vector, friends: make([][]int32, level+1)})
+	h.ids[id] = n
+	if h.entry < 0 {
+		h.entry, h.maxLevel = n, level
+		return
+	}
+	entry := h.entry
+	for l := h.maxLevel; l > level; l-- {
+		entry = h.greedy(vector, entry, l)
+	}
+	entries := []int32{entry}
+	for l := min(level, h.maxLevel); l >= 0; l-- {
+		found := h.searchLayer(vector, entries, hnswEfConstruction, l)
+		// Link new nodes to live ones only
+		live := slices.DeleteFunc(slices.Clone(found), func(c hnswCandidate) bool { return h.nodes[c.node].deleted })
+		friends := h.selectNeighbours(live, h.maxFriends(l))
+		h.nodes[n].friends[l] = friends
+		for _, f := range friends {
+			h.link(f, n, l)
+		}
+		entries = entries[:0]
+		for _, c := range found {
+			entries = append(entries, c.node)
+		}
+	}
+	if level > h.maxLevel {
+		h.entry, h.maxLevel = n, level
+	}
+}
+
+// remove removes the vector of a chunk, if it was added.
+func (h *hnswIndex) remove(id string) {
+	n, ok := h.ids[id]
+	if !ok {
+		return
+	}
+	h.nodes[n].deleted = true
+	delete(h.ids, id)
+	h.deleted++
+	switch {
+	case len(h.ids) == 0:
+		*h = *newHNSWIndex(h.metric, h.quantization)
+	case h.deleted > len(h.ids):
+		h.rebuild()
+	}
+}
+
+// rebuild builds the graph again from the nodes that are not deleted.
+func (h *hnswIndex) rebuild() {
+	nodes := h.nodes
+	*h = *newHNSWIndex(h.metric, h.quantization)
+	for _, node := range nodes {
+		if !node.deleted {
+			h.insert(node.id, node.vector)
+		}
+	}
+}
+
+// maxFriends is how many neighbours a node may have on layer l.
+func (h *hnswIndex) maxFriends(l int) int {
+	if l == 0 {
+		return 2 * hnswM
+	}
+	return hnswM
+}
+
+// link links node from to node to on layer l, dropping the least similar
+// neighbour of from if it has too many.
+func (h *hnswIndex) link(from, to int32, l int) {
+	node := &h.nodes[from]
+	node.friends[l] = append(node.friends[l], to)
+	if len(node.friends[l]) <= h.maxFriends(l) {
+		return
+	}
+	candidates := make([]hnswCandidate, 0, len(node.friends[l]))
+	for _, f := range node.friends[l] {
+		if !h.nodes[f].deleted {
+			candidates = append(candidates, hnswCandidate{f, h.score(node.vector, h.nodes[f].vector)})
+		}
+	}
+	slices.SortFunc(candidates, func(a, b hnswCandidate) int { return compareCandidates(b, a) })
+	// Keeping the most similar is much cheaper than selectNeighbours, and
+	// these links matter less than those of new nodes
+	node.friends[l] = node.friends[l][:0]
+	for _, c := range candidates[:min(len(candidates), h.maxFriends(l))] {
+		node.friends[l] = append(node.friends[l], c.node)
+	}
+}
+
+// selectNeighbours picks up to m neighbours among candidates, sorted from
+// the most similar: a candidate more similar to a neighbour picked already
+// than to the node is skipped, so that neighbours lie in different
+// directions, unless too few others remain.
+func (h *hnswIndex) selectNeighbours(candidates []hnswCandidate, m int) []int32 {
+	friends := make([]int32, 0, m)
+	var skipped []int32
+	for _, c := range candidates {
+		if len(friends) == m {
+			break
+		}
+		diverse := true
+		for _, f := range friends {
+			if h.score(h.nodes[c.node].vector, h.nodes[f].vector) > c.score {
+				diverse = false
+				break
+			}
+		}
+		if diverse {
+			friends = append(friends, c.node)
+		} else {
+			skipped = append(skipped, c.node)
+		}
+	}
+	for _, s := range skipped {
+		if len(friends) == m {
+			break
+		}
+		friends = append(friends, s)
+	}
+	return friends
+}
+
+// greedy walks layer l from entry to the node most similar to vector.
+func (h *hnswIndex) greedy(vector hnswVector, entry int32, l int) int32 {
+	best := h.score(vector, h.nodes[entry].vector)
+	for changed := true; changed; {
+		changed = false
+		for _, f := range h.nodes[entry].friends[l] {
+			if s := h.score(vector, h.nodes[f].vector); s > best {
+				entry, best, changed = f, s, true
+			}
+		}
+	}
+	return entry
+}
+
+// searchLayer returns the ef nodes of layer l most similar to vector that
+// it finds exploring the layer from entries, deleted nodes included, from
+// the most similar.
+func (h *hnswIndex) searchLayer(vector hnswVector, entries []int32, ef, l int) []hnswCandidate {
+	visited := make([]uint64, (len(h.nodes)+63)/64)
+	candidates := hnswQueue{best: true}
+	var found hnswQueue
+	for _, e := range entries {
+		visited[e/64] |= 1 << (e % 64)
+		c := hnswCandidate{e, h.score(vector, h.nodes[e].vector)}
+		candidates.push(c)
+		found.push(c)
+	}
+	for found.len() > ef {
+		found.pop()
+	}
+	for candidates.len() > 0 {
+		c := candidates.pop()
+		if found.len() >= ef && c.score < found.top().score {
+			break
+		}
+		for _, f := range h.nodes[c.node].friends[l] {
+			if visited[f/64]&(1<<(f%64)) != 0 {
+				continue
+			}
+			visited[f/64] |= 1 << (f % 64)
+			s := h.score(vector, h.nodes[f].vector)
+			if found.len() < ef || s > found.top().score {
+				candidates.push(hnswCandidate{f, s})
+				found.push(hnswCandidate{f, s})
+				if found.len() > ef {
+					found.pop()
+				}
+			}
+		}
+	}
+	results := found.items
+	slices.SortFunc(results, func(a, b hnswCandidate) int { return compareCandidates(b, a) })
+	return results
+}
+
+// search returns the IDs of up to k of the chunks most similar to query
+// that match, searching ef candidates; the results are approximate. match
+// may be nil.
+func (h *hnswIndex) search(query []float32, k, ef int, match func(id string) bool) []string {
+	if h.entry < 0 {
+		return nil
+	}
+	vector := h.prepare(query)
+	entry := h.entry
+	for l := h.maxLevel; l > 0; l-- {
+		entry = h.greedy(vector, entry, l)
+	}
+	var ids []string
+	for _, c := range h.searchLayer(vector, []int32{entry}, max(ef, k), 0) {
+		node := &h.nodes[c.node]
+		if node.deleted || match != nil && !match(node.id) {
+			continue
+		}
+		if ids = append(ids, node.id); len(ids) == k {
+			break
+		}
+	}
+	return ids
+}
+
+// hnswCandidate is a node found in a search, with its similarity to the
+// vector searched for.
+type hnswCandidate struct {
+	node  int32
+	score float32
+}
+
+// compareCandidates orders candidates by similarity, breaking ties by node
+// so that searches are deterministic.
+func compareCandidates(a, b hnswCandidate) int {
+	if a.score != b.score {
+		if a.score < b.score {
+			return -1
+		}
+		return 1
+	}
+	return int(b.node - a.node)
+}
+
+// hnswQueue is a binary heap of candidates that pops the least similar
+// candidate first, or the most similar one if best is set.
+type hnswQueue struct {
+	items []hnswCandidate
+	best  bool
+}
+
+func (q *hnswQueue) len() int { return len(q.items) }
+
+// top returns the candidate pop would return.
+func (q *hnswQueue) top() hnswCandidate { return q.items[0] }
+
+// before reports whether a pops before b.
+func (q *hnswQueue) before(a, b hnswCandidate) bool {
+	if q.best {
+		return compareCandidates(a, b) > 0
+	}
+	return compareCandidates(a, b) < 0
+}
+
+func (q *hnswQueue) push(c hnswCandidate) {
+	q.items = append(q.items, c)
+	for i := len(q.items) - 1; i > 0; {
+		parent := (i - 1) / 2
+		if !q.before(q.items[i], q.items[parent]) {
+			break
+		}
+		q.items[i], q.items[parent] = q.items[parent], q.items[i]
+		i = parent
+	}
+}
+
+func (q *hnswQueue) pop() hnswCandidate {
+	top := q.items[0]
+	last := len(q.items) - 1
+	q.items[0] = q.items[last]
+	q.items = q.items[:last]
+	for i := 0; ; {
+		first, left, right := i, 2*i+1, 2*i+2
+		if left < last && q.before(q.items[left], q.items[first]) {
+			first = left
+		}
+		if right < last && q.before(q.items[right], q.items[first]) {
+			first = right
+		}
+		if first == i {
+			break
+		}
+		q.items[i], q.items[first] = q.items[first], q.items[i]
+		i = first
+	}
+	return top
+}
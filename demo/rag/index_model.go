@@ -0,0 +1,213 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrModelMismatch is returned for embeddings that cannot be compared with
+// those of the index, see ModelStore: embeddings of another model, or with
+// other dimensions or normalization, whose similarity scores would mean
+// nothing.
+var ErrModelMismatch = errors.New("embedding model mismatch")
+
+// IndexModel describes the embeddings of an index: the embedding model that
+// made them, such as "openai/text-embedding-3-small", their dimensions and
+// whether they are normalized to unit length.
+type IndexModel struct {
+	Model      string `json:"model"`
+	Dimensions int    `json:"dimensions"`
+	Normalized bool   `json:"normalized"`
+}
+
+func (m IndexModel) String() string {
+	s := fmt.Sprintf("%d dimensions", m.Dimensions)
+	if m.Normalized {
+		s += ", normalized"
+	}
+	if m.Model == "" {
+		return s
+	}
+	return fmt.Sprintf("%s (%s)", m.Model, s)
+}
+
+// A ModelStore records the IndexModel of the embeddings it holds, so that
+// the pipeline refuses to store embeddings of another model with them, or
+// to search them with one, see Pipeline.EmbeddingModel. The model is that
+// of the whole store, whose namespaces share the pipeline's Embedder but
+// for those whose NamespaceProfile names a model of their own.
+// IndexModel returns nil if none was recorded, as for a new store;
+// SetIndexModel replaces the recorded model.
+type ModelStore interface {
+	VectorStore
+	IndexModel(ctx context.Context) (*IndexModel, error)
+	SetIndexModel(ctx context.Context, m IndexModel) error
+}
+
+// modelCacheTTL is how long the stores keeping their IndexModel elsewhere
+// than in memory keep the model they read, so that searches do not read it
+// again every time; a model recorded by another instance sharing the store
+// is seen once it passed.
+const modelCacheTTL = 10 * time.Second
+
+// A modelCache keeps the IndexModel a store read or recorded.
+type modelCache struct {
+	mu     sync.Mutex
+	model  *IndexModel
+	readAt time.Time
+}
+
+// get returns the cached model, reading it with read if it is older than
+// modelCacheTTL.
+func (c *modelCache) get(read func() (*IndexModel, error)) (*IndexModel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.readAt.IsZero() || time.Since(c.readAt) >= modelCacheTTL {
+		m, err := read()
+		if err != nil {
+			return nil, err
+		}
+		c.model, c.readAt = m, time.Now()
+	}
+	if c.model == nil {
+		return nil, nil
+	}
+	m := *c.model
+	return &m, nil
+}
+
+// set caches m as the model just recorded.
+func (c *modelCache) set(m IndexModel) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.model, c.readAt = &m, time.Now()
+}
+
+// unitLength reports whether v has unit length, and whether it can tell:
+// zero vectors, as some embedders return for texts without a word, have no
+// direction to normalize.
+func unitLength(v []float32) (unit, ok bool) {
+	var norm float64
+	for _, x := range v {
+		norm += float64(x) * float64(x)
+	}
+	if norm == 0 {
+		return false, false
+	}
+	return math.Abs(math.Sqrt(norm)-1) < 1e-3, true
+}
+
+// checkModel returns an ErrModelMismatch if embedding, made by the model
+// named model, cannot be compared with the embeddings recorded describes.
+// Models are only compared if both are named.
+func checkModel(recorded *IndexModel, model string, embedding []float32) error {
+	if recorded == nil || len(embedding) == 0 {
+		return nil
+	}
+	got := IndexModel{Model: model, Dimensions: len(embedding), Normalized: recorded.Normalized}
+	if unit, ok := unitLength(embedding); ok {
+		got.Normalized = unit
+	}
+	if model == "" || recorded.Model == "" {
+		got.Model = recorded.Model
+	}
+	if got != *recorded {
+		return fmt.Errorf("%w: the index holds embeddings of %s, not %s; reindex it to change the embedding model", ErrModelMismatch, recorded, got)
+	}
+	return nil
+}
+
+// recordModel checks the embeddings of chunks, made by the model named
+// model, against the model recorded with s if it is a ModelStore, and
+// records theirs if none is. The model of a store holding no chunks is
+// replaced, so that an emptied index can be filled anew with another
+// model. Chunks of namespaces with their own embedding model are not
+// checked, see ownModel.
+func recordModel(ctx context.Context, s VectorStore, model string, chunks []Chunk) error {
+	ms, ok := s.(ModelStore)
+	if !ok {
+		return nil
+	}
+	// Tell normalization from the first embedding that is not a zero vector
+	var embedding []float32
+	for _, c := range chunks {
+		if len(c.Embedding) == 0 {
+			continue
+		}
+		if embedding == nil {
+			embedding = c.Embedding
+		}
+		if _, ok := unitLength(c.Embedding); ok {
+			embedding = c.Embedding
+			break
+		}
+	}
+	if embedding == nil {
+		return nil
+	}
+	recorded, err := ms.IndexModel(ctx)
+	if err != nil {
+		return err
+	}
+	mismatch := checkModel(recorded, model, embedding)
+	if recorded != nil && mismatch == nil {
+		return nil
+	}
+	if own, err := ownModel(ctx, s); err != nil || own {
+		return err
+	}
+	if mismatch != nil {
+		if empty, err := emptyStore(ctx, s); err != nil || !empty {
+			return cmp.Or(err, mismatch)
+		}
+	}
+	unit, _ := unitLength(embedding)
+	return ms.SetIndexModel(ctx, IndexModel{Model: model, Dimensions: len(embedding), Normalized: unit})
+}
+
+// emptyStore reports whether s holds no chunks in any namespace embedded
+// with the model it records, that is without its own, see ownModel.
+func emptyStore(ctx context.Context, s VectorStore) (bool, error) {
+	namespaces, err := s.Namespaces(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, ns := range namespaces {
+		if ns.Chunks == 0 {
+			continue
+		}
+		own, err := ownModel(WithNamespace(ctx, ns.Name), s)
+		if err != nil || !own {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// checkQuery returns an ErrModelMismatch if the embedding of a query, made
+// by the model named model, cannot be compared with the embeddings of s, if
+// it is a ModelStore, unless the namespace has its own model. The profile
+// of the namespace is only read for embeddings that do not match.
+func checkQuery(ctx context.Context, s VectorStore, model string, embedding []float32) error {
+	ms, ok := s.(ModelStore)
+	if !ok {
+		return nil
+	}
+	recorded, err := ms.IndexModel(ctx)
+	if err != nil {
+		return err
+	}
+	mismatch := checkModel(recorded, model, embedding)
+	if mismatch == nil {
+		return nil
+	}
+	if own, err := ownModel(ctx, s); err != nil || own {
+		return err
+	}
+	return mismatch
+}
@@ -0,0 +1,318 @@
+package rag
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// jobTimeFormat is how job times are stored, with a fixed number of
+// digits so that they sort as text.
+const jobTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// jobGroup is how many documents of a job are ingested together; progress
+// is recorded after every group.
+const jobGroup = 32
+
+// ErrJobNotFound is returned for a job ID that is not in a JobQueue.
+var ErrJobNotFound = errors.New("job not found")
+
+var jobMigrations = []string{
+	`CREATE TABLE jobs (
+		id         TEXT PRIMARY KEY,
+		namespace  TEXT NOT NULL,
+		status     TEXT NOT NULL,
+		documents  INTEGER NOT NULL,
+		processed  INTEGER NOT NULL DEFAULT 0,
+		chunks     INTEGER NOT NULL DEFAULT 0,
+		embedded   INTEGER NOT NULL DEFAULT 0,
+		failed     TEXT NOT NULL DEFAULT '[]',
+		error      TEXT NOT NULL DEFAULT '',
+		created_at TEXT NOT NULL,
+		updated_at TEXT NOT NULL
+	) WITHOUT ROWID`,
+	`CREATE TABLE job_documents (
+		job_id   TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		document TEXT NOT NULL,
+		PRIMARY KEY (job_id, position)
+	) WITHOUT ROWID`,
+}
+
+// JobStatus is the state of an ingestion job.
+type JobStatus string
+
+const (
+	JobQueued  JobStatus = "queued"
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+	JobFailed  JobStatus = "failed"
+)
+
+// A Job ingests documents in the background. Processed counts the
+// documents ingested so far, successfully or not, and Chunks and Embedded
+// add up their IngestResults; Failed lists the documents that could not be
+// ingested. Error says why a job failed as a whole.
+type Job struct {
+	ID        string         `json:"id"`
+	Namespace string         `json:"namespace"`
+	Status    JobStatus      `json:"status"`
+	Documents int            `json:"documents"`
+	Processed int            `json:"processed"`
+	Chunks    int            `json:"chunks"`
+	Embedded  int            `json:"embedded"`
+	Failed    []IngestResult `json:"failed,omitempty"`
+	Error     string         `json:"error,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// A JobQueue ingests documents into Pipeline in the background, one job at
+// a time in the order they were submitted. Jobs and the documents they have
+// yet to ingest are kept in a SQLite file, so jobs interrupted by a restart
+// continue where they left off once Run is called again.
+type JobQueue struct {
+	Pipeline *Pipeline
+
+	db   *sql.DB
+	wake chan struct{}
+}
+
+// OpenJobQueue opens or creates the job file at path, creating its
+// directory if needed.
+func OpenJobQueue(ctx context.Context, path string, p *Pipeline) (*JobQueue, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("job queue: %w", err)
+	}
+	db, err := openSQLite(ctx, path, jobMigrations)
+	if err != nil {
+		return nil, fmt.Errorf("job queue: %w", err)
+	}
+	return &JobQueue{Pipeline: p, db: db, wake: make(chan struct{}, 1)}, nil
+}
+
+// Close closes the job file.
+func (q *JobQueue) Close() error {
+	return q.db.Close()
+}
+
+// Submit queues a job ingesting docs into the namespace of ctx.
+func (q *JobQueue) Submit(ctx context.Context, docs []*Document) (*Job, error) {
+	now := time.Now().UTC()
+	job := &Job{
+		ID:        rand.Text(),
+		Namespace: NamespaceFrom(ctx),
+		Status:    JobQueued,
+		Documents: len(docs),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	err := sqliteTx(ctx, q.db, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO jobs (id, namespace, status, documents, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?)`, job.ID, job.Namespace, job.Status, job.Documents, formatJobTime(now), formatJobTime(now))
+		if err != nil {
+			return err
+		}
+		stmt, err := tx.PrepareContext(ctx, `INSERT INTO job_documents (job_id, position, document) VALUES (?, ?, ?)`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for i, doc := range docs {
+			data, err := json.Marshal(doc)
+			if err != nil {
+				return err
+			}
+			if _, err := stmt.ExecContext(ctx, job.ID, i, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("submitting job: %w", err)
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// Job returns the job with the given ID.
+func (q *JobQueue) Job(ctx context.Context, id string) (*Job, error) {
+	jobs, err := q.query(ctx, `WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	return &jobs[0], nil
+}
+
+// Jobs lists the jobs submitted to the namespace of ctx, newest first.
+func (q *JobQueue) Jobs(ctx context.Context) ([]Job, error) {
+	return q.query(ctx, `WHERE namespace = ? ORDER BY created_at DESC, id`, NamespaceFrom(ctx))
+}
+
+func (q *JobQueue) query(ctx context.Context, where string, args ...any) ([]Job, error) {
+	rows, err := q.db.QueryContext(ctx, `SELECT id, namespace, status, documents, processed, chunks, embedded, failed, error, created_at, updated_at
+		FROM jobs `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	jobs := []Job{}
+	for rows.Next() {
+		var job Job
+		var failed, created, updated string
+		if err := rows.Scan(&job.ID, &job.Namespace, &job.Status, &job.Documents, &job.Processed, &job.Chunks, &job.Embedded, &failed, &job.Error, &created, &updated); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(failed), &job.Failed); err != nil {
+			return nil, fmt.Errorf("job %s: %w", job.ID, err)
+		}
+		job.CreatedAt, _ = time.Parse(jobTimeFormat, created)
+		job.UpdatedAt, _ = time.Parse(jobTimeFormat, updated)
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// Run processes queued jobs until ctx is done, starting with jobs left
+// running or queued when the process last stopped.
+func (q *JobQueue) Run(ctx context.Context) error {
+	for {
+		jobs, err := q.query(ctx, `WHERE status IN (?, ?) ORDER BY created_at, id LIMIT 1`, JobQueued, JobRunning)
+		if err != nil {
+			return err
+		}
+		if len(jobs) == 0 {
+			select {
+			case <-q.wake:
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if err := q.run(ctx, &jobs[0]); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+	}
+}
+
+// run ingests the documents of job that were not processed yet. Errors
+// aborting the ingestion fail the job; only errors of the job file are
+// returned.
+func (q *JobQueue) run(ctx context.Context, job *Job) error {
+	ictx := WithNamespace(ctx, job.Namespace)
+	job.Status = JobRunning
+	if err := q.update(ctx, job); err != nil {
+		return err
+	}
+	for job.Processed < job.Documents {
+		docs, err := q.documents(ctx, job.ID, job.Processed, jobGroup)
+		if err != nil {
+			return err
+		}
+		results, err := q.Pipeline.IngestAll(ictx, docs)
+		if ctx.Err() != nil {
+			// Shutting down; the job continues from this group after a restart
+			return ctx.Err()
+		}
+		var batchErr *BatchError
+		if err != nil && !errors.As(err, &batchErr) {
+			job.Status, job.Error = JobFailed, err.Error()
+			return q.finish(ctx, job)
+		}
+		for _, r := range results {
+			if r.Error != "" {
+				job.Failed = append(job.Failed, r)
+				continue
+			}
+			job.Chunks += r.Chunks
+			job.Embedded += r.Embedded
+		}
+		job.Processed += len(docs)
+		if err := q.update(ctx, job); err != nil {
+			return err
+		}
+	}
+	job.Status = JobDone
+	return q.finish(ctx, job)
+}
+
+// documents decodes up to n documents of a job from position start on.
+func (q *JobQueue) documents(ctx context.Context, id string, start, n int) ([]*Document, error) {
+	rows, err := q.db.QueryContext(ctx, `SELECT document FROM job_documents
+		WHERE job_id = ? AND position >= ? ORDER BY position LIMIT ?`, id, start, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var docs []*Document
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		doc := new(Document)
+		if err := json.Unmarshal([]byte(data), doc); err != nil {
+			return nil, fmt.Errorf("job %s: %w", id, err)
+		}
+		docs = append(docs, doc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("job %s: documents from %d on are missing", id, start)
+	}
+	return docs, nil
+}
+
+// update records the progress of job.
+func (q *JobQueue) update(ctx context.Context, job *Job) error {
+	failed := []byte("[]")
+	if len(job.Failed) > 0 {
+		var err error
+		if failed, err = json.Marshal(job.Failed); err != nil {
+			return err
+		}
+	}
+	job.UpdatedAt = time.Now().UTC()
+	_, err := q.db.ExecContext(ctx, `UPDATE jobs SET status = ?, processed = ?, chunks = ?, embedded = ?, failed = ?, error = ?, updated_at = ?
+		WHERE id = ?`, job.Status, job.Processed, job.Chunks, job.Embedded, failed, job.Error, formatJobTime(job.UpdatedAt), job.ID)
+	return err
+}
+
+// finish records the final state of job, drops its documents and sends
+// the pipeline's Webhooks the event of its end.
+func (q *JobQueue) finish(ctx context.Context, job *Job) error {
+	if err := q.update(ctx, job); err != nil {
+		return err
+	}
+	if _, err := q.db.ExecContext(ctx, `DELETE FROM job_documents WHERE job_id = ?`, job.ID); err != nil {
+		return err
+	}
+	event := EventJobCompleted
+	if job.Status == JobFailed {
+		event = EventJobFailed
+	}
+	q.Pipeline.Webhooks.send(WithNamespace(ctx, job.Namespace), event, job)
+	return nil
+}
+
+func formatJobTime(t time.Time) string {
+	return t.UTC().Format(jobTimeFormat)
+}
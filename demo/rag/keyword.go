@@ -0,0 +1,203 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// BM25 parameters: k1 controls term frequency saturation and b how strongly
+// scores are normalized by chunk length.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// KeywordIndex is an in-memory BM25 index over chunk text. It complements
+// dense retrieval for exact matches such as error codes and identifiers. It
+// has the same Upsert and Delete methods as a VectorStore, including keeping
+// namespaces apart, and implements Retriever.
+//
+// If Source is set, the chunks of a namespace are read from it the first
+// time the namespace is searched, so that an index kept in sync with a
+// persistent store also finds the chunks stored before it was created,
+// such as before a restart.
+type KeywordIndex struct {
+	Source ChunkStore
+
+	mu     sync.RWMutex
+	spaces map[string]*keywordSpace
+	loaded map[string]bool // namespaces read from Source
+}
+
+// keywordSpace is the index of one namespace.
+type keywordSpace struct {
+	chunks   map[string]Chunk
+	lengths  map[string]int
+	postings map[string]map[string]int // term -> chunk ID -> term frequency
+	total    int
+}
+
+// NewKeywordIndex creates an empty KeywordIndex.
+func NewKeywordIndex() *KeywordIndex {
+	return &KeywordIndex{spaces: make(map[string]*keywordSpace), loaded: make(map[string]bool)}
+}
+
+func (idx *KeywordIndex) Upsert(ctx context.Context, chunks []Chunk) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.upsert(NamespaceFrom(ctx), chunks)
+	return nil
+}
+
+// upsert adds chunks to the index of namespace ns; the index's mu must be
+// held.
+func (idx *KeywordIndex) upsert(ns string, chunks []Chunk) {
+	space := idx.spaces[ns]
+	if space == nil {
+		space = &keywordSpace{
+			chunks:   make(map[string]Chunk),
+			lengths:  make(map[string]int),
+			postings: make(map[string]map[string]int),
+		}
+		idx.spaces[ns] = space
+	}
+	for _, c := range chunks {
+		space.remove(c.ID)
+		c.Embedding = nil
+		terms := tokenize(c.Text)
+		space.chunks[c.ID] = c
+		space.lengths[c.ID] = len(terms)
+		space.total += len(terms)
+		for _, term := range terms {
+			if space.postings[term] == nil {
+				space.postings[term] = make(map[string]int)
+			}
+			space.postings[term][c.ID]++
+		}
+	}
+}
+
+// load reads the chunks of the namespace of ctx from Source, unless they
+// were read before. The index's mu is held while they are read, so that
+// chunks written to Source and then to the index meanwhile are indexed as
+// they were last written.
+func (idx *KeywordIndex) load(ctx context.Context) error {
+	ns := NamespaceFrom(ctx)
+	idx.mu.RLock()
+	loaded := idx.loaded[ns]
+	idx.mu.RUnlock()
+	if loaded || idx.Source == nil {
+		return nil
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.loaded[ns] {
+		return nil
+	}
+	docs, err := idx.Source.Documents(ctx)
+	if err != nil {
+		return fmt.Errorf("keyword index: %w", err)
+	}
+	for _, doc := range docs {
+		chunks, err := idx.Source.Chunks(ctx, doc.ID)
+		if err != nil {
+			return fmt.Errorf("keyword index: reading chunks of %s: %w", doc.ID, err)
+		}
+		idx.upsert(ns, chunks)
+	}
+	idx.loaded[ns] = true
+	return nil
+}
+
+func (idx *KeywordIndex) Delete(ctx context.Context, docID string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	space := idx.spaces[NamespaceFrom(ctx)]
+	if space == nil {
+		return nil
+	}
+	for id, c := range space.chunks {
+		if c.DocID == docID {
+			space.remove(id)
+		}
+	}
+	return nil
+}
+
+// DeleteNamespace drops the index of a namespace.
+func (idx *KeywordIndex) DeleteNamespace(name string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	delete(idx.spaces, name)
+	delete(idx.loaded, name)
+}
+
+// remove drops a chunk from the index; the index's mu must be held.
+func (space *keywordSpace) remove(id string) {
+	c, ok := space.chunks[id]
+	if !ok {
+		return
+	}
+	for _, term := range tokenize(c.Text) {
+		delete(space.postings[term], id)
+		if len(space.postings[term]) == 0 {
+			delete(space.postings, term)
+		}
+	}
+	space.total -= space.lengths[id]
+	delete(space.lengths, id)
+	delete(space.chunks, id)
+}
+
+func (idx *KeywordIndex) Retrieve(ctx context.Context, query string, k int, filter Filter) ([]SearchResult, error) {
+	if err := idx.load(ctx); err != nil {
+		return nil, err
+	}
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	space := idx.spaces[NamespaceFrom(ctx)]
+	if space == nil || len(space.chunks) == 0 {
+		return nil, nil
+	}
+	n := float64(len(space.chunks))
+	avgLen := float64(space.total) / n
+	scores := make(map[string]float64)
+	seen := make(map[string]bool)
+	for _, term := range tokenize(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		postings := space.postings[term]
+		df := float64(len(postings))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for id, tf := range postings {
+			norm := 1 - bm25B + bm25B*float64(space.lengths[id])/avgLen
+			scores[id] += idf * float64(tf) * (bm25K1 + 1) / (float64(tf) + bm25K1*norm)
+		}
+	}
+	results := make([]SearchResult, 0, len(scores))
+	for id, score := range scores {
+		if !filter.Match(space.chunks[id].Metadata) {
+			continue
+		}
+		results = append(results, SearchResult{Chunk: space.chunks[id], Score: float32(score)})
+	}
+	sortResults(results)
+	if len(results) > k {
+		results = results[:k]
+	}
+	recordScores(ctx, sparseScore, results)
+	return results, nil
+}
+
+// tokenize lower-cases text and splits it into runs of letters and digits.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
@@ -0,0 +1,194 @@
+package rag
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"unicode"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// LanguageMode selects what a Pipeline does with the languages of
+// documents and questions.
+type LanguageMode string
+
+const (
+	// LanguageTag records the language of every ingested document in its
+	// LanguageKey metadata, so that retrieval can be filtered by it.
+	LanguageTag LanguageMode = "tag"
+	// LanguageFilter also restricts retrieval to the chunks in the
+	// language of the question, when it can be told.
+	LanguageFilter LanguageMode = "filter"
+)
+
+// LanguageKey is the metadata key holding the ISO 639-1 code of the
+// language of a document, such as "en" or "de".
+const LanguageKey = "language"
+
+// languageSample is how much of a document's text its language is told
+// from, in bytes.
+const languageSample = 4000
+
+// stopwords are the most frequent words of the languages written in the
+// Latin script that DetectLanguage tells apart.
+var stopwords = map[string][]string{
+	"en": {"the", "and", "of", "to", "is", "in", "that", "it", "for", "was", "with", "are", "this", "be", "on", "not", "have", "what", "which", "you", "from", "how", "does", "can", "they"},
+	"de": {"der", "die", "und", "das", "ist", "nicht", "ein", "eine", "zu", "den", "von", "mit", "sich", "des", "auf", "für", "dem", "auch", "es", "wie", "wird", "sind", "ich", "wer", "was", "welche"},
+	"fr": {"le", "la", "les", "et", "des", "est", "une", "un", "du", "que", "pas", "pour", "dans", "qui", "ce", "sur", "avec", "sont", "au", "il", "elle", "nous", "vous", "quel", "quelle", "comment"},
+	"es": {"el", "la", "los", "las", "y", "de", "que", "en", "es", "un", "una", "por", "con", "no", "para", "del", "se", "como", "más", "pero", "su", "qué", "cómo", "cuál", "está", "son"},
+	"it": {"il", "di", "che", "e", "la", "le", "è", "un", "una", "per", "non", "sono", "con", "del", "della", "gli", "si", "come", "anche", "questo", "dei", "nel", "cosa", "qual", "perché"},
+	"pt": {"o", "a", "os", "as", "de", "que", "e", "do", "da", "em", "um", "uma", "não", "para", "com", "é", "por", "dos", "mais", "como", "se", "na", "no", "são", "qual", "você"},
+	"nl": {"de", "het", "een", "en", "van", "is", "dat", "niet", "op", "te", "zijn", "voor", "met", "die", "er", "maar", "ook", "wat", "hoe", "wordt", "als", "bij", "dit", "aan"},
+	"sv": {"och", "att", "det", "är", "som", "en", "av", "på", "för", "inte", "med", "har", "den", "till", "jag", "om", "ett", "var", "hur", "vad", "kan", "de", "vi"},
+	"pl": {"i", "w", "nie", "się", "na", "to", "jest", "że", "z", "do", "jak", "co", "a", "o", "tak", "ale", "czy", "przez", "być", "jego", "od", "są", "już", "dla"},
+	"tr": {"ve", "bir", "bu", "da", "de", "için", "ile", "ne", "çok", "olan", "gibi", "daha", "olarak", "ama", "var", "mı", "mi", "nasıl", "değil", "kadar", "sonra", "en"},
+	"fi": {"ja", "on", "ei", "se", "että", "oli", "ovat", "mitä", "kuin", "hän", "mutta", "tai", "myös", "tämä", "jos", "niin", "kun", "miten", "mikä", "sen"},
+}
+
+// stopwordLanguages maps every stopword to the languages it is one of.
+var stopwordLanguages = func() map[string][]string {
+	m := make(map[string][]string)
+	for lang, words := range stopwords {
+		for _, w := range words {
+			m[w] = append(m[w], lang)
+		}
+	}
+	return m
+}()
+
+// scriptLanguages are the languages told by their script alone, checked in
+// order.
+var scriptLanguages = []struct {
+	script *unicode.RangeTable
+	lang   string
+}{
+	{unicode.Hangul, "ko"},
+	{unicode.Han, "zh"},
+	{unicode.Greek, "el"},
+	{unicode.Cyrillic, "ru"},
+	{unicode.Arabic, "ar"},
+	{unicode.Hebrew, "he"},
+	{unicode.Devanagari, "hi"},
+	{unicode.Thai, "th"},
+}
+
+// DetectLanguage returns the ISO 639-1 code of the language text is
+// written in, or "" if it cannot be told, as for a few keywords. Text in
+// the Latin script is told by its most frequent words, which distinguishes
+// English, German, French, Spanish, Italian, Portuguese, Dutch, Swedish,
+// Polish, Turkish and Finnish. Other text is told by its script: Chinese,
+// or Japanese if it has kana, Korean, Greek, Russian, or Ukrainian if it
+// has letters only Ukrainian uses, Arabic, or Persian likewise, Hebrew,
+// Hindi and Thai.
+func DetectLanguage(text string) string {
+	var letters, latin, kana int
+	scripts := make([]int, len(scriptLanguages))
+	var ukrainian, persian bool
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.Is(unicode.Latin, r):
+			latin++
+			continue
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			kana++
+			continue
+		case strings.ContainsRune("іїєґІЇЄҐ", r):
+			ukrainian = true
+		case strings.ContainsRune("پچژگ", r):
+			persian = true
+		}
+		for i, s := range scriptLanguages {
+			if unicode.Is(s.script, r) {
+				scripts[i]++
+				break
+			}
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+	// Japanese mixes kanji with kana
+	if kana > 0 && kana+scripts[1] > letters/2 {
+		return "ja"
+	}
+	for i, s := range scriptLanguages {
+		if scripts[i] <= letters/2 {
+			continue
+		}
+		switch {
+		case s.lang == "ru" && ukrainian:
+			return "uk"
+		case s.lang == "ar" && persian:
+			return "fa"
+		}
+		return s.lang
+	}
+	if latin <= letters/2 {
+		return ""
+	}
+	counts := make(map[string]int)
+	for word := range strings.FieldsFuncSeq(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		for _, lang := range stopwordLanguages[word] {
+			counts[lang]++
+		}
+	}
+	best, first, second := "", 0, 0
+	for lang, n := range counts {
+		switch {
+		case n > first:
+			best, first, second = lang, n, first
+		case n > second:
+			second = n
+		}
+	}
+	// A handful of words in common do not tell languages apart
+	if first < 2 || first == second {
+		return ""
+	}
+	return best
+}
+
+// tagLanguage records the language of doc in its LanguageKey metadata,
+// unless it has one already or the language cannot be told.
+func tagLanguage(doc *Document) {
+	if doc.Metadata[LanguageKey] != "" {
+		return
+	}
+	var sample strings.Builder
+	for _, s := range doc.Sections {
+		if sample.Len() >= languageSample {
+			break
+		}
+		sample.WriteString(s.Text[:min(len(s.Text), languageSample-sample.Len())])
+		sample.WriteString("\n\n")
+	}
+	lang := DetectLanguage(sample.String())
+	if lang == "" {
+		return
+	}
+	if doc.Metadata == nil {
+		doc.Metadata = Metadata{}
+	}
+	doc.Metadata[LanguageKey] = lang
+}
+
+// languageFilter returns filter restricted to the chunks in the language
+// of question if the pipeline filters by language, or nil if it does not,
+// the language cannot be told or filter already selects a language.
+func (p *Pipeline) languageFilter(ctx context.Context, question string, filter Filter) Filter {
+	if p.Languages != LanguageFilter || slices.ContainsFunc(filter, func(c Condition) bool { return c.Key == LanguageKey }) {
+		return nil
+	}
+	lang := DetectLanguage(question)
+	if lang == "" {
+		return nil
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("rag.language", lang))
+	return append(slices.Clone(filter), Condition{Key: LanguageKey, Op: OpEq, Value: lang})
+}
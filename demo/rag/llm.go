@@ -0,0 +1,191 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Role identifies the author of a chat message.
+type Role string
+
+const (
+	RoleSystem    Role = "system"
+	RoleUser      Role = "user"
+	RoleAssistant Role = "assistant"
+	RoleTool      Role = "tool"
+)
+
+// Message is a single turn of a chat conversation. An assistant message
+// may call tools instead of, or besides, replying with Content; each
+// result is sent back in a RoleTool message carrying the ToolCallID of the
+// call it answers.
+type Message struct {
+	Role       Role       `json:"role"`
+	Content    string     `json:"content"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+}
+
+// ToolCall is a call of a tool by the model. Arguments is the JSON object
+// the model passed, as it sent it; models do not always send valid JSON.
+type ToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// Tool describes a function the model may call. Parameters is the JSON
+// Schema of the object passed as its arguments.
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  json.RawMessage
+}
+
+// An LLM generates chat completions. Stream calls onDelta with each piece
+// of the answer as it arrives; returning an error from onDelta aborts the
+// stream.
+type LLM interface {
+	Generate(ctx context.Context, messages []Message) (string, error)
+	Stream(ctx context.Context, messages []Message, onDelta func(string) error) error
+}
+
+// A StructuredLLM can constrain its reply to JSON conforming to a JSON
+// Schema. GenerateJSON returns the JSON text of the reply; name identifies
+// the schema to the provider.
+type StructuredLLM interface {
+	LLM
+	GenerateJSON(ctx context.Context, messages []Message, name string, schema json.RawMessage) (string, error)
+}
+
+// A ToolLLM can call tools. GenerateWithTools returns the assistant's
+// reply, which either answers in Content or lists the ToolCalls the model
+// wants to make; the caller runs them and generates again with the results
+// appended to messages.
+type ToolLLM interface {
+	LLM
+	GenerateWithTools(ctx context.Context, messages []Message, tools []Tool) (Message, error)
+}
+
+// GenerationOptions tune how an LLM samples a reply. Unset fields leave
+// the provider's defaults. MaxTokens caps the tokens of the reply, and
+// generation ends before any of the Stop sequences, which are left out of
+// it. TopP samples from the smallest set of tokens whose probabilities add
+// up to it, and the penalties discourage tokens by whether, and how often,
+// they appeared so far. Bedrock ignores the penalties, which its Converse
+// API does not take. Model, which a Router sets, replaces the model of the
+// LLMs of this package.
+type GenerationOptions struct {
+	Temperature      *float64 `json:"temperature,omitempty"` // from 0 to 2
+	TopP             *float64 `json:"top_p,omitempty"`       // from 0 to 1
+	MaxTokens        int      `json:"max_tokens,omitempty"`
+	Stop             []string `json:"stop,omitempty"`              // at most MaxStopSequences
+	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`  // from -2 to 2
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"` // from -2 to 2
+	Model            string   `json:"-"`
+}
+
+// MaxStopSequences is the number of stop sequences all providers accept.
+const MaxStopSequences = 4
+
+func (o GenerationOptions) validate() error {
+	if t := o.Temperature; t != nil && (*t < 0 || *t > 2) {
+		return fmt.Errorf("temperature must be between 0 and 2, got %g", *t)
+	}
+	if p := o.TopP; p != nil && (*p < 0 || *p > 1) {
+		return fmt.Errorf("top_p must be between 0 and 1, got %g", *p)
+	}
+	if o.MaxTokens < 0 {
+		return fmt.Errorf("max_tokens must not be negative, got %d", o.MaxTokens)
+	}
+	if len(o.Stop) > MaxStopSequences {
+		return fmt.Errorf("at most %d stop sequences are allowed, got %d", MaxStopSequences, len(o.Stop))
+	}
+	if slices.Contains(o.Stop, "") {
+		return errors.New("stop sequences must not be empty")
+	}
+	if p := o.PresencePenalty; p != nil && (*p < -2 || *p > 2) {
+		return fmt.Errorf("presence_penalty must be between -2 and 2, got %g", *p)
+	}
+	if p := o.FrequencyPenalty; p != nil && (*p < -2 || *p > 2) {
+		return fmt.Errorf("frequency_penalty must be between -2 and 2, got %g", *p)
+	}
+	return nil
+}
+
+type generationOptionsKey struct{}
+
+// WithGenerationOptions returns a context that makes LLMs generate with
+// opts.
+func WithGenerationOptions(ctx context.Context, opts GenerationOptions) context.Context {
+	return context.WithValue(ctx, generationOptionsKey{}, opts)
+}
+
+// GenerationOptionsFrom returns the options set on ctx by
+// WithGenerationOptions.
+func GenerationOptionsFrom(ctx context.Context) GenerationOptions {
+	opts, _ := ctx.Value(generationOptionsKey{}).(GenerationOptions)
+	return opts
+}
+
+// generationModel returns the model set on ctx by WithGenerationOptions,
+// or model if none is.
+func generationModel(ctx context.Context, model string) string {
+	return cmp.Or(GenerationOptionsFrom(ctx).Model, model)
+}
+
+// NewLLM returns the LLM selected by cfg.LLM, falling back to those of
+// cfg.LLMFallbacks if any are set.
+func NewLLM(cfg Config) (LLM, error) {
+	l, err := newLLM(cfg)
+	if err != nil || cfg.LLMFallbacks == "" {
+		return l, err
+	}
+	return newFallbackLLM(cfg, l)
+}
+
+func newLLM(cfg Config) (LLM, error) {
+	switch cfg.LLM {
+	case "", "openai":
+		return NewOpenAILLM(cfg.ChatModel, openAIClientOptions(cfg)...), nil
+	case "ollama":
+		l := NewOllamaLLM(cfg.OllamaHost, cfg.ChatModel)
+		l.Client = newProviderClient(cfg)
+		return l, nil
+	case "vertex":
+		l, err := NewVertexLLM(cfg.VertexProject, cfg.VertexLocation, cfg.ChatModel)
+		if err != nil {
+			return nil, err
+		}
+		l.Client = newProviderClient(cfg)
+		return l, nil
+	case "bedrock":
+		l := NewBedrockLLM(cfg.S3Region, cfg.ChatModel, awsKeys(cfg))
+		l.Client = newProviderClient(cfg)
+		return l, nil
+	case "openai-compatible":
+		return NewOpenAICompatibleLLM(cfg.LLMBaseURL, cfg.LLMAPIKey, cfg.ChatModel, cfg.LLMStructured, openAIClientOptions(cfg)...)
+	}
+	return nil, fmt.Errorf("unknown llm %q", cfg.LLM)
+}
+
+// chatModel identifies the model used by the llm selected by cfg, e.g.
+// "openai/gpt-4o".
+func chatModel(cfg Config) string {
+	model := cfg.ChatModel
+	switch cfg.LLM {
+	case "", "openai":
+		return "openai/" + cmp.Or(model, DefaultOpenAIChatModel)
+	case "ollama":
+		return "ollama/" + cmp.Or(model, DefaultOllamaChatModel)
+	case "vertex":
+		return "vertex/" + cmp.Or(model, DefaultVertexChatModel)
+	case "bedrock":
+		return "bedrock/" + cmp.Or(model, DefaultBedrockChatModel)
+	}
+	return cfg.LLM + "/" + model
+}
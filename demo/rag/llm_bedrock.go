@@ -0,0 +1,372 @@
+package rag
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultBedrockChatModel is used when no chat model is configured.
+const DefaultBedrockChatModel = "amazon.nova-lite-v1:0"
+
+// DefaultBedrockRegion is the region Bedrock is called in when none is
+// configured.
+const DefaultBedrockRegion = "us-east-1"
+
+// bedrockAPI sends requests to the Bedrock runtime of an AWS region, signed
+// with the configured keys or those of the environment, see
+// awsCredentialChain.
+type bedrockAPI struct {
+	Client *http.Client // http.DefaultClient if nil
+
+	region string
+	creds  *awsCredentialChain
+}
+
+func newBedrockAPI(region string, keys AWSCredentials) bedrockAPI {
+	return bedrockAPI{region: cmp.Or(region, DefaultBedrockRegion), creds: newAWSCredentialChain(keys)}
+}
+
+// post sends body to an operation on model, such as "converse", returning
+// the response if its status is OK.
+func (b *bedrockAPI) post(ctx context.Context, model, operation string, body any) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := b.creds.Credentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := "https://bedrock-runtime." + b.region + ".amazonaws.com/model/" + url.PathEscape(model) + "/" + operation
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	hash := sha256.Sum256(data)
+	signV4(req, creds, b.region, "bedrock", hex.EncodeToString(hash[:]), time.Now())
+	resp, err := cmp.Or(b.Client, http.DefaultClient).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var res struct {
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(data, &res) != nil || res.Message == "" {
+			res.Message = strings.TrimSpace(string(data))
+		}
+		return nil, fmt.Errorf("bedrock: %s: %s", resp.Status, res.Message)
+	}
+	return resp, nil
+}
+
+// BedrockLLM generates answers with a model on Amazon Bedrock, through the
+// Converse API, which takes the same messages for every model it serves.
+type BedrockLLM struct {
+	bedrockAPI
+
+	model string
+}
+
+// NewBedrockLLM creates a BedrockLLM calling Bedrock in region,
+// DefaultBedrockRegion if empty, signed with keys or, if they are unset,
+// the credentials of the environment.
+func NewBedrockLLM(region, model string, keys AWSCredentials) *BedrockLLM {
+	return &BedrockLLM{bedrockAPI: newBedrockAPI(region, keys), model: cmp.Or(model, DefaultBedrockChatModel)}
+}
+
+// bedrockMessage is a message as Converse takes and returns it, in the
+// role "user" or "assistant".
+type bedrockMessage struct {
+	Role    string         `json:"role"`
+	Content []bedrockBlock `json:"content"`
+}
+
+type bedrockBlock struct {
+	Text       string             `json:"text,omitempty"`
+	ToolUse    *bedrockToolUse    `json:"toolUse,omitempty"`
+	ToolResult *bedrockToolResult `json:"toolResult,omitempty"`
+}
+
+type bedrockToolUse struct {
+	ToolUseID string          `json:"toolUseId"`
+	Name      string          `json:"name"`
+	Input     json.RawMessage `json:"input"`
+}
+
+type bedrockToolResult struct {
+	ToolUseID string         `json:"toolUseId"`
+	Content   []bedrockBlock `json:"content"`
+}
+
+// bedrockUsage is the token usage Converse reports.
+type bedrockUsage struct {
+	InputTokens  int `json:"inputTokens"`
+	OutputTokens int `json:"outputTokens"`
+}
+
+// bedrockMessages converts messages for a request: system messages become
+// its system prompt and the results of tools blocks of a user message.
+// Consecutive messages of a role are merged, as Converse expects the
+// results of parallel calls in one message.
+func bedrockMessages(messages []Message) ([]bedrockBlock, []bedrockMessage) {
+	var system []bedrockBlock
+	var converted []bedrockMessage
+	for _, m := range messages {
+		role := "user"
+		var blocks []bedrockBlock
+		switch m.Role {
+		case RoleSystem:
+			system = append(system, bedrockBlock{Text: m.Content})
+			continue
+		case RoleAssistant:
+			role = "assistant"
+			if m.Content != "" {
+				blocks = append(blocks, bedrockBlock{Text: m.Content})
+			}
+			for _, c := range m.ToolCalls {
+				input := json.RawMessage(c.Arguments)
+				if !json.Valid(input) {
+					input = json.RawMessage("{}")
+				}
+				blocks = append(blocks, bedrockBlock{ToolUse: &bedrockToolUse{ToolUseID: c.ID, Name: c.Name, Input: input}})
+			}
+		case RoleTool:
+			blocks = []bedrockBlock{{ToolResult: &bedrockToolResult{ToolUseID: m.ToolCallID, Content: []bedrockBlock{{Text: m.Content}}}}}
+		default:
+			blocks = []bedrockBlock{{Text: m.Content}}
+		}
+		if n := len(converted); n > 0 && converted[n-1].Role == role {
+			converted[n-1].Content = append(converted[n-1].Content, blocks...)
+			continue
+		}
+		converted = append(converted, bedrockMessage{Role: role, Content: blocks})
+	}
+	return system, converted
+}
+
+// request returns the body of a Converse request for messages.
+func (l *BedrockLLM) request(ctx context.Context, messages []Message) map[string]any {
+	system, converted := bedrockMessages(messages)
+	body := map[string]any{"messages": converted}
+	if len(system) > 0 {
+		body["system"] = system
+	}
+	opts := GenerationOptionsFrom(ctx)
+	config := make(map[string]any)
+	if t := opts.Temperature; t != nil {
+		config["temperature"] = *t
+	}
+	if p := opts.TopP; p != nil {
+		config["topP"] = *p
+	}
+	if opts.MaxTokens > 0 {
+		config["maxTokens"] = opts.MaxTokens
+	}
+	if len(opts.Stop) > 0 {
+		config["stopSequences"] = opts.Stop
+	}
+	if len(config) > 0 {
+		body["inferenceConfig"] = config
+	}
+	return body
+}
+
+func (l *BedrockLLM) Generate(ctx context.Context, messages []Message) (string, error) {
+	reply, err := l.converse(ctx, l.request(ctx, messages))
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, block := range reply.Content {
+		b.WriteString(block.Text)
+	}
+	return b.String(), nil
+}
+
+// GenerateWithTools passes tools as the tool configuration of the request.
+// Calls keep the IDs Bedrock gives them.
+func (l *BedrockLLM) GenerateWithTools(ctx context.Context, messages []Message, tools []Tool) (Message, error) {
+	specs := make([]map[string]any, len(tools))
+	for i, t := range tools {
+		specs[i] = map[string]any{"toolSpec": map[string]any{
+			"name":        t.Name,
+			"description": t.Description,
+			"inputSchema": map[string]any{"json": t.Parameters},
+		}}
+	}
+	body := l.request(ctx, messages)
+	body["toolConfig"] = map[string]any{"tools": specs}
+	res, err := l.converse(ctx, body)
+	if err != nil {
+		return Message{}, err
+	}
+	reply := Message{Role: RoleAssistant}
+	for _, block := range res.Content {
+		reply.Content += block.Text
+		if block.ToolUse != nil {
+			reply.ToolCalls = append(reply.ToolCalls, ToolCall{
+				ID:        block.ToolUse.ToolUseID,
+				Name:      block.ToolUse.Name,
+				Arguments: cmp.Or(string(block.ToolUse.Input), "{}"),
+			})
+		}
+	}
+	return reply, nil
+}
+
+// converse sends a Converse request and returns the reply.
+func (l *BedrockLLM) converse(ctx context.Context, body map[string]any) (bedrockMessage, error) {
+	model := generationModel(ctx, l.model)
+	resp, err := l.post(ctx, model, "converse", body)
+	if err != nil {
+		return bedrockMessage{}, err
+	}
+	defer resp.Body.Close()
+	var res struct {
+		Output struct {
+			Message bedrockMessage `json:"message"`
+		} `json:"output"`
+		Usage bedrockUsage `json:"usage"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return bedrockMessage{}, fmt.Errorf("bedrock: decoding response: %w", err)
+	}
+	reportUsage(ctx, Usage{Model: model, PromptTokens: res.Usage.InputTokens, CompletionTokens: res.Usage.OutputTokens})
+	return res.Output.Message, nil
+}
+
+// Stream reads the events of ConverseStream, each a message of the AWS
+// event stream encoding: contentBlockDelta events carry the next piece of
+// the answer and the metadata event the usage.
+func (l *BedrockLLM) Stream(ctx context.Context, messages []Message, onDelta func(string) error) error {
+	model := generationModel(ctx, l.model)
+	resp, err := l.post(ctx, model, "converse-stream", l.request(ctx, messages))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	r := bufio.NewReader(resp.Body)
+	for {
+		headers, payload, err := readEventStreamMessage(r)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("bedrock: reading stream: %w", err)
+		}
+		if headers[":message-type"] != "event" {
+			var res struct {
+				Message string `json:"message"`
+			}
+			json.Unmarshal(payload, &res)
+			return fmt.Errorf("bedrock: %s: %s", cmp.Or(headers[":exception-type"], headers[":error-code"]), cmp.Or(res.Message, headers[":error-message"]))
+		}
+		switch headers[":event-type"] {
+		case "contentBlockDelta":
+			var event struct {
+				Delta struct {
+					Text string `json:"text"`
+				} `json:"delta"`
+			}
+			if err := json.Unmarshal(payload, &event); err != nil {
+				return fmt.Errorf("bedrock: decoding event: %w", err)
+			}
+			if event.Delta.Text != "" {
+				if err := onDelta(event.Delta.Text); err != nil {
+					return err
+				}
+			}
+		case "metadata":
+			var event struct {
+				Usage bedrockUsage `json:"usage"`
+			}
+			if err := json.Unmarshal(payload, &event); err != nil {
+				return fmt.Errorf("bedrock: decoding event: %w", err)
+			}
+			reportUsage(ctx, Usage{Model: model, PromptTokens: event.Usage.InputTokens, CompletionTokens: event.Usage.OutputTokens})
+		}
+	}
+}
+
+// readEventStreamMessage reads a message of the AWS event stream encoding:
+// its total and headers length, a CRC of them, the headers, the payload
+// and a CRC of the whole message. Only the headers with string values are
+// returned, which are all the ones Bedrock sends.
+func readEventStreamMessage(r io.Reader) (map[string]string, []byte, error) {
+	var prelude [12]byte
+	if _, err := io.ReadFull(r, prelude[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, nil, errors.New("truncated message")
+		}
+		return nil, nil, err
+	}
+	total, headersLen := binary.BigEndian.Uint32(prelude[0:4]), binary.BigEndian.Uint32(prelude[4:8])
+	if crc32.ChecksumIEEE(prelude[:8]) != binary.BigEndian.Uint32(prelude[8:12]) {
+		return nil, nil, errors.New("prelude checksum mismatch")
+	}
+	if total < 16 || total > 16<<20 || headersLen > total-16 {
+		return nil, nil, fmt.Errorf("invalid message length %d", total)
+	}
+	rest := make([]byte, total-12)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, nil, errors.New("truncated message")
+	}
+	body := rest[:len(rest)-4]
+	crc := crc32.Update(crc32.ChecksumIEEE(prelude[:]), crc32.IEEETable, body)
+	if crc != binary.BigEndian.Uint32(rest[len(rest)-4:]) {
+		return nil, nil, errors.New("message checksum mismatch")
+	}
+
+	headers := make(map[string]string)
+	b := body[:headersLen]
+	for len(b) > 0 {
+		n := int(b[0])
+		if len(b) < 2+n {
+			return nil, nil, errors.New("invalid header")
+		}
+		name, kind := string(b[1:1+n]), b[1+n]
+		b = b[2+n:]
+		// The sizes of the values of the fixed-size types, by type
+		size := [...]int{0, 0, 1, 2, 4, 8, -1, -1, 8, 16}
+		if int(kind) >= len(size) {
+			return nil, nil, fmt.Errorf("invalid header type %d", kind)
+		}
+		if size[kind] < 0 { // byte array or string, prefixed with its length
+			if len(b) < 2 {
+				return nil, nil, errors.New("invalid header")
+			}
+			n := int(binary.BigEndian.Uint16(b))
+			if len(b) < 2+n {
+				return nil, nil, errors.New("invalid header")
+			}
+			if kind == 7 {
+				headers[name] = string(b[2 : 2+n])
+			}
+			b = b[2+n:]
+			continue
+		}
+		if len(b) < size[kind] {
+			return nil, nil, errors.New("invalid header")
+		}
+		b = b[size[kind]:]
+	}
+	return headers, body[headersLen:], nil
+}
@@ -0,0 +1,53 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/openai/openai-go/option"
+)
+
+// NewOpenAICompatibleLLM creates an LLM for a server implementing the
+// OpenAI chat completions API under baseURL, such as vLLM, LM Studio,
+// llama.cpp's llama-server or Groq, e.g. http://localhost:8000/v1. apiKey
+// is sent as a bearer token if it is set; the OpenAI credentials of the
+// environment never are. Few such servers implement structured outputs in
+// strict mode, so the LLM is only a StructuredLLM if structured is set;
+// otherwise JSON replies are asked for in the prompt alone.
+func NewOpenAICompatibleLLM(baseURL, apiKey, model string, structured bool, opts ...option.RequestOption) (LLM, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid LLM_BASE_URL %q: want a URL like http://localhost:8000/v1", baseURL)
+	}
+	if model == "" {
+		return nil, fmt.Errorf("the openai-compatible llm needs CHAT_MODEL to name the served model")
+	}
+	// Paths of requests are resolved against the base URL like a directory
+	endpoint := []option.RequestOption{
+		option.WithBaseURL(strings.TrimRight(baseURL, "/") + "/"),
+		option.WithHeaderDel("OpenAI-Organization"),
+		option.WithHeaderDel("OpenAI-Project"),
+	}
+	if apiKey != "" {
+		endpoint = append(endpoint, option.WithAPIKey(apiKey))
+	} else {
+		endpoint = append(endpoint, option.WithHeaderDel("Authorization"))
+	}
+	l := NewOpenAILLM(model, append(endpoint, opts...)...)
+	if structured {
+		return l, nil
+	}
+	return unstructuredLLM{l}, nil
+}
+
+// unstructuredLLM hides the GenerateJSON method of an OpenAILLM but keeps
+// it a ToolLLM.
+type unstructuredLLM struct {
+	LLM
+}
+
+func (l unstructuredLLM) GenerateWithTools(ctx context.Context, messages []Message, tools []Tool) (Message, error) {
+	return l.LLM.(ToolLLM).GenerateWithTools(ctx, messages, tools)
+}
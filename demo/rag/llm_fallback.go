@@ -0,0 +1,221 @@
+package rag
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// A FallbackProvider is an LLM of a FallbackLLM and the name it is known
+// by, such as "ollama/llama3.2".
+type FallbackProvider struct {
+	Name string
+	LLM  LLM
+}
+
+// FallbackLLM generates with the first of its Providers, and if that fails
+// or, with Timeout set, does not reply in time, with the next one, and so
+// on; the last one is given the time that is left. The errors of the
+// caller come back at once: a canceled or expired context, an error of
+// onDelta, and a stream failing after part of it was passed to onDelta,
+// which cannot be taken back. The model set by WithGenerationOptions, such
+// as a Router's, only replaces that of the first provider. GenerateJSON
+// has providers that are not StructuredLLMs generate plain replies, and
+// GenerateWithTools skips those that are not ToolLLMs.
+//
+// The provider that generated an answer is named in Answer.Provider.
+type FallbackLLM struct {
+	Providers []FallbackProvider
+	Timeout   time.Duration
+}
+
+// errSkipProvider is returned by the calls FallbackLLM.each makes for
+// providers that cannot make them.
+var errSkipProvider = errors.New("skip provider")
+
+// each makes call with every provider in turn until one succeeds. call
+// reports whether its error may be recovered from by the next provider.
+func (l *FallbackLLM) each(ctx context.Context, call func(ctx context.Context, llm LLM) (fallback bool, err error)) error {
+	var errs []error
+	for i, p := range l.Providers {
+		pctx := ctx
+		if i > 0 {
+			opts := GenerationOptionsFrom(ctx)
+			opts.Model = ""
+			pctx = WithGenerationOptions(ctx, opts)
+		}
+		cancel := func() {}
+		if l.Timeout > 0 && i < len(l.Providers)-1 {
+			pctx, cancel = context.WithTimeoutCause(pctx, l.Timeout, fmt.Errorf("no reply after %v: %w", l.Timeout, context.DeadlineExceeded))
+		}
+		fallback, err := call(pctx, p.LLM)
+		if err != nil && pctx.Err() != nil && ctx.Err() == nil {
+			err = context.Cause(pctx)
+		}
+		cancel()
+		switch {
+		case err == nil:
+			if scope, ok := ctx.Value(generatingKey{}).(*providerScope); ok {
+				scope.set(p.Name)
+			}
+			return nil
+		case errors.Is(err, errSkipProvider):
+			continue
+		case !fallback || ctx.Err() != nil:
+			return err
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", p.Name, err))
+		trace.SpanFromContext(ctx).AddEvent("rag.llm.fallback", trace.WithAttributes(
+			attribute.String("rag.llm.provider", p.Name), attribute.String("error", err.Error())))
+	}
+	if len(errs) == 0 {
+		return fmt.Errorf("calling tools is %w by the llms", ErrNotSupported)
+	}
+	return errors.Join(errs...)
+}
+
+func (l *FallbackLLM) Generate(ctx context.Context, messages []Message) (string, error) {
+	var reply string
+	err := l.each(ctx, func(ctx context.Context, llm LLM) (bool, error) {
+		var err error
+		reply, err = llm.Generate(ctx, messages)
+		return true, err
+	})
+	return reply, err
+}
+
+func (l *FallbackLLM) Stream(ctx context.Context, messages []Message, onDelta func(string) error) error {
+	return l.each(ctx, func(ctx context.Context, llm LLM) (bool, error) {
+		var streamed bool
+		var deltaErr error
+		err := llm.Stream(ctx, messages, func(delta string) error {
+			streamed = true
+			deltaErr = onDelta(delta)
+			return deltaErr
+		})
+		return !streamed && deltaErr == nil, err
+	})
+}
+
+func (l *FallbackLLM) GenerateJSON(ctx context.Context, messages []Message, name string, schema json.RawMessage) (string, error) {
+	var reply string
+	err := l.each(ctx, func(ctx context.Context, llm LLM) (bool, error) {
+		var err error
+		if s, ok := llm.(StructuredLLM); ok {
+			reply, err = s.GenerateJSON(ctx, messages, name, schema)
+		} else {
+			reply, err = llm.Generate(ctx, messages)
+		}
+		return true, err
+	})
+	return reply, err
+}
+
+func (l *FallbackLLM) GenerateWithTools(ctx context.Context, messages []Message, tools []Tool) (Message, error) {
+	var reply Message
+	err := l.each(ctx, func(ctx context.Context, llm LLM) (bool, error) {
+		t, ok := llm.(ToolLLM)
+		if !ok {
+			return true, errSkipProvider
+		}
+		var err error
+		reply, err = t.GenerateWithTools(ctx, messages, tools)
+		return true, err
+	})
+	return reply, err
+}
+
+// callsTools reports whether one of the providers can call tools.
+func (l *FallbackLLM) callsTools() bool {
+	for _, p := range l.Providers {
+		if _, ok := p.LLM.(ToolLLM); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// newFallbackLLM returns llm, the LLM of cfg, followed by those of
+// LLM_FALLBACKS, or llm itself if there are none.
+func newFallbackLLM(cfg Config, llm LLM) (LLM, error) {
+	chain := &FallbackLLM{Providers: []FallbackProvider{{Name: chatModel(cfg), LLM: llm}}, Timeout: cfg.FallbackTimeout}
+	for _, spec := range strings.Split(cfg.LLMFallbacks, ",") {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		fallback := cfg
+		// Model names may have slashes of their own, as in meta-llama/Llama-3.1-8B
+		fallback.LLM, fallback.ChatModel, _ = strings.Cut(spec, "/")
+		l, err := newLLM(fallback)
+		if err != nil {
+			return nil, fmt.Errorf("LLM_FALLBACKS: %w", err)
+		}
+		chain.Providers = append(chain.Providers, FallbackProvider{Name: chatModel(fallback), LLM: l})
+	}
+	if len(chain.Providers) == 1 {
+		return llm, nil
+	}
+	return chain, nil
+}
+
+// A providerScope records the provider of a FallbackLLM that generated the
+// answer to a question.
+type providerScope struct {
+	mu   sync.Mutex
+	name string
+}
+
+type (
+	providerScopeKey struct{}
+	generatingKey    struct{}
+)
+
+// withProviderScope returns ctx with a scope recording the provider that
+// generates the answer to the question asked in it.
+func withProviderScope(ctx context.Context) (context.Context, *providerScope) {
+	scope := &providerScope{}
+	return context.WithValue(ctx, providerScopeKey{}, scope), scope
+}
+
+// generating returns ctx marked as generating the answer, so that the
+// provider serving it is recorded, and not those of the other calls to
+// the LLM, such as condensing the question.
+func generating(ctx context.Context) context.Context {
+	if scope, ok := ctx.Value(providerScopeKey{}).(*providerScope); ok {
+		return context.WithValue(ctx, generatingKey{}, scope)
+	}
+	return ctx
+}
+
+func (s *providerScope) set(name string) {
+	s.mu.Lock()
+	s.name = name
+	s.mu.Unlock()
+}
+
+func (s *providerScope) get() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.name
+}
+
+// CheckHealth checks every provider, and fails only if none replies, as a
+// FallbackLLM still answers while one of them does.
+func (l *FallbackLLM) CheckHealth(ctx context.Context) error {
+	var errs []error
+	for _, p := range l.Providers {
+		err := llmHealth(ctx, p.LLM)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", p.Name, err))
+	}
+	return errors.Join(errs...)
+}
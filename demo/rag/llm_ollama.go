@@ -0,0 +1,251 @@
+package rag
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultOllamaChatModel is used when no chat model is configured.
+const DefaultOllamaChatModel = "llama3.2"
+
+// OllamaLLM generates answers with a model served by a local Ollama
+// instance, through its /api/chat endpoint.
+type OllamaLLM struct {
+	Client *http.Client // http.DefaultClient if nil
+
+	host  string
+	model string
+}
+
+// NewOllamaLLM creates an OllamaLLM talking to the Ollama server at host,
+// e.g. http://localhost:11434.
+func NewOllamaLLM(host, model string) *OllamaLLM {
+	if model == "" {
+		model = DefaultOllamaChatModel
+	}
+	return &OllamaLLM{host: strings.TrimRight(host, "/"), model: model}
+}
+
+// ollamaChatResponse is the response to a chat request, or one line of it
+// when streaming.
+type ollamaChatResponse struct {
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	Error           string        `json:"error"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+}
+
+// ollamaMessage is a message as /api/chat sends and receives it. Tool
+// calls carry their arguments as a JSON object and have no ID; the results
+// of tools are matched to calls by the tool's name.
+type ollamaMessage struct {
+	Role      Role             `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+// ollamaMessages converts messages for a chat request.
+func ollamaMessages(messages []Message) []ollamaMessage {
+	names := make(map[string]string) // tool names by call ID
+	converted := make([]ollamaMessage, len(messages))
+	for i, m := range messages {
+		converted[i] = ollamaMessage{Role: m.Role, Content: m.Content, ToolName: names[m.ToolCallID]}
+		for _, c := range m.ToolCalls {
+			names[c.ID] = c.Name
+			var call ollamaToolCall
+			call.Function.Name = c.Name
+			call.Function.Arguments = json.RawMessage(c.Arguments)
+			if !json.Valid(call.Function.Arguments) {
+				call.Function.Arguments = json.RawMessage("{}")
+			}
+			converted[i].ToolCalls = append(converted[i].ToolCalls, call)
+		}
+	}
+	return converted
+}
+
+// usage returns the token counts of a final response.
+func (r *ollamaChatResponse) usage(model string) Usage {
+	return Usage{Model: model, PromptTokens: r.PromptEvalCount, CompletionTokens: r.EvalCount}
+}
+
+func (l *OllamaLLM) Generate(ctx context.Context, messages []Message) (string, error) {
+	res, err := l.generate(ctx, map[string]any{"messages": ollamaMessages(messages)})
+	return res.Content, err
+}
+
+// GenerateJSON passes schema as the request's format, which makes Ollama
+// constrain sampling to JSON matching it.
+func (l *OllamaLLM) GenerateJSON(ctx context.Context, messages []Message, name string, schema json.RawMessage) (string, error) {
+	res, err := l.generate(ctx, map[string]any{"messages": ollamaMessages(messages), "format": schema})
+	return res.Content, err
+}
+
+// GenerateWithTools passes tools as functions the model may call, which
+// only models trained for tool calling do. Calls are given IDs from their
+// position in the conversation.
+func (l *OllamaLLM) GenerateWithTools(ctx context.Context, messages []Message, tools []Tool) (Message, error) {
+	functions := make([]map[string]any, len(tools))
+	for i, t := range tools {
+		functions[i] = map[string]any{
+			"type":     "function",
+			"function": map[string]any{"name": t.Name, "description": t.Description, "parameters": t.Parameters},
+		}
+	}
+	res, err := l.generate(ctx, map[string]any{"messages": ollamaMessages(messages), "tools": functions})
+	if err != nil {
+		return Message{}, err
+	}
+	reply := Message{Role: RoleAssistant, Content: res.Content}
+	for i, c := range res.ToolCalls {
+		reply.ToolCalls = append(reply.ToolCalls, ToolCall{
+			ID:        fmt.Sprintf("call_%d_%d", len(messages), i),
+			Name:      c.Function.Name,
+			Arguments: string(c.Function.Arguments),
+		})
+	}
+	return reply, nil
+}
+
+// generate sends a chat request that does not stream, with params added to
+// it, and returns the reply.
+func (l *OllamaLLM) generate(ctx context.Context, params map[string]any) (ollamaMessage, error) {
+	params["stream"] = false
+	resp, err := l.chat(ctx, params)
+	if err != nil {
+		return ollamaMessage{}, err
+	}
+	defer resp.Body.Close()
+	var res ollamaChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return ollamaMessage{}, fmt.Errorf("ollama: decoding response: %w", err)
+	}
+	if res.Error != "" {
+		return ollamaMessage{}, fmt.Errorf("ollama: %s", res.Error)
+	}
+	reportUsage(ctx, res.usage(generationModel(ctx, l.model)))
+	return res.Message, nil
+}
+
+// Stream reads the newline-delimited JSON objects Ollama sends while
+// generating, each carrying the next piece of the answer.
+func (l *OllamaLLM) Stream(ctx context.Context, messages []Message, onDelta func(string) error) error {
+	resp, err := l.chat(ctx, map[string]any{"messages": ollamaMessages(messages), "stream": true})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var res ollamaChatResponse
+		if err := json.Unmarshal(scanner.Bytes(), &res); err != nil {
+			return fmt.Errorf("ollama: decoding response: %w", err)
+		}
+		if res.Error != "" {
+			return fmt.Errorf("ollama: %s", res.Error)
+		}
+		if res.Message.Content != "" {
+			if err := onDelta(res.Message.Content); err != nil {
+				return err
+			}
+		}
+		if res.Done {
+			reportUsage(ctx, res.usage(generationModel(ctx, l.model)))
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// CheckHealth lists the models of the Ollama server.
+func (l *OllamaLLM) CheckHealth(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, l.host+"/api/tags", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := cmp.Or(l.Client, http.DefaultClient).Do(req)
+	if err != nil {
+		return fmt.Errorf("ollama: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ollama: GET /api/tags: %s", resp.Status)
+	}
+	return nil
+}
+
+// chat sends a chat request with params, such as the messages and the
+// format of the reply, returning the response if its status is OK.
+func (l *OllamaLLM) chat(ctx context.Context, params map[string]any) (*http.Response, error) {
+	params["model"] = generationModel(ctx, l.model)
+	if options := ollamaOptions(GenerationOptionsFrom(ctx)); len(options) > 0 {
+		params["options"] = options
+	}
+	body, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.host+"/api/chat", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := cmp.Or(l.Client, http.DefaultClient).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var res ollamaChatResponse
+		json.NewDecoder(resp.Body).Decode(&res)
+		return nil, fmt.Errorf("ollama: %s: %s", resp.Status, res.Error)
+	}
+	return resp, nil
+}
+
+// ollamaOptions returns the model options generating with opts takes.
+func ollamaOptions(opts GenerationOptions) map[string]any {
+	options := make(map[string]any)
+	if t := opts.Temperature; t != nil {
+		options["temperature"] = *t
+	}
+	if p := opts.TopP; p != nil {
+		options["top_p"] = *p
+	}
+	if opts.MaxTokens > 0 {
+		options["num_predict"] = opts.MaxTokens
+	}
+	if len(opts.Stop) > 0 {
+		options["stop"] = opts.Stop
+	}
+	if p := opts.PresencePenalty; p != nil {
+		options["presence_penalty"] = *p
+	}
+	if p := opts.FrequencyPenalty; p != nil {
+		options["frequency_penalty"] = *p
+	}
+	return options
+}
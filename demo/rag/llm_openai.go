@@ -0,0 +1,224 @@
+package rag
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// DefaultOpenAIChatModel is used when no chat model is configured.
+const DefaultOpenAIChatModel = openai.ChatModelGPT4o
+
+// OpenAILLM generates answers with the OpenAI chat completions API.
+type OpenAILLM struct {
+	client *openai.Client
+	model  string
+}
+
+// NewOpenAILLM creates an OpenAILLM for the given model.
+func NewOpenAILLM(model string, opts ...option.RequestOption) *OpenAILLM {
+	if model == "" {
+		model = DefaultOpenAIChatModel
+	}
+	return &OpenAILLM{client: openai.NewClient(opts...), model: model}
+}
+
+func (l *OpenAILLM) Generate(ctx context.Context, messages []Message) (string, error) {
+	params, err := l.params(ctx, messages)
+	if err != nil {
+		return "", err
+	}
+	return l.complete(ctx, params)
+}
+
+// GenerateJSON uses structured outputs in strict mode, so the reply always
+// conforms to schema unless the model refuses to answer.
+func (l *OpenAILLM) GenerateJSON(ctx context.Context, messages []Message, name string, schema json.RawMessage) (string, error) {
+	params, err := l.params(ctx, messages)
+	if err != nil {
+		return "", err
+	}
+	params.ResponseFormat = openai.F[openai.ChatCompletionNewParamsResponseFormatUnion](openai.ResponseFormatJSONSchemaParam{
+		Type: openai.F(openai.ResponseFormatJSONSchemaTypeJSONSchema),
+		JSONSchema: openai.F(openai.ResponseFormatJSONSchemaJSONSchemaParam{
+			Name:   openai.F(name),
+			Schema: openai.F[any](schema),
+			Strict: openai.F(true),
+		}),
+	})
+	return l.complete(ctx, params)
+}
+
+// GenerateWithTools offers tools to the model as functions and lets it
+// decide whether to call them.
+func (l *OpenAILLM) GenerateWithTools(ctx context.Context, messages []Message, tools []Tool) (Message, error) {
+	params, err := l.params(ctx, messages)
+	if err != nil {
+		return Message{}, err
+	}
+	functions := make([]openai.ChatCompletionToolParam, len(tools))
+	for i, t := range tools {
+		var schema openai.FunctionParameters
+		if err := json.Unmarshal(t.Parameters, &schema); err != nil {
+			return Message{}, fmt.Errorf("openai: parameters of tool %s: %w", t.Name, err)
+		}
+		functions[i] = openai.ChatCompletionToolParam{
+			Type: openai.F(openai.ChatCompletionToolTypeFunction),
+			Function: openai.F(openai.FunctionDefinitionParam{
+				Name:        openai.F(t.Name),
+				Description: openai.F(t.Description),
+				Parameters:  openai.F(schema),
+			}),
+		}
+	}
+	params.Tools = openai.F(functions)
+	message, err := l.message(ctx, params)
+	if err != nil {
+		return Message{}, err
+	}
+	reply := Message{Role: RoleAssistant, Content: message.Content}
+	for _, c := range message.ToolCalls {
+		reply.ToolCalls = append(reply.ToolCalls, ToolCall{ID: c.ID, Name: c.Function.Name, Arguments: c.Function.Arguments})
+	}
+	return reply, nil
+}
+
+func (l *OpenAILLM) complete(ctx context.Context, params openai.ChatCompletionNewParams) (string, error) {
+	message, err := l.message(ctx, params)
+	if err != nil {
+		return "", err
+	}
+	return message.Content, nil
+}
+
+// message returns the message of the completion of params.
+func (l *OpenAILLM) message(ctx context.Context, params openai.ChatCompletionNewParams) (openai.ChatCompletionMessage, error) {
+	completion, err := l.client.Chat.Completions.New(ctx, params)
+	if err != nil {
+		return openai.ChatCompletionMessage{}, err
+	}
+	if len(completion.Choices) == 0 {
+		return openai.ChatCompletionMessage{}, errors.New("openai: completion has no choices")
+	}
+	reportUsage(ctx, Usage{
+		Model:            generationModel(ctx, l.model),
+		PromptTokens:     int(completion.Usage.PromptTokens),
+		CompletionTokens: int(completion.Usage.CompletionTokens),
+	})
+	message := completion.Choices[0].Message
+	if message.Refusal != "" {
+		return openai.ChatCompletionMessage{}, fmt.Errorf("openai: model refused: %s", message.Refusal)
+	}
+	return message, nil
+}
+
+func (l *OpenAILLM) Stream(ctx context.Context, messages []Message, onDelta func(string) error) error {
+	params, err := l.params(ctx, messages)
+	if err != nil {
+		return err
+	}
+	// The usage is sent in a last chunk without choices
+	params.StreamOptions = openai.F(openai.ChatCompletionStreamOptionsParam{IncludeUsage: openai.F(true)})
+	stream := l.client.Chat.Completions.NewStreaming(ctx, params)
+	// A stream whose request failed has nothing to close
+	if err := stream.Err(); err != nil {
+		return err
+	}
+	defer stream.Close()
+	for stream.Next() {
+		chunk := stream.Current()
+		if chunk.Usage.TotalTokens > 0 {
+			reportUsage(ctx, Usage{
+				Model:            generationModel(ctx, l.model),
+				PromptTokens:     int(chunk.Usage.PromptTokens),
+				CompletionTokens: int(chunk.Usage.CompletionTokens),
+			})
+		}
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			continue
+		}
+		if err := onDelta(chunk.Choices[0].Delta.Content); err != nil {
+			return err
+		}
+	}
+	return stream.Err()
+}
+
+func (l *OpenAILLM) params(ctx context.Context, messages []Message) (openai.ChatCompletionNewParams, error) {
+	params := make([]openai.ChatCompletionMessageParamUnion, len(messages))
+	for i, m := range messages {
+		switch m.Role {
+		case RoleSystem:
+			params[i] = openai.SystemMessage(m.Content)
+		case RoleUser:
+			params[i] = openai.UserMessage(m.Content)
+		case RoleAssistant:
+			params[i] = assistantMessage(m)
+		case RoleTool:
+			params[i] = openai.ToolMessage(m.ToolCallID, m.Content)
+		default:
+			return openai.ChatCompletionNewParams{}, fmt.Errorf("openai: unknown message role %q", m.Role)
+		}
+	}
+	completion := openai.ChatCompletionNewParams{
+		Messages: openai.F(params),
+		Model:    openai.F(generationModel(ctx, l.model)),
+	}
+	opts := GenerationOptionsFrom(ctx)
+	if t := opts.Temperature; t != nil {
+		completion.Temperature = openai.F(*t)
+	}
+	if p := opts.TopP; p != nil {
+		completion.TopP = openai.F(*p)
+	}
+	if opts.MaxTokens > 0 {
+		// max_tokens rather than max_completion_tokens, which fewer
+		// OpenAI-compatible servers know
+		completion.MaxTokens = openai.F(int64(opts.MaxTokens))
+	}
+	if len(opts.Stop) > 0 {
+		completion.Stop = openai.F[openai.ChatCompletionNewParamsStopUnion](openai.ChatCompletionNewParamsStopArray(opts.Stop))
+	}
+	if p := opts.PresencePenalty; p != nil {
+		completion.PresencePenalty = openai.F(*p)
+	}
+	if p := opts.FrequencyPenalty; p != nil {
+		completion.FrequencyPenalty = openai.F(*p)
+	}
+	return completion, nil
+}
+
+// assistantMessage converts an assistant message, which has no content if
+// it only calls tools.
+func assistantMessage(m Message) openai.ChatCompletionAssistantMessageParam {
+	if len(m.ToolCalls) == 0 {
+		return openai.AssistantMessage(m.Content)
+	}
+	param := openai.ChatCompletionAssistantMessageParam{Role: openai.F(openai.ChatCompletionAssistantMessageParamRoleAssistant)}
+	if m.Content != "" {
+		param.Content = openai.F([]openai.ChatCompletionAssistantMessageParamContentUnion{openai.TextPart(m.Content)})
+	}
+	calls := make([]openai.ChatCompletionMessageToolCallParam, len(m.ToolCalls))
+	for i, c := range m.ToolCalls {
+		calls[i] = openai.ChatCompletionMessageToolCallParam{
+			ID:   openai.F(c.ID),
+			Type: openai.F(openai.ChatCompletionMessageToolCallTypeFunction),
+			Function: openai.F(openai.ChatCompletionMessageToolCallFunctionParam{
+				Name:      openai.F(c.Name),
+				Arguments: openai.F(c.Arguments),
+			}),
+		}
+	}
+	param.ToolCalls = openai.F(calls)
+	return param
+}
+
+// CheckHealth lists the models of the API, which checks the API key too.
+func (l *OpenAILLM) CheckHealth(ctx context.Context) error {
+	_, err := l.client.Models.List(ctx)
+	return err
+}
@@ -0,0 +1,340 @@
+package rag
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultVertexChatModel is used when no chat model is configured.
+const DefaultVertexChatModel = "gemini-2.5-flash"
+
+// DefaultVertexLocation is the region Vertex AI is called in when none is
+// configured.
+const DefaultVertexLocation = "us-central1"
+
+// vertexAPI sends requests to the Vertex AI API of a Google Cloud project
+// in one location, authorized with the application default credentials,
+// see googleTokenSource.
+type vertexAPI struct {
+	Client *http.Client // http.DefaultClient if nil
+
+	baseURL string
+	tokens  *googleTokenSource
+}
+
+func newVertexAPI(project, location string) (vertexAPI, error) {
+	if project == "" {
+		return vertexAPI{}, errors.New("VERTEX_PROJECT must be set for Vertex AI")
+	}
+	location = cmp.Or(location, DefaultVertexLocation)
+	host := location + "-aiplatform.googleapis.com"
+	if location == "global" {
+		host = "aiplatform.googleapis.com"
+	}
+	return vertexAPI{
+		baseURL: "https://" + host + "/v1/projects/" + url.PathEscape(project) + "/locations/" + url.PathEscape(location),
+		tokens:  newGoogleTokenSource(),
+	}, nil
+}
+
+// post sends body to a method of a Google model, such as
+// "gemini-2.5-flash:generateContent", returning the response if its status
+// is OK.
+func (v *vertexAPI) post(ctx context.Context, method string, body any) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	token, err := v.tokens.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.baseURL+"/publishers/google/models/"+method, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := cmp.Or(v.Client, http.DefaultClient).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var res struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(data, &res) != nil || res.Error.Message == "" {
+			res.Error.Message = strings.TrimSpace(string(data))
+		}
+		return nil, fmt.Errorf("vertex: %s: %s", resp.Status, res.Error.Message)
+	}
+	return resp, nil
+}
+
+// VertexLLM generates answers with a Gemini model on Vertex AI, through
+// its generateContent method.
+type VertexLLM struct {
+	vertexAPI
+
+	model string
+}
+
+// NewVertexLLM creates a VertexLLM calling Vertex AI in the given Google
+// Cloud project and location, DefaultVertexLocation if empty.
+func NewVertexLLM(project, location, model string) (*VertexLLM, error) {
+	api, err := newVertexAPI(project, location)
+	if err != nil {
+		return nil, err
+	}
+	return &VertexLLM{vertexAPI: api, model: cmp.Or(model, DefaultVertexChatModel)}, nil
+}
+
+// geminiContent is a message as generateContent takes and returns it, in
+// the role "user" or "model".
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type geminiFunctionCall struct {
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+type geminiFunctionResponse struct {
+	Name     string         `json:"name"`
+	Response map[string]any `json:"response"`
+}
+
+// geminiResponse is the response of generateContent, or one event of it
+// when streaming.
+type geminiResponse struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	PromptFeedback struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+	UsageMetadata struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+// reply returns the first candidate, or an error if the prompt was
+// blocked.
+func (r *geminiResponse) reply() (geminiContent, error) {
+	if len(r.Candidates) == 0 {
+		if r.PromptFeedback.BlockReason != "" {
+			return geminiContent{}, fmt.Errorf("vertex: prompt blocked: %s", r.PromptFeedback.BlockReason)
+		}
+		return geminiContent{}, nil
+	}
+	return r.Candidates[0].Content, nil
+}
+
+// text joins the text parts of c.
+func (c geminiContent) text() string {
+	var b strings.Builder
+	for _, p := range c.Parts {
+		b.WriteString(p.Text)
+	}
+	return b.String()
+}
+
+// geminiContents converts messages for a request: system messages become
+// its system instruction and the results of tools function responses of
+// the user, named after the call they answer. Consecutive messages of a
+// role are merged, as Gemini expects the responses to parallel calls in
+// one message.
+func geminiContents(messages []Message) (*geminiContent, []geminiContent) {
+	var system *geminiContent
+	var contents []geminiContent
+	names := make(map[string]string) // tool names by call ID
+	for _, m := range messages {
+		role := "user"
+		var parts []geminiPart
+		switch m.Role {
+		case RoleSystem:
+			if system == nil {
+				system = &geminiContent{}
+			}
+			system.Parts = append(system.Parts, geminiPart{Text: m.Content})
+			continue
+		case RoleAssistant:
+			role = "model"
+			if m.Content != "" {
+				parts = append(parts, geminiPart{Text: m.Content})
+			}
+			for _, c := range m.ToolCalls {
+				names[c.ID] = c.Name
+				args := json.RawMessage(c.Arguments)
+				if !json.Valid(args) {
+					args = json.RawMessage("{}")
+				}
+				parts = append(parts, geminiPart{FunctionCall: &geminiFunctionCall{Name: c.Name, Args: args}})
+			}
+		case RoleTool:
+			parts = []geminiPart{{FunctionResponse: &geminiFunctionResponse{Name: names[m.ToolCallID], Response: map[string]any{"content": m.Content}}}}
+		default:
+			parts = []geminiPart{{Text: m.Content}}
+		}
+		if n := len(contents); n > 0 && contents[n-1].Role == role {
+			contents[n-1].Parts = append(contents[n-1].Parts, parts...)
+			continue
+		}
+		contents = append(contents, geminiContent{Role: role, Parts: parts})
+	}
+	return system, contents
+}
+
+// request returns the body of a request for messages, with config added to
+// its generation config.
+func (l *VertexLLM) request(ctx context.Context, messages []Message, config map[string]any) map[string]any {
+	system, contents := geminiContents(messages)
+	body := map[string]any{"contents": contents}
+	if system != nil {
+		body["systemInstruction"] = system
+	}
+	if config == nil {
+		config = make(map[string]any)
+	}
+	opts := GenerationOptionsFrom(ctx)
+	if t := opts.Temperature; t != nil {
+		config["temperature"] = *t
+	}
+	if p := opts.TopP; p != nil {
+		config["topP"] = *p
+	}
+	if opts.MaxTokens > 0 {
+		config["maxOutputTokens"] = opts.MaxTokens
+	}
+	if len(opts.Stop) > 0 {
+		config["stopSequences"] = opts.Stop
+	}
+	if p := opts.PresencePenalty; p != nil {
+		config["presencePenalty"] = *p
+	}
+	if p := opts.FrequencyPenalty; p != nil {
+		config["frequencyPenalty"] = *p
+	}
+	if len(config) > 0 {
+		body["generationConfig"] = config
+	}
+	return body
+}
+
+func (l *VertexLLM) Generate(ctx context.Context, messages []Message) (string, error) {
+	reply, err := l.generate(ctx, l.request(ctx, messages, nil))
+	return reply.text(), err
+}
+
+// GenerateJSON passes schema as the response schema, which makes Gemini
+// reply with JSON matching it.
+func (l *VertexLLM) GenerateJSON(ctx context.Context, messages []Message, name string, schema json.RawMessage) (string, error) {
+	body := l.request(ctx, messages, map[string]any{"responseMimeType": "application/json", "responseJsonSchema": schema})
+	reply, err := l.generate(ctx, body)
+	return reply.text(), err
+}
+
+// GenerateWithTools declares tools as functions the model may call. Calls
+// are given IDs from their position in the conversation.
+func (l *VertexLLM) GenerateWithTools(ctx context.Context, messages []Message, tools []Tool) (Message, error) {
+	declarations := make([]map[string]any, len(tools))
+	for i, t := range tools {
+		declarations[i] = map[string]any{"name": t.Name, "description": t.Description, "parametersJsonSchema": t.Parameters}
+	}
+	body := l.request(ctx, messages, nil)
+	body["tools"] = []map[string]any{{"functionDeclarations": declarations}}
+	res, err := l.generate(ctx, body)
+	if err != nil {
+		return Message{}, err
+	}
+	reply := Message{Role: RoleAssistant, Content: res.text()}
+	for _, p := range res.Parts {
+		if p.FunctionCall == nil {
+			continue
+		}
+		reply.ToolCalls = append(reply.ToolCalls, ToolCall{
+			ID:        fmt.Sprintf("call_%d_%d", len(messages), len(reply.ToolCalls)),
+			Name:      p.FunctionCall.Name,
+			Arguments: cmp.Or(string(p.FunctionCall.Args), "{}"),
+		})
+	}
+	return reply, nil
+}
+
+// generate sends a generateContent request and returns the reply.
+func (l *VertexLLM) generate(ctx context.Context, body map[string]any) (geminiContent, error) {
+	model := generationModel(ctx, l.model)
+	resp, err := l.post(ctx, url.PathEscape(model)+":generateContent", body)
+	if err != nil {
+		return geminiContent{}, err
+	}
+	defer resp.Body.Close()
+	var res geminiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return geminiContent{}, fmt.Errorf("vertex: decoding response: %w", err)
+	}
+	reportUsage(ctx, Usage{Model: model, PromptTokens: res.UsageMetadata.PromptTokenCount, CompletionTokens: res.UsageMetadata.CandidatesTokenCount})
+	return res.reply()
+}
+
+// Stream reads the server-sent events of streamGenerateContent, each
+// carrying the next piece of the answer; the last one carries the usage.
+func (l *VertexLLM) Stream(ctx context.Context, messages []Message, onDelta func(string) error) error {
+	model := generationModel(ctx, l.model)
+	resp, err := l.post(ctx, url.PathEscape(model)+":streamGenerateContent?alt=sse", l.request(ctx, messages, nil))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var last geminiResponse
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		var res geminiResponse
+		if err := json.Unmarshal([]byte(data), &res); err != nil {
+			return fmt.Errorf("vertex: decoding response: %w", err)
+		}
+		reply, err := res.reply()
+		if err != nil {
+			return err
+		}
+		if text := reply.text(); text != "" {
+			if err := onDelta(text); err != nil {
+				return err
+			}
+		}
+		last = res
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	reportUsage(ctx, Usage{Model: model, PromptTokens: last.UsageMetadata.PromptTokenCount, CompletionTokens: last.UsageMetadata.CandidatesTokenCount})
+	return nil
+}
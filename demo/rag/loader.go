@@ -0,0 +1,126 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// A Loader extracts a Document from the contents of a file. name is the file
+// name or path and becomes the document ID and "source" metadata.
+type Loader interface {
+	Load(ctx context.Context, name string, r io.Reader) (*Document, error)
+}
+
+// ErrNoLoader is returned for files that no Loader handles.
+var ErrNoLoader = errors.New("no loader")
+
+// loaders maps lower-case file extensions to the Loader handling them.
+var loaders = map[string]Loader{
+	".txt":      TextLoader{},
+	".log":      TextLoader{},
+	".md":       MarkdownLoader{},
+	".markdown": MarkdownLoader{},
+	".pdf":      PDFLoader{},
+	".html":     HTMLLoader{},
+	".htm":      HTMLLoader{},
+	".docx":     DOCXLoader{},
+	".pptx":     PPTXLoader{},
+	".csv":      RecordLoader{},
+	".jsonl":    RecordLoader{},
+	".ndjson":   RecordLoader{},
+	".eml":      EmailLoader{},
+	".mbox":     EmailLoader{},
+}
+
+// RegisterLoader makes l handle files with the given extension, e.g. ".csv".
+// It is not safe to call concurrently with loading and is meant to be used
+// from init functions.
+func RegisterLoader(ext string, l Loader) {
+	loaders[strings.ToLower(ext)] = l
+}
+
+// LoaderFor returns the Loader registered for name's extension.
+func LoaderFor(name string) (Loader, error) {
+	ext := strings.ToLower(filepath.Ext(name))
+	if l, ok := loaders[ext]; ok {
+		return l, nil
+	}
+	return nil, fmt.Errorf("%w for %q files", ErrNoLoader, ext)
+}
+
+// mediaTypes maps the media types of files found without a known extension
+// to the extension of their Loader.
+var mediaTypes = map[string]string{
+	"text/plain":           ".txt",
+	"text/markdown":        ".md",
+	"text/html":            ".html",
+	"application/pdf":      ".pdf",
+	"text/csv":             ".csv",
+	"application/jsonl":    ".jsonl",
+	"application/x-ndjson": ".jsonl",
+	"message/rfc822":       ".eml",
+	"application/mbox":     ".mbox",
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   ".docx",
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": ".pptx",
+}
+
+// LoaderForType returns the Loader for files of the given media type, such
+// as the Content-Type of a download, ignoring its parameters.
+func LoaderForType(contentType string) (Loader, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if ext, ok := mediaTypes[mediaType]; ok {
+		return loaders[ext], nil
+	}
+	return nil, fmt.Errorf("%w for %q files", ErrNoLoader, mediaType)
+}
+
+// LoadFile opens the file at path and loads it with the Loader registered
+// for its extension.
+func LoadFile(ctx context.Context, path string) (*Document, error) {
+	l, err := LoaderFor(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return loadDocument(ctx, l, filepath.ToSlash(path), f)
+}
+
+// loadDocument loads r with l in a span recording the number of sections.
+func loadDocument(ctx context.Context, l Loader, name string, r io.Reader) (_ *Document, err error) {
+	ctx, span := tracer.Start(ctx, "rag.load", trace.WithAttributes(attribute.String("rag.document", name)))
+	defer func() { endSpan(span, err) }()
+	doc, err := l.Load(ctx, name, r)
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("rag.sections", len(doc.Sections)))
+	return doc, nil
+}
+
+// TextLoader loads plain text files as a single section.
+type TextLoader struct{}
+
+func (TextLoader) Load(ctx context.Context, name string, r io.Reader) (*Document, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Document{
+		ID:       name,
+		Sections: []Section{{Text: string(data)}},
+		Metadata: Metadata{"source": name},
+	}, nil
+}
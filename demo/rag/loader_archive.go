@@ -0,0 +1,259 @@
+package rag
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Metadata keys of the documents loaded from archives: the path of the
+// archive file and the path of the document's file within it, which for a
+// file in an archive inside another holds both inner paths.
+const (
+	ArchiveKey     = "archive"
+	ArchivePathKey = "archive_path"
+)
+
+// maxArchiveDepth is how deep archives inside archives are opened.
+const maxArchiveDepth = 3
+
+// IsArchive reports whether name is a zip or tar archive by its extension:
+// .zip, .tar, .tar.gz or .tgz.
+func IsArchive(name string) bool {
+	name = strings.ToLower(name)
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// ArchiveLoader loads the files in zip and tar archives, gzipped or not,
+// with the Loaders registered for their extensions, opening the archives
+// found inside as well. A file's document has the ID of the archive path,
+// a slash and its path within the archive, such as
+// "docs.zip/guide/intro.md", and both paths in its ArchiveKey and
+// ArchivePathKey metadata. Files no Loader handles are skipped, as are
+// directories, links and the "__MACOSX" folders of archives made on macOS.
+type ArchiveLoader struct {
+	// PartSize is the size in bytes from which files are loaded in parts
+	// if their Loader is a PartLoader, as LoadFileParts does; 0 never
+	// loads files in parts.
+	PartSize int64
+	// MaxSize is the uncompressed size in bytes above which files are
+	// skipped; 0 sets no limit.
+	MaxSize int64
+
+	// OnSkip, if set, is called for the files skipped for being larger
+	// than MaxSize, having paths leaving the archive, such as "../x", or
+	// failing to load.
+	OnSkip func(name string, err error)
+}
+
+// LoadFile loads the archive at path, passing the document of every file
+// in it to yield in the order of the archive. A file that cannot be
+// loaded, such as a corrupt archive inside it or a file longer than its
+// header says, is skipped, and reported to OnSkip, while the others are
+// loaded; an error of yield stops loading and is returned.
+func (a *ArchiveLoader) LoadFile(ctx context.Context, path string, yield func(*Document) error) error {
+	err := a.loadFile(ctx, path, func(doc *Document) error {
+		if err := yield(doc); err != nil {
+			return yieldError{err}
+		}
+		return nil
+	})
+	var ye yieldError
+	if errors.As(err, &ye) {
+		return ye.err
+	}
+	return err
+}
+
+// yieldError marks the errors of the yield of LoadFile, which stop loading
+// the archive, where the errors of its files only skip them.
+type yieldError struct{ err error }
+
+func (e yieldError) Error() string { return e.err.Error() }
+
+func (a *ArchiveLoader) loadFile(ctx context.Context, path string, yield func(*Document) error) error {
+	name := filepath.ToSlash(path)
+	if strings.HasSuffix(strings.ToLower(name), ".zip") {
+		z, err := zip.OpenReader(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		defer z.Close()
+		return a.loadZip(ctx, &z.Reader, name, "", 0, yield)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return a.loadTar(ctx, f, name, name, "", 0, yield)
+}
+
+func (a *ArchiveLoader) loadZip(ctx context.Context, z *zip.Reader, archive, prefix string, depth int, yield func(*Document) error) error {
+	for _, f := range z.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		err := a.loadEntry(ctx, archive, prefix, f.Name, int64(f.UncompressedSize64), depth, func() (io.ReadCloser, error) { return f.Open() }, yield)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadTar loads the tar archive r, which is gzipped if name says so.
+func (a *ArchiveLoader) loadTar(ctx context.Context, r io.Reader, name, archive, prefix string, depth int, yield func(*Document) error) error {
+	if lower := strings.ToLower(name); strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	t := tar.NewReader(r)
+	for {
+		hdr, err := t.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		err = a.loadEntry(ctx, archive, prefix, hdr.Name, hdr.Size, depth, func() (io.ReadCloser, error) { return io.NopCloser(t), nil }, yield)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// loadEntry loads the file entry of size bytes within the archive file
+// archive, at prefix and entry within it, or opens it if it is an archive
+// itself. If it cannot be loaded, it is skipped.
+func (a *ArchiveLoader) loadEntry(ctx context.Context, archive, prefix, entry string, size int64, depth int, open func() (io.ReadCloser, error), yield func(*Document) error) error {
+	err := a.readEntry(ctx, archive, prefix, entry, size, depth, open, yield)
+	var ye yieldError
+	if err == nil || errors.As(err, &ye) || ctx.Err() != nil {
+		return err
+	}
+	inner := path.Clean(strings.TrimPrefix(entry, "./"))
+	a.skip(archive+"/"+prefix+inner, err)
+	return nil
+}
+
+// readEntry is loadEntry without skipping the entry if it fails.
+func (a *ArchiveLoader) readEntry(ctx context.Context, archive, prefix, entry string, size int64, depth int, open func() (io.ReadCloser, error), yield func(*Document) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	inner := path.Clean(strings.TrimPrefix(entry, "./"))
+	id := archive + "/" + prefix + inner
+	switch {
+	case inner == ".." || strings.HasPrefix(inner, "../") || path.IsAbs(inner):
+		a.skip(id, fmt.Errorf("path %q leaves the archive", entry))
+		return nil
+	case inner == "__MACOSX" || strings.HasPrefix(inner, "__MACOSX/"):
+		return nil
+	case a.MaxSize > 0 && size > a.MaxSize:
+		a.skip(id, fmt.Errorf("%w: %s has %d bytes, more than %d", ErrFileTooLarge, id, size, a.MaxSize))
+		return nil
+	}
+	nested := IsArchive(inner)
+	l, err := LoaderFor(inner)
+	if err != nil && !nested {
+		return nil
+	}
+	if nested && depth+1 >= maxArchiveDepth {
+		return nil
+	}
+	rc, err := open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	// The size in the header is not trusted
+	var r io.Reader = rc
+	if a.MaxSize > 0 {
+		r = &limitedReader{r: rc, n: a.MaxSize, name: id}
+	}
+	if nested {
+		if !strings.HasSuffix(strings.ToLower(inner), ".zip") {
+			return a.loadTar(ctx, r, id, archive, prefix+inner+"/", depth+1, yield)
+		}
+		// Zip files are read from their end, so they are held in memory
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return err
+		}
+		return a.loadZip(ctx, z, archive, prefix+inner+"/", depth+1, yield)
+	}
+	tag := func(doc *Document) error {
+		if doc.Metadata == nil {
+			doc.Metadata = Metadata{}
+		}
+		doc.Metadata[ArchiveKey] = archive
+		doc.Metadata[ArchivePathKey] = prefix + inner
+		return yield(doc)
+	}
+	if pl, ok := l.(PartLoader); ok && a.PartSize > 0 && size > a.PartSize {
+		return pl.LoadParts(ctx, id, r, int(a.PartSize), tag)
+	}
+	doc, err := loadDocument(ctx, l, id, r)
+	if err != nil {
+		return err
+	}
+	return tag(doc)
+}
+
+func (a *ArchiveLoader) skip(name string, err error) {
+	if a.OnSkip != nil {
+		a.OnSkip(name, err)
+	}
+}
+
+// limitedReader fails reads past n bytes with ErrFileTooLarge, so that a
+// file whose archive understates its size, or a page larger than allowed,
+// cannot fill the memory.
+type limitedReader struct {
+	r    io.Reader
+	n    int64
+	name string
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		// Only the end of the file may follow
+		if n, err := l.r.Read(make([]byte, 1)); n == 0 {
+			return 0, err
+		}
+		return 0, fmt.Errorf("%w: %s has more bytes than allowed", ErrFileTooLarge, l.name)
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
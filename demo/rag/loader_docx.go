@@ -0,0 +1,238 @@
+package rag
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DOCXLoader extracts the text of Word documents: paragraphs, headings,
+// which become Markdown headings, and tables, flattened with " | " between
+// cells. The document is split into one section per top-level heading,
+// numbered in "section" metadata, with the heading text in "heading".
+type DOCXLoader struct{}
+
+func (DOCXLoader) Load(ctx context.Context, name string, r io.Reader) (*Document, error) {
+	zr, err := openZip(r)
+	if err != nil {
+		return nil, fmt.Errorf("docx %s: %w", name, err)
+	}
+	body, err := readPart(zr, "word/document.xml")
+	if err == nil && body == nil {
+		err = errors.New("no word/document.xml")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("docx %s: %w", name, err)
+	}
+	styles, err := readPart(zr, "word/styles.xml")
+	if err != nil {
+		return nil, fmt.Errorf("docx %s: %w", name, err)
+	}
+	blocks, err := docxBlocks(body, docxHeadingStyles(styles))
+	if err != nil {
+		return nil, fmt.Errorf("docx %s: %w", name, err)
+	}
+	doc := &Document{ID: name, Metadata: Metadata{"source": name}}
+	doc.Sections = docxSections(blocks)
+	return doc, nil
+}
+
+// docxBlock is a paragraph or table of a Word document. Level is the
+// heading level of heading paragraphs and 0 otherwise.
+type docxBlock struct {
+	text  string
+	level int
+}
+
+// docxHeadingStyles maps the IDs of heading paragraph styles to their
+// level. Styles are recognized by their outline level, which is
+// independent of the document's language, or else by a name such as
+// "heading 2" or "Title".
+func docxHeadingStyles(data []byte) map[string]int {
+	levels := make(map[string]int)
+	if data == nil {
+		return levels
+	}
+	var styles struct {
+		Styles []struct {
+			ID   string `xml:"styleId,attr"`
+			Name struct {
+				Val string `xml:"val,attr"`
+			} `xml:"name"`
+			OutlineLevel *struct {
+				Val int `xml:"val,attr"`
+			} `xml:"pPr>outlineLvl"`
+		} `xml:"style"`
+	}
+	if xml.Unmarshal(data, &styles) != nil {
+		return levels
+	}
+	for _, s := range styles.Styles {
+		name := strings.ToLower(s.Name.Val)
+		switch {
+		case s.OutlineLevel != nil && s.OutlineLevel.Val < 9:
+			levels[s.ID] = s.OutlineLevel.Val + 1
+		case name == "title":
+			levels[s.ID] = 1
+		case strings.HasPrefix(name, "heading "):
+			if n, err := strconv.Atoi(strings.TrimPrefix(name, "heading ")); err == nil && n > 0 {
+				levels[s.ID] = n
+			}
+		}
+	}
+	return levels
+}
+
+// docxBlocks reads the paragraphs and tables of a document body in order.
+// Paragraphs inside tables become cell text, and nested tables are
+// flattened into the cell that holds them.
+func docxBlocks(data []byte, headingStyles map[string]int) ([]docxBlock, error) {
+	type paragraph struct {
+		text  strings.Builder
+		level int
+	}
+	var (
+		blocks    []docxBlock
+		paras     []*paragraph // paragraphs can nest through text boxes
+		inRun     bool
+		inText    bool
+		tblDepth  int
+		rows      [][]string
+		cell      strings.Builder
+		cellParas int
+	)
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return blocks, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "p":
+				paras = append(paras, &paragraph{})
+			case "pStyle":
+				if len(paras) > 0 {
+					paras[len(paras)-1].level = headingStyles[xmlAttr(t, "val")]
+				}
+			case "outlineLvl":
+				if n, err := strconv.Atoi(xmlAttr(t, "val")); err == nil && n < 9 && len(paras) > 0 {
+					paras[len(paras)-1].level = n + 1
+				}
+			case "r":
+				inRun = true
+			case "t":
+				inText = inRun && len(paras) > 0
+			case "tab":
+				if inRun && len(paras) > 0 {
+					paras[len(paras)-1].text.WriteString("\t")
+				}
+			case "br", "cr":
+				if inRun && len(paras) > 0 {
+					paras[len(paras)-1].text.WriteString("\n")
+				}
+			case "tbl":
+				tblDepth++
+				if tblDepth == 1 {
+					rows = nil
+				}
+			case "tr":
+				if tblDepth == 1 {
+					rows = append(rows, nil)
+				}
+			case "tc":
+				if tblDepth == 1 {
+					cell.Reset()
+					cellParas = 0
+				}
+			}
+		case xml.CharData:
+			if inText {
+				paras[len(paras)-1].text.Write(t)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "r":
+				inRun = false
+			case "t":
+				inText = false
+			case "p":
+				if len(paras) == 0 {
+					continue
+				}
+				para := paras[len(paras)-1]
+				paras = paras[:len(paras)-1]
+				text := cleanSpaces(para.text.String())
+				switch {
+				case text == "":
+				case tblDepth > 0:
+					if cellParas > 0 {
+						cell.WriteString(" ")
+					}
+					cell.WriteString(strings.ReplaceAll(text, "\n", " "))
+					cellParas++
+				default:
+					blocks = append(blocks, docxBlock{text: text, level: para.level})
+				}
+			case "tc":
+				if tblDepth == 1 && len(rows) > 0 {
+					rows[len(rows)-1] = append(rows[len(rows)-1], cell.String())
+				}
+			case "tbl":
+				tblDepth--
+				if tblDepth == 0 {
+					if text := tableText(rows); text != "" {
+						blocks = append(blocks, docxBlock{text: text})
+					}
+				}
+			}
+		}
+	}
+}
+
+// docxSections groups blocks into sections, starting a new one at every
+// heading of the highest level used in the document.
+func docxSections(blocks []docxBlock) []Section {
+	top := 0
+	for _, b := range blocks {
+		if b.level > 0 && (top == 0 || b.level < top) {
+			top = b.level
+		}
+	}
+	var sections []Section
+	var text []string
+	var heading string
+	flush := func() {
+		if len(text) == 0 {
+			return
+		}
+		metadata := Metadata{"section": strconv.Itoa(len(sections) + 1)}
+		if heading != "" {
+			metadata["heading"] = heading
+		}
+		sections = append(sections, Section{Text: strings.Join(text, "\n\n"), Metadata: metadata})
+		text = nil
+	}
+	for _, b := range blocks {
+		if b.level > 0 && b.level == top {
+			flush()
+			heading = b.text
+		}
+		if b.level > 0 {
+			text = append(text, strings.Repeat("#", min(b.level, 6))+" "+strings.ReplaceAll(b.text, "\n", " "))
+		} else {
+			text = append(text, b.text)
+		}
+	}
+	flush()
+	return sections
+}
@@ -0,0 +1,435 @@
+package rag
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html/charset"
+)
+
+// EmailLoader loads e-mail: .eml files holding one message and mbox files
+// holding many, every message becoming a section of its own. The text of a
+// section starts with the subject, sender and date of its message,
+// followed by its body: the plain text part, or the text of the HTML part
+// if there is none, without quoted replies, the attribution lines
+// introducing them, forwarded originals or signatures, which would repeat
+// the text of other messages in every chunk. Attachments are left out.
+//
+// Messages are grouped into threads by their Message-ID, In-Reply-To and
+// References headers, and the sections ordered by thread and date, so that
+// a conversation is read in order. Every section carries the "subject",
+// "from", "to", "date", as an RFC 3339 time, "message_id" and
+// "in_reply_to" of its message in its metadata, where known, and its
+// conversation: the "thread" it belongs to, identified by the Message-ID of
+// its first message, the "thread_subject", its "thread_position" from 1
+// and the number of "thread_messages", and the senders of the thread as
+// "participants".
+//
+// A malformed message of an mbox file is left out, and the other messages
+// loaded; only a file of one message fails to load if it is malformed.
+type EmailLoader struct {
+	// OnSkip, if set, is called for the messages of mbox files left out
+	// for being malformed, with the name of the file and the position of
+	// the message in it, such as "inbox.mbox message 3".
+	OnSkip func(message string, err error)
+}
+
+func (l EmailLoader) Load(ctx context.Context, name string, r io.Reader) (*Document, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	raws := [][]byte{data}
+	if bytes.HasPrefix(data, []byte("From ")) {
+		raws = splitMbox(data)
+	}
+	var messages []*emailMessage
+	for i, raw := range raws {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		m, err := parseEmail(raw)
+		if err != nil && len(raws) == 1 {
+			return nil, fmt.Errorf("email %s: %w", name, err)
+		}
+		if err != nil {
+			if l.OnSkip != nil {
+				l.OnSkip(fmt.Sprintf("%s message %d", name, i+1), err)
+			}
+			continue
+		}
+		messages = append(messages, m)
+	}
+	doc := &Document{ID: name, Metadata: Metadata{"source": name}}
+	for _, thread := range threadEmails(messages) {
+		var participants []string
+		for _, m := range thread {
+			if m.from != "" && !slices.Contains(participants, m.from) {
+				participants = append(participants, m.from)
+			}
+		}
+		for i, m := range thread {
+			if m.body == "" {
+				continue
+			}
+			metadata := Metadata{
+				"thread":          m.thread,
+				"thread_subject":  threadSubject(thread[0].subject),
+				"thread_position": strconv.Itoa(i + 1),
+				"thread_messages": strconv.Itoa(len(thread)),
+				"participants":    strings.Join(participants, ", "),
+			}
+			var text strings.Builder
+			for _, h := range []struct{ key, value string }{
+				{"subject", m.subject},
+				{"from", m.from},
+				{"to", m.to},
+				{"date", m.dateText()},
+				{"message_id", m.id},
+				{"in_reply_to", m.inReplyTo},
+			} {
+				if h.value != "" {
+					metadata[h.key] = h.value
+				}
+			}
+			for _, h := range [][2]string{{"Subject", m.subject}, {"From", m.from}, {"Date", m.dateText()}} {
+				if h[1] != "" {
+					fmt.Fprintf(&text, "%s: %s\n", h[0], h[1])
+				}
+			}
+			text.WriteString("\n" + m.body)
+			doc.Sections = append(doc.Sections, Section{Text: text.String(), Metadata: metadata})
+		}
+	}
+	return doc, nil
+}
+
+// emailMessage is a parsed message of an EmailLoader.
+type emailMessage struct {
+	id, inReplyTo string
+	references    []string
+	subject       string
+	from, to      string
+	date          time.Time // zero if the message has no valid Date
+	body          string    // cleaned, see cleanEmailBody
+	thread        string    // the ID of the thread, set by threadEmails
+	index         int       // the position of the message in its file
+}
+
+func (m *emailMessage) dateText() string {
+	if m.date.IsZero() {
+		return ""
+	}
+	return m.date.UTC().Format(time.RFC3339)
+}
+
+// splitMbox splits an mbox file into its messages, which start with a
+// "From " line at the start of the file or after a blank line. Lines of the
+// messages escaped as ">From " are unescaped.
+func splitMbox(data []byte) [][]byte {
+	var buffers []*bytes.Buffer
+	blank := true
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if blank && bytes.HasPrefix(line, []byte("From ")) {
+			buffers = append(buffers, &bytes.Buffer{})
+			blank = false
+			continue
+		}
+		blank = len(bytes.TrimRight(line, "\r")) == 0
+		if len(buffers) == 0 {
+			continue
+		}
+		if unescaped, ok := bytes.CutPrefix(line, []byte(">")); ok && bytes.HasPrefix(bytes.TrimLeft(unescaped, ">"), []byte("From ")) {
+			line = unescaped
+		}
+		b := buffers[len(buffers)-1]
+		b.Write(line)
+		b.WriteByte('\n')
+	}
+	var messages [][]byte
+	for _, b := range buffers {
+		if len(bytes.TrimSpace(b.Bytes())) > 0 {
+			messages = append(messages, b.Bytes())
+		}
+	}
+	return messages
+}
+
+// headerDecoder decodes the encoded words of headers in any charset.
+var headerDecoder = &mime.WordDecoder{CharsetReader: charset.NewReaderLabel}
+
+// messageIDPattern matches the message IDs of Message-ID, In-Reply-To and
+// References headers.
+var messageIDPattern = regexp.MustCompile(`<[^<>\s]+>`)
+
+// parseEmail parses a message in the Internet Message Format.
+func parseEmail(raw []byte) (*emailMessage, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	decode := func(key string) string {
+		value := msg.Header.Get(key)
+		if decoded, err := headerDecoder.DecodeHeader(value); err == nil {
+			value = decoded
+		}
+		return strings.Join(strings.Fields(value), " ")
+	}
+	ids := func(key string) []string {
+		found := messageIDPattern.FindAllString(msg.Header.Get(key), -1)
+		for i, id := range found {
+			found[i] = strings.Trim(id, "<>")
+		}
+		return found
+	}
+	m := &emailMessage{
+		subject:    decode("Subject"),
+		from:       addressList(msg.Header, "From"),
+		to:         addressList(msg.Header, "To"),
+		references: ids("References"),
+	}
+	if found := ids("Message-ID"); len(found) > 0 {
+		m.id = found[0]
+	}
+	if found := ids("In-Reply-To"); len(found) > 0 {
+		m.inReplyTo = found[0]
+	}
+	if date, err := msg.Header.Date(); err == nil {
+		m.date = date
+	}
+	body, err := emailText(msg.Header, msg.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.body = cleanEmailBody(body)
+	return m, nil
+}
+
+// addressList returns the addresses of the header key as names, or as the
+// addresses of those without one, separated by commas.
+func addressList(h mail.Header, key string) string {
+	if h.Get(key) == "" {
+		return ""
+	}
+	addresses, err := (&mail.AddressParser{WordDecoder: headerDecoder}).ParseList(h.Get(key))
+	if err != nil {
+		value := h.Get(key)
+		if decoded, err := headerDecoder.DecodeHeader(value); err == nil {
+			value = decoded
+		}
+		return strings.Join(strings.Fields(value), " ")
+	}
+	names := make([]string, len(addresses))
+	for i, a := range addresses {
+		names[i] = cmp.Or(a.Name, a.Address)
+	}
+	return strings.Join(names, ", ")
+}
+
+// partHeader is the header of a message or of a part of a multipart body.
+type partHeader interface {
+	Get(key string) string
+}
+
+// emailText returns the text of a message or part with header h and body
+// r: a text/plain body, the text of a text/html one, or that of the parts
+// of a multipart one, from which multipart/alternative keeps the plain text
+// if it has any. Attachments and other media types have no text.
+func emailText(h partHeader, r io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(cmp.Or(h.Get("Content-Type"), "text/plain"))
+	if err != nil {
+		mediaType, params = "text/plain", nil
+	}
+	if disposition, _, _ := mime.ParseMediaType(h.Get("Content-Disposition")); disposition == "attachment" {
+		return "", nil
+	}
+	switch encoding := strings.ToLower(strings.TrimSpace(h.Get("Content-Transfer-Encoding"))); encoding {
+	case "quoted-printable":
+		r = quotedprintable.NewReader(r)
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, r)
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(r, params["boundary"])
+		var texts []string
+		var plain, html string
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", err
+			}
+			text, err := emailText(part.Header, part)
+			if err != nil {
+				return "", err
+			}
+			if text = strings.TrimSpace(text); text == "" {
+				continue
+			}
+			partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+			switch {
+			case partType == "text/html" && html == "":
+				html = text
+			case partType != "text/html" && plain == "":
+				plain = text
+			}
+			texts = append(texts, text)
+		}
+		if mediaType == "multipart/alternative" {
+			return cmp.Or(plain, html), nil
+		}
+		return strings.Join(texts, "\n\n"), nil
+	}
+	if mediaType != "text/plain" && mediaType != "text/html" {
+		return "", nil
+	}
+	if cs := strings.ToLower(params["charset"]); cs != "" && cs != "utf-8" && cs != "us-ascii" {
+		if r, err = charset.NewReaderLabel(cs, r); err != nil {
+			return "", err
+		}
+	}
+	if mediaType == "text/html" {
+		page, err := parseHTML(r, nil)
+		if err != nil {
+			return "", err
+		}
+		return page.text, nil
+	}
+	data, err := io.ReadAll(r)
+	return string(data), err
+}
+
+var (
+	// attributionPattern matches the line introducing a quoted message, as
+	// in "On Mon, 3 Jun 2024 at 10:00, Alice <alice@example.com> wrote:".
+	attributionPattern = regexp.MustCompile(`^(On|Am|Le|El|Il|Op) .{4,200}(wrote|schrieb|a écrit|escribió|ha scritto|schreef):$`)
+	// forwardedPattern matches the lines starting a forwarded or, in
+	// Outlook, quoted message.
+	forwardedPattern = regexp.MustCompile(`(?i)^(-+\s*(original message|forwarded message)\s*-+|_{10,}|begin forwarded message:)$`)
+	// sentFromPattern matches the signatures of mail apps.
+	sentFromPattern = regexp.MustCompile(`(?i)^(sent from my \w+|get outlook for \w+)`)
+)
+
+// cleanEmailBody returns the text of a message body without what repeats
+// other messages or is not part of the message: quoted lines, the
+// attribution lines introducing them, forwarded or quoted originals that
+// are not marked by ">", and signatures after a "-- " line or from mail
+// apps.
+func cleanEmailBody(body string) string {
+	lines := strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n")
+	var kept []string
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], " \t\r")
+		trimmed := strings.TrimSpace(line)
+		if line == "--" || lines[i] == "-- " || forwardedPattern.MatchString(trimmed) {
+			break
+		}
+		// Attributions may be wrapped onto a second line
+		attribution := attributionPattern.MatchString(trimmed)
+		if !attribution && i+1 < len(lines) && strings.HasPrefix(trimmed, "On ") {
+			if attributionPattern.MatchString(trimmed + " " + strings.TrimSpace(lines[i+1])) {
+				attribution = true
+				i++
+			}
+		}
+		if attribution {
+			// A quote not marked by ">", as of HTML mail, runs to the end
+			next := i + 1
+			for next < len(lines) && strings.TrimSpace(lines[next]) == "" {
+				next++
+			}
+			if next < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[next]), ">") {
+				break
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, ">") || sentFromPattern.MatchString(trimmed) {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return cleanText(strings.Join(kept, "\n"))
+}
+
+// replyPrefix matches the prefixes mail apps put before the subjects of
+// replies and forwards, such as "Re: " or "Fwd: ".
+var replyPrefix = regexp.MustCompile(`(?i)^\s*((re|fw|fwd|aw|wg|sv|tr)(\[\d+\])?:\s*)+`)
+
+// threadSubject returns subject without the prefixes of replies and
+// forwards.
+func threadSubject(subject string) string {
+	return strings.TrimSpace(replyPrefix.ReplaceAllString(subject, ""))
+}
+
+// threadEmails groups messages into threads and sets their thread, and
+// returns the threads ordered by the date of their first message, each
+// with its messages ordered by date. A message belongs to the thread of
+// the first message it references, or of the message it replies to; those
+// without either start a thread, identified by their Message-ID, or by
+// their subject if they have none.
+func threadEmails(messages []*emailMessage) [][]*emailMessage {
+	byID := make(map[string]*emailMessage, len(messages))
+	for i, m := range messages {
+		m.index = i
+		if m.id != "" && byID[m.id] == nil {
+			byID[m.id] = m
+		}
+	}
+	var root func(m *emailMessage, depth int) string
+	root = func(m *emailMessage, depth int) string {
+		switch {
+		case len(m.references) > 0:
+			return m.references[0]
+		case m.inReplyTo != "":
+			// Bound the walk, as replies may form a cycle
+			if parent := byID[m.inReplyTo]; parent != nil && parent != m && depth < len(messages) {
+				return root(parent, depth+1)
+			}
+			return m.inReplyTo
+		case m.id != "":
+			return m.id
+		}
+		return "subject:" + strings.ToLower(threadSubject(m.subject))
+	}
+	threads := make(map[string][]*emailMessage)
+	var order []string
+	for _, m := range messages {
+		m.thread = root(m, 0)
+		if _, ok := threads[m.thread]; !ok {
+			order = append(order, m.thread)
+		}
+		threads[m.thread] = append(threads[m.thread], m)
+	}
+	byDate := func(a, b *emailMessage) int {
+		if c := a.date.Compare(b.date); c != 0 {
+			return c
+		}
+		return a.index - b.index
+	}
+	grouped := make([][]*emailMessage, len(order))
+	for i, id := range order {
+		grouped[i] = threads[id]
+		slices.SortStableFunc(grouped[i], byDate)
+	}
+	slices.SortStableFunc(grouped, func(a, b []*emailMessage) int { return byDate(a[0], b[0]) })
+	return grouped
+}
@@ -0,0 +1,245 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// HTMLLoader extracts the readable text of an HTML page. Navigation,
+// site headers and footers, sidebars, forms and scripts are dropped, and
+// when the page marks up its content with <main>, or a single <article>,
+// only that is kept.
+// Headings become Markdown headings so the splitter can cut on them. The
+// page title and the URL given by <link rel="canonical">, if any, are
+// recorded as "title" and "url" metadata.
+type HTMLLoader struct{}
+
+func (HTMLLoader) Load(ctx context.Context, name string, r io.Reader) (*Document, error) {
+	page, err := parseHTML(r, nil)
+	if err != nil {
+		return nil, fmt.Errorf("html %s: %w", name, err)
+	}
+	doc := &Document{ID: name, Metadata: Metadata{"source": name}}
+	if page.title != "" {
+		doc.Metadata["title"] = page.title
+	}
+	if page.canonical != nil {
+		doc.Metadata["url"] = page.canonical.String()
+	}
+	if page.text != "" {
+		doc.Sections = []Section{{Text: page.text}}
+	}
+	return doc, nil
+}
+
+// htmlPage is what is extracted from a parsed HTML page.
+type htmlPage struct {
+	title     string
+	text      string
+	canonical *url.URL   // nil unless the page declares one
+	links     []*url.URL // absolute, without fragments
+}
+
+// boilerplate lists elements whose content is never part of the text.
+var boilerplate = map[atom.Atom]bool{
+	atom.Head: true, atom.Script: true, atom.Style: true, atom.Noscript: true,
+	atom.Template: true, atom.Svg: true, atom.Iframe: true, atom.Nav: true,
+	atom.Header: true, atom.Footer: true, atom.Aside: true, atom.Form: true,
+	atom.Button: true, atom.Select: true,
+}
+
+// boilerplateRoles lists ARIA landmark roles treated like boilerplate.
+var boilerplateRoles = map[string]bool{
+	"navigation": true, "banner": true, "contentinfo": true, "complementary": true,
+	"search": true, "menu": true, "menubar": true,
+}
+
+// blockElements start a new line in the extracted text.
+var blockElements = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Section: true, atom.Article: true,
+	atom.Main: true, atom.Blockquote: true, atom.Pre: true, atom.Ul: true,
+	atom.Ol: true, atom.Li: true, atom.Dl: true, atom.Dt: true, atom.Dd: true,
+	atom.Table: true, atom.Tr: true, atom.Br: true, atom.Hr: true,
+	atom.Figure: true, atom.Figcaption: true, atom.Details: true, atom.Summary: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+}
+
+var headingLevels = map[atom.Atom]int{
+	atom.H1: 1, atom.H2: 2, atom.H3: 3, atom.H4: 4, atom.H5: 5, atom.H6: 6,
+}
+
+// parseHTML parses an HTML page. Relative links and the canonical URL are
+// resolved against base, or against the page's <base href> if it has one;
+// with a nil base only absolute links are returned.
+func parseHTML(r io.Reader, base *url.URL) (*htmlPage, error) {
+	root, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	page := &htmlPage{}
+	resolve := func(ref string) *url.URL {
+		u, err := url.Parse(strings.TrimSpace(ref))
+		if err != nil {
+			return nil
+		}
+		if base != nil {
+			u = base.ResolveReference(u)
+		}
+		if !u.IsAbs() {
+			return nil
+		}
+		u.Fragment, u.RawFragment = "", ""
+		return u
+	}
+	var mains, articles []*html.Node
+	for n := range root.Descendants() {
+		if n.Type != html.ElementNode {
+			continue
+		}
+		switch n.DataAtom {
+		case atom.Title:
+			if page.title == "" {
+				page.title = strings.Join(strings.Fields(nodeText(n)), " ")
+			}
+		case atom.Base:
+			if href, ok := attr(n, "href"); ok && base != nil {
+				if u, err := url.Parse(href); err == nil {
+					base = base.ResolveReference(u)
+				}
+			}
+		case atom.Link:
+			if rel, _ := attr(n, "rel"); strings.EqualFold(rel, "canonical") && page.canonical == nil {
+				if href, ok := attr(n, "href"); ok {
+					page.canonical = resolve(href)
+				}
+			}
+		case atom.A:
+			if href, ok := attr(n, "href"); ok {
+				if u := resolve(href); u != nil && (u.Scheme == "http" || u.Scheme == "https") {
+					page.links = append(page.links, u)
+				}
+			}
+		case atom.Main:
+			mains = append(mains, n)
+		case atom.Article:
+			articles = append(articles, n)
+		}
+	}
+	content := root
+	switch {
+	case len(mains) > 0:
+		content = mains[0]
+	case len(articles) == 1:
+		content = articles[0]
+	}
+	var b strings.Builder
+	writeText(&b, content)
+	page.text = cleanText(b.String())
+	return page, nil
+}
+
+// writeText appends the text under n, skipping boilerplate and starting
+// block elements on a new line.
+func writeText(b *strings.Builder, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		b.WriteString(n.Data)
+		return
+	case html.ElementNode:
+		if isBoilerplate(n) {
+			return
+		}
+	}
+	block := n.Type == html.ElementNode && blockElements[n.DataAtom]
+	if block {
+		b.WriteString("\n\n")
+		if level := headingLevels[n.DataAtom]; level > 0 {
+			b.WriteString(strings.Repeat("#", level) + " ")
+		}
+		if n.DataAtom == atom.Li {
+			b.WriteString("- ")
+		}
+	}
+	if n.Type == html.ElementNode && (n.DataAtom == atom.Td || n.DataAtom == atom.Th) && hasPrevElement(n) {
+		b.WriteString(" | ")
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		writeText(b, c)
+	}
+	if block {
+		b.WriteString("\n\n")
+	}
+}
+
+// cleanText collapses whitespace within paragraphs and drops paragraphs
+// left empty, such as list items or headings without text.
+func cleanText(s string) string {
+	var paragraphs []string
+	for _, p := range strings.Split(s, "\n\n") {
+		if p = strings.Join(strings.Fields(p), " "); p != "" && p != "-" && strings.Trim(p, "# ") != "" {
+			paragraphs = append(paragraphs, p)
+		}
+	}
+	return strings.Join(paragraphs, "\n\n")
+}
+
+// isBoilerplate reports whether the element n and its content should be
+// left out of the text. Headers and footers only count when they belong to
+// the page rather than to an article or section within it.
+func isBoilerplate(n *html.Node) bool {
+	if boilerplate[n.DataAtom] {
+		if n.DataAtom != atom.Header && n.DataAtom != atom.Footer {
+			return true
+		}
+		for p := n.Parent; ; p = p.Parent {
+			if p == nil {
+				return true
+			}
+			if p.DataAtom == atom.Article || p.DataAtom == atom.Section || p.DataAtom == atom.Main {
+				break
+			}
+		}
+	}
+	if _, ok := attr(n, "hidden"); ok {
+		return true
+	}
+	if v, _ := attr(n, "aria-hidden"); v == "true" {
+		return true
+	}
+	role, _ := attr(n, "role")
+	return boilerplateRoles[strings.ToLower(role)]
+}
+
+func hasPrevElement(n *html.Node) bool {
+	for p := n.PrevSibling; p != nil; p = p.PrevSibling {
+		if p.Type == html.ElementNode {
+			return true
+		}
+	}
+	return false
+}
+
+func nodeText(n *html.Node) string {
+	var b strings.Builder
+	for d := range n.Descendants() {
+		if d.Type == html.TextNode {
+			b.WriteString(d.Data)
+		}
+	}
+	return b.String()
+}
+
+func attr(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && strings.EqualFold(a.Key, key) {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,147 @@
+package rag
+
+import (
+	"context"
+	"io"
+	"strings"
+)
+
+// breadcrumbSeparator joins the headings of a Markdown breadcrumb.
+const breadcrumbSeparator = " > "
+
+// MarkdownLoader loads Markdown files with one section per heading of level
+// one to three. Each section records its heading in "heading" metadata and
+// the path of enclosing headings, e.g. "Install > Linux > Debian", in
+// "breadcrumb", which the default prompt puts before the text of every
+// chunk so that the model knows where a passage belongs. Only ATX headings
+// ("## Title") are recognized, and lines in fenced code blocks are never
+// taken for headings. YAML front matter is dropped, except for its title
+// and date, which become "title" and "date" metadata.
+type MarkdownLoader struct{}
+
+func (MarkdownLoader) Load(ctx context.Context, name string, r io.Reader) (*Document, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	doc := &Document{ID: name, Metadata: Metadata{"source": name}}
+	text, fields := markdownFrontMatter(string(data))
+	for k, v := range fields {
+		doc.Metadata[k] = v
+	}
+	doc.Sections = markdownSections(text)
+	return doc, nil
+}
+
+// markdownFrontMatter strips YAML front matter delimited by "---" lines from
+// the start of text and returns the rest and the front matter's title and
+// date, if set, by key.
+func markdownFrontMatter(text string) (rest string, fields Metadata) {
+	text = strings.TrimPrefix(text, "\ufeff")
+	if !strings.HasPrefix(text, "---\n") && !strings.HasPrefix(text, "---\r\n") {
+		return text, nil
+	}
+	fields = Metadata{}
+	lines := strings.SplitAfter(text, "\n")
+	for i := 1; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\r\n")
+		if line == "---" || line == "..." {
+			return strings.Join(lines[i+1:], ""), fields
+		}
+		for _, key := range []string{"title", "date"} {
+			if v, ok := strings.CutPrefix(line, key+":"); ok {
+				if v = strings.Trim(strings.TrimSpace(v), `"'`); v != "" {
+					fields[key] = v
+				}
+			}
+		}
+	}
+	// Unterminated, so not front matter after all
+	return text, nil
+}
+
+// markdownSections splits text before every heading of level one to three.
+// Sections holding nothing but their heading are dropped, as their heading
+// is part of the breadcrumb of the sections below it.
+func markdownSections(text string) []Section {
+	var (
+		sections []Section
+		lines    []string
+		metadata Metadata
+		headings [3]string // current heading of each level
+		fence    string    // delimiter of the open code block, if any
+	)
+	flush := func() {
+		body := strings.TrimSpace(strings.Join(lines, ""))
+		if metadata != nil && !strings.Contains(body, "\n") {
+			// Only the heading line
+			body = ""
+		}
+		if body != "" {
+			sections = append(sections, Section{Text: body, Metadata: metadata})
+		}
+		lines = nil
+	}
+	for _, line := range strings.SplitAfter(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+				fence = ""
+			}
+			lines = append(lines, line)
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fence = trimmed[:3]
+			lines = append(lines, line)
+			continue
+		}
+		level, heading := markdownHeading(line)
+		if level == 0 || level > len(headings) {
+			lines = append(lines, line)
+			continue
+		}
+		flush()
+		headings[level-1] = heading
+		clear(headings[level:])
+		var path []string
+		for _, h := range headings[:level] {
+			if h != "" {
+				path = append(path, h)
+			}
+		}
+		metadata = Metadata{"heading": heading, "breadcrumb": strings.Join(path, breadcrumbSeparator)}
+		lines = append(lines, line)
+	}
+	flush()
+	return sections
+}
+
+// markdownHeading returns the level and text of an ATX heading line, or 0
+// if line is not one.
+func markdownHeading(line string) (int, string) {
+	line = strings.TrimRight(line, "\r\n")
+	// Up to three spaces of indentation; four make a code block
+	indent := len(line) - len(strings.TrimLeft(line, " "))
+	if indent > 3 {
+		return 0, ""
+	}
+	line = line[indent:]
+	level := len(line) - len(strings.TrimLeft(line, "#"))
+	if level == 0 || level > 6 {
+		return 0, ""
+	}
+	rest := line[level:]
+	if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return 0, ""
+	}
+	// A closing sequence of #s is not part of the heading
+	heading := strings.TrimSpace(rest)
+	if trimmed := strings.TrimRight(heading, "#"); trimmed == "" || strings.HasSuffix(trimmed, " ") {
+		heading = strings.TrimSpace(trimmed)
+	}
+	if heading == "" {
+		return 0, ""
+	}
+	return level, heading
+}
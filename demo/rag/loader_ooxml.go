@@ -0,0 +1,106 @@
+package rag
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// maxPartSize bounds how much of a single part of an Office file is read,
+// guarding against zip bombs.
+const maxPartSize = 64 << 20
+
+// openZip reads an Office Open XML package, which is a zip archive.
+func openZip(r io.Reader) (*zip.Reader, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return zip.NewReader(bytes.NewReader(data), int64(len(data)))
+}
+
+// readPart returns the contents of the named part, or nil if the package
+// has no such part.
+func readPart(zr *zip.Reader, name string) ([]byte, error) {
+	f, err := zr.Open(name)
+	if err != nil {
+		return nil, nil
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxPartSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if len(data) > maxPartSize {
+		return nil, fmt.Errorf("%s: part too large", name)
+	}
+	return data, nil
+}
+
+// readRels returns the relationships of the part at partName, mapping
+// relationship IDs to the absolute names of their target parts.
+func readRels(zr *zip.Reader, partName string) (map[string]string, error) {
+	dir, file := path.Split(partName)
+	data, err := readPart(zr, dir+"_rels/"+file+".rels")
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var rels struct {
+		Relationships []struct {
+			ID         string `xml:"Id,attr"`
+			Target     string `xml:"Target,attr"`
+			TargetMode string `xml:"TargetMode,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := xml.Unmarshal(data, &rels); err != nil {
+		return nil, fmt.Errorf("%s relationships: %w", partName, err)
+	}
+	targets := make(map[string]string, len(rels.Relationships))
+	for _, r := range rels.Relationships {
+		if r.TargetMode == "External" {
+			continue
+		}
+		target := r.Target
+		if strings.HasPrefix(target, "/") {
+			target = target[1:]
+		} else {
+			target = path.Join(dir, target)
+		}
+		targets[r.ID] = target
+	}
+	return targets, nil
+}
+
+// xmlAttr returns the value of the attribute with the given local name.
+func xmlAttr(e xml.StartElement, local string) string {
+	for _, a := range e.Attr {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// tableText flattens table rows into lines, with " | " between cells.
+func tableText(rows [][]string) string {
+	lines := make([]string, 0, len(rows))
+	for _, row := range rows {
+		if strings.Join(row, "") != "" {
+			lines = append(lines, strings.Join(row, " | "))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// cleanSpaces collapses runs of spaces and tabs, keeping line breaks.
+func cleanSpaces(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
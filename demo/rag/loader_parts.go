@@ -0,0 +1,167 @@
+package rag
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultPartSize is the size in bytes from which LoadFileParts loads files
+// in parts, and the size of those parts.
+const DefaultPartSize = 8 << 20
+
+// PartKey is the metadata key of the number of a part, counting from 1, of a
+// file loaded in parts.
+const PartKey = "part"
+
+// ErrFileTooLarge is returned by LoadFileParts for files above its limit.
+var ErrFileTooLarge = errors.New("file too large")
+
+// A PartLoader loads a file as a sequence of documents, its parts, of about
+// size bytes of text each. Every part is passed to yield before the next is
+// read, so that only one is held in memory however large the file is. Parts
+// have the IDs PartID returns and the file name in "source" metadata.
+type PartLoader interface {
+	LoadParts(ctx context.Context, name string, r io.Reader, size int, yield func(*Document) error) error
+}
+
+// PartID returns the ID of part n of the file name, such as "app.log#part2".
+func PartID(name string, n int) string {
+	return fmt.Sprintf("%s#part%d", name, n)
+}
+
+// IsPartOf reports whether id is the ID of the file name or of one of its
+// parts.
+func IsPartOf(id, name string) bool {
+	n, ok := strings.CutPrefix(id, name+"#part")
+	if !ok {
+		return id == name
+	}
+	_, err := strconv.Atoi(n)
+	return err == nil
+}
+
+// newPart returns part n of the file name, without sections.
+func newPart(name string, n int) *Document {
+	return &Document{ID: PartID(name, n), Metadata: Metadata{"source": name, PartKey: strconv.Itoa(n)}}
+}
+
+// LoadFileParts loads the file at path as LoadFile does and passes the
+// document to yield, unless the file is larger than partSize bytes and its
+// Loader is a PartLoader: then its parts of partSize bytes are passed to
+// yield one at a time, which bounds the memory used by multi-gigabyte logs
+// and exports. A partSize of 0 never loads files in parts. Files larger than
+// maxSize bytes, unless it is 0, are rejected with ErrFileTooLarge before
+// they are read.
+func LoadFileParts(ctx context.Context, path string, partSize, maxSize int64, yield func(*Document) error) error {
+	l, err := LoaderFor(path)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if maxSize > 0 && info.Size() > maxSize {
+		return fmt.Errorf("%w: %s has %d bytes, more than %d", ErrFileTooLarge, path, info.Size(), maxSize)
+	}
+	name := filepath.ToSlash(path)
+	pl, ok := l.(PartLoader)
+	if !ok || partSize <= 0 || info.Size() <= partSize {
+		doc, err := loadDocument(ctx, l, name, f)
+		if err != nil {
+			return err
+		}
+		return yield(doc)
+	}
+	return pl.LoadParts(ctx, name, f, int(partSize), yield)
+}
+
+// LoadParts loads a text file in parts that end at the last line break
+// within size bytes, or at the last whole character of lines longer than
+// that.
+func (TextLoader) LoadParts(ctx context.Context, name string, r io.Reader, size int, yield func(*Document) error) error {
+	buf := make([]byte, 0, size)
+	for n := 1; ; n++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		read, err := io.ReadFull(r, buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+read]
+		eof := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !eof {
+			return err
+		}
+		if len(buf) == 0 {
+			return nil
+		}
+		cut := len(buf)
+		if !eof {
+			cut = partCut(buf)
+		}
+		part := newPart(name, n)
+		part.Sections = []Section{{Text: string(buf[:cut])}}
+		if err := yield(part); err != nil {
+			return err
+		}
+		if eof {
+			return nil
+		}
+		buf = buf[:copy(buf, buf[cut:])]
+	}
+}
+
+// partCut returns where to end a part of text read into a full buf: after
+// its last line break, or else before a character cut off at its end.
+func partCut(buf []byte) int {
+	if i := bytes.LastIndexByte(buf, '\n'); i >= 0 {
+		return i + 1
+	}
+	for i := len(buf) - 1; i > 0 && i >= len(buf)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(buf[i]) {
+			if !utf8.FullRune(buf[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(buf)
+}
+
+// LoadParts loads the records of a CSV or JSONL file in parts of about size
+// bytes of text. Their "row" metadata counts on across parts.
+func (l RecordLoader) LoadParts(ctx context.Context, name string, r io.Reader, size int, yield func(*Document) error) error {
+	n, chars := 1, 0
+	part := newPart(name, n)
+	err := l.records(ctx, name, r, func(s Section) error {
+		if chars >= size {
+			if err := yield(part); err != nil {
+				return err
+			}
+			n++
+			part, chars = newPart(name, n), 0
+		}
+		part.Sections = append(part.Sections, s)
+		chars += len(s.Text)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(part.Sections) == 0 && n > 1 {
+		return nil
+	}
+	return yield(part)
+}
@@ -0,0 +1,366 @@
+package rag
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// PDFLoader extracts text from PDF files, producing one section per page
+// with a "page" metadata entry. Text is reassembled from glyph positions so
+// that two-column layouts are read column by column and table rows are
+// flattened with " | " between cells.
+//
+// Pages with fewer than OCRMinChars characters of text, DefaultOCRMinChars
+// if zero, are taken to be scanned images. If OCR is set, their text is
+// recognized with it instead, and their sections get an "ocr_confidence"
+// metadata entry with the mean word confidence from 0 to 1, unless less
+// text is recognized than was extracted, which is then kept.
+type PDFLoader struct {
+	OCR         OCR
+	OCRMinChars int
+}
+
+func (l PDFLoader) Load(ctx context.Context, name string, r io.Reader) (*Document, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("pdf %s: %w", name, err)
+	}
+	minChars := l.OCRMinChars
+	if minChars <= 0 {
+		minChars = DefaultOCRMinChars
+	}
+	doc := &Document{ID: name, Metadata: Metadata{"source": name}}
+	var scanned []int
+	var extracted []string // the text of the scanned pages
+	for num := 1; num <= reader.NumPage(); num++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		page := reader.Page(num)
+		if page.V.IsNull() {
+			continue
+		}
+		texts, err := pageTexts(page)
+		if err != nil {
+			return nil, fmt.Errorf("pdf %s page %d: %w", name, num, err)
+		}
+		width := pageWidth(page)
+		text := layoutPage(texts, width)
+		if l.OCR != nil && textChars(text) < minChars {
+			scanned = append(scanned, num)
+			extracted = append(extracted, text)
+			continue
+		}
+		if text == "" {
+			continue
+		}
+		doc.Sections = append(doc.Sections, Section{
+			Text:     text,
+			Metadata: Metadata{"page": strconv.Itoa(num)},
+		})
+	}
+	if len(scanned) == 0 {
+		return doc, nil
+	}
+	pages, err := l.OCR.Recognize(ctx, data, scanned)
+	if err != nil {
+		return nil, fmt.Errorf("pdf %s: %w", name, err)
+	}
+	for i, page := range pages {
+		// The little text extracted beats no text recognized
+		if textChars(page.Text) <= textChars(extracted[i]) {
+			if extracted[i] != "" {
+				doc.Sections = append(doc.Sections, Section{
+					Text:     extracted[i],
+					Metadata: Metadata{"page": strconv.Itoa(scanned[i])},
+				})
+			}
+			continue
+		}
+		doc.Sections = append(doc.Sections, Section{
+			Text: page.Text,
+			Metadata: Metadata{
+				"page":           strconv.Itoa(scanned[i]),
+				"ocr_confidence": strconv.FormatFloat(page.Confidence, 'f', 2, 64),
+			},
+		})
+	}
+	// Put the recognized pages back in order
+	slices.SortStableFunc(doc.Sections, func(a, b Section) int {
+		pa, _ := strconv.Atoi(a.Metadata["page"])
+		pb, _ := strconv.Atoi(b.Metadata["page"])
+		return cmp.Compare(pa, pb)
+	})
+	return doc, nil
+}
+
+// textChars counts the characters of text other than white space.
+func textChars(text string) int {
+	n := 0
+	for _, r := range text {
+		if !unicode.IsSpace(r) {
+			n++
+		}
+	}
+	return n
+}
+
+// pageTexts returns the positioned text runs on a page. The pdf package
+// panics on malformed content streams, so that is turned into an error.
+func pageTexts(page pdf.Page) (texts []pdf.Text, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed content: %v", r)
+		}
+	}()
+	for _, t := range page.Content().Text {
+		if t.S == "" {
+			continue
+		}
+		if t.W == 0 {
+			// Standard fonts have no width table; estimate an average glyph
+			t.W = 0.5 * max(t.FontSize, 1) * float64(len([]rune(t.S)))
+		}
+		texts = append(texts, t)
+	}
+	return texts, nil
+}
+
+// pageWidth returns the width of the page's media box, which may be
+// inherited from an ancestor in the page tree. It is 0 when unknown.
+func pageWidth(page pdf.Page) float64 {
+	for v := page.V; !v.IsNull(); v = v.Key("Parent") {
+		if box := v.Key("MediaBox"); box.Len() == 4 {
+			return box.Index(2).Float64() - box.Index(0).Float64()
+		}
+	}
+	return 0
+}
+
+// pdfSpan is a run of text on a line with no large horizontal gaps.
+type pdfSpan struct {
+	x0, x1 float64
+	text   string
+}
+
+type pdfLine struct {
+	y     float64
+	size  float64
+	spans []pdfSpan
+}
+
+// layoutPage groups text runs into lines and spans, then reads the page
+// top to bottom. Blocks of lines that sit on either side of a common gutter
+// are emitted one column at a time.
+func layoutPage(texts []pdf.Text, width float64) string {
+	lines := groupLines(texts)
+	if len(lines) == 0 {
+		return ""
+	}
+	if width <= 0 {
+		for _, l := range lines {
+			width = max(width, l.spans[len(l.spans)-1].x1)
+		}
+	}
+	gutter, ok := findGutter(lines, width)
+
+	var out strings.Builder
+	writeLines := func(lines []pdfLine) {
+		for i, line := range lines {
+			if i > 0 && lines[i-1].y-line.y > 1.8*line.size {
+				out.WriteString("\n")
+			}
+			out.WriteString(joinSpans(line.spans))
+			out.WriteString("\n")
+		}
+	}
+	writeBlock := func(block []pdfLine) {
+		if !isColumnBlock(block, gutter) {
+			writeLines(block)
+			return
+		}
+		var left, right []pdfLine
+		for _, line := range block {
+			l, r := splitLine(line, gutter)
+			if len(l.spans) > 0 {
+				left = append(left, l)
+			}
+			if len(r.spans) > 0 {
+				right = append(right, r)
+			}
+		}
+		writeLines(left)
+		out.WriteString("\n")
+		writeLines(right)
+	}
+	if !ok {
+		writeLines(lines)
+		return strings.TrimSpace(out.String())
+	}
+	start := 0
+	for i, line := range lines {
+		switch {
+		case crossesGutter(line, gutter):
+			// A line crossing the gutter (title, footer) ends the block
+			writeBlock(lines[start:i])
+			writeLines(lines[i : i+1])
+			start = i + 1
+		case i > start && lines[i-1].y-line.y > 1.8*line.size:
+			// So does vertical space that is empty in both columns
+			writeBlock(lines[start:i])
+			out.WriteString("\n")
+			start = i
+		}
+	}
+	writeBlock(lines[start:])
+	return strings.TrimSpace(out.String())
+}
+
+func crossesGutter(line pdfLine, gutter float64) bool {
+	for _, s := range line.spans {
+		if s.x0 < gutter && s.x1 > gutter {
+			return true
+		}
+	}
+	return false
+}
+
+func splitLine(line pdfLine, gutter float64) (left, right pdfLine) {
+	left, right = pdfLine{y: line.y, size: line.size}, pdfLine{y: line.y, size: line.size}
+	for _, s := range line.spans {
+		if s.x1 <= gutter {
+			left.spans = append(left.spans, s)
+		} else {
+			right.spans = append(right.spans, s)
+		}
+	}
+	return left, right
+}
+
+// isColumnBlock reports whether a block of lines reads as two columns of
+// prose rather than, say, a table: most lines have text on both sides of the
+// gutter but only a single span on each side.
+func isColumnBlock(block []pdfLine, gutter float64) bool {
+	if len(block) < 3 {
+		return false
+	}
+	both, simple := 0, 0
+	for _, line := range block {
+		l, r := splitLine(line, gutter)
+		if len(l.spans) > 0 && len(r.spans) > 0 {
+			both++
+		}
+		if len(l.spans) <= 1 && len(r.spans) <= 1 {
+			simple++
+		}
+	}
+	return both*2 >= len(block) && simple*5 >= len(block)*4
+}
+
+// groupLines clusters text runs by baseline and merges neighbouring runs on
+// each line into spans, inserting spaces between words.
+func groupLines(texts []pdf.Text) []pdfLine {
+	slices.SortStableFunc(texts, func(a, b pdf.Text) int {
+		if c := cmp.Compare(b.Y, a.Y); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.X, b.X)
+	})
+	var lines []pdfLine
+	var runs []pdf.Text
+	flush := func() {
+		if len(runs) == 0 {
+			return
+		}
+		slices.SortStableFunc(runs, func(a, b pdf.Text) int { return cmp.Compare(a.X, b.X) })
+		line := pdfLine{y: runs[0].Y, size: max(runs[0].FontSize, 1)}
+		var span *pdfSpan
+		for _, t := range runs {
+			size := max(t.FontSize, 1)
+			gap := math.Inf(1)
+			if span != nil {
+				gap = t.X - span.x1
+			}
+			switch {
+			case gap > 2.5*size:
+				line.spans = append(line.spans, pdfSpan{x0: t.X, x1: t.X + t.W, text: t.S})
+				span = &line.spans[len(line.spans)-1]
+				continue
+			case gap > 0.2*size && !strings.HasSuffix(span.text, " ") && !strings.HasPrefix(t.S, " "):
+				span.text += " "
+			}
+			span.text += t.S
+			span.x1 = max(span.x1, t.X+t.W)
+		}
+		lines = append(lines, line)
+		runs = runs[:0]
+	}
+	for _, t := range texts {
+		if len(runs) > 0 && math.Abs(runs[0].Y-t.Y) > 0.5*max(t.FontSize, 2) {
+			flush()
+		}
+		runs = append(runs, t)
+	}
+	flush()
+	return lines
+}
+
+// findGutter looks for the widest vertical strip in the middle of the page
+// that almost no span crosses.
+func findGutter(lines []pdfLine, width float64) (float64, bool) {
+	if len(lines) < 6 || width <= 0 {
+		return 0, false
+	}
+	bins := int(width)
+	crossing := make([]int, bins+1)
+	for _, l := range lines {
+		for _, s := range l.spans {
+			for x := max(int(s.x0), 0); x <= min(int(s.x1), bins); x++ {
+				crossing[x]++
+			}
+		}
+	}
+	allowed := max(1, len(lines)/10)
+	bestStart, bestLen := 0, 0
+	lo, hi := int(0.3*width), int(0.7*width)
+	for start := lo; start < hi; {
+		if crossing[start] > allowed {
+			start++
+			continue
+		}
+		end := start
+		for end < hi && crossing[end] <= allowed {
+			end++
+		}
+		if end-start > bestLen {
+			bestStart, bestLen = start, end-start
+		}
+		start = end
+	}
+	if bestLen < 8 {
+		return 0, false
+	}
+	return float64(bestStart) + float64(bestLen)/2, true
+}
+
+func joinSpans(spans []pdfSpan) string {
+	texts := make([]string, len(spans))
+	for i, s := range spans {
+		texts[i] = strings.TrimSpace(s.text)
+	}
+	return strings.Join(texts, " | ")
+}
@@ -0,0 +1,218 @@
+package rag
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// PPTXLoader extracts the text of PowerPoint presentations, producing one
+// section per slide with its number in "slide" metadata. Slide titles
+// become Markdown headings, tables are flattened with " | " between cells,
+// and speaker notes follow the slide's text.
+type PPTXLoader struct{}
+
+func (PPTXLoader) Load(ctx context.Context, name string, r io.Reader) (*Document, error) {
+	zr, err := openZip(r)
+	if err != nil {
+		return nil, fmt.Errorf("pptx %s: %w", name, err)
+	}
+	slides, err := pptxSlides(zr)
+	if err != nil {
+		return nil, fmt.Errorf("pptx %s: %w", name, err)
+	}
+	doc := &Document{ID: name, Metadata: Metadata{"source": name}}
+	for i, slide := range slides {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		text, err := pptxSlideText(zr, slide)
+		if err != nil {
+			return nil, fmt.Errorf("pptx %s slide %d: %w", name, i+1, err)
+		}
+		if text == "" {
+			continue
+		}
+		doc.Sections = append(doc.Sections, Section{
+			Text:     text,
+			Metadata: Metadata{"slide": strconv.Itoa(i + 1)},
+		})
+	}
+	return doc, nil
+}
+
+// pptxSlides returns the part names of the slides in presentation order.
+func pptxSlides(zr *zip.Reader) ([]string, error) {
+	const presentation = "ppt/presentation.xml"
+	data, err := readPart(zr, presentation)
+	if err == nil && data == nil {
+		err = errors.New("no " + presentation)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var pres struct {
+		Slides []struct {
+			RelID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sldIdLst>sldId"`
+	}
+	if err := xml.Unmarshal(data, &pres); err != nil {
+		return nil, fmt.Errorf("%s: %w", presentation, err)
+	}
+	rels, err := readRels(zr, presentation)
+	if err != nil {
+		return nil, err
+	}
+	slides := make([]string, 0, len(pres.Slides))
+	for _, s := range pres.Slides {
+		if target, ok := rels[s.RelID]; ok {
+			slides = append(slides, target)
+		}
+	}
+	return slides, nil
+}
+
+// pptxSlideText returns the text of a slide followed by its notes.
+func pptxSlideText(zr *zip.Reader, slide string) (string, error) {
+	data, err := readPart(zr, slide)
+	if err != nil || data == nil {
+		return "", err
+	}
+	text, err := pptxShapesText(data, false)
+	if err != nil {
+		return "", err
+	}
+	rels, err := readRels(zr, slide)
+	if err != nil {
+		return "", err
+	}
+	for _, target := range rels {
+		if !strings.Contains(target, "notesSlides/") {
+			continue
+		}
+		data, err := readPart(zr, target)
+		if err != nil {
+			return "", err
+		}
+		if data == nil {
+			continue
+		}
+		notes, err := pptxShapesText(data, true)
+		if err != nil {
+			return "", fmt.Errorf("notes: %w", err)
+		}
+		if notes != "" {
+			text = strings.TrimSpace(text + "\n\nSpeaker notes:\n" + notes)
+		}
+	}
+	return text, nil
+}
+
+// pptxShapesText returns the text of the shapes and tables on a slide in
+// document order. Placeholders for slide numbers, dates and footers are
+// skipped; in notes, so is everything but the notes body.
+func pptxShapesText(data []byte, notes bool) (string, error) {
+	var (
+		blocks   []string
+		shape    []string // paragraphs of the current shape
+		para     strings.Builder
+		inText   bool
+		skip     bool // current shape is skipped
+		title    bool // current shape is the slide title
+		tblDepth int
+		rows     [][]string
+		cell     []string
+	)
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "sp":
+				shape, skip, title = nil, false, false
+			case "ph":
+				switch typ := xmlAttr(t, "type"); {
+				case typ == "sldNum" || typ == "dt" || typ == "ftr" || typ == "hdr" || typ == "sldImg":
+					skip = true
+				case notes && typ != "body":
+					skip = true
+				case typ == "title" || typ == "ctrTitle":
+					title = true
+				}
+			case "p":
+				para.Reset()
+			case "t":
+				inText = true
+			case "br":
+				para.WriteString("\n")
+			case "tbl":
+				tblDepth++
+				if tblDepth == 1 {
+					rows = nil
+				}
+			case "tr":
+				if tblDepth == 1 {
+					rows = append(rows, nil)
+				}
+			case "tc":
+				if tblDepth == 1 {
+					cell = nil
+				}
+			}
+		case xml.CharData:
+			if inText {
+				para.Write(t)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				text := cleanSpaces(para.String())
+				if text == "" {
+					continue
+				}
+				if tblDepth > 0 {
+					cell = append(cell, strings.ReplaceAll(text, "\n", " "))
+				} else {
+					shape = append(shape, text)
+				}
+			case "sp":
+				if skip || len(shape) == 0 {
+					continue
+				}
+				text := strings.Join(shape, "\n")
+				if title && !notes {
+					text = "# " + strings.ReplaceAll(text, "\n", " ")
+				}
+				blocks = append(blocks, text)
+				shape = nil
+			case "tc":
+				if tblDepth == 1 && len(rows) > 0 {
+					rows[len(rows)-1] = append(rows[len(rows)-1], strings.Join(cell, " "))
+				}
+			case "tbl":
+				tblDepth--
+				if tblDepth == 0 {
+					if text := tableText(rows); text != "" {
+						blocks = append(blocks, text)
+					}
+				}
+			}
+		}
+	}
+	return strings.Join(blocks, "\n\n"), nil
+}
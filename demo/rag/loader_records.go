@@ -0,0 +1,172 @@
+package rag
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// RecordLoader loads structured data: CSV files, whose first row names the
+// columns, and JSONL files of one JSON object per line. Every record becomes
+// a section of its own, with its number, counting from 1, in "row" metadata.
+// Template turns a record into the text of its section, accessing fields by
+// column name or key as in "Product {{.name}} costs {{.price}}"; without
+// one, records are written as "column: value" lines, in column order for
+// CSV and in key order for JSONL.
+type RecordLoader struct {
+	Template *template.Template
+}
+
+// ParseRowTemplate parses a text/template for RecordLoader. Records missing
+// a field the template refers to fail to load; {{index . "field"}} renders
+// optional fields as empty instead.
+func ParseRowTemplate(text string) (*template.Template, error) {
+	return template.New("row").Option("missingkey=error").Parse(text)
+}
+
+func (l RecordLoader) Load(ctx context.Context, name string, r io.Reader) (*Document, error) {
+	doc := &Document{ID: name, Metadata: Metadata{"source": name}}
+	err := l.records(ctx, name, r, func(s Section) error {
+		doc.Sections = append(doc.Sections, s)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// records passes the section of every record of the file name to section.
+func (l RecordLoader) records(ctx context.Context, name string, r io.Reader, section func(Section) error) error {
+	add := func(row int, record any, lines func() string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		text := ""
+		if l.Template != nil {
+			var b strings.Builder
+			if err := l.Template.Execute(&b, record); err != nil {
+				return fmt.Errorf("record %s row %d: %w", name, row, err)
+			}
+			text = b.String()
+		} else {
+			text = lines()
+		}
+		if text = strings.TrimSpace(text); text == "" {
+			return nil
+		}
+		return section(Section{Text: text, Metadata: Metadata{"row": strconv.Itoa(row)}})
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jsonl", ".ndjson":
+		return loadJSONL(name, r, add)
+	default:
+		return loadCSV(name, r, add)
+	}
+}
+
+// loadCSV passes every row after the header to add as a map from column
+// names to values.
+func loadCSV(name string, r io.Reader, add func(int, any, func() string) error) error {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("csv %s: %w", name, err)
+	}
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+	for row := 1; ; row++ {
+		values, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("csv %s: %w", name, err)
+		}
+		record := make(map[string]string, len(header))
+		for i, column := range header {
+			record[column] = values[i]
+		}
+		lines := func() string {
+			var b strings.Builder
+			for i, column := range header {
+				if values[i] != "" {
+					fmt.Fprintf(&b, "%s: %s\n", column, values[i])
+				}
+			}
+			return b.String()
+		}
+		if err := add(row, record, lines); err != nil {
+			return err
+		}
+	}
+}
+
+// loadJSONL passes every non-empty line to add as the decoded JSON object.
+// Numbers are kept as written.
+func loadJSONL(name string, r io.Reader, add func(int, any, func() string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16<<20)
+	row := 0
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var record map[string]any
+		if err := dec.Decode(&record); err != nil {
+			return fmt.Errorf("jsonl %s line %d: %w", name, line, err)
+		}
+		row++
+		lines := func() string {
+			var b strings.Builder
+			for _, key := range slices.Sorted(maps.Keys(record)) {
+				if v := recordValue(record[key]); v != "" {
+					fmt.Fprintf(&b, "%s: %s\n", key, v)
+				}
+			}
+			return b.String()
+		}
+		if err := add(row, record, lines); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("jsonl %s: %w", name, err)
+	}
+	return nil
+}
+
+// recordValue formats a JSON value for a "key: value" line; nested arrays
+// and objects are written as JSON.
+func recordValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
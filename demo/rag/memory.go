@@ -0,0 +1,128 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// DefaultMemoryWindow is how many messages are kept verbatim per session.
+const DefaultMemoryWindow = 6
+
+// Conversation is the remembered state of a chat session: a summary of
+// older turns and the most recent messages verbatim.
+type Conversation struct {
+	Summary  string    `json:"summary,omitempty"`
+	Messages []Message `json:"messages,omitempty"`
+}
+
+// A ConversationStore persists conversations by session ID. Get returns an
+// empty Conversation for unknown sessions.
+type ConversationStore interface {
+	Get(ctx context.Context, sessionID string) (*Conversation, error)
+	Save(ctx context.Context, sessionID string, c *Conversation) error
+	Delete(ctx context.Context, sessionID string) error
+}
+
+// MemoryConversationStore keeps conversations in memory.
+type MemoryConversationStore struct {
+	mu       sync.Mutex
+	sessions map[string]Conversation
+}
+
+// NewMemoryConversationStore creates an empty MemoryConversationStore.
+func NewMemoryConversationStore() *MemoryConversationStore {
+	return &MemoryConversationStore{sessions: make(map[string]Conversation)}
+}
+
+func (s *MemoryConversationStore) Get(ctx context.Context, sessionID string) (*Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.sessions[sessionID]
+	c.Messages = append([]Message(nil), c.Messages...)
+	return &c, nil
+}
+
+func (s *MemoryConversationStore) Save(ctx context.Context, sessionID string, c *Conversation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[sessionID] = Conversation{Summary: c.Summary, Messages: append([]Message(nil), c.Messages...)}
+	return nil
+}
+
+func (s *MemoryConversationStore) Delete(ctx context.Context, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, sessionID)
+	return nil
+}
+
+const summaryPrompt = `You maintain a running summary of a conversation between a user and an assistant.
+Merge the new messages into the existing summary. Keep it brief, but keep any names, numbers and facts the user may refer back to.
+Reply with the updated summary only.`
+
+// ConversationMemory records the turns of each session, keeps the last
+// Window messages verbatim and folds older ones into a summary written by
+// LLM.
+type ConversationMemory struct {
+	Store  ConversationStore
+	LLM    LLM
+	Window int // DefaultMemoryWindow if zero
+
+	locks sync.Map // session ID -> *sync.Mutex
+}
+
+// Load returns the conversation so far for sessionID.
+func (m *ConversationMemory) Load(ctx context.Context, sessionID string) (*Conversation, error) {
+	return m.Store.Get(ctx, sessionID)
+}
+
+// Append records a question and its answer, summarizing messages that fall
+// out of the window.
+func (m *ConversationMemory) Append(ctx context.Context, sessionID, question, answer string) error {
+	lock, _ := m.locks.LoadOrStore(sessionID, new(sync.Mutex))
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	c, err := m.Store.Get(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	c.Messages = append(c.Messages,
+		Message{Role: RoleUser, Content: question},
+		Message{Role: RoleAssistant, Content: answer})
+	window := m.Window
+	if window <= 0 {
+		window = DefaultMemoryWindow
+	}
+	if overflow := len(c.Messages) - window; overflow > 0 {
+		summary, err := m.summarize(ctx, c.Summary, c.Messages[:overflow])
+		if err != nil {
+			return fmt.Errorf("summarizing conversation: %w", err)
+		}
+		c.Summary = summary
+		c.Messages = c.Messages[overflow:]
+	}
+	return m.Store.Save(ctx, sessionID, c)
+}
+
+// Reset forgets the conversation for sessionID.
+func (m *ConversationMemory) Reset(ctx context.Context, sessionID string) error {
+	return m.Store.Delete(ctx, sessionID)
+}
+
+func (m *ConversationMemory) summarize(ctx context.Context, summary string, messages []Message) (string, error) {
+	var prompt strings.Builder
+	if summary != "" {
+		fmt.Fprintf(&prompt, "Summary so far:\n%s\n\n", summary)
+	}
+	prompt.WriteString("New messages:\n")
+	for _, msg := range messages {
+		fmt.Fprintf(&prompt, "%s: %s\n", msg.Role, msg.Content)
+	}
+	return m.LLM.Generate(ctx, []Message{
+		{Role: RoleSystem, Content: summaryPrompt},
+		{Role: RoleUser, Content: prompt.String()},
+	})
+}
@@ -0,0 +1,206 @@
+package rag
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Metrics are registered with the default Prometheus registry and served
+// by NewHandler at /metrics.
+var (
+	ingestedDocuments = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rag_ingested_documents_total",
+		Help: "Documents ingested, by result (ok or error).",
+	}, []string{"result"})
+	ingestedChunks = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "rag_ingested_chunks_total",
+		Help: "Chunks stored by ingestion, including unchanged ones.",
+	})
+	embeddedChunks = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "rag_ingest_embedded_chunks_total",
+		Help: "Chunks that ingestion had to embed because they were new or changed.",
+	})
+	embeddingDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "rag_embedding_duration_seconds",
+		Help:    "Latency of requests to the embedding provider.",
+		Buckets: prometheus.DefBuckets,
+	})
+	embeddedTexts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "rag_embedded_texts_total",
+		Help: "Texts embedded by the embedding provider, excluding cache hits.",
+	})
+	embeddingTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rag_embedding_tokens_total",
+		Help: "Tokens embedded by the embedding provider, by model.",
+	}, []string{"model"})
+	retrievalDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "rag_retrieval_duration_seconds",
+		Help:    "Latency of retrieving the context for a question.",
+		Buckets: prometheus.DefBuckets,
+	})
+	llmDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rag_llm_duration_seconds",
+		Help:    "Latency of LLM requests, by operation (generate or stream).",
+		Buckets: []float64{.1, .25, .5, 1, 2.5, 5, 10, 20, 40, 80},
+	}, []string{"operation"})
+	llmTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rag_llm_tokens_total",
+		Help: "Tokens used by LLM requests, by model and type (prompt or completion).",
+	}, []string{"model", "type"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rag_http_request_duration_seconds",
+		Help:    "End-to-end latency of HTTP requests, by route, method and status code.",
+		Buckets: []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 20, 40, 80},
+	}, []string{"handler", "method", "code"})
+	grpcDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rag_grpc_request_duration_seconds",
+		Help:    "End-to-end latency of gRPC calls, by method and status code.",
+		Buckets: []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 20, 40, 80},
+	}, []string{"method", "code"})
+)
+
+// recordIngest counts the outcome of an ingestion.
+func recordIngest(results []IngestResult) {
+	for _, r := range results {
+		if r.Error != "" {
+			ingestedDocuments.WithLabelValues("error").Inc()
+			continue
+		}
+		ingestedDocuments.WithLabelValues("ok").Inc()
+		ingestedChunks.Add(float64(r.Chunks))
+		embeddedChunks.Add(float64(r.Embedded))
+	}
+}
+
+// instrumentedEmbedder records the latency and token usage of an Embedder
+// and marks its errors as ErrEmbeddingProvider.
+type instrumentedEmbedder struct {
+	Embedder
+}
+
+func (e instrumentedEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	start := time.Now()
+	vectors, err := e.Embedder.Embed(WithUsageFunc(ctx, countEmbeddingTokens), texts)
+	embeddingDuration.Observe(time.Since(start).Seconds())
+	if err == nil {
+		embeddedTexts.Add(float64(len(texts)))
+	}
+	return vectors, providerError(ErrEmbeddingProvider, err)
+}
+
+// instrumentedRetriever records the latency of a Retriever.
+type instrumentedRetriever struct {
+	Retriever
+}
+
+func (r instrumentedRetriever) Retrieve(ctx context.Context, query string, k int, filter Filter) ([]SearchResult, error) {
+	start := time.Now()
+	defer func() { retrievalDuration.Observe(time.Since(start).Seconds()) }()
+	return r.Retriever.Retrieve(ctx, query, k, filter)
+}
+
+// instrumentedLLM records the latency and token usage of an LLM and marks
+// its errors as ErrLLMProvider. It is a StructuredLLM whether or not the
+// wrapped LLM is; if it is not, GenerateJSON falls back to Generate. It is a
+// ToolLLM too, but GenerateWithTools fails if the wrapped LLM is not one.
+type instrumentedLLM struct {
+	LLM
+}
+
+func (l instrumentedLLM) Generate(ctx context.Context, messages []Message) (string, error) {
+	defer observeLLM("generate", time.Now())
+	reply, err := l.LLM.Generate(WithUsageFunc(ctx, countTokens), messages)
+	return reply, providerError(ErrLLMProvider, err)
+}
+
+func (l instrumentedLLM) GenerateJSON(ctx context.Context, messages []Message, name string, schema json.RawMessage) (string, error) {
+	defer observeLLM("generate", time.Now())
+	ctx = WithUsageFunc(ctx, countTokens)
+	var reply string
+	var err error
+	if s, ok := l.LLM.(StructuredLLM); ok {
+		reply, err = s.GenerateJSON(ctx, messages, name, schema)
+	} else {
+		reply, err = l.LLM.Generate(ctx, messages)
+	}
+	return reply, providerError(ErrLLMProvider, err)
+}
+
+func (l instrumentedLLM) GenerateWithTools(ctx context.Context, messages []Message, tools []Tool) (Message, error) {
+	defer observeLLM("generate", time.Now())
+	t, ok := l.LLM.(ToolLLM)
+	if !ok {
+		return Message{}, fmt.Errorf("calling tools is %w by the llm", ErrNotSupported)
+	}
+	reply, err := t.GenerateWithTools(WithUsageFunc(ctx, countTokens), messages, tools)
+	return reply, providerError(ErrLLMProvider, err)
+}
+
+func (l instrumentedLLM) Stream(ctx context.Context, messages []Message, onDelta func(string) error) error {
+	defer observeLLM("stream", time.Now())
+	// The errors of onDelta are those of the caller, not of the provider
+	var deltaErr error
+	err := l.LLM.Stream(WithUsageFunc(ctx, countTokens), messages, func(delta string) error {
+		deltaErr = onDelta(delta)
+		return deltaErr
+	})
+	if deltaErr != nil {
+		return err
+	}
+	return providerError(ErrLLMProvider, err)
+}
+
+func observeLLM(operation string, start time.Time) {
+	llmDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+func countTokens(u Usage) {
+	llmTokens.WithLabelValues(u.Model, "prompt").Add(float64(u.PromptTokens))
+	llmTokens.WithLabelValues(u.Model, "completion").Add(float64(u.CompletionTokens))
+}
+
+func countEmbeddingTokens(u Usage) {
+	embeddingTokens.WithLabelValues(u.Model).Add(float64(u.EmbeddingTokens))
+}
+
+// instrumentHandler records the latency of requests to the route pattern
+// and traces them.
+func instrumentHandler(pattern string, h http.Handler) http.Handler {
+	h = promhttp.InstrumentHandlerDuration(httpDuration.MustCurryWith(prometheus.Labels{"handler": pattern}), h)
+	return otelhttp.NewHandler(h, pattern)
+}
+
+// GRPCServerOptions returns the options instrumenting a gRPC server serving
+// RegisterGRPC with the latency of each call and tracing the calls.
+func GRPCServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			start := time.Now()
+			resp, err := handler(ctx, req)
+			observeGRPC(info.FullMethod, start, err)
+			return resp, err
+		}),
+		grpc.ChainStreamInterceptor(func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			start := time.Now()
+			err := handler(srv, ss)
+			observeGRPC(info.FullMethod, start, err)
+			return err
+		}),
+	}
+}
+
+func observeGRPC(method string, start time.Time, err error) {
+	grpcDuration.WithLabelValues(method, status.Code(err).String()).Observe(time.Since(start).Seconds())
+}
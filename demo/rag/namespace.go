@@ -0,0 +1,114 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// DefaultNamespace holds the chunks of requests that name no namespace. It
+// always exists and cannot be deleted.
+const DefaultNamespace = "default"
+
+var (
+	// ErrNamespaceNotFound is returned by stores for operations on a
+	// namespace that was not created.
+	ErrNamespaceNotFound = errors.New("namespace not found")
+	// ErrNamespaceExists is returned when creating a namespace twice.
+	ErrNamespaceExists = errors.New("namespace already exists")
+)
+
+// namespacePattern restricts names to what every store can use as a key.
+var namespacePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// NamespaceInfo summarizes a namespace held in a VectorStore.
+type NamespaceInfo struct {
+	Name   string `json:"name"`
+	Chunks int    `json:"chunks"`
+}
+
+type namespaceKey struct{}
+
+// WithNamespace returns a context that makes the pipeline and its stores act
+// on the given namespace only, so that several isolated corpora can share
+// one instance. An empty name selects DefaultNamespace.
+func WithNamespace(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, namespaceKey{}, name)
+}
+
+// NamespaceFrom returns the namespace set on ctx by WithNamespace, or
+// DefaultNamespace.
+func NamespaceFrom(ctx context.Context) string {
+	if name, ok := ctx.Value(namespaceKey{}).(string); ok && name != "" {
+		return name
+	}
+	return DefaultNamespace
+}
+
+// ValidateNamespace reports whether name can be used as a namespace: 1 to 63
+// lower-case letters, digits, '-' and '_', starting with a letter or digit.
+func ValidateNamespace(name string) error {
+	if !namespacePattern.MatchString(name) {
+		return fmt.Errorf("invalid namespace %q: use 1 to 63 lower-case letters, digits, '-' and '_'", name)
+	}
+	return nil
+}
+
+// checkNamespaceChange is called by stores before creating or deleting a
+// namespace. It rejects DefaultNamespace and malformed names.
+func checkNamespaceChange(name string) error {
+	if name == DefaultNamespace {
+		return fmt.Errorf("the %s namespace cannot be created or deleted", DefaultNamespace)
+	}
+	return ValidateNamespace(name)
+}
+
+// CreateNamespace creates an empty namespace.
+func (p *Pipeline) CreateNamespace(ctx context.Context, name string) error {
+	return p.Store.CreateNamespace(ctx, name)
+}
+
+// DeleteNamespace deletes a namespace with all of its documents.
+func (p *Pipeline) DeleteNamespace(ctx context.Context, name string) error {
+	if err := p.Store.DeleteNamespace(ctx, name); err != nil {
+		return err
+	}
+	if p.Profiles != nil {
+		p.Profiles.forget(name)
+	}
+	if p.Keywords != nil {
+		p.Keywords.DeleteNamespace(name)
+	}
+	if p.Dedup != nil {
+		p.Dedup.DeleteNamespace(name)
+	}
+	if p.Feedback != nil {
+		if err := p.Feedback.deleteNamespace(ctx, name); err != nil {
+			return err
+		}
+	}
+	if p.Graph != nil {
+		if err := p.Graph.deleteNamespace(ctx, name); err != nil {
+			return err
+		}
+	}
+	if p.Answers != nil {
+		return p.Answers.invalidateNamespace(ctx, name)
+	}
+	return nil
+}
+
+// Namespaces lists the namespaces ordered by name.
+func (p *Pipeline) Namespaces(ctx context.Context) ([]NamespaceInfo, error) {
+	return p.Store.Namespaces(ctx)
+}
+
+// sessionKey scopes a session ID to the namespace of ctx, so that sessions
+// of different namespaces never share a conversation.
+func sessionKey(ctx context.Context, sessionID string) string {
+	if ns := NamespaceFrom(ctx); ns != DefaultNamespace {
+		return ns + "/" + sessionID
+	}
+	return sessionID
+}
@@ -0,0 +1,329 @@
+package rag
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultNotionURL is the Notion API a Notion source without BaseURL uses.
+const DefaultNotionURL = "https://api.notion.com"
+
+// notionVersion is the version of the Notion API requests ask for.
+const notionVersion = "2022-06-28"
+
+// Notion reads the pages of a Notion database through the Notion API,
+// authenticating with the token of an integration the database is shared
+// with.
+type Notion struct {
+	Client   *http.Client
+	BaseURL  string // DefaultNotionURL if empty
+	Token    string
+	Database string // the database ID
+}
+
+// URL returns the URL of the database, notion://database-id/.
+func (n *Notion) URL() string {
+	return "notion://" + n.Database + "/"
+}
+
+// notionText is rich text as the Notion API returns it.
+type notionText []struct {
+	PlainText string `json:"plain_text"`
+}
+
+func (t notionText) String() string {
+	var b strings.Builder
+	for _, part := range t {
+		b.WriteString(part.PlainText)
+	}
+	return b.String()
+}
+
+// Pages lists the pages of the database, following the API's pages of
+// results. Page versions are the times the pages were last edited, which
+// Notion rounds to the minute. The database's properties other than the
+// title are recorded as metadata, named in lower case with words joined
+// by underscores, when they are text, numbers, selections, dates,
+// checkboxes, URLs or email addresses.
+func (n *Notion) Pages(ctx context.Context, fn func(Page) error) error {
+	cursor := ""
+	for {
+		query := map[string]any{"page_size": 100}
+		if cursor != "" {
+			query["start_cursor"] = cursor
+		}
+		var resp struct {
+			Results []struct {
+				ID             string                     `json:"id"`
+				URL            string                     `json:"url"`
+				LastEditedTime time.Time                  `json:"last_edited_time"`
+				Archived       bool                       `json:"archived"`
+				Properties     map[string]json.RawMessage `json:"properties"`
+			} `json:"results"`
+			HasMore    bool   `json:"has_more"`
+			NextCursor string `json:"next_cursor"`
+		}
+		if err := n.do(ctx, http.MethodPost, "/v1/databases/"+url.PathEscape(n.Database)+"/query", query, &resp); err != nil {
+			return err
+		}
+		for _, result := range resp.Results {
+			if result.Archived {
+				continue
+			}
+			page := Page{
+				ID:         n.URL() + result.ID,
+				URL:        result.URL,
+				LastEdited: result.LastEditedTime,
+				Version:    result.LastEditedTime.UTC().Format(time.RFC3339),
+				Metadata:   Metadata{},
+			}
+			for name, raw := range result.Properties {
+				title, value, ok := notionProperty(raw)
+				switch {
+				case title:
+					page.Title = value
+				case ok:
+					page.Metadata[metadataName(name)] = value
+				}
+			}
+			if err := fn(page); err != nil {
+				return err
+			}
+		}
+		if !resp.HasMore || resp.NextCursor == "" {
+			return nil
+		}
+		cursor = resp.NextCursor
+	}
+}
+
+// metadataName returns the metadata key for a property name.
+func metadataName(name string) string {
+	return strings.Trim(nonName.ReplaceAllString(strings.ToLower(name), "_"), "_")
+}
+
+// notionProperty returns the value of a database property as text,
+// reporting whether it is the page's title and whether it has a value of
+// a type kept as metadata.
+func notionProperty(raw json.RawMessage) (title bool, value string, ok bool) {
+	var p struct {
+		Type     string     `json:"type"`
+		Title    notionText `json:"title"`
+		RichText notionText `json:"rich_text"`
+		Number   *float64   `json:"number"`
+		Checkbox bool       `json:"checkbox"`
+		URL      string     `json:"url"`
+		Email    string     `json:"email"`
+		Select   *struct {
+			Name string `json:"name"`
+		} `json:"select"`
+		Status *struct {
+			Name string `json:"name"`
+		} `json:"status"`
+		MultiSelect []struct {
+			Name string `json:"name"`
+		} `json:"multi_select"`
+		Date *struct {
+			Start string `json:"start"`
+		} `json:"date"`
+	}
+	if json.Unmarshal(raw, &p) != nil {
+		return false, "", false
+	}
+	switch p.Type {
+	case "title":
+		return true, p.Title.String(), false
+	case "rich_text":
+		value = p.RichText.String()
+	case "number":
+		if p.Number != nil {
+			value = strconv.FormatFloat(*p.Number, 'f', -1, 64)
+		}
+	case "checkbox":
+		value = strconv.FormatBool(p.Checkbox)
+	case "url":
+		value = p.URL
+	case "email":
+		value = p.Email
+	case "select":
+		if p.Select != nil {
+			value = p.Select.Name
+		}
+	case "status":
+		if p.Status != nil {
+			value = p.Status.Name
+		}
+	case "multi_select":
+		var names []string
+		for _, option := range p.MultiSelect {
+			names = append(names, option.Name)
+		}
+		value = strings.Join(names, ",")
+	case "date":
+		if p.Date != nil {
+			value = p.Date.Start
+		}
+	}
+	return false, value, value != ""
+}
+
+// maxNotionDepth bounds how deeply Load follows nested blocks.
+const maxNotionDepth = 8
+
+// Load fetches the blocks of a page, with the blocks nested in them, and
+// converts them to text: headings become Markdown headings so the
+// splitter can cut on them, list items and to-dos are marked like Markdown
+// lists and quotes and callouts are quoted. Child pages, databases, media
+// and embeds are left out.
+func (n *Notion) Load(ctx context.Context, page Page) (*Document, error) {
+	var b strings.Builder
+	if err := n.writeBlocks(ctx, &b, strings.TrimPrefix(page.ID, n.URL()), 0); err != nil {
+		return nil, err
+	}
+	var sections []Section
+	if text := strings.TrimSpace(b.String()); text != "" {
+		sections = []Section{{Text: text}}
+	}
+	return pageDocument(page, sections), nil
+}
+
+// notionBlock is a block as the Notion API returns it; the content of a
+// block of each type is kept under that type's name.
+type notionBlock struct {
+	ID          string                     `json:"id"`
+	Type        string                     `json:"type"`
+	HasChildren bool                       `json:"has_children"`
+	Content     map[string]json.RawMessage `json:"-"`
+}
+
+func (b *notionBlock) UnmarshalJSON(data []byte) error {
+	type plain notionBlock
+	if err := json.Unmarshal(data, (*plain)(b)); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &b.Content)
+}
+
+// writeBlocks appends the text of the child blocks of the block or page
+// with the given ID, indenting blocks nested in list items by depth.
+func (n *Notion) writeBlocks(ctx context.Context, b *strings.Builder, id string, depth int) error {
+	if depth > maxNotionDepth {
+		return nil
+	}
+	cursor := ""
+	for number := 1; ; {
+		path := "/v1/blocks/" + url.PathEscape(id) + "/children?page_size=100"
+		if cursor != "" {
+			path += "&start_cursor=" + url.QueryEscape(cursor)
+		}
+		var resp struct {
+			Results    []notionBlock `json:"results"`
+			HasMore    bool          `json:"has_more"`
+			NextCursor string        `json:"next_cursor"`
+		}
+		if err := n.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+			return err
+		}
+		for _, block := range resp.Results {
+			var content struct {
+				RichText notionText   `json:"rich_text"`
+				Checked  bool         `json:"checked"`
+				Language string       `json:"language"`
+				Cells    []notionText `json:"cells"`
+			}
+			json.Unmarshal(block.Content[block.Type], &content)
+			text := content.RichText.String()
+			indent := strings.Repeat("  ", depth)
+			if block.Type != "numbered_list_item" {
+				number = 1
+			}
+			nested := depth
+			switch block.Type {
+			case "paragraph", "toggle":
+				writeParagraph(b, indent, text)
+			case "heading_1", "heading_2", "heading_3":
+				writeParagraph(b, "", strings.Repeat("#", int(block.Type[len(block.Type)-1]-'0'))+" "+text)
+			case "bulleted_list_item":
+				fmt.Fprintf(b, "%s- %s\n", indent, text)
+				nested++
+			case "numbered_list_item":
+				fmt.Fprintf(b, "%s%d. %s\n", indent, number, text)
+				number++
+				nested++
+			case "to_do":
+				mark := " "
+				if content.Checked {
+					mark = "x"
+				}
+				fmt.Fprintf(b, "%s- [%s] %s\n", indent, mark, text)
+				nested++
+			case "quote", "callout":
+				writeParagraph(b, indent, "> "+text)
+			case "code":
+				writeParagraph(b, "", "```"+content.Language+"\n"+text+"\n```")
+			case "table_row":
+				var cells []string
+				for _, cell := range content.Cells {
+					cells = append(cells, cell.String())
+				}
+				fmt.Fprintf(b, "%s| %s |\n", indent, strings.Join(cells, " | "))
+			case "child_page", "child_database":
+				continue
+			}
+			if block.HasChildren {
+				if err := n.writeBlocks(ctx, b, block.ID, nested); err != nil {
+					return err
+				}
+			}
+		}
+		if !resp.HasMore || resp.NextCursor == "" {
+			return nil
+		}
+		cursor = resp.NextCursor
+	}
+}
+
+// writeParagraph appends text as a paragraph of its own, unless it is
+// empty.
+func writeParagraph(b *strings.Builder, indent, text string) {
+	if strings.TrimSpace(strings.TrimLeft(text, "#>")) == "" {
+		return
+	}
+	if s := b.String(); s != "" && !strings.HasSuffix(s, "\n\n") {
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(b, "%s%s\n\n", indent, text)
+}
+
+// do sends a request with the JSON encoding of body, if not nil, to path
+// under the base URL and decodes the JSON response into v.
+func (n *Notion) do(ctx context.Context, method, path string, body, v any) error {
+	base := n.BaseURL
+	if base == "" {
+		base = DefaultNotionURL
+	}
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(base, "/")+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+n.Token)
+	req.Header.Set("Notion-Version", notionVersion)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return doJSON(n.Client, req, v)
+}
@@ -0,0 +1,165 @@
+package rag
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultOCRMinChars is the number of characters of text below which
+// PDFLoader takes a page to be scanned.
+const DefaultOCRMinChars = 20
+
+// An OCR recognizes the text of scanned PDF pages. pdf holds the whole
+// file and pages the 1-based numbers of the pages to read; the results are
+// in the same order.
+type OCR interface {
+	Recognize(ctx context.Context, pdf []byte, pages []int) ([]OCRPage, error)
+}
+
+// OCRPage is the text recognized on a page. Confidence is the mean
+// confidence of its words, from 0 to 1.
+type OCRPage struct {
+	Text       string
+	Confidence float64
+}
+
+// NewOCR returns the OCR selected by cfg.OCR: off or tesseract. It returns
+// nil if OCR is off.
+func NewOCR(cfg Config) (OCR, error) {
+	switch cfg.OCR {
+	case "", "off":
+		return nil, nil
+	case "tesseract":
+		return NewTesseractOCR(cfg.OCRLanguages)
+	}
+	return nil, fmt.Errorf("unknown ocr engine %q", cfg.OCR)
+}
+
+// TesseractOCR renders pages with pdftoppm, from poppler-utils, and reads
+// them with the tesseract command, which must both be installed.
+// Languages are tesseract language codes joined by "+", e.g. "eng+deu";
+// tesseract reads English if it is empty.
+type TesseractOCR struct {
+	Languages string
+	DPI       int // 300 if zero
+
+	pdftoppm, tesseract string
+}
+
+// NewTesseractOCR creates a TesseractOCR, failing if pdftoppm or tesseract
+// cannot be found on the PATH.
+func NewTesseractOCR(languages string) (*TesseractOCR, error) {
+	o := &TesseractOCR{Languages: languages}
+	var err error
+	if o.pdftoppm, err = exec.LookPath("pdftoppm"); err != nil {
+		return nil, fmt.Errorf("ocr: pdftoppm from poppler-utils is needed to render pages: %w", err)
+	}
+	if o.tesseract, err = exec.LookPath("tesseract"); err != nil {
+		return nil, fmt.Errorf("ocr: %w", err)
+	}
+	return o, nil
+}
+
+func (o *TesseractOCR) Recognize(ctx context.Context, pdf []byte, pages []int) (_ []OCRPage, err error) {
+	ctx, span := tracer.Start(ctx, "rag.ocr", trace.WithAttributes(attribute.Int("rag.pages", len(pages))))
+	defer func() { endSpan(span, err) }()
+	dir, err := os.MkdirTemp("", "rag-ocr-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "input.pdf")
+	if err := os.WriteFile(input, pdf, 0o600); err != nil {
+		return nil, err
+	}
+	dpi := o.DPI
+	if dpi <= 0 {
+		dpi = 300
+	}
+	results := make([]OCRPage, len(pages))
+	for i, page := range pages {
+		n := strconv.Itoa(page)
+		image := filepath.Join(dir, "page-"+n)
+		if _, err := o.run(ctx, o.pdftoppm, "-r", strconv.Itoa(dpi), "-f", n, "-l", n, "-gray", "-png", "-singlefile", input, image); err != nil {
+			return nil, fmt.Errorf("ocr: rendering page %d: %w", page, err)
+		}
+		args := []string{image + ".png", "stdout"}
+		if o.Languages != "" {
+			args = append(args, "-l", o.Languages)
+		}
+		tsv, err := o.run(ctx, o.tesseract, append(args, "tsv")...)
+		if err != nil {
+			return nil, fmt.Errorf("ocr: reading page %d: %w", page, err)
+		}
+		results[i] = parseTesseractTSV(tsv)
+	}
+	return results, nil
+}
+
+// run runs a command, returning its standard output and naming its
+// standard error in errors.
+func (o *TesseractOCR) run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// parseTesseractTSV reassembles the words of tesseract's TSV output into
+// lines, with blank lines between paragraphs, and averages their
+// confidence. Rows have the columns level, page_num, block_num, par_num,
+// line_num, word_num, left, top, width, height, conf and text; only words
+// have a confidence of 0 to 100, other rows have -1.
+func parseTesseractTSV(tsv []byte) OCRPage {
+	var text strings.Builder
+	var confidence float64
+	words := 0
+	lastPar, lastLine := "", ""
+	scanner := bufio.NewScanner(bytes.NewReader(tsv))
+	scanner.Buffer(nil, 1<<20)
+	for first := true; scanner.Scan(); first = false {
+		cols := strings.Split(scanner.Text(), "\t")
+		if first || len(cols) < 12 {
+			continue
+		}
+		conf, err := strconv.ParseFloat(cols[10], 64)
+		word := strings.TrimSpace(cols[11])
+		if err != nil || conf < 0 || word == "" {
+			continue
+		}
+		par, line := cols[2]+"."+cols[3], cols[2]+"."+cols[3]+"."+cols[4]
+		switch {
+		case words == 0:
+		case par != lastPar:
+			text.WriteString("\n\n")
+		case line != lastLine:
+			text.WriteString("\n")
+		default:
+			text.WriteString(" ")
+		}
+		text.WriteString(word)
+		lastPar, lastLine = par, line
+		confidence += conf / 100
+		words++
+	}
+	if words == 0 {
+		return OCRPage{}
+	}
+	return OCRPage{Text: text.String(), Confidence: confidence / float64(words)}
+}
@@ -0,0 +1,108 @@
+package rag
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// A PageSource reads the pages of a wiki or workspace, such as a Confluence
+// space or a Notion database, as documents.
+type PageSource interface {
+	// Pages calls fn with every page of the source, without its content,
+	// stopping at the first error from fn.
+	Pages(ctx context.Context, fn func(Page) error) error
+	// Load fetches the content of a page listed by Pages.
+	Load(ctx context.Context, page Page) (*Document, error)
+	// URL returns the URL of the source, e.g. confluence://ENG/, which
+	// starts the IDs of all of its documents.
+	URL() string
+}
+
+// Page describes a page listed by a PageSource. ID is the ID of its
+// document and URL the address it is read at in a browser. Version changes
+// whenever the page is edited, so that pages whose version was ingested
+// already need not be loaded again. Metadata is added to the metadata of
+// the page's document.
+type Page struct {
+	ID         string
+	Title      string
+	URL        string
+	LastEdited time.Time
+	Version    string
+	Metadata   Metadata
+}
+
+// OpenPageSource returns the PageSource for a URL of the form
+// confluence://SPACE, reading the pages of a Confluence space, or
+// notion://database-id, reading the pages of a Notion database, with the
+// servers and credentials taken from cfg.
+func OpenPageSource(rawURL string, cfg Config) (PageSource, error) {
+	scheme, rest, _ := strings.Cut(rawURL, "://")
+	scope := strings.Trim(rest, "/")
+	if scope == "" || strings.Contains(scope, "/") {
+		return nil, fmt.Errorf("page source URL %s must name a single space or database", rawURL)
+	}
+	switch scheme {
+	case "confluence":
+		if cfg.ConfluenceURL == "" {
+			return nil, fmt.Errorf("%s: set CONFLUENCE_URL", rawURL)
+		}
+		return &Confluence{
+			Client:  newProviderClient(cfg),
+			BaseURL: strings.TrimRight(cfg.ConfluenceURL, "/"),
+			User:    cfg.ConfluenceUser,
+			Token:   cfg.ConfluenceToken,
+			Space:   scope,
+		}, nil
+	case "notion":
+		if cfg.NotionToken == "" {
+			return nil, fmt.Errorf("%s: set NOTION_TOKEN", rawURL)
+		}
+		return &Notion{Client: newProviderClient(cfg), BaseURL: cfg.NotionURL, Token: cfg.NotionToken, Database: scope}, nil
+	}
+	return nil, fmt.Errorf("unsupported page source URL %s: use confluence:// or notion://", rawURL)
+}
+
+// IsPageSourceURL reports whether s is a URL OpenPageSource accepts.
+func IsPageSourceURL(s string) bool {
+	return strings.HasPrefix(s, "confluence://") || strings.HasPrefix(s, "notion://")
+}
+
+// pageDocument returns the document of page with the given sections and
+// the page's metadata, its title and URL, which is also its "source", and
+// when it was last edited, in RFC 3339 format, in "last_modified".
+func pageDocument(page Page, sections []Section) *Document {
+	doc := &Document{ID: page.ID, Sections: sections, Metadata: Metadata{"source": page.URL, "url": page.URL}}
+	if page.Title != "" {
+		doc.Metadata["title"] = page.Title
+	}
+	if !page.LastEdited.IsZero() {
+		doc.Metadata["last_modified"] = page.LastEdited.UTC().Format(time.RFC3339)
+	}
+	doc.Metadata = doc.Metadata.merge(page.Metadata)
+	return doc
+}
+
+// doJSON sends req with client and decodes the JSON response into v,
+// returning the error message of the response if it failed.
+func doJSON(client *http.Client, req *http.Request, v any) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Redacted(), resp.Status, strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("%s %s: %w", req.Method, req.URL.Redacted(), err)
+	}
+	return nil
+}
@@ -0,0 +1,494 @@
+package rag
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math/big"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PIIMode selects where a PIIRedactor masks personal data.
+type PIIMode string
+
+const (
+	// PIIIngest masks personal data in documents as they are ingested, so
+	// that neither their chunks nor their stored sources hold it.
+	PIIIngest PIIMode = "ingest"
+	// PIIAnswers masks personal data in generated answers.
+	PIIAnswers PIIMode = "answers"
+	// PIIBoth masks personal data in ingested documents and in answers.
+	PIIBoth PIIMode = "both"
+)
+
+// PIIKind names a kind of personal data. Besides the kinds PatternDetector
+// finds, a PresidioDetector reports the entities of its recognizers, such
+// as "person" and "location".
+type PIIKind string
+
+const (
+	PIIEmail      PIIKind = "email"
+	PIIPhone      PIIKind = "phone"
+	PIISSN        PIIKind = "ssn"
+	PIICreditCard PIIKind = "credit_card"
+	PIIIBAN       PIIKind = "iban"
+	PIIIP         PIIKind = "ip"
+)
+
+// PIISpan is personal data found at text[Start:End].
+type PIISpan struct {
+	Kind       PIIKind
+	Start, End int
+}
+
+// A PIIDetector finds personal data in text.
+type PIIDetector interface {
+	Detect(ctx context.Context, text string) ([]PIISpan, error)
+}
+
+// PatternDetector finds e-mail addresses, phone numbers, US social
+// security numbers, credit card numbers, IBANs and IPv4 addresses with
+// regular expressions. Card numbers and IBANs must have valid check digits.
+type PatternDetector struct{}
+
+// piiPattern matches personal data of a kind, which valid, if not nil,
+// must accept as well.
+type piiPattern struct {
+	kind  PIIKind
+	re    *regexp.Regexp
+	valid func(string) bool
+}
+
+var piiPatterns = []piiPattern{
+	{PIIEmail, regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`), nil},
+	{PIICreditCard, regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), validLuhn},
+	{PIISSN, regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`), validSSN},
+	{PIIIBAN, regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,3})?\b`), validIBAN},
+	{PIIIP, regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`), func(s string) bool { _, err := netip.ParseAddr(s); return err == nil }},
+	// International numbers, numbers with an area code in parentheses and
+	// numbers of three groups joined by dashes or dots
+	{PIIPhone, regexp.MustCompile(`\+\d{1,3}(?:[ .-]?\(?\d{1,4}\)?){2,5}|\(\d{2,4}\)[ .-]?\d{3,4}[ .-]?\d{3,4}|\b\d{2,4}[.-]\d{3,4}[.-]\d{3,4}\b`), validPhone},
+}
+
+func (PatternDetector) Detect(_ context.Context, text string) ([]PIISpan, error) {
+	var spans []PIISpan
+	for _, p := range piiPatterns {
+		for _, loc := range p.re.FindAllStringIndex(text, -1) {
+			if p.valid == nil || p.valid(text[loc[0]:loc[1]]) {
+				spans = append(spans, PIISpan{Kind: p.kind, Start: loc[0], End: loc[1]})
+			}
+		}
+	}
+	return spans, nil
+}
+
+// digits returns the decimal digits of s.
+func digits(s string) []byte {
+	var d []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] >= '0' && s[i] <= '9' {
+			d = append(d, s[i]-'0')
+		}
+	}
+	return d
+}
+
+// validLuhn reports whether the digits of s pass the Luhn check of card
+// numbers.
+func validLuhn(s string) bool {
+	d := digits(s)
+	sum := 0
+	for i := range d {
+		n := int(d[len(d)-1-i])
+		if i%2 == 1 {
+			if n *= 2; n > 9 {
+				n -= 9
+			}
+		}
+		sum += n
+	}
+	return sum%10 == 0
+}
+
+// validSSN reports whether s, of the form 123-45-6789, is a social
+// security number that can have been issued.
+func validSSN(s string) bool {
+	area, group, serial := s[:3], s[4:6], s[7:]
+	return area != "000" && area != "666" && area[0] != '9' && group != "00" && serial != "0000"
+}
+
+// validIBAN reports whether the check digits of an IBAN, with or without
+// spaces, are valid.
+func validIBAN(s string) bool {
+	s = strings.ReplaceAll(s, " ", "")
+	var n strings.Builder
+	for _, c := range s[4:] + s[:4] {
+		if c >= 'A' && c <= 'Z' {
+			fmt.Fprint(&n, int(c-'A'+10))
+		} else {
+			n.WriteRune(c)
+		}
+	}
+	v, ok := new(big.Int).SetString(n.String(), 10)
+	return ok && new(big.Int).Mod(v, big.NewInt(97)).Int64() == 1
+}
+
+// validPhone reports whether s has as many digits as phone numbers do.
+func validPhone(s string) bool {
+	n := len(digits(s))
+	return n >= 7 && n <= 15
+}
+
+// PresidioDetector finds personal data with the analyzer of Microsoft
+// Presidio, whose NER models also recognize names, places and other
+// entities. URL is the analyzer's base URL, e.g. http://localhost:5002.
+type PresidioDetector struct {
+	URL      string
+	Language string  // "en" if empty
+	MinScore float64 // the analyzer's default if zero
+	Client   *http.Client
+}
+
+// presidioKinds maps Presidio's entity types to the kinds PatternDetector
+// reports; other types are reported in lower case.
+var presidioKinds = map[string]PIIKind{
+	"EMAIL_ADDRESS": PIIEmail,
+	"PHONE_NUMBER":  PIIPhone,
+	"US_SSN":        PIISSN,
+	"CREDIT_CARD":   PIICreditCard,
+	"IBAN_CODE":     PIIIBAN,
+	"IP_ADDRESS":    PIIIP,
+}
+
+func (d *PresidioDetector) Detect(ctx context.Context, text string) ([]PIISpan, error) {
+	body := map[string]any{"text": text, "language": cmp.Or(d.Language, "en")}
+	if d.MinScore > 0 {
+		body["score_threshold"] = d.MinScore
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(d.URL, "/")+"/analyze", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var results []struct {
+		EntityType string `json:"entity_type"`
+		Start      int    `json:"start"`
+		End        int    `json:"end"`
+	}
+	if err := doJSON(cmp.Or(d.Client, http.DefaultClient), req, &results); err != nil {
+		return nil, fmt.Errorf("presidio: %w", err)
+	}
+	// Presidio counts characters, not bytes
+	offsets := make([]int, 0, len(text)+1)
+	for i := range text {
+		offsets = append(offsets, i)
+	}
+	offsets = append(offsets, len(text))
+	spans := make([]PIISpan, 0, len(results))
+	for _, r := range results {
+		if r.Start < 0 || r.End > utf8.RuneCountInString(text) || r.Start >= r.End {
+			return nil, fmt.Errorf("presidio: entity at %d:%d out of range", r.Start, r.End)
+		}
+		kind, ok := presidioKinds[r.EntityType]
+		if !ok {
+			kind = PIIKind(strings.ToLower(r.EntityType))
+		}
+		spans = append(spans, PIISpan{Kind: kind, Start: offsets[r.Start], End: offsets[r.End]})
+	}
+	return spans, nil
+}
+
+// PIIRedactor masks the personal data its Detectors find, replacing it with
+// its kind in brackets, e.g. [EMAIL], where Mode says. Only the Kinds given
+// are masked, all kinds found if there are none. If Log is set, every
+// redaction is recorded in it.
+type PIIRedactor struct {
+	Mode      PIIMode
+	Detectors []PIIDetector
+	Kinds     []PIIKind
+	Log       *PIILog
+}
+
+// NewPIIRedactor returns the PIIRedactor configured by cfg, which finds
+// personal data with a PatternDetector and, with PII_NER_URL, a
+// PresidioDetector, or nil if PII is off or empty.
+func NewPIIRedactor(cfg Config) (*PIIRedactor, error) {
+	mode := PIIMode(cfg.PII)
+	switch mode {
+	case "", "off":
+		return nil, nil
+	case PIIIngest, PIIAnswers, PIIBoth:
+	default:
+		return nil, fmt.Errorf("unknown PII mode %q", cfg.PII)
+	}
+	r := &PIIRedactor{Mode: mode, Detectors: []PIIDetector{PatternDetector{}}}
+	if cfg.PIINERURL != "" {
+		r.Detectors = append(r.Detectors, &PresidioDetector{URL: cfg.PIINERURL, Client: newProviderClient(cfg)})
+	}
+	for _, kind := range strings.Split(cfg.PIIKinds, ",") {
+		kind := PIIKind(strings.ToLower(strings.TrimSpace(kind)))
+		if kind == "" {
+			continue
+		}
+		known := slices.ContainsFunc(piiPatterns, func(p piiPattern) bool { return p.kind == kind })
+		if !known && cfg.PIINERURL == "" {
+			return nil, fmt.Errorf("PII_KINDS: %q is only found with PII_NER_URL", kind)
+		}
+		r.Kinds = append(r.Kinds, kind)
+	}
+	if cfg.PIILog != "" {
+		r.Log = &PIILog{Path: cfg.PIILog}
+	}
+	return r, nil
+}
+
+// Redact returns text with the personal data found in it masked, and how
+// many of each kind were. Where what detectors found overlaps, the span
+// starting first, or the longer one, is masked.
+func (r *PIIRedactor) Redact(ctx context.Context, text string) (string, map[PIIKind]int, error) {
+	var spans []PIISpan
+	for _, d := range r.Detectors {
+		found, err := d.Detect(ctx, text)
+		if err != nil {
+			return "", nil, err
+		}
+		for _, s := range found {
+			if len(r.Kinds) == 0 || slices.Contains(r.Kinds, s.Kind) {
+				spans = append(spans, s)
+			}
+		}
+	}
+	if len(spans) == 0 {
+		return text, nil, nil
+	}
+	slices.SortFunc(spans, func(a, b PIISpan) int {
+		return cmp.Or(cmp.Compare(a.Start, b.Start), cmp.Compare(b.End, a.End))
+	})
+	var b strings.Builder
+	counts := make(map[PIIKind]int)
+	end := 0
+	for _, s := range spans {
+		if s.Start < end {
+			continue
+		}
+		b.WriteString(text[end:s.Start])
+		b.WriteString("[" + strings.ToUpper(string(s.Kind)) + "]")
+		counts[s.Kind]++
+		end = s.End
+	}
+	b.WriteString(text[end:])
+	return b.String(), counts, nil
+}
+
+// piiKeptKeys are the metadata keys whose values are never redacted, as
+// access to documents depends on them.
+var piiKeptKeys = []string{ACLKey, ExpiresKey}
+
+// Middleware returns the Middleware masking personal data where r's Mode
+// says: the Load stage redacts the sections and metadata of documents,
+// and the Prompt and Generate stages the texts of the sources answers give
+// and the replies of the LLM. Streamed replies are passed on a line at a
+// time, once each line is complete, so that data split across deltas is
+// found. Documents and answers are failed rather than passed on unredacted
+// if a detector or the log fails.
+func (r *PIIRedactor) Middleware() Middleware {
+	var m Middleware
+	if r.Mode == PIIIngest || r.Mode == PIIBoth {
+		m.Load = r.load
+	}
+	if r.Mode == PIIAnswers || r.Mode == PIIBoth {
+		m.Prompt = r.prompt
+		m.Generate = r.generate
+	}
+	return m
+}
+
+func (r *PIIRedactor) load(next LoadFunc) LoadFunc {
+	return func(ctx context.Context, doc *Document) (_ *Document, err error) {
+		piiCtx, span := tracer.Start(ctx, "rag.pii")
+		redacted := *doc
+		redacted.Sections = slices.Clone(doc.Sections)
+		counts := make(map[PIIKind]int)
+		for i := range redacted.Sections {
+			text, found, err := r.Redact(piiCtx, redacted.Sections[i].Text)
+			if err != nil {
+				endSpan(span, err)
+				return nil, fmt.Errorf("redacting personal data: %w", err)
+			}
+			redacted.Sections[i].Text = text
+			addRedactions(counts, found)
+		}
+		redacted.Metadata = maps.Clone(doc.Metadata)
+		for key, value := range redacted.Metadata {
+			if slices.Contains(piiKeptKeys, key) {
+				continue
+			}
+			text, found, err := r.Redact(piiCtx, value)
+			if err != nil {
+				endSpan(span, err)
+				return nil, fmt.Errorf("redacting personal data: %w", err)
+			}
+			redacted.Metadata[key] = text
+			addRedactions(counts, found)
+		}
+		err = r.record(piiCtx, "ingest", doc.ID, counts)
+		span.SetAttributes(attribute.Int("rag.redactions", countRedactions(counts)))
+		endSpan(span, err)
+		if err != nil {
+			return nil, err
+		}
+		return next(ctx, &redacted)
+	}
+}
+
+// prompt redacts the texts of the sources the prompt gives, which answers
+// return, after the prompt is built, so that the LLM still reads them as
+// they are.
+func (r *PIIRedactor) prompt(next PromptFunc) PromptFunc {
+	return func(ctx context.Context, req PromptRequest) ([]Message, []SearchResult, error) {
+		messages, sources, err := next(ctx, req)
+		if err != nil {
+			return nil, nil, err
+		}
+		sources = slices.Clone(sources)
+		counts := make(map[PIIKind]int)
+		for i := range sources {
+			text, found, err := r.Redact(ctx, sources[i].Text)
+			if err != nil {
+				return nil, nil, fmt.Errorf("redacting personal data: %w", err)
+			}
+			sources[i].Text = text
+			addRedactions(counts, found)
+		}
+		if err := r.record(ctx, "sources", "", counts); err != nil {
+			return nil, nil, err
+		}
+		return messages, sources, nil
+	}
+}
+
+func (r *PIIRedactor) generate(next GenerateFunc) GenerateFunc {
+	return func(ctx context.Context, messages []Message, onDelta func(string) error) (string, error) {
+		var text, pending strings.Builder
+		counts := make(map[PIIKind]int)
+		redact := func(s string) (string, error) {
+			redacted, found, err := r.Redact(ctx, s)
+			if err != nil {
+				return "", fmt.Errorf("redacting personal data: %w", err)
+			}
+			addRedactions(counts, found)
+			text.WriteString(redacted)
+			return redacted, nil
+		}
+		var err error
+		if onDelta == nil {
+			var reply string
+			if reply, err = next(ctx, messages, nil); err == nil {
+				_, err = redact(reply)
+			}
+		} else {
+			emit := func(s string) error {
+				redacted, err := redact(s)
+				if err != nil {
+					return err
+				}
+				return onDelta(redacted)
+			}
+			_, err = next(ctx, messages, func(delta string) error {
+				pending.WriteString(delta)
+				s := pending.String()
+				i := strings.LastIndexByte(s, '\n')
+				if i < 0 {
+					return nil
+				}
+				pending.Reset()
+				pending.WriteString(s[i+1:])
+				return emit(s[:i+1])
+			})
+			if err == nil && pending.Len() > 0 {
+				err = emit(pending.String())
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Int("rag.redactions", countRedactions(counts)))
+		if err := r.record(ctx, "answer", "", counts); err != nil {
+			return "", err
+		}
+		return text.String(), nil
+	}
+}
+
+func addRedactions(counts, found map[PIIKind]int) {
+	for kind, n := range found {
+		counts[kind] += n
+	}
+}
+
+func countRedactions(counts map[PIIKind]int) int {
+	n := 0
+	for _, c := range counts {
+		n += c
+	}
+	return n
+}
+
+// record records redactions in r's Log, if it has one and there were any.
+func (r *PIIRedactor) record(ctx context.Context, stage, docID string, counts map[PIIKind]int) error {
+	if r.Log == nil || len(counts) == 0 {
+		return nil
+	}
+	rec := PIIRecord{Time: time.Now().UTC(), Namespace: NamespaceFrom(ctx), Stage: stage, DocID: docID, Redactions: counts}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		rec.TraceID = sc.TraceID().String()
+	}
+	if err := r.Log.Record(rec); err != nil {
+		return fmt.Errorf("writing PII log: %w", err)
+	}
+	return nil
+}
+
+// PIIRecord is the record of personal data masked in a document, at the
+// "ingest" stage, in the sources of an answer, at the "sources" stage, or
+// in an answer, at the "answer" stage, counting the redactions of each
+// kind. The data itself is not recorded; TraceID links the redactions in an
+// answer to the query's AuditRecord.
+type PIIRecord struct {
+	Time       time.Time       `json:"time"`
+	TraceID    string          `json:"trace_id,omitempty"`
+	Namespace  string          `json:"namespace"`
+	Stage      string          `json:"stage"`
+	DocID      string          `json:"doc_id,omitempty"`
+	Redactions map[PIIKind]int `json:"redactions"`
+}
+
+// A PIILog appends a PIIRecord of every redaction of a PIIRedactor to a
+// file of JSON Lines at Path, the way an AuditLog does.
+type PIILog struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// Record appends rec to the log.
+func (l *PIILog) Record(rec PIIRecord) error {
+	return appendJSONLine(&l.mu, l.Path, rec)
+}
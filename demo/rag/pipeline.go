@@ -0,0 +1,927 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Pipeline ties together the components used to ingest documents and answer
+// questions about them. Middleware wraps the stages of ingestion and
+// queries, see Use. Summarizer summarizes documents on request, see
+// Summarize.
+//
+// During ingestion chunks are embedded BatchSize at a time with up to
+// Concurrency requests in flight, and every failed request is retried
+// Retries times. If ParentSplitter is set, documents are first cut into
+// parent chunks with it and then into the chunks that are embedded with
+// Splitter, see ChunkWithParents; Store must then be a ParentStore. If
+// SparseEmbedder is set, chunks are also given sparse vectors with it and
+// Store must be a SparseStore. If Dedup is set, chunks repeating the text
+// of chunks already ingested are not stored. If Enricher is set, it
+// describes every chunk as it is ingested. Languages, if set, has
+// documents tagged with their language when they are ingested. If Graph is
+// set, the entities and relations of chunks are extracted into it as they
+// are ingested. If Summaries is set, every document is stored with a tree
+// of summaries of its chunks. If Webhooks is set, it is sent events as
+// documents are ingested and deleted and the index is reindexed.
+//
+// Keywords is optional; when set it is kept in sync with Store so that
+// hybrid retrieval sees the same chunks. Memory is also optional and
+// enables follow-up questions within a session; if Condenser is set too,
+// follow-ups are rewritten with it into standalone questions to retrieve
+// for. If Agent is set, it can retrieve the context of questions instead
+// of Retriever, which it searches with. With LanguageFilter, questions are
+// searched for in their own language. Retrieved chunks scoring below
+// MinScore, if it is not zero, are dropped, and queries may ask for up to
+// MaxK chunks, DefaultMaxK if it is zero. If Guard is set, retrieved
+// chunks are scanned for prompt injections.
+//
+// Prompt renders the messages sent to the LLM; DefaultPrompt is used if it
+// is nil. If Budget is set, retrieved chunks are dropped, shortened,
+// summarized or split across several LLM calls to keep prompts within its
+// token limit. Questions left without chunks are answered as NoContext
+// says, NoContextRefuse if it is empty. If Router is set, answers are
+// generated with the model and temperature of the route their question
+// takes. If Grounding is set, every answer is checked against its sources.
+// The citation markers of answers are rewritten as Citations says, if it
+// is set. If Answers is set, answers to questions asked before are served
+// from it. If Feedback is set, answers are recorded in it to be rated. If
+// Audit is set, every query is recorded in it; a query that cannot be
+// recorded fails. If Usage is set, it adds up the tokens and cost of every
+// query and ingestion, and its Pricing also prices the usage of each
+// Answer. Questions are answered within Timeouts.
+//
+// If Store is a ModelStore, it records the EmbeddingModel, the name of the
+// model of Embedder, with the dimensions and normalization of the
+// embeddings ingested, and chunks and queries embedded otherwise fail with
+// ErrModelMismatch. If Profiles is set, namespaces are chunked and
+// prompted as their NamespaceProfile says; Embedder must then embed with
+// the model of the profile of the namespace of its context, as that of
+// NewPipeline does.
+type Pipeline struct {
+	Embedder   Embedder
+	Store      VectorStore
+	Keywords   *KeywordIndex
+	Retriever  Retriever
+	LLM        LLM
+	Splitter   Splitter
+	Memory     *ConversationMemory
+	Prompt     *PromptTemplate
+	Budget     *ContextBudget
+	Grounding  *GroundingCheck
+	Guard      *InjectionGuard
+	Dedup      *Deduplicator
+	Usage      *UsageMeter
+	Answers    *AnswerCache
+	Enricher   *Enricher
+	Agent      *RetrievalAgent
+	Condenser  *QueryCondenser
+	MinScore   float64
+	MaxK       int
+	NoContext  NoContextMode
+	Languages  LanguageMode
+	Audit      *AuditLog
+	Citations  CitationMode
+	Router     *Router
+	Feedback   *FeedbackStore
+	Graph      *KnowledgeGraph
+	Summaries  *SummaryTree
+	Summarizer *Summarizer
+	Timeouts   Timeouts
+	Webhooks   *Webhooks
+	Profiles   *NamespaceProfiles
+
+	Middleware []Middleware
+
+	ParentSplitter Splitter
+	SparseEmbedder SparseEmbedder
+	EmbeddingModel string
+
+	BatchSize   int // DefaultBatchSize if zero
+	Concurrency int // DefaultConcurrency if zero
+	Retries     int
+}
+
+// NewPipeline assembles a Pipeline from the providers selected in cfg.
+func NewPipeline(ctx context.Context, cfg Config) (*Pipeline, error) {
+	return NewPipelineWith(ctx, cfg, Providers{})
+}
+
+// Providers are the embedder, vector store and LLM NewPipelineWith
+// assembles a Pipeline around, in place of those cfg selects. Those that
+// are nil are made from cfg; the others are used as they are, without the
+// tracing and embedding cache NewPipeline adds to its own.
+type Providers struct {
+	Embedder Embedder
+	Store    VectorStore
+	LLM      LLM
+}
+
+// NewPipelineWith is NewPipeline with the embedder, vector store and LLM
+// of providers, for programs that bring providers of their own. The
+// embeddings are still recorded as those of the EMBEDDER and
+// EMBEDDING_MODEL of cfg.
+func NewPipelineWith(ctx context.Context, cfg Config, providers Providers) (*Pipeline, error) {
+	var cache EmbeddingStore
+	if cfg.EmbedCache == "redis" {
+		client, err := sharedRedisClient(cfg.RedisURL)
+		if err != nil {
+			return nil, err
+		}
+		cache = &RedisEmbeddingCache{Client: client}
+	} else if cfg.EmbedCache != "" {
+		var err error
+		if cache, err = NewEmbeddingCache(ctx, cfg.EmbedCache); err != nil {
+			return nil, err
+		}
+	}
+	newEmbedder := func(cfg Config) (Embedder, error) {
+		embedder, err := NewEmbedder(cfg)
+		if err != nil {
+			return nil, err
+		}
+		embedder = instrumentedEmbedder{embedder}
+		if cache != nil {
+			embedder = &CachedEmbedder{Embedder: embedder, Cache: cache, Model: embeddingModel(cfg)}
+		}
+		return embedder, nil
+	}
+	embedder, store, llm := providers.Embedder, providers.Store, providers.LLM
+	var err error
+	if embedder == nil {
+		if embedder, err = newEmbedder(cfg); err != nil {
+			return nil, err
+		}
+	}
+	if store == nil {
+		if store, err = NewVectorStore(ctx, cfg); err != nil {
+			return nil, err
+		}
+	}
+	var profiles *NamespaceProfiles
+	if ps, ok := store.(ProfileStore); ok {
+		profiles = &NamespaceProfiles{Store: ps, Embedders: func(model string) (Embedder, error) {
+			switch cfg.Embedder {
+			case "hash", "onnx":
+				return nil, fmt.Errorf("EMBEDDER=%s has no embedding models to choose from", cfg.Embedder)
+			}
+			cfg := cfg
+			cfg.EmbeddingModel = model
+			return newEmbedder(cfg)
+		}}
+		embedder = namespaceEmbedder{Embedder: embedder, profiles: profiles}
+	}
+	keywords := NewKeywordIndex()
+	if cs, ok := store.(ChunkStore); ok {
+		keywords.Source = cs
+	}
+	sparse, err := NewSparseEmbedder(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if llm == nil {
+		if llm, err = NewLLM(cfg); err != nil {
+			return nil, err
+		}
+		llm = instrumentedLLM{llm}
+	}
+	var graph *KnowledgeGraph
+	if cfg.GraphDB != "" {
+		if _, ok := store.(ChunkStore); !ok {
+			return nil, errors.New("GRAPH_DB needs a vector store that can list its chunks")
+		}
+		if graph, err = NewKnowledgeGraph(ctx, cfg.GraphDB, llm, cfg.GraphHops, cfg.GraphChunks); err != nil {
+			return nil, err
+		}
+	}
+	var feedback *FeedbackStore
+	if cfg.FeedbackDB != "" {
+		if feedback, err = NewFeedbackStore(ctx, cfg.FeedbackDB, cfg.FeedbackWeight, cfg.FeedbackSim); err != nil {
+			return nil, err
+		}
+	}
+	splitter, err := NewRecursiveSplitter(cfg.ChunkSize, cfg.ChunkOverlap)
+	if err != nil {
+		return nil, err
+	}
+	var parentSplitter Splitter
+	if cfg.ParentChunkSize > 0 {
+		if cfg.ParentChunkSize <= cfg.ChunkSize {
+			return nil, fmt.Errorf("PARENT_CHUNK_SIZE must be larger than the chunk size %d, got %d", cfg.ChunkSize, cfg.ParentChunkSize)
+		}
+		if _, ok := store.(ParentStore); !ok {
+			return nil, errors.New("PARENT_CHUNK_SIZE needs a vector store that keeps parent chunks")
+		}
+		if parentSplitter, err = NewRecursiveSplitter(cfg.ParentChunkSize, 0); err != nil {
+			return nil, err
+		}
+	}
+	enricher, err := NewEnricher(cfg.Enrichment, llm)
+	if err != nil {
+		return nil, err
+	}
+	summaries, err := NewSummaryTree(cfg.SummaryFanout, llm)
+	if err != nil {
+		return nil, err
+	}
+	dedup, err := NewDeduplicator(cfg.Dedup, cfg.DedupThreshold)
+	if err != nil {
+		return nil, err
+	}
+	if cs, ok := store.(ChunkStore); ok && dedup != nil {
+		dedup.Source = cs
+	}
+	pricing := DefaultPricing
+	if cfg.Pricing != "" {
+		if pricing, err = LoadPricing(cfg.Pricing); err != nil {
+			return nil, err
+		}
+	}
+	var answers *AnswerCache
+	if cfg.AnswerCache != "" {
+		if answers, err = NewAnswerCache(ctx, cfg.AnswerCache, cfg.AnswerCacheTTL, cfg.AnswerSimilarity); err != nil {
+			return nil, err
+		}
+	}
+	languages := LanguageMode(cfg.Languages)
+	switch languages {
+	case "off":
+		languages = ""
+	case "", LanguageTag, LanguageFilter:
+	default:
+		return nil, fmt.Errorf("unknown language detection mode %q", cfg.Languages)
+	}
+	var audit *AuditLog
+	if cfg.AuditLog != "" {
+		audit = &AuditLog{Path: cfg.AuditLog}
+	}
+	var sessions ConversationStore = NewMemoryConversationStore()
+	if cfg.SessionStore == "redis" {
+		client, err := sharedRedisClient(cfg.RedisURL)
+		if err != nil {
+			return nil, err
+		}
+		sessions = &RedisConversationStore{Client: client, TTL: cfg.SessionTTL}
+	}
+	pii, err := NewPIIRedactor(cfg)
+	if err != nil {
+		return nil, err
+	}
+	webhooks, err := NewWebhooks(cfg)
+	if err != nil {
+		return nil, err
+	}
+	p := &Pipeline{
+		Embedder:  embedder,
+		Store:     store,
+		Keywords:  keywords,
+		LLM:       llm,
+		Splitter:  splitter,
+		Dedup:     dedup,
+		Usage:     &UsageMeter{Pricing: pricing},
+		Answers:   answers,
+		Enricher:  enricher,
+		Languages: languages,
+		Audit:     audit,
+		Feedback:  feedback,
+		Graph:     graph,
+		Summaries: summaries,
+		Webhooks:  webhooks,
+		Profiles:  profiles,
+
+		ParentSplitter: parentSplitter,
+		SparseEmbedder: sparse,
+		EmbeddingModel: embeddingModel(cfg),
+		Memory: &ConversationMemory{
+			Store:  sessions,
+			LLM:    llm,
+			Window: cfg.MemoryWindow,
+		},
+		BatchSize:   cfg.BatchSize,
+		Concurrency: cfg.Concurrency,
+		Retries:     cfg.Retries,
+	}
+	if err := p.configure(cfg); err != nil {
+		return nil, err
+	}
+	if pii != nil {
+		p.Use(pii.Middleware())
+	}
+	return p, nil
+}
+
+// configure sets up how p answers questions from the query settings of
+// cfg, those Reconfigure applies: the Retriever, with the retrievers
+// wrapping the search configured, and the Prompt, Budget, Grounding, Guard,
+// Agent, Condenser, MinScore, NoContext, Citations, Router and Summarizer.
+// The retrievers search the stores p has.
+func (p *Pipeline) configure(cfg Config) error {
+	retriever, err := NewRetriever(cfg, p.Embedder, p.SparseEmbedder, p.Store, p.Keywords)
+	if err != nil {
+		return err
+	}
+	retriever = &ACLRetriever{Retriever: retriever}
+	canCallTools := callsTools(p.LLM)
+	if cfg.AgentSteps > 0 && !canCallTools {
+		return errors.New("AGENT_STEPS needs an llm that can call tools")
+	}
+	if p.Graph != nil {
+		// A copy, as the graph may be ingesting with its old settings
+		graph := *p.Graph
+		graph.Hops, graph.Chunks = cfg.GraphHops, cfg.GraphChunks
+		p.Graph = &graph
+		retriever = &GraphRetriever{Retriever: retriever, Graph: p.Graph, Store: p.Store.(ChunkStore)}
+	}
+	if cfg.HyDE {
+		retriever = &HyDERetriever{Retriever: retriever, LLM: p.LLM}
+	}
+	if cfg.QueryVariants > 0 {
+		retriever = &MultiQueryRetriever{Retriever: retriever, LLM: p.LLM, Variants: cfg.QueryVariants}
+	}
+	if fields, err := ParseMetadataFields(cfg.SelfQuery); err != nil {
+		return err
+	} else if len(fields) > 0 {
+		retriever = &SelfQueryRetriever{Retriever: retriever, LLM: p.LLM, Fields: fields}
+	}
+	reranker, err := NewReranker(cfg)
+	if err != nil {
+		return err
+	}
+	if reranker != nil {
+		retriever = &RerankRetriever{Retriever: retriever, Reranker: reranker, Candidates: cfg.RerankCandidates}
+	}
+	if p.Feedback != nil {
+		feedback := *p.Feedback
+		feedback.Weight, feedback.Similarity = cfg.FeedbackWeight, cfg.FeedbackSim
+		p.Feedback = &feedback
+		if cfg.FeedbackWeight > 0 {
+			retriever = &FeedbackRetriever{Retriever: retriever, Embedder: p.Embedder, Store: p.Feedback}
+		}
+	}
+	if cfg.RecencyWeight > 0 {
+		retriever = &RecencyRetriever{Retriever: retriever, Weight: cfg.RecencyWeight, HalfLife: cfg.RecencyHalfLife, Fields: ParseRecencyFields(cfg.RecencyFields)}
+	}
+	if cfg.MMRLambda > 0 {
+		retriever = &MMRRetriever{Retriever: retriever, Embedder: p.Embedder, Lambda: cfg.MMRLambda}
+	}
+	if p.ParentSplitter != nil {
+		retriever = &ParentRetriever{Retriever: retriever, Store: p.Store.(ParentStore)}
+	}
+	compressor, err := NewCompressor(cfg.Compression, p.LLM, p.Embedder, cfg.CompressionRatio)
+	if err != nil {
+		return err
+	}
+	if compressor != nil {
+		retriever = &CompressRetriever{Retriever: retriever, Compressor: compressor}
+	}
+	grounding, err := NewGroundingCheck(cfg.Grounding, p.LLM)
+	if err != nil {
+		return err
+	}
+	guard, err := NewInjectionGuard(cfg.InjectionGuard)
+	if err != nil {
+		return err
+	}
+	prompt := DefaultPrompt
+	if cfg.PromptTemplate != "" {
+		if prompt, err = LoadPrompt(cfg.PromptTemplate); err != nil {
+			return err
+		}
+	}
+	switch NoContextMode(cfg.NoContext) {
+	case "", NoContextRefuse, NoContextGenerate:
+	default:
+		return fmt.Errorf("unknown no-context mode %q", cfg.NoContext)
+	}
+	citations := CitationMode(cfg.Citations)
+	switch citations {
+	case "off":
+		citations = ""
+	case "", CitationsValidate, CitationsRenumber:
+	default:
+		return fmt.Errorf("unknown citation mode %q", cfg.Citations)
+	}
+	var agent *RetrievalAgent
+	if canCallTools {
+		agent = &RetrievalAgent{LLM: p.LLM.(ToolLLM), MaxSteps: cfg.AgentSteps}
+		// Parents already hold the chunks around those found
+		if chunks, ok := p.Store.(ChunkStore); ok && p.ParentSplitter == nil {
+			agent.Chunks = chunks
+		}
+	}
+	var condenser *QueryCondenser
+	if cfg.CondenseQueries {
+		condenser = &QueryCondenser{LLM: p.LLM}
+	}
+	if err := OverflowStrategy(cfg.ContextOverflow).validate(); err != nil {
+		return err
+	}
+	var budget *ContextBudget
+	if cfg.ContextTokens > 0 {
+		tokenizer, err := NewTiktokenTokenizer(cfg.ChatModel)
+		if err != nil {
+			return err
+		}
+		budget = &ContextBudget{Tokenizer: tokenizer, MaxTokens: cfg.ContextTokens, Overflow: OverflowStrategy(cfg.ContextOverflow)}
+	}
+	router, err := NewRouter(cfg.Router, cfg.Routes, p.LLM)
+	if err != nil {
+		return err
+	}
+	summarizer, err := NewSummarizer(cfg, p.LLM)
+	if err != nil {
+		return err
+	}
+	summarizer.Concurrency = p.Concurrency
+	p.Retriever = instrumentedRetriever{retriever}
+	p.Prompt = prompt
+	p.Budget = budget
+	p.Grounding = grounding
+	p.Guard = guard
+	p.Agent = agent
+	p.Condenser = condenser
+	p.MinScore = cfg.MinScore
+	p.MaxK = cfg.MaxK
+	p.NoContext = NoContextMode(cfg.NoContext)
+	p.Citations = citations
+	p.Router = router
+	p.Summarizer = summarizer
+	p.Timeouts = Timeouts{
+		Request:  cfg.RequestTimeout,
+		Embed:    cfg.EmbedTimeout,
+		Retrieve: cfg.RetrieveTimeout,
+		Rerank:   cfg.RerankTimeout,
+		Generate: cfg.GenerateTimeout,
+	}
+	return nil
+}
+
+// callsTools reports whether llm can call tools, which the instrumentedLLM
+// wrapping an LLM claims either way, as does a FallbackLLM.
+func callsTools(llm LLM) bool {
+	if l, ok := llm.(instrumentedLLM); ok {
+		llm = l.LLM
+	}
+	if l, ok := llm.(*FallbackLLM); ok {
+		return l.callsTools()
+	}
+	_, ok := llm.(ToolLLM)
+	return ok
+}
+
+// IngestResult reports the outcome of ingesting one document. Chunks is
+// the number of chunks stored for it and Embedded how many of them were
+// new or changed and had to be embedded. Duplicates counts the chunks that
+// were not stored because the pipeline's Deduplicator found them to repeat
+// other chunks.
+type IngestResult struct {
+	ID         string `json:"id"`
+	Chunks     int    `json:"chunks"`
+	Embedded   int    `json:"embedded"`
+	Duplicates int    `json:"duplicates,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Ingest chunks and embeds doc and stores the result, replacing any chunks
+// previously stored for the same document ID. It returns the number of
+// chunks stored.
+func (p *Pipeline) Ingest(ctx context.Context, doc *Document) (int, error) {
+	results, err := p.IngestAll(ctx, []*Document{doc})
+	if err != nil {
+		return 0, err
+	}
+	return results[0].Chunks, nil
+}
+
+// IngestAll ingests docs, embedding the chunks of all documents together in
+// batches. A document is only stored if all of its chunks were embedded,
+// with the SparseEmbedder too if there is one; the others are reported in
+// their IngestResult and the returned error is a *BatchError describing the
+// failed batches, or joins one for either embedder. Documents the Load or
+// Chunk stage fails for are only reported in their IngestResult. Any other
+// error aborts the ingestion.
+//
+// If the store is an IncrementalStore, chunks whose hash matches the stored
+// chunk with the same ID are neither embedded nor rewritten, and stored
+// chunks that no longer exist in the document are deleted. Unchanged
+// chunks that an edit before them moved are rewritten with their new
+// offsets and stored embeddings if the store is a ChunkStore too.
+//
+// Canceling ctx stops the ingestion between documents: a document whose
+// chunks are being written is written completely, and IngestAll returns
+// ctx.Err() with the documents stored so far, so that ingesting the same
+// documents again resumes where it stopped.
+func (p *Pipeline) IngestAll(ctx context.Context, docs []*Document) (_ []IngestResult, err error) {
+	ctx, span := tracer.Start(ctx, "rag.ingest", trace.WithAttributes(
+		attribute.String("rag.namespace", NamespaceFrom(ctx)),
+		attribute.Int("rag.documents", len(docs))))
+	defer func() { endSpan(span, err) }()
+	if p.Usage != nil {
+		ctx = WithUsageFunc(ctx, p.Usage.Record)
+	}
+
+	// Chunking includes finding the chunks that changed
+	chunkCtx, chunkSpan := tracer.Start(ctx, "rag.chunk")
+	chunks := make([][]Chunk, len(docs))
+	parents := make([][]Chunk, len(docs))
+	stale := make([][]string, len(docs))
+	duplicates := make([]int, len(docs))
+	toEmbed := make([]int, len(docs))
+	moved := make([]int, len(docs))
+	failed := make([]string, len(docs)) // why documents could not be ingested
+	var texts []string
+	var pending []*Chunk // chunks to embed, in the order of texts
+	var owners []int     // the index in docs of the document of each pending chunk
+	docs = slices.Clone(docs)
+	load, chunk := p.loadStage(), p.chunkStage()
+	for i, doc := range docs {
+		loaded, c, parent, err := prepare(chunkCtx, load, chunk, doc)
+		if loaded != nil {
+			docs[i], doc = loaded, loaded
+		}
+		if err != nil {
+			failed[i] = err.Error()
+			continue
+		}
+		chunks[i], parents[i] = c, parent
+		if p.Dedup != nil {
+			unique, err := p.Dedup.Unique(chunkCtx, doc.ID, chunks[i])
+			if err != nil {
+				endSpan(chunkSpan, err)
+				return nil, err
+			}
+			duplicates[i] = len(chunks[i]) - len(unique)
+			chunks[i] = unique
+		}
+		if p.Summaries != nil {
+			chunks[i] = append(chunks[i], p.Summaries.nodes(doc, chunks[i])...)
+		}
+		var stored map[string]string
+		if s, ok := p.Store.(IncrementalStore); ok {
+			var err error
+			if stored, err = s.ChunkHashes(chunkCtx, doc.ID); err != nil {
+				endSpan(chunkSpan, err)
+				return nil, fmt.Errorf("reading stored chunks of %s: %w", doc.ID, err)
+			}
+		}
+		if p.Summaries != nil && stored != nil {
+			if err := p.restoreSummaries(chunkCtx, doc.ID, chunks[i], stored); err != nil {
+				endSpan(chunkSpan, err)
+				return nil, fmt.Errorf("reading stored summaries of %s: %w", doc.ID, err)
+			}
+		}
+		unchanged := make(map[string]bool)
+		for j := range chunks[i] {
+			c := &chunks[i][j]
+			if p.Enricher != nil {
+				c.Hash = p.Enricher.hash(c.Hash)
+			}
+			if hash, ok := stored[c.ID]; !ok || hash != c.Hash {
+				texts = append(texts, c.Text)
+				pending = append(pending, c)
+				owners = append(owners, i)
+				toEmbed[i]++
+			} else {
+				unchanged[c.ID] = true
+			}
+			delete(stored, c.ID)
+		}
+		for id := range stored {
+			stale[i] = append(stale[i], id)
+		}
+		if len(unchanged) > 0 && (toEmbed[i] > 0 || len(stale[i]) > 0) {
+			var err error
+			if moved[i], err = p.reuseMoved(chunkCtx, doc.ID, chunks[i], unchanged); err != nil {
+				endSpan(chunkSpan, err)
+				return nil, fmt.Errorf("reading stored chunks of %s: %w", doc.ID, err)
+			}
+		}
+	}
+	total := 0
+	for _, c := range chunks {
+		total += len(c)
+	}
+	chunkSpan.SetAttributes(attribute.Int("rag.chunks", total), attribute.Int("rag.changed_chunks", len(texts)))
+	chunkSpan.End()
+
+	// Chunks of documents that failed are not enriched or embedded
+	dropFailed := func() {
+		kept := 0
+		for j, c := range pending {
+			if failed[owners[j]] == "" {
+				pending[kept], texts[kept], owners[kept] = c, c.Text, owners[j]
+				kept++
+			}
+		}
+		pending, texts, owners = pending[:kept], texts[:kept], owners[:kept]
+	}
+	if p.Summaries != nil {
+		summarizeCtx, summarizeSpan := tracer.Start(ctx, "rag.summarize")
+		if err := p.summarize(summarizeCtx, docs, chunks, failed); err != nil {
+			endSpan(summarizeSpan, err)
+			return nil, err
+		}
+		dropFailed()
+		summarizeSpan.End()
+	}
+	var graphs [][]chunkGraph
+	if p.Graph != nil {
+		graphCtx, graphSpan := tracer.Start(ctx, "rag.graph")
+		var err error
+		if graphs, err = p.extractGraph(graphCtx, docs, chunks, failed); err != nil {
+			endSpan(graphSpan, err)
+			return nil, err
+		}
+		dropFailed()
+		graphSpan.End()
+	}
+
+	if p.Enricher != nil && len(pending) > 0 {
+		enrichCtx, enrichSpan := tracer.Start(ctx, "rag.enrich", trace.WithAttributes(attribute.Int("rag.chunks", len(pending))))
+		errs := p.enrich(enrichCtx, docs, pending, owners)
+		if err := ctx.Err(); err != nil {
+			endSpan(enrichSpan, err)
+			return nil, err
+		}
+		// Chunks of documents that failed are not embedded
+		var enriched []*Chunk
+		texts = texts[:0]
+		for j := range pending {
+			if errs[j] != nil && failed[owners[j]] == "" {
+				failed[owners[j]] = "enrichment failed: " + errs[j].Error()
+			}
+		}
+		for j, c := range pending {
+			if failed[owners[j]] == "" {
+				enriched = append(enriched, c)
+				texts = append(texts, p.Enricher.text(c))
+			}
+		}
+		pending = enriched
+		endSpan(enrichSpan, errors.Join(errs...))
+	}
+
+	embedCtx, embedSpan := tracer.Start(ctx, "rag.embed", trace.WithAttributes(attribute.Int("rag.texts", len(texts))))
+	vectors, embedErr := p.embedStage()(embedCtx, texts)
+	var sparse []*SparseVector
+	if p.SparseEmbedder != nil && (embedErr == nil || errors.As(embedErr, new(*BatchError))) {
+		var sparseErr error
+		sparse, sparseErr = p.embedSparseBatches(embedCtx, texts)
+		embedErr = errors.Join(embedErr, sparseErr)
+	}
+	endSpan(embedSpan, embedErr)
+	var batchErr *BatchError
+	if embedErr != nil && !errors.As(embedErr, &batchErr) {
+		return nil, embedErr
+	}
+	if len(vectors) != len(texts) && embedErr == nil {
+		embedErr = fmt.Errorf("embedding returned %d vectors for %d texts", len(vectors), len(texts))
+		endSpan(embedSpan, embedErr)
+		return nil, embedErr
+	}
+	for i, c := range pending {
+		c.Embedding = vectors[i]
+		if sparse != nil {
+			// Chunks missing either vector count as not embedded
+			if c.Sparse = sparse[i]; c.Sparse == nil {
+				c.Embedding = nil
+			}
+		}
+	}
+
+	upsertCtx, upsertSpan := tracer.Start(ctx, "rag.upsert")
+	store := p.storeStage()
+	results := make([]IngestResult, len(docs))
+	stored := 0
+	for i, doc := range docs {
+		results[i].ID = doc.ID
+		var changed []Chunk
+		for _, c := range chunks[i] {
+			if c.Embedding != nil {
+				changed = append(changed, c)
+			}
+		}
+		if failed[i] != "" || len(changed) < toEmbed[i] {
+			results[i].Error = cmp.Or(failed[i], "embedding failed")
+			if p.Dedup != nil {
+				// Its chunks were not stored, so they cannot make others duplicates
+				p.Dedup.Delete(ctx, doc.ID)
+			}
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			endSpan(upsertSpan, err)
+			return results, err
+		}
+		w := DocumentWrite{Document: doc, Chunks: chunks[i], Changed: changed, Stale: stale[i], Parents: parents[i]}
+		if err := store(upsertCtx, w); err != nil {
+			endSpan(upsertSpan, err)
+			return results, err
+		}
+		if p.Graph != nil {
+			if err := p.Graph.replace(context.WithoutCancel(upsertCtx), doc.ID, chunks[i], graphs[i]); err != nil {
+				err = fmt.Errorf("storing graph of %s: %w", doc.ID, err)
+				endSpan(upsertSpan, err)
+				return results, err
+			}
+		}
+		results[i].Chunks = len(chunks[i])
+		results[i].Embedded = len(changed) - moved[i]
+		results[i].Duplicates = duplicates[i]
+		p.Webhooks.send(ctx, EventDocumentIngested, results[i])
+		stored++
+	}
+	upsertSpan.SetAttributes(attribute.Int("rag.documents", stored))
+	upsertSpan.End()
+	recordIngest(results)
+	return results, embedErr
+}
+
+// reuseMoved gives the chunks of docID whose IDs are in unchanged, and
+// whose stored offsets differ from theirs, the stored embeddings and
+// metadata, so that they are stored again with their new offsets without
+// being embedded or enriched again. It returns how many it gave
+// embeddings; chunks stored without an embedding or, with a
+// SparseEmbedder, a sparse vector keep their old offsets.
+func (p *Pipeline) reuseMoved(ctx context.Context, docID string, chunks []Chunk, unchanged map[string]bool) (int, error) {
+	cs, ok := p.Store.(ChunkStore)
+	if !ok {
+		return 0, nil
+	}
+	stored, err := cs.Chunks(ctx, docID)
+	if err != nil {
+		return 0, err
+	}
+	byID := make(map[string]Chunk, len(stored))
+	for _, c := range stored {
+		byID[c.ID] = c
+	}
+	n := 0
+	for j := range chunks {
+		c := &chunks[j]
+		old, ok := byID[c.ID]
+		if !unchanged[c.ID] || !ok || old.Embedding == nil || (p.SparseEmbedder != nil && old.Sparse == nil) {
+			continue
+		}
+		if old.Metadata[CharStartKey] == c.Metadata[CharStartKey] && old.Metadata[CharEndKey] == c.Metadata[CharEndKey] {
+			continue
+		}
+		// The stored metadata holds what enrichment added to it
+		metadata := maps.Clone(old.Metadata)
+		metadata[CharStartKey], metadata[CharEndKey] = c.Metadata[CharStartKey], c.Metadata[CharEndKey]
+		c.Metadata, c.Embedding, c.Sparse = metadata, old.Embedding, old.Sparse
+		n++
+	}
+	return n, nil
+}
+
+// store is the default Store stage, replacing the chunks of a document, its
+// source if the store is a SourceStore and its parents if it is a
+// ParentStore. An IncrementalStore is only sent the changed chunks and the
+// IDs of stale ones; other stores have all chunks of the document
+// replaced. An AtomicStore writes all of it in one transaction. Once
+// begun, the writes are not canceled with ctx, which would leave the
+// document half-written in the other stores.
+func (p *Pipeline) store(ctx context.Context, w DocumentWrite) error {
+	ctx = context.WithoutCancel(ctx)
+	doc, chunks, parents, changed, stale := w.Document, w.Chunks, w.Parents, w.Changed, w.Stale
+	docID := doc.ID
+	if err := recordModel(ctx, p.Store, p.EmbeddingModel, changed); err != nil {
+		return fmt.Errorf("storing %s: %w", docID, err)
+	}
+	switch s := p.Store.(type) {
+	case AtomicStore:
+		if err := s.ReplaceDocument(ctx, w); err != nil {
+			return fmt.Errorf("storing %s: %w", docID, err)
+		}
+	case IncrementalStore:
+		if err := s.DeleteChunks(ctx, stale); err != nil {
+			return fmt.Errorf("deleting old chunks of %s: %w", docID, err)
+		}
+		if err := s.Upsert(ctx, changed); err != nil {
+			return fmt.Errorf("storing %s: %w", docID, err)
+		}
+		if err := p.storeExtras(ctx, doc, parents); err != nil {
+			return err
+		}
+	default:
+		if err := s.Delete(ctx, docID); err != nil {
+			return fmt.Errorf("deleting old chunks of %s: %w", docID, err)
+		}
+		if err := s.Upsert(ctx, chunks); err != nil {
+			return fmt.Errorf("storing %s: %w", docID, err)
+		}
+		if err := p.storeExtras(ctx, doc, parents); err != nil {
+			return err
+		}
+	}
+	if p.Keywords != nil {
+		p.Keywords.Delete(ctx, docID)
+		p.Keywords.Upsert(ctx, chunks)
+	}
+	if p.Answers != nil && (len(changed) > 0 || len(stale) > 0) {
+		if err := p.Answers.invalidate(ctx, docID); err != nil {
+			return fmt.Errorf("invalidating answers from %s: %w", docID, err)
+		}
+	}
+	return nil
+}
+
+// storeExtras stores the source and parents of a document in stores that
+// keep them.
+func (p *Pipeline) storeExtras(ctx context.Context, doc *Document, parents []Chunk) error {
+	if s, ok := p.Store.(SourceStore); ok {
+		if err := s.PutSource(ctx, doc); err != nil {
+			return fmt.Errorf("storing source of %s: %w", doc.ID, err)
+		}
+	}
+	if s, ok := p.Store.(ParentStore); ok {
+		// Without a ParentSplitter this removes parents left from earlier
+		// ingestions
+		if err := s.ReplaceParents(ctx, doc.ID, parents); err != nil {
+			return fmt.Errorf("storing parents of %s: %w", doc.ID, err)
+		}
+	}
+	return nil
+}
+
+// Delete removes a document's chunks from the store, the keyword index,
+// the Deduplicator and the KnowledgeGraph, and the answers drawn from it
+// from the AnswerCache.
+func (p *Pipeline) Delete(ctx context.Context, docID string) error {
+	return p.delete(ctx, docID, false)
+}
+
+// delete removes a document as Delete does, but keeps its graph if
+// keepGraph is set, for the caller to replace.
+func (p *Pipeline) delete(ctx context.Context, docID string, keepGraph bool) error {
+	if err := p.Store.Delete(ctx, docID); err != nil {
+		return err
+	}
+	if p.Keywords != nil {
+		p.Keywords.Delete(ctx, docID)
+	}
+	if p.Dedup != nil {
+		p.Dedup.Delete(ctx, docID)
+	}
+	if p.Graph != nil && !keepGraph {
+		if err := p.Graph.deleteDocument(ctx, docID); err != nil {
+			return err
+		}
+	}
+	p.Webhooks.send(ctx, EventDocumentDeleted, map[string]string{"id": docID})
+	if p.Answers != nil {
+		return p.Answers.invalidate(ctx, docID)
+	}
+	return nil
+}
+
+// rechunkGroup is how many stored documents Rechunk ingests at a time.
+const rechunkGroup = 32
+
+// Rechunk splits every document of the namespace again with the current
+// Splitter and ingests the result, reading the documents from the store
+// instead of their original sources, which need not exist anymore. As in
+// IngestAll, only chunks that changed are embedded. The store must be a
+// SourceStore. Documents that could not be embedded keep their old chunks
+// and are reported with an error in their IngestResult, as are documents
+// stored before their source was kept.
+func (p *Pipeline) Rechunk(ctx context.Context) ([]IngestResult, error) {
+	s, ok := p.Store.(SourceStore)
+	if !ok {
+		return nil, fmt.Errorf("keeping document sources is %w by the vector store", ErrNotSupported)
+	}
+	stored, err := s.Documents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var results []IngestResult
+	for start := 0; start < len(stored); start += rechunkGroup {
+		var docs []*Document
+		for _, info := range stored[start:min(start+rechunkGroup, len(stored))] {
+			doc, err := s.Source(ctx, info.ID)
+			if err != nil {
+				return results, fmt.Errorf("reading source of %s: %w", info.ID, err)
+			}
+			if doc == nil {
+				results = append(results, IngestResult{ID: info.ID, Error: "source not stored"})
+				continue
+			}
+			docs = append(docs, doc)
+		}
+		ingested, err := p.IngestAll(ctx, docs)
+		results = append(results, ingested...)
+		var batchErr *BatchError
+		if err != nil && !errors.As(err, &batchErr) {
+			return results, err
+		}
+	}
+	return results, nil
+}
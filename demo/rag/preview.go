@@ -0,0 +1,72 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+)
+
+// A ChunkPreview is a document as IngestAll would cut it into chunks,
+// without embedding or storing them. Embed counts the chunks that would be
+// embedded, those whose text or metadata differ from the stored chunks with
+// the same ID if the store is an IncrementalStore, and all of them
+// otherwise.
+type ChunkPreview struct {
+	Document *Document
+	Chunks   []Chunk
+	Parents  []Chunk
+	Embed    int
+}
+
+// Preview loads and chunks doc as IngestAll would, running the Load and
+// Chunk stages of the middleware, to tune chunking before paying for
+// embeddings. Nothing is written to the store. The stages calling the LLM,
+// enrichment and summaries, are skipped, and so is deduplication, which
+// would record the chunks as ingested.
+func (p *Pipeline) Preview(ctx context.Context, doc *Document) (*ChunkPreview, error) {
+	loaded, chunks, parents, err := prepare(ctx, p.loadStage(), p.chunkStage(), doc)
+	if err != nil {
+		return nil, err
+	}
+	preview := &ChunkPreview{Document: loaded, Chunks: chunks, Parents: parents, Embed: len(chunks)}
+	if s, ok := p.Store.(IncrementalStore); ok {
+		stored, err := s.ChunkHashes(ctx, loaded.ID)
+		if err != nil {
+			return nil, fmt.Errorf("reading stored chunks of %s: %w", loaded.ID, err)
+		}
+		preview.Embed = 0
+		for _, c := range chunks {
+			hash := c.Hash
+			if p.Enricher != nil {
+				hash = p.Enricher.hash(hash)
+			}
+			if stored[c.ID] != hash {
+				preview.Embed++
+			}
+		}
+	}
+	return preview, nil
+}
+
+// prepare runs the first stages of ingesting doc, load and then chunk, and
+// hashes the chunks and parents. It returns the loaded document unless
+// loading failed, and an error saying which stage failed. Loaded documents
+// with an invalid ExpiresKey fail too.
+func prepare(ctx context.Context, load LoadFunc, chunk ChunkFunc, doc *Document) (*Document, []Chunk, []Chunk, error) {
+	loaded, err := load(ctx, doc)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("loading failed: %w", err)
+	}
+	if err := checkExpiry(loaded); err != nil {
+		return loaded, nil, nil, err
+	}
+	chunks, parents, err := chunk(ctx, loaded)
+	if err != nil {
+		return loaded, nil, nil, fmt.Errorf("chunking failed: %w", err)
+	}
+	for _, c := range [][]Chunk{chunks, parents} {
+		for j := range c {
+			c[j].Hash = chunkHash(c[j].Text, c[j].ParentID, c[j].Metadata)
+		}
+	}
+	return loaded, chunks, parents, nil
+}
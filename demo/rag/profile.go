@@ -0,0 +1,312 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// A NamespaceProfile overrides settings of the pipeline for the namespace
+// it is stored with, so that one instance can host corpora as different as
+// short support tickets and long manuals. Its zero fields keep the
+// pipeline's settings: ChunkSize, with ChunkOverlap, replaces the chunk
+// size and overlap of its Splitter, EmbeddingModel the model of its
+// Embedder, of the same provider, and Prompt, the text of a prompt
+// template, its Prompt.
+type NamespaceProfile struct {
+	ChunkSize      int    `json:"chunk_size,omitempty"`
+	ChunkOverlap   int    `json:"chunk_overlap,omitempty"`
+	EmbeddingModel string `json:"embedding_model,omitempty"`
+	Prompt         string `json:"prompt,omitempty"`
+}
+
+// A ProfileStore stores a NamespaceProfile with each namespace that has
+// one, DefaultNamespace included. NamespaceProfile returns nil for a
+// namespace without a profile and SetNamespaceProfile removes the profile
+// if it is nil; both fail with ErrNamespaceNotFound for unknown
+// namespaces. DeleteNamespace deletes the profile with the namespace.
+type ProfileStore interface {
+	VectorStore
+	NamespaceProfile(ctx context.Context, name string) (*NamespaceProfile, error)
+	SetNamespaceProfile(ctx context.Context, name string, profile *NamespaceProfile) error
+}
+
+// profileCacheTTL is how long NamespaceProfiles keeps the profiles it read.
+const profileCacheTTL = 10 * time.Second
+
+// NamespaceProfiles applies the profiles stored in Store to the namespaces
+// of requests. Embedders returns the Embedder of an embedding model, or an
+// error if the provider has no such choice; the embedders, splitters and
+// prompts of profiles are made once and kept. Profiles read from Store are
+// kept for profileCacheTTL, so that every instance sharing it sees changes
+// within that time, and those the pipeline changes at once.
+type NamespaceProfiles struct {
+	Store     ProfileStore
+	Embedders func(model string) (Embedder, error)
+
+	mu        sync.Mutex
+	embedders map[string]Embedder
+	splitters map[[2]int]Splitter
+	prompts   map[string]*PromptTemplate
+	profiles  map[string]cachedProfile
+}
+
+// A cachedProfile is the profile of a namespace as read at readAt.
+type cachedProfile struct {
+	profile *NamespaceProfile
+	readAt  time.Time
+}
+
+// profile returns the profile of the namespace of ctx, or nil if it has
+// none or n is nil.
+func (n *NamespaceProfiles) profile(ctx context.Context) (*NamespaceProfile, error) {
+	if n == nil {
+		return nil, nil
+	}
+	name := NamespaceFrom(ctx)
+	n.mu.Lock()
+	cached, ok := n.profiles[name]
+	n.mu.Unlock()
+	if ok && time.Since(cached.readAt) < profileCacheTTL {
+		return cached.profile, nil
+	}
+	profile, err := n.Store.NamespaceProfile(ctx, name)
+	if errors.Is(err, ErrNamespaceNotFound) {
+		// The store reports unknown namespaces where it is used
+		profile, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.profiles == nil {
+		n.profiles = make(map[string]cachedProfile)
+	}
+	n.profiles[name] = cachedProfile{profile: profile, readAt: time.Now()}
+	return profile, nil
+}
+
+// forget drops the cached profile of the namespace name, which changed.
+func (n *NamespaceProfiles) forget(name string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.profiles, name)
+}
+
+// embedder returns the Embedder for the namespace of ctx, def unless its
+// profile names an embedding model.
+func (n *NamespaceProfiles) embedder(ctx context.Context, def Embedder) (Embedder, error) {
+	profile, err := n.profile(ctx)
+	if err != nil || profile == nil || profile.EmbeddingModel == "" {
+		return def, err
+	}
+	return n.modelEmbedder(profile.EmbeddingModel)
+}
+
+func (n *NamespaceProfiles) modelEmbedder(model string) (Embedder, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if e, ok := n.embedders[model]; ok {
+		return e, nil
+	}
+	if n.Embedders == nil {
+		return nil, fmt.Errorf("embedding model %s: %w", model, ErrNotSupported)
+	}
+	e, err := n.Embedders(model)
+	if err != nil {
+		return nil, fmt.Errorf("embedding model %s: %w", model, err)
+	}
+	if n.embedders == nil {
+		n.embedders = make(map[string]Embedder)
+	}
+	n.embedders[model] = e
+	return e, nil
+}
+
+// splitter returns the Splitter for the namespace of ctx, def unless its
+// profile sets a chunk size.
+func (n *NamespaceProfiles) splitter(ctx context.Context, def Splitter) (Splitter, error) {
+	profile, err := n.profile(ctx)
+	if err != nil || profile == nil || profile.ChunkSize == 0 {
+		return def, err
+	}
+	key := [2]int{profile.ChunkSize, profile.ChunkOverlap}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if s, ok := n.splitters[key]; ok {
+		return s, nil
+	}
+	s, err := NewRecursiveSplitter(profile.ChunkSize, profile.ChunkOverlap)
+	if err != nil {
+		return nil, err
+	}
+	if n.splitters == nil {
+		n.splitters = make(map[[2]int]Splitter)
+	}
+	n.splitters[key] = s
+	return s, nil
+}
+
+// prompt returns the PromptTemplate for the namespace of ctx, def unless
+// its profile has a prompt.
+func (n *NamespaceProfiles) prompt(ctx context.Context, def *PromptTemplate) (*PromptTemplate, error) {
+	profile, err := n.profile(ctx)
+	if err != nil || profile == nil || profile.Prompt == "" {
+		return def, err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if t, ok := n.prompts[profile.Prompt]; ok {
+		return t, nil
+	}
+	t, err := ParsePrompt(profile.Prompt)
+	if err != nil {
+		return nil, fmt.Errorf("prompt of namespace %s: %w", NamespaceFrom(ctx), err)
+	}
+	if n.prompts == nil {
+		n.prompts = make(map[string]*PromptTemplate)
+	}
+	n.prompts[profile.Prompt] = t
+	return t, nil
+}
+
+// ownModel reports whether the namespace of ctx has a profile of s naming
+// its own embedding model. The model a ModelStore records is that of the
+// other namespaces, and the vectors of such a namespace are not checked
+// against it: they stay comparable as SetNamespaceProfile only changes its
+// model while it is empty.
+func ownModel(ctx context.Context, s VectorStore) (bool, error) {
+	ps, ok := s.(ProfileStore)
+	if !ok {
+		return false, nil
+	}
+	profile, err := ps.NamespaceProfile(ctx, NamespaceFrom(ctx))
+	if errors.Is(err, ErrNamespaceNotFound) {
+		return false, nil
+	}
+	return profile != nil && profile.EmbeddingModel != "", err
+}
+
+// namespaceEmbedder embeds texts with the Embedder for the namespace of
+// their context, see NamespaceProfiles, so that every part of the pipeline
+// holding its Embedder embeds as the namespace's profile says.
+type namespaceEmbedder struct {
+	Embedder
+	profiles *NamespaceProfiles
+}
+
+func (e namespaceEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	embedder, err := e.profiles.embedder(ctx, e.Embedder)
+	if err != nil {
+		return nil, err
+	}
+	return embedder.Embed(ctx, texts)
+}
+
+// NamespaceProfile returns the profile stored with the namespace name, or
+// nil if it has none.
+func (p *Pipeline) NamespaceProfile(ctx context.Context, name string) (*NamespaceProfile, error) {
+	if p.Profiles == nil {
+		return nil, fmt.Errorf("namespace profiles are %w by the vector store", ErrNotSupported)
+	}
+	return p.Profiles.Store.NamespaceProfile(ctx, name)
+}
+
+// SetNamespaceProfile stores profile with the namespace name, replacing its
+// profile, or removes the profile if it is nil or has no field set. A new
+// chunk size applies to documents as they are ingested again, see
+// Rechunk, and a new prompt to the next question. The embedding model of a
+// namespace holding chunks cannot be changed, as their vectors could not
+// be compared with those of the new model: that fails with
+// ErrModelMismatch, and the documents must be deleted first.
+func (p *Pipeline) SetNamespaceProfile(ctx context.Context, name string, profile *NamespaceProfile) error {
+	if p.Profiles == nil {
+		return fmt.Errorf("namespace profiles are %w by the vector store", ErrNotSupported)
+	}
+	if err := ValidateNamespace(name); err != nil {
+		return withKind(ErrInvalidRequest, err)
+	}
+	if profile != nil && *profile == (NamespaceProfile{}) {
+		profile = nil
+	}
+	if err := p.checkProfile(profile); err != nil {
+		return err
+	}
+	nctx := WithNamespace(ctx, name)
+	current, err := p.Profiles.Store.NamespaceProfile(ctx, name)
+	if err != nil {
+		return err
+	}
+	var model, currentModel string
+	if profile != nil {
+		model = profile.EmbeddingModel
+	}
+	if current != nil {
+		currentModel = current.EmbeddingModel
+	}
+	if model != currentModel {
+		docs, err := p.Store.Documents(nctx)
+		if err != nil {
+			return err
+		}
+		if len(docs) > 0 {
+			return fmt.Errorf("%w: namespace %s holds %d documents embedded with %s; delete them to change its embedding model",
+				ErrModelMismatch, name, len(docs), cmp.Or(currentModel, p.EmbeddingModel, "the pipeline's embedding model"))
+		}
+	}
+	if err := p.Profiles.Store.SetNamespaceProfile(ctx, name, profile); err != nil {
+		return err
+	}
+	p.Profiles.forget(name)
+	if p.Answers != nil {
+		return p.Answers.invalidateNamespace(ctx, name)
+	}
+	return nil
+}
+
+// checkProfile returns an ErrInvalidRequest if profile cannot be applied.
+func (p *Pipeline) checkProfile(profile *NamespaceProfile) error {
+	if p.Profiles == nil {
+		return fmt.Errorf("namespace profiles are %w by the vector store", ErrNotSupported)
+	}
+	if profile == nil {
+		return nil
+	}
+	if profile.ChunkSize != 0 || profile.ChunkOverlap != 0 {
+		if _, err := NewRecursiveSplitter(profile.ChunkSize, profile.ChunkOverlap); err != nil {
+			return invalidRequest("invalid profile: %w", err)
+		}
+		if parent, ok := p.ParentSplitter.(*RecursiveSplitter); ok && profile.ChunkSize >= parent.ChunkSize {
+			return invalidRequest("invalid profile: chunk size must be smaller than the parent chunk size %d, got %d", parent.ChunkSize, profile.ChunkSize)
+		}
+	}
+	if profile.EmbeddingModel != "" {
+		if _, err := p.Profiles.modelEmbedder(profile.EmbeddingModel); err != nil {
+			return invalidRequest("invalid profile: %w", err)
+		}
+	}
+	if profile.Prompt != "" {
+		if _, err := ParsePrompt(profile.Prompt); err != nil {
+			return invalidRequest("invalid profile: %w", err)
+		}
+	}
+	return nil
+}
+
+// copyProfile stores the profile of the namespace name with the namespace
+// in target, which must be a ProfileStore if there is one.
+func (p *Pipeline) copyProfile(ctx context.Context, target VectorStore, name string) error {
+	profile, err := p.Profiles.profile(WithNamespace(ctx, name))
+	if err != nil || profile == nil {
+		return err
+	}
+	ps, ok := target.(ProfileStore)
+	if !ok {
+		return fmt.Errorf("namespace %s has a profile, and namespace profiles are %w by the target store", name, ErrNotSupported)
+	}
+	return ps.SetNamespaceProfile(ctx, name, profile)
+}
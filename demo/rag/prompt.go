@@ -0,0 +1,123 @@
+package rag
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+//go:embed default_prompt.tmpl
+var defaultPromptText string
+
+// DefaultPrompt is the prompt used when no template is configured.
+var DefaultPrompt = MustParsePrompt(defaultPromptText)
+
+// PromptData is the data available to prompt templates.
+type PromptData struct {
+	Question string
+	Chunks   []PromptChunk
+	History  []Message // recent messages of the session, oldest first
+	Summary  string    // summary of older messages of the session
+	Date     string    // today's date, e.g. 2024-06-01
+}
+
+// PromptChunk is a retrieved chunk as seen by prompt templates. Number is
+// the 1-based index the model should cite it by.
+type PromptChunk struct {
+	Number   int
+	DocID    string
+	Text     string
+	Score    float32
+	Metadata Metadata
+}
+
+// A PromptTemplate renders the messages sent to the LLM. It is a Go
+// text/template that must define a "system" and a "user" template, which
+// become the system and user message respectively.
+type PromptTemplate struct {
+	tmpl *template.Template
+}
+
+// ParsePrompt parses a prompt template.
+func ParsePrompt(text string) (*PromptTemplate, error) {
+	tmpl, err := template.New("prompt").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"system", "user"} {
+		if tmpl.Lookup(name) == nil {
+			return nil, fmt.Errorf("prompt template does not define %q", name)
+		}
+	}
+	return &PromptTemplate{tmpl: tmpl}, nil
+}
+
+// MustParsePrompt is like ParsePrompt but panics on error.
+func MustParsePrompt(text string) *PromptTemplate {
+	t, err := ParsePrompt(text)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// LoadPrompt reads and parses the prompt template file at path.
+func LoadPrompt(path string) (*PromptTemplate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t, err := ParsePrompt(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return t, nil
+}
+
+// Messages renders the system and user messages for data.
+func (t *PromptTemplate) Messages(data PromptData) ([]Message, error) {
+	var system, user strings.Builder
+	if err := t.tmpl.ExecuteTemplate(&system, "system", data); err != nil {
+		return nil, err
+	}
+	if err := t.tmpl.ExecuteTemplate(&user, "user", data); err != nil {
+		return nil, err
+	}
+	return []Message{
+		{Role: RoleSystem, Content: system.String()},
+		{Role: RoleUser, Content: user.String()},
+	}, nil
+}
+
+type promptDateKey struct{}
+
+// WithPromptDate returns a context with which prompts give date as today's
+// date, so that prompts built on different days can be compared.
+func WithPromptDate(ctx context.Context, date time.Time) context.Context {
+	return context.WithValue(ctx, promptDateKey{}, date)
+}
+
+// newPromptData collects the template data for a question.
+func newPromptData(ctx context.Context, question string, sources []SearchResult, history *Conversation) PromptData {
+	date, ok := ctx.Value(promptDateKey{}).(time.Time)
+	if !ok {
+		date = time.Now()
+	}
+	data := PromptData{
+		Question: question,
+		Chunks:   make([]PromptChunk, len(sources)),
+		Date:     date.Format(time.DateOnly),
+	}
+	for i, s := range sources {
+		data.Chunks[i] = PromptChunk{Number: i + 1, DocID: s.DocID, Text: escapePassage(s.Text), Score: s.Score, Metadata: s.Metadata}
+	}
+	if history != nil {
+		data.History = history.Messages
+		data.Summary = history.Summary
+	}
+	return data
+}
@@ -0,0 +1,173 @@
+package rag
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// Quantization is how MemoryStore and SQLiteStore compress the embeddings
+// they search: int8 keeps a byte per dimension and binary a bit, a quarter
+// and a thirty-second of a float32. A quantized search scores the
+// compressed embeddings and rescores its best candidates with the full
+// ones, so that it returns the same scores as an exact search and loses
+// little recall. As the full embeddings are kept, quantizing saves the
+// time of scoring them rather than their space: MemoryStore's graph holds
+// the codes instead of a copy of the embeddings, and SQLiteStore stores
+// the codes beside them. Binary quantization suits models trained for it,
+// and cosine similarity better than inner products, as it drops the
+// vectors' lengths.
+type Quantization string
+
+const (
+	QuantizationOff    Quantization = ""
+	QuantizationInt8   Quantization = "int8"
+	QuantizationBinary Quantization = "binary"
+)
+
+// ParseQuantization parses the value of VECTOR_QUANTIZATION.
+func ParseQuantization(s string) (Quantization, error) {
+	switch q := Quantization(s); q {
+	case "off":
+		return QuantizationOff, nil
+	case QuantizationOff, QuantizationInt8, QuantizationBinary:
+		return q, nil
+	}
+	return "", fmt.Errorf("unknown quantization %q", s)
+}
+
+// rescore is how many candidates per result a search with q rescores with
+// the full embeddings; binary codes need more, as they rank less
+// accurately.
+func (q Quantization) rescore() int {
+	switch q {
+	case QuantizationInt8:
+		return 4
+	case QuantizationBinary:
+		return 10
+	}
+	return 1
+}
+
+// quantized is a vector compressed by a Quantization: the int8 codes of
+// its values divided by scale, or the bits of their signs, the first value
+// in the lowest bit.
+type quantized struct {
+	codes []byte
+	scale float32
+}
+
+// quantize compresses v, which is normalized for cosine similarity.
+func (q Quantization) quantize(v []float32) quantized {
+	switch q {
+	case QuantizationInt8:
+		var peak float32
+		for _, x := range v {
+			peak = max(peak, float32(math.Abs(float64(x))))
+		}
+		codes := make([]byte, len(v))
+		if peak == 0 {
+			return quantized{codes: codes}
+		}
+		scale := peak / 127
+		for i, x := range v {
+			codes[i] = byte(int8(math.Round(float64(x / scale))))
+		}
+		return quantized{codes: codes, scale: scale}
+	case QuantizationBinary:
+		codes := make([]byte, (len(v)+7)/8)
+		for i, x := range v {
+			if x > 0 {
+				codes[i/8] |= 1 << (i % 8)
+			}
+		}
+		return quantized{codes: codes}
+	}
+	return quantized{}
+}
+
+// score estimates the similarity of the vectors of a and b: their inner
+// product for int8 codes, and for binary ones the fraction of signs they
+// share, scaled to [-1, 1].
+func (q Quantization) score(a, b quantized) float32 {
+	n := min(len(a.codes), len(b.codes))
+	if q == QuantizationBinary {
+		differ := 0
+		i := 0
+		for ; i+8 <= n; i += 8 {
+			differ += bits.OnesCount64(binary.LittleEndian.Uint64(a.codes[i:]) ^ binary.LittleEndian.Uint64(b.codes[i:]))
+		}
+		for ; i < n; i++ {
+			differ += bits.OnesCount8(a.codes[i] ^ b.codes[i])
+		}
+		if n == 0 {
+			return 0
+		}
+		return 1 - 2*float32(differ)/float32(8*n)
+	}
+	var dot int32
+	for i := range n {
+		dot += int32(int8(a.codes[i])) * int32(int8(b.codes[i]))
+	}
+	return float32(dot) * a.scale * b.scale
+}
+
+// encode packs v for storage: a byte naming q, then for int8 the scale as
+// a little-endian float32, then the codes.
+func (v quantized) encode(q Quantization) []byte {
+	if q == QuantizationInt8 {
+		b := make([]byte, 5, 5+len(v.codes))
+		b[0] = quantizationTag(q)
+		binary.LittleEndian.PutUint32(b[1:], math.Float32bits(v.scale))
+		return append(b, v.codes...)
+	}
+	return append([]byte{quantizationTag(q)}, v.codes...)
+}
+
+// decodeQuantized is the inverse of encode, reporting false if b was not
+// encoded for q.
+func decodeQuantized(q Quantization, b []byte) (quantized, bool) {
+	if len(b) == 0 || b[0] != quantizationTag(q) {
+		return quantized{}, false
+	}
+	if q == QuantizationInt8 {
+		if len(b) < 5 {
+			return quantized{}, false
+		}
+		return quantized{codes: b[5:], scale: math.Float32frombits(binary.LittleEndian.Uint32(b[1:]))}, true
+	}
+	return quantized{codes: b[1:]}, true
+}
+
+// quantizationTag is the byte encode starts the codes of q with.
+func quantizationTag(q Quantization) byte {
+	switch q {
+	case QuantizationInt8:
+		return 1
+	case QuantizationBinary:
+		return 2
+	}
+	return 0
+}
+
+// normalize returns v scaled to unit length for cosine similarity, or v
+// itself for inner products and zero vectors.
+func normalize(metric Metric, v []float32) []float32 {
+	if metric == MetricInnerProduct {
+		return v
+	}
+	var norm float64
+	for _, x := range v {
+		norm += float64(x) * float64(x)
+	}
+	if norm == 0 {
+		return v
+	}
+	scale := float32(1 / math.Sqrt(norm))
+	normalized := make([]float32, len(v))
+	for i, x := range v {
+		normalized[i] = x * scale
+	}
+	return normalized
+}
@@ -0,0 +1,132 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// quantizedStores lists the stores that quantize, by name, each opening
+// an empty store in dir.
+var quantizedStores = map[string]func(tb testing.TB, dir string, q Quantization) VectorStore{
+	"memory": func(tb testing.TB, dir string, q Quantization) VectorStore {
+		return NewMemoryStore(MetricCosine, q)
+	},
+	"sqlite": func(tb testing.TB, dir string, q Quantization) VectorStore {
+		s, err := NewSQLiteStore(context.Background(), filepath.Join(dir, "rag.db"), MetricCosine, q)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		tb.Cleanup(func() { s.Close() })
+		return s
+	},
+}
+
+// recall returns the share of the exact top k of each query that s
+// finds, the chunks being those of exact.
+func recall(tb testing.TB, s VectorStore, exact *MemoryStore, queries [][]float32, k int) float64 {
+	tb.Helper()
+	found := 0
+	for _, query := range queries {
+		want := make(map[string]bool)
+		for _, res := range exactSearch(exact, query, k) {
+			want[res.ID] = true
+		}
+		results, err := s.Search(context.Background(), query, k, nil)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		for _, res := range results {
+			if want[res.ID] {
+				found++
+			}
+		}
+	}
+	return float64(found) / float64(len(queries)*k)
+}
+
+func TestQuantizedSearchRecall(t *testing.T) {
+	r := rand.New(rand.NewPCG(9, 10))
+	chunks := randomChunks(r, "c", 2000, 96)
+	queries := make([][]float32, 50)
+	for i := range queries {
+		queries[i] = randomVector(r, 96)
+	}
+	exact := NewMemoryStore(MetricCosine, QuantizationOff)
+	if err := exact.Upsert(context.Background(), chunks); err != nil {
+		t.Fatal(err)
+	}
+	for name, open := range quantizedStores {
+		t.Run(name, func(t *testing.T) {
+			s := open(t, t.TempDir(), QuantizationInt8)
+			if err := s.Upsert(context.Background(), chunks); err != nil {
+				t.Fatal(err)
+			}
+			if got := recall(t, s, exact, queries, benchK); got < 0.95 {
+				t.Errorf("int8 recall = %.3f, want at least 0.95", got)
+			}
+		})
+	}
+}
+
+// BenchmarkQuantizedSearch searches the quantizing stores, reporting the
+// recall of their searches and the memory, or the disk space, the chunks
+// take.
+func BenchmarkQuantizedSearch(b *testing.B) {
+	const n, dimensions = 5000, 384
+	queries := make([][]float32, 50)
+	r := rand.New(rand.NewPCG(11, 12))
+	for i := range queries {
+		queries[i] = randomVector(r, dimensions)
+	}
+	exact := NewMemoryStore(MetricCosine, QuantizationOff)
+	if err := exact.Upsert(context.Background(), randomChunks(rand.New(rand.NewPCG(13, 14)), "c", n, dimensions)); err != nil {
+		b.Fatal(err)
+	}
+	for _, name := range []string{"memory", "sqlite"} {
+		for _, q := range []Quantization{QuantizationOff, QuantizationInt8, QuantizationBinary} {
+			b.Run(fmt.Sprintf("%s/%s", name, cmp.Or(string(q), "off")), func(b *testing.B) {
+				dir := b.TempDir()
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+				s := quantizedStores[name](b, dir, q)
+				// The same chunks as exact, made anew so that the heap the
+				// store takes includes their embeddings
+				chunks := randomChunks(rand.New(rand.NewPCG(13, 14)), "c", n, dimensions)
+				if err := s.Upsert(context.Background(), chunks); err != nil {
+					b.Fatal(err)
+				}
+				chunks = nil
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				i := 0
+				for b.Loop() {
+					if _, err := s.Search(context.Background(), queries[i%len(queries)], benchK, nil); err != nil {
+						b.Fatal(err)
+					}
+					i++
+				}
+				b.ReportMetric(recall(b, s, exact, queries, benchK), "recall")
+				if name == "memory" {
+					b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/(1<<20), "heap-MB")
+					return
+				}
+				// Closing checkpoints the write-ahead log into the file
+				if err := s.(*SQLiteStore).Close(); err != nil {
+					b.Fatal(err)
+				}
+				info, err := os.Stat(filepath.Join(dir, "rag.db"))
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.ReportMetric(float64(info.Size())/(1<<20), "file-MB")
+			})
+		}
+	}
+}
@@ -0,0 +1,513 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultTopK is the number of chunks retrieved when a query does not say.
+const DefaultTopK = 4
+
+// DefaultMaxK is the most chunks a query may ask for if the pipeline's MaxK
+// is zero.
+const DefaultMaxK = 100
+
+// NoContextMode selects how a pipeline answers a question for which no
+// chunk is retrieved, or none scores at least the minimum score.
+type NoContextMode string
+
+const (
+	// NoContextRefuse answers NoContextAnswer without asking the LLM, so that
+	// it cannot make up an answer.
+	NoContextRefuse NoContextMode = "refuse"
+	// NoContextGenerate asks the LLM all the same, with no passages in the
+	// prompt.
+	NoContextGenerate NoContextMode = "generate"
+)
+
+// NoContextAnswer is the answer given with NoContextRefuse.
+const NoContextAnswer = "I could not find anything relevant to this question in the documents."
+
+// QueryRequest is a question to answer. Requests with a SessionID are
+// answered in the context of earlier questions in the same session when the
+// pipeline has conversation memory.
+//
+// The remaining fields override settings of the pipeline for one request.
+// Retrieved chunks scoring below MinScore are dropped; scores are those of
+// the last retrieval stage, so with a reranker they are its relevance
+// scores and with hybrid retrieval fusion scores. MinScore overrides the
+// pipeline's MinScore. Rerank set to false skips
+// the pipeline's reranker, if it has one, and GenerationOptions apply to
+// generating the answer. AgentSteps sets the turns the pipeline's
+// RetrievalAgent is given, 0 to search for the question once.
+type QueryRequest struct {
+	Question   string       `json:"question"`
+	K          int          `json:"k,omitempty"` // DefaultTopK if zero
+	SessionID  string       `json:"session_id,omitempty"`
+	Filter     string       `json:"filter,omitempty"` // a filter expression, see ParseFilter
+	Format     AnswerFormat `json:"format,omitempty"` // FormatText if empty
+	MinScore   *float64     `json:"min_score,omitempty"`
+	Rerank     *bool        `json:"rerank,omitempty"`
+	AgentSteps *int         `json:"agent_steps,omitempty"`
+	GenerationOptions
+}
+
+// Answer is the result of a query: the generated answer and the chunks it
+// was generated from. Citations holds the 1-based positions in Sources of
+// the cited chunks. Confidence is only set for FormatJSON, and Grounding
+// only if the pipeline checks the grounding of answers. Cached is set if the
+// answer was served from the pipeline's AnswerCache and NoContext if no
+// relevant chunk was found, so that the answer is NoContextAnswer and was
+// not generated. Usage totals the
+// tokens and cost of the LLM and embedding requests made for the answer,
+// and Trace lists the tool calls of the RetrievalAgent that found its
+// sources, if one did. Route names the route of the pipeline's Router the
+// question took, if any. ID identifies the answer to Pipeline.SubmitFeedback
+// if the pipeline has a FeedbackStore and the answer has sources.
+// TimedOut lists the stages skipped as they ran out of time, see Timeouts.
+// Provider names the provider of a FallbackLLM that generated the answer,
+// if the pipeline's LLM is one and the answer was generated.
+type Answer struct {
+	ID         string       `json:"id,omitempty"`
+	Answer     string       `json:"answer"`
+	Confidence *float64     `json:"confidence,omitempty"`
+	Citations  []int        `json:"citations"`
+	Sources    []SourceRef  `json:"sources"`
+	Grounding  *Grounding   `json:"grounding,omitempty"`
+	Cached     bool         `json:"cached,omitempty"`
+	NoContext  bool         `json:"no_context,omitempty"`
+	Usage      *UsageReport `json:"usage,omitempty"`
+	Trace      []AgentStep  `json:"trace,omitempty"`
+	Route      string       `json:"route,omitempty"`
+	TimedOut   []string     `json:"timed_out,omitempty"`
+	Provider   string       `json:"provider,omitempty"`
+}
+
+// Query retrieves the chunks most relevant to the question and asks the LLM
+// to answer from them, unless the pipeline's AnswerCache has an answer or,
+// with NoContextRefuse, no chunk is found.
+func (p *Pipeline) Query(ctx context.Context, req QueryRequest) (answer *Answer, err error) {
+	ctx, span := startQuerySpan(ctx, req)
+	defer func() { endSpan(span, err) }()
+	ctx, meter := p.metered(ctx)
+	ctx, cancel, timeouts := p.timed(ctx)
+	defer cancel()
+	ctx, providers := withProviderScope(ctx)
+	var sources []SearchResult
+	var steps []AgentStep
+	var route string
+	defer func() {
+		err = timedOut(ctx, err)
+		if answer != nil {
+			answer.Usage, answer.Trace, answer.Route = meter.Report(), steps, route
+			answer.TimedOut, answer.Provider = timeouts.timedOutStages(), providers.get()
+			answer.ID = p.recordAnswer(ctx, req, sources)
+		}
+		if auditErr := p.audit(ctx, req, sources, answer, err); auditErr != nil {
+			answer, err = nil, fmt.Errorf("writing audit log: %w", auditErr)
+		}
+	}()
+	prepared, err := p.prepare(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	req, route, sources, steps = prepared.req, prepared.route, prepared.sources, prepared.steps
+	messages := prepared.messages
+	if len(sources) == 0 && p.NoContext != NoContextGenerate {
+		return p.noContext(ctx, req)
+	}
+	cached, key, err := p.cachedAnswer(ctx, req, sources)
+	if err != nil || cached != nil {
+		return cached, err
+	}
+	if req.Format == FormatJSON {
+		answer, err = p.queryJSON(ctx, req, sources, messages)
+	} else {
+		genCtx, genSpan := startGenerateSpan(WithGenerationOptions(ctx, req.GenerationOptions), messages)
+		var text string
+		text, err = p.generateStage(p.generate)(genCtx, messages, nil)
+		endSpan(genSpan, err)
+		if err != nil {
+			return nil, fmt.Errorf("generating answer: %w", err)
+		}
+		answer, err = p.finish(ctx, req, text, sources, nil, p.regenerator(req, messages, text))
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.cacheAnswer(ctx, key, answer)
+	return answer, nil
+}
+
+// QueryStream is like Query but passes the answer to onDelta as it is
+// generated. The returned Answer holds the complete text. Answers in
+// FormatJSON cannot be streamed and are passed to onDelta in one piece, as
+// are cached answers and NoContextAnswer.
+// When the pipeline's grounding check strips claims or regenerates the
+// answer, the returned Answer holds the checked text rather than the one
+// passed to onDelta. Citation markers are rewritten as the deltas are
+// passed on.
+func (p *Pipeline) QueryStream(ctx context.Context, req QueryRequest, onDelta func(string) error) (answer *Answer, err error) {
+	ctx, span := startQuerySpan(ctx, req)
+	defer func() { endSpan(span, err) }()
+	ctx, meter := p.metered(ctx)
+	ctx, cancel, timeouts := p.timed(ctx)
+	defer cancel()
+	ctx, providers := withProviderScope(ctx)
+	var sources []SearchResult
+	var steps []AgentStep
+	var route string
+	defer func() {
+		err = timedOut(ctx, err)
+		if answer != nil {
+			answer.Usage, answer.Trace, answer.Route = meter.Report(), steps, route
+			answer.TimedOut, answer.Provider = timeouts.timedOutStages(), providers.get()
+			answer.ID = p.recordAnswer(ctx, req, sources)
+		}
+		if auditErr := p.audit(ctx, req, sources, answer, err); auditErr != nil {
+			answer, err = nil, fmt.Errorf("writing audit log: %w", auditErr)
+		}
+	}()
+	prepared, err := p.prepare(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	req, route, sources, steps = prepared.req, prepared.route, prepared.sources, prepared.steps
+	messages := prepared.messages
+	// Cached answers and those without context are not generated
+	var cached *Answer
+	var key *answerKey
+	if len(sources) == 0 && p.NoContext != NoContextGenerate {
+		cached, err = p.noContext(ctx, req)
+	} else {
+		cached, key, err = p.cachedAnswer(ctx, req, sources)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil || req.Format == FormatJSON {
+		answer := cached
+		if answer == nil {
+			if answer, err = p.queryJSON(ctx, req, sources, messages); err != nil {
+				return nil, err
+			}
+			p.cacheAnswer(ctx, key, answer)
+		}
+		if err := onDelta(answer.Answer); err != nil {
+			return nil, err
+		}
+		return answer, nil
+	}
+	// Deltas have their citations rewritten as the answer will be
+	stream := onDelta
+	var w *citationWriter
+	if p.Citations != "" {
+		w = newCitationWriter(p.Citations, len(sources))
+		stream = func(delta string) error {
+			if delta = w.write(delta); delta == "" {
+				return nil
+			}
+			return onDelta(delta)
+		}
+	}
+	genCtx, genSpan := startGenerateSpan(WithGenerationOptions(ctx, req.GenerationOptions), messages)
+	text, err := p.generateStage(p.generate)(genCtx, messages, stream)
+	if err == nil && w != nil {
+		if rest := w.flush(); rest != "" {
+			err = onDelta(rest)
+		}
+	}
+	endSpan(genSpan, err)
+	if err != nil {
+		return nil, fmt.Errorf("generating answer: %w", err)
+	}
+	if answer, err = p.finish(ctx, req, text, sources, nil, p.regenerator(req, messages, text)); err != nil {
+		return nil, err
+	}
+	p.cacheAnswer(ctx, key, answer)
+	return answer, nil
+}
+
+// preparedQuery is a request ready to be answered.
+type preparedQuery struct {
+	req      QueryRequest // with the model and temperature of route
+	route    string
+	sources  []SearchResult
+	messages []Message
+	steps    []AgentStep // of the RetrievalAgent, if it retrieved sources
+}
+
+// maxK returns the most chunks a query may ask for.
+func (p *Pipeline) maxK() int {
+	return cmp.Or(p.MaxK, DefaultMaxK)
+}
+
+// checkK returns an error if req asks for more chunks than maxK allows.
+func (p *Pipeline) checkK(req QueryRequest) error {
+	if req.K > p.maxK() {
+		return fmt.Errorf("k must be at most %d, got %d", p.maxK(), req.K)
+	}
+	return nil
+}
+
+// prepare routes the question, retrieves context for it and runs the
+// Prompt stage with that and the session's conversation so far. Follow-up
+// questions are routed and searched for in their condensed form.
+func (p *Pipeline) prepare(ctx context.Context, req QueryRequest) (*preparedQuery, error) {
+	k := req.K
+	if k <= 0 {
+		k = DefaultTopK
+	}
+	k = min(k, p.maxK())
+	filter, err := ParseFilter(req.Filter)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Format.validate(); err != nil {
+		return nil, err
+	}
+	if err := req.GenerationOptions.validate(); err != nil {
+		return nil, err
+	}
+	agentSteps, err := p.agentSteps(req)
+	if err != nil {
+		return nil, err
+	}
+	var history *Conversation
+	if p.Memory != nil && req.SessionID != "" {
+		if history, err = p.Memory.Load(ctx, sessionKey(ctx, req.SessionID)); err != nil {
+			return nil, fmt.Errorf("loading conversation: %w", err)
+		}
+	}
+	inLanguage := p.languageFilter(ctx, req.Question, filter)
+	search := func(ctx context.Context, query string) ([]SearchResult, error) {
+		// Questions nothing is written about in their language are searched
+		// for in all of them
+		if inLanguage != nil {
+			if sources, err := p.retrieve(ctx, req, query, k, inLanguage); err != nil || len(sources) > 0 {
+				return sources, err
+			}
+		}
+		return p.retrieve(ctx, req, query, k, filter)
+	}
+	query := req.Question
+	// The agent reads the conversation itself
+	if agentSteps == 0 && p.Condenser != nil {
+		query = p.Condenser.Condense(ctx, req.Question, history)
+	}
+	prepared := &preparedQuery{}
+	if req, prepared.route, err = p.route(ctx, req, query); err != nil {
+		return nil, err
+	}
+	prepared.req = req
+	var sources []SearchResult
+	if agentSteps > 0 {
+		screen := func(ctx context.Context, results []SearchResult) []SearchResult {
+			return p.screen(ctx, req, filter, results)
+		}
+		sources, prepared.steps, err = p.Agent.Retrieve(ctx, req.Question, history, agentSteps, search, screen)
+	} else {
+		sources, err = search(ctx, query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	recordRetrieved(ctx, query, sources)
+	if prepared.messages, prepared.sources, err = p.promptStage()(ctx, PromptRequest{Question: req.Question, Sources: sources, History: history}); err != nil {
+		return nil, err
+	}
+	return prepared, nil
+}
+
+// buildPrompt is the default Prompt stage, rendering the pipeline's Prompt,
+// or that of the namespace's profile, with the sources that fit its Budget
+// and handling the others as its Overflow says.
+func (p *Pipeline) buildPrompt(ctx context.Context, req PromptRequest) ([]Message, []SearchResult, error) {
+	prompt := p.Prompt
+	if prompt == nil {
+		prompt = DefaultPrompt
+	}
+	prompt, err := p.Profiles.prompt(ctx, prompt)
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.Budget != nil {
+		return p.fitPrompt(ctx, prompt, req)
+	}
+	messages, err := prompt.Messages(newPromptData(ctx, req.Question, req.Sources, req.History))
+	if err != nil {
+		return nil, nil, fmt.Errorf("rendering prompt: %w", err)
+	}
+	return messages, req.Sources, nil
+}
+
+// retrieve runs the Retrieve and Rerank stages for the k chunks most
+// relevant to query with the settings of req and scans them with the
+// pipeline's Guard.
+func (p *Pipeline) retrieve(ctx context.Context, req QueryRequest, query string, k int, filter Filter) ([]SearchResult, error) {
+	retrieveCtx, retrieveSpan := tracer.Start(ctx, "rag.retrieve", trace.WithAttributes(attribute.Int("rag.k", k)))
+	rerank := req.Rerank == nil || *req.Rerank
+	var scope *rerankScope
+	if rerank {
+		retrieveCtx, scope = p.withRerankStage(retrieveCtx)
+	} else {
+		retrieveCtx = withoutRerank(retrieveCtx)
+	}
+	sources, err := p.retrieveStage()(retrieveCtx, query, k, filter)
+	if err == nil && rerank {
+		sources, err = p.rerankUnranked(retrieveCtx, scope, query, sources)
+	}
+	if minScore := p.minScore(req); err == nil && minScore != nil {
+		sources = slices.DeleteFunc(sources, func(r SearchResult) bool { return float64(r.Score) < *minScore })
+	}
+	retrieveSpan.SetAttributes(attribute.Int("rag.chunks", len(sources)))
+	endSpan(retrieveSpan, err)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving context: %w", err)
+	}
+	if p.Guard != nil {
+		sources = p.Guard.Scan(ctx, sources)
+	}
+	return sources, nil
+}
+
+// minScore returns the minimum score of the chunks retrieved for req, nil
+// if there is none.
+func (p *Pipeline) minScore(req QueryRequest) *float64 {
+	if req.MinScore == nil && p.MinScore != 0 {
+		return &p.MinScore
+	}
+	return req.MinScore
+}
+
+// screen drops the chunks of results that a retrieval with the settings of
+// req and filter would not return: those the principals of ctx may not
+// see, expired ones, those not matching filter and those scoring below
+// the minimum score. The others are scanned with the pipeline's Guard. It
+// screens the chunks found other than by retrieving, such as those the
+// RetrievalAgent reads around a passage.
+func (p *Pipeline) screen(ctx context.Context, req QueryRequest, filter Filter, results []SearchResult) []SearchResult {
+	principals, now, minScore := PrincipalsFrom(ctx), time.Now(), p.minScore(req)
+	results = slices.DeleteFunc(results, func(r SearchResult) bool {
+		return !allowed(r.Metadata, principals) || expired(r.Metadata, now) || !filter.Match(r.Metadata) ||
+			(minScore != nil && float64(r.Score) < *minScore)
+	})
+	if p.Guard != nil {
+		results = p.Guard.Scan(ctx, results)
+	}
+	return results
+}
+
+// noContext answers a question for which no chunk was found with
+// NoContextAnswer and records it in the session's memory.
+func (p *Pipeline) noContext(ctx context.Context, req QueryRequest) (*Answer, error) {
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("rag.no_context", true))
+	if p.Memory != nil && req.SessionID != "" {
+		if err := p.Memory.Append(ctx, sessionKey(ctx, req.SessionID), req.Question, NoContextAnswer); err != nil {
+			return nil, err
+		}
+	}
+	answer := &Answer{Answer: NoContextAnswer, Citations: []int{}, Sources: []SourceRef{}, NoContext: true}
+	if req.Format == FormatJSON {
+		answer.Confidence = new(float64)
+	}
+	return answer, nil
+}
+
+// ResetSession forgets the conversation of a session, so that the next
+// question in it is answered without earlier context.
+func (p *Pipeline) ResetSession(ctx context.Context, sessionID string) error {
+	if p.Memory == nil {
+		return nil
+	}
+	return p.Memory.Reset(ctx, sessionKey(ctx, sessionID))
+}
+
+// finish checks the grounding of the answer, if the pipeline has a
+// GroundingCheck, rewrites its citation markers, records the answered
+// question in the session's memory and attributes the answer to its
+// sources, marking the passages numbered in cited as cited too. regenerate
+// is passed to the check.
+func (p *Pipeline) finish(ctx context.Context, req QueryRequest, text string, sources []SearchResult, cited []int, regenerate func(context.Context, string) (string, error)) (*Answer, error) {
+	var grounding *Grounding
+	if p.Grounding != nil {
+		var err error
+		if text, grounding, err = p.Grounding.Check(ctx, text, sources, regenerate); err != nil {
+			return nil, err
+		}
+	}
+	text, sources, cited = p.cite(text, sources, cited)
+	if p.Memory != nil && req.SessionID != "" {
+		if err := p.Memory.Append(ctx, sessionKey(ctx, req.SessionID), req.Question, text); err != nil {
+			return nil, err
+		}
+	}
+	refs := sourceRefs(text, sources)
+	for _, n := range cited {
+		refs[n-1].Cited = true
+	}
+	return &Answer{Answer: text, Citations: citations(refs), Sources: refs, Grounding: grounding}, nil
+}
+
+// regenerator returns a function that generates the answer to messages
+// again, after the draft answer and feedback on it.
+func (p *Pipeline) regenerator(req QueryRequest, messages []Message, draft string) func(context.Context, string) (string, error) {
+	return func(ctx context.Context, feedback string) (_ string, err error) {
+		messages := append(slices.Clip(messages), Message{Role: RoleAssistant, Content: draft}, Message{Role: RoleUser, Content: feedback})
+		ctx, span := startGenerateSpan(WithGenerationOptions(ctx, req.GenerationOptions), messages)
+		defer func() { endSpan(span, err) }()
+		return p.generateStage(p.generate)(ctx, messages, nil)
+	}
+}
+
+// queryJSON generates an answer in FormatJSON. Sources are marked as cited
+// if the reply lists them or the answer text cites them.
+func (p *Pipeline) queryJSON(ctx context.Context, req QueryRequest, sources []SearchResult, messages []Message) (*Answer, error) {
+	genCtx, genSpan := startGenerateSpan(WithGenerationOptions(ctx, req.GenerationOptions), messages)
+	reply, err := p.generateJSON(genCtx, messages, len(sources))
+	endSpan(genSpan, err)
+	if err != nil {
+		return nil, fmt.Errorf("generating answer: %w", err)
+	}
+	regenerate := func(ctx context.Context, feedback string) (_ string, err error) {
+		messages := append(slices.Clip(messages), Message{Role: RoleAssistant, Content: reply.Answer}, Message{Role: RoleUser, Content: feedback})
+		ctx, span := startGenerateSpan(WithGenerationOptions(ctx, req.GenerationOptions), messages)
+		defer func() { endSpan(span, err) }()
+		if reply, err = p.generateJSON(ctx, messages, len(sources)); err != nil {
+			return "", err
+		}
+		return reply.Answer, nil
+	}
+	answer, err := p.finish(ctx, req, reply.Answer, sources, reply.Citations, regenerate)
+	if err != nil {
+		return nil, err
+	}
+	answer.Confidence = &reply.Confidence
+	return answer, nil
+}
+
+// startQuerySpan starts the span covering a query.
+func startQuerySpan(ctx context.Context, req QueryRequest) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{attribute.String("rag.namespace", NamespaceFrom(ctx))}
+	if req.SessionID != "" {
+		attrs = append(attrs, attribute.String("rag.session_id", req.SessionID))
+	}
+	if req.Filter != "" {
+		attrs = append(attrs, attribute.String("rag.filter", req.Filter))
+	}
+	if req.Format != "" {
+		attrs = append(attrs, attribute.String("rag.format", string(req.Format)))
+	}
+	return tracer.Start(ctx, "rag.query", trace.WithAttributes(attrs...))
+}
+
+// startGenerateSpan starts the span covering the generation of an answer.
+func startGenerateSpan(ctx context.Context, messages []Message) (context.Context, trace.Span) {
+	return tracer.Start(generating(ctx), "rag.generate", trace.WithAttributes(attribute.Int("rag.messages", len(messages))))
+}
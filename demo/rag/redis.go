@@ -0,0 +1,432 @@
+package rag
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultSessionTTL is how long a RedisConversationStore keeps a session
+// after its last question.
+const DefaultSessionTTL = 24 * time.Hour
+
+// maxIdleRedisConns is how many connections a RedisClient keeps open
+// between commands.
+const maxIdleRedisConns = 16
+
+// RedisClient sends commands to a Redis server over RESP, the Redis wire
+// protocol. It implements only what the pipeline needs: commands are sent
+// as strings and replies come back as string, int64, []any or nil, with
+// error replies as errors. Connections are opened on first use and kept
+// for the next command, so a client may be created before the server is
+// up and is safe for concurrent use.
+type RedisClient struct {
+	addr       string
+	serverName string // for TLS; empty without it
+	username   string
+	password   string
+	db         int
+
+	mu   sync.Mutex
+	idle []*redisConn
+}
+
+// redisConn is a connection with a buffered reader for its replies.
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// redisError is an error reply.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// NewRedisClient creates a client for the server at rawURL, which has the
+// form redis://[[user]:password@]host[:port][/db], or rediss:// for TLS.
+// The port defaults to 6379 and the database to 0.
+func NewRedisClient(rawURL string) (*RedisClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid REDIS_URL %q: want a URL like redis://localhost:6379/0", rawURL)
+	}
+	c := &RedisClient{addr: u.Host}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.Scheme == "rediss" {
+		c.serverName = u.Hostname()
+	}
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil || c.db < 0 {
+			return nil, fmt.Errorf("invalid REDIS_URL %q: the path must be a database number", rawURL)
+		}
+	}
+	return c, nil
+}
+
+// redisClients holds the clients of sharedRedisClient by URL.
+var redisClients = struct {
+	sync.Mutex
+	m map[string]*RedisClient
+}{m: make(map[string]*RedisClient)}
+
+// sharedRedisClient returns the one client used for rawURL throughout the
+// process, so that its components share connections.
+func sharedRedisClient(rawURL string) (*RedisClient, error) {
+	redisClients.Lock()
+	defer redisClients.Unlock()
+	if c, ok := redisClients.m[rawURL]; ok {
+		return c, nil
+	}
+	c, err := NewRedisClient(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	redisClients.m[rawURL] = c
+	return c, nil
+}
+
+// Do sends a command and returns its reply.
+func (c *RedisClient) Do(ctx context.Context, args ...string) (any, error) {
+	replies, err := c.Pipeline(ctx, [][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	if err, ok := replies[0].(redisError); ok {
+		return nil, err
+	}
+	return replies[0], nil
+}
+
+// Pipeline sends commands in one round trip and returns their replies, in
+// order. Error replies are returned among the replies as errors.
+func (c *RedisClient) Pipeline(ctx context.Context, cmds [][]string) (_ []any, err error) {
+	conn, err := c.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Time{})
+	}
+	// Cancelling ctx interrupts reads and writes in progress
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+	defer func() {
+		if !stop() || err != nil {
+			conn.Close()
+			return
+		}
+		c.release(conn)
+	}()
+	replies, err := conn.roundTrip(cmds)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	return replies, nil
+}
+
+// conn returns an idle connection, or a new one.
+func (c *RedisClient) conn(ctx context.Context) (*redisConn, error) {
+	c.mu.Lock()
+	if n := len(c.idle); n > 0 {
+		conn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return conn, nil
+	}
+	c.mu.Unlock()
+	var d net.Dialer
+	raw, err := d.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	if c.serverName != "" {
+		raw = tls.Client(raw, &tls.Config{ServerName: c.serverName})
+	}
+	conn := &redisConn{Conn: raw, r: bufio.NewReader(raw)}
+	var setup [][]string
+	switch {
+	case c.username != "":
+		setup = append(setup, []string{"AUTH", c.username, c.password})
+	case c.password != "":
+		setup = append(setup, []string{"AUTH", c.password})
+	}
+	if c.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	if len(setup) == 0 {
+		return conn, nil
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	replies, err := conn.roundTrip(setup)
+	if err == nil {
+		for _, r := range replies {
+			if e, ok := r.(redisError); ok {
+				err = e
+				break
+			}
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("redis: connecting to %s: %w", c.addr, err)
+	}
+	return conn, nil
+}
+
+// release keeps a connection for the next command.
+func (c *RedisClient) release(conn *redisConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.idle) >= maxIdleRedisConns {
+		conn.Close()
+		return
+	}
+	c.idle = append(c.idle, conn)
+}
+
+// roundTrip writes cmds and reads one reply for each.
+func (conn *redisConn) roundTrip(cmds [][]string) ([]any, error) {
+	var b strings.Builder
+	for _, args := range cmds {
+		fmt.Fprintf(&b, "*%d\r\n", len(args))
+		for _, a := range args {
+			fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+		}
+	}
+	if _, err := io.WriteString(conn, b.String()); err != nil {
+		return nil, err
+	}
+	replies := make([]any, len(cmds))
+	for i := range replies {
+		reply, err := readRedisReply(conn.r)
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+// readRedisReply reads a RESP2 reply.
+func readRedisReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return redisError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil // a missing value
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil // a missing value
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readRedisReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unexpected reply %q", line)
+}
+
+// RedisConversationStore keeps conversations in Redis, so that replicas of
+// a server share sessions. A session expires TTL after its last question,
+// or never if TTL is zero.
+type RedisConversationStore struct {
+	Client *RedisClient
+	TTL    time.Duration
+}
+
+func (s *RedisConversationStore) Get(ctx context.Context, sessionID string) (*Conversation, error) {
+	reply, err := s.Client.Do(ctx, "GET", "rag:session:"+sessionID)
+	if err != nil {
+		return nil, err
+	}
+	var c Conversation
+	if data, ok := reply.(string); ok {
+		if err := json.Unmarshal([]byte(data), &c); err != nil {
+			return nil, fmt.Errorf("redis: session %s: %w", sessionID, err)
+		}
+	}
+	return &c, nil
+}
+
+func (s *RedisConversationStore) Save(ctx context.Context, sessionID string, c *Conversation) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	args := []string{"SET", "rag:session:" + sessionID, string(data)}
+	if s.TTL > 0 {
+		args = append(args, "PX", strconv.FormatInt(s.TTL.Milliseconds(), 10))
+	}
+	_, err = s.Client.Do(ctx, args...)
+	return err
+}
+
+func (s *RedisConversationStore) Delete(ctx context.Context, sessionID string) error {
+	_, err := s.Client.Do(ctx, "DEL", "rag:session:"+sessionID)
+	return err
+}
+
+// RedisEmbeddingCache keeps embeddings in Redis, so that replicas of a
+// server share them. Embeddings never expire; configure the server with a
+// maxmemory-policy such as allkeys-lru to bound the memory they take.
+type RedisEmbeddingCache struct {
+	Client *RedisClient
+}
+
+// redisBatch is how many keys a RedisEmbeddingCache reads or writes per
+// command.
+const redisBatch = 500
+
+func (c *RedisEmbeddingCache) Get(ctx context.Context, keys [][]byte) (map[string][]float32, error) {
+	found := make(map[string][]float32)
+	for start := 0; start < len(keys); start += redisBatch {
+		part := keys[start:min(start+redisBatch, len(keys))]
+		args := []string{"MGET"}
+		for _, k := range part {
+			args = append(args, redisEmbeddingKey(k))
+		}
+		reply, err := c.Client.Do(ctx, args...)
+		if err != nil {
+			return nil, err
+		}
+		values, _ := reply.([]any)
+		for i, v := range values {
+			if data, ok := v.(string); ok && i < len(part) {
+				found[string(part[i])] = decodeVector([]byte(data))
+			}
+		}
+	}
+	return found, nil
+}
+
+func (c *RedisEmbeddingCache) Put(ctx context.Context, embeddings map[string][]float32) error {
+	args := []string{"MSET"}
+	flush := func() error {
+		if len(args) == 1 {
+			return nil
+		}
+		_, err := c.Client.Do(ctx, args...)
+		args = args[:1]
+		return err
+	}
+	for key, v := range embeddings {
+		args = append(args, redisEmbeddingKey([]byte(key)), string(encodeVector(v)))
+		if len(args) > 2*redisBatch {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+func redisEmbeddingKey(key []byte) string {
+	return "rag:embedding:" + hex.EncodeToString(key)
+}
+
+// RedisRateLimiter shares rate limits between processes through Redis. It
+// counts requests per key in fixed windows, so that at most the given rate
+// is admitted on average while bursts of up to one window's worth can
+// occur at window boundaries.
+type RedisRateLimiter struct {
+	Client *RedisClient
+}
+
+// Wait blocks until a request under key may be sent at rate requests per
+// second.
+func (l *RedisRateLimiter) Wait(ctx context.Context, key string, rate float64) error {
+	// A window admits a whole number of requests, one at least
+	n := max(1, math.Floor(rate))
+	window := time.Duration(n / rate * float64(time.Second))
+	expiry := strconv.FormatInt(max(2*window.Milliseconds(), 1000), 10)
+	for {
+		slot := time.Now().UnixNano() / int64(window)
+		k := fmt.Sprintf("rag:ratelimit:%s:%d", key, slot)
+		replies, err := l.Client.Pipeline(ctx, [][]string{{"INCR", k}, {"PEXPIRE", k, expiry}})
+		if err != nil {
+			return err
+		}
+		count, ok := replies[0].(int64)
+		if !ok {
+			return fmt.Errorf("redis: unexpected reply to INCR: %v", replies[0])
+		}
+		if float64(count) <= n {
+			return nil
+		}
+		timer := time.NewTimer(time.Until(time.Unix(0, (slot+1)*int64(window))))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// waitShared waits for the shared rate limit of a RetryTransport. If Redis
+// fails, the request goes ahead with only the transport's own limit, and
+// the error is recorded on the span of ctx.
+func (l *RedisRateLimiter) waitShared(ctx context.Context, key string, rate float64) error {
+	err := l.Wait(ctx, key, rate)
+	if err != nil && ctx.Err() == nil {
+		trace.SpanFromContext(ctx).RecordError(fmt.Errorf("shared rate limit: %w", err))
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,187 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ReindexProgress is reported by Reindex after each batch of documents:
+// Documents of Total documents and Chunks chunks have been written to the
+// new store.
+type ReindexProgress struct {
+	Namespace string `json:"namespace"`
+	Documents int    `json:"documents"`
+	Total     int    `json:"total"`
+	Chunks    int    `json:"chunks"`
+}
+
+// Reindex copies the whole index into target, every namespace with its
+// profile and documents, their sources and parents where both stores keep
+// them, with every chunk embedded again by the pipeline's Embedder, and its
+// SparseEmbedder if it has one. After the embedding model changed, this
+// moves the index to vectors of the new model without loading or chunking
+// the documents again; the stored vectors, which may have other
+// dimensions, are only read. The texts embedded are those ingestion
+// embeds, the enrichment of chunks included. Documents go through the
+// Embed stage in batches of BatchSize times Concurrency chunks, after each
+// of which onProgress, if not nil, is called. With a KnowledgeGraph, the
+// graph of the chunks it does not hold yet is extracted as they are
+// copied. The pipeline's store must be a ChunkStore; it is left
+// unchanged, and switching to target is up to the caller.
+func (p *Pipeline) Reindex(ctx context.Context, target VectorStore, onProgress func(ReindexProgress)) (_ SnapshotStats, err error) {
+	ctx, span := tracer.Start(ctx, "rag.reindex")
+	defer func() { endSpan(span, err) }()
+	var stats SnapshotStats
+	s, ok := p.Store.(ChunkStore)
+	if !ok {
+		return stats, fmt.Errorf("listing chunks is %w by the vector store", ErrNotSupported)
+	}
+	namespaces, err := s.Namespaces(ctx)
+	if err != nil {
+		return stats, err
+	}
+	docs := make([][]DocumentInfo, len(namespaces))
+	progress := ReindexProgress{}
+	for i, ns := range namespaces {
+		if docs[i], err = s.Documents(WithNamespace(ctx, ns.Name)); err != nil {
+			return stats, err
+		}
+		progress.Total += len(docs[i])
+	}
+	batch := cmp.Or(p.BatchSize, DefaultBatchSize) * cmp.Or(p.Concurrency, DefaultConcurrency)
+	for i, ns := range namespaces {
+		nctx := WithNamespace(ctx, ns.Name)
+		if ns.Name != DefaultNamespace {
+			if err := target.CreateNamespace(ctx, ns.Name); err != nil && !errors.Is(err, ErrNamespaceExists) {
+				return stats, err
+			}
+		}
+		if err := p.copyProfile(ctx, target, ns.Name); err != nil {
+			return stats, err
+		}
+		progress.Namespace = ns.Name
+		var pending []*snapshotDocument
+		chunks := 0
+		flush := func() error {
+			if err := p.reindexDocuments(nctx, target, pending); err != nil {
+				return err
+			}
+			progress.Documents += len(pending)
+			progress.Chunks += chunks
+			stats.Documents += len(pending)
+			stats.Chunks += chunks
+			pending, chunks = pending[:0], 0
+			if onProgress != nil {
+				onProgress(progress)
+			}
+			return nil
+		}
+		for _, info := range docs[i] {
+			if err := ctx.Err(); err != nil {
+				return stats, err
+			}
+			doc, err := p.exportDocument(nctx, s, info.ID)
+			if err != nil {
+				return stats, fmt.Errorf("reading %s: %w", info.ID, err)
+			}
+			pending = append(pending, doc)
+			if chunks += len(doc.Chunks); chunks >= batch {
+				if err := flush(); err != nil {
+					return stats, err
+				}
+			}
+		}
+		if len(pending) > 0 {
+			if err := flush(); err != nil {
+				return stats, err
+			}
+		}
+		stats.Namespaces++
+	}
+	span.SetAttributes(attribute.Int("rag.documents", stats.Documents), attribute.Int("rag.chunks", stats.Chunks))
+	p.Webhooks.send(ctx, EventIndexReindexed, stats)
+	return stats, nil
+}
+
+// reindexDocuments embeds the chunks of docs again, extracts the graph of
+// those the KnowledgeGraph does not hold yet and writes the documents to
+// target.
+func (p *Pipeline) reindexDocuments(ctx context.Context, target VectorStore, docs []*snapshotDocument) error {
+	var texts []string
+	for _, doc := range docs {
+		for _, c := range doc.Chunks {
+			text := c.Text
+			if p.Enricher != nil && c.Metadata[summaryKey] != "" {
+				text = p.Enricher.text(&c)
+			}
+			texts = append(texts, text)
+		}
+	}
+	embedCtx, embedSpan := tracer.Start(ctx, "rag.embed", trace.WithAttributes(attribute.Int("rag.texts", len(texts))))
+	vectors, err := p.embedStage()(embedCtx, texts)
+	if err == nil && len(vectors) != len(texts) {
+		err = fmt.Errorf("embedding returned %d vectors for %d texts", len(vectors), len(texts))
+	}
+	var sparse []*SparseVector
+	if err == nil && p.SparseEmbedder != nil {
+		sparse, err = p.embedSparseBatches(embedCtx, texts)
+	}
+	endSpan(embedSpan, err)
+	if err != nil {
+		return fmt.Errorf("embedding: %w", err)
+	}
+	graphs, err := p.snapshotGraphs(ctx, docs)
+	if err != nil {
+		return err
+	}
+	i := 0
+	for k, doc := range docs {
+		for j := range doc.Chunks {
+			doc.Chunks[j].Embedding, doc.Chunks[j].Sparse = vectors[i], nil
+			if sparse != nil {
+				doc.Chunks[j].Sparse = sparse[i]
+			}
+			i++
+		}
+		if err := writeDocument(ctx, target, p.EmbeddingModel, doc); err != nil {
+			return fmt.Errorf("writing %s: %w", doc.ID, err)
+		}
+		if p.Graph != nil {
+			if err := p.Graph.replace(ctx, doc.ID, doc.Chunks, graphs[k]); err != nil {
+				return fmt.Errorf("storing graph of %s: %w", doc.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// writeDocument writes a document, embedded by the model named model, to a
+// store that does not hold it yet.
+func writeDocument(ctx context.Context, s VectorStore, model string, doc *snapshotDocument) error {
+	if err := recordModel(ctx, s, model, doc.Chunks); err != nil {
+		return err
+	}
+	if err := s.Upsert(ctx, doc.Chunks); err != nil {
+		return err
+	}
+	if ss, ok := s.(SourceStore); ok && doc.Source != nil {
+		if err := ss.PutSource(ctx, doc.Source); err != nil {
+			return err
+		}
+	}
+	if len(doc.Parents) > 0 {
+		ps, ok := s.(ParentStore)
+		if !ok {
+			return fmt.Errorf("keeping parent chunks is %w by the vector store", ErrNotSupported)
+		}
+		if err := ps.ReplaceParents(ctx, doc.ID, doc.Parents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package rag
+
+import (
+	"net/http"
+	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+)
+
+// reloadable lists the settings Reconfigure applies, by environment
+// variable: those of retrieval and generation that need no store, provider
+// or file of their own. The others only take effect in a new pipeline.
+var reloadable = map[string]bool{
+	"RETRIEVER":           true,
+	"HYBRID_WEIGHT":       true,
+	"QUERY_VARIANTS":      true,
+	"MIN_SCORE":           true,
+	"MAX_K":               true,
+	"AGENT_STEPS":         true,
+	"HYDE":                true,
+	"CONDENSE_QUERIES":    true,
+	"MMR_LAMBDA":          true,
+	"COMPRESSION":         true,
+	"COMPRESSION_RATIO":   true,
+	"RERANKER":            true,
+	"RERANK_URL":          true,
+	"RERANK_API_KEY":      true,
+	"RERANK_MODEL":        true,
+	"RERANK_CANDIDATES":   true,
+	"PROMPT_TEMPLATE":     true,
+	"CONTEXT_TOKENS":      true,
+	"CONTEXT_OVERFLOW":    true,
+	"SUMMARIZE_TOKENS":    true,
+	"GROUNDING":           true,
+	"INJECTION_GUARD":     true,
+	"CITATIONS":           true,
+	"NO_CONTEXT":          true,
+	"ROUTER":              true,
+	"ROUTES":              true,
+	"FEEDBACK_WEIGHT":     true,
+	"FEEDBACK_SIMILARITY": true,
+	"RECENCY_WEIGHT":      true,
+	"RECENCY_HALF_LIFE":   true,
+	"RECENCY_FIELDS":      true,
+	"SELF_QUERY":          true,
+	"GRAPH_HOPS":          true,
+	"GRAPH_CHUNKS":        true,
+	"REQUEST_TIMEOUT":     true,
+	"EMBED_TIMEOUT":       true,
+	"RETRIEVE_TIMEOUT":    true,
+	"RERANK_TIMEOUT":      true,
+	"GENERATE_TIMEOUT":    true,
+}
+
+// Reconfigure returns a copy of p that answers questions with the query
+// settings of cfg: its retrieval strategy and the retrievers wrapping it,
+// such as reranking, HyDE, self-querying or MMR, query condensation, its
+// MIN_SCORE and MAX_K, the prompt template, read again from its file, the context
+// budget, grounding, injection guard, citations, no-context mode, router and
+// timeouts. The copy shares the stores, providers and ingestion settings of
+// p, which keeps working as it was; the other settings of cfg are ignored.
+// Stages added with Use are kept.
+func (p *Pipeline) Reconfigure(cfg Config) (*Pipeline, error) {
+	q := *p
+	q.Middleware = slices.Clone(p.Middleware)
+	if err := q.configure(cfg); err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+
+// A Reloader holds the pipeline a server answers with and replaces it with
+// one reconfigured by Reload while requests are served. Requests in flight
+// finish with the pipeline they started with.
+type Reloader struct {
+	mu      sync.Mutex // serializes reloads
+	cfg     Config     // the settings of the current pipeline
+	current atomic.Pointer[Pipeline]
+}
+
+// NewReloader returns a Reloader serving p, which was built from cfg.
+func NewReloader(p *Pipeline, cfg Config) *Reloader {
+	r := &Reloader{cfg: cfg}
+	r.current.Store(p)
+	return r
+}
+
+// Pipeline returns the current pipeline.
+func (r *Reloader) Pipeline() *Pipeline { return r.current.Load() }
+
+// Reload replaces the current pipeline with one reconfigured by cfg, see
+// Reconfigure, reading its prompt template and routes again even if their
+// paths did not change. It returns the environment variables of the
+// settings that changed and were applied, and of those that changed but
+// need a restart to take effect. If cfg is invalid, the current pipeline is
+// kept.
+func (r *Reloader) Reload(cfg Config) (applied, ignored []string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// The ignored settings keep their values, so that nothing reconfigured
+	// is derived from them, such as the tokenizer of a new CHAT_MODEL, and
+	// they are reported until the restart
+	next := cfg
+	nextSettings, currentSettings := next.settings(), r.cfg.settings()
+	for i, s := range nextSettings {
+		to, from := reflect.ValueOf(s.dst).Elem(), reflect.ValueOf(currentSettings[i].dst).Elem()
+		if to.Equal(from) {
+			continue
+		}
+		if reloadable[s.env] {
+			applied = append(applied, s.env)
+			continue
+		}
+		ignored = append(ignored, s.env)
+		to.Set(from)
+	}
+	p, err := r.Pipeline().Reconfigure(next)
+	if err != nil {
+		return nil, nil, err
+	}
+	r.cfg = next
+	r.current.Store(p)
+	return applied, ignored, nil
+}
+
+// Handler returns the HTTP API of NewHandler, served by the current
+// pipeline.
+func (r *Reloader) Handler(jobs *JobQueue) http.Handler {
+	return newHandler(r.Pipeline, jobs)
+}
+
+// RegisterGRPC registers the service of RegisterGRPC on s, served by the
+// current pipeline.
+func (r *Reloader) RegisterGRPC(s grpc.ServiceRegistrar) {
+	registerGRPC(s, r.Pipeline)
+}
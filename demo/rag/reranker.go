@@ -0,0 +1,152 @@
+package rag
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultRerankCandidates is how many first-stage results are rescored.
+const DefaultRerankCandidates = 50
+
+// A Reranker rescores retrieved chunks against the query, typically with a
+// cross-encoder that reads query and chunk together, and returns them best
+// first.
+type Reranker interface {
+	Rerank(ctx context.Context, query string, results []SearchResult) ([]SearchResult, error)
+}
+
+// NewReranker returns the Reranker selected by cfg.Reranker, or nil if
+// reranking is disabled.
+func NewReranker(cfg Config) (Reranker, error) {
+	switch cfg.Reranker {
+	case "", "none":
+		return nil, nil
+	case "http":
+		if cfg.RerankURL == "" {
+			return nil, fmt.Errorf("RERANK_URL must be set for the http reranker")
+		}
+		return &HTTPReranker{URL: cfg.RerankURL, APIKey: cfg.RerankAPIKey, Model: cfg.RerankModel, Client: newProviderClient(cfg)}, nil
+	}
+	return nil, fmt.Errorf("unknown reranker %q", cfg.Reranker)
+}
+
+// RerankRetriever asks Retriever for Candidates results and keeps the k
+// that Reranker scores highest. Retrieving for a Pipeline, it runs Reranker
+// as the pipeline's Rerank stage.
+type RerankRetriever struct {
+	Retriever  Retriever
+	Reranker   Reranker
+	Candidates int // DefaultRerankCandidates if zero
+}
+
+// withoutRerank returns a context in which RerankRetriever passes the
+// results of Retriever through unchanged.
+func withoutRerank(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipRerankKey{}, true)
+}
+
+type skipRerankKey struct{}
+
+func (r *RerankRetriever) Retrieve(ctx context.Context, query string, k int, filter Filter) ([]SearchResult, error) {
+	if skip, _ := ctx.Value(skipRerankKey{}).(bool); skip {
+		return r.Retriever.Retrieve(ctx, query, k, filter)
+	}
+	candidates := r.Candidates
+	if candidates <= 0 {
+		candidates = DefaultRerankCandidates
+	}
+	results, err := r.Retriever.Retrieve(ctx, query, max(candidates, k), filter)
+	if err != nil || len(results) == 0 {
+		return results, err
+	}
+	rerankCtx, span := tracer.Start(ctx, "rag.rerank", trace.WithAttributes(attribute.Int("rag.candidates", len(results))))
+	reranked, err := rerankStageFor(ctx, r.Reranker.Rerank)(rerankCtx, query, results)
+	endSpan(span, err)
+	switch {
+	case isTimeout(err, StageRerank):
+		// Keep the order of retrieval
+		skipTimedOut(ctx, StageRerank)
+	case err != nil:
+		return nil, fmt.Errorf("reranking: %w", err)
+	default:
+		results = reranked
+		recordScores(ctx, rerankScore, results)
+	}
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results, nil
+}
+
+// HTTPReranker calls a Cohere-compatible /rerank endpoint, as offered by
+// Cohere, Jina and Voyage, or by local cross-encoder servers such as
+// Infinity. URL is the full endpoint URL.
+type HTTPReranker struct {
+	URL    string
+	APIKey string
+	Model  string
+	Client *http.Client // http.DefaultClient if nil
+}
+
+func (r *HTTPReranker) Rerank(ctx context.Context, query string, results []SearchResult) ([]SearchResult, error) {
+	documents := make([]string, len(results))
+	for i, res := range results {
+		documents[i] = res.Text
+	}
+	body, err := json.Marshal(map[string]any{
+		"model":     r.Model,
+		"query":     query,
+		"documents": documents,
+		"top_n":     len(documents),
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+r.APIKey)
+	}
+	resp, err := cmp.Or(r.Client, http.DefaultClient).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var msg bytes.Buffer
+		msg.ReadFrom(resp.Body)
+		return nil, fmt.Errorf("rerank: %s: %s", resp.Status, strings.TrimSpace(msg.String()))
+	}
+
+	var res struct {
+		Results []struct {
+			Index          int     `json:"index"`
+			RelevanceScore float32 `json:"relevance_score"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("rerank: decoding response: %w", err)
+	}
+	reranked := make([]SearchResult, 0, len(res.Results))
+	for _, scored := range res.Results {
+		if scored.Index < 0 || scored.Index >= len(results) {
+			return nil, fmt.Errorf("rerank: result index %d out of range", scored.Index)
+		}
+		result := results[scored.Index]
+		result.Score = scored.RelevanceScore
+		reranked = append(reranked, result)
+	}
+	sortResults(reranked)
+	return reranked, nil
+}
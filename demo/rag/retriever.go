@@ -0,0 +1,75 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// A Retriever finds the k chunks most relevant to a query among those
+// matching filter, best first.
+type Retriever interface {
+	Retrieve(ctx context.Context, query string, k int, filter Filter) ([]SearchResult, error)
+}
+
+// VectorRetriever embeds the query and searches a VectorStore with it. If
+// the store is a ModelStore, queries fail with ErrModelMismatch unless
+// their embeddings can be compared with those stored, made by the model
+// named Model if it is set.
+type VectorRetriever struct {
+	Embedder Embedder
+	Store    VectorStore
+	Model    string
+}
+
+func (r *VectorRetriever) Retrieve(ctx context.Context, query string, k int, filter Filter) ([]SearchResult, error) {
+	vector, err := embedQuery(ctx, r.Embedder, denseQuery(ctx, query))
+	if err != nil {
+		return nil, fmt.Errorf("embedding query: %w", err)
+	}
+	if err := checkQuery(ctx, r.Store, r.Model, vector); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	results, err := r.Store.Search(ctx, vector, k, filter)
+	benchTimed(ctx, StageSearch, start)
+	recordScores(ctx, denseScore, results)
+	return results, err
+}
+
+// NewRetriever returns the Retriever selected by cfg.Retriever: vector,
+// hybrid or sparse. sparse is needed by the sparse retriever and makes the
+// hybrid retriever fuse vector search with sparse search rather than
+// keyword search; store must then be a SparseStore. keywords is only used
+// by the hybrid retriever without sparse, and not even by that if store is
+// a HybridSearcher.
+func NewRetriever(cfg Config, embedder Embedder, sparse SparseEmbedder, store VectorStore, keywords *KeywordIndex) (Retriever, error) {
+	dense := &VectorRetriever{Embedder: embedder, Store: store, Model: embeddingModel(cfg)}
+	var sparseRetriever *SparseRetriever
+	if sparse != nil {
+		ss, ok := store.(SparseStore)
+		if !ok {
+			return nil, errNoSparseStore
+		}
+		sparseRetriever = &SparseRetriever{Embedder: sparse, Store: ss}
+	}
+	switch cfg.Retriever {
+	case "", "vector":
+		return dense, nil
+	case "sparse":
+		if sparseRetriever == nil {
+			return nil, errors.New("RETRIEVER=sparse needs a SPARSE_EMBEDDER")
+		}
+		return sparseRetriever, nil
+	case "hybrid":
+		if sparseRetriever != nil {
+			return &HybridRetriever{Dense: dense, Sparse: sparseRetriever, Weight: cfg.HybridWeight}, nil
+		}
+		if hs, ok := store.(HybridSearcher); ok {
+			return &NativeHybridRetriever{Embedder: embedder, Store: hs, Model: embeddingModel(cfg), Weight: cfg.HybridWeight}, nil
+		}
+		return &HybridRetriever{Dense: dense, Sparse: keywords, Weight: cfg.HybridWeight}, nil
+	}
+	return nil, fmt.Errorf("unknown retriever %q", cfg.Retriever)
+}
@@ -0,0 +1,109 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// rrfK dampens the contribution of top ranks in reciprocal rank fusion; 60 is
+// the constant from the original RRF paper.
+const rrfK = 60
+
+// HybridRetriever runs a dense and a sparse (keyword or sparse vector)
+// retriever in parallel and merges their rankings with weighted reciprocal
+// rank fusion. Weight is the share given to the dense ranking, from 0
+// (sparse only) to 1 (dense only). Each retriever is asked for Candidates
+// results, 4*k by default.
+type HybridRetriever struct {
+	Dense      Retriever
+	Sparse     Retriever
+	Weight     float64
+	Candidates int
+}
+
+func (r *HybridRetriever) Retrieve(ctx context.Context, query string, k int, filter Filter) ([]SearchResult, error) {
+	candidates := r.Candidates
+	if candidates <= 0 {
+		candidates = 4 * k
+	}
+	var dense, sparse []SearchResult
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() (err error) {
+		dense, err = r.Dense.Retrieve(gctx, query, candidates, filter)
+		if isTimeout(err, StageEmbed) {
+			// Rank the keyword results alone
+			skipTimedOut(ctx, StageEmbed)
+			dense, err = nil, nil
+		}
+		return err
+	})
+	g.Go(func() (err error) {
+		sparse, err = r.Sparse.Retrieve(gctx, query, candidates, filter)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return fuseRankings(k, []float64{r.Weight, 1 - r.Weight}, dense, sparse), nil
+}
+
+// A HybridSearcher is a VectorStore with its own hybrid search, combining
+// keyword search for query with vector search for vector. weight is the
+// share of the vector search, as for HybridRetriever.
+type HybridSearcher interface {
+	VectorStore
+	HybridSearch(ctx context.Context, query string, vector []float32, k int, weight float64, filter Filter) ([]SearchResult, error)
+}
+
+// NativeHybridRetriever embeds the query and runs the hybrid search of a
+// HybridSearcher with it, in place of a HybridRetriever. Its embedding is
+// checked against a ModelStore as by VectorRetriever.
+type NativeHybridRetriever struct {
+	Embedder Embedder
+	Store    HybridSearcher
+	Model    string
+	Weight   float64
+}
+
+func (r *NativeHybridRetriever) Retrieve(ctx context.Context, query string, k int, filter Filter) ([]SearchResult, error) {
+	vector, err := embedQuery(ctx, r.Embedder, denseQuery(ctx, query))
+	if err != nil {
+		return nil, fmt.Errorf("embedding query: %w", err)
+	}
+	if err := checkQuery(ctx, r.Store, r.Model, vector); err != nil {
+		return nil, err
+	}
+	defer benchTimed(ctx, StageSearch, time.Now())
+	return r.Store.HybridSearch(ctx, query, vector, k, r.Weight, filter)
+}
+
+// fuseRankings merges ranked lists by summing weight/(rrfK+rank) for every
+// list a chunk appears in, and returns the top k by fused score.
+func fuseRankings(k int, weights []float64, rankings ...[]SearchResult) []SearchResult {
+	fused := make(map[string]*SearchResult)
+	var order []string
+	for i, ranking := range rankings {
+		for rank, result := range ranking {
+			score := float32(weights[i] / float64(rrfK+rank+1))
+			if f, ok := fused[result.ID]; ok {
+				f.Score += score
+				continue
+			}
+			result.Score = score
+			fused[result.ID] = &result
+			order = append(order, result.ID)
+		}
+	}
+	results := make([]SearchResult, len(order))
+	for i, id := range order {
+		results[i] = *fused[id]
+	}
+	sortResults(results)
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results
+}
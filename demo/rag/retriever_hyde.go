@@ -0,0 +1,66 @@
+package rag
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const hydePrompt = `You write passages for a document retrieval system.
+Write a short passage, of one paragraph, that answers the user's question the way a reference document would. State specifics plausibly even if you do not know them; the passage is only used to search for real documents.
+Reply with the passage and nothing else.`
+
+// HyDERetriever implements hypothetical document embeddings: it asks LLM to
+// draft a passage answering the query and has Retriever search for the
+// query, with the embedding of the query followed by the draft. A draft
+// answer resembles the passages that answer the question more than the
+// question itself does, which improves recall for questions over terse
+// documents. Only the embeddings of VectorRetriever and
+// NativeHybridRetriever include the draft; keyword and sparse searches,
+// and the rest of Retriever, see the query alone, so that the made-up
+// specifics of the draft do not match words of unrelated chunks. If the
+// LLM fails, the query is searched for alone.
+type HyDERetriever struct {
+	Retriever Retriever
+	LLM       LLM
+}
+
+func (r *HyDERetriever) Retrieve(ctx context.Context, query string, k int, filter Filter) ([]SearchResult, error) {
+	if draft := r.draft(ctx, query); draft != "" {
+		ctx = context.WithValue(ctx, hydeKey{}, hydeQuery{query: query, dense: query + "\n\n" + draft})
+	}
+	return r.Retriever.Retrieve(ctx, query, k, filter)
+}
+
+type hydeKey struct{}
+
+// hydeQuery is the text a query of HyDERetriever is embedded as.
+type hydeQuery struct {
+	query, dense string
+}
+
+// denseQuery returns the text query is to be embedded as in ctx: the query
+// followed by its draft under a HyDERetriever, or the query itself.
+func denseQuery(ctx context.Context, query string) string {
+	if q, ok := ctx.Value(hydeKey{}).(hydeQuery); ok && q.query == query {
+		return q.dense
+	}
+	return query
+}
+
+// draft returns the hypothetical passage for query, or "" if the LLM failed.
+func (r *HyDERetriever) draft(ctx context.Context, query string) string {
+	ctx, span := tracer.Start(ctx, "rag.hyde")
+	reply, err := r.LLM.Generate(ctx, []Message{
+		{Role: RoleSystem, Content: hydePrompt},
+		{Role: RoleUser, Content: query},
+	})
+	reply = strings.TrimSpace(reply)
+	span.SetAttributes(attribute.Int("rag.draft_length", len(reply)))
+	endSpan(span, err)
+	if err != nil {
+		return ""
+	}
+	return reply
+}
@@ -0,0 +1,101 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// MMRRetriever diversifies results with maximal marginal relevance: it asks
+// Retriever for Candidates results, 4*k by default, and picks k of them one
+// at a time, each maximizing Lambda times its relevance minus 1-Lambda times
+// its cosine similarity to the most similar result already picked. Relevance
+// is the retriever's score scaled to between 0 and 1 over the candidates, so
+// that any retriever can be diversified; Lambda 1 keeps the original order.
+// Candidates are embedded with Embedder unless they carry an embedding, and
+// results keep their original scores.
+type MMRRetriever struct {
+	Retriever  Retriever
+	Embedder   Embedder
+	Lambda     float64
+	Candidates int
+}
+
+func (r *MMRRetriever) Retrieve(ctx context.Context, query string, k int, filter Filter) ([]SearchResult, error) {
+	candidates := r.Candidates
+	if candidates <= 0 {
+		candidates = 4 * k
+	}
+	results, err := r.Retriever.Retrieve(ctx, query, max(candidates, k), filter)
+	if err != nil || len(results) <= k {
+		return results, err
+	}
+	vectors, err := r.vectors(ctx, results)
+	if err != nil {
+		return nil, err
+	}
+	lo, hi := float32(math.Inf(1)), float32(math.Inf(-1))
+	for _, res := range results {
+		lo, hi = min(lo, res.Score), max(hi, res.Score)
+	}
+	relevance := make([]float64, len(results))
+	for i, res := range results {
+		relevance[i] = 1
+		if hi > lo {
+			relevance[i] = float64((res.Score - lo) / (hi - lo))
+		}
+	}
+	// redundancy[i] is the highest similarity of candidate i to a pick
+	redundancy := make([]float64, len(results))
+	picked := make([]bool, len(results))
+	selected := make([]SearchResult, 0, k)
+	for len(selected) < k {
+		best, bestScore := -1, math.Inf(-1)
+		for i := range results {
+			if picked[i] {
+				continue
+			}
+			score := r.Lambda*relevance[i] - (1-r.Lambda)*redundancy[i]
+			if score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		picked[best] = true
+		selected = append(selected, results[best])
+		for i := range results {
+			if !picked[i] {
+				redundancy[i] = max(redundancy[i], float64(similarity(MetricCosine, vectors[i], vectors[best])))
+			}
+		}
+	}
+	return selected, nil
+}
+
+// vectors returns the embeddings of results, embedding those that lack one.
+func (r *MMRRetriever) vectors(ctx context.Context, results []SearchResult) ([][]float32, error) {
+	vectors := make([][]float32, len(results))
+	var texts []string
+	var missing []int
+	for i, res := range results {
+		if len(res.Embedding) > 0 {
+			vectors[i] = res.Embedding
+			continue
+		}
+		texts = append(texts, res.Text)
+		missing = append(missing, i)
+	}
+	if len(texts) == 0 {
+		return vectors, nil
+	}
+	embeddings, err := r.Embedder.Embed(ctx, texts)
+	if err != nil {
+		return nil, fmt.Errorf("embedding candidates for MMR: %w", err)
+	}
+	if len(embeddings) != len(texts) {
+		return nil, fmt.Errorf("embedding candidates for MMR: got %d embeddings for %d texts", len(embeddings), len(texts))
+	}
+	for j, i := range missing {
+		vectors[i] = embeddings[j]
+	}
+	return vectors, nil
+}
@@ -0,0 +1,87 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// DefaultQueryVariants is how many paraphrases MultiQueryRetriever asks for.
+const DefaultQueryVariants = 4
+
+const multiQueryPrompt = `You rewrite search queries for a document retrieval system.
+Write %d different versions of the user's question that could match relevant passages: vary the wording, use synonyms, and spell out anything vague or implied.
+Reply with one query per line and nothing else.`
+
+// MultiQueryRetriever improves recall for vague questions by asking LLM for
+// Variants paraphrases of the query, retrieving for the original and every
+// paraphrase in parallel, and fusing the results with reciprocal rank
+// fusion, which also removes duplicates. Each retrieval asks for Candidates
+// results, 2*k by default. If the LLM fails, the original query is used
+// alone.
+type MultiQueryRetriever struct {
+	Retriever  Retriever
+	LLM        LLM
+	Variants   int // DefaultQueryVariants if zero
+	Candidates int
+}
+
+func (r *MultiQueryRetriever) Retrieve(ctx context.Context, query string, k int, filter Filter) ([]SearchResult, error) {
+	candidates := r.Candidates
+	if candidates <= 0 {
+		candidates = 2 * k
+	}
+	queries := append([]string{query}, r.expand(ctx, query)...)
+	rankings := make([][]SearchResult, len(queries))
+	g, gctx := errgroup.WithContext(ctx)
+	for i, q := range queries {
+		g.Go(func() (err error) {
+			rankings[i], err = r.Retriever.Retrieve(gctx, q, candidates, filter)
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	weights := make([]float64, len(rankings))
+	for i := range weights {
+		weights[i] = 1
+	}
+	return fuseRankings(k, weights, rankings...), nil
+}
+
+// listMarker matches the bullets or numbers models put before list items
+// even when asked not to.
+var listMarker = regexp.MustCompile(`^\s*(?:[-*•]|\d+[.)])\s+`)
+
+// expand returns up to r.Variants paraphrases of query that differ from it.
+func (r *MultiQueryRetriever) expand(ctx context.Context, query string) []string {
+	variants := r.Variants
+	if variants <= 0 {
+		variants = DefaultQueryVariants
+	}
+	reply, err := r.LLM.Generate(ctx, []Message{
+		{Role: RoleSystem, Content: fmt.Sprintf(multiQueryPrompt, variants)},
+		{Role: RoleUser, Content: query},
+	})
+	if err != nil {
+		return nil
+	}
+	seen := map[string]bool{strings.ToLower(query): true}
+	var queries []string
+	for _, line := range strings.Split(reply, "\n") {
+		line = strings.Trim(strings.TrimSpace(listMarker.ReplaceAllString(line, "")), `"`)
+		if line == "" || seen[strings.ToLower(line)] {
+			continue
+		}
+		seen[strings.ToLower(line)] = true
+		queries = append(queries, line)
+		if len(queries) == variants {
+			break
+		}
+	}
+	return queries
+}
@@ -0,0 +1,58 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+)
+
+// ParentRetriever implements small-to-big retrieval: Retriever searches the
+// small child chunks produced by ChunkWithParents, which match queries
+// precisely, and every child is replaced by its larger parent from Store,
+// which gives the LLM the surrounding context. A parent is returned once,
+// with the score of its best child. Retriever is asked for Candidates
+// children, 4*k by default, so that k distinct parents can be found. Chunks
+// without a parent, or whose parent is missing, are returned themselves.
+type ParentRetriever struct {
+	Retriever  Retriever
+	Store      ParentStore
+	Candidates int
+}
+
+func (r *ParentRetriever) Retrieve(ctx context.Context, query string, k int, filter Filter) ([]SearchResult, error) {
+	candidates := r.Candidates
+	if candidates <= 0 {
+		candidates = 4 * k
+	}
+	children, err := r.Retriever.Retrieve(ctx, query, max(candidates, k), filter)
+	if err != nil || len(children) == 0 {
+		return children, err
+	}
+	var ids []string
+	for _, c := range children {
+		if c.ParentID != "" {
+			ids = append(ids, c.ParentID)
+		}
+	}
+	parents, err := r.Store.Parents(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("reading parent chunks: %w", err)
+	}
+	// Children arrive best first, so the first one seen sets a parent's score
+	var results []SearchResult
+	seen := make(map[string]bool)
+	for _, c := range children {
+		result := c
+		if parent, ok := parents[c.ParentID]; ok {
+			result = SearchResult{Chunk: parent, Score: c.Score}
+		}
+		if seen[result.ID] {
+			continue
+		}
+		seen[result.ID] = true
+		results = append(results, result)
+		if len(results) == k {
+			break
+		}
+	}
+	return results, nil
+}
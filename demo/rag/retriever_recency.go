@@ -0,0 +1,104 @@
+package rag
+
+import (
+	"context"
+	"math"
+	"strings"
+	"time"
+)
+
+// DefaultRecencyHalfLife is the age at which RecencyRetriever counts a
+// document half as fresh as a new one.
+const DefaultRecencyHalfLife = 30 * 24 * time.Hour
+
+// DefaultRecencyFields are the metadata keys RecencyRetriever reads the
+// time of a document from.
+const DefaultRecencyFields = "date, last_modified"
+
+// RecencyRetriever favours fresh documents, for corpora such as release
+// notes or news where the newest match is the best one. It asks Retriever
+// for Candidates results, 4*k by default, and mixes every score with the
+// recency of its chunk, which halves with every HalfLife of its age:
+// Weight of the score is scaled by the recency, so that 0 ranks by score
+// alone and 1 by score times recency, and the best k are kept. The time of
+// a chunk is read from the first of the metadata keys in Fields that holds
+// an RFC 3339 time or a date such as 2024-05-31, like "last_modified" of
+// bucket objects and wiki pages or "date" of Markdown front matter. Chunks
+// without a time count with the average recency of the dated candidates,
+// so that they neither outrank older dated chunks nor sink below them, and
+// times in the future count as now.
+type RecencyRetriever struct {
+	Retriever  Retriever
+	Weight     float64
+	HalfLife   time.Duration
+	Fields     []string
+	Candidates int
+}
+
+// ParseRecencyFields splits a comma-separated list of metadata keys.
+func ParseRecencyFields(list string) []string {
+	var fields []string
+	for _, f := range strings.Split(list, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+func (r *RecencyRetriever) Retrieve(ctx context.Context, query string, k int, filter Filter) ([]SearchResult, error) {
+	candidates := r.Candidates
+	if candidates <= 0 {
+		candidates = 4 * k
+	}
+	results, err := r.Retriever.Retrieve(ctx, query, max(candidates, k), filter)
+	if err != nil || len(results) == 0 {
+		return results, err
+	}
+	now := time.Now()
+	recencies := make([]float64, len(results))
+	var sum float64
+	dated := 0
+	for i := range results {
+		recencies[i] = -1
+		if t, ok := r.time(results[i].Metadata); ok {
+			age := max(now.Sub(t), 0)
+			recencies[i] = math.Exp2(-float64(age) / float64(r.HalfLife))
+			sum += recencies[i]
+			dated++
+		}
+	}
+	// Undated chunks neither gain nor lose against the dated ones
+	neutral := 1.0
+	if dated > 0 {
+		neutral = sum / float64(dated)
+	}
+	for i, recency := range recencies {
+		if recency < 0 {
+			recency = neutral
+		}
+		// Lowering by the magnitude keeps negative scores in order too
+		results[i].Score -= float32(math.Abs(float64(results[i].Score)) * r.Weight * (1 - recency))
+	}
+	sortResults(results)
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results, nil
+}
+
+// time returns the time of a chunk with the given metadata.
+func (r *RecencyRetriever) time(m Metadata) (time.Time, bool) {
+	for _, f := range r.Fields {
+		v := strings.TrimSpace(m[f])
+		if v == "" {
+			continue
+		}
+		for _, layout := range []string{time.RFC3339, time.DateOnly, time.DateTime} {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
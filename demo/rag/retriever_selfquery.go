@@ -0,0 +1,195 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Types of a MetadataField, which tell the LLM how to write the values it
+// compares the field with.
+const (
+	FieldString = "string"
+	FieldNumber = "number"
+	FieldDate   = "date"
+)
+
+// A MetadataField is a metadata key SelfQueryRetriever may filter on, with
+// the type of its values: FieldString, FieldNumber or FieldDate, whose
+// values are ISO dates such as 2026-06-30, which compare as strings in
+// order.
+type MetadataField struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// ParseMetadataFields parses a comma-separated list of metadata fields, each
+// a key optionally followed by a colon and its type, as in
+// "service, type, filed_at:date, priority:number". Fields are strings by
+// default.
+func ParseMetadataFields(list string) ([]MetadataField, error) {
+	var fields []MetadataField
+	for f := range strings.SplitSeq(list, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		name, typ, _ := strings.Cut(f, ":")
+		field := MetadataField{Name: strings.TrimSpace(name), Type: cmp.Or(strings.TrimSpace(typ), FieldString)}
+		switch field.Type {
+		case FieldString, FieldNumber, FieldDate:
+		default:
+			return nil, fmt.Errorf("metadata field %s: unknown type %q, want string, number or date", field.Name, field.Type)
+		}
+		if field.Name == "" {
+			return nil, fmt.Errorf("metadata field %q has no name", f)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+const selfQueryPrompt = `You turn questions into searches of a document index.
+Split the user's question into a search query and filters on the metadata of the documents. The filters take the constraints the question states on these metadata fields, and only those:
+%s
+Write dates as YYYY-MM-DD; today is %s. The search query is what the question asks about, with the constraints taken into filters left out. Use no filter for a constraint the fields cannot express, and keep it in the query instead.
+Reply with JSON only, in the form {"query": "...", "filter": [{"key": "...", "op": "...", "value": "..."}]}, where op is one of =, !=, <, <=, > and >=.`
+
+// selfQuerySchema is the JSON Schema of the replies to selfQueryPrompt.
+var selfQuerySchema = json.RawMessage(`{
+	"type": "object",
+	"properties": {
+		"query": {"type": "string", "description": "The search query, without the constraints taken into filters."},
+		"filter": {"type": "array", "items": {
+			"type": "object",
+			"properties": {
+				"key": {"type": "string", "description": "The metadata field."},
+				"op": {"type": "string", "enum": ["=", "!=", "<", "<=", ">", ">="]},
+				"value": {"type": "string", "description": "The value the field is compared with."}
+			},
+			"required": ["key", "op", "value"],
+			"additionalProperties": false
+		}}
+	},
+	"required": ["query", "filter"],
+	"additionalProperties": false
+}`)
+
+// A SelfQuery is what SelfQueryRetriever made of a question: the query it
+// searched for and the Filter it parsed from the question.
+type SelfQuery struct {
+	Query  string `json:"query"`
+	Filter Filter `json:"filter"`
+}
+
+// SelfQueryRetriever lets questions state constraints on metadata in
+// natural language, as in "bugs filed after June about the billing
+// service": it asks LLM to split the question into a query without the
+// constraints and a Filter on Fields, and has Retriever search for the
+// query with the filter added to that of the request. Conditions on other
+// fields, that compare a number or date field with another kind of value,
+// or that repeat one of the request are dropped. If the LLM fails, or the
+// store cannot search with the filter, such as Qdrant with a range of
+// dates, the question is searched for as it is.
+type SelfQueryRetriever struct {
+	Retriever Retriever
+	LLM       LLM
+	Fields    []MetadataField
+}
+
+func (r *SelfQueryRetriever) Retrieve(ctx context.Context, query string, k int, filter Filter) ([]SearchResult, error) {
+	sq := r.parse(ctx, query)
+	if sq == nil {
+		return r.Retriever.Retrieve(ctx, query, k, filter)
+	}
+	combined := filter
+	for _, c := range sq.Filter {
+		if !slices.Contains(combined, c) {
+			combined = append(slices.Clip(combined), c)
+		}
+	}
+	results, err := r.Retriever.Retrieve(ctx, sq.Query, k, combined)
+	if errors.Is(err, ErrNotSupported) && len(combined) > len(filter) {
+		trace.SpanFromContext(ctx).AddEvent("rag.self_query.unsupported", trace.WithAttributes(
+			attribute.String("error", err.Error())))
+		return r.Retriever.Retrieve(ctx, query, k, filter)
+	}
+	if err == nil {
+		recordSelfQuery(ctx, sq)
+	}
+	return results, err
+}
+
+// parse asks the LLM to split query, returning nil if it failed.
+func (r *SelfQueryRetriever) parse(ctx context.Context, query string) *SelfQuery {
+	ctx, span := tracer.Start(ctx, "rag.self_query")
+	var fields strings.Builder
+	for _, f := range r.Fields {
+		fmt.Fprintf(&fields, "- %s (%s)\n", f.Name, f.Type)
+	}
+	messages := []Message{
+		{Role: RoleSystem, Content: fmt.Sprintf(selfQueryPrompt, strings.TrimSuffix(fields.String(), "\n"), time.Now().Format(time.DateOnly))},
+		{Role: RoleUser, Content: query},
+	}
+	var reply string
+	var err error
+	if llm, ok := r.LLM.(StructuredLLM); ok {
+		reply, err = llm.GenerateJSON(ctx, messages, "self_query", selfQuerySchema)
+	} else {
+		reply, err = r.LLM.Generate(ctx, messages)
+	}
+	var sq *SelfQuery
+	if err == nil {
+		sq, err = r.parseReply(reply)
+	}
+	if sq != nil {
+		sq.Query = cmp.Or(sq.Query, query)
+		span.SetAttributes(attribute.String("rag.query", sq.Query), attribute.String("rag.filter", sq.Filter.String()))
+	}
+	endSpan(span, err)
+	return sq
+}
+
+// parseReply decodes a reply to selfQueryPrompt, keeping the conditions on
+// the fields of r whose values have the type of their field.
+func (r *SelfQueryRetriever) parseReply(reply string) (*SelfQuery, error) {
+	var parsed SelfQuery
+	if err := decodeJSONReply(reply, &parsed); err != nil {
+		return nil, fmt.Errorf("self-query %w", err)
+	}
+	sq := &SelfQuery{Query: strings.TrimSpace(parsed.Query), Filter: Filter{}}
+	for _, c := range parsed.Filter {
+		i := slices.IndexFunc(r.Fields, func(f MetadataField) bool { return f.Name == c.Key })
+		if i < 0 || !validOp(c.Op) || c.Value == "" {
+			continue
+		}
+		switch r.Fields[i].Type {
+		case FieldNumber:
+			if _, ok := parseNumber(c.Value); !ok {
+				continue
+			}
+		case FieldDate:
+			if _, err := time.Parse(time.DateOnly, c.Value); err != nil {
+				continue
+			}
+		}
+		sq.Filter = append(sq.Filter, c)
+	}
+	return sq, nil
+}
+
+// validOp reports whether op is one of the operators of a Condition.
+func validOp(op Op) bool {
+	switch op {
+	case OpEq, OpNe, OpLt, OpLe, OpGt, OpGe:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,198 @@
+package rag
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RouterMode selects how a Router classifies questions.
+type RouterMode string
+
+const (
+	// RouteByRules routes a question by the Patterns and MaxWords of the
+	// routes.
+	RouteByRules RouterMode = "rules"
+	// RouteByLLM has the LLM pick the route of a question from the names
+	// and descriptions of the routes.
+	RouteByLLM RouterMode = "llm"
+)
+
+const routerPrompt = `You route questions to the model best suited to answer them. The routes are:
+%s
+Reply with the name of the route of the user's question and nothing else.`
+
+// Route is an entry of a routing table: the questions it matches, and the
+// Model and Temperature their answers are generated with. Unset, they leave
+// those of the LLM. A question matches a route if one of its Patterns, a
+// regular expression matched without regard to case, matches it or, with
+// MaxWords, if it has at most that many words; a route with neither
+// matches every question. Description tells the LLM of a RouteByLLM Router
+// which questions the route is for.
+type Route struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Patterns    []string `json:"patterns,omitempty"`
+	MaxWords    int      `json:"max_words,omitempty"`
+	Model       string   `json:"model,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+}
+
+// Router routes questions to the models and temperatures suited to them,
+// such as factual lookups to a small, fast model and questions asking to
+// compare or summarize to a larger one. With RouteByRules, a question takes
+// the first of Routes it matches; with RouteByLLM, LLM picks its route, and
+// the rules only decide if the LLM fails or names no route. A question
+// matching no route is answered with the LLM's own settings. The LLMs of
+// this package generate with the Model of a route instead of their own.
+type Router struct {
+	Mode   RouterMode
+	Routes []Route
+	LLM    LLM
+
+	once     sync.Once
+	patterns [][]*regexp.Regexp
+	err      error
+}
+
+// NewRouter returns the Router selected by mode, which is off, rules or
+// llm, with the routing table in the JSON file routes, or nil if mode is
+// off or empty.
+func NewRouter(mode, routes string, llm LLM) (*Router, error) {
+	switch RouterMode(mode) {
+	case "", "off":
+		return nil, nil
+	case RouteByRules, RouteByLLM:
+	default:
+		return nil, fmt.Errorf("unknown router mode %q", mode)
+	}
+	if routes == "" {
+		return nil, errors.New("ROUTER needs a routing table in ROUTES")
+	}
+	table, err := LoadRoutes(routes)
+	if err != nil {
+		return nil, err
+	}
+	r := &Router{Mode: RouterMode(mode), Routes: table, LLM: llm}
+	if err := r.compile(); err != nil {
+		return nil, fmt.Errorf("routing table %s: %w", routes, err)
+	}
+	return r, nil
+}
+
+// LoadRoutes reads a routing table, a JSON array of routes, from path.
+func LoadRoutes(path string) ([]Route, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var routes []Route
+	if err := json.Unmarshal(data, &routes); err != nil {
+		return nil, fmt.Errorf("parsing routing table %s: %w", path, err)
+	}
+	return routes, nil
+}
+
+// compile checks the routes and compiles their patterns, once.
+func (r *Router) compile() error {
+	r.once.Do(func() {
+		seen := make(map[string]bool)
+		for _, route := range r.Routes {
+			if route.Name == "" || seen[route.Name] {
+				r.err = fmt.Errorf("route names must be unique and not empty, got %q", route.Name)
+				return
+			}
+			seen[route.Name] = true
+			if err := (GenerationOptions{Temperature: route.Temperature}).validate(); err != nil {
+				r.err = fmt.Errorf("route %s: %w", route.Name, err)
+				return
+			}
+			var patterns []*regexp.Regexp
+			for _, p := range route.Patterns {
+				re, err := regexp.Compile("(?i)" + p)
+				if err != nil {
+					r.err = fmt.Errorf("route %s: %w", route.Name, err)
+					return
+				}
+				patterns = append(patterns, re)
+			}
+			r.patterns = append(r.patterns, patterns)
+		}
+	})
+	return r.err
+}
+
+// Route returns the route of question, or nil if it matches none.
+func (r *Router) Route(ctx context.Context, question string) (*Route, error) {
+	if err := r.compile(); err != nil {
+		return nil, err
+	}
+	if r.Mode == RouteByLLM {
+		if route := r.classify(ctx, question); route != nil {
+			return route, nil
+		}
+	}
+	words := len(strings.Fields(question))
+	for i, route := range r.Routes {
+		matched := len(r.patterns[i]) == 0 && route.MaxWords == 0 || route.MaxWords > 0 && words <= route.MaxWords
+		for _, re := range r.patterns[i] {
+			matched = matched || re.MatchString(question)
+		}
+		if matched {
+			return &r.Routes[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// classify returns the route the LLM picks for question, or nil if it
+// failed or named none.
+func (r *Router) classify(ctx context.Context, question string) *Route {
+	ctx, span := tracer.Start(ctx, "rag.route")
+	var list strings.Builder
+	for _, route := range r.Routes {
+		fmt.Fprintf(&list, "- %s: %s\n", route.Name, route.Description)
+	}
+	reply, err := r.LLM.Generate(ctx, []Message{
+		{Role: RoleSystem, Content: fmt.Sprintf(routerPrompt, strings.TrimSuffix(list.String(), "\n"))},
+		{Role: RoleUser, Content: question},
+	})
+	var picked *Route
+	reply = strings.ToLower(strings.Trim(strings.TrimSpace(reply), "`'\".:"))
+	for i, route := range r.Routes {
+		if err == nil && strings.ToLower(route.Name) == reply {
+			picked = &r.Routes[i]
+			span.SetAttributes(attribute.String("rag.route", route.Name))
+			break
+		}
+	}
+	endSpan(span, err)
+	return picked
+}
+
+// route returns req with the model and temperature of the route query,
+// its question or the condensed form of it, takes, if the pipeline has a
+// Router, and the name of the route. A temperature set on req is kept.
+func (p *Pipeline) route(ctx context.Context, req QueryRequest, query string) (QueryRequest, string, error) {
+	if p.Router == nil {
+		return req, "", nil
+	}
+	route, err := p.Router.Route(ctx, query)
+	if err != nil || route == nil {
+		return req, "", err
+	}
+	if req.Temperature == nil {
+		req.Temperature = route.Temperature
+	}
+	req.Model = route.Model
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("rag.route", route.Name))
+	return req, route.Name, nil
+}
@@ -0,0 +1,169 @@
+package rag
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Schedule tells when a recurring task is due. Next returns the first
+// time after the given one at which it is due, or the zero time if it
+// never is.
+type Schedule interface {
+	Next(after time.Time) time.Time
+}
+
+// scheduleMacros are the shorthands ParseSchedule accepts for common cron
+// expressions.
+var scheduleMacros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+}
+
+// ParseSchedule parses a cron expression, such as "*/15 * * * *" or
+// "0 3 * * 1-5", giving the minute, hour, day of the month, month and day
+// of the week, from 0 for Sunday to 6, at which a task is due in the local
+// time zone. Each field is *, a number, a range such as 1-5, or a list of
+// them separated by commas, and numbers or ranges may be followed by a
+// step, as in */15 or 8-18/2. As in cron, a task restricted by both day
+// fields is due on the days matching either. The shorthands @hourly,
+// @daily, @weekly, @monthly and @yearly are accepted, and "@every 90m"
+// schedules a task at fixed intervals from the time it is scheduled.
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if interval, ok := strings.CutPrefix(spec, "@every "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(interval))
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: %w", spec, err)
+		}
+		if d < time.Minute {
+			return nil, fmt.Errorf("schedule %q: interval must be at least a minute", spec)
+		}
+		return everySchedule(d), nil
+	}
+	expr := spec
+	if macro, ok := scheduleMacros[spec]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %q: want 5 fields, minute hour day month weekday, got %d", spec, len(fields))
+	}
+	var s cronSchedule
+	for i, f := range []struct {
+		dst      *uint64
+		min, max int
+	}{{&s.minute, 0, 59}, {&s.hour, 0, 23}, {&s.day, 1, 31}, {&s.month, 1, 12}, {&s.weekday, 0, 7}} {
+		bits, err := parseCronField(fields[i], f.min, f.max)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: field %d: %w", spec, i+1, err)
+		}
+		*f.dst = bits
+	}
+	// 7 is Sunday too
+	if s.weekday&(1<<7) != 0 {
+		s.weekday |= 1
+	}
+	s.anyDay, s.anyWeekday = fields[2] == "*", fields[4] == "*"
+	if s.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("schedule %q is never due", spec)
+	}
+	return &s, nil
+}
+
+// parseCronField returns the set of values, between min and max, that a
+// field of a cron expression matches, as a bit set.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for part := range strings.SplitSeq(field, ",") {
+		rng, step := part, 1
+		if r, s, ok := strings.Cut(part, "/"); ok {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step %q", s)
+			}
+			rng, step = r, n
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("invalid value %q", a)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("invalid value %q", b)
+				}
+			} else if step > 1 {
+				// 5/15 means from 5 on
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is out of the range %d-%d", rng, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	if bits == 0 {
+		return 0, errors.New("empty field")
+	}
+	return bits, nil
+}
+
+// cronSchedule is a parsed cron expression; the fields are bit sets of the
+// values they match.
+type cronSchedule struct {
+	minute, hour, day, month, weekday uint64
+	anyDay, anyWeekday                bool
+}
+
+func (s *cronSchedule) Next(after time.Time) time.Time {
+	loc := after.Location()
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	// Every expression that is ever due is due within a leap year cycle
+	limit := t.AddDate(8, 0, 0)
+	for t.Before(limit) {
+		y, m, d := t.Date()
+		switch {
+		case s.month&(1<<m) == 0:
+			t = time.Date(y, m+1, 1, 0, 0, 0, 0, loc)
+		case !s.dayMatches(t):
+			t = time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+		case s.hour&(1<<t.Hour()) == 0:
+			t = time.Date(y, m, d, t.Hour()+1, 0, 0, 0, loc)
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches reports whether the day of t matches the day of the month and
+// day of the week fields.
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	day := s.day&(1<<t.Day()) != 0
+	weekday := s.weekday&(1<<t.Weekday()) != 0
+	if s.anyDay || s.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
+
+// everySchedule is due at fixed intervals.
+type everySchedule time.Duration
+
+func (s everySchedule) Next(after time.Time) time.Time {
+	return after.Add(time.Duration(s))
+}
@@ -0,0 +1,666 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// maxUploadSize bounds the memory used to parse multipart uploads; larger
+// files are buffered on disk by the multipart reader.
+const maxUploadSize = 32 << 20
+
+// NewHandler exposes p over an HTTP JSON API:
+//
+//	POST   /ingest                     ingest files or JSON documents
+//	GET    /jobs                       list ingestion jobs
+//	GET    /jobs/{id}                  the progress of an ingestion job
+//	POST   /query                      answer a question, streamed if asked
+//	POST   /explain                    score the chunks a question retrieves
+//	POST   /summarize                  summarize one or matching documents
+//	POST   /chat                       stream a chat completion over SSE
+//	POST   /feedback                   rate an answer up or down
+//	GET    /documents                  list stored documents
+//	GET    /documents/{id}             list the chunks of a document
+//	DELETE /documents/{id}             delete a document and its chunks
+//	GET    /expiring                   list documents expiring soon
+//	GET    /sources/{id}               a document's text, chunks highlighted
+//	GET    /namespaces                 list namespaces
+//	POST   /namespaces                 create a namespace and its profile
+//	DELETE /namespaces/{name}          delete a namespace and its documents
+//	GET    /namespaces/{name}/profile  the settings a namespace overrides
+//	PUT    /namespaces/{name}/profile  replace the profile of a namespace
+//	DELETE /namespaces/{name}/profile  remove the profile of a namespace
+//	GET    /usage                      token usage and cost since startup
+//	GET    /healthz                    whether the server is up
+//	GET    /readyz                     whether store and providers are up
+//	GET    /metrics                    Prometheus metrics
+//	GET    /ui/                        the admin UI, to which / redirects
+//
+// Ingestion, queries, summaries and documents use the namespace given by
+// the "namespace" query parameter, or DefaultNamespace. Queries only
+// retrieve, and sources, documents, summaries and expiring documents only
+// show, documents the principals listed in the PrincipalsHeader may see,
+// and queries, documents and summaries none that expired; documents they
+// may not see can neither be deleted nor ingested again. If jobs is not
+// nil, ingestion runs in the background as a job of jobs unless the
+// request sets the "wait" query parameter; otherwise the /jobs endpoints
+// report 404. Errors are reported with the status of their kind and a JSON
+// body holding their message and ErrorCode.
+func NewHandler(p *Pipeline, jobs *JobQueue) http.Handler {
+	return newHandler(func() *Pipeline { return p }, jobs)
+}
+
+// newHandler returns the handler of NewHandler, serving every request with
+// the pipeline returned by pipeline.
+func newHandler(pipeline func() *Pipeline, jobs *JobQueue) http.Handler {
+	s := &server{pipeline: pipeline, jobs: jobs, health: &HealthCheck{}}
+	mux := http.NewServeMux()
+	handle := func(pattern string, h http.Handler) {
+		mux.Handle(pattern, instrumentHandler(pattern, h))
+	}
+	handle("POST /ingest", namespaced(identified(s.ingest)))
+	handle("POST /query", namespaced(identified(s.query)))
+	handle("POST /explain", namespaced(identified(s.explain)))
+	handle("POST /summarize", namespaced(identified(s.summarize)))
+	// Reconfigured pipelines share the LLM
+	handle("POST /chat", s.metered(StreamHandler(pipeline().LLM)))
+	handle("POST /feedback", http.HandlerFunc(s.feedback))
+	handle("GET /jobs", namespaced(s.listJobs))
+	handle("GET /jobs/{id}", http.HandlerFunc(s.job))
+	handle("GET /documents", namespaced(identified(s.documents)))
+	handle("GET /documents/{id...}", namespaced(identified(s.document)))
+	handle("DELETE /documents/{id...}", namespaced(identified(s.deleteDocument)))
+	handle("GET /expiring", namespaced(identified(s.expiring)))
+	handle("GET /sources/{id...}", namespaced(identified(s.source)))
+	handle("GET /namespaces", http.HandlerFunc(s.namespaces))
+	handle("POST /namespaces", http.HandlerFunc(s.createNamespace))
+	handle("DELETE /namespaces/{name}", http.HandlerFunc(s.deleteNamespace))
+	handle("GET /namespaces/{name}/profile", http.HandlerFunc(s.namespaceProfile))
+	handle("PUT /namespaces/{name}/profile", http.HandlerFunc(s.setNamespaceProfile))
+	handle("DELETE /namespaces/{name}/profile", http.HandlerFunc(s.setNamespaceProfile))
+	handle("GET /usage", http.HandlerFunc(s.usage))
+	handle("GET /healthz", http.HandlerFunc(live))
+	handle("GET /readyz", s.healthHandler(ComponentStore, ComponentEmbedder, ComponentLLM))
+	mux.Handle("GET /metrics", promhttp.Handler())
+	mux.Handle("GET /ui/", uiHandler())
+	mux.Handle("GET /{$}", http.RedirectHandler("/ui/", http.StatusFound))
+	return mux
+}
+
+type server struct {
+	pipeline func() *Pipeline
+	jobs     *JobQueue
+	health   *HealthCheck
+}
+
+// namespaced runs h in the namespace named by the "namespace" query
+// parameter, if there is one.
+func namespaced(h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ns := r.URL.Query().Get("namespace"); ns != "" {
+			if err := ValidateNamespace(ns); err != nil {
+				writeError(w, withKind(ErrInvalidRequest, err))
+				return
+			}
+			r = r.WithContext(WithNamespace(r.Context(), ns))
+		}
+		h(w, r)
+	})
+}
+
+// identified runs h with the principals listed in the PrincipalsHeader of
+// the request.
+func identified(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principals := ParsePrincipals(r.Header.Get(PrincipalsHeader))
+		h(w, r.WithContext(WithPrincipals(r.Context(), principals...)))
+	}
+}
+
+// metered adds the usage of requests to h to the pipeline's Usage meter; the
+// pipeline's own operations meter themselves.
+func (s *server) metered(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.pipeline().Usage != nil {
+			r = r.WithContext(WithUsageFunc(r.Context(), s.pipeline().Usage.Record))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// ingest accepts either multipart/form-data with one or more "file" parts,
+// loaded by extension, or a JSON body of the form
+// {"documents": [{"id": ..., "text": ..., "metadata": {...}}]}. An "acl"
+// form field sets the ACL of the uploaded files, see ACLKey, as the "acl"
+// metadata key does for JSON documents. With a job queue, the documents
+// are ingested by a job and the response, 202 Accepted, holds the job.
+// Otherwise, or with the "wait" query parameter set, they are ingested
+// before responding; documents whose chunks could not be embedded are
+// reported with an error in the response, which also carries the usage of
+// the embedding requests. Nothing is ingested, with ErrForbidden, if one
+// of the documents would replace one the caller may not see.
+func (s *server) ingest(w http.ResponseWriter, r *http.Request) {
+	docs, err := s.ingestDocuments(r)
+	if err != nil {
+		writeError(w, uploadError(err))
+		return
+	}
+	if len(docs) == 0 {
+		writeError(w, invalidRequest("no documents to ingest"))
+		return
+	}
+	if err := s.pipeline().checkAllowed(r.Context(), ErrForbidden, documentIDs(docs)...); err != nil {
+		writeError(w, err)
+		return
+	}
+	if wait, _ := strconv.ParseBool(r.URL.Query().Get("wait")); s.jobs != nil && !wait {
+		// Report a missing namespace now rather than in the job
+		if err := s.checkNamespace(r.Context()); err != nil {
+			writeError(w, err)
+			return
+		}
+		job, err := s.jobs.Submit(r.Context(), docs)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Location", "/jobs/"+job.ID)
+		writeJSON(w, http.StatusAccepted, map[string]any{"job": job})
+		return
+	}
+	meter := &UsageMeter{Pricing: s.pipeline().pricing()}
+	results, err := s.pipeline().IngestAll(WithUsageFunc(r.Context(), meter.Record), docs)
+	var batchErr *BatchError
+	if err != nil && !errors.As(err, &batchErr) {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"documents": results, "usage": meter.Report()})
+}
+
+// checkNamespace returns ErrNamespaceNotFound if the namespace of ctx was
+// not created.
+func (s *server) checkNamespace(ctx context.Context) error {
+	name := NamespaceFrom(ctx)
+	if name == DefaultNamespace {
+		return nil
+	}
+	namespaces, err := s.pipeline().Namespaces(ctx)
+	if err != nil {
+		return err
+	}
+	for _, ns := range namespaces {
+		if ns.Name == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrNamespaceNotFound, name)
+}
+
+// uploadError marks an error reading the documents of an ingest request
+// with ErrFileTooLarge if the body was larger than an http.MaxBytesReader
+// allows, and with ErrInvalidRequest if it is of no kind of its own, such
+// as ErrNoLoader.
+func uploadError(err error) error {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		return withKind(ErrFileTooLarge, err)
+	case ErrorCode(err) != CodeInternal:
+		return err
+	}
+	return withKind(ErrInvalidRequest, err)
+}
+
+func (s *server) ingestDocuments(r *http.Request) ([]*Document, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+			return nil, err
+		}
+		var docs []*Document
+		for _, header := range r.MultipartForm.File["file"] {
+			loader, err := LoaderFor(header.Filename)
+			if err != nil {
+				return nil, err
+			}
+			f, err := header.Open()
+			if err != nil {
+				return nil, err
+			}
+			doc, err := loadDocument(r.Context(), loader, header.Filename, f)
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+			for _, key := range []string{ACLKey, ExpiresKey} {
+				if v, ok := r.MultipartForm.Value[key]; ok {
+					doc.Metadata[key] = v[0]
+				}
+			}
+			docs = append(docs, doc)
+		}
+		return docs, nil
+	}
+
+	var req struct {
+		Documents []struct {
+			ID       string   `json:"id"`
+			Text     string   `json:"text"`
+			Metadata Metadata `json:"metadata"`
+		} `json:"documents"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("invalid request body: %w", err)
+	}
+	docs := make([]*Document, len(req.Documents))
+	for i, d := range req.Documents {
+		if d.ID == "" {
+			return nil, fmt.Errorf("document %d has no id", i)
+		}
+		docs[i] = &Document{
+			ID:       d.ID,
+			Sections: []Section{{Text: d.Text}},
+			Metadata: Metadata{"source": d.ID}.merge(d.Metadata),
+		}
+	}
+	return docs, nil
+}
+
+// documentIDs returns the IDs of docs.
+func documentIDs(docs []*Document) []string {
+	ids := make([]string, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.ID
+	}
+	return ids
+}
+
+type queryRequest struct {
+	QueryRequest
+	Stream bool `json:"stream"`
+}
+
+// query answers a question. When the request sets "stream" the answer is sent
+// as SSE "delta" events followed by a "done" event carrying the full Answer.
+func (s *server) query(w http.ResponseWriter, r *http.Request) {
+	var req queryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, invalidRequest("invalid request body: %w", err))
+		return
+	}
+	if err := s.validateQuery(req.QueryRequest); err != nil {
+		writeError(w, err)
+		return
+	}
+	if !req.Stream {
+		answer, err := s.pipeline().Query(r.Context(), req.QueryRequest)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, answer)
+		return
+	}
+
+	sse, err := NewSSEWriter(w)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	answer, err := s.pipeline().QueryStream(r.Context(), req.QueryRequest, func(delta string) error {
+		return sse.Event("delta", map[string]string{"text": delta})
+	})
+	if err != nil {
+		sse.Event("error", errorBody(err))
+		return
+	}
+	sse.Event("done", answer)
+}
+
+// validateQuery reports an ErrInvalidRequest for a query that Query would
+// reject, before any of it is answered.
+func (s *server) validateQuery(req QueryRequest) error {
+	if req.Question == "" {
+		return invalidRequest("question must not be empty")
+	}
+	if _, err := ParseFilter(req.Filter); err != nil {
+		return invalidRequest("invalid filter: %w", err)
+	}
+	if err := req.Format.validate(); err != nil {
+		return withKind(ErrInvalidRequest, err)
+	}
+	if err := req.GenerationOptions.validate(); err != nil {
+		return withKind(ErrInvalidRequest, err)
+	}
+	if _, err := s.pipeline().agentSteps(req); err != nil {
+		return withKind(ErrInvalidRequest, err)
+	}
+	if err := s.pipeline().checkK(req); err != nil {
+		return withKind(ErrInvalidRequest, err)
+	}
+	return nil
+}
+
+// explain takes the body of a query and responds with the Explanation of
+// the chunks retrieved for it.
+func (s *server) explain(w http.ResponseWriter, r *http.Request) {
+	var req QueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, invalidRequest("invalid request body: %w", err))
+		return
+	}
+	if err := s.validateQuery(req); err != nil {
+		writeError(w, err)
+		return
+	}
+	explanation, err := s.pipeline().Explain(r.Context(), req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, explanation)
+}
+
+func (s *server) summarize(w http.ResponseWriter, r *http.Request) {
+	var req SummarizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, invalidRequest("invalid request body: %w", err))
+		return
+	}
+	summary, err := s.pipeline().Summarize(r.Context(), req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
+
+// live reports that the process serves requests, for liveness probes,
+// which must not fail when a dependency does lest every pod be restarted.
+func live(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// healthHandler reports the HealthReport of the given components, with
+// 503 Service Unavailable if one of them is not ok.
+func (s *server) healthHandler(components ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := s.health.Check(r.Context(), s.pipeline(), components...)
+		status := http.StatusOK
+		if !report.OK() {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, report)
+	})
+}
+
+func (s *server) listJobs(w http.ResponseWriter, r *http.Request) {
+	if s.jobs == nil {
+		writeError(w, fmt.Errorf("ingestion jobs are %w", ErrNotEnabled))
+		return
+	}
+	jobs, err := s.jobs.Jobs(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"jobs": jobs})
+}
+
+func (s *server) job(w http.ResponseWriter, r *http.Request) {
+	if s.jobs == nil {
+		writeError(w, fmt.Errorf("ingestion jobs are %w", ErrNotEnabled))
+		return
+	}
+	job, err := s.jobs.Job(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (s *server) documents(w http.ResponseWriter, r *http.Request) {
+	docs, err := s.pipeline().Documents(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"documents": docs})
+}
+
+// defaultExpiringWithin is how far ahead /expiring looks for documents
+// expiring without a "within" query parameter.
+const defaultExpiringWithin = 7 * 24 * time.Hour
+
+// expiring lists the documents expiring within the duration given by the
+// "within" query parameter, such as 720h, those already expired included.
+func (s *server) expiring(w http.ResponseWriter, r *http.Request) {
+	within := defaultExpiringWithin
+	if v := r.URL.Query().Get("within"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			writeError(w, invalidRequest("invalid within %q: want a duration such as 168h", v))
+			return
+		}
+		within = d
+	}
+	docs, err := s.pipeline().Expiring(r.Context(), time.Now().Add(within))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"documents": docs})
+}
+
+// document lists the chunks of a document the caller may see, without
+// their embeddings, if the store is a ChunkStore.
+func (s *server) document(w http.ResponseWriter, r *http.Request) {
+	store, ok := s.pipeline().Store.(ChunkStore)
+	if !ok {
+		writeError(w, fmt.Errorf("listing chunks is %w by the vector store", ErrNotSupported))
+		return
+	}
+	id := r.PathValue("id")
+	chunks, err := store.Chunks(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	// Chunks the caller may not retrieve are not listed either
+	principals, now := PrincipalsFrom(r.Context()), time.Now()
+	visible := chunks[:0]
+	for _, c := range chunks {
+		if allowed(c.Metadata, principals) && !expired(c.Metadata, now) {
+			c.Embedding, c.Sparse = nil, nil
+			visible = append(visible, c)
+		}
+	}
+	if len(visible) == 0 {
+		writeError(w, fmt.Errorf("%w: %s", ErrDocumentNotFound, id))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"id": id, "chunks": visible})
+}
+
+// source returns the SourceView of a document, highlighting the chunks
+// whose IDs are given in "chunk" query parameters.
+func (s *server) source(w http.ResponseWriter, r *http.Request) {
+	view, err := s.pipeline().SourceView(r.Context(), r.PathValue("id"), r.URL.Query()["chunk"])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, view)
+}
+
+func (s *server) deleteDocument(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := s.pipeline().checkAllowed(r.Context(), ErrDocumentNotFound, id); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := s.pipeline().Delete(r.Context(), id); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) namespaces(w http.ResponseWriter, r *http.Request) {
+	namespaces, err := s.pipeline().Namespaces(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"namespaces": namespaces})
+}
+
+// createNamespace creates the namespace named by a JSON body of the form
+// {"name": ..., "profile": {...}}, with the profile if there is one.
+func (s *server) createNamespace(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name    string            `json:"name"`
+		Profile *NamespaceProfile `json:"profile"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, invalidRequest("invalid request body: %w", err))
+		return
+	}
+	if err := checkNamespaceChange(req.Name); err != nil {
+		writeError(w, withKind(ErrInvalidRequest, err))
+		return
+	}
+	p := s.pipeline()
+	// Reject an invalid profile before creating the namespace
+	if req.Profile != nil {
+		if err := p.checkProfile(req.Profile); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	if err := p.CreateNamespace(r.Context(), req.Name); err != nil {
+		writeError(w, err)
+		return
+	}
+	if req.Profile != nil {
+		if err := p.SetNamespaceProfile(r.Context(), req.Name, req.Profile); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusCreated, NamespaceInfo{Name: req.Name})
+}
+
+// namespaceProfile reports the profile of a namespace, {} if it has none.
+func (s *server) namespaceProfile(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := ValidateNamespace(name); err != nil {
+		writeError(w, withKind(ErrInvalidRequest, err))
+		return
+	}
+	profile, err := s.pipeline().NamespaceProfile(r.Context(), name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, cmp.Or(profile, &NamespaceProfile{}))
+}
+
+// setNamespaceProfile replaces the profile of a namespace with the JSON
+// body of a PUT, or removes it on DELETE.
+func (s *server) setNamespaceProfile(w http.ResponseWriter, r *http.Request) {
+	var profile *NamespaceProfile
+	if r.Method == http.MethodPut {
+		profile = &NamespaceProfile{}
+		if err := json.NewDecoder(r.Body).Decode(profile); err != nil {
+			writeError(w, invalidRequest("invalid request body: %w", err))
+			return
+		}
+	}
+	name := r.PathValue("name")
+	if err := s.pipeline().SetNamespaceProfile(r.Context(), name, profile); err != nil {
+		writeError(w, err)
+		return
+	}
+	if profile == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, profile)
+}
+
+func (s *server) deleteNamespace(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := checkNamespaceChange(name); err != nil {
+		writeError(w, withKind(ErrInvalidRequest, err))
+		return
+	}
+	if err := s.pipeline().DeleteNamespace(r.Context(), name); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// usage reports the totals of the pipeline's Usage meter.
+func (s *server) usage(w http.ResponseWriter, r *http.Request) {
+	if s.pipeline().Usage == nil {
+		writeJSON(w, http.StatusOK, &UsageReport{Models: []ModelUsage{}})
+		return
+	}
+	writeJSON(w, http.StatusOK, s.pipeline().Usage.Report())
+}
+
+// feedback rates an answer given with an ID, taking a JSON Feedback such as
+// {"answer_id": ..., "rating": "up", "comment": ...}. It reports 404 unless
+// the pipeline has a FeedbackStore.
+func (s *server) feedback(w http.ResponseWriter, r *http.Request) {
+	if s.pipeline().Feedback == nil {
+		writeError(w, fmt.Errorf("feedback is %w", ErrNotEnabled))
+		return
+	}
+	var f Feedback
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		writeError(w, invalidRequest("invalid request body: %w", err))
+		return
+	}
+	if f.Rating != RatingUp && f.Rating != RatingDown {
+		writeError(w, invalidRequest("rating must be %q or %q", RatingUp, RatingDown))
+		return
+	}
+	if err := s.pipeline().SubmitFeedback(r.Context(), f); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError responds with the status of err and a JSON body holding its
+// message and ErrorCode, e.g. {"error": "answer not found", "code":
+// "answer_not_found"}.
+func writeError(w http.ResponseWriter, err error) {
+	writeJSON(w, errorStatus(err), errorBody(err))
+}
+
+// errorBody is the JSON form of an error in responses and SSE "error"
+// events.
+func errorBody(err error) map[string]string {
+	return map[string]string{"error": err.Error(), "code": ErrorCode(err)}
+}
@@ -0,0 +1,243 @@
+package rag
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// snapshotFormat and snapshotVersion identify snapshot files; Import
+// rejects versions it does not know.
+const (
+	snapshotFormat  = "go_rag_demo snapshot"
+	snapshotVersion = 1
+)
+
+// snapshotHeader is the first line of a snapshot.
+type snapshotHeader struct {
+	Format     string      `json:"format"`
+	Version    int         `json:"version"`
+	CreatedAt  time.Time   `json:"created_at"`
+	Namespaces []string    `json:"namespaces"`
+	Model      *IndexModel `json:"model,omitempty"`
+
+	Profiles map[string]*NamespaceProfile `json:"profiles,omitempty"`
+}
+
+// snapshotDocument is every later line of a snapshot: one document of a
+// namespace with its chunks, their parents and, if it was kept, its source.
+type snapshotDocument struct {
+	Namespace string    `json:"namespace"`
+	ID        string    `json:"id"`
+	Source    *Document `json:"source,omitempty"`
+	Chunks    []Chunk   `json:"chunks"`
+	Parents   []Chunk   `json:"parents,omitempty"`
+}
+
+// SnapshotStats counts what Export wrote or Import read.
+type SnapshotStats struct {
+	Namespaces int `json:"namespaces"`
+	Documents  int `json:"documents"`
+	Chunks     int `json:"chunks"`
+}
+
+// Export writes the whole index, every namespace with its profile and the
+// chunks, embeddings and metadata of its documents, to w as a snapshot that
+// Import loads into another store, even of another kind. Sources and
+// parent chunks are included if the store keeps them. Snapshots are
+// gzipped JSON Lines, a header followed by one line per document. The
+// store must be a ChunkStore.
+func (p *Pipeline) Export(ctx context.Context, w io.Writer) (SnapshotStats, error) {
+	var stats SnapshotStats
+	s, ok := p.Store.(ChunkStore)
+	if !ok {
+		return stats, fmt.Errorf("listing chunks is %w by the vector store", ErrNotSupported)
+	}
+	namespaces, err := s.Namespaces(ctx)
+	if err != nil {
+		return stats, err
+	}
+	header := snapshotHeader{Format: snapshotFormat, Version: snapshotVersion, CreatedAt: time.Now().UTC()}
+	for _, ns := range namespaces {
+		header.Namespaces = append(header.Namespaces, ns.Name)
+		profile, err := p.Profiles.profile(WithNamespace(ctx, ns.Name))
+		if err != nil {
+			return stats, err
+		}
+		if profile != nil {
+			if header.Profiles == nil {
+				header.Profiles = make(map[string]*NamespaceProfile)
+			}
+			header.Profiles[ns.Name] = profile
+		}
+	}
+	if ms, ok := p.Store.(ModelStore); ok {
+		if header.Model, err = ms.IndexModel(ctx); err != nil {
+			return stats, err
+		}
+	}
+	zw := gzip.NewWriter(w)
+	enc := json.NewEncoder(zw)
+	if err := enc.Encode(header); err != nil {
+		return stats, err
+	}
+	for _, name := range header.Namespaces {
+		nctx := WithNamespace(ctx, name)
+		docs, err := s.Documents(nctx)
+		if err != nil {
+			return stats, err
+		}
+		for _, info := range docs {
+			if err := ctx.Err(); err != nil {
+				return stats, err
+			}
+			doc, err := p.exportDocument(nctx, s, info.ID)
+			if err != nil {
+				return stats, fmt.Errorf("exporting %s: %w", info.ID, err)
+			}
+			if err := enc.Encode(doc); err != nil {
+				return stats, err
+			}
+			stats.Documents++
+			stats.Chunks += len(doc.Chunks)
+		}
+		stats.Namespaces++
+	}
+	return stats, zw.Close()
+}
+
+// exportDocument collects the stored parts of a document.
+func (p *Pipeline) exportDocument(ctx context.Context, s ChunkStore, docID string) (*snapshotDocument, error) {
+	chunks, err := s.Chunks(ctx, docID)
+	if err != nil {
+		return nil, err
+	}
+	doc := &snapshotDocument{Namespace: NamespaceFrom(ctx), ID: docID, Chunks: chunks}
+	if ss, ok := p.Store.(SourceStore); ok {
+		if doc.Source, err = ss.Source(ctx, docID); err != nil {
+			return nil, err
+		}
+	}
+	var parentIDs []string
+	seen := make(map[string]bool)
+	for _, c := range chunks {
+		if c.ParentID != "" && !seen[c.ParentID] {
+			seen[c.ParentID] = true
+			parentIDs = append(parentIDs, c.ParentID)
+		}
+	}
+	if ps, ok := p.Store.(ParentStore); ok && len(parentIDs) > 0 {
+		parents, err := ps.Parents(ctx, parentIDs)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range parentIDs {
+			if parent, ok := parents[id]; ok {
+				doc.Parents = append(doc.Parents, parent)
+			}
+		}
+	}
+	return doc, nil
+}
+
+// Import loads a snapshot written by Export. Missing namespaces are
+// created, and namespaces are given the profiles of the snapshot, see
+// SetNamespaceProfile; documents replace stored documents with the same
+// ID, while other stored documents are kept. Chunks are stored with the
+// embeddings of the snapshot, which must have been made with the
+// pipeline's EmbeddingModel, or the model of their namespace's profile:
+// snapshots of stores that recorded another one, and chunks that cannot be
+// compared with those of a ModelStore, fail with ErrModelMismatch. With a
+// KnowledgeGraph, the graph of the chunks it does not hold yet is
+// extracted as the documents are imported.
+func (p *Pipeline) Import(ctx context.Context, r io.Reader) (SnapshotStats, error) {
+	var stats SnapshotStats
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return stats, fmt.Errorf("reading snapshot: %w", err)
+	}
+	dec := json.NewDecoder(bufio.NewReader(zr))
+	var header snapshotHeader
+	if err := dec.Decode(&header); err != nil || header.Format != snapshotFormat {
+		return stats, errors.New("reading snapshot: not a snapshot file")
+	}
+	if header.Version != snapshotVersion {
+		return stats, fmt.Errorf("reading snapshot: unsupported version %d", header.Version)
+	}
+	if m := header.Model; m != nil && m.Model != "" && p.EmbeddingModel != "" && m.Model != p.EmbeddingModel {
+		return stats, fmt.Errorf("%w: the snapshot holds embeddings of %s, not of %s", ErrModelMismatch, m, p.EmbeddingModel)
+	}
+	for _, name := range header.Namespaces {
+		if err := ValidateNamespace(name); err != nil {
+			return stats, fmt.Errorf("reading snapshot: %w", err)
+		}
+		if name != DefaultNamespace {
+			if err := p.Store.CreateNamespace(ctx, name); err != nil && !errors.Is(err, ErrNamespaceExists) {
+				return stats, err
+			}
+		}
+		if profile := header.Profiles[name]; profile != nil {
+			if err := p.SetNamespaceProfile(ctx, name, profile); err != nil {
+				return stats, fmt.Errorf("profile of namespace %s: %w", name, err)
+			}
+		}
+		stats.Namespaces++
+	}
+	// Namespaces with a model of their own may have other dimensions
+	dims := make(map[string]int)
+	for {
+		var doc snapshotDocument
+		if err := dec.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return stats, fmt.Errorf("reading snapshot: %w", err)
+		}
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		for _, c := range doc.Chunks {
+			if dims[doc.Namespace] == 0 {
+				dims[doc.Namespace] = len(c.Embedding)
+			}
+			if want := dims[doc.Namespace]; len(c.Embedding) != want || want == 0 {
+				return stats, fmt.Errorf("reading snapshot: chunk %s has %d dimensions, want %d", c.ID, len(c.Embedding), want)
+			}
+		}
+		if err := p.importDocument(WithNamespace(ctx, doc.Namespace), &doc); err != nil {
+			return stats, fmt.Errorf("importing %s: %w", doc.ID, err)
+		}
+		stats.Documents++
+		stats.Chunks += len(doc.Chunks)
+	}
+	return stats, nil
+}
+
+// importDocument replaces a stored document with one read from a snapshot,
+// and its graph with that of its chunks if the pipeline has a
+// KnowledgeGraph.
+func (p *Pipeline) importDocument(ctx context.Context, doc *snapshotDocument) error {
+	graphs, err := p.snapshotGraphs(ctx, []*snapshotDocument{doc})
+	if err != nil {
+		return err
+	}
+	if err := p.delete(ctx, doc.ID, true); err != nil {
+		return err
+	}
+	if err := writeDocument(ctx, p.Store, p.EmbeddingModel, doc); err != nil {
+		return err
+	}
+	if p.Keywords != nil {
+		p.Keywords.Upsert(ctx, doc.Chunks)
+	}
+	if p.Graph != nil {
+		if err := p.Graph.replace(ctx, doc.ID, doc.Chunks, graphs[0]); err != nil {
+			return fmt.Errorf("storing graph: %w", err)
+		}
+	}
+	return nil
+}
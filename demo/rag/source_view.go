@@ -0,0 +1,148 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Metadata keys of the offsets of a chunk in the text of its document, see
+// Document.Text: the characters at which the chunk starts and, exclusive,
+// ends. Characters are counted in Unicode code points.
+const (
+	CharStartKey = "char_start"
+	CharEndKey   = "char_end"
+)
+
+// A Span is a range of the characters of the text of a document, from Start
+// up to, exclusive, End.
+type Span struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// chunkSpan returns the Span of a chunk with the given metadata, if it
+// records one.
+func chunkSpan(metadata Metadata) (*Span, bool) {
+	start, err := strconv.Atoi(metadata[CharStartKey])
+	if err != nil {
+		return nil, false
+	}
+	end, err := strconv.Atoi(metadata[CharEndKey])
+	if err != nil || end < start {
+		return nil, false
+	}
+	return &Span{Start: start, End: end}, true
+}
+
+// A Highlight is the Span of a chunk in the text of a SourceView.
+type Highlight struct {
+	ChunkID string `json:"chunk_id"`
+	Span
+}
+
+// A SourceView is the text of a stored document, see Document.Text, with
+// the spans of some of its chunks, such as those cited by an answer, to
+// highlight in it. Highlights are ordered by Start and may overlap.
+type SourceView struct {
+	ID         string      `json:"id"`
+	Text       string      `json:"text"`
+	Metadata   Metadata    `json:"metadata,omitempty"`
+	Highlights []Highlight `json:"highlights"`
+}
+
+// SourceView returns the text of the stored document docID with the spans
+// of the chunks or parent chunks with the given IDs highlighted, so that
+// the passages an answer came from can be shown where they are. The store
+// must be a SourceStore to keep the text, and a ChunkStore or ParentStore
+// to return the chunks. Chunks stored without offsets, such as those
+// ingested before chunks had them, are not highlighted; IDs of chunks not
+// stored for the document fail with ErrInvalidRequest.
+//
+// Documents with an ACL none of the principals of ctx are on are not found,
+// as when retrieving them, see WithPrincipals.
+func (p *Pipeline) SourceView(ctx context.Context, docID string, chunkIDs []string) (*SourceView, error) {
+	s, ok := p.Store.(SourceStore)
+	if !ok {
+		return nil, fmt.Errorf("viewing sources is %w by the vector store", ErrNotSupported)
+	}
+	doc, err := s.Source(ctx, docID)
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil || !visible(doc, PrincipalsFrom(ctx)) {
+		return nil, fmt.Errorf("%w: %s", ErrDocumentNotFound, docID)
+	}
+	chunks, err := p.storedChunks(ctx, docID, chunkIDs)
+	if err != nil {
+		return nil, err
+	}
+	view := &SourceView{ID: doc.ID, Text: doc.Text(), Metadata: doc.Metadata, Highlights: []Highlight{}}
+	for _, c := range chunks {
+		if span, ok := chunkSpan(c.Metadata); ok {
+			view.Highlights = append(view.Highlights, Highlight{ChunkID: c.ID, Span: *span})
+		}
+	}
+	slices.SortStableFunc(view.Highlights, func(a, b Highlight) int { return a.Start - b.Start })
+	return view, nil
+}
+
+// visible reports whether the principals may see every section of doc.
+func visible(doc *Document, principals []string) bool {
+	for _, section := range doc.Sections {
+		if !allowed(doc.Metadata.merge(section.Metadata), principals) {
+			return false
+		}
+	}
+	return allowed(doc.Metadata, principals)
+}
+
+// storedChunks returns the stored chunks and parent chunks of docID with
+// the given IDs, in their order.
+func (p *Pipeline) storedChunks(ctx context.Context, docID string, ids []string) ([]Chunk, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	found := make(map[string]Chunk)
+	var parentIDs []string
+	for _, id := range ids {
+		if strings.HasPrefix(id, docID+"#p") {
+			parentIDs = append(parentIDs, id)
+		}
+	}
+	if ps, ok := p.Store.(ParentStore); ok && len(parentIDs) > 0 {
+		parents, err := ps.Parents(ctx, parentIDs)
+		if err != nil {
+			return nil, err
+		}
+		for id, c := range parents {
+			if c.DocID == docID {
+				found[id] = c
+			}
+		}
+	}
+	if len(found) < len(ids) {
+		cs, ok := p.Store.(ChunkStore)
+		if !ok {
+			return nil, fmt.Errorf("listing chunks is %w by the vector store", ErrNotSupported)
+		}
+		chunks, err := cs.Chunks(ctx, docID)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range chunks {
+			found[c.ID] = c
+		}
+	}
+	chunks := make([]Chunk, len(ids))
+	for i, id := range ids {
+		c, ok := found[id]
+		if !ok {
+			return nil, invalidRequest("%s has no chunk %s", docID, id)
+		}
+		chunks[i] = c
+	}
+	return chunks, nil
+}
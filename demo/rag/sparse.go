@@ -0,0 +1,142 @@
+package rag
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SparseVector is a sparse embedding, such as a SPLADE vector, giving the
+// weights of a few of many dimensions, usually vocabulary terms. Indices
+// are distinct and Values holds the weight of each.
+type SparseVector struct {
+	Indices []uint32  `json:"indices"`
+	Values  []float32 `json:"values"`
+}
+
+// A SparseEmbedder turns text into sparse vectors, one per input text in
+// the same order as the inputs. Unlike dense embeddings, sparse vectors of
+// learned models such as SPLADE weight the terms of the text and the terms
+// related to them, so they match rare words and exact names the way
+// keyword search does.
+type SparseEmbedder interface {
+	EmbedSparse(ctx context.Context, texts []string) ([]SparseVector, error)
+}
+
+// NewSparseEmbedder returns the SparseEmbedder selected by
+// cfg.SparseEmbedder: off or tei. It returns nil if it is off.
+func NewSparseEmbedder(cfg Config) (SparseEmbedder, error) {
+	switch cfg.SparseEmbedder {
+	case "", "off":
+		return nil, nil
+	case "tei":
+		e := NewTEISparseEmbedder(cfg.SparseURL)
+		e.Client = newProviderClient(cfg)
+		return e, nil
+	}
+	return nil, fmt.Errorf("unknown sparse embedder %q", cfg.SparseEmbedder)
+}
+
+// A SparseStore also stores the sparse vectors of chunks and searches them
+// by dot product. Chunks upserted with a Sparse vector can be found by
+// SearchSparse, which otherwise behaves like Search.
+type SparseStore interface {
+	VectorStore
+	SearchSparse(ctx context.Context, query SparseVector, k int, filter Filter) ([]SearchResult, error)
+}
+
+// SparseRetriever embeds the query with a SparseEmbedder and searches the
+// sparse vectors of a SparseStore with it.
+type SparseRetriever struct {
+	Embedder SparseEmbedder
+	Store    SparseStore
+}
+
+func (r *SparseRetriever) Retrieve(ctx context.Context, query string, k int, filter Filter) ([]SearchResult, error) {
+	start := time.Now()
+	vectors, err := r.Embedder.EmbedSparse(ctx, []string{query})
+	benchTimed(ctx, StageEmbed, start)
+	if err != nil {
+		return nil, fmt.Errorf("embedding query: %w", err)
+	}
+	start = time.Now()
+	results, err := r.Store.SearchSparse(ctx, vectors[0], k, filter)
+	benchTimed(ctx, StageSearch, start)
+	recordScores(ctx, sparseScore, results)
+	return results, err
+}
+
+// TEISparseEmbedder embeds text with a sparse model such as
+// naver/splade-v3 served by Hugging Face's text-embeddings-inference.
+type TEISparseEmbedder struct {
+	Client *http.Client // http.DefaultClient if nil
+
+	baseURL string
+}
+
+// NewTEISparseEmbedder creates a TEISparseEmbedder talking to the
+// text-embeddings-inference server at baseURL, e.g. http://localhost:8080.
+func NewTEISparseEmbedder(baseURL string) *TEISparseEmbedder {
+	return &TEISparseEmbedder{baseURL: strings.TrimRight(baseURL, "/")}
+}
+
+func (e *TEISparseEmbedder) EmbedSparse(ctx context.Context, texts []string) ([]SparseVector, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	body, err := json.Marshal(map[string]any{"inputs": texts, "truncate": true})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.baseURL+"/embed_sparse", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := cmp.Or(e.Client, http.DefaultClient).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var res struct {
+			Error string `json:"error"`
+		}
+		json.Unmarshal(data, &res)
+		return nil, fmt.Errorf("tei: %s: %s", resp.Status, cmp.Or(res.Error, strings.TrimSpace(string(data))))
+	}
+	var res [][]struct {
+		Index uint32  `json:"index"`
+		Value float32 `json:"value"`
+	}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, fmt.Errorf("tei: decoding response: %w", err)
+	}
+	if len(res) != len(texts) {
+		return nil, fmt.Errorf("tei: got %d sparse vectors for %d inputs", len(res), len(texts))
+	}
+	vectors := make([]SparseVector, len(res))
+	for i, terms := range res {
+		v := SparseVector{Indices: make([]uint32, len(terms)), Values: make([]float32, len(terms))}
+		for j, t := range terms {
+			v.Indices[j], v.Values[j] = t.Index, t.Value
+		}
+		vectors[i] = v
+	}
+	return vectors, nil
+}
+
+// errNoSparseStore is returned for configurations needing a SparseStore
+// with a store that is not one.
+var errNoSparseStore = errors.New("sparse vectors need a vector store that keeps them: qdrant or milvus")
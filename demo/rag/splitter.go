@@ -0,0 +1,234 @@
+package rag
+
+import (
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A Splitter cuts text into chunks small enough to embed.
+type Splitter interface {
+	Split(text string) []string
+}
+
+// DefaultSeparators are tried in order by RecursiveSplitter: Markdown
+// headings, then paragraphs, lines, sentences and finally words.
+var DefaultSeparators = []string{"\n# ", "\n## ", "\n### ", "\n\n", "\n", ". ", "? ", "! ", "; ", " "}
+
+// RecursiveSplitter splits text on the coarsest separator that occurs in it,
+// recursing with finer separators into pieces that are still too large, and
+// then greedily merges neighbouring pieces back up to ChunkSize characters.
+// Consecutive chunks share up to ChunkOverlap characters of context.
+// Heading separators stay with the section they introduce; all others stay
+// with the text preceding them.
+type RecursiveSplitter struct {
+	ChunkSize    int
+	ChunkOverlap int
+	Separators   []string // DefaultSeparators if nil
+}
+
+// NewRecursiveSplitter creates a RecursiveSplitter using DefaultSeparators.
+func NewRecursiveSplitter(size, overlap int) (*RecursiveSplitter, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("chunk size must be positive, got %d", size)
+	}
+	if overlap < 0 || overlap >= size {
+		return nil, fmt.Errorf("chunk overlap must be between 0 and the chunk size %d, got %d", size, overlap)
+	}
+	return &RecursiveSplitter{ChunkSize: size, ChunkOverlap: overlap}, nil
+}
+
+func (s *RecursiveSplitter) Split(text string) []string {
+	separators := s.Separators
+	if separators == nil {
+		separators = DefaultSeparators
+	}
+	var chunks []string
+	for _, chunk := range s.split(text, separators) {
+		if chunk = strings.TrimSpace(chunk); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+	}
+	return chunks
+}
+
+func (s *RecursiveSplitter) split(text string, separators []string) []string {
+	if utf8.RuneCountInString(text) <= s.ChunkSize {
+		return []string{text}
+	}
+	// Fall back to splitting between characters if no separator occurs
+	sep, finer := "", []string(nil)
+	for i, candidate := range separators {
+		if strings.Contains(text, candidate) {
+			sep, finer = candidate, separators[i+1:]
+			break
+		}
+	}
+	var chunks, pending []string
+	for _, piece := range splitKeep(text, sep) {
+		if utf8.RuneCountInString(piece) <= s.ChunkSize {
+			pending = append(pending, piece)
+			continue
+		}
+		chunks = append(chunks, s.merge(pending)...)
+		pending = nil
+		chunks = append(chunks, s.split(piece, finer)...)
+	}
+	return append(chunks, s.merge(pending)...)
+}
+
+// merge joins consecutive pieces into chunks of at most ChunkSize
+// characters, starting each chunk with up to ChunkOverlap characters from
+// the end of the previous one.
+func (s *RecursiveSplitter) merge(pieces []string) []string {
+	var chunks, window []string
+	size := 0
+	for _, piece := range pieces {
+		n := utf8.RuneCountInString(piece)
+		if size+n > s.ChunkSize && len(window) > 0 {
+			chunks = append(chunks, strings.Join(window, ""))
+			for len(window) > 0 && (size > s.ChunkOverlap || size+n > s.ChunkSize) {
+				size -= utf8.RuneCountInString(window[0])
+				window = window[1:]
+			}
+		}
+		window = append(window, piece)
+		size += n
+	}
+	if len(window) > 0 {
+		chunks = append(chunks, strings.Join(window, ""))
+	}
+	return chunks
+}
+
+// splitKeep splits text around sep without dropping it, so that joining the
+// pieces gives back text. An empty sep splits between characters.
+func splitKeep(text, sep string) []string {
+	if sep == "" {
+		return strings.Split(text, "")
+	}
+	if strings.HasPrefix(sep, "\n#") {
+		// Keep headings at the start of the section they introduce
+		parts := strings.Split(text, sep)
+		for i := 1; i < len(parts); i++ {
+			parts[i] = sep[1:] + parts[i]
+			parts[i-1] += "\n"
+		}
+		return parts
+	}
+	return strings.SplitAfter(text, sep)
+}
+
+// ChunkDocument splits every section of doc into chunks. Chunk IDs are the
+// document ID followed by the chunk's position, e.g. "notes.md#3". Chunks
+// found in the text of doc, as those of RecursiveSplitter are, have their
+// offsets in it in their CharStartKey and CharEndKey metadata.
+func ChunkDocument(doc *Document, splitter Splitter) []Chunk {
+	var chunks []Chunk
+	offset := 0
+	for _, section := range doc.Sections {
+		metadata := doc.Metadata.merge(section.Metadata)
+		texts := splitter.Split(section.Text)
+		for i, span := range locate(section.Text, texts) {
+			text, metadata := texts[i], withSpan(metadata, offset, span)
+			chunks = append(chunks, Chunk{
+				ID:       fmt.Sprintf("%s#%d", doc.ID, len(chunks)),
+				DocID:    doc.ID,
+				Index:    len(chunks),
+				Text:     text,
+				Metadata: metadata,
+				Hash:     chunkHash(text, "", metadata),
+			})
+		}
+		offset += sectionLength(section)
+	}
+	return chunks
+}
+
+// ChunkWithParents splits every section of doc into parent chunks with
+// parentSplitter and every parent into child chunks with splitter. Children
+// are numbered across the document as in ChunkDocument and carry the ID of
+// their parent; parent IDs are the document ID followed by "#p" and the
+// parent's position, e.g. "notes.md#p1". Parents are not embedded, see
+// ParentRetriever. Parents and children have their offsets in the text of
+// doc in their metadata as in ChunkDocument.
+func ChunkWithParents(doc *Document, parentSplitter, splitter Splitter) (parents, children []Chunk) {
+	offset := 0
+	for _, section := range doc.Sections {
+		metadata := doc.Metadata.merge(section.Metadata)
+		parentTexts := parentSplitter.Split(section.Text)
+		for i, parentSpan := range locate(section.Text, parentTexts) {
+			parentText := parentTexts[i]
+			parent := Chunk{
+				ID:       fmt.Sprintf("%s#p%d", doc.ID, len(parents)),
+				DocID:    doc.ID,
+				Index:    len(parents),
+				Text:     parentText,
+				Metadata: withSpan(metadata, offset, parentSpan),
+			}
+			parent.Hash = chunkHash(parentText, "", parent.Metadata)
+			parents = append(parents, parent)
+			texts := splitter.Split(parentText)
+			for j, span := range locate(parentText, texts) {
+				if parentSpan.Start < 0 {
+					span.Start = -1
+				}
+				text, metadata := texts[j], withSpan(metadata, offset+parentSpan.Start, span)
+				children = append(children, Chunk{
+					ID:       fmt.Sprintf("%s#%d", doc.ID, len(children)),
+					DocID:    doc.ID,
+					ParentID: parent.ID,
+					Index:    len(children),
+					Text:     text,
+					Metadata: metadata,
+					Hash:     chunkHash(text, parent.ID, metadata),
+				})
+			}
+		}
+		offset += sectionLength(section)
+	}
+	return parents, children
+}
+
+// sectionLength is the length in characters of section in the text of its
+// document, the blank line separating it from the next one included.
+func sectionLength(section Section) int {
+	return utf8.RuneCountInString(section.Text) + len("\n\n")
+}
+
+// locate returns the Spans of pieces, cut in order from text, within text.
+// Pieces not found in it, such as those a Splitter rewrote, have a Start of
+// -1.
+func locate(text string, pieces []string) []Span {
+	spans := make([]Span, len(pieces))
+	from, runes := 0, 0 // the byte searched from and its offset in characters
+	for i, piece := range pieces {
+		j := strings.Index(text[from:], piece)
+		if j < 0 {
+			spans[i] = Span{Start: -1, End: -1}
+			continue
+		}
+		start := runes + utf8.RuneCountInString(text[from:from+j])
+		spans[i] = Span{Start: start, End: start + utf8.RuneCountInString(piece)}
+		// Pieces may overlap the one before them, but start after it
+		_, size := utf8.DecodeRuneInString(text[from+j:])
+		from, runes = from+j+size, start+1
+	}
+	return spans
+}
+
+// withSpan returns metadata with the offsets of span, a span within text
+// starting at offset in the document, or metadata itself if span was not
+// found.
+func withSpan(metadata Metadata, offset int, span Span) Metadata {
+	if span.Start < 0 {
+		return metadata
+	}
+	m := make(Metadata, len(metadata)+2)
+	maps.Copy(m, metadata)
+	m[CharStartKey] = strconv.Itoa(offset + span.Start)
+	m[CharEndKey] = strconv.Itoa(offset + span.End)
+	return m
+}
@@ -0,0 +1,81 @@
+package rag
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/url"
+
+	_ "modernc.org/sqlite"
+)
+
+// openSQLite opens or creates the SQLite database at path and applies
+// migrations in order. The number applied is recorded in the database's
+// user_version, so each statement runs exactly once per file.
+func openSQLite(ctx context.Context, path string, migrations []string) (*sql.DB, error) {
+	dsn := "file:" + (&url.URL{Path: path}).EscapedPath() +
+		"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+	// SQLite allows a single writer, and every connection to ":memory:"
+	// would see a database of its own
+	db.SetMaxOpenConns(1)
+	err = sqliteTx(ctx, db, func(tx *sql.Tx) error {
+		var applied int
+		if err := tx.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&applied); err != nil {
+			return fmt.Errorf("reading schema version of %s: %w", path, err)
+		}
+		for version := applied; version < len(migrations); version++ {
+			if _, err := tx.ExecContext(ctx, migrations[version]); err != nil {
+				return fmt.Errorf("migration %d: %w", version+1, err)
+			}
+		}
+		if applied < len(migrations) {
+			// PRAGMA does not take parameters
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d`, len(migrations))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// sqliteTx runs fn in a transaction, committing it if fn succeeds.
+func sqliteTx(ctx context.Context, db *sql.DB, fn func(*sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// encodeVector packs v into little-endian float32s.
+func encodeVector(v []float32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(x))
+	}
+	return b
+}
+
+// decodeVector is the inverse of encodeVector.
+func decodeVector(b []byte) []float32 {
+	v := make([]float32, len(b)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return v
+}
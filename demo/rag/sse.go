@@ -0,0 +1,75 @@
+package rag
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// SSEWriter writes Server-Sent Events to an HTTP response, flushing after
+// every event so clients can render output incrementally.
+type SSEWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// NewSSEWriter sets the event stream headers on w. It fails if w does not
+// support flushing.
+func NewSSEWriter(w http.ResponseWriter) (*SSEWriter, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errors.New("sse: response writer does not support flushing")
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return &SSEWriter{w: w, flusher: flusher}, nil
+}
+
+// Event sends a named event whose data is the JSON encoding of v.
+func (s *SSEWriter) Event(name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", name, data); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// StreamHandler serves chat completions from llm as Server-Sent Events. The
+// request body is a JSON object with a "messages" array; the response is a
+// series of "delta" events followed by a single "done" or "error" event.
+func StreamHandler(llm LLM) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Messages []Message `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, invalidRequest("invalid request body: %w", err))
+			return
+		}
+		if len(req.Messages) == 0 {
+			writeError(w, invalidRequest("messages must not be empty"))
+			return
+		}
+		sse, err := NewSSEWriter(w)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		err = llm.Stream(r.Context(), req.Messages, func(delta string) error {
+			return sse.Event("delta", map[string]string{"text": delta})
+		})
+		if err != nil {
+			sse.Event("error", errorBody(err))
+			return
+		}
+		sse.Event("done", struct{}{})
+	})
+}
@@ -0,0 +1,231 @@
+package rag
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// LoadFunc prepares a document read from its source for chunking; by
+// default it tags the document with its language if the pipeline detects
+// languages. A document it fails for is not ingested, and the error is
+// reported in the document's IngestResult.
+type LoadFunc func(ctx context.Context, doc *Document) (*Document, error)
+
+// ChunkFunc cuts a document into the chunks that are embedded and, with a
+// ParentSplitter, the parents they are cut from; by default it calls
+// ChunkDocument or ChunkWithParents. The chunks it returns are hashed again,
+// so that chunks whose text or metadata it changed are embedded again. A
+// document it fails for is not ingested.
+type ChunkFunc func(ctx context.Context, doc *Document) (chunks, parents []Chunk, err error)
+
+// EmbedFunc embeds the texts of the changed chunks of all documents ingested
+// together, as embedBatches does by default, returning the vectors in the
+// order of texts. A *BatchError leaves the chunks of the texts without a
+// vector, and their documents, unstored; any other error aborts the
+// ingestion.
+type EmbedFunc func(ctx context.Context, texts []string) ([][]float32, error)
+
+// StoreFunc writes an ingested document to the vector store, the keyword
+// index and the pipeline's other stores.
+type StoreFunc func(ctx context.Context, w DocumentWrite) error
+
+// RetrieveFunc retrieves the k chunks most relevant to a query, with the
+// pipeline's Retriever by default. It is called once for every search of a
+// question, of which a RetrievalAgent may make several.
+type RetrieveFunc func(ctx context.Context, query string, k int, filter Filter) ([]SearchResult, error)
+
+// RerankFunc reorders the retrieved chunks by their relevance to the query.
+// With a RerankRetriever it wraps the Reranker, which is given the
+// candidates before they are cut to k; without one it is passed the
+// retrieved results and returns them unchanged by default.
+type RerankFunc func(ctx context.Context, query string, results []SearchResult) ([]SearchResult, error)
+
+// PromptRequest is what the prompt of a question is built from: the
+// question, the retrieved chunks and the conversation of its session, if
+// any.
+type PromptRequest struct {
+	Question string
+	Sources  []SearchResult
+	History  *Conversation
+}
+
+// PromptFunc builds the messages sent to the LLM, returning the sources
+// they give it; by default it renders the pipeline's Prompt, with the
+// sources fitted into its Budget as its Overflow says.
+type PromptFunc func(ctx context.Context, req PromptRequest) (messages []Message, sources []SearchResult, err error)
+
+// GenerateFunc asks the LLM to reply to messages. If onDelta is not nil the
+// reply is streamed to it as it is generated; it is nil when the reply is
+// not streamed, as for answers in FormatJSON, whose reply is the JSON text.
+type GenerateFunc func(ctx context.Context, messages []Message, onDelta func(string) error) (string, error)
+
+// Middleware wraps stages of a pipeline: documents are loaded, chunked,
+// embedded and stored when they are ingested, and questions have chunks
+// retrieved and reranked for them before the prompt is built and the answer
+// generated. Each field that is not nil is given the function running the
+// rest of its stage and returns the function run instead, which usually
+// calls it, and may change what it is passed or returns, or fail it:
+//
+//	rag.Middleware{Embed: func(next rag.EmbedFunc) rag.EmbedFunc {
+//		return func(ctx context.Context, texts []string) ([][]float32, error) {
+//			start := time.Now()
+//			vectors, err := next(ctx, texts)
+//			embedSeconds.Observe(time.Since(start).Seconds())
+//			return vectors, err
+//		}
+//	}}
+type Middleware struct {
+	Load     func(LoadFunc) LoadFunc
+	Chunk    func(ChunkFunc) ChunkFunc
+	Embed    func(EmbedFunc) EmbedFunc
+	Store    func(StoreFunc) StoreFunc
+	Retrieve func(RetrieveFunc) RetrieveFunc
+	Rerank   func(RerankFunc) RerankFunc
+	Prompt   func(PromptFunc) PromptFunc
+	Generate func(GenerateFunc) GenerateFunc
+}
+
+// Use registers middleware with the pipeline. Middleware registered first
+// runs first, wrapping that registered after it. Use must not be called
+// while the pipeline is ingesting or answering.
+func (p *Pipeline) Use(middleware ...Middleware) {
+	p.Middleware = append(p.Middleware, middleware...)
+}
+
+// wrapStage returns stage wrapped in the functions of middleware that wrap
+// returns, the first outermost.
+func wrapStage[F any](stage F, middleware []Middleware, wrap func(Middleware) func(F) F) F {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		if w := wrap(middleware[i]); w != nil {
+			stage = w(stage)
+		}
+	}
+	return stage
+}
+
+func (p *Pipeline) loadStage() LoadFunc {
+	return wrapStage[LoadFunc](func(_ context.Context, doc *Document) (*Document, error) {
+		if p.Languages != "" {
+			tagLanguage(doc)
+		}
+		return doc, nil
+	}, p.Middleware, func(m Middleware) func(LoadFunc) LoadFunc { return m.Load })
+}
+
+func (p *Pipeline) chunkStage() ChunkFunc {
+	return wrapStage[ChunkFunc](func(ctx context.Context, doc *Document) (chunks, parents []Chunk, err error) {
+		splitter, err := p.Profiles.splitter(ctx, p.Splitter)
+		if err != nil {
+			return nil, nil, err
+		}
+		if p.ParentSplitter != nil {
+			parents, chunks = ChunkWithParents(doc, p.ParentSplitter, splitter)
+			return chunks, parents, nil
+		}
+		return ChunkDocument(doc, splitter), nil, nil
+	}, p.Middleware, func(m Middleware) func(ChunkFunc) ChunkFunc { return m.Chunk })
+}
+
+func (p *Pipeline) embedStage() EmbedFunc {
+	return wrapStage[EmbedFunc](p.embedBatches, p.Middleware, func(m Middleware) func(EmbedFunc) EmbedFunc { return m.Embed })
+}
+
+func (p *Pipeline) storeStage() StoreFunc {
+	return wrapStage[StoreFunc](p.store, p.Middleware, func(m Middleware) func(StoreFunc) StoreFunc { return m.Store })
+}
+
+// retrieveStage, rerankStage and generateStage run their stage within its
+// timeout, see Timeouts.
+func (p *Pipeline) retrieveStage() RetrieveFunc {
+	stage := wrapStage[RetrieveFunc](p.Retriever.Retrieve, p.Middleware, func(m Middleware) func(RetrieveFunc) RetrieveFunc { return m.Retrieve })
+	return func(ctx context.Context, query string, k int, filter Filter) ([]SearchResult, error) {
+		ctx, cancel := withStageTimeout(ctx, StageRetrieve, timeoutsFrom(ctx).Retrieve)
+		defer cancel()
+		results, err := stage(ctx, query, k, filter)
+		return results, timedOut(ctx, err)
+	}
+}
+
+func (p *Pipeline) rerankStage(rerank RerankFunc) RerankFunc {
+	stage := wrapStage[RerankFunc](rerank, p.Middleware, func(m Middleware) func(RerankFunc) RerankFunc { return m.Rerank })
+	return func(ctx context.Context, query string, results []SearchResult) ([]SearchResult, error) {
+		ctx, cancel := withStageTimeout(ctx, StageRerank, timeoutsFrom(ctx).Rerank)
+		defer cancel()
+		reranked, err := stage(ctx, query, results)
+		return reranked, timedOut(ctx, err)
+	}
+}
+
+func (p *Pipeline) promptStage() PromptFunc {
+	return wrapStage[PromptFunc](p.buildPrompt, p.Middleware, func(m Middleware) func(PromptFunc) PromptFunc { return m.Prompt })
+}
+
+func (p *Pipeline) generateStage(generate GenerateFunc) GenerateFunc {
+	stage := wrapStage[GenerateFunc](generate, p.Middleware, func(m Middleware) func(GenerateFunc) GenerateFunc { return m.Generate })
+	return func(ctx context.Context, messages []Message, onDelta func(string) error) (string, error) {
+		ctx, cancel := withStageTimeout(ctx, StageGenerate, timeoutsFrom(ctx).Generate)
+		defer cancel()
+		text, err := stage(ctx, messages, onDelta)
+		return text, timedOut(ctx, err)
+	}
+}
+
+// generate is the default Generate stage, calling the LLM's Stream method
+// if onDelta is not nil and its Generate method otherwise.
+func (p *Pipeline) generate(ctx context.Context, messages []Message, onDelta func(string) error) (string, error) {
+	if onDelta == nil {
+		return p.LLM.Generate(ctx, messages)
+	}
+	var text strings.Builder
+	err := p.LLM.Stream(ctx, messages, func(delta string) error {
+		text.WriteString(delta)
+		return onDelta(delta)
+	})
+	return text.String(), err
+}
+
+// rerankScope holds the pipeline whose Rerank stage a RerankRetriever
+// runs its Reranker in; ran is set once one has.
+type rerankScope struct {
+	p   *Pipeline
+	ran atomic.Bool
+}
+
+type rerankScopeKey struct{}
+
+// withRerankStage returns a context in which a RerankRetriever runs its
+// Reranker as the Rerank stage of p.
+func (p *Pipeline) withRerankStage(ctx context.Context) (context.Context, *rerankScope) {
+	scope := &rerankScope{p: p}
+	return context.WithValue(ctx, rerankScopeKey{}, scope), scope
+}
+
+// rerankStageFor returns rerank wrapped as the Rerank stage of the pipeline
+// retrieving in ctx, if any.
+func rerankStageFor(ctx context.Context, rerank RerankFunc) RerankFunc {
+	scope, ok := ctx.Value(rerankScopeKey{}).(*rerankScope)
+	if !ok {
+		return rerank
+	}
+	scope.ran.Store(true)
+	return scope.p.rerankStage(rerank)
+}
+
+// rerankUnranked runs the Rerank stage of a retrieval in which no
+// RerankRetriever ran it, if a middleware wraps it, returning the results
+// unchanged by default.
+func (p *Pipeline) rerankUnranked(ctx context.Context, scope *rerankScope, query string, results []SearchResult) ([]SearchResult, error) {
+	if scope.ran.Load() || !slices.ContainsFunc(p.Middleware, func(m Middleware) bool { return m.Rerank != nil }) {
+		return results, nil
+	}
+	reranked, err := p.rerankStage(func(_ context.Context, _ string, results []SearchResult) ([]SearchResult, error) {
+		return results, nil
+	})(ctx, query, results)
+	if isTimeout(err, StageRerank) {
+		skipTimedOut(ctx, StageRerank)
+		return results, nil
+	}
+	return reranked, err
+}
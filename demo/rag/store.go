@@ -0,0 +1,194 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// A VectorStore persists chunks and finds the ones most similar to a query
+// embedding. Upsert replaces chunks with the same ID; Search returns at most
+// k results whose metadata matches filter, best first.
+// Documents lists the stored documents ordered by ID.
+//
+// Chunks are kept in namespaces. Every method except the namespace ones acts
+// on the namespace of its context, see WithNamespace, and never sees chunks
+// of another; chunk IDs only need to be unique within a namespace. Upsert,
+// Search and Documents return ErrNamespaceNotFound for a namespace that was
+// not created, while deleting from one does nothing. Namespaces lists the
+// namespaces ordered by name, including DefaultNamespace.
+type VectorStore interface {
+	Upsert(ctx context.Context, chunks []Chunk) error
+	Search(ctx context.Context, query []float32, k int, filter Filter) ([]SearchResult, error)
+	Delete(ctx context.Context, docID string) error
+	Documents(ctx context.Context) ([]DocumentInfo, error)
+
+	CreateNamespace(ctx context.Context, name string) error
+	DeleteNamespace(ctx context.Context, name string) error
+	Namespaces(ctx context.Context) ([]NamespaceInfo, error)
+}
+
+// An IncrementalStore can report the chunks it holds for a document and
+// delete individual chunks, which lets ingestion re-embed only the chunks of
+// a document that changed. ChunkHashes maps chunk IDs to their Hash and,
+// like Search, fails with ErrNamespaceNotFound for unknown namespaces.
+type IncrementalStore interface {
+	VectorStore
+	ChunkHashes(ctx context.Context, docID string) (map[string]string, error)
+	DeleteChunks(ctx context.Context, ids []string) error
+}
+
+// A SourceStore also keeps the document each set of chunks was cut from, so
+// that documents can be chunked again without loading their sources, see
+// Pipeline.Rechunk. PutSource replaces the document with the same ID;
+// Source returns nil for documents whose source was not stored. Delete and
+// DeleteNamespace remove sources along with chunks.
+type SourceStore interface {
+	VectorStore
+	PutSource(ctx context.Context, doc *Document) error
+	Source(ctx context.Context, docID string) (*Document, error)
+}
+
+// A ParentStore also keeps the parent chunks that chunks with a ParentID
+// were cut from, see ChunkWithParents. Parents are stored without
+// embeddings and are never searched. ReplaceParents replaces all parents of
+// a document; Parents returns the stored parents with the given IDs, keyed
+// by ID, and skips unknown ones. Delete and DeleteNamespace remove parents
+// along with chunks.
+type ParentStore interface {
+	VectorStore
+	ReplaceParents(ctx context.Context, docID string, parents []Chunk) error
+	Parents(ctx context.Context, ids []string) (map[string]Chunk, error)
+}
+
+// A ChunkStore can return the chunks it holds for a document, embeddings
+// included, which lets the index be copied to another store, see
+// Pipeline.Export. Chunks are ordered by Index and, like Search, Chunks
+// fails with ErrNamespaceNotFound for unknown namespaces.
+type ChunkStore interface {
+	VectorStore
+	Chunks(ctx context.Context, docID string) ([]Chunk, error)
+}
+
+// A MetadataStore can find the chunks whose metadata has a key without
+// reading their text or embeddings, which lets documents be listed by
+// their metadata, see Pipeline.Expiring. ChunkMetadata returns the ID,
+// DocID and Metadata of those chunks, in no particular order, and, like
+// Search, fails with ErrNamespaceNotFound for unknown namespaces.
+type MetadataStore interface {
+	VectorStore
+	ChunkMetadata(ctx context.Context, key string) ([]Chunk, error)
+}
+
+// An AtomicStore is an IncrementalStore that can write everything the
+// ingestion of a document changes in one transaction, so that an
+// interrupted ingestion leaves either the old or the new version of the
+// document and never a mix of both. ReplaceDocument deletes the stale
+// chunks, upserts the changed ones and, in a SourceStore or ParentStore,
+// replaces the document's source and parents.
+type AtomicStore interface {
+	IncrementalStore
+	ReplaceDocument(ctx context.Context, w DocumentWrite) error
+}
+
+// DocumentWrite is what ingesting Document writes to an AtomicStore: the
+// Changed chunks to upsert, the IDs of the Stale chunks to delete and the
+// Parents replacing those stored for the document. Chunks holds all chunks
+// of the document, changed or not, for stores that replace them all.
+type DocumentWrite struct {
+	Document *Document
+	Chunks   []Chunk
+	Changed  []Chunk
+	Stale    []string
+	Parents  []Chunk
+}
+
+// DocumentInfo summarizes a document held in a VectorStore.
+type DocumentInfo struct {
+	ID     string `json:"id"`
+	Chunks int    `json:"chunks"`
+}
+
+// Metric is the similarity function used to compare embeddings.
+type Metric string
+
+const (
+	MetricCosine       Metric = "cosine"
+	MetricInnerProduct Metric = "ip"
+)
+
+// NewVectorStore returns the VectorStore selected by cfg.VectorStore.
+func NewVectorStore(ctx context.Context, cfg Config) (VectorStore, error) {
+	metric := Metric(cfg.Metric)
+	switch metric {
+	case "":
+		metric = MetricCosine
+	case MetricCosine, MetricInnerProduct:
+	default:
+		return nil, fmt.Errorf("unknown metric %q", cfg.Metric)
+	}
+	quantization, err := ParseQuantization(cfg.Quantization)
+	if err != nil {
+		return nil, err
+	}
+	if quantization != QuantizationOff && !slices.Contains([]string{"", "sqlite", "memory"}, cfg.VectorStore) {
+		return nil, fmt.Errorf("VECTOR_QUANTIZATION is only supported by the sqlite and memory stores, not %s", cfg.VectorStore)
+	}
+	if cfg.Shards > 0 || cfg.ShardURLs != "" {
+		return newShardedStore(ctx, cfg)
+	}
+	switch cfg.VectorStore {
+	case "", "sqlite":
+		return NewSQLiteStore(ctx, cfg.SQLitePath, metric, quantization)
+	case "memory":
+		return NewMemoryStore(metric, quantization), nil
+	case "pgvector":
+		return NewPGVectorStore(ctx, cfg.DatabaseURL, metric)
+	case "qdrant":
+		return NewQdrantStore(ctx, cfg.QdrantURL, cfg.QdrantAPIKey, cfg.Collection, metric)
+	case "weaviate":
+		return NewWeaviateStore(ctx, cfg.WeaviateURL, cfg.WeaviateAPIKey, cfg.Collection, metric)
+	case "milvus":
+		index := MilvusIndex{Type: cfg.MilvusIndex}
+		if err := milvusParams("MILVUS_INDEX_PARAMS", cfg.MilvusIndexOpts, &index.Params); err != nil {
+			return nil, err
+		}
+		if err := milvusParams("MILVUS_SEARCH_PARAMS", cfg.MilvusSearchOpts, &index.SearchParams); err != nil {
+			return nil, err
+		}
+		return NewMilvusStore(ctx, cfg.MilvusURL, cfg.MilvusToken, cfg.Collection, metric, index)
+	case "opensearch", "elasticsearch":
+		return NewOpenSearchStore(ctx, cfg.OpenSearchURL, cfg.OpenSearchToken, cfg.Collection, metric)
+	}
+	return nil, fmt.Errorf("unknown vector store %q", cfg.VectorStore)
+}
+
+// similarity scores a and b with the given metric.
+func similarity(metric Metric, a, b []float32) float32 {
+	var dot, normA, normB float64
+	for i := range min(len(a), len(b)) {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if metric == MetricInnerProduct {
+		return float32(dot)
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return float32(dot / (math.Sqrt(normA) * math.Sqrt(normB)))
+}
+
+// sortResults orders results by descending score, breaking ties by chunk ID
+// so that searches are deterministic.
+func sortResults(results []SearchResult) {
+	slices.SortFunc(results, func(a, b SearchResult) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+}
@@ -0,0 +1,369 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// MemoryStore is a VectorStore that keeps chunks in memory and indexes
+// their embeddings in an HNSW graph, so that a search only scores a small
+// part of the chunks instead of every one. The results are approximate: a
+// search may miss a chunk slightly more similar than those it returns.
+// Namespaces with few chunks, and searches with filters that few chunks
+// match, are searched exactly by scoring every matching chunk. Searches run
+// concurrently with one another, and writes wait for the searches under
+// way. With a Quantization, the graph keeps only the codes of the
+// embeddings, and the best candidates it finds are rescored with the
+// embeddings of their chunks. Its contents are lost when the process exits.
+type MemoryStore struct {
+	mu           sync.RWMutex
+	metric       Metric
+	quantization Quantization
+	namespaces   map[string]map[string]Chunk  // namespace -> chunk ID -> chunk
+	indexes      map[string]*hnswIndex        // namespace -> index of its chunks
+	sources      map[string]map[string][]byte // namespace -> document ID -> JSON
+	parents      map[string]map[string]Chunk  // namespace -> parent ID -> parent
+	profiles     map[string]NamespaceProfile  // namespace -> profile
+	model        *IndexModel
+}
+
+// NewMemoryStore creates an empty MemoryStore that indexes embeddings with
+// quantization, QuantizationOff for the embeddings themselves.
+func NewMemoryStore(metric Metric, quantization Quantization) *MemoryStore {
+	return &MemoryStore{
+		metric:       metric,
+		quantization: quantization,
+		namespaces:   map[string]map[string]Chunk{DefaultNamespace: {}},
+		indexes:      map[string]*hnswIndex{DefaultNamespace: newHNSWIndex(metric, quantization)},
+		sources:      map[string]map[string][]byte{DefaultNamespace: {}},
+		parents:      map[string]map[string]Chunk{DefaultNamespace: {}},
+		profiles:     map[string]NamespaceProfile{},
+	}
+}
+
+// chunks returns the chunks of the namespace of ctx; s.mu must be held.
+func (s *MemoryStore) chunks(ctx context.Context) (map[string]Chunk, error) {
+	ns := NamespaceFrom(ctx)
+	chunks, ok := s.namespaces[ns]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNamespaceNotFound, ns)
+	}
+	return chunks, nil
+}
+
+func (s *MemoryStore) Upsert(ctx context.Context, chunks []Chunk) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, err := s.chunks(ctx)
+	if err != nil {
+		return err
+	}
+	index := s.indexes[NamespaceFrom(ctx)]
+	for _, c := range chunks {
+		// Callers may reuse the metadata of chunks they stored
+		c.Metadata = maps.Clone(c.Metadata)
+		stored[c.ID] = c
+		index.add(c.ID, c.Embedding)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Search(ctx context.Context, query []float32, k int, filter Filter) ([]SearchResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	chunks, err := s.chunks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var results []SearchResult
+	if len(chunks) > hnswEfSearch {
+		var match func(id string) bool
+		if len(filter) > 0 {
+			match = func(id string) bool { return filter.Match(chunks[id].Metadata) }
+		}
+		n := k * s.quantization.rescore()
+		if ids := s.indexes[NamespaceFrom(ctx)].search(query, n, max(hnswEfSearch, n), match); len(ids) >= k {
+			for _, id := range ids {
+				results = append(results, SearchResult{Chunk: chunks[id], Score: similarity(s.metric, query, chunks[id].Embedding)})
+			}
+			sortResults(results)
+			return results[:k], nil
+		}
+		// Too few of the candidates found match the filter
+	}
+	return s.searchExact(chunks, query, k, filter), nil
+}
+
+// searchExact returns the k chunks most similar to query among those
+// matching filter, scoring every one of them.
+func (s *MemoryStore) searchExact(chunks map[string]Chunk, query []float32, k int, filter Filter) []SearchResult {
+	var results []SearchResult
+	for _, c := range chunks {
+		if !filter.Match(c.Metadata) {
+			continue
+		}
+		results = append(results, SearchResult{Chunk: c, Score: similarity(s.metric, query, c.Embedding)})
+	}
+	sortResults(results)
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, docID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	chunks := s.namespaces[NamespaceFrom(ctx)]
+	for id, c := range chunks {
+		if c.DocID == docID {
+			delete(chunks, id)
+			s.indexes[NamespaceFrom(ctx)].remove(id)
+		}
+	}
+	delete(s.sources[NamespaceFrom(ctx)], docID)
+	deleteParents(s.parents[NamespaceFrom(ctx)], docID)
+	return nil
+}
+
+// deleteParents deletes the parents of a document from parents.
+func deleteParents(parents map[string]Chunk, docID string) {
+	for id, c := range parents {
+		if c.DocID == docID {
+			delete(parents, id)
+		}
+	}
+}
+
+func (s *MemoryStore) ReplaceParents(ctx context.Context, docID string, parents []Chunk) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.chunks(ctx); err != nil {
+		return err
+	}
+	stored := s.parents[NamespaceFrom(ctx)]
+	deleteParents(stored, docID)
+	for _, c := range parents {
+		stored[c.ID] = c
+	}
+	return nil
+}
+
+func (s *MemoryStore) Parents(ctx context.Context, ids []string) (map[string]Chunk, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, err := s.chunks(ctx); err != nil {
+		return nil, err
+	}
+	stored := s.parents[NamespaceFrom(ctx)]
+	parents := make(map[string]Chunk, len(ids))
+	for _, id := range ids {
+		if c, ok := stored[id]; ok {
+			parents[id] = c
+		}
+	}
+	return parents, nil
+}
+
+func (s *MemoryStore) PutSource(ctx context.Context, doc *Document) error {
+	// Store a copy that later changes to doc cannot affect
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.chunks(ctx); err != nil {
+		return err
+	}
+	s.sources[NamespaceFrom(ctx)][doc.ID] = data
+	return nil
+}
+
+func (s *MemoryStore) Source(ctx context.Context, docID string) (*Document, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, ok := s.sources[NamespaceFrom(ctx)][docID]
+	if !ok {
+		return nil, nil
+	}
+	var doc Document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+func (s *MemoryStore) IndexModel(ctx context.Context) (*IndexModel, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.model == nil {
+		return nil, nil
+	}
+	m := *s.model
+	return &m, nil
+}
+
+func (s *MemoryStore) SetIndexModel(ctx context.Context, m IndexModel) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.model = &m
+	return nil
+}
+
+func (s *MemoryStore) NamespaceProfile(ctx context.Context, name string) (*NamespaceProfile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.namespaces[name]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNamespaceNotFound, name)
+	}
+	profile, ok := s.profiles[name]
+	if !ok {
+		return nil, nil
+	}
+	return &profile, nil
+}
+
+func (s *MemoryStore) SetNamespaceProfile(ctx context.Context, name string, profile *NamespaceProfile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.namespaces[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrNamespaceNotFound, name)
+	}
+	if profile == nil {
+		delete(s.profiles, name)
+	} else {
+		s.profiles[name] = *profile
+	}
+	return nil
+}
+
+func (s *MemoryStore) ChunkHashes(ctx context.Context, docID string) (map[string]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	chunks, err := s.chunks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make(map[string]string)
+	for id, c := range chunks {
+		if c.DocID == docID {
+			hashes[id] = c.Hash
+		}
+	}
+	return hashes, nil
+}
+
+func (s *MemoryStore) Chunks(ctx context.Context, docID string) ([]Chunk, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stored, err := s.chunks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var chunks []Chunk
+	for _, c := range stored {
+		if c.DocID == docID {
+			chunks = append(chunks, c)
+		}
+	}
+	slices.SortFunc(chunks, func(a, b Chunk) int {
+		return cmp.Or(cmp.Compare(a.Index, b.Index), cmp.Compare(a.ID, b.ID))
+	})
+	return chunks, nil
+}
+
+func (s *MemoryStore) DeleteChunks(ctx context.Context, ids []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	chunks := s.namespaces[NamespaceFrom(ctx)]
+	for _, id := range ids {
+		delete(chunks, id)
+		s.indexes[NamespaceFrom(ctx)].remove(id)
+	}
+	return nil
+}
+
+func (s *MemoryStore) ChunkMetadata(ctx context.Context, key string) ([]Chunk, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	chunks, err := s.chunks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var found []Chunk
+	for _, c := range chunks {
+		if _, ok := c.Metadata[key]; ok {
+			found = append(found, Chunk{ID: c.ID, DocID: c.DocID, Metadata: maps.Clone(c.Metadata)})
+		}
+	}
+	return found, nil
+}
+
+func (s *MemoryStore) Documents(ctx context.Context) ([]DocumentInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	chunks, err := s.chunks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for _, c := range chunks {
+		counts[c.DocID]++
+	}
+	docs := make([]DocumentInfo, 0, len(counts))
+	for id, n := range counts {
+		docs = append(docs, DocumentInfo{ID: id, Chunks: n})
+	}
+	slices.SortFunc(docs, func(a, b DocumentInfo) int { return cmp.Compare(a.ID, b.ID) })
+	return docs, nil
+}
+
+func (s *MemoryStore) CreateNamespace(ctx context.Context, name string) error {
+	if err := checkNamespaceChange(name); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.namespaces[name]; ok {
+		return fmt.Errorf("%w: %s", ErrNamespaceExists, name)
+	}
+	s.namespaces[name] = make(map[string]Chunk)
+	s.indexes[name] = newHNSWIndex(s.metric, s.quantization)
+	s.sources[name] = make(map[string][]byte)
+	s.parents[name] = make(map[string]Chunk)
+	return nil
+}
+
+func (s *MemoryStore) DeleteNamespace(ctx context.Context, name string) error {
+	if err := checkNamespaceChange(name); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.namespaces[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrNamespaceNotFound, name)
+	}
+	delete(s.namespaces, name)
+	delete(s.indexes, name)
+	delete(s.sources, name)
+	delete(s.parents, name)
+	delete(s.profiles, name)
+	return nil
+}
+
+func (s *MemoryStore) Namespaces(ctx context.Context) ([]NamespaceInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	namespaces := make([]NamespaceInfo, 0, len(s.namespaces))
+	for name, chunks := range s.namespaces {
+		namespaces = append(namespaces, NamespaceInfo{Name: name, Chunks: len(chunks)})
+	}
+	slices.SortFunc(namespaces, func(a, b NamespaceInfo) int { return cmp.Compare(a.Name, b.Name) })
+	return namespaces, nil
+}
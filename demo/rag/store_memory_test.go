@@ -0,0 +1,157 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"testing"
+)
+
+const (
+	benchChunks     = 5000
+	benchDimensions = 128
+	benchK          = 10
+)
+
+// randomVector returns a unit vector of random direction.
+func randomVector(r *rand.Rand, dimensions int) []float32 {
+	v := make([]float32, dimensions)
+	var norm float64
+	for i := range v {
+		x := r.NormFloat64()
+		v[i] = float32(x)
+		norm += x * x
+	}
+	norm = math.Sqrt(norm)
+	for i := range v {
+		v[i] /= float32(norm)
+	}
+	return v
+}
+
+// randomChunks returns n chunks with random embeddings, whose IDs start
+// with prefix.
+func randomChunks(r *rand.Rand, prefix string, n, dimensions int) []Chunk {
+	chunks := make([]Chunk, n)
+	for i := range chunks {
+		chunks[i] = Chunk{
+			ID:        fmt.Sprintf("%s%d", prefix, i),
+			DocID:     fmt.Sprintf("%sdoc%d", prefix, i/10),
+			Embedding: randomVector(r, dimensions),
+		}
+	}
+	return chunks
+}
+
+// newBenchStore returns a MemoryStore holding n random chunks.
+func newBenchStore(tb testing.TB, r *rand.Rand, quantization Quantization, n, dimensions int) *MemoryStore {
+	tb.Helper()
+	s := NewMemoryStore(MetricCosine, quantization)
+	if err := s.Upsert(context.Background(), randomChunks(r, "c", n, dimensions)); err != nil {
+		tb.Fatal(err)
+	}
+	return s
+}
+
+// exactSearch searches s by scoring every chunk, as Search does without
+// the index.
+func exactSearch(s *MemoryStore, query []float32, k int) []SearchResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.searchExact(s.namespaces[DefaultNamespace], query, k, nil)
+}
+
+func BenchmarkMemoryStoreSearch(b *testing.B) {
+	ctx := context.Background()
+	r := rand.New(rand.NewPCG(1, 2))
+	queries := make([][]float32, 100)
+	for i := range queries {
+		queries[i] = randomVector(r, benchDimensions)
+	}
+	searches := map[string]func(s *MemoryStore, query []float32) error{
+		"exact": func(s *MemoryStore, query []float32) error {
+			exactSearch(s, query, benchK)
+			return nil
+		},
+		"hnsw": func(s *MemoryStore, query []float32) error {
+			_, err := s.Search(ctx, query, benchK, nil)
+			return err
+		},
+	}
+	for _, name := range []string{"exact", "hnsw"} {
+		for _, upserts := range []bool{false, true} {
+			b.Run(fmt.Sprintf("%s/upserts=%t", name, upserts), func(b *testing.B) {
+				// Every run starts from the same chunks, which upserts add to
+				s := newBenchStore(b, rand.New(rand.NewPCG(7, 8)), QuantizationOff, benchChunks, benchDimensions)
+				if upserts {
+					stop := upsertConcurrently(b, s)
+					defer stop()
+				}
+				i := 0
+				for b.Loop() {
+					if err := searches[name](s, queries[i%len(queries)]); err != nil {
+						b.Fatal(err)
+					}
+					i++
+				}
+			})
+		}
+	}
+}
+
+// upsertConcurrently upserts chunks into s, one document of 10 chunks at a
+// time, until the returned function is called.
+func upsertConcurrently(b *testing.B, s *MemoryStore) (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r := rand.New(rand.NewPCG(3, 4))
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			chunks := randomChunks(r, fmt.Sprintf("u%d-", i), 10, benchDimensions)
+			if err := s.Upsert(context.Background(), chunks); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+func TestMemoryStoreSearchRecall(t *testing.T) {
+	const queries = 50
+	r := rand.New(rand.NewPCG(5, 6))
+	s := newBenchStore(t, r, QuantizationOff, 2000, 64)
+	found := 0
+	for range queries {
+		query := randomVector(r, 64)
+		exact := make(map[string]bool)
+		for _, res := range exactSearch(s, query, benchK) {
+			exact[res.ID] = true
+		}
+		results, err := s.Search(context.Background(), query, benchK, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, res := range results {
+			if exact[res.ID] {
+				found++
+			}
+		}
+	}
+	// The share of the exact top k the index finds
+	if recall := float64(found) / (queries * benchK); recall < 0.95 {
+		t.Errorf("recall = %.3f, want at least 0.95", recall)
+	}
+}
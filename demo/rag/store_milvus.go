@@ -0,0 +1,934 @@
+package rag
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	// milvusPageSize is how many entities are fetched per query when
+	// listing chunks. Milvus caps offset plus limit of a query, 16384 by
+	// default, which bounds the chunks a namespace can list.
+	milvusPageSize = 1000
+	// milvusMaxID bounds the length of the IDs stored in VarChar fields.
+	milvusMaxID = 1024
+	// milvusMaxText is the longest chunk text a VarChar field holds, in
+	// bytes.
+	milvusMaxText = 65535
+)
+
+// milvusNamePattern matches valid Milvus collection names.
+var milvusNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// MilvusIndex configures the vector index of a Milvus collection. Type is
+// a Milvus index type such as HNSW (the default), IVF_FLAT, IVF_SQ8, FLAT
+// or AUTOINDEX. Params are its build parameters and SearchParams are sent
+// with every search; HNSW and IVF indexes get defaults for the parameters
+// that are not set, see milvusIndexDefaults.
+type MilvusIndex struct {
+	Type         string
+	Params       map[string]any
+	SearchParams map[string]any
+}
+
+// MilvusStore is a VectorStore backed by a Milvus collection, accessed over
+// the RESTful API v2 of Milvus 2.4 and later. The collection is created on
+// first upsert, once the embedding dimension is known, with a vector index
+// configured by MilvusIndex. Chunk metadata is stored in a JSON field, and
+// numeric values are also stored in a second JSON field so that range
+// filters compare them as numbers; as with Qdrant, range conditions on
+// strings are not supported. If the chunks of the first upsert carry sparse
+// vectors, the collection gets a sparse vector field with an inverted index
+// as well, which makes the store a SparseStore; every chunk upserted into it
+// must then carry a sparse vector, while collections created without the
+// field have to be recreated to store them.
+//
+// Every namespace is a partition of the collection, DefaultNamespace being
+// Milvus's _default partition, so searches only scan the partition of
+// their namespace; Milvus allows 1024 partitions per collection by
+// default. Since partitions cannot exist before their collection, created
+// namespaces are also recorded in a second collection named after the first
+// with a "_namespaces" suffix, and the IndexModel, as JSON, in a third with
+// a "_model" suffix.
+type MilvusStore struct {
+	baseURL    string
+	token      string
+	collection string
+	metric     Metric
+	index      MilvusIndex
+
+	mu            sync.Mutex
+	ready         bool
+	sparse        bool // the collection has a sparse vector field
+	registryReady bool
+	modelReady    bool            // the collection of the IndexModel exists
+	partitions    map[string]bool // partitions known to exist
+	model         modelCache
+}
+
+// milvusFields are the scalar fields of an entity returned for a chunk.
+var milvusFields = []string{"chunk_id", "doc_id", "parent_id", "idx", "text", "metadata", "hash"}
+
+// milvusEntity is a chunk as stored in Milvus.
+type milvusEntity struct {
+	PK          string              `json:"pk,omitempty"`
+	ChunkID     string              `json:"chunk_id"`
+	DocID       string              `json:"doc_id"`
+	ParentID    string              `json:"parent_id"`
+	Index       int                 `json:"idx"`
+	Text        string              `json:"text"`
+	Metadata    json.RawMessage     `json:"metadata"`
+	MetadataNum map[string]float64  `json:"metadata_num,omitempty"`
+	Hash        string              `json:"hash"`
+	Embedding   []float32           `json:"embedding,omitempty"`
+	Sparse      *milvusSparseVector `json:"sparse,omitempty"`
+	Distance    float32             `json:"distance,omitempty"`
+}
+
+// NewMilvusStore creates a MilvusStore for collection on the server at
+// baseURL, e.g. http://localhost:19530. token is a Zilliz Cloud API key or
+// "user:password" for servers with authentication enabled.
+func NewMilvusStore(ctx context.Context, baseURL, token, collection string, metric Metric, index MilvusIndex) (*MilvusStore, error) {
+	if !milvusNamePattern.MatchString(collection) {
+		return nil, fmt.Errorf("milvus: invalid collection name %q", collection)
+	}
+	if index.Type == "" {
+		index.Type = "HNSW"
+	}
+	index.Type = strings.ToUpper(index.Type)
+	s := &MilvusStore{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		token:      token,
+		collection: collection,
+		metric:     metric,
+		index:      index,
+		partitions: make(map[string]bool),
+	}
+	var err error
+	if s.ready, err = s.has(ctx, s.collection); err != nil {
+		return nil, err
+	}
+	if s.registryReady, err = s.has(ctx, s.registry()); err != nil {
+		return nil, err
+	}
+	if s.modelReady, err = s.has(ctx, s.modelCollection()); err != nil {
+		return nil, err
+	}
+	if s.ready {
+		var info struct {
+			Fields []struct {
+				Name string `json:"name"`
+			} `json:"fields"`
+		}
+		if err := s.do(ctx, "/collections/describe", map[string]any{"collectionName": s.collection}, &info); err != nil {
+			return nil, err
+		}
+		for _, f := range info.Fields {
+			s.sparse = s.sparse || f.Name == "sparse"
+		}
+		// Collections must be loaded into memory to be searched
+		if err := s.do(ctx, "/collections/load", map[string]any{"collectionName": s.collection}, nil); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// registry is the name of the collection recording namespaces.
+func (s *MilvusStore) registry() string {
+	return s.collection + "_namespaces"
+}
+
+// modelCollection is the name of the collection recording the IndexModel.
+func (s *MilvusStore) modelCollection() string {
+	return s.collection + "_model"
+}
+
+// has reports whether the named collection exists.
+func (s *MilvusStore) has(ctx context.Context, collection string) (bool, error) {
+	var res struct {
+		Has bool `json:"has"`
+	}
+	err := s.do(ctx, "/collections/has", map[string]any{"collectionName": collection}, &res)
+	return res.Has, err
+}
+
+// milvusParams decodes the JSON object of parameters in the named
+// setting, if set.
+func milvusParams(name, value string, params *map[string]any) error {
+	if value == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(value), params); err != nil {
+		return fmt.Errorf("%s: want a JSON object: %w", name, err)
+	}
+	return nil
+}
+
+// milvusIndexDefaults returns the build and search parameters of an index
+// type that NewMilvusStore uses unless they are configured.
+func milvusIndexDefaults(indexType string) (params, search map[string]any) {
+	switch {
+	case indexType == "HNSW":
+		return map[string]any{"M": 16, "efConstruction": 200}, map[string]any{"ef": 64}
+	case strings.HasPrefix(indexType, "IVF_"):
+		return map[string]any{"nlist": 1024}, map[string]any{"nprobe": 16}
+	}
+	return map[string]any{}, map[string]any{}
+}
+
+func (s *MilvusStore) metricType() string {
+	if s.metric == MetricInnerProduct {
+		return "IP"
+	}
+	return "COSINE"
+}
+
+// ensure creates and loads the collection with vectors of size dim if
+// needed, with a sparse vector field if sparse is set, along with the
+// partitions of the namespaces created so far. It reports whether the
+// collection has a sparse vector field.
+func (s *MilvusStore) ensure(ctx context.Context, dim int, sparse bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ready {
+		return s.sparse, nil
+	}
+	varchar := func(name string, length int) map[string]any {
+		return map[string]any{"fieldName": name, "dataType": "VarChar", "elementTypeParams": map[string]any{"max_length": length}}
+	}
+	pk := varchar("pk", 2*milvusMaxID)
+	pk["isPrimary"] = true
+	fields := []map[string]any{
+		pk,
+		varchar("chunk_id", milvusMaxID),
+		varchar("doc_id", milvusMaxID),
+		varchar("parent_id", milvusMaxID),
+		{"fieldName": "idx", "dataType": "Int64"},
+		varchar("text", milvusMaxText),
+		{"fieldName": "metadata", "dataType": "JSON"},
+		{"fieldName": "metadata_num", "dataType": "JSON"},
+		varchar("hash", 64),
+		{"fieldName": "embedding", "dataType": "FloatVector", "elementTypeParams": map[string]any{"dim": dim}},
+	}
+	params, _ := milvusIndexDefaults(s.index.Type)
+	maps.Copy(params, s.index.Params)
+	indexes := []map[string]any{
+		{"fieldName": "embedding", "indexName": "embedding", "indexType": s.index.Type, "metricType": s.metricType(), "params": params},
+		{"fieldName": "doc_id", "indexName": "doc_id", "indexType": "INVERTED"},
+	}
+	if sparse {
+		fields = append(fields, map[string]any{"fieldName": "sparse", "dataType": "SparseFloatVector"})
+		indexes = append(indexes, map[string]any{"fieldName": "sparse", "indexName": "sparse", "indexType": "SPARSE_INVERTED_INDEX", "metricType": "IP"})
+	}
+	err := s.do(ctx, "/collections/create", map[string]any{
+		"collectionName": s.collection,
+		"schema":         map[string]any{"autoId": false, "enableDynamicField": false, "fields": fields},
+		"indexParams":    indexes,
+	}, nil)
+	if err != nil {
+		return false, fmt.Errorf("milvus: creating collection: %w", err)
+	}
+	s.ready, s.sparse = true, sparse
+	if !s.registryReady {
+		return sparse, nil
+	}
+	namespaces, err := s.registered(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, ns := range namespaces {
+		if err := s.createPartition(ctx, ns); err != nil {
+			return false, err
+		}
+	}
+	return sparse, nil
+}
+
+// milvusPartition returns the partition holding a namespace. Partition
+// names only allow letters, digits and underscores, so namespaces are
+// prefixed, with "_" written as "_u" and "-" as "__".
+func milvusPartition(ns string) string {
+	if ns == DefaultNamespace {
+		return "_default"
+	}
+	return "ns_" + strings.ReplaceAll(strings.ReplaceAll(ns, "_", "_u"), "-", "__")
+}
+
+// createPartition creates and loads the partition of ns; s.mu must be held.
+func (s *MilvusStore) createPartition(ctx context.Context, ns string) error {
+	name := milvusPartition(ns)
+	req := map[string]any{"collectionName": s.collection, "partitionName": name}
+	var res struct {
+		Has bool `json:"has"`
+	}
+	if err := s.do(ctx, "/partitions/has", req, &res); err != nil {
+		return err
+	}
+	if !res.Has {
+		if err := s.do(ctx, "/partitions/create", req, nil); err != nil {
+			return fmt.Errorf("milvus: creating partition of %s: %w", ns, err)
+		}
+	}
+	if err := s.do(ctx, "/partitions/load", map[string]any{"collectionName": s.collection, "partitionNames": []string{name}}, nil); err != nil {
+		return fmt.Errorf("milvus: loading partition of %s: %w", ns, err)
+	}
+	s.partitions[ns] = true
+	return nil
+}
+
+// ensurePartition creates the partition of ns unless it is known to exist.
+func (s *MilvusStore) ensurePartition(ctx context.Context, ns string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ns == DefaultNamespace || s.partitions[ns] {
+		return nil
+	}
+	return s.createPartition(ctx, ns)
+}
+
+func (s *MilvusStore) isReady() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ready
+}
+
+func (s *MilvusStore) Upsert(ctx context.Context, chunks []Chunk) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	ns := NamespaceFrom(ctx)
+	if err := s.namespaceExists(ctx, ns); err != nil {
+		return err
+	}
+	sparse, err := s.ensure(ctx, len(chunks[0].Embedding), chunks[0].Sparse != nil)
+	if err != nil {
+		return err
+	}
+	if err := s.ensurePartition(ctx, ns); err != nil {
+		return err
+	}
+	entities := make([]milvusEntity, len(chunks))
+	for i, c := range chunks {
+		metadata, err := json.Marshal(c.Metadata)
+		if err != nil {
+			return err
+		}
+		if len(c.Text) > milvusMaxText {
+			return fmt.Errorf("milvus: chunk %s is longer than %d bytes", c.ID, milvusMaxText)
+		}
+		nums := numericMetadata(c.Metadata)
+		if nums == nil {
+			nums = map[string]float64{}
+		}
+		entities[i] = milvusEntity{
+			PK: milvusPK(ns, c.ID), ChunkID: c.ID, DocID: c.DocID, ParentID: c.ParentID, Index: c.Index, Text: c.Text,
+			Metadata: metadata, MetadataNum: nums, Hash: c.Hash, Embedding: c.Embedding,
+		}
+		switch {
+		case sparse && c.Sparse == nil:
+			return fmt.Errorf("milvus: collection %s stores sparse vectors, but chunk %s has none; set SPARSE_EMBEDDER", s.collection, c.ID)
+		case !sparse && c.Sparse != nil:
+			return fmt.Errorf("milvus: collection %s was created without sparse vectors; drop it and ingest again to store them", s.collection)
+		case sparse:
+			entities[i].Sparse = newMilvusSparseVector(*c.Sparse)
+		}
+	}
+	return s.do(ctx, "/entities/upsert", map[string]any{
+		"collectionName": s.collection,
+		"partitionName":  milvusPartition(ns),
+		"data":           entities,
+	}, nil)
+}
+
+// milvusPK is the primary key of a chunk, which is unique across all
+// partitions of the collection.
+func milvusPK(ns, chunkID string) string {
+	return ns + "/" + chunkID
+}
+
+func (s *MilvusStore) Search(ctx context.Context, query []float32, k int, filter Filter) ([]SearchResult, error) {
+	ns := NamespaceFrom(ctx)
+	if err := s.namespaceExists(ctx, ns); err != nil {
+		return nil, err
+	}
+	if !s.isReady() {
+		return nil, nil
+	}
+	expr, err := milvusFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	_, search := milvusIndexDefaults(s.index.Type)
+	if ef, ok := search["ef"].(int); ok {
+		// HNSW returns at most ef results
+		search["ef"] = max(ef, k)
+	}
+	maps.Copy(search, s.index.SearchParams)
+	req := map[string]any{
+		"collectionName": s.collection,
+		"partitionNames": []string{milvusPartition(ns)},
+		"data":           [][]float32{query},
+		"annsField":      "embedding",
+		"limit":          k,
+		"outputFields":   milvusFields,
+		"searchParams":   map[string]any{"metricType": s.metricType(), "params": search},
+	}
+	if expr != "" {
+		req["filter"] = expr
+	}
+	var entities []milvusEntity
+	if err := s.do(ctx, "/entities/search", req, &entities); err != nil {
+		return nil, err
+	}
+	results := make([]SearchResult, len(entities))
+	for i, e := range entities {
+		chunk, err := e.chunk()
+		if err != nil {
+			return nil, err
+		}
+		// For COSINE and IP, Milvus reports similarities as distances
+		results[i] = SearchResult{Chunk: chunk, Score: e.Distance}
+	}
+	return results, nil
+}
+
+// SearchSparse searches the sparse vector field of the collection, scoring
+// chunks by inner product.
+func (s *MilvusStore) SearchSparse(ctx context.Context, query SparseVector, k int, filter Filter) ([]SearchResult, error) {
+	ns := NamespaceFrom(ctx)
+	if err := s.namespaceExists(ctx, ns); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	ready, sparse := s.ready, s.sparse
+	s.mu.Unlock()
+	if !ready {
+		return nil, nil
+	}
+	if !sparse {
+		return nil, fmt.Errorf("milvus: collection %s has no sparse vectors; drop it and ingest again with SPARSE_EMBEDDER set", s.collection)
+	}
+	expr, err := milvusFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	req := map[string]any{
+		"collectionName": s.collection,
+		"partitionNames": []string{milvusPartition(ns)},
+		"data":           []*milvusSparseVector{newMilvusSparseVector(query)},
+		"annsField":      "sparse",
+		"limit":          k,
+		"outputFields":   milvusFields,
+		"searchParams":   map[string]any{"metricType": "IP"},
+	}
+	if expr != "" {
+		req["filter"] = expr
+	}
+	var entities []milvusEntity
+	if err := s.do(ctx, "/entities/search", req, &entities); err != nil {
+		return nil, err
+	}
+	results := make([]SearchResult, len(entities))
+	for i, e := range entities {
+		chunk, err := e.chunk()
+		if err != nil {
+			return nil, err
+		}
+		results[i] = SearchResult{Chunk: chunk, Score: e.Distance}
+	}
+	return results, nil
+}
+
+// milvusSparseVector is a sparse vector as Milvus takes it, mapping indices
+// to values.
+type milvusSparseVector map[uint32]float32
+
+func newMilvusSparseVector(v SparseVector) *milvusSparseVector {
+	m := make(milvusSparseVector, len(v.Indices))
+	for i, index := range v.Indices {
+		m[index] = v.Values[i]
+	}
+	return &m
+}
+
+// vector returns the SparseVector of m, ordered by index.
+func (m milvusSparseVector) vector() *SparseVector {
+	v := &SparseVector{Indices: slices.Sorted(maps.Keys(m))}
+	v.Values = make([]float32, len(v.Indices))
+	for i, index := range v.Indices {
+		v.Values[i] = m[index]
+	}
+	return v
+}
+
+func (s *MilvusStore) Delete(ctx context.Context, docID string) error {
+	if !s.isReady() {
+		return nil
+	}
+	return s.delete(ctx, NamespaceFrom(ctx), "doc_id == "+milvusString(docID))
+}
+
+func (s *MilvusStore) DeleteChunks(ctx context.Context, ids []string) error {
+	ns := NamespaceFrom(ctx)
+	if len(ids) == 0 || !s.isReady() {
+		return nil
+	}
+	pks := make([]string, len(ids))
+	for i, id := range ids {
+		pks[i] = milvusString(milvusPK(ns, id))
+	}
+	return s.delete(ctx, ns, "pk in ["+strings.Join(pks, ", ")+"]")
+}
+
+// delete deletes the entities of the partition of ns matching expr. As in
+// the other stores, there is nothing to delete in unknown namespaces.
+func (s *MilvusStore) delete(ctx context.Context, ns, expr string) error {
+	if err := s.namespaceExists(ctx, ns); errors.Is(err, ErrNamespaceNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := s.ensurePartition(ctx, ns); err != nil {
+		return err
+	}
+	return s.do(ctx, "/entities/delete", map[string]any{
+		"collectionName": s.collection,
+		"partitionName":  milvusPartition(ns),
+		"filter":         expr,
+	}, nil)
+}
+
+func (s *MilvusStore) ChunkHashes(ctx context.Context, docID string) (map[string]string, error) {
+	ns := NamespaceFrom(ctx)
+	if err := s.namespaceExists(ctx, ns); err != nil {
+		return nil, err
+	}
+	hashes := make(map[string]string)
+	err := s.query(ctx, ns, "doc_id == "+milvusString(docID), []string{"chunk_id", "hash"}, func(e milvusEntity) error {
+		hashes[e.ChunkID] = e.Hash
+		return nil
+	})
+	return hashes, err
+}
+
+func (s *MilvusStore) Chunks(ctx context.Context, docID string) ([]Chunk, error) {
+	ns := NamespaceFrom(ctx)
+	if err := s.namespaceExists(ctx, ns); err != nil {
+		return nil, err
+	}
+	fields := append(slices.Clip(milvusFields), "embedding")
+	s.mu.Lock()
+	if s.sparse {
+		fields = append(fields, "sparse")
+	}
+	s.mu.Unlock()
+	var chunks []Chunk
+	err := s.query(ctx, ns, "doc_id == "+milvusString(docID), fields, func(e milvusEntity) error {
+		c, err := e.chunk()
+		c.Embedding = e.Embedding
+		if e.Sparse != nil {
+			c.Sparse = e.Sparse.vector()
+		}
+		chunks = append(chunks, c)
+		return err
+	})
+	slices.SortFunc(chunks, func(a, b Chunk) int {
+		return cmp.Or(cmp.Compare(a.Index, b.Index), cmp.Compare(a.ID, b.ID))
+	})
+	return chunks, err
+}
+
+func (s *MilvusStore) Documents(ctx context.Context) ([]DocumentInfo, error) {
+	ns := NamespaceFrom(ctx)
+	if err := s.namespaceExists(ctx, ns); err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	err := s.query(ctx, ns, `doc_id != ""`, []string{"doc_id"}, func(e milvusEntity) error {
+		counts[e.DocID]++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	docs := make([]DocumentInfo, 0, len(counts))
+	for id, n := range counts {
+		docs = append(docs, DocumentInfo{ID: id, Chunks: n})
+	}
+	slices.SortFunc(docs, func(a, b DocumentInfo) int { return cmp.Compare(a.ID, b.ID) })
+	return docs, nil
+}
+
+// query pages through the entities of the partition of ns matching expr,
+// calling fn with the requested fields of each.
+func (s *MilvusStore) query(ctx context.Context, ns, expr string, fields []string, fn func(milvusEntity) error) error {
+	if !s.isReady() {
+		return nil
+	}
+	if err := s.ensurePartition(ctx, ns); err != nil {
+		return err
+	}
+	for offset := 0; ; offset += milvusPageSize {
+		var page []milvusEntity
+		err := s.do(ctx, "/entities/query", map[string]any{
+			"collectionName": s.collection,
+			"partitionNames": []string{milvusPartition(ns)},
+			"filter":         expr,
+			"outputFields":   fields,
+			"limit":          milvusPageSize,
+			"offset":         offset,
+		}, &page)
+		if err != nil {
+			return err
+		}
+		for _, e := range page {
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		if len(page) < milvusPageSize {
+			return nil
+		}
+	}
+}
+
+func (s *MilvusStore) CreateNamespace(ctx context.Context, name string) error {
+	if err := checkNamespaceChange(name); err != nil {
+		return err
+	}
+	if err := s.ensureRegistry(ctx); err != nil {
+		return err
+	}
+	if err := s.namespaceExists(ctx, name); err == nil {
+		return fmt.Errorf("%w: %s", ErrNamespaceExists, name)
+	} else if !errors.Is(err, ErrNamespaceNotFound) {
+		return err
+	}
+	// The collection only records names, but Milvus requires a vector
+	err := s.do(ctx, "/entities/upsert", map[string]any{
+		"collectionName": s.registry(),
+		"data":           []map[string]any{{"name": name, "vector": []float32{1, 0}}},
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if s.isReady() {
+		return s.ensurePartition(ctx, name)
+	}
+	return nil
+}
+
+func (s *MilvusStore) DeleteNamespace(ctx context.Context, name string) error {
+	if err := checkNamespaceChange(name); err != nil {
+		return err
+	}
+	if err := s.namespaceExists(ctx, name); err != nil {
+		return err
+	}
+	if s.isReady() {
+		// Partitions are released from memory before they can be dropped
+		req := map[string]any{"collectionName": s.collection, "partitionName": milvusPartition(name)}
+		var res struct {
+			Has bool `json:"has"`
+		}
+		if err := s.do(ctx, "/partitions/has", req, &res); err != nil {
+			return err
+		}
+		if res.Has {
+			release := map[string]any{"collectionName": s.collection, "partitionNames": []string{milvusPartition(name)}}
+			if err := s.do(ctx, "/partitions/release", release, nil); err != nil {
+				return err
+			}
+			if err := s.do(ctx, "/partitions/drop", req, nil); err != nil {
+				return err
+			}
+		}
+		s.mu.Lock()
+		delete(s.partitions, name)
+		s.mu.Unlock()
+	}
+	return s.do(ctx, "/entities/delete", map[string]any{
+		"collectionName": s.registry(),
+		"filter":         "name == " + milvusString(name),
+	}, nil)
+}
+
+func (s *MilvusStore) Namespaces(ctx context.Context) ([]NamespaceInfo, error) {
+	names := []string{DefaultNamespace}
+	if s.isRegistryReady() {
+		registered, err := s.registered(ctx)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, registered...)
+	}
+	slices.Sort(names)
+	namespaces := make([]NamespaceInfo, len(names))
+	for i, name := range names {
+		namespaces[i].Name = name
+		if !s.isReady() {
+			continue
+		}
+		if err := s.ensurePartition(ctx, name); err != nil {
+			return nil, err
+		}
+		var res []map[string]int
+		err := s.do(ctx, "/entities/query", map[string]any{
+			"collectionName": s.collection,
+			"partitionNames": []string{milvusPartition(name)},
+			"outputFields":   []string{"count(*)"},
+		}, &res)
+		if err != nil {
+			return nil, err
+		}
+		if len(res) > 0 {
+			namespaces[i].Chunks = res[0]["count(*)"]
+		}
+	}
+	return namespaces, nil
+}
+
+// registered returns the names recorded in the registry collection.
+func (s *MilvusStore) registered(ctx context.Context) ([]string, error) {
+	var rows []struct {
+		Name string `json:"name"`
+	}
+	err := s.do(ctx, "/entities/query", map[string]any{
+		"collectionName": s.registry(),
+		"filter":         `name != ""`,
+		"outputFields":   []string{"name"},
+		"limit":          milvusPageSize,
+	}, &rows)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(rows))
+	for i, r := range rows {
+		names[i] = r.Name
+	}
+	return names, nil
+}
+
+// ensureRegistry creates the collection recording namespaces if needed.
+func (s *MilvusStore) ensureRegistry(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.registryReady {
+		return nil
+	}
+	err := s.do(ctx, "/collections/create", map[string]any{
+		"collectionName": s.registry(),
+		"schema": map[string]any{"autoId": false, "fields": []map[string]any{
+			{"fieldName": "name", "dataType": "VarChar", "isPrimary": true, "elementTypeParams": map[string]any{"max_length": 64}},
+			{"fieldName": "vector", "dataType": "FloatVector", "elementTypeParams": map[string]any{"dim": 2}},
+		}},
+		"indexParams": []map[string]any{{"fieldName": "vector", "indexName": "vector", "indexType": "FLAT", "metricType": "IP"}},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("milvus: creating namespace collection: %w", err)
+	}
+	s.registryReady = true
+	return nil
+}
+
+func (s *MilvusStore) IndexModel(ctx context.Context) (*IndexModel, error) {
+	return s.model.get(func() (*IndexModel, error) {
+		s.mu.Lock()
+		ready := s.modelReady
+		s.mu.Unlock()
+		if !ready {
+			return nil, nil
+		}
+		var rows []struct {
+			Model string `json:"model"`
+		}
+		err := s.do(ctx, "/entities/get", map[string]any{
+			"collectionName": s.modelCollection(),
+			"id":             []string{"index_model"},
+			"outputFields":   []string{"model"},
+		}, &rows)
+		if err != nil || len(rows) == 0 {
+			return nil, err
+		}
+		var m IndexModel
+		if err := json.Unmarshal([]byte(rows[0].Model), &m); err != nil {
+			return nil, fmt.Errorf("milvus: decoding the index model: %w", err)
+		}
+		return &m, nil
+	})
+}
+
+func (s *MilvusStore) SetIndexModel(ctx context.Context, m IndexModel) error {
+	if err := s.ensureModelCollection(ctx); err != nil {
+		return err
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	err = s.do(ctx, "/entities/upsert", map[string]any{
+		"collectionName": s.modelCollection(),
+		"data":           []map[string]any{{"id": "index_model", "model": string(data), "vector": []float32{1, 0}}},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("milvus: recording the index model: %w", err)
+	}
+	s.model.set(m)
+	return nil
+}
+
+// ensureModelCollection creates the collection recording the IndexModel if
+// needed.
+func (s *MilvusStore) ensureModelCollection(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.modelReady {
+		return nil
+	}
+	err := s.do(ctx, "/collections/create", map[string]any{
+		"collectionName": s.modelCollection(),
+		"schema": map[string]any{"autoId": false, "fields": []map[string]any{
+			{"fieldName": "id", "dataType": "VarChar", "isPrimary": true, "elementTypeParams": map[string]any{"max_length": 64}},
+			{"fieldName": "model", "dataType": "VarChar", "elementTypeParams": map[string]any{"max_length": milvusMaxID}},
+			{"fieldName": "vector", "dataType": "FloatVector", "elementTypeParams": map[string]any{"dim": 2}},
+		}},
+		"indexParams": []map[string]any{{"fieldName": "vector", "indexName": "vector", "indexType": "FLAT", "metricType": "IP"}},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("milvus: creating model collection: %w", err)
+	}
+	s.modelReady = true
+	return nil
+}
+
+func (s *MilvusStore) isRegistryReady() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.registryReady
+}
+
+// namespaceExists returns ErrNamespaceNotFound unless ns is DefaultNamespace
+// or was created.
+func (s *MilvusStore) namespaceExists(ctx context.Context, ns string) error {
+	if ns == DefaultNamespace {
+		return nil
+	}
+	if s.isRegistryReady() {
+		var rows []json.RawMessage
+		err := s.do(ctx, "/entities/get", map[string]any{
+			"collectionName": s.registry(),
+			"id":             []string{ns},
+			"outputFields":   []string{"name"},
+		}, &rows)
+		if err != nil {
+			return err
+		}
+		if len(rows) > 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrNamespaceNotFound, ns)
+}
+
+func (e milvusEntity) chunk() (Chunk, error) {
+	c := Chunk{ID: e.ChunkID, DocID: e.DocID, ParentID: e.ParentID, Index: e.Index, Text: e.Text, Hash: e.Hash}
+	metadata := []byte(e.Metadata)
+	// Depending on the version, JSON fields come back as objects or as
+	// strings holding them
+	var text string
+	if json.Unmarshal(metadata, &text) == nil {
+		metadata = []byte(text)
+	}
+	if len(metadata) > 0 && string(metadata) != "null" {
+		if err := json.Unmarshal(metadata, &c.Metadata); err != nil {
+			return c, fmt.Errorf("milvus: metadata of chunk %s: %w", e.ChunkID, err)
+		}
+	}
+	return c, nil
+}
+
+// milvusFilter translates filter into a Milvus boolean expression. String
+// comparisons apply to metadata["key"], numeric ones to metadata_num["key"].
+// Comparisons with keys a chunk lacks are false, so != also requires the
+// key, as in Filter.Match.
+func milvusFilter(filter Filter) (string, error) {
+	var conds []string
+	for _, c := range filter {
+		op := string(c.Op)
+		if op == "=" {
+			op = "=="
+		}
+		if !c.numeric() {
+			if c.Op != OpEq && c.Op != OpNe {
+				return "", fmt.Errorf("milvus: filter %s: range comparisons of non-numeric values are %w", c.Key+string(c.Op)+c.Value, ErrNotSupported)
+			}
+			conds = append(conds, fmt.Sprintf("metadata[%s] %s %s", milvusString(c.Key), op, milvusString(c.Value)))
+			continue
+		}
+		v, _ := parseNumber(c.Value)
+		conds = append(conds, fmt.Sprintf("metadata_num[%s] %s %v", milvusString(c.Key), op, v))
+	}
+	return strings.Join(conds, " and "), nil
+}
+
+// milvusString quotes s as a string literal of a Milvus expression.
+func milvusString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// CheckHealth describes the collection.
+func (s *MilvusStore) CheckHealth(ctx context.Context) error {
+	return s.do(ctx, "/collections/describe", map[string]any{"collectionName": s.collection}, nil)
+}
+
+// do sends a request to the Milvus RESTful API v2 and decodes the "data"
+// field of the response into out, if out is not nil. Milvus reports errors
+// with a non-zero code in an otherwise successful response.
+func (s *MilvusStore) do(ctx context.Context, path string, body, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.baseURL+"/v2/vectordb"+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var res struct {
+		Code    int             `json:"code"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return fmt.Errorf("milvus: %s: %s", path, resp.Status)
+	}
+	if resp.StatusCode >= 300 || res.Code != 0 {
+		return fmt.Errorf("milvus: %s: code %d: %s", path, res.Code, res.Message)
+	}
+	if out == nil || len(res.Data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(res.Data, out); err != nil {
+		return fmt.Errorf("milvus: decoding %s response: %w", path, err)
+	}
+	return nil
+}
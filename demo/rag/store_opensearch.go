@@ -0,0 +1,747 @@
+package rag
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	// openSearchPageSize is how many hits or aggregation buckets are
+	// fetched per request when listing chunks and documents.
+	openSearchPageSize = 1000
+	// openSearchBulkSize is how many chunks are indexed per bulk request.
+	openSearchBulkSize = 200
+)
+
+// openSearchCollectionPattern matches collection names that are valid in
+// index names.
+var openSearchCollectionPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_\-]*$`)
+
+// OpenSearchStore is a VectorStore backed by OpenSearch, or by
+// Elasticsearch 8.11 or later, accessed over their REST APIs; which of the
+// two the server is, is asked when the store is created. Every namespace
+// is an index of its own, named after the collection and the namespace,
+// e.g. rag-default, whose mapping records both in _meta, that of
+// DefaultNamespace also recording the IndexModel. Embeddings are
+// indexed for approximate kNN search in an HNSW graph, a knn_vector field
+// of the Lucene engine in OpenSearch and a dense_vector field in
+// Elasticsearch, which is mapped when the first chunks are stored in the
+// index and so takes their dimensions. Chunk texts are indexed for BM25.
+//
+// Filters run inside the server. Every metadata key is also stored in a
+// field of its own: meta_<key>, a keyword field holding the value, and,
+// for numeric values, metanum_<key>, a double field. Keys are encoded as
+// for Weaviate, see weaviateProperty. Unlike in the other remote stores,
+// range conditions on strings are supported, comparing them as strings.
+//
+// The store's HybridSearch sends a kNN and a BM25 search, both filtered,
+// in one multi-search request and fuses the two rankings as
+// HybridRetriever does, which the hybrid retriever uses instead of the
+// in-process keyword index.
+type OpenSearchStore struct {
+	baseURL    string
+	auth       string // Authorization header, if any
+	collection string
+	metric     Metric
+	elastic    bool // the server is Elasticsearch
+
+	mu     sync.Mutex
+	mapped map[string]bool // indices whose embedding field is mapped
+	model  modelCache
+}
+
+// NewOpenSearchStore creates an OpenSearchStore for collection on the
+// server at baseURL, e.g. http://localhost:9200, creating the index of
+// DefaultNamespace if it does not exist. token is an Elasticsearch API
+// key, or user:password for basic authentication; an empty token sends no
+// credentials.
+func NewOpenSearchStore(ctx context.Context, baseURL, token, collection string, metric Metric) (*OpenSearchStore, error) {
+	collection = strings.ToLower(collection)
+	if !openSearchCollectionPattern.MatchString(collection) {
+		return nil, fmt.Errorf("opensearch: invalid collection name %q", collection)
+	}
+	s := &OpenSearchStore{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		collection: collection,
+		metric:     metric,
+		mapped:     make(map[string]bool),
+	}
+	switch user, password, ok := strings.Cut(token, ":"); {
+	case ok:
+		s.auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+	case token != "":
+		s.auth = "ApiKey " + token
+	}
+	var info struct {
+		Version struct {
+			Distribution string `json:"distribution"`
+		} `json:"version"`
+	}
+	if _, err := s.do(ctx, http.MethodGet, "/", nil, &info); err != nil {
+		return nil, err
+	}
+	s.elastic = info.Version.Distribution != "opensearch"
+	found, err := s.do(ctx, http.MethodHead, "/"+s.index(DefaultNamespace), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		if err := s.createIndex(ctx, DefaultNamespace); err != nil && !errors.Is(err, ErrNamespaceExists) {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// index returns the name of the index of namespace ns.
+func (s *OpenSearchStore) index(ns string) string {
+	return s.collection + "-" + ns
+}
+
+// createIndex creates the index of namespace ns, without its embedding
+// field. It returns ErrNamespaceExists if the index exists.
+func (s *OpenSearchStore) createIndex(ctx context.Context, ns string) error {
+	body := map[string]any{
+		"mappings": map[string]any{
+			"_meta": map[string]any{"collection": s.collection, "namespace": ns},
+			"dynamic_templates": []any{
+				map[string]any{"meta": map[string]any{"match": "meta_*", "mapping": map[string]any{"type": "keyword"}}},
+				map[string]any{"metanum": map[string]any{"match": "metanum_*", "mapping": map[string]any{"type": "double"}}},
+			},
+			"properties": map[string]any{
+				"chunk_id": map[string]any{"type": "keyword"},
+				"doc_id":   map[string]any{"type": "keyword"},
+				"idx":      map[string]any{"type": "integer"},
+				"text":     map[string]any{"type": "text"},
+				"hash":     map[string]any{"type": "keyword", "index": false},
+				"metadata": map[string]any{"type": "object", "enabled": false},
+			},
+		},
+	}
+	if !s.elastic {
+		body["settings"] = map[string]any{"index.knn": true}
+	}
+	_, err := s.do(ctx, http.MethodPut, "/"+s.index(ns), body, nil)
+	if err != nil && strings.Contains(err.Error(), "already exists") {
+		return fmt.Errorf("%w: %s", ErrNamespaceExists, ns)
+	}
+	if err != nil {
+		return fmt.Errorf("opensearch: creating index %s: %w", s.index(ns), err)
+	}
+	return nil
+}
+
+func (s *OpenSearchStore) IndexModel(ctx context.Context) (*IndexModel, error) {
+	return s.model.get(func() (*IndexModel, error) {
+		index := s.index(DefaultNamespace)
+		var mapping map[string]struct {
+			Mappings struct {
+				Meta struct {
+					IndexModel *IndexModel `json:"index_model"`
+				} `json:"_meta"`
+			} `json:"mappings"`
+		}
+		if _, err := s.do(ctx, http.MethodGet, "/"+index+"/_mapping", nil, &mapping); err != nil {
+			return nil, err
+		}
+		return mapping[index].Mappings.Meta.IndexModel, nil
+	})
+}
+
+// SetIndexModel records m in the _meta of the index of DefaultNamespace,
+// which is replaced as a whole.
+func (s *OpenSearchStore) SetIndexModel(ctx context.Context, m IndexModel) error {
+	index := s.index(DefaultNamespace)
+	body := map[string]any{"_meta": map[string]any{"collection": s.collection, "namespace": DefaultNamespace, "index_model": m}}
+	if _, err := s.do(ctx, http.MethodPut, "/"+index+"/_mapping", body, nil); err != nil {
+		return fmt.Errorf("opensearch: recording the index model in %s: %w", index, err)
+	}
+	s.model.set(m)
+	return nil
+}
+
+// hasEmbedding reports whether the embedding field of index is mapped.
+func (s *OpenSearchStore) hasEmbedding(ctx context.Context, index string) (bool, error) {
+	s.mu.Lock()
+	mapped := s.mapped[index]
+	s.mu.Unlock()
+	if mapped {
+		return true, nil
+	}
+	var mapping map[string]struct {
+		Mappings struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"mappings"`
+	}
+	if _, err := s.do(ctx, http.MethodGet, "/"+index+"/_mapping", nil, &mapping); err != nil {
+		return false, err
+	}
+	_, mapped = mapping[index].Mappings.Properties["embedding"]
+	if mapped {
+		s.mu.Lock()
+		s.mapped[index] = true
+		s.mu.Unlock()
+	}
+	return mapped, nil
+}
+
+// mapEmbedding maps the embedding field of index for vectors with the
+// given dimensions, unless it is mapped already.
+func (s *OpenSearchStore) mapEmbedding(ctx context.Context, index string, dims int) error {
+	if mapped, err := s.hasEmbedding(ctx, index); err != nil || mapped {
+		return err
+	}
+	var field map[string]any
+	if s.elastic {
+		similarity := "cosine"
+		if s.metric == MetricInnerProduct {
+			similarity = "max_inner_product"
+		}
+		field = map[string]any{"type": "dense_vector", "dims": dims, "index": true, "similarity": similarity}
+	} else {
+		space := "cosinesimil"
+		if s.metric == MetricInnerProduct {
+			space = "innerproduct"
+		}
+		field = map[string]any{
+			"type":      "knn_vector",
+			"dimension": dims,
+			"method":    map[string]any{"name": "hnsw", "engine": "lucene", "space_type": space},
+		}
+	}
+	body := map[string]any{"properties": map[string]any{"embedding": field}}
+	if _, err := s.do(ctx, http.MethodPut, "/"+index+"/_mapping", body, nil); err != nil {
+		return fmt.Errorf("opensearch: mapping embeddings of %s: %w", index, err)
+	}
+	s.mu.Lock()
+	s.mapped[index] = true
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *OpenSearchStore) Upsert(ctx context.Context, chunks []Chunk) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	ns := NamespaceFrom(ctx)
+	if err := s.namespaceExists(ctx, ns); err != nil {
+		return err
+	}
+	index := s.index(ns)
+	if err := s.mapEmbedding(ctx, index, len(chunks[0].Embedding)); err != nil {
+		return err
+	}
+	for start := 0; start < len(chunks); start += openSearchBulkSize {
+		batch := chunks[start:min(start+openSearchBulkSize, len(chunks))]
+		var body bytes.Buffer
+		enc := json.NewEncoder(&body)
+		for _, c := range batch {
+			doc := map[string]any{
+				"chunk_id":  c.ID,
+				"doc_id":    c.DocID,
+				"idx":       c.Index,
+				"text":      c.Text,
+				"hash":      c.Hash,
+				"metadata":  c.Metadata,
+				"embedding": c.Embedding,
+			}
+			for k, v := range c.Metadata {
+				doc[weaviateProperty("meta_", k)] = v
+				if f, ok := parseNumber(v); ok {
+					doc[weaviateProperty("metanum_", k)] = f
+				}
+			}
+			enc.Encode(map[string]any{"index": map[string]any{"_index": index, "_id": c.ID}})
+			if err := enc.Encode(doc); err != nil {
+				return err
+			}
+		}
+		if err := s.bulk(ctx, &body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bulk sends a bulk request, waiting for its changes to be searchable, and
+// returns the first error of its items.
+func (s *OpenSearchStore) bulk(ctx context.Context, body *bytes.Buffer) error {
+	var res struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			ID     string          `json:"_id"`
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+	if _, err := s.do(ctx, http.MethodPost, "/_bulk?refresh=wait_for", body, &res); err != nil {
+		return err
+	}
+	if !res.Errors {
+		return nil
+	}
+	for _, item := range res.Items {
+		for action, r := range item {
+			// Deleting a chunk that is already gone is not an error
+			if len(r.Error) == 0 || action == "delete" && r.Status == http.StatusNotFound {
+				continue
+			}
+			return fmt.Errorf("opensearch: %s of chunk %s: %s", action, r.ID, openSearchReason(r.Error))
+		}
+	}
+	return nil
+}
+
+func (s *OpenSearchStore) Search(ctx context.Context, query []float32, k int, filter Filter) ([]SearchResult, error) {
+	index, ok, err := s.searchIndex(ctx)
+	if err != nil || !ok {
+		return nil, err
+	}
+	hits, err := s.msearch(ctx, index, s.knnQuery(query, k, filter))
+	if err != nil {
+		return nil, err
+	}
+	return s.results(hits[0], true), nil
+}
+
+// HybridSearch runs a kNN search for vector and a BM25 search of the chunk
+// texts for query in one multi-search request, each for 4*k candidates
+// matching filter, and fuses their rankings with weighted reciprocal rank
+// fusion. weight is the share of the vector search, from 0 (keywords
+// only) to 1 (vectors only). The scores are those of the fusion, not
+// similarities.
+func (s *OpenSearchStore) HybridSearch(ctx context.Context, query string, vector []float32, k int, weight float64, filter Filter) ([]SearchResult, error) {
+	index, ok, err := s.searchIndex(ctx)
+	if err != nil || !ok {
+		return nil, err
+	}
+	candidates := 4 * k
+	keywords := map[string]any{
+		"size":    candidates,
+		"_source": map[string]any{"excludes": []string{"embedding"}},
+		"query": map[string]any{"bool": map[string]any{
+			"must":   []any{map[string]any{"match": map[string]any{"text": query}}},
+			"filter": s.filterClauses(filter),
+		}},
+	}
+	hits, err := s.msearch(ctx, index, s.knnQuery(vector, candidates, filter), keywords)
+	if err != nil {
+		return nil, err
+	}
+	return fuseRankings(k, []float64{weight, 1 - weight}, s.results(hits[0], true), s.results(hits[1], false)), nil
+}
+
+// searchIndex returns the index of the namespace of ctx, reporting false if
+// nothing was stored in it yet, so that there is nothing to search.
+func (s *OpenSearchStore) searchIndex(ctx context.Context) (string, bool, error) {
+	ns := NamespaceFrom(ctx)
+	if err := s.namespaceExists(ctx, ns); err != nil {
+		return "", false, err
+	}
+	index := s.index(ns)
+	mapped, err := s.hasEmbedding(ctx, index)
+	return index, mapped, err
+}
+
+// knnQuery returns the search body of a kNN search for the k chunks
+// nearest to vector that match filter.
+func (s *OpenSearchStore) knnQuery(vector []float32, k int, filter Filter) map[string]any {
+	body := map[string]any{"size": k, "_source": map[string]any{"excludes": []string{"embedding"}}}
+	knn := map[string]any{"k": k}
+	if clauses := s.filterClauses(filter); len(clauses) > 0 {
+		knn["filter"] = map[string]any{"bool": map[string]any{"filter": clauses}}
+	}
+	if s.elastic {
+		knn["field"] = "embedding"
+		knn["query_vector"] = vector
+		knn["num_candidates"] = min(max(10*k, 100), 10000)
+		body["knn"] = knn
+	} else {
+		knn["vector"] = vector
+		body["query"] = map[string]any{"knn": map[string]any{"embedding": knn}}
+	}
+	return body
+}
+
+// filterClauses translates filter into the clauses of a bool filter.
+func (s *OpenSearchStore) filterClauses(filter Filter) []any {
+	clauses := []any{}
+	for _, c := range filter {
+		field := weaviateProperty("meta_", c.Key)
+		var value any = c.Value
+		if f, ok := parseNumber(c.Value); ok {
+			field = weaviateProperty("metanum_", c.Key)
+			value = f
+		}
+		switch c.Op {
+		case OpEq:
+			clauses = append(clauses, map[string]any{"term": map[string]any{field: value}})
+		case OpNe:
+			// Chunks without the key never match, as in Filter.Match
+			clauses = append(clauses, map[string]any{"bool": map[string]any{
+				"must":     []any{map[string]any{"exists": map[string]any{"field": field}}},
+				"must_not": []any{map[string]any{"term": map[string]any{field: value}}},
+			}})
+		default:
+			op := map[Op]string{OpLt: "lt", OpLe: "lte", OpGt: "gt", OpGe: "gte"}[c.Op]
+			clauses = append(clauses, map[string]any{"range": map[string]any{field: map[string]any{op: value}}})
+		}
+	}
+	return clauses
+}
+
+// openSearchHit is a hit of a search.
+type openSearchHit struct {
+	Score  float32 `json:"_score"`
+	Source struct {
+		ChunkID  string   `json:"chunk_id"`
+		DocID    string   `json:"doc_id"`
+		Index    int      `json:"idx"`
+		Text     string   `json:"text"`
+		Hash     string   `json:"hash"`
+		Metadata Metadata `json:"metadata"`
+	} `json:"_source"`
+	Sort []any `json:"sort"`
+}
+
+// results converts hits to search results. The scores of kNN hits are
+// converted back to similarities.
+func (s *OpenSearchStore) results(hits []openSearchHit, knn bool) []SearchResult {
+	results := make([]SearchResult, len(hits))
+	for i, h := range hits {
+		score := h.Score
+		if knn {
+			score = s.similarity(score)
+		}
+		results[i] = SearchResult{Chunk: Chunk{
+			ID:       h.Source.ChunkID,
+			DocID:    h.Source.DocID,
+			Index:    h.Source.Index,
+			Text:     h.Source.Text,
+			Hash:     h.Source.Hash,
+			Metadata: h.Source.Metadata,
+		}, Score: score}
+	}
+	return results
+}
+
+// similarity converts the score of a kNN hit to the similarity it was
+// derived from: both servers score cosine similarity as (1+cos)/2 and an
+// inner product as ip+1, or 1/(1-ip) when it is negative.
+func (s *OpenSearchStore) similarity(score float32) float32 {
+	if s.metric == MetricInnerProduct {
+		if score >= 1 {
+			return score - 1
+		}
+		return 1 - 1/float32(math.Max(float64(score), 1e-9))
+	}
+	return 2*score - 1
+}
+
+// msearch runs the given searches of index in one multi-search request and
+// returns the hits of each.
+func (s *OpenSearchStore) msearch(ctx context.Context, index string, searches ...map[string]any) ([][]openSearchHit, error) {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, search := range searches {
+		enc.Encode(map[string]any{"index": index})
+		if err := enc.Encode(search); err != nil {
+			return nil, err
+		}
+	}
+	var res struct {
+		Responses []struct {
+			Hits struct {
+				Hits []openSearchHit `json:"hits"`
+			} `json:"hits"`
+			Error json.RawMessage `json:"error"`
+		} `json:"responses"`
+	}
+	if _, err := s.do(ctx, http.MethodPost, "/_msearch", &body, &res); err != nil {
+		return nil, err
+	}
+	if len(res.Responses) != len(searches) {
+		return nil, fmt.Errorf("opensearch: %d responses to %d searches", len(res.Responses), len(searches))
+	}
+	hits := make([][]openSearchHit, len(searches))
+	for i, r := range res.Responses {
+		if len(r.Error) > 0 {
+			return nil, fmt.Errorf("opensearch: searching %s: %s", index, openSearchReason(r.Error))
+		}
+		hits[i] = r.Hits.Hits
+	}
+	return hits, nil
+}
+
+func (s *OpenSearchStore) Delete(ctx context.Context, docID string) error {
+	ns := NamespaceFrom(ctx)
+	if err := s.namespaceExists(ctx, ns); errors.Is(err, ErrNamespaceNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	_, err := s.do(ctx, http.MethodPost, "/"+s.index(ns)+"/_delete_by_query?refresh=true", map[string]any{
+		"query": map[string]any{"term": map[string]any{"doc_id": docID}},
+	}, nil)
+	return err
+}
+
+func (s *OpenSearchStore) ChunkHashes(ctx context.Context, docID string) (map[string]string, error) {
+	ns := NamespaceFrom(ctx)
+	if err := s.namespaceExists(ctx, ns); err != nil {
+		return nil, err
+	}
+	hashes := make(map[string]string)
+	var after []any
+	for {
+		body := map[string]any{
+			"size":    openSearchPageSize,
+			"_source": []string{"chunk_id", "hash"},
+			"query":   map[string]any{"term": map[string]any{"doc_id": docID}},
+			"sort":    []any{map[string]any{"chunk_id": "asc"}},
+		}
+		if after != nil {
+			body["search_after"] = after
+		}
+		var res struct {
+			Hits struct {
+				Hits []openSearchHit `json:"hits"`
+			} `json:"hits"`
+		}
+		if _, err := s.do(ctx, http.MethodPost, "/"+s.index(ns)+"/_search", body, &res); err != nil {
+			return nil, err
+		}
+		for _, h := range res.Hits.Hits {
+			hashes[h.Source.ChunkID] = h.Source.Hash
+		}
+		if len(res.Hits.Hits) < openSearchPageSize {
+			return hashes, nil
+		}
+		after = res.Hits.Hits[len(res.Hits.Hits)-1].Sort
+	}
+}
+
+func (s *OpenSearchStore) DeleteChunks(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	ns := NamespaceFrom(ctx)
+	if err := s.namespaceExists(ctx, ns); errors.Is(err, ErrNamespaceNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, id := range ids {
+		enc.Encode(map[string]any{"delete": map[string]any{"_index": s.index(ns), "_id": id}})
+	}
+	return s.bulk(ctx, &body)
+}
+
+func (s *OpenSearchStore) Documents(ctx context.Context) ([]DocumentInfo, error) {
+	ns := NamespaceFrom(ctx)
+	if err := s.namespaceExists(ctx, ns); err != nil {
+		return nil, err
+	}
+	var docs []DocumentInfo
+	var after map[string]any
+	for {
+		composite := map[string]any{
+			"size":    openSearchPageSize,
+			"sources": []any{map[string]any{"id": map[string]any{"terms": map[string]any{"field": "doc_id"}}}},
+		}
+		if after != nil {
+			composite["after"] = after
+		}
+		var res struct {
+			Aggregations struct {
+				Docs struct {
+					AfterKey map[string]any `json:"after_key"`
+					Buckets  []struct {
+						Key struct {
+							ID string `json:"id"`
+						} `json:"key"`
+						DocCount int `json:"doc_count"`
+					} `json:"buckets"`
+				} `json:"docs"`
+			} `json:"aggregations"`
+		}
+		body := map[string]any{"size": 0, "aggs": map[string]any{"docs": map[string]any{"composite": composite}}}
+		if _, err := s.do(ctx, http.MethodPost, "/"+s.index(ns)+"/_search", body, &res); err != nil {
+			return nil, err
+		}
+		for _, b := range res.Aggregations.Docs.Buckets {
+			docs = append(docs, DocumentInfo{ID: b.Key.ID, Chunks: b.DocCount})
+		}
+		if len(res.Aggregations.Docs.Buckets) < openSearchPageSize || res.Aggregations.Docs.AfterKey == nil {
+			break
+		}
+		after = res.Aggregations.Docs.AfterKey
+	}
+	slices.SortFunc(docs, func(a, b DocumentInfo) int { return cmp.Compare(a.ID, b.ID) })
+	return docs, nil
+}
+
+func (s *OpenSearchStore) CreateNamespace(ctx context.Context, name string) error {
+	if err := checkNamespaceChange(name); err != nil {
+		return err
+	}
+	return s.createIndex(ctx, name)
+}
+
+func (s *OpenSearchStore) DeleteNamespace(ctx context.Context, name string) error {
+	if err := checkNamespaceChange(name); err != nil {
+		return err
+	}
+	if err := s.namespaceExists(ctx, name); err != nil {
+		return err
+	}
+	if _, err := s.do(ctx, http.MethodDelete, "/"+s.index(name), nil, nil); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	delete(s.mapped, s.index(name))
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *OpenSearchStore) Namespaces(ctx context.Context) ([]NamespaceInfo, error) {
+	// The indices of other collections may match the pattern too, so the
+	// collection is checked in the mappings' _meta
+	var mappings map[string]struct {
+		Mappings struct {
+			Meta struct {
+				Collection string `json:"collection"`
+				Namespace  string `json:"namespace"`
+			} `json:"_meta"`
+		} `json:"mappings"`
+	}
+	if _, err := s.do(ctx, http.MethodGet, "/"+s.collection+"-*/_mapping", nil, &mappings); err != nil {
+		return nil, fmt.Errorf("opensearch: listing indices: %w", err)
+	}
+	var namespaces []NamespaceInfo
+	for index, m := range mappings {
+		meta := m.Mappings.Meta
+		if meta.Collection != s.collection || s.index(meta.Namespace) != index {
+			continue
+		}
+		var count struct {
+			Count int `json:"count"`
+		}
+		if _, err := s.do(ctx, http.MethodGet, "/"+index+"/_count", nil, &count); err != nil {
+			return nil, err
+		}
+		namespaces = append(namespaces, NamespaceInfo{Name: meta.Namespace, Chunks: count.Count})
+	}
+	slices.SortFunc(namespaces, func(a, b NamespaceInfo) int { return cmp.Compare(a.Name, b.Name) })
+	return namespaces, nil
+}
+
+// namespaceExists returns ErrNamespaceNotFound unless the index of ns
+// exists. The index of DefaultNamespace is created with the store.
+func (s *OpenSearchStore) namespaceExists(ctx context.Context, ns string) error {
+	if ns == DefaultNamespace {
+		return nil
+	}
+	found, err := s.do(ctx, http.MethodHead, "/"+s.index(ns), nil, nil)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: %s", ErrNamespaceNotFound, ns)
+	}
+	return nil
+}
+
+// openSearchReason returns the reason of an error object of the REST API,
+// or the object itself if it has none.
+func openSearchReason(raw json.RawMessage) string {
+	var e struct {
+		Type      string `json:"type"`
+		Reason    string `json:"reason"`
+		RootCause []struct {
+			Reason string `json:"reason"`
+		} `json:"root_cause"`
+	}
+	if json.Unmarshal(raw, &e) != nil || e.Reason == "" {
+		return string(raw)
+	}
+	// The reason of a failed search is often only "all shards failed"
+	if len(e.RootCause) > 0 && e.RootCause[0].Reason != "" && e.RootCause[0].Reason != e.Reason {
+		return e.Reason + ": " + e.RootCause[0].Reason
+	}
+	return e.Reason
+}
+
+// CheckHealth reads the cluster's info.
+func (s *OpenSearchStore) CheckHealth(ctx context.Context) error {
+	_, err := s.do(ctx, http.MethodGet, "/", nil, nil)
+	return err
+}
+
+// do sends a request to the REST API and decodes the response into out, if
+// out is not nil. A *bytes.Buffer body is sent as newline-delimited JSON,
+// any other body as JSON. It reports false, without an error, if a GET or
+// HEAD request found nothing.
+func (s *OpenSearchStore) do(ctx context.Context, method, path string, body, out any) (bool, error) {
+	var reader io.Reader = http.NoBody
+	contentType := "application/json"
+	switch body := body.(type) {
+	case nil:
+	case *bytes.Buffer:
+		reader = body
+		contentType = "application/x-ndjson"
+	default:
+		data, err := json.Marshal(body)
+		if err != nil {
+			return false, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.baseURL+path, reader)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if s.auth != "" {
+		req.Header.Set("Authorization", s.auth)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("opensearch: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && (method == http.MethodGet || method == http.MethodHead) {
+		return false, nil
+	}
+	if resp.StatusCode >= 300 {
+		var res struct {
+			Error json.RawMessage `json:"error"`
+		}
+		data, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(data, &res) == nil && len(res.Error) > 0 {
+			return false, fmt.Errorf("opensearch: %s %s: %s: %s", method, path, resp.Status, openSearchReason(res.Error))
+		}
+		return false, fmt.Errorf("opensearch: %s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	if out == nil || method == http.MethodHead {
+		return true, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil && err != io.EOF {
+		return true, fmt.Errorf("opensearch: decoding %s response: %w", path, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,632 @@
+package rag
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// pgMigrations are applied in order and recorded in rag_migrations, so each
+// statement runs exactly once per database.
+var pgMigrations = []string{
+	`CREATE EXTENSION IF NOT EXISTS vector`,
+	`CREATE TABLE rag_chunks (
+		id        text PRIMARY KEY,
+		doc_id    text NOT NULL,
+		idx       integer NOT NULL,
+		text      text NOT NULL,
+		metadata  jsonb NOT NULL DEFAULT '{}',
+		embedding vector NOT NULL
+	)`,
+	`CREATE INDEX rag_chunks_doc_id ON rag_chunks (doc_id)`,
+	`CREATE INDEX rag_chunks_metadata ON rag_chunks USING gin (metadata jsonb_path_ops)`,
+	`ALTER TABLE rag_chunks ADD COLUMN hash text NOT NULL DEFAULT ''`,
+	`CREATE TABLE rag_namespaces (name text PRIMARY KEY)`,
+	`ALTER TABLE rag_chunks ADD COLUMN namespace text NOT NULL DEFAULT 'default'`,
+	`ALTER TABLE rag_chunks DROP CONSTRAINT rag_chunks_pkey, ADD PRIMARY KEY (namespace, id)`,
+	`DROP INDEX rag_chunks_doc_id`,
+	`CREATE INDEX rag_chunks_doc_id ON rag_chunks (namespace, doc_id)`,
+	`CREATE TABLE rag_documents (
+		namespace text NOT NULL,
+		id        text NOT NULL,
+		document  jsonb NOT NULL,
+		PRIMARY KEY (namespace, id)
+	)`,
+	`ALTER TABLE rag_chunks ADD COLUMN parent_id text NOT NULL DEFAULT ''`,
+	`CREATE TABLE rag_parents (
+		namespace text NOT NULL,
+		id        text NOT NULL,
+		doc_id    text NOT NULL,
+		idx       integer NOT NULL,
+		text      text NOT NULL,
+		metadata  jsonb NOT NULL DEFAULT '{}',
+		PRIMARY KEY (namespace, id)
+	)`,
+	`CREATE INDEX rag_parents_doc_id ON rag_parents (namespace, doc_id)`,
+	`CREATE TABLE rag_index_model (
+		id         integer PRIMARY KEY CHECK (id = 1),
+		model      text NOT NULL,
+		dimensions integer NOT NULL,
+		normalized boolean NOT NULL
+	)`,
+	`CREATE TABLE rag_namespace_profiles (
+		name    text PRIMARY KEY,
+		profile jsonb NOT NULL
+	)`,
+}
+
+// PGVectorStore is a VectorStore backed by Postgres with the pgvector
+// extension. Filters are translated into SQL conditions on the metadata
+// column.
+type PGVectorStore struct {
+	pool   *pgxpool.Pool
+	metric Metric
+	model  modelCache
+	active string // the schema SwapIndex makes the index active in
+}
+
+// NewPGVectorStore connects to the database at dsn and migrates its schema.
+func NewPGVectorStore(ctx context.Context, dsn string, metric Metric) (*PGVectorStore, error) {
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("pgvector: connecting: %w", err)
+	}
+	s := &PGVectorStore{pool: pool, metric: metric}
+	if err := s.migrate(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// pgReindexSchema is the schema NewPGVectorReindexStore builds an index in.
+const pgReindexSchema = "rag_reindex"
+
+// NewPGVectorReindexStore returns a PGVectorStore of a new, empty index of
+// the database at dsn, kept in a schema of its own besides the active
+// index until SwapIndex replaces that with it. An index a previous call
+// left behind is dropped.
+func NewPGVectorReindexStore(ctx context.Context, dsn string, metric Metric) (*PGVectorStore, error) {
+	config, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("pgvector: %w", err)
+	}
+	conn, err := pgx.ConnectConfig(ctx, config.ConnConfig.Copy())
+	if err != nil {
+		return nil, fmt.Errorf("pgvector: connecting: %w", err)
+	}
+	defer conn.Close(ctx)
+	var active, path string
+	if err := conn.QueryRow(ctx, `SELECT current_schema(), current_setting('search_path')`).Scan(&active, &path); err != nil {
+		return nil, fmt.Errorf("pgvector: %w", err)
+	}
+	schema := pgx.Identifier{pgReindexSchema}.Sanitize()
+	for _, stmt := range []string{`DROP SCHEMA IF EXISTS ` + schema + ` CASCADE`, `CREATE SCHEMA ` + schema} {
+		if _, err := conn.Exec(ctx, stmt); err != nil {
+			return nil, fmt.Errorf("pgvector: %w", err)
+		}
+	}
+	// The schemas searched before stay searched, for the vector type
+	config.ConnConfig.RuntimeParams["search_path"] = schema + ", " + path
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("pgvector: connecting: %w", err)
+	}
+	s := &PGVectorStore{pool: pool, metric: metric, active: active}
+	if err := s.migrate(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// SwapIndex replaces the active index of the database with that of s, a
+// store of NewPGVectorReindexStore, and drops the old index. The tables
+// are swapped in one transaction, so other processes using the database
+// switch from one index to the other at once.
+func (s *PGVectorStore) SwapIndex(ctx context.Context) error {
+	if s.active == "" {
+		return errors.New("pgvector: only a store of NewPGVectorReindexStore can be swapped in")
+	}
+	staging, active := pgx.Identifier{pgReindexSchema}.Sanitize(), pgx.Identifier{s.active}.Sanitize()
+	err := pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `SELECT tablename FROM pg_tables WHERE schemaname = $1`, pgReindexSchema)
+		if err != nil {
+			return err
+		}
+		tables, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			return err
+		}
+		for _, table := range tables {
+			table := pgx.Identifier{table}.Sanitize()
+			if _, err := tx.Exec(ctx, `DROP TABLE IF EXISTS `+active+`.`+table+` CASCADE`); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, `ALTER TABLE `+staging+`.`+table+` SET SCHEMA `+active); err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec(ctx, `DROP SCHEMA `+staging)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("pgvector: swapping the index: %w", err)
+	}
+	return nil
+}
+
+// Close closes the connection pool.
+func (s *PGVectorStore) Close() error {
+	s.pool.Close()
+	return nil
+}
+
+// CheckHealth pings the database.
+func (s *PGVectorStore) CheckHealth(ctx context.Context) error {
+	return s.pool.Ping(ctx)
+}
+
+func (s *PGVectorStore) migrate(ctx context.Context) error {
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		// Serialize concurrent migrations from several processes
+		if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(4242)`); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `CREATE TABLE IF NOT EXISTS rag_migrations (version integer PRIMARY KEY)`); err != nil {
+			return fmt.Errorf("pgvector: creating migrations table: %w", err)
+		}
+		var applied int
+		if err := tx.QueryRow(ctx, `SELECT count(*) FROM rag_migrations`).Scan(&applied); err != nil {
+			return err
+		}
+		for version := applied; version < len(pgMigrations); version++ {
+			if _, err := tx.Exec(ctx, pgMigrations[version]); err != nil {
+				return fmt.Errorf("pgvector: migration %d: %w", version+1, err)
+			}
+			if _, err := tx.Exec(ctx, `INSERT INTO rag_migrations (version) VALUES ($1)`, version+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *PGVectorStore) Upsert(ctx context.Context, chunks []Chunk) error {
+	batch := &pgx.Batch{}
+	if err := pgQueueUpsert(batch, NamespaceFrom(ctx), chunks); err != nil {
+		return err
+	}
+	return s.sendBatch(ctx, batch)
+}
+
+// sendBatch sends batch in a transaction holding the namespace of ctx.
+func (s *PGVectorStore) sendBatch(ctx context.Context, batch *pgx.Batch) error {
+	ns := NamespaceFrom(ctx)
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		// Lock the namespace so that it cannot be deleted concurrently
+		if err := pgNamespaceExists(ctx, tx, ns, " FOR SHARE"); err != nil {
+			return err
+		}
+		return tx.SendBatch(ctx, batch).Close()
+	})
+}
+
+func pgQueueUpsert(batch *pgx.Batch, ns string, chunks []Chunk) error {
+	for _, c := range chunks {
+		metadata, err := json.Marshal(c.Metadata)
+		if err != nil {
+			return err
+		}
+		batch.Queue(`INSERT INTO rag_chunks (namespace, id, doc_id, parent_id, idx, text, metadata, hash, embedding)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9::vector)
+			ON CONFLICT (namespace, id) DO UPDATE SET doc_id = excluded.doc_id, parent_id = excluded.parent_id,
+				idx = excluded.idx, text = excluded.text, metadata = excluded.metadata, hash = excluded.hash,
+				embedding = excluded.embedding`,
+			ns, c.ID, c.DocID, c.ParentID, c.Index, c.Text, metadata, c.Hash, pgVector(c.Embedding))
+	}
+	return nil
+}
+
+func (s *PGVectorStore) Search(ctx context.Context, query []float32, k int, filter Filter) ([]SearchResult, error) {
+	// <=> is cosine distance and <#> is negative inner product, so ascending
+	// order is best first for both
+	operator, score := "<=>", "1 - (embedding <=> $1::vector)"
+	if s.metric == MetricInnerProduct {
+		operator, score = "<#>", "-(embedding <#> $1::vector)"
+	}
+	ns := NamespaceFrom(ctx)
+	if err := pgNamespaceExists(ctx, s.pool, ns, ""); err != nil {
+		return nil, err
+	}
+	args := []any{pgVector(query), k, ns}
+	where := "namespace = $3 AND " + pgFilter(filter, &args)
+	rows, err := s.pool.Query(ctx, `SELECT id, doc_id, parent_id, idx, text, metadata, hash, `+score+`
+		FROM rag_chunks WHERE `+where+`
+		ORDER BY embedding `+operator+` $1::vector LIMIT $2`, args...)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (SearchResult, error) {
+		var r SearchResult
+		err := row.Scan(&r.ID, &r.DocID, &r.ParentID, &r.Index, &r.Text, &r.Metadata, &r.Hash, &r.Score)
+		return r, err
+	})
+}
+
+func (s *PGVectorStore) Delete(ctx context.Context, docID string) error {
+	ns := NamespaceFrom(ctx)
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `DELETE FROM rag_chunks WHERE namespace = $1 AND doc_id = $2`, ns, docID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM rag_documents WHERE namespace = $1 AND id = $2`, ns, docID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `DELETE FROM rag_parents WHERE namespace = $1 AND doc_id = $2`, ns, docID)
+		return err
+	})
+}
+
+func (s *PGVectorStore) ReplaceParents(ctx context.Context, docID string, parents []Chunk) error {
+	batch := &pgx.Batch{}
+	if err := pgQueueParents(batch, NamespaceFrom(ctx), docID, parents); err != nil {
+		return err
+	}
+	return s.sendBatch(ctx, batch)
+}
+
+func pgQueueParents(batch *pgx.Batch, ns, docID string, parents []Chunk) error {
+	batch.Queue(`DELETE FROM rag_parents WHERE namespace = $1 AND doc_id = $2`, ns, docID)
+	for _, c := range parents {
+		metadata, err := json.Marshal(c.Metadata)
+		if err != nil {
+			return err
+		}
+		batch.Queue(`INSERT INTO rag_parents (namespace, id, doc_id, idx, text, metadata) VALUES ($1, $2, $3, $4, $5, $6)`,
+			ns, c.ID, docID, c.Index, c.Text, metadata)
+	}
+	return nil
+}
+
+func (s *PGVectorStore) Parents(ctx context.Context, ids []string) (map[string]Chunk, error) {
+	ns := NamespaceFrom(ctx)
+	if err := pgNamespaceExists(ctx, s.pool, ns, ""); err != nil {
+		return nil, err
+	}
+	rows, err := s.pool.Query(ctx, `SELECT id, doc_id, idx, text, metadata FROM rag_parents
+		WHERE namespace = $1 AND id = ANY($2)`, ns, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	parents := make(map[string]Chunk, len(ids))
+	for rows.Next() {
+		var c Chunk
+		if err := rows.Scan(&c.ID, &c.DocID, &c.Index, &c.Text, &c.Metadata); err != nil {
+			return nil, err
+		}
+		parents[c.ID] = c
+	}
+	return parents, rows.Err()
+}
+
+func (s *PGVectorStore) PutSource(ctx context.Context, doc *Document) error {
+	batch := &pgx.Batch{}
+	if err := pgQueueSource(batch, NamespaceFrom(ctx), doc); err != nil {
+		return err
+	}
+	return s.sendBatch(ctx, batch)
+}
+
+func pgQueueSource(batch *pgx.Batch, ns string, doc *Document) error {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	batch.Queue(`INSERT INTO rag_documents (namespace, id, document) VALUES ($1, $2, $3)
+		ON CONFLICT (namespace, id) DO UPDATE SET document = excluded.document`, ns, doc.ID, data)
+	return nil
+}
+
+func (s *PGVectorStore) Source(ctx context.Context, docID string) (*Document, error) {
+	var doc Document
+	err := s.pool.QueryRow(ctx, `SELECT document FROM rag_documents WHERE namespace = $1 AND id = $2`,
+		NamespaceFrom(ctx), docID).Scan(&doc)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+func (s *PGVectorStore) IndexModel(ctx context.Context) (*IndexModel, error) {
+	return s.model.get(func() (*IndexModel, error) {
+		var m IndexModel
+		err := s.pool.QueryRow(ctx, `SELECT model, dimensions, normalized FROM rag_index_model`).Scan(&m.Model, &m.Dimensions, &m.Normalized)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &m, nil
+	})
+}
+
+func (s *PGVectorStore) SetIndexModel(ctx context.Context, m IndexModel) error {
+	_, err := s.pool.Exec(ctx, `INSERT INTO rag_index_model (id, model, dimensions, normalized) VALUES (1, $1, $2, $3)
+		ON CONFLICT (id) DO UPDATE SET model = excluded.model, dimensions = excluded.dimensions, normalized = excluded.normalized`,
+		m.Model, m.Dimensions, m.Normalized)
+	if err == nil {
+		s.model.set(m)
+	}
+	return err
+}
+
+func (s *PGVectorStore) NamespaceProfile(ctx context.Context, name string) (*NamespaceProfile, error) {
+	if err := pgNamespaceExists(ctx, s.pool, name, ""); err != nil {
+		return nil, err
+	}
+	var profile NamespaceProfile
+	err := s.pool.QueryRow(ctx, `SELECT profile FROM rag_namespace_profiles WHERE name = $1`, name).Scan(&profile)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+func (s *PGVectorStore) SetNamespaceProfile(ctx context.Context, name string, profile *NamespaceProfile) error {
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		// Lock the namespace so that it cannot be deleted concurrently
+		if err := pgNamespaceExists(ctx, tx, name, " FOR SHARE"); err != nil {
+			return err
+		}
+		if profile == nil {
+			_, err := tx.Exec(ctx, `DELETE FROM rag_namespace_profiles WHERE name = $1`, name)
+			return err
+		}
+		data, err := json.Marshal(profile)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `INSERT INTO rag_namespace_profiles (name, profile) VALUES ($1, $2)
+			ON CONFLICT (name) DO UPDATE SET profile = excluded.profile`, name, data)
+		return err
+	})
+}
+
+func (s *PGVectorStore) ChunkHashes(ctx context.Context, docID string) (map[string]string, error) {
+	ns := NamespaceFrom(ctx)
+	if err := pgNamespaceExists(ctx, s.pool, ns, ""); err != nil {
+		return nil, err
+	}
+	rows, err := s.pool.Query(ctx, `SELECT id, hash FROM rag_chunks WHERE namespace = $1 AND doc_id = $2`, ns, docID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hashes := make(map[string]string)
+	for rows.Next() {
+		var id, hash string
+		if err := rows.Scan(&id, &hash); err != nil {
+			return nil, err
+		}
+		hashes[id] = hash
+	}
+	return hashes, rows.Err()
+}
+
+func (s *PGVectorStore) Chunks(ctx context.Context, docID string) ([]Chunk, error) {
+	ns := NamespaceFrom(ctx)
+	if err := pgNamespaceExists(ctx, s.pool, ns, ""); err != nil {
+		return nil, err
+	}
+	rows, err := s.pool.Query(ctx, `SELECT id, doc_id, parent_id, idx, text, metadata, hash, embedding::text
+		FROM rag_chunks WHERE namespace = $1 AND doc_id = $2 ORDER BY idx, id`, ns, docID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Chunk, error) {
+		var (
+			c         Chunk
+			embedding string
+		)
+		if err := row.Scan(&c.ID, &c.DocID, &c.ParentID, &c.Index, &c.Text, &c.Metadata, &c.Hash, &embedding); err != nil {
+			return c, err
+		}
+		// pgvector's text representation is a JSON array
+		if err := json.Unmarshal([]byte(embedding), &c.Embedding); err != nil {
+			return c, fmt.Errorf("pgvector: embedding of chunk %s: %w", c.ID, err)
+		}
+		return c, nil
+	})
+}
+
+func (s *PGVectorStore) ChunkMetadata(ctx context.Context, key string) ([]Chunk, error) {
+	ns := NamespaceFrom(ctx)
+	if err := pgNamespaceExists(ctx, s.pool, ns, ""); err != nil {
+		return nil, err
+	}
+	rows, err := s.pool.Query(ctx, `SELECT id, doc_id, metadata FROM rag_chunks
+		WHERE namespace = $1 AND metadata ? $2`, ns, key)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Chunk, error) {
+		var c Chunk
+		err := row.Scan(&c.ID, &c.DocID, &c.Metadata)
+		return c, err
+	})
+}
+
+func (s *PGVectorStore) DeleteChunks(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := s.pool.Exec(ctx, `DELETE FROM rag_chunks WHERE namespace = $1 AND id = ANY($2)`, NamespaceFrom(ctx), ids)
+	return err
+}
+
+func (s *PGVectorStore) ReplaceDocument(ctx context.Context, w DocumentWrite) error {
+	ns := NamespaceFrom(ctx)
+	batch := &pgx.Batch{}
+	if len(w.Stale) > 0 {
+		batch.Queue(`DELETE FROM rag_chunks WHERE namespace = $1 AND id = ANY($2)`, ns, w.Stale)
+	}
+	if err := pgQueueUpsert(batch, ns, w.Changed); err != nil {
+		return err
+	}
+	if err := pgQueueSource(batch, ns, w.Document); err != nil {
+		return err
+	}
+	if err := pgQueueParents(batch, ns, w.Document.ID, w.Parents); err != nil {
+		return err
+	}
+	return s.sendBatch(ctx, batch)
+}
+
+func (s *PGVectorStore) Documents(ctx context.Context) ([]DocumentInfo, error) {
+	ns := NamespaceFrom(ctx)
+	if err := pgNamespaceExists(ctx, s.pool, ns, ""); err != nil {
+		return nil, err
+	}
+	rows, err := s.pool.Query(ctx, `SELECT doc_id, count(*) FROM rag_chunks
+		WHERE namespace = $1 GROUP BY doc_id ORDER BY doc_id`, ns)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (DocumentInfo, error) {
+		var d DocumentInfo
+		err := row.Scan(&d.ID, &d.Chunks)
+		return d, err
+	})
+}
+
+func (s *PGVectorStore) CreateNamespace(ctx context.Context, name string) error {
+	if err := checkNamespaceChange(name); err != nil {
+		return err
+	}
+	tag, err := s.pool.Exec(ctx, `INSERT INTO rag_namespaces (name) VALUES ($1) ON CONFLICT DO NOTHING`, name)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", ErrNamespaceExists, name)
+	}
+	return nil
+}
+
+func (s *PGVectorStore) DeleteNamespace(ctx context.Context, name string) error {
+	if err := checkNamespaceChange(name); err != nil {
+		return err
+	}
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `DELETE FROM rag_namespaces WHERE name = $1`, name)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return fmt.Errorf("%w: %s", ErrNamespaceNotFound, name)
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM rag_chunks WHERE namespace = $1`, name); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM rag_documents WHERE namespace = $1`, name); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM rag_parents WHERE namespace = $1`, name); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `DELETE FROM rag_namespace_profiles WHERE name = $1`, name)
+		return err
+	})
+}
+
+func (s *PGVectorStore) Namespaces(ctx context.Context) ([]NamespaceInfo, error) {
+	rows, err := s.pool.Query(ctx, `SELECT n.name, count(c.id) FROM
+		(SELECT $1::text AS name UNION SELECT name FROM rag_namespaces) n
+		LEFT JOIN rag_chunks c ON c.namespace = n.name
+		GROUP BY n.name ORDER BY n.name`, DefaultNamespace)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (NamespaceInfo, error) {
+		var n NamespaceInfo
+		err := row.Scan(&n.Name, &n.Chunks)
+		return n, err
+	})
+}
+
+// pgNamespaceExists returns ErrNamespaceNotFound unless ns is
+// DefaultNamespace or was created. lock is appended to the query, e.g. to
+// lock the namespace's row.
+func pgNamespaceExists(ctx context.Context, q interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}, ns, lock string) error {
+	if ns == DefaultNamespace {
+		return nil
+	}
+	var name string
+	err := q.QueryRow(ctx, `SELECT name FROM rag_namespaces WHERE name = $1`+lock, ns).Scan(&name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("%w: %s", ErrNamespaceNotFound, ns)
+	}
+	return err
+}
+
+// pgFilter translates filter into a WHERE clause, appending its parameters
+// to args. Equality on strings uses the GIN-indexed containment operator;
+// numeric comparisons only consider metadata values that look like numbers.
+func pgFilter(filter Filter, args *[]any) string {
+	param := func(v any) string {
+		*args = append(*args, v)
+		return fmt.Sprintf("$%d", len(*args))
+	}
+	conds := []string{"true"}
+	for _, c := range filter {
+		op := string(c.Op)
+		if c.Op == OpNe {
+			op = "<>"
+		}
+		if !c.numeric() {
+			if c.Op == OpEq {
+				metadata, _ := json.Marshal(Metadata{c.Key: c.Value})
+				conds = append(conds, "metadata @> "+param(metadata)+"::jsonb")
+				continue
+			}
+			conds = append(conds, fmt.Sprintf("(metadata->>%s) %s %s COLLATE \"C\"", param(c.Key), op, param(c.Value)))
+			continue
+		}
+		value, _ := parseNumber(c.Value)
+		key := param(c.Key)
+		conds = append(conds, fmt.Sprintf("CASE WHEN (metadata->>%[1]s) ~ '%[2]s' THEN (metadata->>%[1]s)::float8 %[3]s %[4]s ELSE false END",
+			key, numberPattern.String(), op, param(value)))
+	}
+	return strings.Join(conds, " AND ")
+}
+
+// pgVector formats v in pgvector's text representation, e.g. [1,2,3].
+func pgVector(v []float32) string {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, x := range v {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.FormatFloat(float64(x), 'g', -1, 32))
+	}
+	b.WriteByte(']')
+	return b.String()
+}
@@ -0,0 +1,698 @@
+package rag
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// qdrantScrollLimit is the page size used when scrolling through points.
+const qdrantScrollLimit = 256
+
+// QdrantStore is a VectorStore backed by a Qdrant collection, accessed over
+// Qdrant's REST API. The collection is created on first upsert, once the
+// embedding dimension is known. Chunk fields are stored in the point
+// payload and filters become payload match and range conditions. Numeric
+// metadata values are also stored under metadata_num so that range
+// conditions can use them. If the chunks of the first upsert carry sparse
+// vectors, the collection is created with a sparse vector named "sparse"
+// besides the dense one, which makes the store a SparseStore; collections
+// created without it cannot store sparse vectors and have to be recreated.
+//
+// All namespaces share the collection, with the namespace stored in the
+// payload as Qdrant recommends for multitenancy; points stored before
+// namespaces existed belong to DefaultNamespace. Created namespaces are
+// recorded in a second collection named after the first with a
+// "_namespaces" suffix, which also records the IndexModel in a point of
+// its own.
+type QdrantStore struct {
+	baseURL    string
+	apiKey     string
+	collection string
+	metric     Metric
+
+	mu            sync.Mutex
+	ready         bool
+	sparse        bool // the collection has sparse vectors
+	registryReady bool
+	model         modelCache
+}
+
+// qdrantModelPoint is the key of the ID of the point recording the
+// IndexModel, which no namespace name can have.
+const qdrantModelPoint = "/index_model"
+
+// qdrantPayload is the payload stored with every point.
+type qdrantPayload struct {
+	ChunkID   string   `json:"chunk_id"`
+	Namespace string   `json:"namespace,omitempty"`
+	DocID     string   `json:"doc_id"`
+	Index     int      `json:"index"`
+	Text      string   `json:"text"`
+	Metadata  Metadata `json:"metadata,omitempty"`
+	Hash      string   `json:"hash,omitempty"`
+
+	MetadataNum map[string]float64 `json:"metadata_num,omitempty"`
+}
+
+// NewQdrantStore creates a QdrantStore for collection on the server at
+// baseURL, e.g. http://localhost:6333.
+func NewQdrantStore(ctx context.Context, baseURL, apiKey, collection string, metric Metric) (*QdrantStore, error) {
+	s := &QdrantStore{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		apiKey:     apiKey,
+		collection: collection,
+		metric:     metric,
+	}
+	var err error
+	if s.ready, err = s.exists(ctx, s.path("")); err != nil {
+		return nil, fmt.Errorf("qdrant: %w", err)
+	}
+	if s.ready {
+		var info struct {
+			Config struct {
+				Params struct {
+					SparseVectors map[string]any `json:"sparse_vectors"`
+				} `json:"params"`
+			} `json:"config"`
+		}
+		if err := s.do(ctx, http.MethodGet, s.path(""), nil, &info); err != nil {
+			return nil, fmt.Errorf("qdrant: %w", err)
+		}
+		_, s.sparse = info.Config.Params.SparseVectors[qdrantSparseVector]
+	}
+	if s.registryReady, err = s.exists(ctx, s.registryPath("")); err != nil {
+		return nil, fmt.Errorf("qdrant: %w", err)
+	}
+	return s, nil
+}
+
+func (s *QdrantStore) path(suffix string) string {
+	return "/collections/" + url.PathEscape(s.collection) + suffix
+}
+
+// registryPath is like path for the collection recording the namespaces.
+func (s *QdrantStore) registryPath(suffix string) string {
+	return "/collections/" + url.PathEscape(s.collection+"_namespaces") + suffix
+}
+
+// exists reports whether the collection at path exists.
+func (s *QdrantStore) exists(ctx context.Context, path string) (bool, error) {
+	var res struct {
+		Exists bool `json:"exists"`
+	}
+	err := s.do(ctx, http.MethodGet, path+"/exists", nil, &res)
+	return res.Exists, err
+}
+
+// ensure creates the collection with vectors of size dim if needed, and
+// with sparse vectors if sparse is set. It reports whether the collection
+// has sparse vectors.
+func (s *QdrantStore) ensure(ctx context.Context, dim int, sparse bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ready {
+		return s.sparse, nil
+	}
+	distance := "Cosine"
+	if s.metric == MetricInnerProduct {
+		distance = "Dot"
+	}
+	params := map[string]any{"vectors": map[string]any{"size": dim, "distance": distance}}
+	if sparse {
+		params["sparse_vectors"] = map[string]any{qdrantSparseVector: map[string]any{}}
+	}
+	err := s.do(ctx, http.MethodPut, s.path(""), params, nil)
+	if err != nil {
+		return false, fmt.Errorf("qdrant: creating collection: %w", err)
+	}
+	err = s.do(ctx, http.MethodPut, s.path("/index?wait=true"), map[string]any{
+		"field_name":   "doc_id",
+		"field_schema": "keyword",
+	}, nil)
+	if err != nil {
+		return false, fmt.Errorf("qdrant: creating doc_id index: %w", err)
+	}
+	err = s.do(ctx, http.MethodPut, s.path("/index?wait=true"), map[string]any{
+		"field_name":   "namespace",
+		"field_schema": map[string]any{"type": "keyword", "is_tenant": true},
+	}, nil)
+	if err != nil {
+		return false, fmt.Errorf("qdrant: creating namespace index: %w", err)
+	}
+	s.ready, s.sparse = true, sparse
+	return sparse, nil
+}
+
+func (s *QdrantStore) isReady() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ready
+}
+
+func (s *QdrantStore) Upsert(ctx context.Context, chunks []Chunk) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	ns := NamespaceFrom(ctx)
+	if err := s.namespaceExists(ctx, ns); err != nil {
+		return err
+	}
+	sparse, err := s.ensure(ctx, len(chunks[0].Embedding), chunks[0].Sparse != nil)
+	if err != nil {
+		return err
+	}
+	points := make([]map[string]any, len(chunks))
+	for i, c := range chunks {
+		var vector any = c.Embedding
+		if c.Sparse != nil {
+			if !sparse {
+				return fmt.Errorf("qdrant: collection %s was created without sparse vectors; delete it and ingest again to store them", s.collection)
+			}
+			// The dense vector is the collection's unnamed vector
+			vector = map[string]any{"": c.Embedding, qdrantSparseVector: c.Sparse}
+		}
+		points[i] = map[string]any{
+			"id":     qdrantPointID(ns, c.ID),
+			"vector": vector,
+			"payload": qdrantPayload{
+				ChunkID: c.ID, Namespace: ns, DocID: c.DocID, Index: c.Index, Text: c.Text, Metadata: c.Metadata, Hash: c.Hash,
+				MetadataNum: numericMetadata(c.Metadata),
+			},
+		}
+	}
+	return s.do(ctx, http.MethodPut, s.path("/points?wait=true"), map[string]any{"points": points}, nil)
+}
+
+func (s *QdrantStore) Search(ctx context.Context, query []float32, k int, filter Filter) ([]SearchResult, error) {
+	ns := NamespaceFrom(ctx)
+	if err := s.namespaceExists(ctx, ns); err != nil {
+		return nil, err
+	}
+	if !s.isReady() {
+		return nil, nil
+	}
+	f, err := qdrantNamespaceFilter(ns, filter)
+	if err != nil {
+		return nil, err
+	}
+	req := map[string]any{"vector": query, "limit": k, "with_payload": true, "filter": f}
+	var points []qdrantScoredPoint
+	if err := s.do(ctx, http.MethodPost, s.path("/points/search"), req, &points); err != nil {
+		return nil, err
+	}
+	return qdrantResults(points), nil
+}
+
+// SearchSparse searches the sparse vectors of the collection with Qdrant's
+// query API, scoring chunks by dot product.
+func (s *QdrantStore) SearchSparse(ctx context.Context, query SparseVector, k int, filter Filter) ([]SearchResult, error) {
+	ns := NamespaceFrom(ctx)
+	if err := s.namespaceExists(ctx, ns); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	ready, sparse := s.ready, s.sparse
+	s.mu.Unlock()
+	if !ready {
+		return nil, nil
+	}
+	if !sparse {
+		return nil, fmt.Errorf("qdrant: collection %s has no sparse vectors; delete it and ingest again with SPARSE_EMBEDDER set", s.collection)
+	}
+	f, err := qdrantNamespaceFilter(ns, filter)
+	if err != nil {
+		return nil, err
+	}
+	req := map[string]any{"query": query, "using": qdrantSparseVector, "limit": k, "with_payload": true, "filter": f}
+	var res struct {
+		Points []qdrantScoredPoint `json:"points"`
+	}
+	if err := s.do(ctx, http.MethodPost, s.path("/points/query"), req, &res); err != nil {
+		return nil, err
+	}
+	return qdrantResults(res.Points), nil
+}
+
+// qdrantSparseVector names the sparse vector of the collection.
+const qdrantSparseVector = "sparse"
+
+// qdrantScoredPoint is a point found by a search.
+type qdrantScoredPoint struct {
+	Score   float32       `json:"score"`
+	Payload qdrantPayload `json:"payload"`
+}
+
+func qdrantResults(points []qdrantScoredPoint) []SearchResult {
+	results := make([]SearchResult, len(points))
+	for i, p := range points {
+		results[i] = SearchResult{Chunk: p.Payload.chunk(), Score: p.Score}
+	}
+	return results
+}
+
+// qdrantNamespaceFilter converts filter and restricts it to namespace ns.
+func qdrantNamespaceFilter(ns string, filter Filter) (map[string]any, error) {
+	f, err := qdrantFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	must, _ := f["must"].([]any)
+	f["must"] = append(must, qdrantNamespace(ns))
+	return f, nil
+}
+
+func (s *QdrantStore) Delete(ctx context.Context, docID string) error {
+	if !s.isReady() {
+		return nil
+	}
+	return s.do(ctx, http.MethodPost, s.path("/points/delete?wait=true"), map[string]any{
+		"filter": map[string]any{"must": []any{qdrantNamespace(NamespaceFrom(ctx)), qdrantMatch("doc_id", docID)}},
+	}, nil)
+}
+
+func (s *QdrantStore) ChunkHashes(ctx context.Context, docID string) (map[string]string, error) {
+	ns := NamespaceFrom(ctx)
+	if err := s.namespaceExists(ctx, ns); err != nil {
+		return nil, err
+	}
+	if !s.isReady() {
+		return nil, nil
+	}
+	hashes := make(map[string]string)
+	filter := map[string]any{"must": []any{qdrantNamespace(ns), qdrantMatch("doc_id", docID)}}
+	err := s.scroll(ctx, filter, []string{"chunk_id", "hash"}, func(p qdrantPayload) {
+		hashes[p.ChunkID] = p.Hash
+	})
+	return hashes, err
+}
+
+func (s *QdrantStore) DeleteChunks(ctx context.Context, ids []string) error {
+	if len(ids) == 0 || !s.isReady() {
+		return nil
+	}
+	ns := NamespaceFrom(ctx)
+	points := make([]string, len(ids))
+	for i, id := range ids {
+		points[i] = qdrantPointID(ns, id)
+	}
+	return s.do(ctx, http.MethodPost, s.path("/points/delete?wait=true"), map[string]any{"points": points}, nil)
+}
+
+func (s *QdrantStore) Documents(ctx context.Context) ([]DocumentInfo, error) {
+	ns := NamespaceFrom(ctx)
+	if err := s.namespaceExists(ctx, ns); err != nil {
+		return nil, err
+	}
+	if !s.isReady() {
+		return nil, nil
+	}
+	counts := make(map[string]int)
+	filter := map[string]any{"must": []any{qdrantNamespace(ns)}}
+	err := s.scroll(ctx, filter, []string{"doc_id"}, func(p qdrantPayload) {
+		counts[p.DocID]++
+	})
+	if err != nil {
+		return nil, err
+	}
+	docs := make([]DocumentInfo, 0, len(counts))
+	for id, n := range counts {
+		docs = append(docs, DocumentInfo{ID: id, Chunks: n})
+	}
+	slices.SortFunc(docs, func(a, b DocumentInfo) int { return cmp.Compare(a.ID, b.ID) })
+	return docs, nil
+}
+
+// scroll pages through the points matching filter, or all points if it is
+// nil, calling fn with the requested payload fields of each.
+func (s *QdrantStore) scroll(ctx context.Context, filter map[string]any, fields []string, fn func(qdrantPayload)) error {
+	return qdrantScroll(ctx, s, s.path("/points/scroll"), filter, fields, fn)
+}
+
+// qdrantScroll is like QdrantStore.scroll for any collection, given the path
+// of its scroll endpoint, and payload type.
+func qdrantScroll[P any](ctx context.Context, s *QdrantStore, path string, filter map[string]any, fields []string, fn func(P)) error {
+	var offset any
+	for {
+		req := map[string]any{"limit": qdrantScrollLimit, "with_payload": fields, "with_vector": false}
+		if filter != nil {
+			req["filter"] = filter
+		}
+		if offset != nil {
+			req["offset"] = offset
+		}
+		var page struct {
+			Points []struct {
+				Payload P `json:"payload"`
+			} `json:"points"`
+			NextPageOffset any `json:"next_page_offset"`
+		}
+		if err := s.do(ctx, http.MethodPost, path, req, &page); err != nil {
+			return err
+		}
+		for _, p := range page.Points {
+			fn(p.Payload)
+		}
+		if page.NextPageOffset == nil {
+			return nil
+		}
+		offset = page.NextPageOffset
+	}
+}
+
+func (s *QdrantStore) CreateNamespace(ctx context.Context, name string) error {
+	if err := checkNamespaceChange(name); err != nil {
+		return err
+	}
+	if err := s.ensureRegistry(ctx); err != nil {
+		return err
+	}
+	if err := s.namespaceExists(ctx, name); err == nil {
+		return fmt.Errorf("%w: %s", ErrNamespaceExists, name)
+	} else if !errors.Is(err, ErrNamespaceNotFound) {
+		return err
+	}
+	// The collection only holds payloads, but Qdrant requires a vector
+	return s.do(ctx, http.MethodPut, s.registryPath("/points?wait=true"), map[string]any{
+		"points": []any{map[string]any{
+			"id":      qdrantPointID(DefaultNamespace, name),
+			"vector":  []float32{1},
+			"payload": qdrantNamespaceInfo{Name: name},
+		}},
+	}, nil)
+}
+
+func (s *QdrantStore) DeleteNamespace(ctx context.Context, name string) error {
+	if err := checkNamespaceChange(name); err != nil {
+		return err
+	}
+	if err := s.namespaceExists(ctx, name); err != nil {
+		return err
+	}
+	if s.isReady() {
+		err := s.do(ctx, http.MethodPost, s.path("/points/delete?wait=true"), map[string]any{
+			"filter": map[string]any{"must": []any{qdrantNamespace(name)}},
+		}, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return s.do(ctx, http.MethodPost, s.registryPath("/points/delete?wait=true"), map[string]any{
+		"points": []string{qdrantPointID(DefaultNamespace, name)},
+	}, nil)
+}
+
+func (s *QdrantStore) Namespaces(ctx context.Context) ([]NamespaceInfo, error) {
+	names := []string{DefaultNamespace}
+	if s.isRegistryReady() {
+		err := qdrantScroll(ctx, s, s.registryPath("/points/scroll"), nil, []string{"name"}, func(n qdrantNamespaceInfo) {
+			// The point of the IndexModel has no name
+			if n.Name != "" {
+				names = append(names, n.Name)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	slices.Sort(names)
+	namespaces := make([]NamespaceInfo, len(names))
+	for i, name := range names {
+		namespaces[i].Name = name
+		if !s.isReady() {
+			continue
+		}
+		var res struct {
+			Count int `json:"count"`
+		}
+		err := s.do(ctx, http.MethodPost, s.path("/points/count"), map[string]any{
+			"filter": map[string]any{"must": []any{qdrantNamespace(name)}},
+			"exact":  true,
+		}, &res)
+		if err != nil {
+			return nil, err
+		}
+		namespaces[i].Chunks = res.Count
+	}
+	return namespaces, nil
+}
+
+// qdrantNamespaceInfo is the payload of the points recording namespaces.
+type qdrantNamespaceInfo struct {
+	Name string `json:"name"`
+}
+
+func (s *QdrantStore) IndexModel(ctx context.Context) (*IndexModel, error) {
+	return s.model.get(func() (*IndexModel, error) {
+		if !s.isRegistryReady() {
+			return nil, nil
+		}
+		var points []struct {
+			Payload struct {
+				Model *IndexModel `json:"model"`
+			} `json:"payload"`
+		}
+		err := s.do(ctx, http.MethodPost, s.registryPath("/points"), map[string]any{
+			"ids":          []string{nameUUID(qdrantModelPoint)},
+			"with_payload": true,
+		}, &points)
+		if err != nil || len(points) == 0 {
+			return nil, err
+		}
+		return points[0].Payload.Model, nil
+	})
+}
+
+func (s *QdrantStore) SetIndexModel(ctx context.Context, m IndexModel) error {
+	if err := s.ensureRegistry(ctx); err != nil {
+		return err
+	}
+	err := s.do(ctx, http.MethodPut, s.registryPath("/points?wait=true"), map[string]any{
+		"points": []any{map[string]any{
+			"id":      nameUUID(qdrantModelPoint),
+			"vector":  []float32{1},
+			"payload": map[string]any{"model": m},
+		}},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("qdrant: recording the index model: %w", err)
+	}
+	s.model.set(m)
+	return nil
+}
+
+// ensureRegistry creates the collection recording namespaces if needed.
+func (s *QdrantStore) ensureRegistry(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.registryReady {
+		return nil
+	}
+	err := s.do(ctx, http.MethodPut, s.registryPath(""), map[string]any{
+		"vectors": map[string]any{"size": 1, "distance": "Dot"},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("qdrant: creating namespace collection: %w", err)
+	}
+	s.registryReady = true
+	return nil
+}
+
+func (s *QdrantStore) isRegistryReady() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.registryReady
+}
+
+// namespaceExists returns ErrNamespaceNotFound unless ns is DefaultNamespace
+// or was created.
+func (s *QdrantStore) namespaceExists(ctx context.Context, ns string) error {
+	if ns == DefaultNamespace {
+		return nil
+	}
+	if s.isRegistryReady() {
+		var points []json.RawMessage
+		err := s.do(ctx, http.MethodPost, s.registryPath("/points"), map[string]any{
+			"ids": []string{qdrantPointID(DefaultNamespace, ns)},
+		}, &points)
+		if err != nil {
+			return err
+		}
+		if len(points) > 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrNamespaceNotFound, ns)
+}
+
+func (p qdrantPayload) chunk() Chunk {
+	return Chunk{ID: p.ChunkID, DocID: p.DocID, Index: p.Index, Text: p.Text, Metadata: p.Metadata, Hash: p.Hash}
+}
+
+// qdrantFilter translates filter into a Qdrant filter. String equality
+// becomes a match on metadata.<key>; numeric comparisons become a range on
+// metadata_num.<key>. Qdrant has no string ranges, so those are rejected.
+func qdrantFilter(filter Filter) (map[string]any, error) {
+	var must, mustNot []any
+	for _, c := range filter {
+		key := "metadata." + c.Key
+		if !c.numeric() {
+			switch c.Op {
+			case OpEq:
+				must = append(must, qdrantMatch(key, c.Value))
+			case OpNe:
+				// Chunks without the key never match, as in Filter.Match
+				must = append(must, map[string]any{"must_not": []any{map[string]any{"is_empty": map[string]any{"key": key}}}})
+				mustNot = append(mustNot, qdrantMatch(key, c.Value))
+			default:
+				return nil, fmt.Errorf("qdrant: filter %s: range comparisons of non-numeric values are %w", c.Key+string(c.Op)+c.Value, ErrNotSupported)
+			}
+			continue
+		}
+		key = "metadata_num." + c.Key
+		v, _ := parseNumber(c.Value)
+		var bounds map[string]any
+		switch c.Op {
+		case OpEq, OpNe:
+			bounds = map[string]any{"gte": v, "lte": v}
+		case OpLt:
+			bounds = map[string]any{"lt": v}
+		case OpLe:
+			bounds = map[string]any{"lte": v}
+		case OpGt:
+			bounds = map[string]any{"gt": v}
+		case OpGe:
+			bounds = map[string]any{"gte": v}
+		}
+		cond := map[string]any{"key": key, "range": bounds}
+		if c.Op == OpNe {
+			must = append(must, map[string]any{"must_not": []any{map[string]any{"is_empty": map[string]any{"key": key}}}})
+			mustNot = append(mustNot, cond)
+			continue
+		}
+		must = append(must, cond)
+	}
+	f := map[string]any{}
+	if len(must) > 0 {
+		f["must"] = must
+	}
+	if len(mustNot) > 0 {
+		f["must_not"] = mustNot
+	}
+	return f, nil
+}
+
+func qdrantMatch(key, value string) map[string]any {
+	return map[string]any{"key": key, "match": map[string]any{"value": value}}
+}
+
+// qdrantNamespace matches the points of a namespace. Points without one were
+// stored before namespaces existed and belong to DefaultNamespace.
+func qdrantNamespace(ns string) map[string]any {
+	if ns != DefaultNamespace {
+		return qdrantMatch("namespace", ns)
+	}
+	return map[string]any{"should": []any{
+		qdrantMatch("namespace", ns),
+		map[string]any{"is_empty": map[string]any{"key": "namespace"}},
+	}}
+}
+
+// numericMetadata returns the metadata values that parse as numbers.
+func numericMetadata(m Metadata) map[string]float64 {
+	var nums map[string]float64
+	for k, v := range m {
+		if f, ok := parseNumber(v); ok {
+			if nums == nil {
+				nums = make(map[string]float64)
+			}
+			nums[k] = f
+		}
+	}
+	return nums
+}
+
+// qdrantPointID derives a stable UUID from a namespace and chunk ID, since
+// Qdrant point IDs must be integers or UUIDs. Chunks of DefaultNamespace
+// keep the IDs they had before namespaces existed.
+func qdrantPointID(namespace, chunkID string) string {
+	key := chunkID
+	if namespace != DefaultNamespace {
+		key = namespace + "/" + chunkID
+	}
+	return nameUUID(key)
+}
+
+// nameUUID derives a stable, name-based UUID from key, for stores whose
+// object IDs must be UUIDs.
+func nameUUID(key string) string {
+	h := sha1.Sum([]byte(key))
+	h[6] = h[6]&0x0f | 0x50 // version 5
+	h[8] = h[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+}
+
+// CheckHealth reads the collection's info.
+func (s *QdrantStore) CheckHealth(ctx context.Context) error {
+	if err := s.do(ctx, http.MethodGet, s.path(""), nil, nil); err != nil {
+		return fmt.Errorf("qdrant: %w", err)
+	}
+	return nil
+}
+
+// do sends a request to the Qdrant API and decodes the "result" field of
+// the response into out, if out is not nil.
+func (s *QdrantStore) do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader = http.NoBody
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.apiKey != "" {
+		req.Header.Set("api-key", s.apiKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var res struct {
+		Result json.RawMessage `json:"result"`
+		Status any             `json:"status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return fmt.Errorf("qdrant: %s %s: %s", method, path, resp.Status)
+	}
+	if resp.StatusCode >= 300 {
+		if status, ok := res.Status.(map[string]any); ok {
+			return fmt.Errorf("qdrant: %s %s: %s: %v", method, path, resp.Status, status["error"])
+		}
+		return fmt.Errorf("qdrant: %s %s: %s", method, path, resp.Status)
+	}
+	if out == nil || len(res.Result) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(res.Result, out); err != nil {
+		return fmt.Errorf("qdrant: decoding %s response: %w", path, err)
+	}
+	return nil
+}
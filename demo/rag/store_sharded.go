@@ -0,0 +1,469 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// ShardMode selects how a ShardedStore partitions chunks among its shards.
+type ShardMode string
+
+const (
+	// ShardByHash keeps every document on the shard its ID hashes to, so
+	// that every shard holds part of every namespace and searches are sent
+	// to all shards.
+	ShardByHash ShardMode = "hash"
+	// ShardByNamespace keeps every namespace on the shard its name hashes
+	// to, so that searches are sent to one shard only.
+	ShardByNamespace ShardMode = "namespace"
+)
+
+// ShardedStore partitions an index among several stores, its shards, for
+// corpora too large for one. The chunks of a document are always kept
+// together on one shard, chosen by the hash of the document ID or of the
+// namespace as Mode says, and requests for a document go to its shard
+// only. Searches and listings that span shards are sent to all of them in
+// parallel and their results merged, best first or ordered as the
+// VectorStore methods say. Namespaces are created on every shard with
+// ShardByHash.
+//
+// Shards are chosen by hash modulo the number of shards, so changing their
+// number moves most documents to another shard: the index must then be
+// built again, for example by exporting it before and importing it after.
+type ShardedStore struct {
+	Shards []IncrementalStore
+	Mode   ShardMode // ShardByHash if empty
+}
+
+// NewShardedStore returns a ShardedStore over shards, which must all be
+// IncrementalStores, with the optional capabilities they all share: it is
+// a SourceStore, ParentStore, ChunkStore and ProfileStore, and an
+// AtomicStore too, if they all are, or else a SparseStore, and a
+// ChunkStore too, or a HybridSearcher, if they all are, as the stores of
+// this package are. It is always a ModelStore, recording the model on
+// every shard that is one.
+func NewShardedStore(shards []VectorStore, mode ShardMode) (VectorStore, error) {
+	switch mode {
+	case "":
+		mode = ShardByHash
+	case ShardByHash, ShardByNamespace:
+	default:
+		return nil, fmt.Errorf("unknown shard mode %q", mode)
+	}
+	if len(shards) == 0 {
+		return nil, errors.New("no shards")
+	}
+	s := &ShardedStore{Mode: mode}
+	for i, shard := range shards {
+		incremental, ok := shard.(IncrementalStore)
+		if !ok {
+			return nil, fmt.Errorf("shard %d is not an IncrementalStore", i)
+		}
+		s.Shards = append(s.Shards, incremental)
+	}
+	documents := allAre[SourceStore](shards) && allAre[ParentStore](shards) && allAre[ChunkStore](shards) && allAre[ProfileStore](shards)
+	switch {
+	case documents && allAre[AtomicStore](shards):
+		return shardedAtomicStore{shardedDocumentStore{s}}, nil
+	case documents:
+		return shardedDocumentStore{s}, nil
+	case allAre[SparseStore](shards) && allAre[ChunkStore](shards):
+		return shardedSparseChunkStore{shardedSparseStore{s}}, nil
+	case allAre[SparseStore](shards):
+		return shardedSparseStore{s}, nil
+	case allAre[HybridSearcher](shards):
+		return shardedHybridStore{s}, nil
+	}
+	return s, nil
+}
+
+// allAre reports whether all of shards are a T.
+func allAre[T any](shards []VectorStore) bool {
+	return !slices.ContainsFunc(shards, func(s VectorStore) bool {
+		_, ok := s.(T)
+		return !ok
+	})
+}
+
+// newShardedStore creates the ShardedStore that cfg configures. Every shard
+// is a store of cfg.VectorStore at one of cfg.ShardURLs or, without them,
+// a SQLite file or a collection whose name has the shard's number appended,
+// as rag_0.db or rag_0.
+func newShardedStore(ctx context.Context, cfg Config) (VectorStore, error) {
+	var urls []string
+	for _, url := range strings.Split(cfg.ShardURLs, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+	n := cfg.Shards
+	switch {
+	case len(urls) > 0 && n > 0 && n != len(urls):
+		return nil, fmt.Errorf("SHARDS is %d but SHARD_URLS has %d", n, len(urls))
+	case len(urls) > 0:
+		n = len(urls)
+	case cfg.VectorStore == "pgvector":
+		return nil, errors.New("sharding pgvector requires SHARD_URLS")
+	}
+	if cfg.VectorStore == "memory" && len(urls) > 0 {
+		return nil, errors.New("the memory store takes no SHARD_URLS")
+	}
+	shards := make([]VectorStore, n)
+	for i := range shards {
+		shard := cfg
+		shard.Shards, shard.ShardURLs = 0, ""
+		url := ""
+		if len(urls) > 0 {
+			url = urls[i]
+		}
+		switch cfg.VectorStore {
+		case "", "sqlite":
+			if url == "" {
+				ext := filepath.Ext(cfg.SQLitePath)
+				url = fmt.Sprintf("%s_%d%s", strings.TrimSuffix(cfg.SQLitePath, ext), i, ext)
+			}
+			shard.SQLitePath = url
+		case "pgvector":
+			shard.DatabaseURL = url
+		case "qdrant", "weaviate", "milvus", "opensearch", "elasticsearch":
+			if url == "" {
+				shard.Collection = fmt.Sprintf("%s_%d", cfg.Collection, i)
+			}
+			shard.QdrantURL = cmp.Or(url, cfg.QdrantURL)
+			shard.WeaviateURL = cmp.Or(url, cfg.WeaviateURL)
+			shard.MilvusURL = cmp.Or(url, cfg.MilvusURL)
+			shard.OpenSearchURL = cmp.Or(url, cfg.OpenSearchURL)
+		}
+		store, err := NewVectorStore(ctx, shard)
+		if err != nil {
+			return nil, fmt.Errorf("shard %d: %w", i, err)
+		}
+		shards[i] = store
+	}
+	return NewShardedStore(shards, ShardMode(cfg.ShardBy))
+}
+
+// shardIndex returns the index of the shard key hashes to.
+func (s *ShardedStore) shardIndex(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(s.Shards)))
+}
+
+// shard returns the shard holding the document docID of the namespace of
+// ctx.
+func (s *ShardedStore) shard(ctx context.Context, docID string) IncrementalStore {
+	if s.Mode == ShardByNamespace {
+		return s.Shards[s.shardIndex(NamespaceFrom(ctx))]
+	}
+	return s.Shards[s.shardIndex(docID)]
+}
+
+// spanned returns the shards a request spanning the documents of the
+// namespace of ctx is sent to.
+func (s *ShardedStore) spanned(ctx context.Context) []IncrementalStore {
+	if s.Mode == ShardByNamespace {
+		return s.Shards[s.shardIndex(NamespaceFrom(ctx)):][:1]
+	}
+	return s.Shards
+}
+
+// CheckHealth checks every shard.
+func (s *ShardedStore) CheckHealth(ctx context.Context) error {
+	_, err := fanOut(ctx, s.Shards, func(ctx context.Context, shard IncrementalStore) (struct{}, error) {
+		return struct{}{}, storeHealth(ctx, shard)
+	})
+	return err
+}
+
+// fanOut calls fn with every shard of shards in parallel, returning the
+// results in the order of shards, or the first error.
+func fanOut[T any](ctx context.Context, shards []IncrementalStore, fn func(context.Context, IncrementalStore) (T, error)) ([]T, error) {
+	results := make([]T, len(shards))
+	g, gctx := errgroup.WithContext(ctx)
+	for i, shard := range shards {
+		g.Go(func() error {
+			var err error
+			if results[i], err = fn(gctx, shard); err != nil && len(shards) > 1 {
+				return fmt.Errorf("shard %d: %w", i, err)
+			}
+			return err
+		})
+	}
+	return results, g.Wait()
+}
+
+// mergeResults returns the k best of the results of all shards.
+func mergeResults(results [][]SearchResult, k int) []SearchResult {
+	merged := slices.Concat(results...)
+	sortResults(merged)
+	if len(merged) > k {
+		merged = merged[:k]
+	}
+	return merged
+}
+
+func (s *ShardedStore) Upsert(ctx context.Context, chunks []Chunk) error {
+	byShard := make(map[IncrementalStore][]Chunk)
+	for _, c := range chunks {
+		shard := s.shard(ctx, c.DocID)
+		byShard[shard] = append(byShard[shard], c)
+	}
+	_, err := fanOut(ctx, slices.Collect(maps.Keys(byShard)), func(ctx context.Context, shard IncrementalStore) (struct{}, error) {
+		return struct{}{}, shard.Upsert(ctx, byShard[shard])
+	})
+	return err
+}
+
+func (s *ShardedStore) Search(ctx context.Context, query []float32, k int, filter Filter) ([]SearchResult, error) {
+	results, err := fanOut(ctx, s.spanned(ctx), func(ctx context.Context, shard IncrementalStore) ([]SearchResult, error) {
+		return shard.Search(ctx, query, k, filter)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mergeResults(results, k), nil
+}
+
+func (s *ShardedStore) Delete(ctx context.Context, docID string) error {
+	return s.shard(ctx, docID).Delete(ctx, docID)
+}
+
+func (s *ShardedStore) Documents(ctx context.Context) ([]DocumentInfo, error) {
+	docs, err := fanOut(ctx, s.spanned(ctx), func(ctx context.Context, shard IncrementalStore) ([]DocumentInfo, error) {
+		return shard.Documents(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	merged := slices.Concat(docs...)
+	slices.SortFunc(merged, func(a, b DocumentInfo) int { return cmp.Compare(a.ID, b.ID) })
+	return merged, nil
+}
+
+// ChunkMetadata asks every shard the namespace may be on, which must all be
+// MetadataStores.
+func (s *ShardedStore) ChunkMetadata(ctx context.Context, key string) ([]Chunk, error) {
+	chunks, err := fanOut(ctx, s.spanned(ctx), func(ctx context.Context, shard IncrementalStore) ([]Chunk, error) {
+		ms, ok := shard.(MetadataStore)
+		if !ok {
+			return nil, fmt.Errorf("finding chunks by metadata is %w by the shard", ErrNotSupported)
+		}
+		return ms.ChunkMetadata(ctx, key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return slices.Concat(chunks...), nil
+}
+
+// IndexModel reads the model of the first shard, on which, like on every
+// other, SetIndexModel records it; shards that are not ModelStores record
+// none.
+func (s *ShardedStore) IndexModel(ctx context.Context) (*IndexModel, error) {
+	ms, ok := s.Shards[0].(ModelStore)
+	if !ok {
+		return nil, nil
+	}
+	return ms.IndexModel(ctx)
+}
+
+func (s *ShardedStore) SetIndexModel(ctx context.Context, m IndexModel) error {
+	for _, shard := range s.Shards {
+		if ms, ok := shard.(ModelStore); ok {
+			if err := ms.SetIndexModel(ctx, m); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *ShardedStore) ChunkHashes(ctx context.Context, docID string) (map[string]string, error) {
+	return s.shard(ctx, docID).ChunkHashes(ctx, docID)
+}
+
+// DeleteChunks asks the shards that may hold the chunks to delete them,
+// since chunk IDs do not say which document they belong to.
+func (s *ShardedStore) DeleteChunks(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := fanOut(ctx, s.spanned(ctx), func(ctx context.Context, shard IncrementalStore) (struct{}, error) {
+		return struct{}{}, shard.DeleteChunks(ctx, ids)
+	})
+	return err
+}
+
+// CreateNamespace creates the namespace on its shard, or with ShardByHash
+// on every shard, creating it where it is missing if only some have it.
+func (s *ShardedStore) CreateNamespace(ctx context.Context, name string) error {
+	return s.changeNamespace(ctx, name, ErrNamespaceExists, func(ctx context.Context, shard IncrementalStore) error {
+		return shard.CreateNamespace(ctx, name)
+	})
+}
+
+// DeleteNamespace deletes the namespace from its shard, or with
+// ShardByHash from every shard holding it.
+func (s *ShardedStore) DeleteNamespace(ctx context.Context, name string) error {
+	return s.changeNamespace(ctx, name, ErrNamespaceNotFound, func(ctx context.Context, shard IncrementalStore) error {
+		return shard.DeleteNamespace(ctx, name)
+	})
+}
+
+// changeNamespace calls change with the shards of the namespace name,
+// only failing with unchanged, the error of shards that needed no change,
+// if all of them failed with it.
+func (s *ShardedStore) changeNamespace(ctx context.Context, name string, unchanged error, change func(context.Context, IncrementalStore) error) error {
+	shards := s.Shards
+	if s.Mode == ShardByNamespace {
+		shards = s.Shards[s.shardIndex(name):][:1]
+	}
+	unchangedBy, err := fanOut(ctx, shards, func(ctx context.Context, shard IncrementalStore) (bool, error) {
+		if err := change(ctx, shard); errors.Is(err, unchanged) {
+			return true, nil
+		} else {
+			return false, err
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(unchangedBy, false) {
+		return fmt.Errorf("%w: %s", unchanged, name)
+	}
+	return nil
+}
+
+// Namespaces lists the namespaces of all shards, adding up their chunks.
+func (s *ShardedStore) Namespaces(ctx context.Context) ([]NamespaceInfo, error) {
+	lists, err := fanOut(ctx, s.Shards, func(ctx context.Context, shard IncrementalStore) ([]NamespaceInfo, error) {
+		return shard.Namespaces(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	chunks := make(map[string]int)
+	for _, list := range lists {
+		for _, ns := range list {
+			chunks[ns.Name] += ns.Chunks
+		}
+	}
+	namespaces := make([]NamespaceInfo, 0, len(chunks))
+	for _, name := range slices.Sorted(maps.Keys(chunks)) {
+		namespaces = append(namespaces, NamespaceInfo{Name: name, Chunks: chunks[name]})
+	}
+	return namespaces, nil
+}
+
+// shardedDocumentStore is a ShardedStore of SourceStores, ParentStores,
+// ChunkStores and ProfileStores.
+type shardedDocumentStore struct{ *ShardedStore }
+
+func (s shardedDocumentStore) PutSource(ctx context.Context, doc *Document) error {
+	return s.shard(ctx, doc.ID).(SourceStore).PutSource(ctx, doc)
+}
+
+func (s shardedDocumentStore) Source(ctx context.Context, docID string) (*Document, error) {
+	return s.shard(ctx, docID).(SourceStore).Source(ctx, docID)
+}
+
+func (s shardedDocumentStore) ReplaceParents(ctx context.Context, docID string, parents []Chunk) error {
+	return s.shard(ctx, docID).(ParentStore).ReplaceParents(ctx, docID, parents)
+}
+
+// Parents asks the shards that may hold the parents for them, since parent
+// IDs do not say which document they belong to.
+func (s shardedDocumentStore) Parents(ctx context.Context, ids []string) (map[string]Chunk, error) {
+	found, err := fanOut(ctx, s.spanned(ctx), func(ctx context.Context, shard IncrementalStore) (map[string]Chunk, error) {
+		return shard.(ParentStore).Parents(ctx, ids)
+	})
+	if err != nil {
+		return nil, err
+	}
+	parents := make(map[string]Chunk)
+	for _, m := range found {
+		maps.Copy(parents, m)
+	}
+	return parents, nil
+}
+
+func (s shardedDocumentStore) Chunks(ctx context.Context, docID string) ([]Chunk, error) {
+	return s.shard(ctx, docID).(ChunkStore).Chunks(ctx, docID)
+}
+
+// NamespaceProfile reads the profile from the shards of the namespace in
+// turn, skipping those that do not hold it.
+func (s shardedDocumentStore) NamespaceProfile(ctx context.Context, name string) (*NamespaceProfile, error) {
+	shards := s.Shards
+	if s.Mode == ShardByNamespace {
+		shards = s.Shards[s.shardIndex(name):][:1]
+	}
+	for _, shard := range shards {
+		profile, err := shard.(ProfileStore).NamespaceProfile(ctx, name)
+		if !errors.Is(err, ErrNamespaceNotFound) {
+			return profile, err
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNamespaceNotFound, name)
+}
+
+// SetNamespaceProfile stores the profile on every shard holding the
+// namespace.
+func (s shardedDocumentStore) SetNamespaceProfile(ctx context.Context, name string, profile *NamespaceProfile) error {
+	return s.changeNamespace(ctx, name, ErrNamespaceNotFound, func(ctx context.Context, shard IncrementalStore) error {
+		return shard.(ProfileStore).SetNamespaceProfile(ctx, name, profile)
+	})
+}
+
+// shardedAtomicStore is a shardedDocumentStore of AtomicStores. Documents
+// are written to their shard in one transaction.
+type shardedAtomicStore struct{ shardedDocumentStore }
+
+func (s shardedAtomicStore) ReplaceDocument(ctx context.Context, w DocumentWrite) error {
+	return s.shard(ctx, w.Document.ID).(AtomicStore).ReplaceDocument(ctx, w)
+}
+
+// shardedSparseStore is a ShardedStore of SparseStores.
+type shardedSparseStore struct{ *ShardedStore }
+
+func (s shardedSparseStore) SearchSparse(ctx context.Context, query SparseVector, k int, filter Filter) ([]SearchResult, error) {
+	results, err := fanOut(ctx, s.spanned(ctx), func(ctx context.Context, shard IncrementalStore) ([]SearchResult, error) {
+		return shard.(SparseStore).SearchSparse(ctx, query, k, filter)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mergeResults(results, k), nil
+}
+
+// shardedSparseChunkStore is a shardedSparseStore of ChunkStores.
+type shardedSparseChunkStore struct{ shardedSparseStore }
+
+func (s shardedSparseChunkStore) Chunks(ctx context.Context, docID string) ([]Chunk, error) {
+	return s.shard(ctx, docID).(ChunkStore).Chunks(ctx, docID)
+}
+
+// shardedHybridStore is a ShardedStore of HybridSearchers. The fused
+// scores of the shards are merged as they are, so that the ranking across
+// shards only approximates the one a single store would fuse.
+type shardedHybridStore struct{ *ShardedStore }
+
+func (s shardedHybridStore) HybridSearch(ctx context.Context, query string, vector []float32, k int, weight float64, filter Filter) ([]SearchResult, error) {
+	results, err := fanOut(ctx, s.spanned(ctx), func(ctx context.Context, shard IncrementalStore) ([]SearchResult, error) {
+		return shard.(HybridSearcher).HybridSearch(ctx, query, vector, k, weight, filter)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mergeResults(results, k), nil
+}
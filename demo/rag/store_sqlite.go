@@ -0,0 +1,641 @@
+package rag
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// sqliteMigrations is the schema of the store, see openSQLite.
+var sqliteMigrations = []string{
+	`CREATE TABLE rag_chunks (
+		id        TEXT PRIMARY KEY,
+		doc_id    TEXT NOT NULL,
+		idx       INTEGER NOT NULL,
+		text      TEXT NOT NULL,
+		metadata  TEXT NOT NULL DEFAULT '{}',
+		hash      TEXT NOT NULL DEFAULT '',
+		embedding BLOB NOT NULL
+	)`,
+	`CREATE INDEX rag_chunks_doc_id ON rag_chunks (doc_id)`,
+	// Namespaces: chunk IDs become unique per namespace, which needs a new
+	// table as SQLite cannot change a primary key in place
+	`CREATE TABLE rag_namespaces (name TEXT PRIMARY KEY) WITHOUT ROWID`,
+	`CREATE TABLE rag_chunks_v2 (
+		namespace TEXT NOT NULL,
+		id        TEXT NOT NULL,
+		doc_id    TEXT NOT NULL,
+		idx       INTEGER NOT NULL,
+		text      TEXT NOT NULL,
+		metadata  TEXT NOT NULL DEFAULT '{}',
+		hash      TEXT NOT NULL DEFAULT '',
+		embedding BLOB NOT NULL,
+		PRIMARY KEY (namespace, id)
+	)`,
+	`INSERT INTO rag_chunks_v2 (namespace, id, doc_id, idx, text, metadata, hash, embedding)
+		SELECT 'default', id, doc_id, idx, text, metadata, hash, embedding FROM rag_chunks`,
+	`DROP TABLE rag_chunks`,
+	`ALTER TABLE rag_chunks_v2 RENAME TO rag_chunks`,
+	`CREATE INDEX rag_chunks_doc_id ON rag_chunks (namespace, doc_id)`,
+	`CREATE TABLE rag_documents (
+		namespace TEXT NOT NULL,
+		id        TEXT NOT NULL,
+		document  TEXT NOT NULL,
+		PRIMARY KEY (namespace, id)
+	) WITHOUT ROWID`,
+	`ALTER TABLE rag_chunks ADD COLUMN parent_id TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE rag_parents (
+		namespace TEXT NOT NULL,
+		id        TEXT NOT NULL,
+		doc_id    TEXT NOT NULL,
+		idx       INTEGER NOT NULL,
+		text      TEXT NOT NULL,
+		metadata  TEXT NOT NULL DEFAULT '{}',
+		PRIMARY KEY (namespace, id)
+	) WITHOUT ROWID`,
+	`CREATE INDEX rag_parents_doc_id ON rag_parents (namespace, doc_id)`,
+	`ALTER TABLE rag_chunks ADD COLUMN quantized BLOB`,
+	`CREATE TABLE rag_index_model (
+		id         INTEGER PRIMARY KEY CHECK (id = 1),
+		model      TEXT NOT NULL,
+		dimensions INTEGER NOT NULL,
+		normalized INTEGER NOT NULL
+	)`,
+	`CREATE TABLE rag_namespace_profiles (
+		name    TEXT PRIMARY KEY,
+		profile TEXT NOT NULL
+	) WITHOUT ROWID`,
+}
+
+// SQLiteStore is a VectorStore that persists chunks in a local SQLite file,
+// so that ingested documents survive restarts without running a database
+// server. Embeddings are stored as little-endian float32 blobs and, unlike
+// in MemoryStore, every chunk matching the filter is scored on each search.
+// With a Quantization, chunks are stored with the codes of their
+// embeddings too, and a search reads and scores only the codes, then
+// rescores its best candidates with their embeddings. Chunks stored without
+// the codes of the quantization, such as before it was set, are scored by
+// quantizing their embeddings as they are read, until they are stored
+// again.
+type SQLiteStore struct {
+	db           *sql.DB
+	metric       Metric
+	quantization Quantization
+	model        modelCache
+}
+
+// NewSQLiteStore opens or creates the database file at path and migrates
+// its schema. The path ":memory:" opens a database that is not persisted.
+func NewSQLiteStore(ctx context.Context, path string, metric Metric, quantization Quantization) (*SQLiteStore, error) {
+	db, err := openSQLite(ctx, path, sqliteMigrations)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: %w", err)
+	}
+	return &SQLiteStore{db: db, metric: metric, quantization: quantization}, nil
+}
+
+// Close closes the database.
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// CheckHealth pings the database.
+func (s *SQLiteStore) CheckHealth(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *SQLiteStore) Upsert(ctx context.Context, chunks []Chunk) error {
+	ns := NamespaceFrom(ctx)
+	return sqliteTx(ctx, s.db, func(tx *sql.Tx) error {
+		if err := sqliteNamespaceExists(ctx, tx, ns); err != nil {
+			return err
+		}
+		return s.upsert(ctx, tx, ns, chunks)
+	})
+}
+
+func (s *SQLiteStore) upsert(ctx context.Context, tx *sql.Tx, ns string, chunks []Chunk) error {
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO rag_chunks (namespace, id, doc_id, parent_id, idx, text, metadata, hash, embedding, quantized)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (namespace, id) DO UPDATE SET doc_id = excluded.doc_id, parent_id = excluded.parent_id,
+			idx = excluded.idx, text = excluded.text, metadata = excluded.metadata, hash = excluded.hash,
+			embedding = excluded.embedding, quantized = excluded.quantized`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, c := range chunks {
+		metadata, err := json.Marshal(c.Metadata)
+		if err != nil {
+			return err
+		}
+		var codes any
+		if s.quantization != QuantizationOff {
+			codes = s.quantization.quantize(normalize(s.metric, c.Embedding)).encode(s.quantization)
+		}
+		if _, err := stmt.ExecContext(ctx, ns, c.ID, c.DocID, c.ParentID, c.Index, c.Text, string(metadata), c.Hash, encodeVector(c.Embedding), codes); err != nil {
+			return fmt.Errorf("sqlite: storing chunk %s: %w", c.ID, err)
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStore) Search(ctx context.Context, query []float32, k int, filter Filter) ([]SearchResult, error) {
+	ns := NamespaceFrom(ctx)
+	if err := sqliteNamespaceExists(ctx, s.db, ns); err != nil {
+		return nil, err
+	}
+	// Score every chunk without loading its text, then fetch the text of
+	// the best k only, or of the candidates to rescore
+	q := s.quantization
+	stmt, args := `SELECT id, metadata, NULL, embedding FROM rag_chunks WHERE namespace = ?`, []any{ns}
+	var codes quantized
+	if q != QuantizationOff {
+		// The embeddings are only read for chunks without codes
+		stmt = `SELECT id, metadata, quantized, CASE WHEN quantized IS NULL OR substr(quantized, 1, 1) <> ? THEN embedding END
+			FROM rag_chunks WHERE namespace = ?`
+		args = []any{[]byte{quantizationTag(q)}, ns}
+		codes = q.quantize(normalize(s.metric, query))
+	}
+	rows, err := s.db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var results []SearchResult
+	for rows.Next() {
+		var (
+			r         SearchResult
+			metadata  string
+			stored    []byte
+			embedding []byte
+		)
+		if err := rows.Scan(&r.ID, &metadata, &stored, &embedding); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(metadata), &r.Metadata); err != nil {
+			return nil, fmt.Errorf("sqlite: metadata of chunk %s: %w", r.ID, err)
+		}
+		if !filter.Match(r.Metadata) {
+			continue
+		}
+		switch v, ok := decodeQuantized(q, stored); {
+		case q == QuantizationOff:
+			r.Score = similarity(s.metric, query, decodeVector(embedding))
+		case ok:
+			r.Score = q.score(codes, v)
+		default:
+			r.Score = q.score(codes, q.quantize(normalize(s.metric, decodeVector(embedding))))
+		}
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	sortResults(results)
+	if n := k * q.rescore(); len(results) > n {
+		results = results[:n]
+	}
+	if len(results) == 0 {
+		return nil, nil
+	}
+
+	index := make(map[string]int, len(results))
+	args = []any{ns}
+	for i, r := range results {
+		index[r.ID] = i
+		args = append(args, r.ID)
+	}
+	rows, err = s.db.QueryContext(ctx, `SELECT id, doc_id, parent_id, idx, text, hash, embedding FROM rag_chunks
+		WHERE namespace = ? AND id IN (?`+strings.Repeat(", ?", len(results)-1)+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			c         Chunk
+			embedding []byte
+		)
+		if err := rows.Scan(&c.ID, &c.DocID, &c.ParentID, &c.Index, &c.Text, &c.Hash, &embedding); err != nil {
+			return nil, err
+		}
+		r := &results[index[c.ID]]
+		r.DocID, r.ParentID, r.Index, r.Text, r.Hash = c.DocID, c.ParentID, c.Index, c.Text, c.Hash
+		if q != QuantizationOff {
+			r.Score = similarity(s.metric, query, decodeVector(embedding))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if q != QuantizationOff {
+		sortResults(results)
+		results = results[:min(k, len(results))]
+	}
+	return results, nil
+}
+
+func (s *SQLiteStore) Delete(ctx context.Context, docID string) error {
+	ns := NamespaceFrom(ctx)
+	return sqliteTx(ctx, s.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM rag_chunks WHERE namespace = ? AND doc_id = ?`, ns, docID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM rag_documents WHERE namespace = ? AND id = ?`, ns, docID); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `DELETE FROM rag_parents WHERE namespace = ? AND doc_id = ?`, ns, docID)
+		return err
+	})
+}
+
+func (s *SQLiteStore) ReplaceParents(ctx context.Context, docID string, parents []Chunk) error {
+	ns := NamespaceFrom(ctx)
+	return sqliteTx(ctx, s.db, func(tx *sql.Tx) error {
+		if err := sqliteNamespaceExists(ctx, tx, ns); err != nil {
+			return err
+		}
+		return sqliteReplaceParents(ctx, tx, ns, docID, parents)
+	})
+}
+
+func sqliteReplaceParents(ctx context.Context, tx *sql.Tx, ns, docID string, parents []Chunk) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM rag_parents WHERE namespace = ? AND doc_id = ?`, ns, docID); err != nil {
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO rag_parents (namespace, id, doc_id, idx, text, metadata)
+		VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, c := range parents {
+		metadata, err := json.Marshal(c.Metadata)
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.ExecContext(ctx, ns, c.ID, docID, c.Index, c.Text, string(metadata)); err != nil {
+			return fmt.Errorf("sqlite: storing parent %s: %w", c.ID, err)
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStore) Parents(ctx context.Context, ids []string) (map[string]Chunk, error) {
+	ns := NamespaceFrom(ctx)
+	if err := sqliteNamespaceExists(ctx, s.db, ns); err != nil {
+		return nil, err
+	}
+	parents := make(map[string]Chunk, len(ids))
+	if len(ids) == 0 {
+		return parents, nil
+	}
+	args := []any{ns}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT id, doc_id, idx, text, metadata FROM rag_parents
+		WHERE namespace = ? AND id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			c        Chunk
+			metadata string
+		)
+		if err := rows.Scan(&c.ID, &c.DocID, &c.Index, &c.Text, &metadata); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(metadata), &c.Metadata); err != nil {
+			return nil, fmt.Errorf("sqlite: metadata of parent %s: %w", c.ID, err)
+		}
+		parents[c.ID] = c
+	}
+	return parents, rows.Err()
+}
+
+func (s *SQLiteStore) PutSource(ctx context.Context, doc *Document) error {
+	ns := NamespaceFrom(ctx)
+	return sqliteTx(ctx, s.db, func(tx *sql.Tx) error {
+		if err := sqliteNamespaceExists(ctx, tx, ns); err != nil {
+			return err
+		}
+		return sqlitePutSource(ctx, tx, ns, doc)
+	})
+}
+
+func sqlitePutSource(ctx context.Context, tx *sql.Tx, ns string, doc *Document) error {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT OR REPLACE INTO rag_documents (namespace, id, document) VALUES (?, ?, ?)`,
+		ns, doc.ID, string(data))
+	return err
+}
+
+func (s *SQLiteStore) Source(ctx context.Context, docID string) (*Document, error) {
+	var data string
+	err := s.db.QueryRowContext(ctx, `SELECT document FROM rag_documents WHERE namespace = ? AND id = ?`,
+		NamespaceFrom(ctx), docID).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var doc Document
+	if err := json.Unmarshal([]byte(data), &doc); err != nil {
+		return nil, fmt.Errorf("sqlite: source of %s: %w", docID, err)
+	}
+	return &doc, nil
+}
+
+func (s *SQLiteStore) ChunkHashes(ctx context.Context, docID string) (map[string]string, error) {
+	ns := NamespaceFrom(ctx)
+	if err := sqliteNamespaceExists(ctx, s.db, ns); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT id, hash FROM rag_chunks WHERE namespace = ? AND doc_id = ?`, ns, docID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hashes := make(map[string]string)
+	for rows.Next() {
+		var id, hash string
+		if err := rows.Scan(&id, &hash); err != nil {
+			return nil, err
+		}
+		hashes[id] = hash
+	}
+	return hashes, rows.Err()
+}
+
+func (s *SQLiteStore) Chunks(ctx context.Context, docID string) ([]Chunk, error) {
+	ns := NamespaceFrom(ctx)
+	if err := sqliteNamespaceExists(ctx, s.db, ns); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT id, doc_id, parent_id, idx, text, metadata, hash, embedding FROM rag_chunks
+		WHERE namespace = ? AND doc_id = ? ORDER BY idx, id`, ns, docID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var chunks []Chunk
+	for rows.Next() {
+		var (
+			c         Chunk
+			metadata  string
+			embedding []byte
+		)
+		if err := rows.Scan(&c.ID, &c.DocID, &c.ParentID, &c.Index, &c.Text, &metadata, &c.Hash, &embedding); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(metadata), &c.Metadata); err != nil {
+			return nil, fmt.Errorf("sqlite: metadata of chunk %s: %w", c.ID, err)
+		}
+		c.Embedding = decodeVector(embedding)
+		chunks = append(chunks, c)
+	}
+	return chunks, rows.Err()
+}
+
+func (s *SQLiteStore) ChunkMetadata(ctx context.Context, key string) ([]Chunk, error) {
+	ns := NamespaceFrom(ctx)
+	if err := sqliteNamespaceExists(ctx, s.db, ns); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT id, doc_id, metadata FROM rag_chunks
+		WHERE namespace = ? AND json_type(metadata, '$.' || json_quote(?)) IS NOT NULL`, ns, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var chunks []Chunk
+	for rows.Next() {
+		var (
+			c        Chunk
+			metadata string
+		)
+		if err := rows.Scan(&c.ID, &c.DocID, &metadata); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(metadata), &c.Metadata); err != nil {
+			return nil, fmt.Errorf("sqlite: metadata of chunk %s: %w", c.ID, err)
+		}
+		chunks = append(chunks, c)
+	}
+	return chunks, rows.Err()
+}
+
+func (s *SQLiteStore) DeleteChunks(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return sqliteTx(ctx, s.db, func(tx *sql.Tx) error {
+		return sqliteDeleteChunks(ctx, tx, NamespaceFrom(ctx), ids)
+	})
+}
+
+func sqliteDeleteChunks(ctx context.Context, tx *sql.Tx, ns string, ids []string) error {
+	stmt, err := tx.PrepareContext(ctx, `DELETE FROM rag_chunks WHERE namespace = ? AND id = ?`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, id := range ids {
+		if _, err := stmt.ExecContext(ctx, ns, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ReplaceDocument(ctx context.Context, w DocumentWrite) error {
+	ns := NamespaceFrom(ctx)
+	return sqliteTx(ctx, s.db, func(tx *sql.Tx) error {
+		if err := sqliteNamespaceExists(ctx, tx, ns); err != nil {
+			return err
+		}
+		if err := sqliteDeleteChunks(ctx, tx, ns, w.Stale); err != nil {
+			return err
+		}
+		if err := s.upsert(ctx, tx, ns, w.Changed); err != nil {
+			return err
+		}
+		if err := sqlitePutSource(ctx, tx, ns, w.Document); err != nil {
+			return err
+		}
+		return sqliteReplaceParents(ctx, tx, ns, w.Document.ID, w.Parents)
+	})
+}
+
+func (s *SQLiteStore) Documents(ctx context.Context) ([]DocumentInfo, error) {
+	ns := NamespaceFrom(ctx)
+	if err := sqliteNamespaceExists(ctx, s.db, ns); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT doc_id, count(*) FROM rag_chunks
+		WHERE namespace = ? GROUP BY doc_id ORDER BY doc_id`, ns)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	docs := []DocumentInfo{}
+	for rows.Next() {
+		var d DocumentInfo
+		if err := rows.Scan(&d.ID, &d.Chunks); err != nil {
+			return nil, err
+		}
+		docs = append(docs, d)
+	}
+	return docs, rows.Err()
+}
+
+func (s *SQLiteStore) CreateNamespace(ctx context.Context, name string) error {
+	if err := checkNamespaceChange(name); err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `INSERT INTO rag_namespaces (name) VALUES (?) ON CONFLICT DO NOTHING`, name)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: %s", ErrNamespaceExists, name)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) DeleteNamespace(ctx context.Context, name string) error {
+	if err := checkNamespaceChange(name); err != nil {
+		return err
+	}
+	return sqliteTx(ctx, s.db, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `DELETE FROM rag_namespaces WHERE name = ?`, name)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fmt.Errorf("%w: %s", ErrNamespaceNotFound, name)
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM rag_chunks WHERE namespace = ?`, name); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM rag_documents WHERE namespace = ?`, name); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM rag_parents WHERE namespace = ?`, name); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM rag_namespace_profiles WHERE name = ?`, name)
+		return err
+	})
+}
+
+func (s *SQLiteStore) Namespaces(ctx context.Context) ([]NamespaceInfo, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT n.name, count(c.id) FROM
+		(SELECT ? AS name UNION SELECT name FROM rag_namespaces) n
+		LEFT JOIN rag_chunks c ON c.namespace = n.name
+		GROUP BY n.name ORDER BY n.name`, DefaultNamespace)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var namespaces []NamespaceInfo
+	for rows.Next() {
+		var n NamespaceInfo
+		if err := rows.Scan(&n.Name, &n.Chunks); err != nil {
+			return nil, err
+		}
+		namespaces = append(namespaces, n)
+	}
+	return namespaces, rows.Err()
+}
+
+func (s *SQLiteStore) IndexModel(ctx context.Context) (*IndexModel, error) {
+	return s.model.get(func() (*IndexModel, error) {
+		var m IndexModel
+		err := s.db.QueryRowContext(ctx, `SELECT model, dimensions, normalized FROM rag_index_model`).Scan(&m.Model, &m.Dimensions, &m.Normalized)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &m, nil
+	})
+}
+
+func (s *SQLiteStore) SetIndexModel(ctx context.Context, m IndexModel) error {
+	_, err := s.db.ExecContext(ctx, `INSERT OR REPLACE INTO rag_index_model (id, model, dimensions, normalized) VALUES (1, ?, ?, ?)`,
+		m.Model, m.Dimensions, m.Normalized)
+	if err == nil {
+		s.model.set(m)
+	}
+	return err
+}
+
+func (s *SQLiteStore) NamespaceProfile(ctx context.Context, name string) (*NamespaceProfile, error) {
+	if err := sqliteNamespaceExists(ctx, s.db, name); err != nil {
+		return nil, err
+	}
+	var data string
+	err := s.db.QueryRowContext(ctx, `SELECT profile FROM rag_namespace_profiles WHERE name = ?`, name).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var profile NamespaceProfile
+	if err := json.Unmarshal([]byte(data), &profile); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+func (s *SQLiteStore) SetNamespaceProfile(ctx context.Context, name string, profile *NamespaceProfile) error {
+	return sqliteTx(ctx, s.db, func(tx *sql.Tx) error {
+		if err := sqliteNamespaceExists(ctx, tx, name); err != nil {
+			return err
+		}
+		if profile == nil {
+			_, err := tx.ExecContext(ctx, `DELETE FROM rag_namespace_profiles WHERE name = ?`, name)
+			return err
+		}
+		data, err := json.Marshal(profile)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT OR REPLACE INTO rag_namespace_profiles (name, profile) VALUES (?, ?)`, name, string(data))
+		return err
+	})
+}
+
+// sqliteNamespaceExists returns ErrNamespaceNotFound unless ns is
+// DefaultNamespace or was created.
+func sqliteNamespaceExists(ctx context.Context, q interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}, ns string) error {
+	if ns == DefaultNamespace {
+		return nil
+	}
+	var found int
+	err := q.QueryRowContext(ctx, `SELECT count(*) FROM rag_namespaces WHERE name = ?`, ns).Scan(&found)
+	if err != nil {
+		return err
+	}
+	if found == 0 {
+		return fmt.Errorf("%w: %s", ErrNamespaceNotFound, ns)
+	}
+	return nil
+}
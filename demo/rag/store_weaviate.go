@@ -0,0 +1,743 @@
+package rag
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// weaviateBatchSize is how many objects are imported per batch request.
+	weaviateBatchSize = 100
+	// weaviatePageSize is how many objects are fetched per GraphQL query
+	// when listing chunks.
+	weaviatePageSize = 500
+)
+
+// weaviateClassPattern matches valid Weaviate class names.
+var weaviateClassPattern = regexp.MustCompile(`^[A-Z][_0-9A-Za-z]*$`)
+
+// WeaviateStore is a VectorStore backed by a Weaviate class, accessed over
+// Weaviate's REST and GraphQL APIs. The class is named after the collection
+// with its first letter upper-cased, as Weaviate requires, and is created
+// with multi-tenancy enabled: every namespace is a tenant, so namespaces
+// are stored in separate shards.
+//
+// Chunk metadata is stored as JSON and, for filtering, every metadata key
+// is also stored in a property of its own, added to the class when a key
+// is first seen: meta_<key> holds the value as text, and metanum_<key> the
+// value of numeric metadata. Keys are encoded to fit Weaviate's property
+// names, see weaviateProperty. As with Qdrant, range conditions on strings
+// are not supported.
+//
+// The store's HybridSearch runs Weaviate's own hybrid search, which the
+// hybrid retriever uses instead of the in-process keyword index. The
+// IndexModel is recorded, as JSON, in the description of the class.
+type WeaviateStore struct {
+	baseURL string
+	apiKey  string
+	class   string
+	metric  Metric
+
+	mu         sync.Mutex
+	properties map[string]bool // properties of the class
+	model      modelCache
+}
+
+// weaviateDescription is the description of a class recording the model of
+// its embeddings.
+type weaviateDescription struct {
+	IndexModel *IndexModel `json:"rag_index_model"`
+}
+
+// weaviateProperties are the properties every class is created with.
+var weaviateProperties = []map[string]any{
+	{"name": "chunk_id", "dataType": []string{"text"}, "tokenization": "field", "indexSearchable": false},
+	{"name": "doc_id", "dataType": []string{"text"}, "tokenization": "field", "indexSearchable": false},
+	{"name": "idx", "dataType": []string{"int"}},
+	{"name": "text", "dataType": []string{"text"}, "tokenization": "word", "indexFilterable": false},
+	{"name": "metadata", "dataType": []string{"text"}, "indexFilterable": false, "indexSearchable": false},
+	{"name": "hash", "dataType": []string{"text"}, "tokenization": "field", "indexSearchable": false},
+}
+
+// weaviateChunkFields are the properties queried to rebuild a chunk.
+const weaviateChunkFields = "chunk_id doc_id idx text metadata hash"
+
+// NewWeaviateStore creates a WeaviateStore for collection on the server at
+// baseURL, e.g. http://localhost:8080, creating the class and the tenant of
+// DefaultNamespace if they do not exist.
+func NewWeaviateStore(ctx context.Context, baseURL, apiKey, collection string, metric Metric) (*WeaviateStore, error) {
+	class := collection
+	if class != "" {
+		class = strings.ToUpper(class[:1]) + class[1:]
+	}
+	if !weaviateClassPattern.MatchString(class) {
+		return nil, fmt.Errorf("weaviate: invalid class name %q", class)
+	}
+	s := &WeaviateStore{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		apiKey:     apiKey,
+		class:      class,
+		metric:     metric,
+		properties: make(map[string]bool),
+	}
+	if err := s.ensure(ctx); err != nil {
+		return nil, err
+	}
+	tenants, err := s.tenants(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(tenants, DefaultNamespace) {
+		if err := s.addTenant(ctx, DefaultNamespace); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// ensure creates the class if needed and loads its properties.
+func (s *WeaviateStore) ensure(ctx context.Context) error {
+	var class struct {
+		Properties []struct {
+			Name string `json:"name"`
+		} `json:"properties"`
+	}
+	found, err := s.do(ctx, http.MethodGet, "/v1/schema/"+s.class, nil, &class)
+	if err != nil {
+		return fmt.Errorf("weaviate: reading class %s: %w", s.class, err)
+	}
+	if !found {
+		distance := "cosine"
+		if s.metric == MetricInnerProduct {
+			distance = "dot"
+		}
+		_, err := s.do(ctx, http.MethodPost, "/v1/schema", map[string]any{
+			"class":               s.class,
+			"vectorizer":          "none",
+			"vectorIndexConfig":   map[string]any{"distance": distance},
+			"invertedIndexConfig": map[string]any{"indexNullState": true},
+			"multiTenancyConfig":  map[string]any{"enabled": true},
+			"properties":          weaviateProperties,
+		}, nil)
+		if err != nil {
+			return fmt.Errorf("weaviate: creating class %s: %w", s.class, err)
+		}
+		class.Properties = nil
+		for _, p := range weaviateProperties {
+			class.Properties = append(class.Properties, struct {
+				Name string `json:"name"`
+			}{p["name"].(string)})
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range class.Properties {
+		s.properties[p.Name] = true
+	}
+	return nil
+}
+
+func (s *WeaviateStore) IndexModel(ctx context.Context) (*IndexModel, error) {
+	return s.model.get(func() (*IndexModel, error) {
+		var class struct {
+			Description string `json:"description"`
+		}
+		if _, err := s.do(ctx, http.MethodGet, "/v1/schema/"+s.class, nil, &class); err != nil {
+			return nil, fmt.Errorf("weaviate: reading class %s: %w", s.class, err)
+		}
+		// Descriptions not written by the store record no model
+		var description weaviateDescription
+		if json.Unmarshal([]byte(class.Description), &description) != nil {
+			return nil, nil
+		}
+		return description.IndexModel, nil
+	})
+}
+
+func (s *WeaviateStore) SetIndexModel(ctx context.Context, m IndexModel) error {
+	var class map[string]any
+	found, err := s.do(ctx, http.MethodGet, "/v1/schema/"+s.class, nil, &class)
+	if err != nil {
+		return fmt.Errorf("weaviate: reading class %s: %w", s.class, err)
+	}
+	if !found {
+		return fmt.Errorf("weaviate: class %s does not exist", s.class)
+	}
+	description, err := json.Marshal(weaviateDescription{IndexModel: &m})
+	if err != nil {
+		return err
+	}
+	class["description"] = string(description)
+	if _, err := s.do(ctx, http.MethodPut, "/v1/schema/"+s.class, class, nil); err != nil {
+		return fmt.Errorf("weaviate: recording the index model: %w", err)
+	}
+	s.model.set(m)
+	return nil
+}
+
+// weaviateProperty returns the name of the property holding metadata key
+// with the given prefix. Letters and digits are kept and every other byte,
+// including "_", is written as "_" and two hex digits, so that different
+// keys never share a property.
+func weaviateProperty(prefix, key string) string {
+	var b strings.Builder
+	b.WriteString(prefix)
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "_%02x", c)
+	}
+	return b.String()
+}
+
+// addProperties adds the metadata properties chunks need to the class.
+func (s *WeaviateStore) addProperties(ctx context.Context, chunks []Chunk) error {
+	needed := make(map[string]string) // property -> data type
+	for _, c := range chunks {
+		for k, v := range c.Metadata {
+			needed[weaviateProperty("meta_", k)] = "text"
+			if _, ok := parseNumber(v); ok {
+				needed[weaviateProperty("metanum_", k)] = "number"
+			}
+		}
+	}
+	for name, dataType := range needed {
+		s.mu.Lock()
+		known := s.properties[name]
+		s.mu.Unlock()
+		if known {
+			continue
+		}
+		property := map[string]any{"name": name, "dataType": []string{dataType}, "indexSearchable": false}
+		if dataType == "text" {
+			property["tokenization"] = "field"
+		} else {
+			delete(property, "indexSearchable")
+		}
+		_, err := s.do(ctx, http.MethodPost, "/v1/schema/"+s.class+"/properties", property, nil)
+		if err != nil && !strings.Contains(err.Error(), "already exists") {
+			return fmt.Errorf("weaviate: adding property %s: %w", name, err)
+		}
+		s.mu.Lock()
+		s.properties[name] = true
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+func (s *WeaviateStore) Upsert(ctx context.Context, chunks []Chunk) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	ns := NamespaceFrom(ctx)
+	if err := s.namespaceExists(ctx, ns); err != nil {
+		return err
+	}
+	if err := s.addProperties(ctx, chunks); err != nil {
+		return err
+	}
+	for start := 0; start < len(chunks); start += weaviateBatchSize {
+		batch := chunks[start:min(start+weaviateBatchSize, len(chunks))]
+		objects := make([]map[string]any, len(batch))
+		for i, c := range batch {
+			metadata, err := json.Marshal(c.Metadata)
+			if err != nil {
+				return err
+			}
+			properties := map[string]any{
+				"chunk_id": c.ID,
+				"doc_id":   c.DocID,
+				"idx":      c.Index,
+				"text":     c.Text,
+				"metadata": string(metadata),
+				"hash":     c.Hash,
+			}
+			for k, v := range c.Metadata {
+				properties[weaviateProperty("meta_", k)] = v
+				if f, ok := parseNumber(v); ok {
+					properties[weaviateProperty("metanum_", k)] = f
+				}
+			}
+			objects[i] = map[string]any{
+				"class":      s.class,
+				"id":         nameUUID(c.ID),
+				"tenant":     ns,
+				"properties": properties,
+				"vector":     c.Embedding,
+			}
+		}
+		var results []struct {
+			ID     string `json:"id"`
+			Result struct {
+				Errors *struct {
+					Error []struct {
+						Message string `json:"message"`
+					} `json:"error"`
+				} `json:"errors"`
+			} `json:"result"`
+		}
+		if _, err := s.do(ctx, http.MethodPost, "/v1/batch/objects", map[string]any{"objects": objects}, &results); err != nil {
+			return err
+		}
+		for i, r := range results {
+			if r.Result.Errors != nil && len(r.Result.Errors.Error) > 0 {
+				return fmt.Errorf("weaviate: storing chunk %s: %s", batch[i].ID, r.Result.Errors.Error[0].Message)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *WeaviateStore) Search(ctx context.Context, query []float32, k int, filter Filter) ([]SearchResult, error) {
+	return s.search(ctx, fmt.Sprintf("nearVector: %s", graphQLValue(map[string]any{"vector": query})), k, filter)
+}
+
+// HybridSearch runs Weaviate's hybrid search, which fuses a BM25 search of
+// the chunk texts for query with the vector search for vector. weight is
+// the share of the vector search, from 0 (keywords only) to 1 (vectors
+// only). The scores are those of the fusion, not similarities.
+func (s *WeaviateStore) HybridSearch(ctx context.Context, query string, vector []float32, k int, weight float64, filter Filter) ([]SearchResult, error) {
+	return s.search(ctx, fmt.Sprintf("hybrid: %s", graphQLValue(map[string]any{
+		"query":      query,
+		"vector":     vector,
+		"alpha":      weight,
+		"properties": []any{"text"},
+	})), k, filter)
+}
+
+// search runs a GraphQL Get with the given search argument, returning the
+// best k chunks of the namespace of ctx that match filter.
+func (s *WeaviateStore) search(ctx context.Context, searchArg string, k int, filter Filter) ([]SearchResult, error) {
+	ns := NamespaceFrom(ctx)
+	if err := s.namespaceExists(ctx, ns); err != nil {
+		return nil, err
+	}
+	where, ok, err := s.where(ctx, filter)
+	if err != nil || !ok {
+		return nil, err
+	}
+	args := []string{"tenant: " + graphQLValue(ns), "limit: " + strconv.Itoa(k), searchArg}
+	if where != nil {
+		args = append(args, "where: "+graphQLValue(where))
+	}
+	var objects []weaviateObject
+	err = s.graphQL(ctx, fmt.Sprintf(`{ Get { %s(%s) { %s _additional { distance score } } } }`,
+		s.class, strings.Join(args, ", "), weaviateChunkFields), "Get", &objects)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]SearchResult, len(objects))
+	for i, o := range objects {
+		chunk, err := o.chunk()
+		if err != nil {
+			return nil, err
+		}
+		results[i] = SearchResult{Chunk: chunk, Score: s.score(o)}
+	}
+	return results, nil
+}
+
+// weaviateObject is an object returned by a GraphQL Get.
+type weaviateObject struct {
+	ChunkID    string `json:"chunk_id"`
+	DocID      string `json:"doc_id"`
+	Index      int    `json:"idx"`
+	Text       string `json:"text"`
+	Metadata   string `json:"metadata"`
+	Hash       string `json:"hash"`
+	Additional struct {
+		ID       string   `json:"id"`
+		Distance *float32 `json:"distance"`
+		Score    string   `json:"score"`
+	} `json:"_additional"`
+}
+
+func (o weaviateObject) chunk() (Chunk, error) {
+	c := Chunk{ID: o.ChunkID, DocID: o.DocID, Index: o.Index, Text: o.Text, Hash: o.Hash}
+	if o.Metadata != "" {
+		if err := json.Unmarshal([]byte(o.Metadata), &c.Metadata); err != nil {
+			return c, fmt.Errorf("weaviate: metadata of chunk %s: %w", o.ChunkID, err)
+		}
+	}
+	return c, nil
+}
+
+// score converts the distance of a vector search, or the score of a hybrid
+// search, to a score where higher is better.
+func (s *WeaviateStore) score(o weaviateObject) float32 {
+	if d := o.Additional.Distance; d != nil {
+		// Weaviate's dot distance is the negative inner product
+		if s.metric == MetricInnerProduct {
+			return -*d
+		}
+		return 1 - *d
+	}
+	score, _ := strconv.ParseFloat(o.Additional.Score, 32)
+	return float32(score)
+}
+
+// where translates filter into a Weaviate where filter, or nil for the
+// empty filter. It reports false if filter refers to metadata that no chunk
+// has, in which case nothing can match.
+func (s *WeaviateStore) where(ctx context.Context, filter Filter) (map[string]any, bool, error) {
+	var operands []any
+	for _, c := range filter {
+		var property string
+		cond := map[string]any{}
+		if c.numeric() {
+			property = weaviateProperty("metanum_", c.Key)
+			v, _ := parseNumber(c.Value)
+			cond["valueNumber"] = v
+		} else {
+			if c.Op != OpEq && c.Op != OpNe {
+				return nil, false, fmt.Errorf("weaviate: filter %s: range comparisons of non-numeric values are %w", c.Key+string(c.Op)+c.Value, ErrNotSupported)
+			}
+			property = weaviateProperty("meta_", c.Key)
+			cond["valueText"] = c.Value
+		}
+		known, err := s.hasProperty(ctx, property)
+		if err != nil || !known {
+			return nil, false, err
+		}
+		cond["path"] = []any{property}
+		cond["operator"] = map[Op]string{
+			OpEq: "Equal", OpNe: "NotEqual", OpLt: "LessThan", OpLe: "LessThanEqual", OpGt: "GreaterThan", OpGe: "GreaterThanEqual",
+		}[c.Op]
+		if c.Op == OpNe {
+			// Chunks without the key never match, as in Filter.Match
+			operands = append(operands, map[string]any{"path": []any{property}, "operator": "IsNull", "valueBoolean": false})
+		}
+		operands = append(operands, cond)
+	}
+	switch len(operands) {
+	case 0:
+		return nil, true, nil
+	case 1:
+		return operands[0].(map[string]any), true, nil
+	}
+	return map[string]any{"operator": "And", "operands": operands}, true, nil
+}
+
+// hasProperty reports whether the class has a property, reloading the
+// schema in case another process added it.
+func (s *WeaviateStore) hasProperty(ctx context.Context, name string) (bool, error) {
+	s.mu.Lock()
+	known := s.properties[name]
+	s.mu.Unlock()
+	if known {
+		return true, nil
+	}
+	if err := s.ensure(ctx); err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.properties[name], nil
+}
+
+func (s *WeaviateStore) Delete(ctx context.Context, docID string) error {
+	return s.deleteWhere(ctx, map[string]any{"path": []any{"doc_id"}, "operator": "Equal", "valueText": docID})
+}
+
+func (s *WeaviateStore) ChunkHashes(ctx context.Context, docID string) (map[string]string, error) {
+	ns := NamespaceFrom(ctx)
+	if err := s.namespaceExists(ctx, ns); err != nil {
+		return nil, err
+	}
+	hashes := make(map[string]string)
+	where := graphQLValue(map[string]any{"path": []any{"doc_id"}, "operator": "Equal", "valueText": docID})
+	for offset := 0; ; offset += weaviatePageSize {
+		var objects []weaviateObject
+		err := s.graphQL(ctx, fmt.Sprintf(`{ Get { %s(tenant: %s, where: %s, limit: %d, offset: %d) { chunk_id hash } } }`,
+			s.class, graphQLValue(ns), where, weaviatePageSize, offset), "Get", &objects)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range objects {
+			hashes[o.ChunkID] = o.Hash
+		}
+		if len(objects) < weaviatePageSize {
+			return hashes, nil
+		}
+	}
+}
+
+func (s *WeaviateStore) DeleteChunks(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	values := make([]any, len(ids))
+	for i, id := range ids {
+		values[i] = id
+	}
+	return s.deleteWhere(ctx, map[string]any{"path": []any{"chunk_id"}, "operator": "ContainsAny", "valueTextArray": values})
+}
+
+// deleteWhere deletes the objects of the namespace of ctx matching where.
+// Deleting from a namespace that does not exist does nothing.
+func (s *WeaviateStore) deleteWhere(ctx context.Context, where map[string]any) error {
+	ns := NamespaceFrom(ctx)
+	if err := s.namespaceExists(ctx, ns); errors.Is(err, ErrNamespaceNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	_, err := s.do(ctx, http.MethodDelete, "/v1/batch/objects?tenant="+url.QueryEscape(ns), map[string]any{
+		"match": map[string]any{"class": s.class, "where": where},
+	}, nil)
+	return err
+}
+
+func (s *WeaviateStore) Documents(ctx context.Context) ([]DocumentInfo, error) {
+	ns := NamespaceFrom(ctx)
+	if err := s.namespaceExists(ctx, ns); err != nil {
+		return nil, err
+	}
+	// Page through all objects with a cursor, which cannot be combined with
+	// a where filter
+	counts := make(map[string]int)
+	after := ""
+	for {
+		args := fmt.Sprintf("tenant: %s, limit: %d", graphQLValue(ns), weaviatePageSize)
+		if after != "" {
+			args += ", after: " + graphQLValue(after)
+		}
+		var objects []weaviateObject
+		err := s.graphQL(ctx, fmt.Sprintf(`{ Get { %s(%s) { doc_id _additional { id } } } }`, s.class, args), "Get", &objects)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range objects {
+			counts[o.DocID]++
+		}
+		if len(objects) < weaviatePageSize {
+			break
+		}
+		after = objects[len(objects)-1].Additional.ID
+	}
+	docs := make([]DocumentInfo, 0, len(counts))
+	for id, n := range counts {
+		docs = append(docs, DocumentInfo{ID: id, Chunks: n})
+	}
+	slices.SortFunc(docs, func(a, b DocumentInfo) int { return cmp.Compare(a.ID, b.ID) })
+	return docs, nil
+}
+
+func (s *WeaviateStore) CreateNamespace(ctx context.Context, name string) error {
+	if err := checkNamespaceChange(name); err != nil {
+		return err
+	}
+	tenants, err := s.tenants(ctx)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(tenants, name) {
+		return fmt.Errorf("%w: %s", ErrNamespaceExists, name)
+	}
+	return s.addTenant(ctx, name)
+}
+
+func (s *WeaviateStore) DeleteNamespace(ctx context.Context, name string) error {
+	if err := checkNamespaceChange(name); err != nil {
+		return err
+	}
+	if err := s.namespaceExists(ctx, name); err != nil {
+		return err
+	}
+	_, err := s.do(ctx, http.MethodDelete, "/v1/schema/"+s.class+"/tenants", []string{name}, nil)
+	return err
+}
+
+func (s *WeaviateStore) Namespaces(ctx context.Context) ([]NamespaceInfo, error) {
+	tenants, err := s.tenants(ctx)
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(tenants)
+	namespaces := make([]NamespaceInfo, len(tenants))
+	for i, name := range tenants {
+		var res []struct {
+			Meta struct {
+				Count int `json:"count"`
+			} `json:"meta"`
+		}
+		err := s.graphQL(ctx, fmt.Sprintf(`{ Aggregate { %s(tenant: %s) { meta { count } } } }`, s.class, graphQLValue(name)), "Aggregate", &res)
+		if err != nil {
+			return nil, err
+		}
+		namespaces[i].Name = name
+		if len(res) > 0 {
+			namespaces[i].Chunks = res[0].Meta.Count
+		}
+	}
+	return namespaces, nil
+}
+
+// tenants lists the tenants of the class.
+func (s *WeaviateStore) tenants(ctx context.Context) ([]string, error) {
+	var tenants []struct {
+		Name string `json:"name"`
+	}
+	if _, err := s.do(ctx, http.MethodGet, "/v1/schema/"+s.class+"/tenants", nil, &tenants); err != nil {
+		return nil, fmt.Errorf("weaviate: listing tenants: %w", err)
+	}
+	names := make([]string, len(tenants))
+	for i, t := range tenants {
+		names[i] = t.Name
+	}
+	return names, nil
+}
+
+func (s *WeaviateStore) addTenant(ctx context.Context, name string) error {
+	_, err := s.do(ctx, http.MethodPost, "/v1/schema/"+s.class+"/tenants", []map[string]string{{"name": name}}, nil)
+	if err != nil {
+		return fmt.Errorf("weaviate: creating tenant %s: %w", name, err)
+	}
+	return nil
+}
+
+// namespaceExists returns ErrNamespaceNotFound unless ns is a tenant of the
+// class. DefaultNamespace is created with the store.
+func (s *WeaviateStore) namespaceExists(ctx context.Context, ns string) error {
+	if ns == DefaultNamespace {
+		return nil
+	}
+	tenants, err := s.tenants(ctx)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(tenants, ns) {
+		return fmt.Errorf("%w: %s", ErrNamespaceNotFound, ns)
+	}
+	return nil
+}
+
+// graphQL runs a GraphQL query and decodes the objects returned for the
+// class under the given operation, e.g. "Get", into out.
+func (s *WeaviateStore) graphQL(ctx context.Context, query, operation string, out any) error {
+	var res struct {
+		Data   map[string]map[string]json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if _, err := s.do(ctx, http.MethodPost, "/v1/graphql", map[string]string{"query": query}, &res); err != nil {
+		return err
+	}
+	if len(res.Errors) > 0 {
+		return fmt.Errorf("weaviate: %s", res.Errors[0].Message)
+	}
+	data := res.Data[operation][s.class]
+	if len(data) == 0 || string(data) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("weaviate: decoding %s response: %w", operation, err)
+	}
+	return nil
+}
+
+// graphQLValue formats v as a GraphQL input value. Maps become objects with
+// their keys as field names, except that "operator" values are written as
+// enum values; everything else is written as JSON, which GraphQL accepts
+// for strings, numbers, booleans and lists.
+func graphQLValue(v any) string {
+	switch v := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		fields := make([]string, len(keys))
+		for i, k := range keys {
+			value := graphQLValue(v[k])
+			if op, ok := v[k].(string); ok && k == "operator" {
+				value = op
+			}
+			fields[i] = k + ": " + value
+		}
+		return "{" + strings.Join(fields, ", ") + "}"
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = graphQLValue(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// CheckHealth reads the schema of the class.
+func (s *WeaviateStore) CheckHealth(ctx context.Context) error {
+	found, err := s.do(ctx, http.MethodGet, "/v1/schema/"+s.class, nil, nil)
+	if err == nil && !found {
+		return fmt.Errorf("weaviate: class %s not found", s.class)
+	}
+	return err
+}
+
+// do sends a request to the Weaviate API and decodes the response into out,
+// if out is not nil. It reports false, without an error, if the resource
+// was not found.
+func (s *WeaviateStore) do(ctx context.Context, method, path string, body, out any) (bool, error) {
+	var reader io.Reader = http.NoBody
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return false, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.baseURL+path, reader)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && method == http.MethodGet {
+		return false, nil
+	}
+	if resp.StatusCode >= 300 {
+		var res struct {
+			Error []struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		data, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(data, &res) == nil && len(res.Error) > 0 {
+			return false, fmt.Errorf("weaviate: %s %s: %s: %s", method, path, resp.Status, res.Error[0].Message)
+		}
+		return false, fmt.Errorf("weaviate: %s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return true, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil && err != io.EOF {
+		return true, fmt.Errorf("weaviate: decoding %s response: %w", path, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,118 @@
+package rag
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// AnswerFormat selects how a query's answer is generated.
+type AnswerFormat string
+
+const (
+	// FormatText answers in free text citing sources as [n].
+	FormatText AnswerFormat = "text"
+	// FormatJSON has the LLM reply with a JSON object holding the answer,
+	// its confidence and the numbers of the cited sources, so that neither
+	// depends on parsing free text.
+	FormatJSON AnswerFormat = "json"
+)
+
+func (f AnswerFormat) validate() error {
+	switch f {
+	case "", FormatText, FormatJSON:
+		return nil
+	}
+	return fmt.Errorf("unknown answer format %q", f)
+}
+
+// answerSchema is the JSON Schema of replies in FormatJSON, written within
+// the subset OpenAI accepts in strict mode.
+var answerSchema = json.RawMessage(`{
+	"type": "object",
+	"properties": {
+		"answer": {"type": "string", "description": "The answer to the question."},
+		"confidence": {"type": "number", "description": "How well the context supports the answer, from 0 (not at all) to 1 (fully)."},
+		"citations": {"type": "array", "items": {"type": "integer"}, "description": "Numbers of the context passages the answer uses."}
+	},
+	"required": ["answer", "confidence", "citations"],
+	"additionalProperties": false
+}`)
+
+const jsonAnswerInstructions = `Reply with a JSON object and nothing else. It must have the fields "answer", the answer as text; "confidence", a number from 0 to 1 saying how well the context supports the answer; and "citations", an array with the numbers of the passages the answer uses.`
+
+// structuredAnswer is a reply in FormatJSON.
+type structuredAnswer struct {
+	Answer     string  `json:"answer"`
+	Confidence float64 `json:"confidence"`
+	Citations  []int   `json:"citations"`
+}
+
+// generateJSON runs the Generate stage for a reply in FormatJSON. An LLM
+// that is not a StructuredLLM is only instructed to reply in JSON, and the
+// first JSON object in its reply is used.
+func (p *Pipeline) generateJSON(ctx context.Context, messages []Message, sources int) (*structuredAnswer, error) {
+	messages = withInstructions(messages, jsonAnswerInstructions)
+	generate := p.generate
+	if llm, ok := p.LLM.(StructuredLLM); ok {
+		generate = func(ctx context.Context, messages []Message, _ func(string) error) (string, error) {
+			return llm.GenerateJSON(ctx, messages, "answer", answerSchema)
+		}
+	}
+	text, err := p.generateStage(generate)(ctx, messages, nil)
+	if err != nil {
+		return nil, err
+	}
+	return parseStructuredAnswer(text, sources)
+}
+
+// errReplyNotJSON is returned by decodeJSONReply for a reply holding no
+// JSON object.
+var errReplyNotJSON = errors.New("reply is not JSON")
+
+// decodeJSONReply decodes the JSON object in a reply of an LLM into v.
+func decodeJSONReply(reply string, v any) error {
+	// Models sometimes wrap the JSON in prose or a code fence
+	start, end := strings.Index(reply, "{"), strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return fmt.Errorf("%w: %q", errReplyNotJSON, reply)
+	}
+	if err := json.Unmarshal([]byte(reply[start:end+1]), v); err != nil {
+		return fmt.Errorf("%w: %w", errReplyNotJSON, err)
+	}
+	return nil
+}
+
+// parseStructuredAnswer decodes a reply in FormatJSON, tolerating Markdown
+// code fences or prose around the object. The confidence is clamped to
+// [0, 1] and citations of passages that were not given are dropped.
+func parseStructuredAnswer(text string, sources int) (*structuredAnswer, error) {
+	var a structuredAnswer
+	if err := decodeJSONReply(text, &a); err != nil {
+		return nil, err
+	}
+	a.Confidence = min(max(a.Confidence, 0), 1)
+	seen := make(map[int]bool)
+	citations := []int{}
+	for _, n := range a.Citations {
+		if n >= 1 && n <= sources && !seen[n] {
+			seen[n] = true
+			citations = append(citations, n)
+		}
+	}
+	a.Citations = citations
+	return &a, nil
+}
+
+// withInstructions returns a copy of messages with text appended to the
+// system message, or prepended as one if there is none.
+func withInstructions(messages []Message, text string) []Message {
+	if len(messages) > 0 && messages[0].Role == RoleSystem {
+		messages = append([]Message(nil), messages...)
+		messages[0].Content += "\n\n" + text
+		return messages
+	}
+	return append([]Message{{Role: RoleSystem, Content: text}}, messages...)
+}
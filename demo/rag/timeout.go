@@ -0,0 +1,207 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Stages of answering a question that Timeouts bound.
+const (
+	StageRequest  = "request"
+	StageEmbed    = "embed"
+	StageRetrieve = "retrieve"
+	StageRerank   = "rerank"
+	StageGenerate = "generate"
+)
+
+// Timeouts bound how long answering a question may take: Request the whole
+// of it, and the others each run of a stage, from when it starts. Embed
+// bounds each embedding of the question or a query derived from it,
+// Retrieve each search, reranking included, and Generate each reply of the
+// LLM, such as the answer or one regenerated for grounding. A zero timeout
+// leaves a stage without a deadline of its own.
+//
+// A stage that only refines the answer is skipped when it runs out of
+// time: a Reranker's candidates are kept in their order of retrieval, and
+// a HybridRetriever ranks the keyword results alone if embedding the query
+// times out. The stages skipped are listed in Answer.TimedOut. Any other
+// stage running out of time fails the question with a *TimeoutError.
+type Timeouts struct {
+	Request  time.Duration
+	Embed    time.Duration
+	Retrieve time.Duration
+	Rerank   time.Duration
+	Generate time.Duration
+}
+
+// A TimeoutError reports that a stage of answering a question ran out of
+// the time its Timeouts gave it. It matches context.DeadlineExceeded.
+type TimeoutError struct {
+	Stage   string // one of the Stage constants
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s timed out after %v", e.Stage, e.Timeout)
+}
+
+func (e *TimeoutError) Is(target error) bool { return target == context.DeadlineExceeded }
+
+// withStageTimeout returns ctx with a deadline of timeout for stage, or
+// ctx itself if timeout is zero.
+func withStageTimeout(ctx context.Context, stage string, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeoutCause(ctx, timeout, &TimeoutError{Stage: stage, Timeout: timeout})
+}
+
+// timedOut returns the *TimeoutError of the stage whose deadline ended ctx
+// in place of err, the error ctx ended with, if such a deadline did.
+func timedOut(ctx context.Context, err error) error {
+	var timeout *TimeoutError
+	if err != nil && ctx.Err() != nil && errors.As(context.Cause(ctx), &timeout) {
+		return timeout
+	}
+	return err
+}
+
+// isTimeout reports whether err is the *TimeoutError of stage.
+func isTimeout(err error, stage string) bool {
+	var timeout *TimeoutError
+	return errors.As(err, &timeout) && timeout.Stage == stage
+}
+
+// A timeoutScope holds the Timeouts of a question being answered, and
+// records the stages skipped as they ran out of time.
+type timeoutScope struct {
+	Timeouts
+
+	mu      sync.Mutex
+	skipped []string
+}
+
+type timeoutScopeKey struct{}
+
+// timed returns ctx with the deadline of p.Timeouts.Request and a scope
+// recording the stages skipped as they timed out.
+func (p *Pipeline) timed(ctx context.Context) (context.Context, context.CancelFunc, *timeoutScope) {
+	scope := &timeoutScope{Timeouts: p.Timeouts}
+	ctx, cancel := withStageTimeout(ctx, StageRequest, p.Timeouts.Request)
+	return context.WithValue(ctx, timeoutScopeKey{}, scope), cancel, scope
+}
+
+// timeoutsFrom returns the Timeouts of the question answered in ctx.
+func timeoutsFrom(ctx context.Context) Timeouts {
+	if scope, ok := ctx.Value(timeoutScopeKey{}).(*timeoutScope); ok {
+		return scope.Timeouts
+	}
+	return Timeouts{}
+}
+
+// skipTimedOut records that stage was skipped for the question answered in
+// ctx as it ran out of time.
+func skipTimedOut(ctx context.Context, stage string) {
+	if scope, ok := ctx.Value(timeoutScopeKey{}).(*timeoutScope); ok {
+		scope.mu.Lock()
+		if !slices.Contains(scope.skipped, stage) {
+			scope.skipped = append(scope.skipped, stage)
+		}
+		scope.mu.Unlock()
+	}
+}
+
+// timedOutAny reports whether a stage was skipped for the question answered
+// in ctx.
+func timedOutAny(ctx context.Context) bool {
+	scope, ok := ctx.Value(timeoutScopeKey{}).(*timeoutScope)
+	return ok && len(scope.timedOutStages()) > 0
+}
+
+// timedOutStages returns the stages skipped so far.
+func (s *timeoutScope) timedOutStages() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.skipped)
+}
+
+// embedQuery embeds a query within the Embed timeout of the question
+// answered in ctx, or returns its embedding if it was embedded in the
+// queryVectors of ctx before.
+func embedQuery(ctx context.Context, embedder Embedder, query string) ([]float32, error) {
+	memo, _ := ctx.Value(queryVectorsKey{}).(*queryVectors)
+	key := queryVector{embedder, query}
+	if !reflect.TypeOf(embedder).Comparable() {
+		memo = nil
+	}
+	if vector := memo.get(key); vector != nil {
+		return vector, nil
+	}
+	defer benchTimed(ctx, StageEmbed, time.Now())
+	ctx = context.WithValue(ctx, queryEmbeddingKey{}, true)
+	ctx, cancel := withStageTimeout(ctx, StageEmbed, timeoutsFrom(ctx).Embed)
+	defer cancel()
+	vectors, err := embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, timedOut(ctx, err)
+	}
+	if len(vectors) != 1 {
+		return nil, fmt.Errorf("got %d embeddings", len(vectors))
+	}
+	memo.set(key, vectors[0])
+	return vectors[0], nil
+}
+
+type queryEmbeddingKey struct{}
+
+// isQueryEmbedding reports whether ctx embeds a query rather than the
+// chunks of documents, for embedders of models that embed them apart.
+func isQueryEmbedding(ctx context.Context) bool {
+	query, _ := ctx.Value(queryEmbeddingKey{}).(bool)
+	return query
+}
+
+type queryVectorsKey struct{}
+
+// queryVectors keeps the embeddings embedQuery makes of queries by each
+// Embedder, so that retrievers stacked on one another embed a query once.
+type queryVectors struct {
+	mu      sync.Mutex
+	vectors map[queryVector][]float32
+}
+
+type queryVector struct {
+	embedder Embedder
+	query    string
+}
+
+// withQueryVectors returns ctx with queryVectors, unless it has them.
+func withQueryVectors(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(queryVectorsKey{}).(*queryVectors); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, queryVectorsKey{}, &queryVectors{vectors: make(map[queryVector][]float32)})
+}
+
+func (q *queryVectors) get(key queryVector) []float32 {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.vectors[key]
+}
+
+func (q *queryVectors) set(key queryVector, vector []float32) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.vectors[key] = vector
+}
@@ -0,0 +1,66 @@
+package rag
+
+import (
+	"sync"
+	"unicode/utf8"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// A Tokenizer counts the tokens a model sees in a text. TruncateTokens
+// returns the longest prefix of text that has at most n tokens.
+type Tokenizer interface {
+	CountTokens(text string) int
+	TruncateTokens(text string, n int) string
+}
+
+// DefaultEncoding is the tiktoken encoding used for models tiktoken does
+// not know, such as those served by Ollama. Counts for other model families
+// are approximate but close enough to budget a prompt.
+const DefaultEncoding = tiktoken.MODEL_O200K_BASE
+
+// useOfflineBPE makes tiktoken read vocabularies compiled into the binary
+// instead of downloading them.
+var useOfflineBPE = sync.OnceFunc(func() {
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+})
+
+// TiktokenTokenizer counts tokens with the byte pair encodings of OpenAI
+// models.
+type TiktokenTokenizer struct {
+	enc *tiktoken.Tiktoken
+}
+
+// NewTiktokenTokenizer returns a TiktokenTokenizer using the encoding of
+// model, or DefaultEncoding if model is unknown.
+func NewTiktokenTokenizer(model string) (*TiktokenTokenizer, error) {
+	useOfflineBPE()
+	enc, err := tiktoken.EncodingForModel(model)
+	if err != nil {
+		if enc, err = tiktoken.GetEncoding(DefaultEncoding); err != nil {
+			return nil, err
+		}
+	}
+	return &TiktokenTokenizer{enc: enc}, nil
+}
+
+func (t *TiktokenTokenizer) CountTokens(text string) int {
+	return len(t.enc.EncodeOrdinary(text))
+}
+
+func (t *TiktokenTokenizer) TruncateTokens(text string, n int) string {
+	tokens := t.enc.EncodeOrdinary(text)
+	if len(tokens) <= n {
+		return text
+	}
+	if n <= 0 {
+		return ""
+	}
+	prefix := t.enc.Decode(tokens[:n])
+	// A token can end inside a multi-byte character
+	for !utf8.ValidString(prefix) {
+		prefix = prefix[:len(prefix)-1]
+	}
+	return prefix
+}
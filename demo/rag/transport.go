@@ -0,0 +1,200 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go/option"
+)
+
+const (
+	// DefaultHTTPRetries is how many times provider requests are retried.
+	DefaultHTTPRetries = 3
+	// retryBackoff is the delay before the first retry, doubled for every
+	// further one up to maxRetryBackoff.
+	retryBackoff    = 500 * time.Millisecond
+	maxRetryBackoff = 30 * time.Second
+	// maxRetryAfter is the longest Retry-After a request waits for; longer
+	// ones are passed on as failures.
+	maxRetryAfter = 2 * time.Minute
+)
+
+// RetryTransport is the http.RoundTripper of the clients calling embedding,
+// chat and rerank APIs. It spaces requests to at most RateLimit per second
+// and retries requests that were rate limited (429), timed out (408) or hit
+// a server error (500, 502, 503, 504), as well as ones that failed to get a
+// response, up to Retries times. Retries wait for the response's
+// Retry-After header or else back off exponentially with jitter. While a
+// request waits for its Retry-After, other requests through the transport
+// wait too, since the provider would reject them as well.
+//
+// If Shared is set, the RateLimit also applies to all requests to the same
+// host through transports sharing its Redis server, such as those of other
+// replicas of a server.
+//
+// Requests with a body are only retried if they have GetBody, as requests
+// created with http.NewRequest from a bytes.Reader do.
+type RetryTransport struct {
+	Base      http.RoundTripper // http.DefaultTransport if nil
+	Retries   int
+	RateLimit float64 // requests per second; unlimited if zero
+	Shared    *RedisRateLimiter
+
+	mu   sync.Mutex
+	next time.Time // earliest time the next request may be sent
+}
+
+// newProviderClient returns an HTTP client for provider APIs using a
+// RetryTransport configured by cfg.
+func newProviderClient(cfg Config) *http.Client {
+	t := &RetryTransport{Retries: cfg.HTTPRetries, RateLimit: cfg.RateLimit}
+	if cfg.RateLimitStore == "redis" {
+		// Config.finish has checked the URL
+		client, _ := sharedRedisClient(cfg.RedisURL)
+		t.Shared = &RedisRateLimiter{Client: client}
+	}
+	return &http.Client{Transport: t}
+}
+
+// openAIClientOptions sends OpenAI requests through newProviderClient,
+// turning off the SDK's own retries in favour of its RetryTransport.
+func openAIClientOptions(cfg Config) []option.RequestOption {
+	return []option.RequestOption{option.WithHTTPClient(newProviderClient(cfg)), option.WithMaxRetries(0)}
+}
+
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		if err := t.wait(ctx); err != nil {
+			return nil, err
+		}
+		if t.Shared != nil && t.RateLimit > 0 {
+			if err := t.Shared.waitShared(ctx, req.URL.Host, t.RateLimit); err != nil {
+				return nil, err
+			}
+		}
+		attemptReq := req
+		if attempt > 0 && req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq = req.Clone(ctx)
+			attemptReq.Body = body
+		}
+		resp, err := base.RoundTrip(attemptReq)
+		delay, retry := t.retryDelay(attempt, resp, err)
+		if !retry || attempt >= t.Retries || (req.Body != nil && req.GetBody == nil) || ctx.Err() != nil {
+			return resp, err
+		}
+		if resp != nil {
+			// Reading the body lets the connection be reused
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// retryDelay reports whether a request should be retried after the given
+// attempt, counting from 0, and how long to wait before doing so.
+func (t *RetryTransport) retryDelay(attempt int, resp *http.Response, err error) (time.Duration, bool) {
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return 0, false
+		}
+		return backoff(attempt), true
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusRequestTimeout, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+	default:
+		return 0, false
+	}
+	after, ok := retryAfter(resp.Header.Get("Retry-After"))
+	if !ok {
+		return backoff(attempt), true
+	}
+	if after > maxRetryAfter {
+		return 0, false
+	}
+	t.pause(after)
+	return after, true
+}
+
+// wait blocks until the rate limit allows another request, reserving the
+// slot it waited for.
+func (t *RetryTransport) wait(ctx context.Context) error {
+	t.mu.Lock()
+	now := time.Now()
+	at := now
+	if t.next.After(now) {
+		at = t.next
+	}
+	if t.RateLimit > 0 {
+		t.next = at.Add(time.Duration(float64(time.Second) / t.RateLimit))
+	}
+	t.mu.Unlock()
+	if !at.After(now) {
+		return nil
+	}
+	timer := time.NewTimer(at.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// pause holds back all requests for d.
+func (t *RetryTransport) pause(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if until := time.Now().Add(d); until.After(t.next) {
+		t.next = until
+	}
+}
+
+// backoff returns the delay before retrying after the given attempt: a
+// random duration between half and all of the exponential backoff, so that
+// clients failing together do not retry together.
+func backoff(attempt int) time.Duration {
+	d := maxRetryBackoff
+	if attempt < 16 {
+		d = min(retryBackoff<<attempt, maxRetryBackoff)
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// retryAfter parses a Retry-After header, given in seconds or as an HTTP
+// date.
+func retryAfter(header string) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		return max(time.Until(at), 0), true
+	}
+	return 0, false
+}
@@ -0,0 +1,21 @@
+package rag
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// uiFiles holds the admin UI, a single page using the HTTP API.
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// uiHandler serves the admin UI under /ui/.
+func uiHandler() http.Handler {
+	files, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix("/ui/", http.FileServerFS(files))
+}
@@ -0,0 +1,174 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+)
+
+// Usage counts the tokens of one LLM or embedding request. EmbeddingTokens
+// is only set for embedding requests, and the other counts only for LLM
+// requests.
+type Usage struct {
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+	EmbeddingTokens  int
+}
+
+type usageKey struct{}
+
+// WithUsageFunc returns a context that makes LLMs and embedders pass the
+// token usage of each request made with it to fn, as far as their provider
+// reports it. Functions registered on parent contexts are called as well.
+func WithUsageFunc(ctx context.Context, fn func(Usage)) context.Context {
+	if parent, ok := ctx.Value(usageKey{}).(func(Usage)); ok {
+		inner := fn
+		fn = func(u Usage) {
+			inner(u)
+			parent(u)
+		}
+	}
+	return context.WithValue(ctx, usageKey{}, fn)
+}
+
+// reportUsage passes u to the functions registered on ctx.
+func reportUsage(ctx context.Context, u Usage) {
+	if fn, ok := ctx.Value(usageKey{}).(func(Usage)); ok {
+		fn(u)
+	}
+}
+
+// Price is what a model charges, in US dollars per million tokens. Input
+// applies to prompt and embedding tokens, Output to completion tokens.
+type Price struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// Pricing maps model names to their prices. Models it does not list are
+// free, as models run locally are.
+type Pricing map[string]Price
+
+// DefaultPricing holds the list prices of the default OpenAI models at the
+// time of writing; LoadPricing adds to and overrides them.
+var DefaultPricing = Pricing{
+	"gpt-4o":                 {Input: 2.50, Output: 10.00},
+	"gpt-4o-mini":            {Input: 0.15, Output: 0.60},
+	"text-embedding-3-small": {Input: 0.02},
+	"text-embedding-3-large": {Input: 0.13},
+}
+
+// LoadPricing reads a JSON object mapping model names to prices, such as
+// {"gpt-4o": {"input": 2.5, "output": 10}}, and returns DefaultPricing
+// updated with them.
+func LoadPricing(path string) (Pricing, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var prices Pricing
+	if err := json.Unmarshal(data, &prices); err != nil {
+		return nil, fmt.Errorf("parsing pricing %s: %w", path, err)
+	}
+	pricing := maps.Clone(DefaultPricing)
+	maps.Copy(pricing, prices)
+	return pricing, nil
+}
+
+// cost returns the price of u.
+func (p Pricing) cost(u Usage) float64 {
+	price := p[u.Model]
+	return (float64(u.PromptTokens+u.EmbeddingTokens)*price.Input + float64(u.CompletionTokens)*price.Output) / 1e6
+}
+
+// ModelUsage totals the requests made to one model and their cost in US
+// dollars.
+type ModelUsage struct {
+	Model            string  `json:"model"`
+	Requests         int     `json:"requests"`
+	PromptTokens     int     `json:"prompt_tokens,omitempty"`
+	CompletionTokens int     `json:"completion_tokens,omitempty"`
+	EmbeddingTokens  int     `json:"embedding_tokens,omitempty"`
+	Cost             float64 `json:"cost"`
+}
+
+// UsageReport totals token usage and cost over all models, which are listed
+// by name.
+type UsageReport struct {
+	PromptTokens     int          `json:"prompt_tokens"`
+	CompletionTokens int          `json:"completion_tokens"`
+	EmbeddingTokens  int          `json:"embedding_tokens"`
+	Cost             float64      `json:"cost"`
+	Models           []ModelUsage `json:"models"`
+}
+
+// A UsageMeter adds up the token usage passed to Record, per model, and
+// prices it with Pricing. It is safe for concurrent use; pass its Record
+// method to WithUsageFunc to meter the requests made with a context.
+type UsageMeter struct {
+	Pricing Pricing
+
+	mu     sync.Mutex
+	models map[string]*ModelUsage
+}
+
+// Record adds u to the totals.
+func (m *UsageMeter) Record(u Usage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.models == nil {
+		m.models = make(map[string]*ModelUsage)
+	}
+	total, ok := m.models[u.Model]
+	if !ok {
+		total = &ModelUsage{Model: u.Model}
+		m.models[u.Model] = total
+	}
+	total.Requests++
+	total.PromptTokens += u.PromptTokens
+	total.CompletionTokens += u.CompletionTokens
+	total.EmbeddingTokens += u.EmbeddingTokens
+	total.Cost += m.Pricing.cost(u)
+}
+
+// Report returns the totals recorded so far.
+func (m *UsageMeter) Report() *UsageReport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	report := &UsageReport{Models: []ModelUsage{}}
+	for _, total := range m.models {
+		report.PromptTokens += total.PromptTokens
+		report.CompletionTokens += total.CompletionTokens
+		report.EmbeddingTokens += total.EmbeddingTokens
+		report.Cost += total.Cost
+		report.Models = append(report.Models, *total)
+	}
+	slices.SortFunc(report.Models, func(a, b ModelUsage) int { return cmp.Compare(a.Model, b.Model) })
+	return report
+}
+
+// metered returns a context that records usage in the pipeline's Usage
+// meter, if it has one, and in the returned meter, which reports the usage
+// of a single operation.
+func (p *Pipeline) metered(ctx context.Context) (context.Context, *UsageMeter) {
+	if p.Usage != nil {
+		ctx = WithUsageFunc(ctx, p.Usage.Record)
+	}
+	meter := &UsageMeter{Pricing: p.pricing()}
+	return WithUsageFunc(ctx, meter.Record), meter
+}
+
+// pricing returns the Pricing of the pipeline's Usage meter, or
+// DefaultPricing.
+func (p *Pipeline) pricing() Pricing {
+	if p.Usage != nil {
+		return p.Usage.Pricing
+	}
+	return DefaultPricing
+}
@@ -0,0 +1,191 @@
+package rag
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Types of the events posted by Webhooks.
+const (
+	EventDocumentIngested = "document.ingested"
+	EventDocumentDeleted  = "document.deleted"
+	EventJobCompleted     = "job.completed"
+	EventJobFailed        = "job.failed"
+	EventIndexReindexed   = "index.reindexed"
+)
+
+// DefaultWebhookRetries is how many times failed deliveries are retried
+// unless configured otherwise.
+const DefaultWebhookRetries = 5
+
+// webhookTimeout bounds each delivery, its retries included.
+const webhookTimeout = 5 * time.Minute
+
+// A WebhookEvent is the JSON body Webhooks post. Data is the IngestResult
+// of a document ingested, the Job of a job completed or failed, the
+// SnapshotStats of a reindex, and for a document deleted an object with
+// its id. ID stays the same across the retries of a delivery, so that
+// receivers can drop the events they already handled.
+type WebhookEvent struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	Namespace string    `json:"namespace,omitempty"`
+	Data      any       `json:"data"`
+}
+
+// Webhooks post events about changes to the corpus to URLs: documents
+// ingested or deleted, ingestion jobs completed or failed, and reindexes.
+// Events are delivered in the background, each on its own, so they may
+// arrive out of order. A delivery is retried up to Retries times as a
+// RetryTransport retries provider requests: rate limited and server errors
+// and requests that got no response, backing off exponentially.
+//
+// If Secret is set, every request carries an X-RAG-Signature header of the
+// form "t=<unix time>,v1=<hex HMAC-SHA256>", the HMAC computed with Secret
+// over the time, a dot and the body, which receivers check to trust the
+// event and reject stale ones. X-RAG-Event holds the type of the event and
+// X-RAG-Delivery its ID.
+type Webhooks struct {
+	URLs    []string
+	Secret  string
+	Events  []string // types of the events posted; all of them if empty
+	Retries int
+	Client  *http.Client // a client with a RetryTransport if nil
+
+	// OnError, if set, is called for deliveries that failed for good.
+	OnError func(url string, event WebhookEvent, err error)
+
+	once    sync.Once
+	ctx     context.Context
+	cancel  context.CancelFunc
+	pending sync.WaitGroup
+}
+
+// send posts an event of type typ, about the namespace of ctx, to every
+// URL, unless w is nil or its Events leave the type out.
+func (w *Webhooks) send(ctx context.Context, typ string, data any) {
+	if w == nil || len(w.URLs) == 0 || (len(w.Events) > 0 && !slices.Contains(w.Events, typ)) {
+		return
+	}
+	event := WebhookEvent{ID: rand.Text(), Type: typ, Time: time.Now().UTC(), Namespace: NamespaceFrom(ctx), Data: data}
+	body, err := json.Marshal(event)
+	if err != nil {
+		trace.SpanFromContext(ctx).RecordError(fmt.Errorf("webhook: %w", err))
+		return
+	}
+	w.init()
+	for _, url := range w.URLs {
+		w.pending.Go(func() {
+			if err := w.deliver(url, event, body); err != nil && w.OnError != nil {
+				w.OnError(url, event, err)
+			}
+		})
+	}
+}
+
+// deliver posts the encoded event body to url.
+func (w *Webhooks) deliver(url string, event WebhookEvent, body []byte) error {
+	ctx, cancel := context.WithTimeout(w.ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-RAG-Event", event.Type)
+	req.Header.Set("X-RAG-Delivery", event.ID)
+	if w.Secret != "" {
+		req.Header.Set("X-RAG-Signature", SignWebhook(w.Secret, event.Time, body))
+	}
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook: %s", resp.Status)
+	}
+	return nil
+}
+
+// init sets up the deliveries of w when it first needs to.
+func (w *Webhooks) init() {
+	w.once.Do(func() {
+		w.ctx, w.cancel = context.WithCancel(context.Background())
+		if w.Client == nil {
+			w.Client = &http.Client{Transport: &RetryTransport{Retries: w.Retries}}
+		}
+	})
+}
+
+// Close waits for the deliveries in progress, giving up on those left when
+// ctx is done.
+func (w *Webhooks) Close(ctx context.Context) error {
+	if w == nil {
+		return nil
+	}
+	w.init()
+	done := make(chan struct{})
+	go func() {
+		w.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		w.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// SignWebhook returns the X-RAG-Signature of a webhook body sent at t.
+func SignWebhook(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewWebhooks returns the Webhooks configured by cfg, or nil if it has no
+// WEBHOOK_URLS.
+func NewWebhooks(cfg Config) (*Webhooks, error) {
+	w := &Webhooks{Secret: cfg.WebhookSecret, Retries: cfg.WebhookRetries}
+	for _, url := range strings.Split(cfg.WebhookURLs, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			w.URLs = append(w.URLs, url)
+		}
+	}
+	if len(w.URLs) == 0 {
+		return nil, nil
+	}
+	for _, typ := range strings.Split(cfg.WebhookEvents, ",") {
+		switch typ = strings.TrimSpace(typ); typ {
+		case "":
+		case EventDocumentIngested, EventDocumentDeleted, EventJobCompleted, EventJobFailed, EventIndexReindexed:
+			w.Events = append(w.Events, typ)
+		default:
+			return nil, fmt.Errorf("unknown webhook event %q", typ)
+		}
+	}
+	return w, nil
+}
@@ -0,0 +1,1861 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: rag/v1/rag.proto
+
+// Package rag.v1 exposes the RAG pipeline: ingesting documents and
+// answering questions about them.
+
+package ragpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Document struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Text          string                 `protobuf:"bytes,2,opt,name=text,proto3" json:"text,omitempty"`
+	Metadata      map[string]string      `protobuf:"bytes,3,rep,name=metadata,proto3" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Document) Reset() {
+	*x = Document{}
+	mi := &file_rag_v1_rag_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Document) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Document) ProtoMessage() {}
+
+func (x *Document) ProtoReflect() protoreflect.Message {
+	mi := &file_rag_v1_rag_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Document.ProtoReflect.Descriptor instead.
+func (*Document) Descriptor() ([]byte, []int) {
+	return file_rag_v1_rag_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Document) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Document) GetText() string {
+	if x != nil {
+		return x.Text
+	}
+	return ""
+}
+
+func (x *Document) GetMetadata() map[string]string {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+type IngestRequest struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Documents []*Document            `protobuf:"bytes,1,rep,name=documents,proto3" json:"documents,omitempty"`
+	// Namespace to store the documents in; "default" if empty.
+	Namespace     string `protobuf:"bytes,2,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *IngestRequest) Reset() {
+	*x = IngestRequest{}
+	mi := &file_rag_v1_rag_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IngestRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IngestRequest) ProtoMessage() {}
+
+func (x *IngestRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_rag_v1_rag_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IngestRequest.ProtoReflect.Descriptor instead.
+func (*IngestRequest) Descriptor() ([]byte, []int) {
+	return file_rag_v1_rag_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *IngestRequest) GetDocuments() []*Document {
+	if x != nil {
+		return x.Documents
+	}
+	return nil
+}
+
+func (x *IngestRequest) GetNamespace() string {
+	if x != nil {
+		return x.Namespace
+	}
+	return ""
+}
+
+type IngestResult struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// Number of chunks stored for the document.
+	Chunks int32 `protobuf:"varint,2,opt,name=chunks,proto3" json:"chunks,omitempty"`
+	// Number of those chunks that were new or changed and had to be embedded.
+	Embedded int32 `protobuf:"varint,3,opt,name=embedded,proto3" json:"embedded,omitempty"`
+	// Set if the document could not be ingested.
+	Error         string `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *IngestResult) Reset() {
+	*x = IngestResult{}
+	mi := &file_rag_v1_rag_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IngestResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IngestResult) ProtoMessage() {}
+
+func (x *IngestResult) ProtoReflect() protoreflect.Message {
+	mi := &file_rag_v1_rag_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IngestResult.ProtoReflect.Descriptor instead.
+func (*IngestResult) Descriptor() ([]byte, []int) {
+	return file_rag_v1_rag_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *IngestResult) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *IngestResult) GetChunks() int32 {
+	if x != nil {
+		return x.Chunks
+	}
+	return 0
+}
+
+func (x *IngestResult) GetEmbedded() int32 {
+	if x != nil {
+		return x.Embedded
+	}
+	return 0
+}
+
+func (x *IngestResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type IngestResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Results       []*IngestResult        `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *IngestResponse) Reset() {
+	*x = IngestResponse{}
+	mi := &file_rag_v1_rag_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IngestResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IngestResponse) ProtoMessage() {}
+
+func (x *IngestResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_rag_v1_rag_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IngestResponse.ProtoReflect.Descriptor instead.
+func (*IngestResponse) Descriptor() ([]byte, []int) {
+	return file_rag_v1_rag_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *IngestResponse) GetResults() []*IngestResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+type QueryRequest struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Question string                 `protobuf:"bytes,1,opt,name=question,proto3" json:"question,omitempty"`
+	// Number of chunks to retrieve; the server default if zero.
+	K int32 `protobuf:"varint,2,opt,name=k,proto3" json:"k,omitempty"`
+	// Questions sharing a session can refer back to earlier answers.
+	SessionId string `protobuf:"bytes,3,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	// Metadata filter expression, e.g. "source=handbook, year>=2023".
+	Filter string `protobuf:"bytes,4,opt,name=filter,proto3" json:"filter,omitempty"`
+	// Answer format: "text" (the default) or "json", which has the model
+	// reply with a machine-readable object filling confidence and citations.
+	Format string `protobuf:"bytes,5,opt,name=format,proto3" json:"format,omitempty"`
+	// Namespace to answer from; "default" if empty.
+	Namespace string `protobuf:"bytes,6,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	// Retrieved chunks scoring below min_score are dropped; the server's
+	// MIN_SCORE if unset. Scores are those of the last retrieval stage, e.g.
+	// the reranker's relevance scores.
+	MinScore *float64 `protobuf:"fixed64,7,opt,name=min_score,json=minScore,proto3,oneof" json:"min_score,omitempty"`
+	// Whether to rerank retrieved chunks, if the server has a reranker;
+	// setting it to false skips the reranker.
+	Rerank *bool `protobuf:"varint,8,opt,name=rerank,proto3,oneof" json:"rerank,omitempty"`
+	// Sampling temperature of the answer, from 0 to 2; the model's default if
+	// unset.
+	Temperature *float64 `protobuf:"fixed64,9,opt,name=temperature,proto3,oneof" json:"temperature,omitempty"`
+	// Turns in which the model may search with tool calls before the answer
+	// is generated; the server default if unset, and 0 searches for the
+	// question once.
+	AgentSteps *int32 `protobuf:"varint,10,opt,name=agent_steps,json=agentSteps,proto3,oneof" json:"agent_steps,omitempty"`
+	// Nucleus sampling of the answer, from 0 to 1; the model's default if
+	// unset.
+	TopP *float64 `protobuf:"fixed64,11,opt,name=top_p,json=topP,proto3,oneof" json:"top_p,omitempty"`
+	// Most tokens the answer may have; the model's limit if zero.
+	MaxTokens int32 `protobuf:"varint,12,opt,name=max_tokens,json=maxTokens,proto3" json:"max_tokens,omitempty"`
+	// Sequences, at most four, before which the answer ends.
+	Stop []string `protobuf:"bytes,13,rep,name=stop,proto3" json:"stop,omitempty"`
+	// Penalties, from -2 to 2, of tokens that appeared in the answer so far
+	// and of how often they did; the model's defaults if unset. Bedrock
+	// ignores them.
+	PresencePenalty  *float64 `protobuf:"fixed64,14,opt,name=presence_penalty,json=presencePenalty,proto3,oneof" json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64 `protobuf:"fixed64,15,opt,name=frequency_penalty,json=frequencyPenalty,proto3,oneof" json:"frequency_penalty,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *QueryRequest) Reset() {
+	*x = QueryRequest{}
+	mi := &file_rag_v1_rag_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QueryRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueryRequest) ProtoMessage() {}
+
+func (x *QueryRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_rag_v1_rag_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueryRequest.ProtoReflect.Descriptor instead.
+func (*QueryRequest) Descriptor() ([]byte, []int) {
+	return file_rag_v1_rag_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *QueryRequest) GetQuestion() string {
+	if x != nil {
+		return x.Question
+	}
+	return ""
+}
+
+func (x *QueryRequest) GetK() int32 {
+	if x != nil {
+		return x.K
+	}
+	return 0
+}
+
+func (x *QueryRequest) GetSessionId() string {
+	if x != nil {
+		return x.SessionId
+	}
+	return ""
+}
+
+func (x *QueryRequest) GetFilter() string {
+	if x != nil {
+		return x.Filter
+	}
+	return ""
+}
+
+func (x *QueryRequest) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+func (x *QueryRequest) GetNamespace() string {
+	if x != nil {
+		return x.Namespace
+	}
+	return ""
+}
+
+func (x *QueryRequest) GetMinScore() float64 {
+	if x != nil && x.MinScore != nil {
+		return *x.MinScore
+	}
+	return 0
+}
+
+func (x *QueryRequest) GetRerank() bool {
+	if x != nil && x.Rerank != nil {
+		return *x.Rerank
+	}
+	return false
+}
+
+func (x *QueryRequest) GetTemperature() float64 {
+	if x != nil && x.Temperature != nil {
+		return *x.Temperature
+	}
+	return 0
+}
+
+func (x *QueryRequest) GetAgentSteps() int32 {
+	if x != nil && x.AgentSteps != nil {
+		return *x.AgentSteps
+	}
+	return 0
+}
+
+func (x *QueryRequest) GetTopP() float64 {
+	if x != nil && x.TopP != nil {
+		return *x.TopP
+	}
+	return 0
+}
+
+func (x *QueryRequest) GetMaxTokens() int32 {
+	if x != nil {
+		return x.MaxTokens
+	}
+	return 0
+}
+
+func (x *QueryRequest) GetStop() []string {
+	if x != nil {
+		return x.Stop
+	}
+	return nil
+}
+
+func (x *QueryRequest) GetPresencePenalty() float64 {
+	if x != nil && x.PresencePenalty != nil {
+		return *x.PresencePenalty
+	}
+	return 0
+}
+
+func (x *QueryRequest) GetFrequencyPenalty() float64 {
+	if x != nil && x.FrequencyPenalty != nil {
+		return *x.FrequencyPenalty
+	}
+	return 0
+}
+
+// SourceRef is a chunk given to the model as context. The answer cites it
+// as [n], where n is its 1-based position in QueryResponse.sources.
+type SourceRef struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	DocId string                 `protobuf:"bytes,1,opt,name=doc_id,json=docId,proto3" json:"doc_id,omitempty"`
+	Chunk int32                  `protobuf:"varint,2,opt,name=chunk,proto3" json:"chunk,omitempty"`
+	Score float32                `protobuf:"fixed32,3,opt,name=score,proto3" json:"score,omitempty"`
+	Page  int32                  `protobuf:"varint,4,opt,name=page,proto3" json:"page,omitempty"`
+	Url   string                 `protobuf:"bytes,5,opt,name=url,proto3" json:"url,omitempty"`
+	Text  string                 `protobuf:"bytes,6,opt,name=text,proto3" json:"text,omitempty"`
+	Cited bool                   `protobuf:"varint,7,opt,name=cited,proto3" json:"cited,omitempty"`
+	// The injection guard mode, "flag" or "strip", applied to the chunk if
+	// it looked like a prompt injection.
+	Injection string `protobuf:"bytes,8,opt,name=injection,proto3" json:"injection,omitempty"`
+	ChunkId   string `protobuf:"bytes,9,opt,name=chunk_id,json=chunkId,proto3" json:"chunk_id,omitempty"`
+	// The offsets of the chunk in the text of its document, if they were
+	// stored.
+	Span          *Span `protobuf:"bytes,10,opt,name=span,proto3" json:"span,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SourceRef) Reset() {
+	*x = SourceRef{}
+	mi := &file_rag_v1_rag_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SourceRef) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SourceRef) ProtoMessage() {}
+
+func (x *SourceRef) ProtoReflect() protoreflect.Message {
+	mi := &file_rag_v1_rag_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SourceRef.ProtoReflect.Descriptor instead.
+func (*SourceRef) Descriptor() ([]byte, []int) {
+	return file_rag_v1_rag_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *SourceRef) GetDocId() string {
+	if x != nil {
+		return x.DocId
+	}
+	return ""
+}
+
+func (x *SourceRef) GetChunk() int32 {
+	if x != nil {
+		return x.Chunk
+	}
+	return 0
+}
+
+func (x *SourceRef) GetScore() float32 {
+	if x != nil {
+		return x.Score
+	}
+	return 0
+}
+
+func (x *SourceRef) GetPage() int32 {
+	if x != nil {
+		return x.Page
+	}
+	return 0
+}
+
+func (x *SourceRef) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *SourceRef) GetText() string {
+	if x != nil {
+		return x.Text
+	}
+	return ""
+}
+
+func (x *SourceRef) GetCited() bool {
+	if x != nil {
+		return x.Cited
+	}
+	return false
+}
+
+func (x *SourceRef) GetInjection() string {
+	if x != nil {
+		return x.Injection
+	}
+	return ""
+}
+
+func (x *SourceRef) GetChunkId() string {
+	if x != nil {
+		return x.ChunkId
+	}
+	return ""
+}
+
+func (x *SourceRef) GetSpan() *Span {
+	if x != nil {
+		return x.Span
+	}
+	return nil
+}
+
+// Span is a range of characters, counted in Unicode code points, of the
+// text of a document, from start up to, exclusive, end.
+type Span struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Start         int32                  `protobuf:"varint,1,opt,name=start,proto3" json:"start,omitempty"`
+	End           int32                  `protobuf:"varint,2,opt,name=end,proto3" json:"end,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Span) Reset() {
+	*x = Span{}
+	mi := &file_rag_v1_rag_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Span) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Span) ProtoMessage() {}
+
+func (x *Span) ProtoReflect() protoreflect.Message {
+	mi := &file_rag_v1_rag_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Span.ProtoReflect.Descriptor instead.
+func (*Span) Descriptor() ([]byte, []int) {
+	return file_rag_v1_rag_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Span) GetStart() int32 {
+	if x != nil {
+		return x.Start
+	}
+	return 0
+}
+
+func (x *Span) GetEnd() int32 {
+	if x != nil {
+		return x.End
+	}
+	return 0
+}
+
+type QueryResponse struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Answer  string                 `protobuf:"bytes,1,opt,name=answer,proto3" json:"answer,omitempty"`
+	Sources []*SourceRef           `protobuf:"bytes,2,rep,name=sources,proto3" json:"sources,omitempty"`
+	// How well the context supports the answer, from 0 to 1. Only set for
+	// the "json" format.
+	Confidence *float64 `protobuf:"fixed64,3,opt,name=confidence,proto3,oneof" json:"confidence,omitempty"`
+	// 1-based positions in sources of the cited chunks.
+	Citations []int32 `protobuf:"varint,4,rep,packed,name=citations,proto3" json:"citations,omitempty"`
+	// The result of checking the answer against its sources. Only set if the
+	// server checks the grounding of answers.
+	Grounding *Grounding `protobuf:"bytes,5,opt,name=grounding,proto3" json:"grounding,omitempty"`
+	// Whether the answer was served from the server's answer cache.
+	Cached bool `protobuf:"varint,6,opt,name=cached,proto3" json:"cached,omitempty"`
+	// The tool calls the model made to find the sources, if it searched for
+	// them itself.
+	Trace []*AgentStep `protobuf:"bytes,7,rep,name=trace,proto3" json:"trace,omitempty"`
+	// Whether no relevant chunk was found, so that the answer says so and
+	// was not generated.
+	NoContext bool `protobuf:"varint,8,opt,name=no_context,json=noContext,proto3" json:"no_context,omitempty"`
+	// The route of the server's router the question took, if any.
+	Route string `protobuf:"bytes,9,opt,name=route,proto3" json:"route,omitempty"`
+	// The ID to give feedback on the answer by, if the server records
+	// feedback and the answer has sources.
+	Id string `protobuf:"bytes,10,opt,name=id,proto3" json:"id,omitempty"`
+	// The stages skipped as they ran out of time, such as "rerank".
+	TimedOut []string `protobuf:"bytes,11,rep,name=timed_out,json=timedOut,proto3" json:"timed_out,omitempty"`
+	// The provider of the fallback chain that generated the answer, such as
+	// "ollama/llama3.2", if the server has one.
+	Provider      string `protobuf:"bytes,12,opt,name=provider,proto3" json:"provider,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QueryResponse) Reset() {
+	*x = QueryResponse{}
+	mi := &file_rag_v1_rag_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QueryResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueryResponse) ProtoMessage() {}
+
+func (x *QueryResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_rag_v1_rag_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueryResponse.ProtoReflect.Descriptor instead.
+func (*QueryResponse) Descriptor() ([]byte, []int) {
+	return file_rag_v1_rag_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *QueryResponse) GetAnswer() string {
+	if x != nil {
+		return x.Answer
+	}
+	return ""
+}
+
+func (x *QueryResponse) GetSources() []*SourceRef {
+	if x != nil {
+		return x.Sources
+	}
+	return nil
+}
+
+func (x *QueryResponse) GetConfidence() float64 {
+	if x != nil && x.Confidence != nil {
+		return *x.Confidence
+	}
+	return 0
+}
+
+func (x *QueryResponse) GetCitations() []int32 {
+	if x != nil {
+		return x.Citations
+	}
+	return nil
+}
+
+func (x *QueryResponse) GetGrounding() *Grounding {
+	if x != nil {
+		return x.Grounding
+	}
+	return nil
+}
+
+func (x *QueryResponse) GetCached() bool {
+	if x != nil {
+		return x.Cached
+	}
+	return false
+}
+
+func (x *QueryResponse) GetTrace() []*AgentStep {
+	if x != nil {
+		return x.Trace
+	}
+	return nil
+}
+
+func (x *QueryResponse) GetNoContext() bool {
+	if x != nil {
+		return x.NoContext
+	}
+	return false
+}
+
+func (x *QueryResponse) GetRoute() string {
+	if x != nil {
+		return x.Route
+	}
+	return ""
+}
+
+func (x *QueryResponse) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *QueryResponse) GetTimedOut() []string {
+	if x != nil {
+		return x.TimedOut
+	}
+	return nil
+}
+
+func (x *QueryResponse) GetProvider() string {
+	if x != nil {
+		return x.Provider
+	}
+	return ""
+}
+
+// AgentStep is a tool call the model made while searching for sources.
+type AgentStep struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// 1-based turn in which the model made the call.
+	Step int32 `protobuf:"varint,1,opt,name=step,proto3" json:"step,omitempty"`
+	// "search" or "read".
+	Tool string `protobuf:"bytes,2,opt,name=tool,proto3" json:"tool,omitempty"`
+	// The arguments of the call, as a JSON object.
+	Arguments string `protobuf:"bytes,3,opt,name=arguments,proto3" json:"arguments,omitempty"`
+	// 1-based positions in QueryResponse.sources of the passages returned.
+	Passages []int32 `protobuf:"varint,4,rep,packed,name=passages,proto3" json:"passages,omitempty"`
+	// What the model was told if the call failed.
+	Error         string `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AgentStep) Reset() {
+	*x = AgentStep{}
+	mi := &file_rag_v1_rag_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AgentStep) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AgentStep) ProtoMessage() {}
+
+func (x *AgentStep) ProtoReflect() protoreflect.Message {
+	mi := &file_rag_v1_rag_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AgentStep.ProtoReflect.Descriptor instead.
+func (*AgentStep) Descriptor() ([]byte, []int) {
+	return file_rag_v1_rag_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *AgentStep) GetStep() int32 {
+	if x != nil {
+		return x.Step
+	}
+	return 0
+}
+
+func (x *AgentStep) GetTool() string {
+	if x != nil {
+		return x.Tool
+	}
+	return ""
+}
+
+func (x *AgentStep) GetArguments() string {
+	if x != nil {
+		return x.Arguments
+	}
+	return ""
+}
+
+func (x *AgentStep) GetPassages() []int32 {
+	if x != nil {
+		return x.Passages
+	}
+	return nil
+}
+
+func (x *AgentStep) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type Grounding struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Fraction of the answer's claims the sources support, from 0 to 1.
+	Score float64 `protobuf:"fixed64,1,opt,name=score,proto3" json:"score,omitempty"`
+	// The claims the sources do not support.
+	Unsupported []string `protobuf:"bytes,2,rep,name=unsupported,proto3" json:"unsupported,omitempty"`
+	// Whether the unsupported claims were removed from the answer.
+	Stripped bool `protobuf:"varint,3,opt,name=stripped,proto3" json:"stripped,omitempty"`
+	// Whether the answer was generated again after failing the check.
+	Regenerated   bool `protobuf:"varint,4,opt,name=regenerated,proto3" json:"regenerated,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Grounding) Reset() {
+	*x = Grounding{}
+	mi := &file_rag_v1_rag_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Grounding) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Grounding) ProtoMessage() {}
+
+func (x *Grounding) ProtoReflect() protoreflect.Message {
+	mi := &file_rag_v1_rag_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Grounding.ProtoReflect.Descriptor instead.
+func (*Grounding) Descriptor() ([]byte, []int) {
+	return file_rag_v1_rag_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *Grounding) GetScore() float64 {
+	if x != nil {
+		return x.Score
+	}
+	return 0
+}
+
+func (x *Grounding) GetUnsupported() []string {
+	if x != nil {
+		return x.Unsupported
+	}
+	return nil
+}
+
+func (x *Grounding) GetStripped() bool {
+	if x != nil {
+		return x.Stripped
+	}
+	return false
+}
+
+func (x *Grounding) GetRegenerated() bool {
+	if x != nil {
+		return x.Regenerated
+	}
+	return false
+}
+
+type QueryStreamResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Event:
+	//
+	//	*QueryStreamResponse_Delta
+	//	*QueryStreamResponse_Done
+	Event         isQueryStreamResponse_Event `protobuf_oneof:"event"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QueryStreamResponse) Reset() {
+	*x = QueryStreamResponse{}
+	mi := &file_rag_v1_rag_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QueryStreamResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueryStreamResponse) ProtoMessage() {}
+
+func (x *QueryStreamResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_rag_v1_rag_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueryStreamResponse.ProtoReflect.Descriptor instead.
+func (*QueryStreamResponse) Descriptor() ([]byte, []int) {
+	return file_rag_v1_rag_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *QueryStreamResponse) GetEvent() isQueryStreamResponse_Event {
+	if x != nil {
+		return x.Event
+	}
+	return nil
+}
+
+func (x *QueryStreamResponse) GetDelta() string {
+	if x != nil {
+		if x, ok := x.Event.(*QueryStreamResponse_Delta); ok {
+			return x.Delta
+		}
+	}
+	return ""
+}
+
+func (x *QueryStreamResponse) GetDone() *QueryResponse {
+	if x != nil {
+		if x, ok := x.Event.(*QueryStreamResponse_Done); ok {
+			return x.Done
+		}
+	}
+	return nil
+}
+
+type isQueryStreamResponse_Event interface {
+	isQueryStreamResponse_Event()
+}
+
+type QueryStreamResponse_Delta struct {
+	// The next piece of the answer.
+	Delta string `protobuf:"bytes,1,opt,name=delta,proto3,oneof"`
+}
+
+type QueryStreamResponse_Done struct {
+	// The complete answer, sent last.
+	Done *QueryResponse `protobuf:"bytes,2,opt,name=done,proto3,oneof"`
+}
+
+func (*QueryStreamResponse_Delta) isQueryStreamResponse_Event() {}
+
+func (*QueryStreamResponse_Done) isQueryStreamResponse_Event() {}
+
+type ListDocumentsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Namespace to list; "default" if empty.
+	Namespace     string `protobuf:"bytes,1,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListDocumentsRequest) Reset() {
+	*x = ListDocumentsRequest{}
+	mi := &file_rag_v1_rag_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListDocumentsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListDocumentsRequest) ProtoMessage() {}
+
+func (x *ListDocumentsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_rag_v1_rag_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListDocumentsRequest.ProtoReflect.Descriptor instead.
+func (*ListDocumentsRequest) Descriptor() ([]byte, []int) {
+	return file_rag_v1_rag_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *ListDocumentsRequest) GetNamespace() string {
+	if x != nil {
+		return x.Namespace
+	}
+	return ""
+}
+
+type DocumentInfo struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Chunks        int32                  `protobuf:"varint,2,opt,name=chunks,proto3" json:"chunks,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DocumentInfo) Reset() {
+	*x = DocumentInfo{}
+	mi := &file_rag_v1_rag_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DocumentInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DocumentInfo) ProtoMessage() {}
+
+func (x *DocumentInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_rag_v1_rag_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DocumentInfo.ProtoReflect.Descriptor instead.
+func (*DocumentInfo) Descriptor() ([]byte, []int) {
+	return file_rag_v1_rag_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *DocumentInfo) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *DocumentInfo) GetChunks() int32 {
+	if x != nil {
+		return x.Chunks
+	}
+	return 0
+}
+
+type ListDocumentsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Documents     []*DocumentInfo        `protobuf:"bytes,1,rep,name=documents,proto3" json:"documents,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListDocumentsResponse) Reset() {
+	*x = ListDocumentsResponse{}
+	mi := &file_rag_v1_rag_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListDocumentsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListDocumentsResponse) ProtoMessage() {}
+
+func (x *ListDocumentsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_rag_v1_rag_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListDocumentsResponse.ProtoReflect.Descriptor instead.
+func (*ListDocumentsResponse) Descriptor() ([]byte, []int) {
+	return file_rag_v1_rag_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *ListDocumentsResponse) GetDocuments() []*DocumentInfo {
+	if x != nil {
+		return x.Documents
+	}
+	return nil
+}
+
+type DeleteDocumentRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// Namespace holding the document; "default" if empty.
+	Namespace     string `protobuf:"bytes,2,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteDocumentRequest) Reset() {
+	*x = DeleteDocumentRequest{}
+	mi := &file_rag_v1_rag_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteDocumentRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteDocumentRequest) ProtoMessage() {}
+
+func (x *DeleteDocumentRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_rag_v1_rag_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteDocumentRequest.ProtoReflect.Descriptor instead.
+func (*DeleteDocumentRequest) Descriptor() ([]byte, []int) {
+	return file_rag_v1_rag_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *DeleteDocumentRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *DeleteDocumentRequest) GetNamespace() string {
+	if x != nil {
+		return x.Namespace
+	}
+	return ""
+}
+
+type DeleteDocumentResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteDocumentResponse) Reset() {
+	*x = DeleteDocumentResponse{}
+	mi := &file_rag_v1_rag_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteDocumentResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteDocumentResponse) ProtoMessage() {}
+
+func (x *DeleteDocumentResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_rag_v1_rag_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteDocumentResponse.ProtoReflect.Descriptor instead.
+func (*DeleteDocumentResponse) Descriptor() ([]byte, []int) {
+	return file_rag_v1_rag_proto_rawDescGZIP(), []int{15}
+}
+
+type CreateNamespaceRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateNamespaceRequest) Reset() {
+	*x = CreateNamespaceRequest{}
+	mi := &file_rag_v1_rag_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateNamespaceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateNamespaceRequest) ProtoMessage() {}
+
+func (x *CreateNamespaceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_rag_v1_rag_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateNamespaceRequest.ProtoReflect.Descriptor instead.
+func (*CreateNamespaceRequest) Descriptor() ([]byte, []int) {
+	return file_rag_v1_rag_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *CreateNamespaceRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+type CreateNamespaceResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateNamespaceResponse) Reset() {
+	*x = CreateNamespaceResponse{}
+	mi := &file_rag_v1_rag_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateNamespaceResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateNamespaceResponse) ProtoMessage() {}
+
+func (x *CreateNamespaceResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_rag_v1_rag_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateNamespaceResponse.ProtoReflect.Descriptor instead.
+func (*CreateNamespaceResponse) Descriptor() ([]byte, []int) {
+	return file_rag_v1_rag_proto_rawDescGZIP(), []int{17}
+}
+
+type ListNamespacesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListNamespacesRequest) Reset() {
+	*x = ListNamespacesRequest{}
+	mi := &file_rag_v1_rag_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListNamespacesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListNamespacesRequest) ProtoMessage() {}
+
+func (x *ListNamespacesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_rag_v1_rag_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListNamespacesRequest.ProtoReflect.Descriptor instead.
+func (*ListNamespacesRequest) Descriptor() ([]byte, []int) {
+	return file_rag_v1_rag_proto_rawDescGZIP(), []int{18}
+}
+
+type NamespaceInfo struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Chunks        int32                  `protobuf:"varint,2,opt,name=chunks,proto3" json:"chunks,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *NamespaceInfo) Reset() {
+	*x = NamespaceInfo{}
+	mi := &file_rag_v1_rag_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NamespaceInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NamespaceInfo) ProtoMessage() {}
+
+func (x *NamespaceInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_rag_v1_rag_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NamespaceInfo.ProtoReflect.Descriptor instead.
+func (*NamespaceInfo) Descriptor() ([]byte, []int) {
+	return file_rag_v1_rag_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *NamespaceInfo) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *NamespaceInfo) GetChunks() int32 {
+	if x != nil {
+		return x.Chunks
+	}
+	return 0
+}
+
+type ListNamespacesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Namespaces    []*NamespaceInfo       `protobuf:"bytes,1,rep,name=namespaces,proto3" json:"namespaces,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListNamespacesResponse) Reset() {
+	*x = ListNamespacesResponse{}
+	mi := &file_rag_v1_rag_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListNamespacesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListNamespacesResponse) ProtoMessage() {}
+
+func (x *ListNamespacesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_rag_v1_rag_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListNamespacesResponse.ProtoReflect.Descriptor instead.
+func (*ListNamespacesResponse) Descriptor() ([]byte, []int) {
+	return file_rag_v1_rag_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *ListNamespacesResponse) GetNamespaces() []*NamespaceInfo {
+	if x != nil {
+		return x.Namespaces
+	}
+	return nil
+}
+
+type DeleteNamespaceRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteNamespaceRequest) Reset() {
+	*x = DeleteNamespaceRequest{}
+	mi := &file_rag_v1_rag_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteNamespaceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteNamespaceRequest) ProtoMessage() {}
+
+func (x *DeleteNamespaceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_rag_v1_rag_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteNamespaceRequest.ProtoReflect.Descriptor instead.
+func (*DeleteNamespaceRequest) Descriptor() ([]byte, []int) {
+	return file_rag_v1_rag_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *DeleteNamespaceRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+type DeleteNamespaceResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteNamespaceResponse) Reset() {
+	*x = DeleteNamespaceResponse{}
+	mi := &file_rag_v1_rag_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteNamespaceResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteNamespaceResponse) ProtoMessage() {}
+
+func (x *DeleteNamespaceResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_rag_v1_rag_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteNamespaceResponse.ProtoReflect.Descriptor instead.
+func (*DeleteNamespaceResponse) Descriptor() ([]byte, []int) {
+	return file_rag_v1_rag_proto_rawDescGZIP(), []int{22}
+}
+
+type FeedbackRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The id of the QueryResponse rated.
+	AnswerId string `protobuf:"bytes,1,opt,name=answer_id,json=answerId,proto3" json:"answer_id,omitempty"`
+	// "up" or "down".
+	Rating        string `protobuf:"bytes,2,opt,name=rating,proto3" json:"rating,omitempty"`
+	Comment       string `protobuf:"bytes,3,opt,name=comment,proto3" json:"comment,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FeedbackRequest) Reset() {
+	*x = FeedbackRequest{}
+	mi := &file_rag_v1_rag_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FeedbackRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FeedbackRequest) ProtoMessage() {}
+
+func (x *FeedbackRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_rag_v1_rag_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FeedbackRequest.ProtoReflect.Descriptor instead.
+func (*FeedbackRequest) Descriptor() ([]byte, []int) {
+	return file_rag_v1_rag_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *FeedbackRequest) GetAnswerId() string {
+	if x != nil {
+		return x.AnswerId
+	}
+	return ""
+}
+
+func (x *FeedbackRequest) GetRating() string {
+	if x != nil {
+		return x.Rating
+	}
+	return ""
+}
+
+func (x *FeedbackRequest) GetComment() string {
+	if x != nil {
+		return x.Comment
+	}
+	return ""
+}
+
+type FeedbackResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FeedbackResponse) Reset() {
+	*x = FeedbackResponse{}
+	mi := &file_rag_v1_rag_proto_msgTypes[24]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FeedbackResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FeedbackResponse) ProtoMessage() {}
+
+func (x *FeedbackResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_rag_v1_rag_proto_msgTypes[24]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FeedbackResponse.ProtoReflect.Descriptor instead.
+func (*FeedbackResponse) Descriptor() ([]byte, []int) {
+	return file_rag_v1_rag_proto_rawDescGZIP(), []int{24}
+}
+
+var File_rag_v1_rag_proto protoreflect.FileDescriptor
+
+const file_rag_v1_rag_proto_rawDesc = "" +
+	"\n" +
+	"\x10rag/v1/rag.proto\x12\x06rag.v1\"\xa7\x01\n" +
+	"\bDocument\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04text\x18\x02 \x01(\tR\x04text\x12:\n" +
+	"\bmetadata\x18\x03 \x03(\v2\x1e.rag.v1.Document.MetadataEntryR\bmetadata\x1a;\n" +
+	"\rMetadataEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"]\n" +
+	"\rIngestRequest\x12.\n" +
+	"\tdocuments\x18\x01 \x03(\v2\x10.rag.v1.DocumentR\tdocuments\x12\x1c\n" +
+	"\tnamespace\x18\x02 \x01(\tR\tnamespace\"h\n" +
+	"\fIngestResult\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
+	"\x06chunks\x18\x02 \x01(\x05R\x06chunks\x12\x1a\n" +
+	"\bembedded\x18\x03 \x01(\x05R\bembedded\x12\x14\n" +
+	"\x05error\x18\x04 \x01(\tR\x05error\"@\n" +
+	"\x0eIngestResponse\x12.\n" +
+	"\aresults\x18\x01 \x03(\v2\x14.rag.v1.IngestResultR\aresults\"\xce\x04\n" +
+	"\fQueryRequest\x12\x1a\n" +
+	"\bquestion\x18\x01 \x01(\tR\bquestion\x12\f\n" +
+	"\x01k\x18\x02 \x01(\x05R\x01k\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x03 \x01(\tR\tsessionId\x12\x16\n" +
+	"\x06filter\x18\x04 \x01(\tR\x06filter\x12\x16\n" +
+	"\x06format\x18\x05 \x01(\tR\x06format\x12\x1c\n" +
+	"\tnamespace\x18\x06 \x01(\tR\tnamespace\x12 \n" +
+	"\tmin_score\x18\a \x01(\x01H\x00R\bminScore\x88\x01\x01\x12\x1b\n" +
+	"\x06rerank\x18\b \x01(\bH\x01R\x06rerank\x88\x01\x01\x12%\n" +
+	"\vtemperature\x18\t \x01(\x01H\x02R\vtemperature\x88\x01\x01\x12$\n" +
+	"\vagent_steps\x18\n" +
+	" \x01(\x05H\x03R\n" +
+	"agentSteps\x88\x01\x01\x12\x18\n" +
+	"\x05top_p\x18\v \x01(\x01H\x04R\x04topP\x88\x01\x01\x12\x1d\n" +
+	"\n" +
+	"max_tokens\x18\f \x01(\x05R\tmaxTokens\x12\x12\n" +
+	"\x04stop\x18\r \x03(\tR\x04stop\x12.\n" +
+	"\x10presence_penalty\x18\x0e \x01(\x01H\x05R\x0fpresencePenalty\x88\x01\x01\x120\n" +
+	"\x11frequency_penalty\x18\x0f \x01(\x01H\x06R\x10frequencyPenalty\x88\x01\x01B\f\n" +
+	"\n" +
+	"_min_scoreB\t\n" +
+	"\a_rerankB\x0e\n" +
+	"\f_temperatureB\x0e\n" +
+	"\f_agent_stepsB\b\n" +
+	"\x06_top_pB\x13\n" +
+	"\x11_presence_penaltyB\x14\n" +
+	"\x12_frequency_penalty\"\xf9\x01\n" +
+	"\tSourceRef\x12\x15\n" +
+	"\x06doc_id\x18\x01 \x01(\tR\x05docId\x12\x14\n" +
+	"\x05chunk\x18\x02 \x01(\x05R\x05chunk\x12\x14\n" +
+	"\x05score\x18\x03 \x01(\x02R\x05score\x12\x12\n" +
+	"\x04page\x18\x04 \x01(\x05R\x04page\x12\x10\n" +
+	"\x03url\x18\x05 \x01(\tR\x03url\x12\x12\n" +
+	"\x04text\x18\x06 \x01(\tR\x04text\x12\x14\n" +
+	"\x05cited\x18\a \x01(\bR\x05cited\x12\x1c\n" +
+	"\tinjection\x18\b \x01(\tR\tinjection\x12\x19\n" +
+	"\bchunk_id\x18\t \x01(\tR\achunkId\x12 \n" +
+	"\x04span\x18\n" +
+	" \x01(\v2\f.rag.v1.SpanR\x04span\".\n" +
+	"\x04Span\x12\x14\n" +
+	"\x05start\x18\x01 \x01(\x05R\x05start\x12\x10\n" +
+	"\x03end\x18\x02 \x01(\x05R\x03end\"\x96\x03\n" +
+	"\rQueryResponse\x12\x16\n" +
+	"\x06answer\x18\x01 \x01(\tR\x06answer\x12+\n" +
+	"\asources\x18\x02 \x03(\v2\x11.rag.v1.SourceRefR\asources\x12#\n" +
+	"\n" +
+	"confidence\x18\x03 \x01(\x01H\x00R\n" +
+	"confidence\x88\x01\x01\x12\x1c\n" +
+	"\tcitations\x18\x04 \x03(\x05R\tcitations\x12/\n" +
+	"\tgrounding\x18\x05 \x01(\v2\x11.rag.v1.GroundingR\tgrounding\x12\x16\n" +
+	"\x06cached\x18\x06 \x01(\bR\x06cached\x12'\n" +
+	"\x05trace\x18\a \x03(\v2\x11.rag.v1.AgentStepR\x05trace\x12\x1d\n" +
+	"\n" +
+	"no_context\x18\b \x01(\bR\tnoContext\x12\x14\n" +
+	"\x05route\x18\t \x01(\tR\x05route\x12\x0e\n" +
+	"\x02id\x18\n" +
+	" \x01(\tR\x02id\x12\x1b\n" +
+	"\ttimed_out\x18\v \x03(\tR\btimedOut\x12\x1a\n" +
+	"\bprovider\x18\f \x01(\tR\bproviderB\r\n" +
+	"\v_confidence\"\x83\x01\n" +
+	"\tAgentStep\x12\x12\n" +
+	"\x04step\x18\x01 \x01(\x05R\x04step\x12\x12\n" +
+	"\x04tool\x18\x02 \x01(\tR\x04tool\x12\x1c\n" +
+	"\targuments\x18\x03 \x01(\tR\targuments\x12\x1a\n" +
+	"\bpassages\x18\x04 \x03(\x05R\bpassages\x12\x14\n" +
+	"\x05error\x18\x05 \x01(\tR\x05error\"\x81\x01\n" +
+	"\tGrounding\x12\x14\n" +
+	"\x05score\x18\x01 \x01(\x01R\x05score\x12 \n" +
+	"\vunsupported\x18\x02 \x03(\tR\vunsupported\x12\x1a\n" +
+	"\bstripped\x18\x03 \x01(\bR\bstripped\x12 \n" +
+	"\vregenerated\x18\x04 \x01(\bR\vregenerated\"c\n" +
+	"\x13QueryStreamResponse\x12\x16\n" +
+	"\x05delta\x18\x01 \x01(\tH\x00R\x05delta\x12+\n" +
+	"\x04done\x18\x02 \x01(\v2\x15.rag.v1.QueryResponseH\x00R\x04doneB\a\n" +
+	"\x05event\"4\n" +
+	"\x14ListDocumentsRequest\x12\x1c\n" +
+	"\tnamespace\x18\x01 \x01(\tR\tnamespace\"6\n" +
+	"\fDocumentInfo\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
+	"\x06chunks\x18\x02 \x01(\x05R\x06chunks\"K\n" +
+	"\x15ListDocumentsResponse\x122\n" +
+	"\tdocuments\x18\x01 \x03(\v2\x14.rag.v1.DocumentInfoR\tdocuments\"E\n" +
+	"\x15DeleteDocumentRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1c\n" +
+	"\tnamespace\x18\x02 \x01(\tR\tnamespace\"\x18\n" +
+	"\x16DeleteDocumentResponse\",\n" +
+	"\x16CreateNamespaceRequest\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\"\x19\n" +
+	"\x17CreateNamespaceResponse\"\x17\n" +
+	"\x15ListNamespacesRequest\";\n" +
+	"\rNamespaceInfo\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x16\n" +
+	"\x06chunks\x18\x02 \x01(\x05R\x06chunks\"O\n" +
+	"\x16ListNamespacesResponse\x125\n" +
+	"\n" +
+	"namespaces\x18\x01 \x03(\v2\x15.rag.v1.NamespaceInfoR\n" +
+	"namespaces\",\n" +
+	"\x16DeleteNamespaceRequest\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\"\x19\n" +
+	"\x17DeleteNamespaceResponse\"`\n" +
+	"\x0fFeedbackRequest\x12\x1b\n" +
+	"\tanswer_id\x18\x01 \x01(\tR\banswerId\x12\x16\n" +
+	"\x06rating\x18\x02 \x01(\tR\x06rating\x12\x18\n" +
+	"\acomment\x18\x03 \x01(\tR\acomment\"\x12\n" +
+	"\x10FeedbackResponse2\x96\x05\n" +
+	"\n" +
+	"RAGService\x127\n" +
+	"\x06Ingest\x12\x15.rag.v1.IngestRequest\x1a\x16.rag.v1.IngestResponse\x124\n" +
+	"\x05Query\x12\x14.rag.v1.QueryRequest\x1a\x15.rag.v1.QueryResponse\x12B\n" +
+	"\vQueryStream\x12\x14.rag.v1.QueryRequest\x1a\x1b.rag.v1.QueryStreamResponse0\x01\x12L\n" +
+	"\rListDocuments\x12\x1c.rag.v1.ListDocumentsRequest\x1a\x1d.rag.v1.ListDocumentsResponse\x12O\n" +
+	"\x0eDeleteDocument\x12\x1d.rag.v1.DeleteDocumentRequest\x1a\x1e.rag.v1.DeleteDocumentResponse\x12R\n" +
+	"\x0fCreateNamespace\x12\x1e.rag.v1.CreateNamespaceRequest\x1a\x1f.rag.v1.CreateNamespaceResponse\x12O\n" +
+	"\x0eListNamespaces\x12\x1d.rag.v1.ListNamespacesRequest\x1a\x1e.rag.v1.ListNamespacesResponse\x12R\n" +
+	"\x0fDeleteNamespace\x12\x1e.rag.v1.DeleteNamespaceRequest\x1a\x1f.rag.v1.DeleteNamespaceResponse\x12=\n" +
+	"\bFeedback\x12\x17.rag.v1.FeedbackRequest\x1a\x18.rag.v1.FeedbackResponseB-Z+github.com/jalling97/go_rag_demo/demo/ragpbb\x06proto3"
+
+var (
+	file_rag_v1_rag_proto_rawDescOnce sync.Once
+	file_rag_v1_rag_proto_rawDescData []byte
+)
+
+func file_rag_v1_rag_proto_rawDescGZIP() []byte {
+	file_rag_v1_rag_proto_rawDescOnce.Do(func() {
+		file_rag_v1_rag_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_rag_v1_rag_proto_rawDesc), len(file_rag_v1_rag_proto_rawDesc)))
+	})
+	return file_rag_v1_rag_proto_rawDescData
+}
+
+var file_rag_v1_rag_proto_msgTypes = make([]protoimpl.MessageInfo, 26)
+var file_rag_v1_rag_proto_goTypes = []any{
+	(*Document)(nil),                // 0: rag.v1.Document
+	(*IngestRequest)(nil),           // 1: rag.v1.IngestRequest
+	(*IngestResult)(nil),            // 2: rag.v1.IngestResult
+	(*IngestResponse)(nil),          // 3: rag.v1.IngestResponse
+	(*QueryRequest)(nil),            // 4: rag.v1.QueryRequest
+	(*SourceRef)(nil),               // 5: rag.v1.SourceRef
+	(*Span)(nil),                    // 6: rag.v1.Span
+	(*QueryResponse)(nil),           // 7: rag.v1.QueryResponse
+	(*AgentStep)(nil),               // 8: rag.v1.AgentStep
+	(*Grounding)(nil),               // 9: rag.v1.Grounding
+	(*QueryStreamResponse)(nil),     // 10: rag.v1.QueryStreamResponse
+	(*ListDocumentsRequest)(nil),    // 11: rag.v1.ListDocumentsRequest
+	(*DocumentInfo)(nil),            // 12: rag.v1.DocumentInfo
+	(*ListDocumentsResponse)(nil),   // 13: rag.v1.ListDocumentsResponse
+	(*DeleteDocumentRequest)(nil),   // 14: rag.v1.DeleteDocumentRequest
+	(*DeleteDocumentResponse)(nil),  // 15: rag.v1.DeleteDocumentResponse
+	(*CreateNamespaceRequest)(nil),  // 16: rag.v1.CreateNamespaceRequest
+	(*CreateNamespaceResponse)(nil), // 17: rag.v1.CreateNamespaceResponse
+	(*ListNamespacesRequest)(nil),   // 18: rag.v1.ListNamespacesRequest
+	(*NamespaceInfo)(nil),           // 19: rag.v1.NamespaceInfo
+	(*ListNamespacesResponse)(nil),  // 20: rag.v1.ListNamespacesResponse
+	(*DeleteNamespaceRequest)(nil),  // 21: rag.v1.DeleteNamespaceRequest
+	(*DeleteNamespaceResponse)(nil), // 22: rag.v1.DeleteNamespaceResponse
+	(*FeedbackRequest)(nil),         // 23: rag.v1.FeedbackRequest
+	(*FeedbackResponse)(nil),        // 24: rag.v1.FeedbackResponse
+	nil,                             // 25: rag.v1.Document.MetadataEntry
+}
+var file_rag_v1_rag_proto_depIdxs = []int32{
+	25, // 0: rag.v1.Document.metadata:type_name -> rag.v1.Document.MetadataEntry
+	0,  // 1: rag.v1.IngestRequest.documents:type_name -> rag.v1.Document
+	2,  // 2: rag.v1.IngestResponse.results:type_name -> rag.v1.IngestResult
+	6,  // 3: rag.v1.SourceRef.span:type_name -> rag.v1.Span
+	5,  // 4: rag.v1.QueryResponse.sources:type_name -> rag.v1.SourceRef
+	9,  // 5: rag.v1.QueryResponse.grounding:type_name -> rag.v1.Grounding
+	8,  // 6: rag.v1.QueryResponse.trace:type_name -> rag.v1.AgentStep
+	7,  // 7: rag.v1.QueryStreamResponse.done:type_name -> rag.v1.QueryResponse
+	12, // 8: rag.v1.ListDocumentsResponse.documents:type_name -> rag.v1.DocumentInfo
+	19, // 9: rag.v1.ListNamespacesResponse.namespaces:type_name -> rag.v1.NamespaceInfo
+	1,  // 10: rag.v1.RAGService.Ingest:input_type -> rag.v1.IngestRequest
+	4,  // 11: rag.v1.RAGService.Query:input_type -> rag.v1.QueryRequest
+	4,  // 12: rag.v1.RAGService.QueryStream:input_type -> rag.v1.QueryRequest
+	11, // 13: rag.v1.RAGService.ListDocuments:input_type -> rag.v1.ListDocumentsRequest
+	14, // 14: rag.v1.RAGService.DeleteDocument:input_type -> rag.v1.DeleteDocumentRequest
+	16, // 15: rag.v1.RAGService.CreateNamespace:input_type -> rag.v1.CreateNamespaceRequest
+	18, // 16: rag.v1.RAGService.ListNamespaces:input_type -> rag.v1.ListNamespacesRequest
+	21, // 17: rag.v1.RAGService.DeleteNamespace:input_type -> rag.v1.DeleteNamespaceRequest
+	23, // 18: rag.v1.RAGService.Feedback:input_type -> rag.v1.FeedbackRequest
+	3,  // 19: rag.v1.RAGService.Ingest:output_type -> rag.v1.IngestResponse
+	7,  // 20: rag.v1.RAGService.Query:output_type -> rag.v1.QueryResponse
+	10, // 21: rag.v1.RAGService.QueryStream:output_type -> rag.v1.QueryStreamResponse
+	13, // 22: rag.v1.RAGService.ListDocuments:output_type -> rag.v1.ListDocumentsResponse
+	15, // 23: rag.v1.RAGService.DeleteDocument:output_type -> rag.v1.DeleteDocumentResponse
+	17, // 24: rag.v1.RAGService.CreateNamespace:output_type -> rag.v1.CreateNamespaceResponse
+	20, // 25: rag.v1.RAGService.ListNamespaces:output_type -> rag.v1.ListNamespacesResponse
+	22, // 26: rag.v1.RAGService.DeleteNamespace:output_type -> rag.v1.DeleteNamespaceResponse
+	24, // 27: rag.v1.RAGService.Feedback:output_type -> rag.v1.FeedbackResponse
+	19, // [19:28] is the sub-list for method output_type
+	10, // [10:19] is the sub-list for method input_type
+	10, // [10:10] is the sub-list for extension type_name
+	10, // [10:10] is the sub-list for extension extendee
+	0,  // [0:10] is the sub-list for field type_name
+}
+
+func init() { file_rag_v1_rag_proto_init() }
+func file_rag_v1_rag_proto_init() {
+	if File_rag_v1_rag_proto != nil {
+		return
+	}
+	file_rag_v1_rag_proto_msgTypes[4].OneofWrappers = []any{}
+	file_rag_v1_rag_proto_msgTypes[7].OneofWrappers = []any{}
+	file_rag_v1_rag_proto_msgTypes[10].OneofWrappers = []any{
+		(*QueryStreamResponse_Delta)(nil),
+		(*QueryStreamResponse_Done)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_rag_v1_rag_proto_rawDesc), len(file_rag_v1_rag_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   26,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_rag_v1_rag_proto_goTypes,
+		DependencyIndexes: file_rag_v1_rag_proto_depIdxs,
+		MessageInfos:      file_rag_v1_rag_proto_msgTypes,
+	}.Build()
+	File_rag_v1_rag_proto = out.File
+	file_rag_v1_rag_proto_goTypes = nil
+	file_rag_v1_rag_proto_depIdxs = nil
+}
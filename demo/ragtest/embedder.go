@@ -0,0 +1,51 @@
+package ragtest
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/jalling97/go_rag_demo/demo/rag"
+)
+
+// An Embedder embeds texts as a rag.HashEmbedder of Dimensions does, so
+// that the same text always gets the same vector and texts sharing words
+// are similar, and records the texts it embeds. If Err is set, every call
+// fails with it. It is safe for concurrent use.
+type Embedder struct {
+	Dimensions int // rag.DefaultHashDimensions if zero
+	Err        error
+
+	mu    sync.Mutex
+	calls int
+	texts []string
+}
+
+func (e *Embedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	e.calls++
+	e.texts = append(e.texts, texts...)
+	err := e.Err
+	e.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return rag.HashEmbedder{Dimensions: e.Dimensions}.Embed(ctx, texts)
+}
+
+// Calls returns the number of calls of Embed, failed ones included.
+func (e *Embedder) Calls() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.calls
+}
+
+// Texts returns the texts passed to Embed, in the order of the calls.
+func (e *Embedder) Texts() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return slices.Clone(e.texts)
+}
@@ -0,0 +1,39 @@
+package ragtest_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/jalling97/go_rag_demo/demo/rag"
+	"github.com/jalling97/go_rag_demo/demo/ragtest"
+)
+
+func ExampleNewPipeline() {
+	ctx := context.Background()
+	llm := &ragtest.LLM{Replies: []string{"Refunds take 14 days [1]."}}
+	p, err := ragtest.NewPipeline(rag.DefaultConfig(), nil, nil, llm)
+	if err != nil {
+		log.Fatal(err)
+	}
+	doc := &rag.Document{ID: "refunds.md", Sections: []rag.Section{
+		{Text: "Refunds are paid within 14 days."},
+	}}
+	if _, err := p.Ingest(ctx, doc); err != nil {
+		log.Fatal(err)
+	}
+	question := "How long do refunds take?"
+	answer, err := p.Query(ctx, rag.QueryRequest{Question: question})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(answer.Answer)
+	for _, s := range answer.Sources {
+		fmt.Println(s.DocID, s.Cited)
+	}
+	fmt.Println(len(llm.Calls()), "LLM call")
+	// Output:
+	// Refunds take 14 days [1].
+	// refunds.md true
+	// 1 LLM call
+}
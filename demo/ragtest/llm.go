@@ -0,0 +1,94 @@
+package ragtest
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/jalling97/go_rag_demo/demo/rag"
+)
+
+// DefaultReply is the reply of an LLM without Replies or Reply.
+const DefaultReply = "This is a canned answer."
+
+// An LLM replies with canned completions: Replies in turn, the last one
+// again once they are used up, or DefaultReply if there are none. If Reply
+// is set, it is called for the reply instead, and an error it returns
+// fails the call; if Err is set, every call fails with it. Stream passes
+// the reply on word by word and GenerateJSON returns it as it is, so a
+// test expecting JSON must reply with JSON. The messages of every call are
+// recorded. It is safe for concurrent use.
+type LLM struct {
+	Replies []string
+	Reply   func(messages []rag.Message) (string, error)
+	Err     error
+
+	mu    sync.Mutex
+	calls [][]rag.Message
+}
+
+func (l *LLM) Generate(ctx context.Context, messages []rag.Message) (string, error) {
+	return l.reply(ctx, messages)
+}
+
+func (l *LLM) Stream(ctx context.Context, messages []rag.Message, onDelta func(string) error) error {
+	reply, err := l.reply(ctx, messages)
+	if err != nil {
+		return err
+	}
+	for _, delta := range strings.SplitAfter(reply, " ") {
+		if delta == "" {
+			continue
+		}
+		if err := onDelta(delta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *LLM) GenerateJSON(ctx context.Context, messages []rag.Message, name string, schema json.RawMessage) (string, error) {
+	return l.reply(ctx, messages)
+}
+
+// reply records a call with messages and returns its reply.
+func (l *LLM) reply(ctx context.Context, messages []rag.Message) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	l.mu.Lock()
+	n := len(l.calls)
+	l.calls = append(l.calls, slices.Clone(messages))
+	l.mu.Unlock()
+	switch {
+	case l.Err != nil:
+		return "", l.Err
+	case l.Reply != nil:
+		return l.Reply(messages)
+	case len(l.Replies) == 0:
+		return DefaultReply, nil
+	}
+	return l.Replies[min(n, len(l.Replies)-1)], nil
+}
+
+// Calls returns the messages of every call, in order, failed ones
+// included.
+func (l *LLM) Calls() [][]rag.Message {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Clone(l.calls)
+}
+
+// LastPrompt returns the content of the last message of the last call, the
+// user's part of the prompt, or "" if there was no call.
+func (l *LLM) LastPrompt() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.calls) == 0 || len(l.calls[len(l.calls)-1]) == 0 {
+		return ""
+	}
+	last := l.calls[len(l.calls)-1]
+	return last[len(last)-1].Content
+}
@@ -0,0 +1,66 @@
+// Package ragtest provides deterministic stand-ins for the providers of a
+// rag.Pipeline, so that programs using the library can test their
+// pipelines without network calls or API keys: an Embedder of hash-based
+// embeddings, an LLM of canned replies and an in-memory vector store.
+// NewPipeline assembles a pipeline from them.
+//
+//	llm := &ragtest.LLM{Replies: []string{"Refunds take 14 days [1]."}}
+//	p, err := ragtest.NewPipeline(rag.DefaultConfig(), nil, nil, llm)
+//	...
+//	doc := &rag.Document{ID: "refunds.md", Sections: []rag.Section{
+//		{Text: "Refunds are paid within 14 days."},
+//	}}
+//	p.Ingest(ctx, doc)
+//	question := "How long do refunds take?"
+//	answer, err := p.Query(ctx, rag.QueryRequest{Question: question})
+//
+// The fakes record how they were called, for tests to assert on, and can be
+// made to fail.
+package ragtest
+
+import (
+	"context"
+
+	"github.com/jalling97/go_rag_demo/demo/rag"
+)
+
+// NewStore returns an empty in-memory vector store comparing embeddings by
+// cosine similarity. It keeps sources, parents, profiles and the model of
+// its embeddings like the stores of NewPipeline, and lists its chunks, so
+// that every feature of a pipeline works with it.
+func NewStore() *rag.MemoryStore {
+	return rag.NewMemoryStore(rag.MetricCosine, rag.QuantizationOff)
+}
+
+// NewPipeline returns the Pipeline rag.NewPipeline assembles from cfg, but
+// embedding with embedder, storing chunks in store and generating with
+// llm, a new Embedder, NewStore and a new LLM for those that are nil. The
+// providers and stores cfg selects are ignored, as are the settings that
+// need a file or service of their own, such as ANSWER_CACHE or GRAPH_DB;
+// conversations are kept in memory. The embeddings are recorded as those
+// of EMBEDDER=hash, which a config passed to Reconfigure must keep. Start
+// from rag.DefaultConfig, rather than rag.ConfigFromEnv, to keep tests
+// from depending on the environment.
+func NewPipeline(cfg rag.Config, embedder rag.Embedder, store rag.VectorStore, llm rag.LLM) (*rag.Pipeline, error) {
+	if embedder == nil {
+		embedder = &Embedder{}
+	}
+	if store == nil {
+		store = NewStore()
+	}
+	if llm == nil {
+		llm = &LLM{}
+	}
+	cfg.Embedder = "hash"
+	cfg.EmbedCache = ""
+	cfg.SessionStore = ""
+	cfg.Pricing = ""
+	cfg.AnswerCache = ""
+	cfg.FeedbackDB = ""
+	cfg.GraphDB = ""
+	cfg.AuditLog = ""
+	cfg.PIILog = ""
+	cfg.WebhookURLs = ""
+	providers := rag.Providers{Embedder: embedder, Store: store, LLM: llm}
+	return rag.NewPipelineWith(context.Background(), cfg, providers)
+}